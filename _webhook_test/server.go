@@ -11,12 +11,15 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // WebhookPayload represents the webhook data structure
 type WebhookPayload struct {
+	ID    string         `json:"id"`
 	Event     string         `json:"event"`
 	Timestamp string         `json:"timestamp"`
 	Data      map[string]any `json:"data"`
@@ -24,22 +27,46 @@ type WebhookPayload struct {
 
 // WebhookLog represents what we save to file
 type WebhookLog struct {
-	ReceivedAt time.Time              `json:"received_at"`
-	Event      string                 `json:"event"`
-	Timestamp  string                 `json:"timestamp"`
-	Data       map[string]any         `json:"data"`
-	Headers    map[string]string      `json:"headers"`
-	Signature  string                 `json:"signature"`
-	Verified   bool                   `json:"signature_verified"`
-	RawPayload string                 `json:"raw_payload"`
+	ReceivedAt  time.Time              `json:"received_at"`
+	DeliveryID  string                 `json:"delivery_id"`
+	Duplicate   bool                   `json:"duplicate"`
+	Event       string                 `json:"event"`
+	Timestamp   string                 `json:"timestamp"`
+	Data        map[string]any         `json:"data"`
+	Headers     map[string]string      `json:"headers"`
+	Signature   string                 `json:"signature"`
+	Verified    bool                   `json:"signature_verified"`
+	VerifyError string                 `json:"verify_error,omitempty"`
+	RawPayload  string                 `json:"raw_payload"`
 }
 
 const (
-	// Test secret - should match the webhook secret in database
-	TestSecret = "test-secret-key-123"
-	LogDir     = "_webhook_test/logs"
+	LogDir = "_webhook_test/logs"
+
+	// SignatureTolerance is how old a delivery may be before it is treated as a replay
+	SignatureTolerance = 5 * time.Minute
+)
+
+var (
+	// Secrets are the webhook's signing secrets (GET /api/admin/webhooks/:id/secret), comma separated in
+	// HOMA_WEBHOOK_SECRET. During a rotation set both the new and the old secret.
+	Secrets = loadSecrets()
+
+	// seenDeliveries remembers delivery IDs, so retried deliveries are only processed once
+	seenDeliveries   = map[string]bool{}
+	seenDeliveriesMu sync.Mutex
 )
 
+func loadSecrets() []string {
+	var secrets []string
+	for _, secret := range strings.Split(os.Getenv("HOMA_WEBHOOK_SECRET"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
 func main() {
 	// Create logs directory
 	if err := os.MkdirAll(LogDir, 0755); err != nil {
@@ -56,7 +83,11 @@ func main() {
 	fmt.Printf("📡 Listening on http://localhost%s\n", port)
 	fmt.Printf("📝 Webhook endpoint: http://localhost%s/webhook\n", port)
 	fmt.Printf("📁 Logs directory: %s\n", LogDir)
-	fmt.Printf("🔑 Test secret: %s\n", TestSecret)
+	if len(Secrets) > 0 {
+		fmt.Printf("🔑 Verifying signatures with %d secret(s) from HOMA_WEBHOOK_SECRET\n", len(Secrets))
+	} else {
+		fmt.Println("⚠️  HOMA_WEBHOOK_SECRET is not set: signatures are not verified")
+	}
 	fmt.Println("================================")
 	fmt.Println("\nWaiting for webhooks...\n")
 
@@ -85,14 +116,14 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
         <h2>✅ Server is Running</h2>
         <p><strong>Webhook Endpoint:</strong></p>
         <div class="endpoint">POST http://localhost:9000/webhook</div>
-        <p><strong>Test Secret:</strong> <code>test-secret-key-123</code></p>
+        <p><strong>Secret:</strong> read from <code>HOMA_WEBHOOK_SECRET</code></p>
         <p><strong>Logs Directory:</strong> <code>_webhook_test/logs/</code></p>
     </div>
 
     <h2>📋 Test Instructions</h2>
     <ol>
         <li>Create a webhook in Homa with URL: <code>http://localhost:9000/webhook</code></li>
-        <li>Set the secret to: <code>test-secret-key-123</code></li>
+        <li>Copy its secret from <code>GET /api/admin/webhooks/:id/secret</code> and restart this server with <code>HOMA_WEBHOOK_SECRET=whsec_...</code></li>
         <li>Create a ticket or trigger an event</li>
         <li>Check the logs directory for received webhooks</li>
     </ol>
@@ -137,23 +168,41 @@ func handleWebhook(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Verify signature if present
-	signature := r.Header.Get("X-Webhook-Signature")
+	// Verify the signature over the raw body before trusting the payload
+	signature := r.Header.Get("X-Homa-Signature")
 	verified := false
-	if signature != "" {
-		verified = verifySignature(body, signature, TestSecret)
+	verifyError := ""
+	if len(Secrets) > 0 {
+		if err := verifySignature(signature, body, SignatureTolerance, Secrets...); err != nil {
+			verifyError = err.Error()
+		} else {
+			verified = true
+		}
+	}
+
+	// Retries reuse the delivery ID, so a delivery that was already processed is only acknowledged
+	deliveryID := r.Header.Get("X-Homa-Delivery")
+	duplicate := false
+	if deliveryID != "" && (verified || len(Secrets) == 0) {
+		seenDeliveriesMu.Lock()
+		duplicate = seenDeliveries[deliveryID]
+		seenDeliveries[deliveryID] = true
+		seenDeliveriesMu.Unlock()
 	}
 
 	// Create log entry
 	webhookLog := WebhookLog{
-		ReceivedAt: time.Now(),
-		Event:      payload.Event,
-		Timestamp:  payload.Timestamp,
-		Data:       payload.Data,
-		Headers:    headers,
-		Signature:  signature,
-		Verified:   verified,
-		RawPayload: string(body),
+		ReceivedAt:  time.Now(),
+		DeliveryID:  deliveryID,
+		Duplicate:   duplicate,
+		Event:       payload.Event,
+		Timestamp:   payload.Timestamp,
+		Data:        payload.Data,
+		Headers:     headers,
+		Signature:   signature,
+		Verified:    verified,
+		VerifyError: verifyError,
+		RawPayload:  string(body),
 	}
 
 	// Save to file
@@ -164,6 +213,12 @@ func handleWebhook(w http.ResponseWriter, r *http.Request) {
 	// Log to console
 	logToConsole(webhookLog)
 
+	// Reject deliveries that are not signed with one of the secrets
+	if len(Secrets) > 0 && !verified {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
 	// Respond with success
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -175,11 +230,42 @@ func handleWebhook(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func verifySignature(payload []byte, signature, secret string) bool {
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write(payload)
-	expectedSignature := hex.EncodeToString(mac.Sum(nil))
-	return signature == expectedSignature
+// verifySignature checks an X-Homa-Signature header ("t=<unix>,v1=<hex>[,v1=<hex>]") against the raw
+// body. Each v1 is the HMAC-SHA256 of "<t>.<body>" with one of the webhook's active secrets; the
+// timestamp must be within tolerance so captured deliveries cannot be replayed later.
+func verifySignature(header string, body []byte, tolerance time.Duration, secrets ...string) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return fmt.Errorf("signature header is missing or malformed")
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return fmt.Errorf("timestamp is outside the %s tolerance", tolerance)
+	}
+
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+		mac.Write(body)
+		expected := hex.EncodeToString(mac.Sum(nil))
+		for _, signature := range signatures {
+			if hmac.Equal([]byte(expected), []byte(signature)) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no signature matches")
 }
 
 func saveWebhookLog(webhookLog WebhookLog) error {
@@ -209,21 +295,24 @@ func logToConsole(webhookLog WebhookLog) {
 	fmt.Println(strings.Repeat("=", 80))
 	fmt.Printf("⏰ Received At: %s\n", webhookLog.ReceivedAt.Format(time.RFC3339))
 	fmt.Printf("📍 Event: %s\n", webhookLog.Event)
+	fmt.Printf("🆔 Delivery: %s\n", webhookLog.DeliveryID)
+	if webhookLog.Duplicate {
+		fmt.Printf("🔁 Duplicate delivery: already processed\n")
+	}
 	fmt.Printf("🕐 Event Timestamp: %s\n", webhookLog.Timestamp)
 
-	if webhookLog.Signature != "" {
-		if webhookLog.Verified {
-			fmt.Printf("✅ Signature: VERIFIED\n")
-		} else {
-			fmt.Printf("❌ Signature: FAILED\n")
-		}
-	} else {
-		fmt.Printf("⚠️  Signature: NOT PROVIDED\n")
+	switch {
+	case webhookLog.Verified:
+		fmt.Printf("✅ Signature: VERIFIED\n")
+	case webhookLog.VerifyError != "":
+		fmt.Printf("❌ Signature: FAILED (%s)\n", webhookLog.VerifyError)
+	default:
+		fmt.Printf("⚠️  Signature: NOT CHECKED\n")
 	}
 
 	fmt.Println("\n📋 Headers:")
 	for key, value := range webhookLog.Headers {
-		if key == "X-Webhook-Signature" || key == "X-Webhook-Event" || key == "X-Webhook-Id" || key == "User-Agent" {
+		if key == "X-Homa-Signature" || key == "X-Homa-Delivery" || key == "X-Webhook-Event" || key == "X-Webhook-Id" || key == "User-Agent" {
 			fmt.Printf("   %s: %s\n", key, value)
 		}
 	}
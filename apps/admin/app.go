@@ -69,6 +69,39 @@ func (a App) Router() error {
 	evo.Delete("/api/admin/clients/:id", controller.DeleteClient)
 	evo.Post("/api/admin/clients/merge", controller.MergeClients)
 
+	// Client duplicate review APIs (auto-merge threshold is the 'clients.auto_merge_threshold' setting)
+	evo.Get("/api/admin/client-duplicates", controller.ListClientDuplicates)
+	evo.Post("/api/admin/client-duplicates/scan", controller.ScanClientDuplicates)
+	evo.Post("/api/admin/client-duplicates/:id/merge", controller.MergeClientDuplicate)
+	evo.Post("/api/admin/client-duplicates/:id/dismiss", controller.DismissClientDuplicate)
+
+	// Workspace APIs (only available to the default workspace)
+	evo.Get("/api/admin/workspaces", controller.ListWorkspaces)
+	evo.Post("/api/admin/workspaces", controller.CreateWorkspace)
+	evo.Put("/api/admin/workspaces/:id", controller.UpdateWorkspace)
+
+	// Blocklist and spam APIs (heuristic email threshold is the 'spam.email_threshold' setting)
+	evo.Get("/api/admin/blocklist", controller.ListBlocklist)
+	evo.Post("/api/admin/blocklist", controller.CreateBlocklistEntry)
+	evo.Put("/api/admin/blocklist/:id", controller.UpdateBlocklistEntry)
+	evo.Delete("/api/admin/blocklist/:id", controller.DeleteBlocklistEntry)
+	evo.Get("/api/admin/spam-events", controller.ListSpamEvents)
+	evo.Post("/api/admin/spam-events/:id/revert", controller.RevertSpamEvent)
+
+	// Campaign APIs (send rate is the 'campaigns.<channel>.rate_per_minute' setting; scheduled sends run in the send_campaigns job)
+	evo.Get("/api/admin/campaigns", controller.ListCampaigns)
+	evo.Post("/api/admin/campaigns", controller.CreateCampaign)
+	evo.Post("/api/admin/campaigns/preview-segment", controller.PreviewCampaignSegment)
+	evo.Get("/api/admin/campaigns/:id", controller.GetCampaign)
+	evo.Put("/api/admin/campaigns/:id", controller.UpdateCampaign)
+	evo.Delete("/api/admin/campaigns/:id", controller.DeleteCampaign)
+	evo.Post("/api/admin/campaigns/:id/send", controller.SendCampaign)
+	evo.Post("/api/admin/campaigns/:id/cancel", controller.CancelCampaign)
+	evo.Get("/api/admin/campaigns/:id/deliveries", controller.ListCampaignDeliveries)
+	evo.Get("/api/admin/campaign-opt-outs", controller.ListClientOptOuts)
+	evo.Post("/api/admin/campaign-opt-outs", controller.CreateClientOptOut)
+	evo.Delete("/api/admin/campaign-opt-outs/:id", controller.DeleteClientOptOut)
+
 	// Message management APIs
 	evo.Delete("/api/admin/messages/:id", controller.DeleteMessage)
 
@@ -96,6 +129,10 @@ func (a App) Router() error {
 	// Knowledge Base Media Upload
 	evo.Post("/api/admin/kb/upload", controller.UploadKBMedia)
 
+	// Canned message usage APIs
+	evo.Get("/api/admin/canned-messages/usage", controller.ListCannedMessageUsage)
+	evo.Post("/api/admin/canned-messages/prune", controller.PruneCannedMessages)
+
 	// Webhook management APIs
 	evo.Get("/api/admin/webhooks", controller.ListWebhooks)
 	evo.Get("/api/admin/webhooks/:id", controller.GetWebhook)
@@ -103,10 +140,17 @@ func (a App) Router() error {
 	evo.Put("/api/admin/webhooks/:id", controller.UpdateWebhook)
 	evo.Delete("/api/admin/webhooks/:id", controller.DeleteWebhook)
 	evo.Post("/api/admin/webhooks/:id/test", controller.TestWebhook)
+	evo.Get("/api/admin/webhooks/:id/secret", controller.GetWebhookSecret)
+	evo.Post("/api/admin/webhooks/:id/rotate-secret", controller.RotateWebhookSecret)
 
 	// Webhook delivery logs APIs
 	evo.Get("/api/admin/webhook_deliveries", controller.ListWebhookDeliveries)
 	evo.Get("/api/admin/webhook_deliveries/:id", controller.GetWebhookDelivery)
+	evo.Post("/api/admin/webhook_deliveries/:id/replay", controller.ReplayWebhookDelivery)
+
+	// Webhook delivery queue APIs
+	evo.Get("/api/admin/webhook_queue", controller.ListWebhookQueue)
+	evo.Post("/api/admin/webhook_queue/:id/replay", controller.ReplayWebhookQueueItem)
 
 	// Integration management APIs
 	evo.Get("/api/admin/integrations", controller.ListIntegrations)
@@ -0,0 +1,258 @@
+package admin
+
+import (
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/pagination"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/gorm"
+)
+
+// ===============================
+// BLOCKLIST AND SPAM APIs
+// ===============================
+
+// BlocklistEntryRequest creates a blocklist entry
+type BlocklistEntryRequest struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason"`
+	IsActive *bool  `json:"is_active"`
+}
+
+// UpdateBlocklistEntryRequest updates a blocklist entry. Type and value cannot be changed.
+type UpdateBlocklistEntryRequest struct {
+	Action   *string `json:"action"`
+	Reason   *string `json:"reason"`
+	IsActive *bool   `json:"is_active"`
+}
+
+// RevertSpamEventRequest controls whether the sender is allowlisted when a spam event is reverted
+type RevertSpamEventRequest struct {
+	AllowSender bool `json:"allow_sender"`
+}
+
+// ListBlocklist returns blocklist entries
+func (c Controller) ListBlocklist(request *evo.Request) any {
+	var entries []models.BlocklistEntry
+
+	query := db.GetContext(request).Model(&models.BlocklistEntry{})
+	if entryType := request.Query("type").String(); entryType != "" {
+		query = query.Where("type = ?", entryType)
+	}
+	if action := request.Query("action").String(); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if search := request.Query("search").String(); search != "" {
+		query = query.Where("value LIKE ? OR reason LIKE ?", "%"+search+"%", "%"+search+"%")
+	}
+	if isActive := request.Query("is_active").String(); isActive != "" {
+		query = query.Where("is_active = ?", isActive == "true" || isActive == "1")
+	}
+
+	query = query.Order("id DESC")
+
+	p, err := pagination.New(query, request, &entries, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMeta(entries, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// CreateBlocklistEntry adds a client, email, domain, phone or IP range to the blocklist
+func (c Controller) CreateBlocklistEntry(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	var req BlocklistEntryRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.BadRequest(request, "Invalid request body")
+	}
+
+	entry := models.BlocklistEntry{
+		Type:      req.Type,
+		Value:     req.Value,
+		Action:    req.Action,
+		Reason:    req.Reason,
+		IsActive:  req.IsActive == nil || *req.IsActive,
+		CreatedBy: &user.UserID,
+	}
+	if err := entry.Normalize(); err != nil {
+		return response.BadRequest(request, err.Error())
+	}
+
+	var count int64
+	db.GetContext(request).Model(&models.BlocklistEntry{}).Where("type = ? AND value = ?", entry.Type, entry.Value).Count(&count)
+	if count > 0 {
+		return response.BadRequest(request, "An entry for this value already exists")
+	}
+
+	if err := db.GetContext(request).Create(&entry).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogBlocklistChange(entry.ID, models.ActionCreate, user, nil, blocklistEntryValues(&entry), request.IP(), request.Header("User-Agent"))
+
+	return response.Created(entry)
+}
+
+// UpdateBlocklistEntry changes the action, reason or active state of a blocklist entry
+func (c Controller) UpdateBlocklistEntry(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	entry, errResp := findBlocklistEntry(request)
+	if errResp != nil {
+		return errResp
+	}
+
+	var req UpdateBlocklistEntryRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.BadRequest(request, "Invalid request body")
+	}
+
+	oldValues := blocklistEntryValues(entry)
+	if req.Action != nil {
+		entry.Action = *req.Action
+	}
+	if req.Reason != nil {
+		entry.Reason = *req.Reason
+	}
+	if req.IsActive != nil {
+		entry.IsActive = *req.IsActive
+	}
+	if err := entry.Normalize(); err != nil {
+		return response.BadRequest(request, err.Error())
+	}
+
+	if err := db.GetContext(request).Model(entry).Updates(map[string]any{
+		"action":    entry.Action,
+		"reason":    entry.Reason,
+		"is_active": entry.IsActive,
+	}).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogBlocklistChange(entry.ID, models.ActionUpdate, user, oldValues, blocklistEntryValues(entry), request.IP(), request.Header("User-Agent"))
+
+	return response.OK(entry)
+}
+
+// DeleteBlocklistEntry removes a blocklist entry
+func (c Controller) DeleteBlocklistEntry(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	entry, errResp := findBlocklistEntry(request)
+	if errResp != nil {
+		return errResp
+	}
+
+	if err := db.GetContext(request).Delete(entry).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogBlocklistChange(entry.ID, models.ActionDelete, user, blocklistEntryValues(entry), nil, request.IP(), request.Header("User-Agent"))
+
+	return response.OK(map[string]interface{}{
+		"message": "Blocklist entry deleted successfully",
+	})
+}
+
+// ListSpamEvents returns dropped and spam-marked inbound traffic, newest first
+func (c Controller) ListSpamEvents(request *evo.Request) any {
+	var events []models.SpamEvent
+
+	query := db.GetContext(request).Model(&models.SpamEvent{}).Preload("BlocklistEntry")
+	if channel := request.Query("channel").String(); channel != "" {
+		query = query.Where("channel = ?", channel)
+	}
+	if action := request.Query("action").String(); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if sender := request.Query("sender").String(); sender != "" {
+		query = query.Where("sender_value LIKE ?", "%"+sender+"%")
+	}
+	if conversationID := request.Query("conversation_id").Int(); conversationID > 0 {
+		query = query.Where("conversation_id = ?", conversationID)
+	}
+	switch request.Query("reverted").String() {
+	case "true", "1":
+		query = query.Where("reverted_at IS NOT NULL")
+	case "false", "0":
+		query = query.Where("reverted_at IS NULL")
+	}
+
+	query = query.Order("id DESC")
+
+	p, err := pagination.New(query, request, &events, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMeta(events, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// RevertSpamEvent restores a spam-marked conversation to its previous status and optionally allowlists the sender
+func (c Controller) RevertSpamEvent(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	id := request.Param("id").Int()
+	if id <= 0 {
+		return response.Error(response.ErrInvalidInput)
+	}
+
+	// The body is optional; by default the sender is not allowlisted
+	var req RevertSpamEventRequest
+	if err := request.BodyParser(&req); err != nil && len(request.Body()) > 0 {
+		return response.Error(response.ErrInvalidInput)
+	}
+
+	event, err := models.RevertSpamEvent(uint(id), user.UserID, req.AllowSender)
+	if err != nil {
+		switch err {
+		case models.ErrSpamEventNotFound:
+			return response.Error(response.ErrNotFound)
+		case models.ErrSpamEventReverted:
+			return response.BadRequest(request, err.Error())
+		}
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogSpamEventRevert(event.ID, user, req.AllowSender, request.IP(), request.Header("User-Agent"))
+
+	return response.OK(event)
+}
+
+func findBlocklistEntry(request *evo.Request) (*models.BlocklistEntry, any) {
+	id := request.Param("id").Int()
+	if id <= 0 {
+		return nil, response.Error(response.ErrInvalidInput)
+	}
+
+	var entry models.BlocklistEntry
+	if err := db.GetContext(request).First(&entry, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, response.NotFound(request, "Blocklist entry not found")
+		}
+		return nil, response.Error(response.ErrInternalError)
+	}
+	return &entry, nil
+}
+
+func blocklistEntryValues(entry *models.BlocklistEntry) map[string]any {
+	return map[string]any{
+		"type":      entry.Type,
+		"value":     entry.Value,
+		"action":    entry.Action,
+		"reason":    entry.Reason,
+		"is_active": entry.IsActive,
+	}
+}
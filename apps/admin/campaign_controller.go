@@ -0,0 +1,458 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/pagination"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/gorm"
+)
+
+// ===============================
+// CAMPAIGN APIs
+// ===============================
+
+// CampaignRequest creates or replaces a campaign. A campaign with scheduled_at is scheduled, otherwise it is a draft.
+type CampaignRequest struct {
+	Name             string                   `json:"name"`
+	Channel          string                   `json:"channel"`
+	Subject          string                   `json:"subject"`
+	Body             string                   `json:"body"`
+	WhatsAppTemplate *models.WhatsAppTemplate `json:"whatsapp_template"`
+	Segment          models.CampaignSegment   `json:"segment"`
+	ScheduledAt      *time.Time               `json:"scheduled_at"`
+}
+
+// PreviewSegmentRequest previews the clients a campaign segment would reach
+type PreviewSegmentRequest struct {
+	Channel string                 `json:"channel"`
+	Segment models.CampaignSegment `json:"segment"`
+}
+
+// ClientOptOutRequest opts a client out of campaigns on a channel
+type ClientOptOutRequest struct {
+	ClientID uuid.UUID `json:"client_id"`
+	Channel  string    `json:"channel"`
+}
+
+// CampaignDetail is a campaign with its delivery stats
+type CampaignDetail struct {
+	models.Campaign
+	Stats models.CampaignStats `json:"stats"`
+}
+
+// campaignSendTimeout bounds a send started from the API; the send_campaigns job picks up what is left
+const campaignSendTimeout = 15 * time.Minute
+
+// ListCampaigns returns campaigns, newest first
+func (c Controller) ListCampaigns(request *evo.Request) any {
+	var campaigns []models.Campaign
+
+	query := db.GetContext(request).Model(&models.Campaign{})
+	if status := request.Query("status").String(); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if channel := request.Query("channel").String(); channel != "" {
+		query = query.Where("channel = ?", channel)
+	}
+	if search := request.Query("search").String(); search != "" {
+		query = query.Where("name LIKE ?", "%"+search+"%")
+	}
+
+	query = query.Order("id DESC")
+
+	p, err := pagination.New(query, request, &campaigns, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMeta(campaigns, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// GetCampaign returns a campaign with its delivery stats
+func (c Controller) GetCampaign(request *evo.Request) any {
+	campaign, errResp := findCampaign(request)
+	if errResp != nil {
+		return errResp
+	}
+
+	stats, err := models.GetCampaignStats(campaign.ID)
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OK(CampaignDetail{Campaign: *campaign, Stats: stats})
+}
+
+// CreateCampaign creates a draft or scheduled campaign
+func (c Controller) CreateCampaign(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	var req CampaignRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.BadRequest(request, "Invalid request body")
+	}
+
+	campaign := models.Campaign{CreatedBy: &user.UserID}
+	if errResp := applyCampaignRequest(request, &campaign, &req); errResp != nil {
+		return errResp
+	}
+
+	if err := db.GetContext(request).Create(&campaign).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogCampaignChange(campaign.ID, models.ActionCreate, user, nil, campaignValues(&campaign), request.IP(), request.Header("User-Agent"))
+
+	return response.Created(campaign)
+}
+
+// UpdateCampaign replaces the content, segment and schedule of a draft or scheduled campaign
+func (c Controller) UpdateCampaign(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	campaign, errResp := findCampaign(request)
+	if errResp != nil {
+		return errResp
+	}
+	if campaign.Status != models.CampaignStatusDraft && campaign.Status != models.CampaignStatusScheduled {
+		return response.BadRequest(request, models.ErrCampaignNotEditable.Error())
+	}
+
+	var req CampaignRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.BadRequest(request, "Invalid request body")
+	}
+
+	oldValues := campaignValues(campaign)
+	if errResp := applyCampaignRequest(request, campaign, &req); errResp != nil {
+		return errResp
+	}
+
+	// Only update while the runner has not started the campaign in the meantime
+	result := db.GetContext(request).Model(&models.Campaign{}).
+		Where("id = ? AND status IN ?", campaign.ID, []string{models.CampaignStatusDraft, models.CampaignStatusScheduled}).
+		Updates(map[string]any{
+			"name":              campaign.Name,
+			"channel":           campaign.Channel,
+			"subject":           campaign.Subject,
+			"body":              campaign.Body,
+			"whatsapp_template": campaign.WhatsAppTemplate,
+			"segment":           campaign.Segment,
+			"status":            campaign.Status,
+			"scheduled_at":      campaign.ScheduledAt,
+		})
+	if result.Error != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	if result.RowsAffected == 0 {
+		return response.BadRequest(request, models.ErrCampaignNotEditable.Error())
+	}
+	models.LogCampaignChange(campaign.ID, models.ActionUpdate, user, oldValues, campaignValues(campaign), request.IP(), request.Header("User-Agent"))
+
+	return response.OK(campaign)
+}
+
+// DeleteCampaign removes a campaign that is not sending, along with its delivery history
+func (c Controller) DeleteCampaign(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	campaign, errResp := findCampaign(request)
+	if errResp != nil {
+		return errResp
+	}
+	if campaign.Status == models.CampaignStatusSending {
+		return response.BadRequest(request, "Cancel the campaign before deleting it")
+	}
+
+	err := db.GetContext(request).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("campaign_id = ?", campaign.ID).Delete(&models.CampaignDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(campaign).Error
+	})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogCampaignChange(campaign.ID, models.ActionDelete, user, campaignValues(campaign), nil, request.IP(), request.Header("User-Agent"))
+
+	return response.OK(map[string]interface{}{
+		"message": "Campaign deleted successfully",
+	})
+}
+
+// PreviewCampaignSegment returns how many clients a segment reaches on a channel and a sample of them
+func (c Controller) PreviewCampaignSegment(request *evo.Request) any {
+	var req PreviewSegmentRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.BadRequest(request, "Invalid request body")
+	}
+	if !slices.Contains(models.OutboundChannels, req.Channel) {
+		return response.BadRequest(request, "Invalid channel")
+	}
+	if err := req.Segment.Validate(); err != nil {
+		return response.BadRequest(request, err.Error())
+	}
+
+	var total int64
+	if err := models.SegmentClientsQuery(db.GetContext(request), req.Channel, req.Segment).Count(&total).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	var sample []models.Client
+	if err := models.SegmentClientsQuery(db.GetContext(request), req.Channel, req.Segment).Preload("ExternalIDs").Order("clients.created_at DESC").Limit(10).Find(&sample).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OK(map[string]any{
+		"total":  total,
+		"sample": sample,
+	})
+}
+
+// SendCampaign starts sending a draft or scheduled campaign now
+func (c Controller) SendCampaign(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	campaign, errResp := findCampaign(request)
+	if errResp != nil {
+		return errResp
+	}
+	if campaign.Status != models.CampaignStatusDraft && campaign.Status != models.CampaignStatusScheduled {
+		return response.BadRequest(request, "Only draft or scheduled campaigns can be sent")
+	}
+	if err := campaign.Validate(); err != nil {
+		return response.BadRequest(request, err.Error())
+	}
+	if campaign.Channel == models.ExternalIDTypeEmail && models.CampaignUnsubscribeURL("token") == "" {
+		return response.BadRequest(request, "APP.API_BASE_URL must be configured to send email campaigns with unsubscribe links")
+	}
+
+	oldStatus := campaign.Status
+	if err := models.StartCampaign(campaign); err != nil {
+		log.Error("Failed to start campaign %d: %v", campaign.ID, err)
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogCampaignChange(campaign.ID, models.ActionStatusChange, user,
+		map[string]any{"status": oldStatus}, map[string]any{"status": campaign.Status}, request.IP(), request.Header("User-Agent"))
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), campaignSendTimeout)
+		defer cancel()
+		if _, err := models.ProcessDueCampaigns(ctx); err != nil {
+			log.Error("Failed to send campaign %d: %v", campaign.ID, err)
+		}
+	}()
+
+	stats, _ := models.GetCampaignStats(campaign.ID)
+	return response.OK(CampaignDetail{Campaign: *campaign, Stats: stats})
+}
+
+// CancelCampaign stops a scheduled or sending campaign; messages already sent are not affected
+func (c Controller) CancelCampaign(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	campaign, errResp := findCampaign(request)
+	if errResp != nil {
+		return errResp
+	}
+	if campaign.Status != models.CampaignStatusScheduled && campaign.Status != models.CampaignStatusSending {
+		return response.BadRequest(request, "Only scheduled or sending campaigns can be cancelled")
+	}
+
+	oldStatus := campaign.Status
+	if err := models.CancelCampaign(campaign); err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogCampaignChange(campaign.ID, models.ActionStatusChange, user,
+		map[string]any{"status": oldStatus}, map[string]any{"status": campaign.Status}, request.IP(), request.Header("User-Agent"))
+
+	return response.OK(campaign)
+}
+
+// ListCampaignDeliveries returns the deliveries of a campaign
+func (c Controller) ListCampaignDeliveries(request *evo.Request) any {
+	campaign, errResp := findCampaign(request)
+	if errResp != nil {
+		return errResp
+	}
+
+	var deliveries []models.CampaignDelivery
+	query := db.GetContext(request).Model(&models.CampaignDelivery{}).Preload("Client").Where("campaign_id = ?", campaign.ID)
+	if status := request.Query("status").String(); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	switch request.Query("replied").String() {
+	case "true", "1":
+		query = query.Where("replied_at IS NOT NULL")
+	case "false", "0":
+		query = query.Where("replied_at IS NULL")
+	}
+
+	query = query.Order("id ASC")
+
+	p, err := pagination.New(query, request, &deliveries, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMeta(deliveries, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// ListClientOptOuts returns campaign opt-outs
+func (c Controller) ListClientOptOuts(request *evo.Request) any {
+	var optOuts []models.ClientOptOut
+
+	query := db.GetContext(request).Model(&models.ClientOptOut{}).Preload("Client")
+	if clientID := request.Query("client_id").String(); clientID != "" {
+		query = query.Where("client_id = ?", clientID)
+	}
+	if channel := request.Query("channel").String(); channel != "" {
+		query = query.Where("channel = ?", channel)
+	}
+	if source := request.Query("source").String(); source != "" {
+		query = query.Where("source = ?", source)
+	}
+
+	query = query.Order("id DESC")
+
+	p, err := pagination.New(query, request, &optOuts, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMeta(optOuts, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// CreateClientOptOut opts a client out of campaigns on a channel on their behalf
+func (c Controller) CreateClientOptOut(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	var req ClientOptOutRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.BadRequest(request, "Invalid request body")
+	}
+	if !slices.Contains(models.OutboundChannels, req.Channel) {
+		return response.BadRequest(request, "Invalid channel")
+	}
+
+	var count int64
+	db.GetContext(request).Model(&models.Client{}).Where("id = ?", req.ClientID).Count(&count)
+	if count == 0 {
+		return response.NotFound(request, "Client not found")
+	}
+
+	if err := models.OptOutClient(req.ClientID, req.Channel, models.OptOutSourceAgent, nil); err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogClientOptOutChange(req.ClientID, models.ActionCreate, user, req.Channel, request.IP(), request.Header("User-Agent"))
+
+	var optOut models.ClientOptOut
+	db.GetContext(request).Where("client_id = ? AND channel = ?", req.ClientID, req.Channel).First(&optOut)
+	return response.Created(optOut)
+}
+
+// DeleteClientOptOut removes a campaign opt-out so the client receives campaigns on the channel again
+func (c Controller) DeleteClientOptOut(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	id := request.Param("id").Int()
+	if id <= 0 {
+		return response.Error(response.ErrInvalidInput)
+	}
+
+	var optOut models.ClientOptOut
+	if err := db.GetContext(request).First(&optOut, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return response.NotFound(request, "Opt-out not found")
+		}
+		return response.Error(response.ErrInternalError)
+	}
+
+	if err := db.GetContext(request).Delete(&optOut).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	models.LogClientOptOutChange(optOut.ClientID, models.ActionDelete, user, optOut.Channel, request.IP(), request.Header("User-Agent"))
+
+	return response.OK(map[string]interface{}{
+		"message": "Opt-out removed successfully",
+	})
+}
+
+// applyCampaignRequest copies the request into campaign and validates it
+func applyCampaignRequest(request *evo.Request, campaign *models.Campaign, req *CampaignRequest) any {
+	campaign.Name = req.Name
+	campaign.Channel = req.Channel
+	campaign.Subject = req.Subject
+	campaign.Body = req.Body
+	campaign.WhatsAppTemplate = nil
+	if req.WhatsAppTemplate != nil {
+		template, _ := json.Marshal(req.WhatsAppTemplate)
+		campaign.WhatsAppTemplate = template
+	}
+	segment, _ := json.Marshal(req.Segment)
+	campaign.Segment = segment
+
+	campaign.ScheduledAt = req.ScheduledAt
+	campaign.Status = models.CampaignStatusDraft
+	if req.ScheduledAt != nil {
+		if req.ScheduledAt.Before(time.Now()) {
+			return response.BadRequest(request, "scheduled_at must be in the future")
+		}
+		campaign.Status = models.CampaignStatusScheduled
+	}
+
+	if err := campaign.Validate(); err != nil {
+		return response.BadRequest(request, err.Error())
+	}
+	return nil
+}
+
+func findCampaign(request *evo.Request) (*models.Campaign, any) {
+	id := request.Param("id").Int()
+	if id <= 0 {
+		return nil, response.Error(response.ErrInvalidInput)
+	}
+
+	var campaign models.Campaign
+	if err := db.GetContext(request).First(&campaign, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, response.NotFound(request, "Campaign not found")
+		}
+		return nil, response.Error(response.ErrInternalError)
+	}
+	return &campaign, nil
+}
+
+func campaignValues(campaign *models.Campaign) map[string]any {
+	return map[string]any{
+		"name":         campaign.Name,
+		"channel":      campaign.Channel,
+		"subject":      campaign.Subject,
+		"status":       campaign.Status,
+		"segment":      campaign.Segment,
+		"scheduled_at": campaign.ScheduledAt,
+	}
+}
@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/pagination"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/gorm"
+)
+
+// ===============================
+// CANNED MESSAGE USAGE APIs
+// ===============================
+
+// PruneCannedMessagesRequest selects canned messages to delete.
+// Either explicit IDs or an unused_days threshold must be given.
+type PruneCannedMessagesRequest struct {
+	IDs        []uint `json:"ids"`
+	UnusedDays int    `json:"unused_days"`
+	Scope      string `json:"scope"`
+	DryRun     bool   `json:"dry_run"`
+}
+
+// ListCannedMessageUsage returns canned messages of every scope ordered by usage (least used first)
+func (c Controller) ListCannedMessageUsage(request *evo.Request) any {
+	var messages []models.CannedMessage
+
+	query := db.GetContext(request).Model(&models.CannedMessage{})
+
+	if scope := request.Query("scope").String(); scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+	if departmentID := request.Query("department_id").Int(); departmentID > 0 {
+		query = query.Where("department_id = ?", departmentID)
+	}
+	if unusedDays := request.Query("unused_days").Int(); unusedDays > 0 {
+		query = unusedCannedMessages(query, unusedDays)
+	}
+
+	query = query.Order("usage_count ASC").Order("last_used_at ASC").Order("id ASC")
+
+	p, err := pagination.New(query, request, &messages, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMeta(messages, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// PruneCannedMessages deletes the given canned messages, or all not used within unused_days
+func (c Controller) PruneCannedMessages(request *evo.Request) any {
+	var req PruneCannedMessagesRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.BadRequest(request, "Invalid request body")
+	}
+	if len(req.IDs) == 0 && req.UnusedDays <= 0 {
+		return response.BadRequest(request, "Either ids or unused_days is required")
+	}
+
+	query := db.GetContext(request).Model(&models.CannedMessage{})
+	if len(req.IDs) > 0 {
+		query = query.Where("id IN ?", req.IDs)
+	}
+	if req.UnusedDays > 0 {
+		query = unusedCannedMessages(query, req.UnusedDays)
+	}
+	if req.Scope != "" {
+		query = query.Where("scope = ?", req.Scope)
+	}
+
+	var ids []uint
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	if !req.DryRun {
+		if err := models.DeleteCannedMessages(ids); err != nil {
+			return response.Error(response.ErrInternalError)
+		}
+	}
+
+	return response.OK(map[string]any{
+		"deleted": len(ids),
+		"ids":     ids,
+		"dry_run": req.DryRun,
+	})
+}
+
+// unusedCannedMessages restricts query to canned messages not used in the last days
+// (never used messages count as unused once they are older than that)
+func unusedCannedMessages(query *gorm.DB, days int) *gorm.DB {
+	threshold := time.Now().AddDate(0, 0, -days)
+	return query.Where("(last_used_at IS NULL AND created_at < ?) OR last_used_at < ?", threshold, threshold)
+}
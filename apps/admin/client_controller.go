@@ -7,6 +7,7 @@ import (
 	"github.com/getevo/evo/v2/lib/db"
 	"github.com/getevo/pagination"
 	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/auth"
 	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/lib/response"
 	"gorm.io/gorm"
@@ -21,7 +22,7 @@ import (
 // ListClients returns paginated list of clients with search and filtering
 func (c Controller) ListClients(request *evo.Request) any {
 	var clients []models.Client
-	query := db.
+	query := db.GetContext(request).
 		Preload("ExternalIDs").
 		Preload("Conversations")
 
@@ -81,128 +82,23 @@ func (c Controller) MergeClients(request *evo.Request) any {
 		return response.Error(response.ErrInvalidInput)
 	}
 
-	// Verify target client exists
-	var targetClient models.Client
-	err := db.First(&targetClient, "id = ?", req.TargetClientID).Error
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return response.Error(response.ErrNotFound)
-		}
-		return response.Error(response.ErrInternalError)
-	}
-
-	// Verify all source clients exist
-	var sourceClients []models.Client
-	err = db.Where("id IN (?)", req.SourceClientIDs).Find(&sourceClients).Error
-	if err != nil {
-		return response.Error(response.ErrInternalError)
+	var userID *uuid.UUID
+	actor, ok := request.User().(*auth.User)
+	if ok {
+		userID = &actor.UserID
 	}
 
-	if len(sourceClients) != len(req.SourceClientIDs) {
-		return response.Error(response.ErrInvalidInput)
-	}
-
-	// Ensure target client is not in source clients list
-	for _, sourceID := range req.SourceClientIDs {
-		if sourceID == req.TargetClientID {
-			return response.Error(response.ErrInvalidInput)
-		}
+	var count int64
+	db.GetContext(request).Model(&models.Client{}).Where("id = ?", req.TargetClientID).Count(&count)
+	if count == 0 {
+		return mergeClientsError(models.ErrMergeClientNotFound)
 	}
 
-	// Start transaction
-	tx := db.Begin()
-
-	// Move all tickets to target client
-	err = tx.Model(&models.Conversation{}).
-		Where("client_id IN (?)", req.SourceClientIDs).
-		Update("client_id", req.TargetClientID).Error
+	targetClient, err := models.MergeClients(req.TargetClientID, req.SourceClientIDs, userID)
 	if err != nil {
-		tx.Rollback()
-		return response.Error(response.ErrInternalError)
-	}
-
-	// Move all messages to target client
-	err = tx.Model(&models.Message{}).
-		Where("client_id IN (?)", req.SourceClientIDs).
-		Update("client_id", req.TargetClientID).Error
-	if err != nil {
-		tx.Rollback()
-		return response.Error(response.ErrInternalError)
-	}
-
-	// Move all external IDs to target client (avoid duplicates)
-	for _, sourceID := range req.SourceClientIDs {
-		err = tx.Model(&models.ClientExternalID{}).
-			Where("client_id = ?", sourceID).
-			Update("client_id", req.TargetClientID).Error
-		if err != nil {
-			// If there are duplicates, delete the source ones
-			if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
-				tx.Where("client_id = ?", sourceID).Delete(&models.ClientExternalID{})
-			} else {
-				tx.Rollback()
-				return response.Error(response.ErrInternalError)
-			}
-		}
-	}
-
-	// Merge data from source clients into target client
-	var targetData map[string]interface{}
-	if len(targetClient.Data) > 0 {
-		err = json.Unmarshal(targetClient.Data, &targetData)
-		if err != nil {
-			targetData = make(map[string]interface{})
-		}
-	} else {
-		targetData = make(map[string]interface{})
-	}
-
-	for _, sourceClient := range sourceClients {
-		var sourceData map[string]interface{}
-		if len(sourceClient.Data) > 0 {
-			err = json.Unmarshal(sourceClient.Data, &sourceData)
-			if err == nil {
-				// Merge source data into target data
-				for key, value := range sourceData {
-					if _, exists := targetData[key]; !exists {
-						targetData[key] = value
-					}
-				}
-			}
-		}
-	}
-
-	// Update target client with merged data
-	mergedData, err := json.Marshal(targetData)
-	if err != nil {
-		tx.Rollback()
-		return response.Error(response.ErrInternalError)
-	}
-
-	err = tx.Model(&targetClient).Update("data", mergedData).Error
-	if err != nil {
-		tx.Rollback()
-		return response.Error(response.ErrInternalError)
-	}
-
-	// Delete source clients
-	err = tx.Where("id IN (?)", req.SourceClientIDs).Delete(&models.Client{}).Error
-	if err != nil {
-		tx.Rollback()
-		return response.Error(response.ErrInternalError)
-	}
-
-	// Commit transaction
-	tx.Commit()
-
-	// Fetch updated target client
-	err = db.
-		Preload("ExternalIDs").
-		Preload("Conversations").
-		First(&targetClient, "id = ?", req.TargetClientID).Error
-	if err != nil {
-		return response.Error(response.ErrInternalError)
+		return mergeClientsError(err)
 	}
+	models.LogClientMerge(req.TargetClientID, req.SourceClientIDs, actor, nil)
 
 	return response.OK(map[string]interface{}{
 		"message":             "Clients merged successfully",
@@ -212,6 +108,18 @@ func (c Controller) MergeClients(request *evo.Request) any {
 	})
 }
 
+// mergeClientsError maps a models.MergeClients error to an API response
+func mergeClientsError(err error) any {
+	switch err {
+	case models.ErrMergeClientNotFound:
+		return response.Error(response.ErrNotFound)
+	case models.ErrMergeInvalidClients:
+		return response.Error(response.ErrInvalidInput)
+	default:
+		return response.Error(response.ErrInternalError)
+	}
+}
+
 // GetClient returns a single client by ID with external IDs
 func (c Controller) GetClient(request *evo.Request) any {
 	clientIDStr := request.Param("id").String()
@@ -221,7 +129,7 @@ func (c Controller) GetClient(request *evo.Request) any {
 	}
 
 	var client models.Client
-	err = db.
+	err = db.GetContext(request).
 		Preload("ExternalIDs").
 		First(&client, "id = ?", clientID).Error
 	if err != nil {
@@ -257,7 +165,7 @@ func (c Controller) CreateClient(request *evo.Request) any {
 	}
 
 	// Start transaction
-	tx := db.Begin()
+	tx := db.GetContext(request).Begin()
 
 	// Create client
 	client := models.Client{
@@ -304,7 +212,7 @@ func (c Controller) CreateClient(request *evo.Request) any {
 	tx.Commit()
 
 	// Fetch created client with external IDs
-	err = db.
+	err = db.GetContext(request).
 		Preload("ExternalIDs").
 		First(&client, "id = ?", client.ID).Error
 	if err != nil {
@@ -339,7 +247,7 @@ func (c Controller) UpdateClient(request *evo.Request) any {
 
 	// Verify client exists
 	var client models.Client
-	err = db.First(&client, "id = ?", clientID).Error
+	err = db.GetContext(request).First(&client, "id = ?", clientID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
@@ -347,8 +255,10 @@ func (c Controller) UpdateClient(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
+	before := client
+
 	// Start transaction
-	tx := db.Begin()
+	tx := db.GetContext(request).Begin()
 
 	// Prepare updates
 	updates := make(map[string]interface{})
@@ -411,13 +321,17 @@ func (c Controller) UpdateClient(request *evo.Request) any {
 	tx.Commit()
 
 	// Fetch updated client with external IDs
-	err = db.
+	err = db.GetContext(request).
 		Preload("ExternalIDs").
 		First(&client, "id = ?", clientID).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
+	actor, _ := request.User().(*auth.User)
+	oldValues, newValues := models.ClientChanges(&before, &client)
+	models.LogClientUpdate(client.ID, actor, oldValues, newValues, request.IP(), request.Header("User-Agent"))
+
 	return response.OK(client)
 }
 
@@ -431,7 +345,7 @@ func (c Controller) DeleteClient(request *evo.Request) any {
 
 	// Verify client exists
 	var client models.Client
-	err = db.First(&client, "id = ?", clientID).Error
+	err = db.GetContext(request).First(&client, "id = ?", clientID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
@@ -440,7 +354,7 @@ func (c Controller) DeleteClient(request *evo.Request) any {
 	}
 
 	// Start transaction
-	tx := db.Begin()
+	tx := db.GetContext(request).Begin()
 
 	// Delete external IDs
 	err = tx.Where("client_id = ?", clientID).Delete(&models.ClientExternalID{}).Error
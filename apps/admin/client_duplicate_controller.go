@@ -0,0 +1,196 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/pagination"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/gorm"
+)
+
+// ===============================
+// CLIENT DUPLICATE REVIEW APIs
+// ===============================
+
+// ScanClientDuplicatesRequest selects the client to scan. Without client_id every client is scanned in the background.
+type ScanClientDuplicatesRequest struct {
+	ClientID *uuid.UUID `json:"client_id"`
+}
+
+// MergeClientDuplicateRequest selects which client of the pair is kept.
+// Keep is "client" (the older client, default) or "duplicate".
+type MergeClientDuplicateRequest struct {
+	Keep string `json:"keep"`
+}
+
+// ListClientDuplicates returns the duplicate review queue ordered by score
+func (c Controller) ListClientDuplicates(request *evo.Request) any {
+	var candidates []models.ClientDuplicateCandidate
+
+	status := request.Query("status").String()
+	if status == "" {
+		status = models.ClientDuplicateStatusPending
+	}
+
+	query := db.GetContext(request).Model(&models.ClientDuplicateCandidate{}).
+		Preload("Client.ExternalIDs").
+		Preload("Duplicate.ExternalIDs").
+		Where("status = ?", status)
+
+	if minScore := request.Query("min_score").Float(); minScore > 0 {
+		query = query.Where("score >= ?", minScore)
+	}
+	if clientID := request.Query("client_id").String(); clientID != "" {
+		query = query.Where("client_id = ? OR duplicate_id = ?", clientID, clientID)
+	}
+
+	query = query.Order("score DESC").Order("id ASC")
+
+	p, err := pagination.New(query, request, &candidates, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMeta(candidates, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// ScanClientDuplicates refreshes duplicate candidates of one client, or of every client in the background.
+// Candidates above the auto-merge threshold are not merged by a scan; they are queued for review.
+func (c Controller) ScanClientDuplicates(request *evo.Request) any {
+	var req ScanClientDuplicatesRequest
+	if err := request.BodyParser(&req); err != nil && len(request.Body()) > 0 {
+		return response.Error(response.ErrInvalidInput)
+	}
+
+	if req.ClientID != nil {
+		var count int64
+		db.GetContext(request).Model(&models.Client{}).Where("id = ?", *req.ClientID).Count(&count)
+		if count == 0 {
+			return response.Error(response.ErrNotFound)
+		}
+		candidates, err := models.FindClientDuplicates(*req.ClientID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return response.Error(response.ErrNotFound)
+			}
+			return response.Error(response.ErrInternalError)
+		}
+		return response.OK(candidates)
+	}
+
+	go scanAllClientDuplicates(models.WorkspaceDB(models.RequestWorkspaceID(request)))
+
+	return response.OK(map[string]interface{}{
+		"message": "Duplicate scan started",
+	})
+}
+
+// scanAllClientDuplicates refreshes duplicate candidates of every client of the workspace of tx, oldest first
+func scanAllClientDuplicates(tx *gorm.DB) {
+	var ids []uuid.UUID
+	if err := tx.Model(&models.Client{}).Order("created_at ASC").Pluck("id", &ids).Error; err != nil {
+		log.Error("Client duplicate scan failed: %v", err)
+		return
+	}
+	for _, id := range ids {
+		// Clients may be merged or deleted while the scan is running
+		if _, err := models.FindClientDuplicates(id); err != nil && err != gorm.ErrRecordNotFound {
+			log.Warning("Failed to scan duplicates of client %s: %v", id, err)
+		}
+	}
+	log.Info("Client duplicate scan finished for %d clients", len(ids))
+}
+
+// MergeClientDuplicate merges a pending candidate pair into the kept client
+func (c Controller) MergeClientDuplicate(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	candidate, errResp := findPendingClientDuplicate(request)
+	if errResp != nil {
+		return errResp
+	}
+
+	// The body is optional; by default the older client is kept
+	var req MergeClientDuplicateRequest
+	if err := request.BodyParser(&req); err != nil && len(request.Body()) > 0 {
+		return response.Error(response.ErrInvalidInput)
+	}
+
+	targetID, sourceID := candidate.ClientID, candidate.DuplicateID
+	switch req.Keep {
+	case "", "client":
+	case "duplicate":
+		targetID, sourceID = sourceID, targetID
+	default:
+		return response.BadRequest(request, "keep must be 'client' or 'duplicate'")
+	}
+
+	targetClient, err := models.MergeClients(targetID, []uuid.UUID{sourceID}, &user.UserID)
+	if err != nil {
+		return mergeClientsError(err)
+	}
+	models.LogClientMerge(targetID, []uuid.UUID{sourceID}, user, map[string]any{
+		"candidate_id": candidate.ID,
+		"score":        candidate.Score,
+	})
+
+	return response.OK(map[string]interface{}{
+		"message":          "Clients merged successfully",
+		"candidate_id":     candidate.ID,
+		"target_client":    targetClient,
+		"merged_client_id": sourceID,
+	})
+}
+
+// DismissClientDuplicate marks a pending candidate pair as not being the same person.
+// Dismissed pairs are never suggested again.
+func (c Controller) DismissClientDuplicate(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	candidate, errResp := findPendingClientDuplicate(request)
+	if errResp != nil {
+		return errResp
+	}
+
+	now := time.Now()
+	err := db.GetContext(request).Model(candidate).Updates(map[string]any{
+		"status":      models.ClientDuplicateStatusDismissed,
+		"resolved_by": user.UserID,
+		"resolved_at": now,
+	}).Error
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OK(candidate)
+}
+
+func findPendingClientDuplicate(request *evo.Request) (*models.ClientDuplicateCandidate, any) {
+	id := request.Param("id").Int()
+	if id <= 0 {
+		return nil, response.Error(response.ErrInvalidInput)
+	}
+
+	var candidate models.ClientDuplicateCandidate
+	if err := db.GetContext(request).Where("id = ?", id).First(&candidate).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, response.Error(response.ErrNotFound)
+		}
+		return nil, response.Error(response.ErrInternalError)
+	}
+	if candidate.Status != models.ClientDuplicateStatusPending {
+		return nil, response.BadRequest(request, "Candidate has already been resolved")
+	}
+	return &candidate, nil
+}
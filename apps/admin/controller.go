@@ -11,9 +11,11 @@ import (
 	"github.com/getevo/pagination"
 	"github.com/google/uuid"
 	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/conversation"
 	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/lib/imageutil"
 	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
@@ -29,7 +31,7 @@ func (c Controller) GetUnreadTickets(request *evo.Request) any {
 	var user = request.User().(*auth.User)
 
 	var tickets []models.Conversation
-	query := db.
+	query := db.GetContext(request).
 		Preload("Client").
 		Preload("Department").
 		Preload("Channel").
@@ -67,7 +69,7 @@ func (c Controller) GetUnreadTicketsCount(request *evo.Request) any {
 	var user = request.User().(*auth.User)
 
 	var count int64
-	query := db.Model(&models.Conversation{}).
+	query := db.GetContext(request).Model(&models.Conversation{}).
 		Where("status IN (?)", []string{models.ConversationStatusNew, models.ConversationStatusWaitForAgent})
 
 	// Administrators can see all tickets, agents see tickets from their departments or assigned to them
@@ -94,7 +96,7 @@ func (c Controller) ListTickets(request *evo.Request) any {
 	var user = request.User().(*auth.User)
 
 	var tickets []models.Conversation
-	query := db.
+	query := db.GetContext(request).
 		Preload("Client").
 		Preload("Client.ExternalIDs").
 		Preload("Department").
@@ -189,7 +191,7 @@ func (c Controller) ChangeTicketStatus(request *evo.Request) any {
 	}
 
 	var ticket models.Conversation
-	err := db.First(&ticket, ticketID).Error
+	err := db.GetContext(request).First(&ticket, ticketID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -197,10 +199,14 @@ func (c Controller) ChangeTicketStatus(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
-	err = db.Model(&ticket).Update("status", req.Status).Error
+	oldStatus := ticket.Status
+	err = db.GetContext(request).Model(&ticket).Update("status", req.Status).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
+	if oldStatus != req.Status {
+		models.LogConversationStatusChange(uint(ticketID), user, oldStatus, req.Status, request.IP(), request.Header("User-Agent"))
+	}
 
 	// Create system message for status change
 	message := models.Message{
@@ -209,7 +215,7 @@ func (c Controller) ChangeTicketStatus(request *evo.Request) any {
 		Body:            "Status changed to " + req.Status,
 		IsSystemMessage: true,
 	}
-	if err := db.Create(&message).Error; err != nil {
+	if err := db.GetContext(request).Create(&message).Error; err != nil {
 		log.Error("Failed to create status change message: %v", err)
 		// Continue - status was updated successfully, just logging failed
 	}
@@ -244,7 +250,7 @@ func (c Controller) ReplyToTicket(request *evo.Request) any {
 
 	// Verify ticket exists
 	var ticket models.Conversation
-	err := db.First(&ticket, ticketID).Error
+	err := db.GetContext(request).First(&ticket, ticketID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -260,14 +266,14 @@ func (c Controller) ReplyToTicket(request *evo.Request) any {
 		IsSystemMessage: false,
 	}
 
-	err = db.Create(&message).Error
+	err = db.GetContext(request).Create(&message).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
 	// Update ticket status to in_progress if it was new
 	if ticket.Status == models.ConversationStatusNew || ticket.Status == models.ConversationStatusWaitForAgent {
-		db.Model(&ticket).Update("status", models.ConversationStatusWaitForUser)
+		db.GetContext(request).Model(&ticket).Update("status", models.ConversationStatusWaitForUser)
 	}
 
 	return response.Created(message)
@@ -302,7 +308,7 @@ func (c Controller) AssignTicket(request *evo.Request) any {
 
 	// Verify ticket exists
 	var ticket models.Conversation
-	err := db.First(&ticket, ticketID).Error
+	err := db.GetContext(request).First(&ticket, ticketID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -321,7 +327,7 @@ func (c Controller) AssignTicket(request *evo.Request) any {
 	}
 
 	// Remove existing assignments
-	err = db.Where("conversation_id = ?", ticketID).Delete(&models.ConversationAssignment{}).Error
+	err = db.GetContext(request).Where("conversation_id = ?", ticketID).Delete(&models.ConversationAssignment{}).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -333,7 +339,7 @@ func (c Controller) AssignTicket(request *evo.Request) any {
 		DepartmentID:   req.DepartmentID,
 	}
 
-	err = db.Create(&assignment).Error
+	err = db.GetContext(request).Create(&assignment).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -352,7 +358,7 @@ func (c Controller) AssignTicket(request *evo.Request) any {
 		Body:            assignmentMessage,
 		IsSystemMessage: true,
 	}
-	if err := db.Create(&message).Error; err != nil {
+	if err := db.GetContext(request).Create(&message).Error; err != nil {
 		log.Error("Failed to create assignment message: %v", err)
 		// Continue - assignment was successful, just logging failed
 	}
@@ -387,7 +393,7 @@ func (c Controller) ChangeTicketDepartments(request *evo.Request) any {
 
 	// Verify ticket exists
 	var ticket models.Conversation
-	err := db.First(&ticket, ticketID).Error
+	err := db.GetContext(request).First(&ticket, ticketID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -396,7 +402,7 @@ func (c Controller) ChangeTicketDepartments(request *evo.Request) any {
 	}
 
 	// Update ticket department
-	err = db.Model(&ticket).Update("department_id", req.DepartmentID).Error
+	err = db.GetContext(request).Model(&ticket).Update("department_id", req.DepartmentID).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -408,7 +414,7 @@ func (c Controller) ChangeTicketDepartments(request *evo.Request) any {
 		Body:            "Ticket department changed",
 		IsSystemMessage: true,
 	}
-	if err := db.Create(&message).Error; err != nil {
+	if err := db.GetContext(request).Create(&message).Error; err != nil {
 		log.Error("Failed to create department change message: %v", err)
 		// Continue - department was updated successfully, just logging failed
 	}
@@ -449,7 +455,7 @@ func (c Controller) TagTicket(request *evo.Request) any {
 
 	// Verify ticket exists
 	var ticket models.Conversation
-	err := db.First(&ticket, ticketID).Error
+	err := db.GetContext(request).First(&ticket, ticketID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -461,7 +467,7 @@ func (c Controller) TagTicket(request *evo.Request) any {
 	var allTagIDs []uint = req.TagIDs
 	if len(req.TagNames) > 0 {
 		var tags []models.Tag
-		err = db.Where("name IN (?)", req.TagNames).Find(&tags).Error
+		err = db.GetContext(request).Where("name IN (?)", req.TagNames).Find(&tags).Error
 		if err != nil {
 			return response.Error(response.ErrInternalError)
 		}
@@ -474,7 +480,7 @@ func (c Controller) TagTicket(request *evo.Request) any {
 	switch req.Action {
 	case "replace":
 		// Remove all existing tags and add new ones
-		err = db.Where("conversation_id = ?", ticketID).Delete(&models.ConversationTag{}).Error
+		err = db.GetContext(request).Where("conversation_id = ?", ticketID).Delete(&models.ConversationTag{}).Error
 		if err != nil {
 			return response.Error(response.ErrInternalError)
 		}
@@ -486,12 +492,12 @@ func (c Controller) TagTicket(request *evo.Request) any {
 				ConversationID: uint(ticketID),
 				TagID:          tagID,
 			}
-			db.FirstOrCreate(&conversationTag, conversationTag)
+			db.GetContext(request).FirstOrCreate(&conversationTag, conversationTag)
 		}
 	case "remove":
 		// Remove specified tags
 		if len(allTagIDs) > 0 {
-			err = db.Where("conversation_id = ? AND tag_id IN (?)", ticketID, allTagIDs).Delete(&models.ConversationTag{}).Error
+			err = db.GetContext(request).Where("conversation_id = ? AND tag_id IN (?)", ticketID, allTagIDs).Delete(&models.ConversationTag{}).Error
 			if err != nil {
 				return response.Error(response.ErrInternalError)
 			}
@@ -521,7 +527,7 @@ func (c Controller) DeleteTicket(request *evo.Request) any {
 
 	// Verify ticket exists
 	var ticket models.Conversation
-	err := db.First(&ticket, ticketID).Error
+	err := db.GetContext(request).First(&ticket, ticketID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -529,8 +535,14 @@ func (c Controller) DeleteTicket(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
+	// Department-scoped roles may only delete tickets of their department
+	if ticket.DepartmentID == nil && !user.HasPermission(auth.PermissionConversationsDelete) ||
+		ticket.DepartmentID != nil && !user.HasDepartmentPermission(auth.PermissionConversationsDelete, *ticket.DepartmentID) {
+		return response.Error(response.ErrForbidden)
+	}
+
 	// Start transaction with defer rollback for cleanup
-	tx := db.Begin()
+	tx := db.GetContext(request).Begin()
 	if tx.Error != nil {
 		log.Error("Failed to start transaction: %v", tx.Error)
 		return response.Error(response.ErrInternalError)
@@ -588,7 +600,7 @@ func (c Controller) DeleteMessage(request *evo.Request) any {
 
 	// Fetch message with ticket information
 	var message models.Message
-	err := db.Preload("Ticket").First(&message, messageID).Error
+	err := db.GetContext(request).Preload("Ticket").First(&message, messageID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
@@ -604,7 +616,7 @@ func (c Controller) DeleteMessage(request *evo.Request) any {
 	}
 
 	// Delete the message
-	err = db.Delete(&message).Error
+	err = db.GetContext(request).Delete(&message).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -641,7 +653,7 @@ func (c Controller) CreateDepartment(request *evo.Request) any {
 	}
 
 	// Use transaction for creating department and assigning users
-	tx := db.Begin()
+	tx := db.GetContext(request).Begin()
 	if tx.Error != nil {
 		log.Error("Failed to start transaction: %v", tx.Error)
 		return response.Error(response.ErrInternalError)
@@ -689,7 +701,7 @@ func (c Controller) CreateDepartment(request *evo.Request) any {
 	}
 
 	// Reload with users and AI agent
-	db.Preload("Users").Preload("AIAgent").First(&department, department.ID)
+	db.GetContext(request).Preload("Users").Preload("AIAgent").First(&department, department.ID)
 
 	return response.Created(department)
 }
@@ -714,7 +726,7 @@ func (c Controller) EditDepartment(request *evo.Request) any {
 	}
 
 	var department models.Department
-	err := db.First(&department, departmentID).Error
+	err := db.GetContext(request).First(&department, departmentID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			notFoundErr := response.NewError(response.ErrorCodeNotFound, "Department not found", 404)
@@ -723,7 +735,7 @@ func (c Controller) EditDepartment(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
-	tx := db.Begin()
+	tx := db.GetContext(request).Begin()
 	if tx.Error != nil {
 		log.Error("Failed to start transaction: %v", tx.Error)
 		return response.Error(response.ErrInternalError)
@@ -789,7 +801,7 @@ func (c Controller) EditDepartment(request *evo.Request) any {
 	}
 
 	// Reload with users and AI agent
-	db.Preload("Users").Preload("AIAgent").First(&department, departmentID)
+	db.GetContext(request).Preload("Users").Preload("AIAgent").First(&department, departmentID)
 
 	return response.OK(department)
 }
@@ -802,7 +814,7 @@ func (c Controller) SoftDeleteDepartment(request *evo.Request) any {
 	}
 
 	var department models.Department
-	err := db.First(&department, departmentID).Error
+	err := db.GetContext(request).First(&department, departmentID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -810,7 +822,7 @@ func (c Controller) SoftDeleteDepartment(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
-	err = db.Delete(&department).Error
+	err = db.GetContext(request).Delete(&department).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -824,7 +836,7 @@ func (c Controller) SoftDeleteDepartment(request *evo.Request) any {
 // ListDepartments returns paginated list of departments with search
 func (c Controller) ListDepartments(request *evo.Request) any {
 	var departments []models.Department
-	query := db.Model(&models.Department{}).Preload("Users").Preload("AIAgent")
+	query := db.GetContext(request).Model(&models.Department{}).Preload("Users").Preload("AIAgent")
 
 	// Search functionality (sanitized)
 	search := sanitizeSearch(request.Query("search").String())
@@ -871,7 +883,7 @@ func (c Controller) GetDepartment(request *evo.Request) any {
 	}
 
 	var department models.Department
-	err := db.Preload("Users").Preload("AIAgent").First(&department, departmentID).Error
+	err := db.GetContext(request).Preload("Users").Preload("AIAgent").First(&department, departmentID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			notFoundErr := response.NewError(response.ErrorCodeNotFound, "Department not found", 404)
@@ -899,7 +911,7 @@ func (c Controller) SuspendDepartment(request *evo.Request) any {
 	}
 
 	var department models.Department
-	err := db.First(&department, departmentID).Error
+	err := db.GetContext(request).First(&department, departmentID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			notFoundErr := response.NewError(response.ErrorCodeNotFound, "Department not found", 404)
@@ -913,7 +925,7 @@ func (c Controller) SuspendDepartment(request *evo.Request) any {
 		newStatus = models.DepartmentStatusSuspended
 	}
 
-	err = db.Model(&department).Update("status", newStatus).Error
+	err = db.GetContext(request).Model(&department).Update("status", newStatus).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -946,7 +958,7 @@ func (c Controller) AddTag(request *evo.Request) any {
 		Name: tagName,
 	}
 
-	err := db.Create(&tag).Error
+	err := db.GetContext(request).Create(&tag).Error
 	if err != nil {
 		if isDuplicateError(err) {
 			return response.Error(response.NewError(response.ErrorCodeConflict, "Tag already exists", 409))
@@ -965,7 +977,7 @@ func (c Controller) DeleteTag(request *evo.Request) any {
 	}
 
 	var tag models.Tag
-	err := db.First(&tag, tagID).Error
+	err := db.GetContext(request).First(&tag, tagID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -974,7 +986,7 @@ func (c Controller) DeleteTag(request *evo.Request) any {
 	}
 
 	// Start transaction to ensure data consistency
-	tx := db.Begin()
+	tx := db.GetContext(request).Begin()
 	if tx.Error != nil {
 		log.Error("Failed to start transaction: %v", tx.Error)
 		return response.Error(response.ErrInternalError)
@@ -1059,11 +1071,11 @@ func (c Controller) CreateUser(request *evo.Request) any {
 
 	// Set password
 	if err := user.SetPassword(req.Password); err != nil {
-		return response.Error(response.ErrInternalError)
+		return auth.PasswordErrorResponse(err)
 	}
 
 	// Save user to database
-	err = db.Create(&user).Error
+	err = db.GetContext(request).Create(&user).Error
 	if err != nil {
 		if isDuplicateError(err) {
 			return response.Error(response.NewError(response.ErrorCodeConflict, "User with this email already exists", 409))
@@ -1100,7 +1112,7 @@ func (c Controller) EditUser(request *evo.Request) any {
 	}
 
 	var user auth.User
-	err = db.First(&user, "id = ?", userID).Error
+	err = db.GetContext(request).First(&user, "id = ?", userID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -1130,12 +1142,12 @@ func (c Controller) EditUser(request *evo.Request) any {
 	// Update password if provided
 	if req.Password != nil && *req.Password != "" {
 		if err := user.SetPassword(*req.Password); err != nil {
-			return response.Error(response.ErrInternalError)
+			return auth.PasswordErrorResponse(err)
 		}
 		updates["password_hash"] = user.PasswordHash
 	}
 
-	err = db.Model(&user).Updates(updates).Error
+	err = db.GetContext(request).Model(&user).Updates(updates).Error
 	if err != nil {
 		if isDuplicateError(err) {
 			return response.Error(response.NewError(response.ErrorCodeConflict, "User with this email already exists", 409))
@@ -1144,7 +1156,7 @@ func (c Controller) EditUser(request *evo.Request) any {
 	}
 
 	// Fetch updated user
-	err = db.First(&user, "id = ?", userID).Error
+	err = db.GetContext(request).First(&user, "id = ?", userID).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -1184,7 +1196,7 @@ func (c Controller) UploadAvatar(request *evo.Request) any {
 // ListUsers returns paginated list of users with search and filtering
 func (c Controller) ListUsers(request *evo.Request) any {
 	var users []auth.User
-	query := db.Model(&auth.User{}).Select("id, name, last_name, display_name, email, type, status, avatar, created_at, updated_at")
+	query := db.GetContext(request).Model(&auth.User{}).Select("id, name, last_name, display_name, email, type, status, avatar, created_at, updated_at")
 
 	// Search functionality (sanitized)
 	search := sanitizeSearch(request.Query("search").String())
@@ -1254,7 +1266,7 @@ func (c Controller) AssignUserToDepartment(request *evo.Request) any {
 
 	// Verify user exists
 	var user auth.User
-	err = db.First(&user, "id = ?", userID).Error
+	err = db.GetContext(request).First(&user, "id = ?", userID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -1264,7 +1276,7 @@ func (c Controller) AssignUserToDepartment(request *evo.Request) any {
 
 	// Verify departments exist
 	var existingDepartments []models.Department
-	err = db.Where("id IN (?)", req.DepartmentIDs).Find(&existingDepartments).Error
+	err = db.GetContext(request).Where("id IN (?)", req.DepartmentIDs).Find(&existingDepartments).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -1277,7 +1289,7 @@ func (c Controller) AssignUserToDepartment(request *evo.Request) any {
 	switch req.Action {
 	case "replace":
 		// Remove all existing department assignments
-		err = db.Where("user_id = ?", userID).Delete(&models.UserDepartment{}).Error
+		err = db.GetContext(request).Where("user_id = ?", userID).Delete(&models.UserDepartment{}).Error
 		if err != nil {
 			return response.Error(response.ErrInternalError)
 		}
@@ -1289,11 +1301,11 @@ func (c Controller) AssignUserToDepartment(request *evo.Request) any {
 				UserID:       userID,
 				DepartmentID: deptID,
 			}
-			db.FirstOrCreate(&userDept, userDept)
+			db.GetContext(request).FirstOrCreate(&userDept, userDept)
 		}
 	case "remove":
 		// Remove specified department assignments
-		err = db.Where("user_id = ? AND department_id IN (?)", userID, req.DepartmentIDs).Delete(&models.UserDepartment{}).Error
+		err = db.GetContext(request).Where("user_id = ? AND department_id IN (?)", userID, req.DepartmentIDs).Delete(&models.UserDepartment{}).Error
 		if err != nil {
 			return response.Error(response.ErrInternalError)
 		}
@@ -1324,7 +1336,7 @@ func (c Controller) BlockUser(request *evo.Request) any {
 
 	// Verify user exists
 	var user auth.User
-	err = db.First(&user, "id = ?", userID).Error
+	err = db.GetContext(request).First(&user, "id = ?", userID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrConversationNotFound)
@@ -1332,26 +1344,20 @@ func (c Controller) BlockUser(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
-	// For now, we'll implement blocking by invalidating the API key
-	// In a full implementation, you might add a 'blocked' field to the User model
+	// Blocking revokes the API keys of the user; unblocked users need new keys
 	if req.Blocked {
-		// Clear API key to block access
-		err = db.Model(&user).Update("api_key", nil).Error
-	} else {
-		// Generate new API key to unblock (if they had one)
-		if user.APIKey != nil {
-			apiKey, err := user.GenerateAPIKey()
-			if err != nil {
-				return response.Error(response.ErrInternalError)
+		revoked, err := auth.RevokeUserAPIKeys(user.UserID)
+		if err != nil {
+			return response.Error(response.ErrInternalError)
+		}
+		if auth.APIKeyAuditLogger != nil {
+			actor := request.User().(*auth.User)
+			for i := range revoked {
+				auth.APIKeyAuditLogger(auth.APIKeyEventRevoked, &revoked[i], actor.UserID, request.IP(), request.Header("User-Agent"))
 			}
-			err = db.Model(&user).Update("api_key", apiKey).Error
 		}
 	}
 
-	if err != nil {
-		return response.Error(response.ErrInternalError)
-	}
-
 	action := "unblocked"
 	if req.Blocked {
 		action = "blocked"
@@ -1371,13 +1377,15 @@ func (c Controller) BlockUser(request *evo.Request) any {
 // CreateCustomAttribute creates a new custom attribute
 func (c Controller) CreateCustomAttribute(request *evo.Request) any {
 	var req struct {
-		Scope       string  `json:"scope" validate:"required,oneof=client conversation"`
-		Name        string  `json:"name" validate:"required,min=1,max=100"`
-		DataType    string  `json:"data_type" validate:"required,oneof=int float date string"`
-		Validation  *string `json:"validation"`
-		Title       string  `json:"title" validate:"required,min=1,max=255"`
-		Description *string `json:"description"`
-		Visibility  string  `json:"visibility" validate:"required,oneof=everyone administrator hidden"`
+		Scope        string         `json:"scope" validate:"required,oneof=client conversation organization"`
+		Name         string         `json:"name" validate:"required,min=1,max=100"`
+		DataType     string         `json:"data_type" validate:"required,oneof=int float date datetime string select multi_select boolean email url phone"`
+		Validation   *string        `json:"validation"`
+		Options      datatypes.JSON `json:"options"`
+		DepartmentID *uint          `json:"department_id"`
+		Title        string         `json:"title" validate:"required,min=1,max=255"`
+		Description  *string        `json:"description"`
+		Visibility   string         `json:"visibility" validate:"required,oneof=everyone administrator hidden"`
 	}
 
 	if err := request.BodyParser(&req); err != nil {
@@ -1393,16 +1401,22 @@ func (c Controller) CreateCustomAttribute(request *evo.Request) any {
 	}
 
 	customAttr := models.CustomAttribute{
-		Scope:       req.Scope,
-		Name:        name,
-		DataType:    req.DataType,
-		Validation:  req.Validation,
-		Title:       req.Title,
-		Description: req.Description,
-		Visibility:  req.Visibility,
+		Scope:        req.Scope,
+		Name:         name,
+		DataType:     req.DataType,
+		Validation:   req.Validation,
+		Options:      req.Options,
+		DepartmentID: req.DepartmentID,
+		Title:        req.Title,
+		Description:  req.Description,
+		Visibility:   req.Visibility,
+	}
+
+	if err := conversation.ValidateAttributeDefinition(db.GetContext(request), customAttr); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid custom attribute definition", 400, err.Error()))
 	}
 
-	err := db.Create(&customAttr).Error
+	err := db.GetContext(request).Create(&customAttr).Error
 	if err != nil {
 		if isDuplicateError(err) {
 			return response.Error(response.NewError(response.ErrorCodeConflict, "Custom attribute with this scope and name already exists", 409))
@@ -1423,11 +1437,13 @@ func (c Controller) EditCustomAttribute(request *evo.Request) any {
 	}
 
 	var req struct {
-		DataType    string  `json:"data_type" validate:"required,oneof=int float date string"`
-		Validation  *string `json:"validation"`
-		Title       string  `json:"title" validate:"required,min=1,max=255"`
-		Description *string `json:"description"`
-		Visibility  string  `json:"visibility" validate:"required,oneof=everyone administrator hidden"`
+		DataType     string         `json:"data_type" validate:"required,oneof=int float date datetime string select multi_select boolean email url phone"`
+		Validation   *string        `json:"validation"`
+		Options      datatypes.JSON `json:"options"`
+		DepartmentID *uint          `json:"department_id"`
+		Title        string         `json:"title" validate:"required,min=1,max=255"`
+		Description  *string        `json:"description"`
+		Visibility   string         `json:"visibility" validate:"required,oneof=everyone administrator hidden"`
 	}
 
 	if err := request.BodyParser(&req); err != nil {
@@ -1435,7 +1451,7 @@ func (c Controller) EditCustomAttribute(request *evo.Request) any {
 	}
 
 	var customAttr models.CustomAttribute
-	err := db.Where("scope = ? AND name = ?", scope, name).First(&customAttr).Error
+	err := db.GetContext(request).Where("scope = ? AND name = ?", scope, name).First(&customAttr).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
@@ -1443,12 +1459,22 @@ func (c Controller) EditCustomAttribute(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
-	err = db.Model(&customAttr).Updates(models.CustomAttribute{
-		DataType:    req.DataType,
-		Validation:  req.Validation,
-		Title:       req.Title,
-		Description: req.Description,
-		Visibility:  req.Visibility,
+	customAttr.DataType = req.DataType
+	customAttr.Validation = req.Validation
+	customAttr.Options = req.Options
+	customAttr.DepartmentID = req.DepartmentID
+	if err := conversation.ValidateAttributeDefinition(db.GetContext(request), customAttr); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid custom attribute definition", 400, err.Error()))
+	}
+
+	err = db.GetContext(request).Model(&customAttr).Select("data_type", "validation", "options", "department_id", "title", "description", "visibility").Updates(models.CustomAttribute{
+		DataType:     req.DataType,
+		Validation:   req.Validation,
+		Options:      req.Options,
+		DepartmentID: req.DepartmentID,
+		Title:        req.Title,
+		Description:  req.Description,
+		Visibility:   req.Visibility,
 	}).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
@@ -1467,7 +1493,7 @@ func (c Controller) DeleteCustomAttribute(request *evo.Request) any {
 	}
 
 	var customAttr models.CustomAttribute
-	err := db.Where("scope = ? AND name = ?", scope, name).First(&customAttr).Error
+	err := db.GetContext(request).Where("scope = ? AND name = ?", scope, name).First(&customAttr).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
@@ -1475,7 +1501,7 @@ func (c Controller) DeleteCustomAttribute(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
-	err = db.Delete(&customAttr).Error
+	err = db.GetContext(request).Delete(&customAttr).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -1490,7 +1516,7 @@ func (c Controller) DeleteCustomAttribute(request *evo.Request) any {
 // ListCustomAttributes returns paginated list of custom attributes with search and filtering
 func (c Controller) ListCustomAttributes(request *evo.Request) any {
 	var customAttrs []models.CustomAttribute
-	query := db.Model(&models.CustomAttribute{})
+	query := db.GetContext(request).Model(&models.CustomAttribute{})
 
 	// Search functionality (sanitized)
 	search := sanitizeSearch(request.Query("search").String())
@@ -1514,6 +1540,11 @@ func (c Controller) ListCustomAttributes(request *evo.Request) any {
 		query = query.Where("visibility = ?", visibility)
 	}
 
+	// Filter by department (attributes shared by all departments are always included)
+	if departmentID := request.Query("department_id").Uint(); departmentID > 0 {
+		query = query.Where("department_id IS NULL OR department_id = ?", departmentID)
+	}
+
 	// Order by
 	orderBy := request.Query("order_by").String()
 	switch orderBy {
@@ -1587,7 +1618,7 @@ func (c Controller) CreateChannel(request *evo.Request) any {
 		channel.Configuration = configJSON
 	}
 
-	err := db.Create(&channel).Error
+	err := db.GetContext(request).Create(&channel).Error
 	if err != nil {
 		if isDuplicateError(err) {
 			return response.Error(response.NewError(response.ErrorCodeConflict, "Channel with this ID already exists", 409))
@@ -1617,7 +1648,7 @@ func (c Controller) EditChannel(request *evo.Request) any {
 	}
 
 	var channel models.Channel
-	err := db.First(&channel, "id = ?", channelID).Error
+	err := db.GetContext(request).First(&channel, "id = ?", channelID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
@@ -1646,13 +1677,13 @@ func (c Controller) EditChannel(request *evo.Request) any {
 		updates["configuration"] = configJSON
 	}
 
-	err = db.Model(&channel).Updates(updates).Error
+	err = db.GetContext(request).Model(&channel).Updates(updates).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
 	// Fetch updated channel
-	err = db.First(&channel, "id = ?", channelID).Error
+	err = db.GetContext(request).First(&channel, "id = ?", channelID).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -1668,7 +1699,7 @@ func (c Controller) DeleteChannel(request *evo.Request) any {
 	}
 
 	var channel models.Channel
-	err := db.First(&channel, "id = ?", channelID).Error
+	err := db.GetContext(request).First(&channel, "id = ?", channelID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
@@ -1678,7 +1709,7 @@ func (c Controller) DeleteChannel(request *evo.Request) any {
 
 	// Check if channel is being used by tickets
 	var ticketCount int64
-	err = db.Model(&models.Conversation{}).Where("channel_id = ?", channelID).Count(&ticketCount).Error
+	err = db.GetContext(request).Model(&models.Conversation{}).Where("channel_id = ?", channelID).Count(&ticketCount).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -1688,7 +1719,7 @@ func (c Controller) DeleteChannel(request *evo.Request) any {
 		return response.Error(conflictErr)
 	}
 
-	err = db.Delete(&channel).Error
+	err = db.GetContext(request).Delete(&channel).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -1702,7 +1733,7 @@ func (c Controller) DeleteChannel(request *evo.Request) any {
 // ListChannels returns paginated list of channels with search and filtering
 func (c Controller) ListChannels(request *evo.Request) any {
 	var channels []models.Channel
-	query := db.Model(&models.Channel{})
+	query := db.GetContext(request).Model(&models.Channel{})
 
 	// Search functionality (sanitized)
 	search := sanitizeSearch(request.Query("search").String())
@@ -1785,7 +1816,7 @@ func isDuplicateError(err error) bool {
 }
 
 func (c Controller) hasTicketAccess(user *auth.User, ticketID uint) bool {
-	if user.Type == auth.UserTypeAdministrator {
+	if user.HasPermission(auth.PermissionTicketsManage) {
 		return true
 	}
 
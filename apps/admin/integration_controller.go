@@ -8,6 +8,7 @@ import (
 	"github.com/getevo/evo/v2/lib/db"
 	"github.com/getevo/evo/v2/lib/settings"
 	"github.com/iesreza/homa-backend/apps/ai"
+	"github.com/iesreza/homa-backend/apps/auth"
 	integrationsDriver "github.com/iesreza/homa-backend/apps/integrations"
 	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/lib/response"
@@ -28,7 +29,7 @@ type InboxInfo struct {
 // ListIntegrations returns all integrations with masked configs
 func (c Controller) ListIntegrations(request *evo.Request) any {
 	var integrations []models.Integration
-	err := db.Preload("Inbox").Order("type ASC").Find(&integrations).Error
+	err := db.GetContext(request).Preload("Inbox").Order("type ASC").Find(&integrations).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -86,7 +87,7 @@ func (c Controller) GetIntegration(request *evo.Request) any {
 	integrationType := request.Param("type").String()
 
 	var integration models.Integration
-	err := db.Preload("Inbox").Where("type = ?", integrationType).First(&integration).Error
+	err := db.GetContext(request).Preload("Inbox").Where("type = ?", integrationType).First(&integration).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// Return empty integration with type info
@@ -152,8 +153,11 @@ func (c Controller) SaveIntegration(request *evo.Request) any {
 	}
 
 	// Get or create integration
-	integration, _ := models.GetIntegration(integrationType)
-	if integration.ID == 0 {
+	integration, _ := models.GetIntegration(models.RequestWorkspaceID(request), integrationType)
+	var oldValues map[string]any
+	if integration.ID != 0 {
+		oldValues = integrationValues(integration)
+	} else {
 		integration = &models.Integration{
 			Type: integrationType,
 			Name: getIntegrationName(integrationType),
@@ -179,12 +183,17 @@ func (c Controller) SaveIntegration(request *evo.Request) any {
 	integration.LastError = ""
 	integration.InboxID = req.InboxID
 
-	if err := models.UpsertIntegration(integration); err != nil {
+	if err := models.UpsertIntegration(models.RequestWorkspaceID(request), integration); err != nil {
 		return response.Error(response.ErrInternalError)
 	}
+	action := models.ActionUpdate
+	if oldValues == nil {
+		action = models.ActionCreate
+	}
+	models.LogIntegrationChange(integration.Type, action, currentUser(request), oldValues, integrationValues(integration), request.IP(), request.Header("User-Agent"))
 
 	// Reload with inbox
-	db.Preload("Inbox").First(integration, integration.ID)
+	db.GetContext(request).Preload("Inbox").First(integration, integration.ID)
 
 	var inbox *InboxInfo
 	if integration.Inbox != nil {
@@ -196,7 +205,7 @@ func (c Controller) SaveIntegration(request *evo.Request) any {
 
 	// Call OnSave callback for post-save actions (e.g., webhook registration)
 	webhookBaseURL := getWebhookBaseURL(request)
-	onSaveResult := integrationsDriver.OnSave(integration.Type, integration.Config, integration.Status, webhookBaseURL)
+	onSaveResult := integrationsDriver.OnSave(integration.Type, integration.Config, integration.Status, webhookBaseURL, integration.WorkspaceID)
 
 	return response.OK(map[string]interface{}{
 		"id":              integration.ID,
@@ -227,7 +236,7 @@ func (c Controller) TestIntegration(request *evo.Request) any {
 	}
 
 	// Get existing integration config to merge with masked values
-	existingIntegration, _ := models.GetIntegration(integrationType)
+	existingIntegration, _ := models.GetIntegration(models.RequestWorkspaceID(request), integrationType)
 	if existingIntegration.ID != 0 && existingIntegration.Config != "" {
 		// Merge the incoming config with existing config to preserve masked sensitive fields
 		req.Config = integrationsDriver.MergeConfigWithExisting(existingIntegration.Config, req.Config)
@@ -244,15 +253,15 @@ func (c Controller) TestIntegration(request *evo.Request) any {
 
 	// Update the integration if it exists
 	if result.Success {
-		integration, _ := models.GetIntegration(integrationType)
+		integration, _ := models.GetIntegration(models.RequestWorkspaceID(request), integrationType)
 		if integration.ID != 0 {
 			now := time.Now()
 			integration.TestedAt = &now
 			integration.LastError = ""
-			db.Save(integration)
+			db.GetContext(request).Save(integration)
 		}
 	} else {
-		integration, _ := models.GetIntegration(integrationType)
+		integration, _ := models.GetIntegration(models.RequestWorkspaceID(request), integrationType)
 		if integration.ID != 0 {
 			now := time.Now()
 			integration.TestedAt = &now
@@ -260,7 +269,7 @@ func (c Controller) TestIntegration(request *evo.Request) any {
 			if result.Details != "" {
 				integration.LastError += ": " + result.Details
 			}
-			db.Save(integration)
+			db.GetContext(request).Save(integration)
 		}
 	}
 
@@ -271,7 +280,7 @@ func (c Controller) TestIntegration(request *evo.Request) any {
 func (c Controller) DeleteIntegration(request *evo.Request) any {
 	integrationType := request.Param("type").String()
 
-	integration, err := models.GetIntegration(integrationType)
+	integration, err := models.GetIntegration(models.RequestWorkspaceID(request), integrationType)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "Integration not found")
@@ -279,9 +288,10 @@ func (c Controller) DeleteIntegration(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
-	if err := db.Delete(integration).Error; err != nil {
+	if err := db.GetContext(request).Delete(integration).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
+	models.LogIntegrationChange(integration.Type, models.ActionDelete, currentUser(request), integrationValues(integration), nil, request.IP(), request.Header("User-Agent"))
 
 	return response.OK(map[string]string{"message": "Integration deleted successfully"})
 }
@@ -330,7 +340,7 @@ func getWebhookBaseURL(request *evo.Request) string {
 func (c Controller) ListAIAgents(request *evo.Request) any {
 	var agents []models.AIAgent
 
-	query := db.Order("id DESC")
+	query := db.GetContext(request).Order("id DESC")
 
 	// Filter by status if provided
 	if status := request.Query("status").String(); status != "" {
@@ -353,7 +363,7 @@ func (c Controller) GetAIAgent(request *evo.Request) any {
 	id := request.Param("id").String()
 	var agent models.AIAgent
 
-	err := db.Preload("Bot").Preload("HandoverUser").First(&agent, id).Error
+	err := db.GetContext(request).Preload("Bot").Preload("HandoverUser").First(&agent, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "AI Agent not found")
@@ -401,13 +411,14 @@ func (c Controller) CreateAIAgent(request *evo.Request) any {
 	}
 
 	// Create the agent
-	err := db.Create(&agent).Error
+	err := db.GetContext(request).Create(&agent).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
+	models.LogAIAgentChange(agent.ID, models.ActionCreate, currentUser(request), nil, auditValues(&agent, "bot", "handover_user"), request.IP(), request.Header("User-Agent"))
 
 	// Reload with relationships
-	db.Preload("Bot").Preload("HandoverUser").First(&agent, agent.ID)
+	db.GetContext(request).Preload("Bot").Preload("HandoverUser").First(&agent, agent.ID)
 
 	return response.OK(agent)
 }
@@ -417,13 +428,14 @@ func (c Controller) UpdateAIAgent(request *evo.Request) any {
 	id := request.Param("id").String()
 
 	var agent models.AIAgent
-	err := db.First(&agent, id).Error
+	err := db.GetContext(request).First(&agent, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "AI Agent not found")
 		}
 		return response.Error(response.ErrInternalError)
 	}
+	oldValues := auditValues(&agent, "bot", "handover_user")
 
 	// Parse update data
 	var updateData map[string]any
@@ -463,13 +475,14 @@ func (c Controller) UpdateAIAgent(request *evo.Request) any {
 	}
 
 	// Update the agent
-	err = db.Model(&agent).Updates(updateData).Error
+	err = db.GetContext(request).Model(&agent).Updates(updateData).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
 	// Reload with relationships
-	db.Preload("Bot").Preload("HandoverUser").First(&agent, id)
+	db.GetContext(request).Preload("Bot").Preload("HandoverUser").First(&agent, id)
+	models.LogAIAgentChange(agent.ID, models.ActionUpdate, currentUser(request), oldValues, auditValues(&agent, "bot", "handover_user"), request.IP(), request.Header("User-Agent"))
 
 	return response.OK(agent)
 }
@@ -479,7 +492,7 @@ func (c Controller) DeleteAIAgent(request *evo.Request) any {
 	id := request.Param("id").String()
 
 	var agent models.AIAgent
-	err := db.First(&agent, id).Error
+	err := db.GetContext(request).First(&agent, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "AI Agent not found")
@@ -489,16 +502,17 @@ func (c Controller) DeleteAIAgent(request *evo.Request) any {
 
 	// Check if agent is being used by any departments
 	var count int64
-	db.Model(&models.Department{}).Where("ai_agent_id = ?", id).Count(&count)
+	db.GetContext(request).Model(&models.Department{}).Where("ai_agent_id = ?", id).Count(&count)
 	if count > 0 {
 		return response.BadRequest(request, "Cannot delete AI agent that is assigned to departments")
 	}
 
 	// Delete the agent
-	err = db.Delete(&agent).Error
+	err = db.GetContext(request).Delete(&agent).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
+	models.LogAIAgentChange(agent.ID, models.ActionDelete, currentUser(request), auditValues(&agent, "bot", "handover_user"), nil, request.IP(), request.Header("User-Agent"))
 
 	return response.OK(map[string]string{"message": "AI Agent deleted successfully"})
 }
@@ -510,7 +524,7 @@ func (c Controller) GetAIAgentTemplate(request *evo.Request) any {
 
 	// Load agent with relationships
 	var agent models.AIAgent
-	err := db.Preload("Bot").Preload("HandoverUser").First(&agent, id).Error
+	err := db.GetContext(request).Preload("Bot").Preload("HandoverUser").First(&agent, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "AI Agent not found")
@@ -520,16 +534,16 @@ func (c Controller) GetAIAgentTemplate(request *evo.Request) any {
 
 	// Load agent tools
 	var tools []models.AIAgentTool
-	db.Where("ai_agent_id = ?", id).Order("id ASC").Find(&tools)
+	db.GetContext(request).Where("ai_agent_id = ?", id).Order("id ASC").Find(&tools)
 
 	// Get project name from settings
-	projectName := models.GetSettingValue("general.project_name", "Your Project")
+	projectName := models.GetWorkspaceSettingValue(agent.WorkspaceID, "general.project_name", "Your Project")
 
 	// Build template data for Jet template
 	templateData := ai.BuildTemplateData(&agent, projectName)
 
 	// Get the custom template from settings, or use default
-	customTemplate := models.GetSettingValue(ai.SettingKeyBotPromptTemplate, "")
+	customTemplate := models.GetWorkspaceSettingValue(agent.WorkspaceID, ai.SettingKeyBotPromptTemplate, "")
 	templateContent := customTemplate
 	if templateContent == "" {
 		templateContent = ai.GetDefaultBotPromptTemplate()
@@ -568,7 +582,7 @@ func (c Controller) ListAIAgentTools(request *evo.Request) any {
 
 	// Verify agent exists
 	var agent models.AIAgent
-	if err := db.First(&agent, agentID).Error; err != nil {
+	if err := db.GetContext(request).First(&agent, agentID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "AI Agent not found")
 		}
@@ -576,7 +590,7 @@ func (c Controller) ListAIAgentTools(request *evo.Request) any {
 	}
 
 	var tools []models.AIAgentTool
-	err := db.Where("ai_agent_id = ?", agentID).Order("id ASC").Find(&tools).Error
+	err := db.GetContext(request).Where("ai_agent_id = ?", agentID).Order("id ASC").Find(&tools).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -589,7 +603,7 @@ func (c Controller) GetAIAgentTool(request *evo.Request) any {
 	toolID := request.Param("tool_id").String()
 
 	var tool models.AIAgentTool
-	err := db.First(&tool, toolID).Error
+	err := db.GetContext(request).First(&tool, toolID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "Tool not found")
@@ -606,7 +620,7 @@ func (c Controller) CreateAIAgentTool(request *evo.Request) any {
 
 	// Verify agent exists
 	var agent models.AIAgent
-	if err := db.First(&agent, agentID).Error; err != nil {
+	if err := db.GetContext(request).First(&agent, agentID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "AI Agent not found")
 		}
@@ -648,10 +662,11 @@ func (c Controller) CreateAIAgentTool(request *evo.Request) any {
 	}
 
 	// Create the tool
-	err := db.Create(&tool).Error
+	err := db.GetContext(request).Create(&tool).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
+	models.LogAIAgentToolChange(tool.ID, tool.AIAgentID, models.ActionCreate, currentUser(request), nil, auditValues(&tool, toolSecretFields...), request.IP(), request.Header("User-Agent"))
 
 	return response.OK(tool)
 }
@@ -661,13 +676,14 @@ func (c Controller) UpdateAIAgentTool(request *evo.Request) any {
 	toolID := request.Param("tool_id").String()
 
 	var tool models.AIAgentTool
-	err := db.First(&tool, toolID).Error
+	err := db.GetContext(request).First(&tool, toolID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "Tool not found")
 		}
 		return response.Error(response.ErrInternalError)
 	}
+	oldValues := auditValues(&tool, toolSecretFields...)
 
 	// Parse update data into the tool struct directly
 	if err := request.BodyParser(&tool); err != nil {
@@ -675,10 +691,11 @@ func (c Controller) UpdateAIAgentTool(request *evo.Request) any {
 	}
 
 	// Save all fields
-	err = db.Save(&tool).Error
+	err = db.GetContext(request).Save(&tool).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
+	models.LogAIAgentToolChange(tool.ID, tool.AIAgentID, models.ActionUpdate, currentUser(request), oldValues, auditValues(&tool, toolSecretFields...), request.IP(), request.Header("User-Agent"))
 
 	return response.OK(tool)
 }
@@ -688,7 +705,7 @@ func (c Controller) DeleteAIAgentTool(request *evo.Request) any {
 	toolID := request.Param("tool_id").String()
 
 	var tool models.AIAgentTool
-	err := db.First(&tool, toolID).Error
+	err := db.GetContext(request).First(&tool, toolID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "Tool not found")
@@ -697,10 +714,45 @@ func (c Controller) DeleteAIAgentTool(request *evo.Request) any {
 	}
 
 	// Delete the tool
-	err = db.Delete(&tool).Error
+	err = db.GetContext(request).Delete(&tool).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
+	models.LogAIAgentToolChange(tool.ID, tool.AIAgentID, models.ActionDelete, currentUser(request), auditValues(&tool, toolSecretFields...), nil, request.IP(), request.Header("User-Agent"))
 
 	return response.OK(map[string]string{"message": "Tool deleted successfully"})
 }
+
+// toolSecretFields are left out of the activity log entries of AI agent tools
+var toolSecretFields = []string{"authorization_value", "ai_agent"}
+
+// integrationValues returns the audited fields of an integration, with secrets masked
+func integrationValues(integration *models.Integration) map[string]any {
+	return map[string]any{
+		"status":   integration.Status,
+		"inbox_id": integration.InboxID,
+		"config":   integrationsDriver.GetMaskedConfig(integration.Type, integration.Config),
+	}
+}
+
+// auditValues returns the JSON fields of v for the activity log, without timestamps and the omitted fields
+func auditValues(v any, omit ...string) map[string]any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil
+	}
+	for _, key := range append(omit, "created_at", "updated_at") {
+		delete(values, key)
+	}
+	return values
+}
+
+// currentUser returns the user making the request, nil when anonymous
+func currentUser(request *evo.Request) *auth.User {
+	user, _ := request.User().(*auth.User)
+	return user
+}
@@ -25,7 +25,7 @@ import (
 // ListKBArticles returns paginated list of knowledge base articles
 func (c Controller) ListKBArticles(request *evo.Request) any {
 	var articles []models.KnowledgeBaseArticle
-	query := db.Model(&models.KnowledgeBaseArticle{}).
+	query := db.GetContext(request).Model(&models.KnowledgeBaseArticle{}).
 		Preload("Category").
 		Preload("Tags").
 		Preload("Media")
@@ -80,7 +80,7 @@ func (c Controller) GetKBArticle(request *evo.Request) any {
 	}
 
 	var article models.KnowledgeBaseArticle
-	err = db.Model(&models.KnowledgeBaseArticle{}).
+	err = db.GetContext(request).Model(&models.KnowledgeBaseArticle{}).
 		Preload("Category").
 		Preload("Tags").
 		Preload("Media", func(db *gorm.DB) *gorm.DB {
@@ -126,12 +126,16 @@ func (c Controller) CreateKBArticle(request *evo.Request) any {
 		return response.Error(response.ErrInvalidInput)
 	}
 
+	if req.Status == "published" && !user.HasPermission(auth.PermissionKBPublish) {
+		return response.Error(response.NewError(response.ErrorCodeForbidden, "You are not allowed to publish articles", 403))
+	}
+
 	// Generate slug from title
 	slug := generateSlug(req.Title)
 
 	// Ensure slug is unique
 	var count int64
-	db.Model(&models.KnowledgeBaseArticle{}).Where("slug = ?", slug).Count(&count)
+	db.GetContext(request).Model(&models.KnowledgeBaseArticle{}).Where("slug = ?", slug).Count(&count)
 	if count > 0 {
 		slug = slug + "-" + time.Now().Format("20060102150405")
 	}
@@ -164,7 +168,7 @@ func (c Controller) CreateKBArticle(request *evo.Request) any {
 	}
 
 	// Create article
-	if err := db.Create(&article).Error; err != nil {
+	if err := db.GetContext(request).Create(&article).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
@@ -176,7 +180,7 @@ func (c Controller) CreateKBArticle(request *evo.Request) any {
 					ArticleID: article.ID,
 					TagID:     tagID,
 				}
-				db.Create(&articleTag)
+				db.GetContext(request).Create(&articleTag)
 			}
 		}
 	}
@@ -198,12 +202,12 @@ func (c Controller) CreateKBArticle(request *evo.Request) any {
 				SortOrder:   m.SortOrder,
 				IsPrimary:   isPrimary,
 			}
-			db.Create(&media)
+			db.GetContext(request).Create(&media)
 		}
 	}
 
 	// Reload article with relationships
-	db.Model(&models.KnowledgeBaseArticle{}).
+	db.GetContext(request).Model(&models.KnowledgeBaseArticle{}).
 		Preload("Category").
 		Preload("Tags").
 		Preload("Media").
@@ -222,7 +226,7 @@ func (c Controller) UpdateKBArticle(request *evo.Request) any {
 	}
 
 	var article models.KnowledgeBaseArticle
-	if err := db.Where("id = ?", articleID).First(&article).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", articleID).First(&article).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
 		}
@@ -253,6 +257,14 @@ func (c Controller) UpdateKBArticle(request *evo.Request) any {
 		return response.Error(response.ErrInvalidInput)
 	}
 
+	// Publishing and unpublishing need the publish permission
+	if req.Status != "" && req.Status != article.Status && (req.Status == "published" || article.Status == "published") {
+		user := request.User().(*auth.User)
+		if !user.HasPermission(auth.PermissionKBPublish) {
+			return response.Error(response.NewError(response.ErrorCodeForbidden, "You are not allowed to publish articles", 403))
+		}
+	}
+
 	// Update fields
 	if req.Title != "" {
 		article.Title = req.Title
@@ -260,7 +272,7 @@ func (c Controller) UpdateKBArticle(request *evo.Request) any {
 		newSlug := generateSlug(req.Title)
 		if newSlug != article.Slug {
 			var count int64
-			db.Model(&models.KnowledgeBaseArticle{}).Where("slug = ? AND id != ?", newSlug, article.ID).Count(&count)
+			db.GetContext(request).Model(&models.KnowledgeBaseArticle{}).Where("slug = ? AND id != ?", newSlug, article.ID).Count(&count)
 			if count > 0 {
 				newSlug = newSlug + "-" + time.Now().Format("20060102150405")
 			}
@@ -303,14 +315,14 @@ func (c Controller) UpdateKBArticle(request *evo.Request) any {
 	}
 
 	// Save article
-	if err := db.Save(&article).Error; err != nil {
+	if err := db.GetContext(request).Save(&article).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
 	// Update tags if provided
 	if req.TagIDs != nil {
 		// Delete existing tags
-		db.Where("article_id = ?", article.ID).Delete(&models.KnowledgeBaseArticleTag{})
+		db.GetContext(request).Where("article_id = ?", article.ID).Delete(&models.KnowledgeBaseArticleTag{})
 
 		// Add new tags
 		for _, tagIDStr := range req.TagIDs {
@@ -319,7 +331,7 @@ func (c Controller) UpdateKBArticle(request *evo.Request) any {
 					ArticleID: article.ID,
 					TagID:     tagID,
 				}
-				db.Create(&articleTag)
+				db.GetContext(request).Create(&articleTag)
 			}
 		}
 	}
@@ -327,7 +339,7 @@ func (c Controller) UpdateKBArticle(request *evo.Request) any {
 	// Update media if provided (ensure only one is primary)
 	if req.Media != nil {
 		// Delete existing media
-		db.Where("article_id = ?", article.ID).Delete(&models.KnowledgeBaseMedia{})
+		db.GetContext(request).Where("article_id = ?", article.ID).Delete(&models.KnowledgeBaseMedia{})
 
 		// Add new media
 		hasPrimary := false
@@ -345,12 +357,12 @@ func (c Controller) UpdateKBArticle(request *evo.Request) any {
 				SortOrder:   m.SortOrder,
 				IsPrimary:   isPrimary,
 			}
-			db.Create(&media)
+			db.GetContext(request).Create(&media)
 		}
 	}
 
 	// Reload article with relationships
-	db.Model(&models.KnowledgeBaseArticle{}).
+	db.GetContext(request).Model(&models.KnowledgeBaseArticle{}).
 		Preload("Category").
 		Preload("Tags").
 		Preload("Media").
@@ -369,7 +381,7 @@ func (c Controller) DeleteKBArticle(request *evo.Request) any {
 	}
 
 	var article models.KnowledgeBaseArticle
-	if err := db.Where("id = ?", articleID).First(&article).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", articleID).First(&article).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
 		}
@@ -377,12 +389,12 @@ func (c Controller) DeleteKBArticle(request *evo.Request) any {
 	}
 
 	// Delete related records
-	db.Where("article_id = ?", articleID).Delete(&models.KnowledgeBaseArticleTag{})
-	db.Where("article_id = ?", articleID).Delete(&models.KnowledgeBaseMedia{})
-	db.Where("article_id = ?", articleID).Delete(&models.KnowledgeBaseChunk{})
+	db.GetContext(request).Where("article_id = ?", articleID).Delete(&models.KnowledgeBaseArticleTag{})
+	db.GetContext(request).Where("article_id = ?", articleID).Delete(&models.KnowledgeBaseMedia{})
+	db.GetContext(request).Where("article_id = ?", articleID).Delete(&models.KnowledgeBaseChunk{})
 
 	// Delete article
-	if err := db.Delete(&article).Error; err != nil {
+	if err := db.GetContext(request).Delete(&article).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
@@ -396,7 +408,7 @@ func (c Controller) DeleteKBArticle(request *evo.Request) any {
 // ListKBCategories returns all knowledge base categories
 func (c Controller) ListKBCategories(request *evo.Request) any {
 	var categories []models.KnowledgeBaseCategory
-	query := db.Model(&models.KnowledgeBaseCategory{}).
+	query := db.GetContext(request).Model(&models.KnowledgeBaseCategory{}).
 		Preload("Parent").
 		Preload("Children").
 		Order("sort_order ASC, name ASC")
@@ -411,7 +423,7 @@ func (c Controller) ListKBCategories(request *evo.Request) any {
 		Count      int
 	}
 	var counts []ArticleCount
-	db.Model(&models.KnowledgeBaseArticle{}).
+	db.GetContext(request).Model(&models.KnowledgeBaseArticle{}).
 		Select("category_id, COUNT(*) as count").
 		Where("category_id IS NOT NULL").
 		Group("category_id").
@@ -448,7 +460,7 @@ func (c Controller) GetKBCategory(request *evo.Request) any {
 	}
 
 	var category models.KnowledgeBaseCategory
-	if err := db.Where("id = ?", categoryID).First(&category).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", categoryID).First(&category).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
 		}
@@ -476,7 +488,7 @@ func (c Controller) CreateKBCategory(request *evo.Request) any {
 	// Generate slug
 	slug := generateSlug(req.Name)
 	var count int64
-	db.Model(&models.KnowledgeBaseCategory{}).Where("slug = ?", slug).Count(&count)
+	db.GetContext(request).Model(&models.KnowledgeBaseCategory{}).Where("slug = ?", slug).Count(&count)
 	if count > 0 {
 		slug = slug + "-" + time.Now().Format("20060102150405")
 	}
@@ -496,7 +508,7 @@ func (c Controller) CreateKBCategory(request *evo.Request) any {
 		}
 	}
 
-	if err := db.Create(&category).Error; err != nil {
+	if err := db.GetContext(request).Create(&category).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
@@ -512,7 +524,7 @@ func (c Controller) UpdateKBCategory(request *evo.Request) any {
 	}
 
 	var category models.KnowledgeBaseCategory
-	if err := db.Where("id = ?", categoryID).First(&category).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", categoryID).First(&category).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
 		}
@@ -538,7 +550,7 @@ func (c Controller) UpdateKBCategory(request *evo.Request) any {
 		newSlug := generateSlug(req.Name)
 		if newSlug != category.Slug {
 			var count int64
-			db.Model(&models.KnowledgeBaseCategory{}).Where("slug = ? AND id != ?", newSlug, category.ID).Count(&count)
+			db.GetContext(request).Model(&models.KnowledgeBaseCategory{}).Where("slug = ? AND id != ?", newSlug, category.ID).Count(&count)
 			if count > 0 {
 				newSlug = newSlug + "-" + time.Now().Format("20060102150405")
 			}
@@ -562,7 +574,7 @@ func (c Controller) UpdateKBCategory(request *evo.Request) any {
 		category.SortOrder = *req.SortOrder
 	}
 
-	if err := db.Save(&category).Error; err != nil {
+	if err := db.GetContext(request).Save(&category).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
@@ -578,7 +590,7 @@ func (c Controller) DeleteKBCategory(request *evo.Request) any {
 	}
 
 	var category models.KnowledgeBaseCategory
-	if err := db.Where("id = ?", categoryID).First(&category).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", categoryID).First(&category).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
 		}
@@ -587,19 +599,19 @@ func (c Controller) DeleteKBCategory(request *evo.Request) any {
 
 	// Check if there are articles using this category
 	var articleCount int64
-	db.Model(&models.KnowledgeBaseArticle{}).Where("category_id = ?", categoryID).Count(&articleCount)
+	db.GetContext(request).Model(&models.KnowledgeBaseArticle{}).Where("category_id = ?", categoryID).Count(&articleCount)
 	if articleCount > 0 {
 		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Cannot delete category with articles", 400))
 	}
 
 	// Check if there are child categories
 	var childCount int64
-	db.Model(&models.KnowledgeBaseCategory{}).Where("parent_id = ?", categoryID).Count(&childCount)
+	db.GetContext(request).Model(&models.KnowledgeBaseCategory{}).Where("parent_id = ?", categoryID).Count(&childCount)
 	if childCount > 0 {
 		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Cannot delete category with child categories", 400))
 	}
 
-	if err := db.Delete(&category).Error; err != nil {
+	if err := db.GetContext(request).Delete(&category).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
@@ -613,7 +625,7 @@ func (c Controller) DeleteKBCategory(request *evo.Request) any {
 // ListKBTags returns all knowledge base tags
 func (c Controller) ListKBTags(request *evo.Request) any {
 	var tags []models.KnowledgeBaseTag
-	query := db.Model(&models.KnowledgeBaseTag{}).Order("name ASC")
+	query := db.GetContext(request).Model(&models.KnowledgeBaseTag{}).Order("name ASC")
 
 	if err := query.Find(&tags).Error; err != nil {
 		return response.Error(response.ErrInternalError)
@@ -625,7 +637,7 @@ func (c Controller) ListKBTags(request *evo.Request) any {
 		Count int
 	}
 	var counts []TagCount
-	db.Model(&models.KnowledgeBaseArticleTag{}).
+	db.GetContext(request).Model(&models.KnowledgeBaseArticleTag{}).
 		Select("tag_id, COUNT(*) as count").
 		Group("tag_id").
 		Scan(&counts)
@@ -661,7 +673,7 @@ func (c Controller) GetKBTag(request *evo.Request) any {
 	}
 
 	var tag models.KnowledgeBaseTag
-	if err := db.Where("id = ?", tagID).First(&tag).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", tagID).First(&tag).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
 		}
@@ -685,7 +697,7 @@ func (c Controller) CreateKBTag(request *evo.Request) any {
 	// Generate slug
 	slug := generateSlug(req.Name)
 	var count int64
-	db.Model(&models.KnowledgeBaseTag{}).Where("slug = ?", slug).Count(&count)
+	db.GetContext(request).Model(&models.KnowledgeBaseTag{}).Where("slug = ?", slug).Count(&count)
 	if count > 0 {
 		slug = slug + "-" + time.Now().Format("20060102150405")
 	}
@@ -696,7 +708,7 @@ func (c Controller) CreateKBTag(request *evo.Request) any {
 		Color: req.Color,
 	}
 
-	if err := db.Create(&tag).Error; err != nil {
+	if err := db.GetContext(request).Create(&tag).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
@@ -712,7 +724,7 @@ func (c Controller) UpdateKBTag(request *evo.Request) any {
 	}
 
 	var tag models.KnowledgeBaseTag
-	if err := db.Where("id = ?", tagID).First(&tag).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", tagID).First(&tag).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
 		}
@@ -734,7 +746,7 @@ func (c Controller) UpdateKBTag(request *evo.Request) any {
 		newSlug := generateSlug(req.Name)
 		if newSlug != tag.Slug {
 			var count int64
-			db.Model(&models.KnowledgeBaseTag{}).Where("slug = ? AND id != ?", newSlug, tag.ID).Count(&count)
+			db.GetContext(request).Model(&models.KnowledgeBaseTag{}).Where("slug = ? AND id != ?", newSlug, tag.ID).Count(&count)
 			if count > 0 {
 				newSlug = newSlug + "-" + time.Now().Format("20060102150405")
 			}
@@ -744,7 +756,7 @@ func (c Controller) UpdateKBTag(request *evo.Request) any {
 
 	tag.Color = req.Color
 
-	if err := db.Save(&tag).Error; err != nil {
+	if err := db.GetContext(request).Save(&tag).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
@@ -760,7 +772,7 @@ func (c Controller) DeleteKBTag(request *evo.Request) any {
 	}
 
 	var tag models.KnowledgeBaseTag
-	if err := db.Where("id = ?", tagID).First(&tag).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", tagID).First(&tag).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.Error(response.ErrNotFound)
 		}
@@ -768,10 +780,10 @@ func (c Controller) DeleteKBTag(request *evo.Request) any {
 	}
 
 	// Delete article-tag associations
-	db.Where("tag_id = ?", tagID).Delete(&models.KnowledgeBaseArticleTag{})
+	db.GetContext(request).Where("tag_id = ?", tagID).Delete(&models.KnowledgeBaseArticleTag{})
 
 	// Delete tag
-	if err := db.Delete(&tag).Error; err != nil {
+	if err := db.GetContext(request).Delete(&tag).Error; err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
@@ -1,11 +1,16 @@
 package admin
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"strings"
+	"time"
 
 	"github.com/getevo/evo/v2"
 	"github.com/getevo/evo/v2/lib/db"
 	"github.com/getevo/pagination"
+	"github.com/google/uuid"
 	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/lib/response"
 	"gorm.io/gorm"
@@ -19,7 +24,7 @@ import (
 func (c Controller) ListWebhooks(request *evo.Request) any {
 	var webhooks []models.Webhook
 
-	err := db.Order("id DESC").Find(&webhooks).Error
+	err := db.GetContext(request).Order("id DESC").Find(&webhooks).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -32,7 +37,7 @@ func (c Controller) GetWebhook(request *evo.Request) any {
 	id := request.Param("id").String()
 	var webhook models.Webhook
 
-	err := db.First(&webhook, id).Error
+	err := db.GetContext(request).First(&webhook, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "Webhook not found")
@@ -60,7 +65,7 @@ func (c Controller) CreateWebhook(request *evo.Request) any {
 	}
 
 	// Create the webhook
-	err := db.Create(&webhook).Error
+	err := db.GetContext(request).Create(&webhook).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -73,7 +78,7 @@ func (c Controller) UpdateWebhook(request *evo.Request) any {
 	id := request.Param("id").String()
 
 	var webhook models.Webhook
-	err := db.First(&webhook, id).Error
+	err := db.GetContext(request).First(&webhook, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "Webhook not found")
@@ -86,15 +91,19 @@ func (c Controller) UpdateWebhook(request *evo.Request) any {
 	if err := request.BodyParser(&updateData); err != nil {
 		return response.BadRequest(request, "Invalid request body")
 	}
+	// Secrets are only changed by RotateWebhookSecret, which encrypts them
+	for _, key := range []string{"secret", "previous_secret", "previous_secret_expires_at"} {
+		delete(updateData, key)
+	}
 
 	// Update the webhook
-	err = db.Model(&webhook).Updates(updateData).Error
+	err = db.GetContext(request).Model(&webhook).Updates(updateData).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
 
 	// Reload the webhook
-	db.First(&webhook, id)
+	db.GetContext(request).First(&webhook, id)
 
 	return response.OK(webhook)
 }
@@ -104,7 +113,7 @@ func (c Controller) DeleteWebhook(request *evo.Request) any {
 	id := request.Param("id").String()
 
 	var webhook models.Webhook
-	err := db.First(&webhook, id).Error
+	err := db.GetContext(request).First(&webhook, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "Webhook not found")
@@ -113,10 +122,11 @@ func (c Controller) DeleteWebhook(request *evo.Request) any {
 	}
 
 	// Delete associated deliveries first
-	db.Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{})
+	db.GetContext(request).Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{})
+	db.GetContext(request).Where("webhook_id = ?", id).Delete(&models.WebhookQueueItem{})
 
 	// Delete the webhook
-	err = db.Delete(&webhook).Error
+	err = db.GetContext(request).Delete(&webhook).Error
 	if err != nil {
 		return response.Error(response.ErrInternalError)
 	}
@@ -124,12 +134,61 @@ func (c Controller) DeleteWebhook(request *evo.Request) any {
 	return response.OK(map[string]string{"message": "Webhook deleted successfully"})
 }
 
+// GetWebhookSecret returns the signing secret of a webhook, to configure the receiver
+func (c Controller) GetWebhookSecret(request *evo.Request) any {
+	id := request.Param("id").String()
+
+	var webhook models.Webhook
+	err := db.GetContext(request).First(&webhook, id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return response.NotFound(request, "Webhook not found")
+		}
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OK(map[string]any{
+		"secret":                     webhook.Secret,
+		"previous_secret_expires_at": webhook.PreviousSecretExpiresAt,
+	})
+}
+
+// RotateWebhookSecret replaces the signing secret of a webhook. With grace_period (hours) deliveries
+// are signed with both secrets for that long, so the receiver can be updated without dropping any.
+func (c Controller) RotateWebhookSecret(request *evo.Request) any {
+	id := request.Param("id").String()
+
+	var webhook models.Webhook
+	err := db.GetContext(request).First(&webhook, id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return response.NotFound(request, "Webhook not found")
+		}
+		return response.Error(response.ErrInternalError)
+	}
+
+	gracePeriod := time.Duration(request.Query("grace_period").Int()) * time.Hour
+	if gracePeriod < 0 || gracePeriod > models.MaxWebhookSecretGracePeriod {
+		return response.BadRequest(request, "Grace period must be between 0 and 168 hours")
+	}
+	webhook.RotateSecret(gracePeriod)
+
+	if err := db.GetContext(request).Save(&webhook).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OK(map[string]any{
+		"secret":                     webhook.Secret,
+		"previous_secret_expires_at": webhook.PreviousSecretExpiresAt,
+	})
+}
+
 // TestWebhook sends a test webhook
 func (c Controller) TestWebhook(request *evo.Request) any {
 	id := request.Param("id").String()
 
 	var webhook models.Webhook
-	err := db.First(&webhook, id).Error
+	err := db.GetContext(request).First(&webhook, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "Webhook not found")
@@ -252,6 +311,18 @@ func buildTestDataForEvent(eventType string) map[string]any {
 				"updated_at": "2024-01-15T10:30:00Z",
 			},
 		}
+	case models.WebhookEventUserLocked:
+		return map[string]any{
+			"user": map[string]any{
+				"id":           "550e8400-e29b-41d4-a716-446655440001",
+				"display_name": "Test Agent",
+				"email":        "agent@example.com",
+				"type":         "agent",
+				"status":       "active",
+			},
+			"locked_until":    "2024-01-15T10:35:00Z",
+			"failed_attempts": 5,
+		}
 	case models.WebhookEventUserCreated,
 		models.WebhookEventUserUpdated:
 		return map[string]any{
@@ -280,7 +351,7 @@ func buildTestDataForEvent(eventType string) map[string]any {
 func (c Controller) ListWebhookDeliveries(request *evo.Request) any {
 	var deliveries []models.WebhookDelivery
 
-	query := db.Model(&models.WebhookDelivery{})
+	query := db.GetContext(request).Model(&models.WebhookDelivery{})
 
 	// Filter by webhook_id if provided
 	if webhookID := request.Query("webhook_id").String(); webhookID != "" {
@@ -323,7 +394,7 @@ func (c Controller) GetWebhookDelivery(request *evo.Request) any {
 	id := request.Param("id").String()
 	var delivery models.WebhookDelivery
 
-	err := db.Preload("Webhook").First(&delivery, id).Error
+	err := db.GetContext(request).Preload("Webhook").First(&delivery, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return response.NotFound(request, "Webhook delivery not found")
@@ -333,3 +404,107 @@ func (c Controller) GetWebhookDelivery(request *evo.Request) any {
 
 	return response.OK(delivery)
 }
+
+// ReplayWebhookDelivery queues a logged delivery again with the same delivery ID
+func (c Controller) ReplayWebhookDelivery(request *evo.Request) any {
+	id := request.Param("id").String()
+	var delivery models.WebhookDelivery
+
+	err := db.GetContext(request).First(&delivery, id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return response.NotFound(request, "Webhook delivery not found")
+		}
+		return response.Error(response.ErrInternalError)
+	}
+
+	var item models.WebhookQueueItem
+	if delivery.DeliveryID != "" {
+		if err := db.GetContext(request).Where("delivery_id = ?", delivery.DeliveryID).Limit(1).Find(&item).Error; err != nil {
+			return response.Error(response.ErrInternalError)
+		}
+	}
+	if item.ID != 0 {
+		return replayQueueItem(request, &item)
+	}
+
+	// The queue item was pruned or the delivery predates the queue: queue the logged body again
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, []byte(delivery.RequestBody)); err != nil {
+		return response.BadRequest(request, "Delivery has no replayable request body")
+	}
+	item = models.WebhookQueueItem{
+		WorkspaceID:   delivery.WorkspaceID,
+		WebhookID:     delivery.WebhookID,
+		DeliveryID:    delivery.DeliveryID,
+		Event:         delivery.Event,
+		Payload:       payload.String(),
+		Status:        models.WebhookQueueStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	if item.DeliveryID == "" {
+		item.DeliveryID = uuid.NewString()
+	}
+	if err := db.GetContext(request).Create(&item).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	return response.OK(item)
+}
+
+// ListWebhookQueue returns queued webhook deliveries, e.g. the dead letters with ?status=dead
+func (c Controller) ListWebhookQueue(request *evo.Request) any {
+	var items []models.WebhookQueueItem
+
+	query := db.GetContext(request).Model(&models.WebhookQueueItem{})
+
+	if webhookID := request.Query("webhook_id").String(); webhookID != "" {
+		query = query.Where("webhook_id = ?", webhookID)
+	}
+	if status := request.Query("status").String(); status != "" {
+		query = query.Where("status IN (?)", strings.Split(status, ","))
+	}
+	if event := request.Query("event").String(); event != "" {
+		query = query.Where("event IN (?)", strings.Split(event, ","))
+	}
+
+	query = query.Order("id DESC")
+
+	p, err := pagination.New(query, request, &items, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMeta(items, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// ReplayWebhookQueueItem queues a delivered or dead-lettered item again
+func (c Controller) ReplayWebhookQueueItem(request *evo.Request) any {
+	id := request.Param("id").String()
+	var item models.WebhookQueueItem
+
+	err := db.GetContext(request).First(&item, id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return response.NotFound(request, "Queued webhook delivery not found")
+		}
+		return response.Error(response.ErrInternalError)
+	}
+
+	return replayQueueItem(request, &item)
+}
+
+// replayQueueItem resets a queue item to pending with a fresh attempt budget
+func replayQueueItem(request *evo.Request, item *models.WebhookQueueItem) any {
+	if err := item.Replay(db.GetContext(request)); err != nil {
+		if errors.Is(err, models.ErrWebhookQueueItemSending) {
+			return response.Conflict(request, err.Error())
+		}
+		return response.Error(response.ErrInternalError)
+	}
+	return response.OK(item)
+}
@@ -0,0 +1,168 @@
+package admin
+
+import (
+	"strings"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/pagination"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+	"github.com/iesreza/homa-backend/lib/tenant"
+)
+
+// ===============================
+// WORKSPACE APIs
+// ===============================
+
+// WorkspaceAdministrator is the first administrator of a new workspace. They choose a password from the invitation.
+type WorkspaceAdministrator struct {
+	Name     string `json:"name"`
+	LastName string `json:"last_name"`
+	Email    string `json:"email"`
+}
+
+// CreateWorkspaceRequest creates a workspace
+type CreateWorkspaceRequest struct {
+	Name          string                  `json:"name"`
+	Slug          string                  `json:"slug"`
+	Administrator *WorkspaceAdministrator `json:"administrator"`
+}
+
+// UpdateWorkspaceRequest updates a workspace. The slug cannot be changed.
+type UpdateWorkspaceRequest struct {
+	Name   *string `json:"name"`
+	Status *string `json:"status"`
+}
+
+// ListWorkspaces returns the workspaces
+func (c Controller) ListWorkspaces(request *evo.Request) any {
+	var workspaces []models.Workspace
+
+	query := db.Model(&models.Workspace{})
+	if status := request.Query("status").String(); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if search := request.Query("search").String(); search != "" {
+		query = query.Where("name LIKE ? OR slug LIKE ?", "%"+search+"%", "%"+search+"%")
+	}
+
+	query = query.Order("id ASC")
+
+	p, err := pagination.New(query, request, &workspaces, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMeta(workspaces, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// CreateWorkspace creates a workspace and optionally invites its first administrator
+func (c Controller) CreateWorkspace(request *evo.Request) any {
+	var user = request.User().(*auth.User)
+
+	var req CreateWorkspaceRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.BadRequest(request, "Invalid request body")
+	}
+
+	workspace := models.Workspace{
+		Name:   strings.TrimSpace(req.Name),
+		Slug:   req.Slug,
+		Status: models.WorkspaceStatusActive,
+	}
+	if err := workspace.Validate(); err != nil {
+		return response.BadRequest(request, err.Error())
+	}
+
+	var count int64
+	db.Model(&models.Workspace{}).Where("slug = ?", workspace.Slug).Count(&count)
+	if count > 0 {
+		return response.BadRequest(request, "A workspace with this slug already exists")
+	}
+
+	var admin *auth.User
+	if req.Administrator != nil {
+		a := req.Administrator
+		a.Email = strings.ToLower(strings.TrimSpace(a.Email))
+		if a.Name == "" || a.LastName == "" || a.Email == "" {
+			return response.BadRequest(request, "The administrator needs a name, last name and email")
+		}
+		// Emails are unique across workspaces, users log in without choosing one
+		db.Model(&auth.User{}).Where("email = ?", a.Email).Count(&count)
+		if count > 0 {
+			return response.Error(response.NewError(response.ErrorCodeConflict, "A user with this email already exists", 409))
+		}
+	}
+
+	if err := db.Create(&workspace).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	if a := req.Administrator; a != nil {
+		admin = &auth.User{
+			Name:        a.Name,
+			LastName:    a.LastName,
+			DisplayName: a.Name + " " + a.LastName,
+			Email:       a.Email,
+			Type:        auth.UserTypeAdministrator,
+			Status:      auth.UserStatusActive,
+			Language:    "en",
+		}
+		if err := models.WorkspaceDB(workspace.ID).Create(admin).Error; err != nil {
+			return response.Error(response.ErrInternalError)
+		}
+		// The workspace is kept when the email fails; the invitation can be sent again from /invite
+		if _, errResponse := auth.SendInvitation(request, admin, user); errResponse != nil {
+			admin.PasswordHash = nil
+			return response.OKWithMessage(map[string]any{
+				"workspace":     workspace,
+				"administrator": admin,
+			}, "Workspace created, but the invitation email could not be sent")
+		}
+		admin.PasswordHash = nil
+	}
+
+	return response.Created(map[string]any{
+		"workspace":     workspace,
+		"administrator": admin,
+	})
+}
+
+// UpdateWorkspace renames, suspends or reactivates a workspace. The default workspace cannot be suspended.
+func (c Controller) UpdateWorkspace(request *evo.Request) any {
+	workspace, err := models.GetWorkspace(request.Param("id").Uint())
+	if err != nil {
+		return response.NotFound(request, "Workspace not found")
+	}
+
+	var req UpdateWorkspaceRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.BadRequest(request, "Invalid request body")
+	}
+
+	if req.Name != nil {
+		workspace.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Status != nil {
+		workspace.Status = *req.Status
+	}
+	if err := workspace.Validate(); err != nil {
+		return response.BadRequest(request, err.Error())
+	}
+	if workspace.ID == tenant.DefaultID && workspace.Status != models.WorkspaceStatusActive {
+		return response.BadRequest(request, "The default workspace cannot be suspended")
+	}
+
+	if err := db.Model(workspace).Updates(map[string]any{"name": workspace.Name, "status": workspace.Status}).Error; err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OK(workspace)
+}
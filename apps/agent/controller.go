@@ -71,7 +71,7 @@ func (c *Controller) GetUnreadTickets(request *evo.Request) interface{} {
 	}
 
 	var tickets []models.Conversation
-	query := db.Where("status IN (?, ?, ?)", models.ConversationStatusNew, models.ConversationStatusWaitForAgent, models.ConversationStatusInProgress)
+	query := db.GetContext(request).Where("status IN (?, ?, ?)", models.ConversationStatusNew, models.ConversationStatusWaitForAgent, models.ConversationStatusInProgress)
 
 	// For agents, show tickets assigned to them or their departments
 	query = query.Where(
@@ -114,7 +114,7 @@ func (c *Controller) GetUnreadTicketsCount(request *evo.Request) interface{} {
 	}
 
 	var count int64
-	query := db.Model(&models.Conversation{}).Where("status IN (?, ?, ?)", models.ConversationStatusNew, models.ConversationStatusWaitForAgent, models.ConversationStatusInProgress)
+	query := db.GetContext(request).Model(&models.Conversation{}).Where("status IN (?, ?, ?)", models.ConversationStatusNew, models.ConversationStatusWaitForAgent, models.ConversationStatusInProgress)
 
 	// For agents, count tickets assigned to them or their departments
 	query = query.Where(
@@ -167,7 +167,7 @@ func (c *Controller) GetTicketList(request *evo.Request) interface{} {
 	var err error
 
 	// Build base query with proper joins for search
-	query := db.Model(&models.Conversation{}).
+	query := db.GetContext(request).Model(&models.Conversation{}).
 		Select("conversations.*, clients.name as client_name").
 		Joins("LEFT JOIN clients ON conversations.client_id = clients.id").
 		Joins("LEFT JOIN client_external_ids ON clients.id = client_external_ids.client_id AND client_external_ids.type = 'email'").
@@ -295,7 +295,7 @@ func (c *Controller) ChangeTicketStatus(request *evo.Request) interface{} {
 
 	// Check if user has access to this ticket
 	var ticket models.Conversation
-	query := db.Where("id = ?", ticketID)
+	query := db.GetContext(request).Where("id = ?", ticketID)
 
 	if user.Type == auth.UserTypeAgent {
 		departmentIDs, err := c.GetUserDepartmentIDs(user.UserID)
@@ -315,7 +315,7 @@ func (c *Controller) ChangeTicketStatus(request *evo.Request) interface{} {
 	}
 
 	// Update ticket status
-	err = db.Model(&ticket).Update("status", requestData.Status).Error
+	err = db.GetContext(request).Model(&ticket).Update("status", requestData.Status).Error
 	if err != nil {
 		return response.Error(response.ErrUpdateConversationStatus())
 	}
@@ -352,7 +352,7 @@ func (c *Controller) ReplyToTicket(request *evo.Request) interface{} {
 
 	// Check if user has access to this ticket
 	var ticket models.Conversation
-	query := db.Where("id = ?", ticketID)
+	query := db.GetContext(request).Where("id = ?", ticketID)
 
 	if user.Type == auth.UserTypeAgent {
 		departmentIDs, err := c.GetUserDepartmentIDs(user.UserID)
@@ -379,7 +379,7 @@ func (c *Controller) ReplyToTicket(request *evo.Request) interface{} {
 		CreatedAt: time.Now(),
 	}
 
-	err = db.Create(&message).Error
+	err = db.GetContext(request).Create(&message).Error
 	if err != nil {
 		return response.Error(response.ErrCreateMessage())
 	}
@@ -421,7 +421,7 @@ func (c *Controller) AssignTicket(request *evo.Request) interface{} {
 
 	// Check if user has access to this ticket
 	var ticket models.Conversation
-	query := db.Where("id = ?", ticketID)
+	query := db.GetContext(request).Where("id = ?", ticketID)
 
 	if user.Type == auth.UserTypeAgent {
 		departmentIDs, err := c.GetUserDepartmentIDs(user.UserID)
@@ -441,7 +441,7 @@ func (c *Controller) AssignTicket(request *evo.Request) interface{} {
 	}
 
 	// Remove existing assignments
-	db.Where("conversation_id = ?", ticketID).Delete(&models.ConversationAssignment{})
+	db.GetContext(request).Where("conversation_id = ?", ticketID).Delete(&models.ConversationAssignment{})
 
 	// Create new assignment
 	assignment := models.ConversationAssignment{
@@ -457,7 +457,7 @@ func (c *Controller) AssignTicket(request *evo.Request) interface{} {
 		assignment.UserID = &userUUID
 	}
 
-	err = db.Create(&assignment).Error
+	err = db.GetContext(request).Create(&assignment).Error
 	if err != nil {
 		return response.Error(response.ErrAssignConversation())
 	}
@@ -494,7 +494,7 @@ func (c *Controller) ChangeTicketDepartment(request *evo.Request) interface{} {
 
 	// Check if user has access to this ticket
 	var ticket models.Conversation
-	query := db.Where("id = ?", ticketID)
+	query := db.GetContext(request).Where("id = ?", ticketID)
 
 	if user.Type == auth.UserTypeAgent {
 		departmentIDs, err := c.GetUserDepartmentIDs(user.UserID)
@@ -514,7 +514,7 @@ func (c *Controller) ChangeTicketDepartment(request *evo.Request) interface{} {
 	}
 
 	// Update ticket department
-	err = db.Model(&ticket).Update("department_id", requestData.DepartmentID).Error
+	err = db.GetContext(request).Model(&ticket).Update("department_id", requestData.DepartmentID).Error
 	if err != nil {
 		return response.Error(response.ErrUpdateConversationDepartment())
 	}
@@ -557,7 +557,7 @@ func (c *Controller) TagTicket(request *evo.Request) interface{} {
 
 	// Check if user has access to this ticket
 	var ticket models.Conversation
-	query := db.Where("id = ?", ticketID)
+	query := db.GetContext(request).Where("id = ?", ticketID)
 
 	if user.Type == auth.UserTypeAgent {
 		departmentIDs, err := c.GetUserDepartmentIDs(user.UserID)
@@ -577,7 +577,7 @@ func (c *Controller) TagTicket(request *evo.Request) interface{} {
 	}
 
 	// Remove existing tags
-	db.Where("conversation_id = ?", ticketID).Delete(&models.ConversationTag{})
+	db.GetContext(request).Where("conversation_id = ?", ticketID).Delete(&models.ConversationTag{})
 
 	// Collect all tag IDs to be assigned
 	var allTagIDs []uint
@@ -591,13 +591,13 @@ func (c *Controller) TagTicket(request *evo.Request) interface{} {
 		var tag models.Tag
 
 		// Try to find existing tag by name
-		err := db.Where("name = ?", tagName).First(&tag).Error
+		err := db.GetContext(request).Where("name = ?", tagName).First(&tag).Error
 		if err != nil {
 			// Tag doesn't exist, create it
 			tag = models.Tag{
 				Name: tagName,
 			}
-			err = db.Create(&tag).Error
+			err = db.GetContext(request).Create(&tag).Error
 			if err != nil {
 				return response.Error(response.ErrCreateTagWithName(tagName))
 			}
@@ -613,7 +613,7 @@ func (c *Controller) TagTicket(request *evo.Request) interface{} {
 			ConversationID: uint(ticketID),
 			TagID:          tagID,
 		}
-		db.Create(&conversationTag)
+		db.GetContext(request).Create(&conversationTag)
 	}
 
 	responseData := map[string]interface{}{
@@ -655,7 +655,7 @@ func (c *Controller) AddTag(request *evo.Request) interface{} {
 		Name: requestData.Name,
 	}
 
-	err := db.Create(&tag).Error
+	err := db.GetContext(request).Create(&tag).Error
 	if err != nil {
 		return response.Error(response.ErrCreateTag())
 	}
@@ -29,6 +29,7 @@ func ProcessIncomingMessage(message *models.Message) error {
 		Preload("Department.AIAgent").
 		Preload("Department.AIAgent.Bot").
 		Preload("Client").
+		Preload("Client.Organization").
 		First(&conversation, message.ConversationID).Error
 	if err != nil {
 		return fmt.Errorf("failed to load conversation: %w", err)
@@ -52,6 +53,10 @@ func ProcessIncomingMessage(message *models.Message) error {
 	}
 
 	// 4.5. Check if bot handling is enabled for this conversation
+	if conversation.Status == models.ConversationStatusSpam {
+		log.Debug("Conversation %d is marked as spam, skipping", conversation.ID)
+		return nil
+	}
 	if !conversation.HandleByBot {
 		log.Debug("Bot handling disabled for conversation %d, skipping", conversation.ID)
 		return nil
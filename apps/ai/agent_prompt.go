@@ -12,16 +12,16 @@ import (
 // Uses the Jet template system with customizable template and separate tool documentation
 func GenerateSystemPrompt(agent *models.AIAgent, tools []models.AIAgentTool, client *models.Client, conversation *models.Conversation) string {
 	// Get project name from settings
-	projectName := models.GetSettingValue("general.project_name", "")
+	projectName := models.GetWorkspaceSettingValue(agent.WorkspaceID, "general.project_name", "")
 	if projectName == "" {
-		projectName = models.GetSettingValue("general.company_name", "the company")
+		projectName = models.GetWorkspaceSettingValue(agent.WorkspaceID, "general.company_name", "the company")
 	}
 
 	// Build template data for Jet template
 	templateData := BuildTemplateData(agent, projectName)
 
 	// Get the custom template from settings, or use default
-	customTemplate := models.GetSettingValue(SettingKeyBotPromptTemplate, "")
+	customTemplate := models.GetWorkspaceSettingValue(agent.WorkspaceID, SettingKeyBotPromptTemplate, "")
 	templateContent := customTemplate
 	if templateContent == "" {
 		templateContent = GetDefaultBotPromptTemplate()
@@ -84,6 +84,21 @@ func generateCustomerContext(client *models.Client, conversation *models.Convers
 		}
 	}
 
+	// Organization the customer belongs to
+	if client.Organization != nil {
+		parts = append(parts, fmt.Sprintf("- Organization: %s", client.Organization.Name))
+		if client.Organization.Website != nil && *client.Organization.Website != "" {
+			parts = append(parts, fmt.Sprintf("- Organization website: %s", *client.Organization.Website))
+		}
+		var orgData map[string]interface{}
+		if err := json.Unmarshal(client.Organization.Data, &orgData); err == nil && len(orgData) > 0 {
+			parts = append(parts, "- Organization information:")
+			for k, v := range orgData {
+				parts = append(parts, fmt.Sprintf("  - %s: %v", k, v))
+			}
+		}
+	}
+
 	// Conversation context
 	if conversation != nil {
 		if conversation.Priority != "" && conversation.Priority != models.ConversationPriorityMedium {
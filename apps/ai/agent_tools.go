@@ -20,7 +20,7 @@ import (
 
 // SearchKnowledgeBase is a function variable to avoid circular imports with rag package
 // It should be set by the rag package during initialization
-var SearchKnowledgeBase func(query string, limit int) (string, error)
+var SearchKnowledgeBase func(workspaceID uint, query string, limit int) (string, error)
 
 // AgentContext holds all context needed for tool execution
 type AgentContext struct {
@@ -445,7 +445,7 @@ func handleSearchKnowledgeBase(ctx *AgentContext, args json.RawMessage) (string,
 	}
 
 	// Use RAG search via function variable
-	context, err := SearchKnowledgeBase(params.Query, 5)
+	context, err := SearchKnowledgeBase(ctx.Conversation.WorkspaceID, params.Query, 5)
 	if err != nil {
 		log.Warning("Knowledge base search failed: %v", err)
 		return "No relevant information found in the knowledge base.", false, nil
@@ -888,9 +888,21 @@ func resolveVariable(variable string, ctx *AgentContext) string {
 		return ""
 	case "channel_id":
 		return ctx.Conversation.ChannelID
-	default:
-		return variable
 	}
+
+	// Custom attribute values: conversation.<name> reads the conversation's custom fields,
+	// client.<name> reads the client's data
+	if name, ok := strings.CutPrefix(variable, "conversation."); ok {
+		return models.CustomAttributeValueString(ctx.Conversation.CustomFields, name)
+	}
+	if name, ok := strings.CutPrefix(variable, "client."); ok {
+		if ctx.Client != nil {
+			return models.CustomAttributeValueString(ctx.Client.Data, name)
+		}
+		return ""
+	}
+
+	return variable
 }
 
 // convertToType converts a string value to the specified data type
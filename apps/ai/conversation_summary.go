@@ -32,7 +32,7 @@ func (c Controller) GetConversationSummaryHandler(req *evo.Request) interface{}
 	}
 
 	// Check if conversation summary feature is enabled
-	enabled := models.GetSettingValue("ai.conversation_summary_enabled", "false")
+	enabled := models.GetWorkspaceSettingValue(user.WorkspaceID, "ai.conversation_summary_enabled", "false")
 	if enabled != "true" {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "Conversation summary feature is disabled", 403, ""))
 	}
@@ -61,13 +61,13 @@ func (c Controller) GetConversationSummaryHandler(req *evo.Request) interface{}
 
 	// Get message count for this conversation
 	var messageCount int64
-	if err := db.Model(&models.Message{}).Where("conversation_id = ?", conversationID).Count(&messageCount).Error; err != nil {
+	if err := db.GetContext(req).Model(&models.Message{}).Where("conversation_id = ?", conversationID).Count(&messageCount).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to count messages", 500, err.Error()))
 	}
 
 	// Get existing summary for this language
 	var summary models.ConversationSummary
-	err := db.Where("conversation_id = ? AND language = ?", conversationID, language).First(&summary).Error
+	err := db.GetContext(req).Where("conversation_id = ? AND language = ?", conversationID, language).First(&summary).Error
 
 	if err != nil {
 		// No summary exists for this language
@@ -108,7 +108,7 @@ func (c Controller) GenerateConversationSummaryHandler(req *evo.Request) interfa
 	}
 
 	// Check if conversation summary feature is enabled
-	enabled := models.GetSettingValue("ai.conversation_summary_enabled", "false")
+	enabled := models.GetWorkspaceSettingValue(user.WorkspaceID, "ai.conversation_summary_enabled", "false")
 	if enabled != "true" {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "Conversation summary feature is disabled", 403, ""))
 	}
@@ -137,13 +137,13 @@ func (c Controller) GenerateConversationSummaryHandler(req *evo.Request) interfa
 
 	// Verify conversation exists
 	var conversation models.Conversation
-	if err := db.First(&conversation, conversationID).Error; err != nil {
+	if err := db.GetContext(req).First(&conversation, conversationID).Error; err != nil {
 		return response.NotFound(req, "Conversation not found")
 	}
 
 	// Get all messages for this conversation
 	var messages []models.Message
-	if err := db.Where("conversation_id = ?", conversationID).
+	if err := db.GetContext(req).Where("conversation_id = ?", conversationID).
 		Preload("User").
 		Preload("Client").
 		Order("created_at ASC").
@@ -190,7 +190,7 @@ func (c Controller) GenerateConversationSummaryHandler(req *evo.Request) interfa
 
 	// Save or update the summary for this language
 	var existingSummary models.ConversationSummary
-	err = db.Where("conversation_id = ? AND language = ?", conversationID, language).First(&existingSummary).Error
+	err = db.GetContext(req).Where("conversation_id = ? AND language = ?", conversationID, language).First(&existingSummary).Error
 
 	if err != nil {
 		// Create new summary
@@ -201,7 +201,7 @@ func (c Controller) GenerateConversationSummaryHandler(req *evo.Request) interfa
 			KeyPoints:      string(keyPointsJSON),
 			Version:        len(messages),
 		}
-		if err := db.Create(&newSummary).Error; err != nil {
+		if err := db.GetContext(req).Create(&newSummary).Error; err != nil {
 			return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to save summary", 500, err.Error()))
 		}
 	} else {
@@ -209,7 +209,7 @@ func (c Controller) GenerateConversationSummaryHandler(req *evo.Request) interfa
 		existingSummary.Summary = result.Summary
 		existingSummary.KeyPoints = string(keyPointsJSON)
 		existingSummary.Version = len(messages)
-		if err := db.Save(&existingSummary).Error; err != nil {
+		if err := db.GetContext(req).Save(&existingSummary).Error; err != nil {
 			return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to update summary", 500, err.Error()))
 		}
 	}
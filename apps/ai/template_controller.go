@@ -49,7 +49,7 @@ func PreviewTemplateHandler(r *evo.Request) any {
 
 	// Get the agent
 	var agent models.AIAgent
-	if err := db.Preload("Bot").First(&agent, req.AgentID).Error; err != nil {
+	if err := db.GetContext(r).Preload("Bot").First(&agent, req.AgentID).Error; err != nil {
 		return response.NotFound(nil, "AI Agent not found")
 	}
 
@@ -79,7 +79,7 @@ func PreviewTemplateHandler(r *evo.Request) any {
 
 	// Load agent tools
 	var tools []models.AIAgentTool
-	db.Where("ai_agent_id = ?", req.AgentID).Order("id ASC").Find(&tools)
+	db.GetContext(r).Where("ai_agent_id = ?", req.AgentID).Order("id ASC").Find(&tools)
 
 	// Generate tool documentation separately (not part of template)
 	toolDocs := GenerateToolDocumentation(&agent, tools)
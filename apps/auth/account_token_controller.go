@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/lib/clientip"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// ForgotPasswordRequest is the body for requesting a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// AccountTokenRequest is the body for accepting an invite or password reset link
+type AccountTokenRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// InviteUser emails an invitation link to a user who has not activated the account yet
+// @Summary Send invitation
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Router /admin/users/{id}/invite [post]
+// @Security Bearer
+func (c Controller) InviteUser(request *evo.Request) interface{} {
+	if request.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	actor := request.User().Interface().(*User)
+
+	id, err := uuid.Parse(request.Param("id").String())
+	if err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user ID format", 400))
+	}
+	var user User
+	if err := db.GetContext(request).Where("id = ?", id).First(&user).Error; err != nil {
+		return response.Error(response.ErrUserNotFound)
+	}
+	if user.Type == UserTypeBot || user.Status == UserStatusBlocked {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Only active agents and administrators can be invited", 400))
+	}
+	if user.EmailVerifiedAt != nil && user.PasswordHash != nil {
+		return response.Error(response.NewError(response.ErrorCodeConflict, "The user has already activated the account", 409))
+	}
+
+	record, errResponse := inviteUser(request, &user, actor)
+	if errResponse != nil {
+		return errResponse
+	}
+	return response.OKWithMessage(map[string]any{"expires_at": record.ExpiresAt}, "Invitation sent successfully")
+}
+
+// inviteUser issues an invite token for the user and emails it
+func inviteUser(request *evo.Request, user *User, actor *User) (*UserAccountToken, interface{}) {
+	token, record, err := IssueAccountToken(user, AccountTokenInvite, &actor.UserID, clientip.FromRequest(request))
+	if err != nil {
+		log.Error("Failed to issue invite token:", err)
+		return nil, response.Error(response.ErrDatabaseError)
+	}
+	if err := sendAccountEmail(user, AccountTokenInvite, token, record.ExpiresAt); err != nil {
+		log.Error("Failed to send invitation to %s: %v", user.Email, err)
+		return nil, response.Error(response.NewError("email_failed", "Failed to send the invitation email. Check the email integration.", 502))
+	}
+
+	now := time.Now()
+	db.Model(&User{}).Where("id = ?", user.UserID).UpdateColumn("invited_at", now)
+	user.InvitedAt = &now
+	auditAccount(request, AccountEventInviteSent, user.UserID, &actor.UserID, map[string]any{"token_id": record.ID, "expires_at": record.ExpiresAt})
+	return record, nil
+}
+
+// SendInvitation issues an invite token for the user and emails it, for users created outside this app
+func SendInvitation(request *evo.Request, user *User, actor *User) (*UserAccountToken, interface{}) {
+	return inviteUser(request, user, actor)
+}
+
+// ForgotPassword emails a password reset link. The response is the same whether or not the email is known.
+// @Summary Request password reset
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body ForgotPasswordRequest true "Email"
+// @Router /auth/password/forgot [post]
+func (c Controller) ForgotPassword(request *evo.Request) interface{} {
+	var req ForgotPasswordRequest
+	if err := request.BodyParser(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		return response.Error(response.NewError(response.ErrorCodeMissingRequired, "email is required", 400))
+	}
+	if lockout := IPLockout(clientip.FromRequest(request)); lockout != nil {
+		return lockoutResponse(lockout)
+	}
+	if recentAccountTokens(AccountTokenPasswordReset, nil, clientip.FromRequest(request)) >= settings.Get("AUTH.PASSWORD_RESET_IP_LIMIT", 10).Int64() {
+		return response.Error(response.NewError("too_many_requests", "Too many password reset requests. Please try again later.", 429))
+	}
+
+	sent := response.Message("If an account exists for this email, a password reset link has been sent")
+
+	var user User
+	if err := db.Where("email = ?", strings.TrimSpace(req.Email)).First(&user).Error; err != nil {
+		return sent
+	}
+	if user.Status == UserStatusBlocked || user.Type == UserTypeBot {
+		return sent
+	}
+	// Quietly drop requests over the limit so the response does not reveal the account
+	if recentAccountTokens(AccountTokenPasswordReset, &user.UserID, "") >= settings.Get("AUTH.PASSWORD_RESET_USER_LIMIT", 3).Int64() {
+		return sent
+	}
+
+	token, record, err := IssueAccountToken(&user, AccountTokenPasswordReset, nil, clientip.FromRequest(request))
+	if err != nil {
+		log.Error("Failed to issue password reset token:", err)
+		return sent
+	}
+	if err := sendAccountEmail(&user, AccountTokenPasswordReset, token, record.ExpiresAt); err != nil {
+		log.Error("Failed to send password reset email to %s: %v", user.Email, err)
+		return sent
+	}
+	auditAccount(request, AccountEventResetRequested, user.UserID, nil, map[string]any{"token_id": record.ID, "expires_at": record.ExpiresAt})
+	return sent
+}
+
+// ResetPassword sets a new password with a password reset link and ends every session of the user
+// @Summary Reset password
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body AccountTokenRequest true "Token and new password"
+// @Router /auth/password/reset [post]
+func (c Controller) ResetPassword(request *evo.Request) interface{} {
+	user, errResponse := acceptAccountToken(request, AccountTokenPasswordReset)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	if _, err := RevokeUserSessions(user.UserID, SessionRevokedPasswordReset, ""); err != nil {
+		log.Error("Failed to revoke sessions after password reset:", err)
+	}
+	// Proving access to the mailbox also ends an account lockout
+	ClearLockout(LockoutScopeUser, user.UserID.String(), nil)
+
+	return response.Message("Password has been reset. You can now log in.")
+}
+
+// AcceptInvite sets the password of an invited user, verifies the email and activates the account
+// @Summary Accept invitation
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body AccountTokenRequest true "Token and password"
+// @Router /auth/invite/accept [post]
+func (c Controller) AcceptInvite(request *evo.Request) interface{} {
+	if _, errResponse := acceptAccountToken(request, AccountTokenInvite); errResponse != nil {
+		return errResponse
+	}
+	return response.Message("Your account is active. You can now log in.")
+}
+
+// acceptAccountToken checks the token and password in the request, uses up the token and saves the password
+func acceptAccountToken(request *evo.Request, purpose string) (*User, interface{}) {
+	var req AccountTokenRequest
+	if err := request.BodyParser(&req); err != nil {
+		return nil, response.Error(response.ErrInvalidInput)
+	}
+	if req.Token == "" || req.Password == "" {
+		return nil, response.Error(response.NewError(response.ErrorCodeMissingRequired, "token and password are required", 400))
+	}
+	if lockout := IPLockout(clientip.FromRequest(request)); lockout != nil {
+		return nil, lockoutResponse(lockout)
+	}
+
+	user, record, err := ParseAccountToken(req.Token, purpose)
+	if err != nil {
+		rejectAccountToken(request, user, record, err)
+		if errors.Is(err, ErrAccountTokenUsed) {
+			return nil, response.Error(response.NewError(response.ErrorCodeInvalidInput, err.Error(), 400))
+		}
+		return nil, response.Error(response.NewError(response.ErrorCodeInvalidInput, ErrAccountTokenInvalid.Error(), 400))
+	}
+	if user.Status == UserStatusBlocked {
+		rejectAccountToken(request, user, record, errors.New("account blocked"))
+		return nil, response.Error(response.NewError(response.ErrorCodeForbidden, "Your account has been blocked. Please contact an administrator.", 403))
+	}
+
+	// The password is checked before the token is used up, so a rejected password can be retried
+	if err := user.SetPassword(req.Password); err != nil {
+		return nil, PasswordErrorResponse(err)
+	}
+	if err := record.Use(clientip.FromRequest(request)); err != nil {
+		rejectAccountToken(request, user, record, err)
+		return nil, response.Error(response.NewError(response.ErrorCodeInvalidInput, ErrAccountTokenUsed.Error(), 400))
+	}
+
+	updates := map[string]any{"password_hash": user.PasswordHash}
+	if user.EmailVerifiedAt == nil {
+		updates["email_verified_at"] = time.Now()
+	}
+	if err := db.GetContext(request).Model(user).Updates(updates).Error; err != nil {
+		log.Error("Failed to save password:", err)
+		return nil, response.Error(response.ErrDatabaseError)
+	}
+
+	event := AccountEventPasswordReset
+	if purpose == AccountTokenInvite {
+		event = AccountEventInviteAccepted
+	}
+	auditAccount(request, event, user.UserID, &user.UserID, map[string]any{"token_id": record.ID})
+	return user, nil
+}
+
+// rejectAccountToken records a token that was not accepted; unknown tokens count towards the IP lockout
+func rejectAccountToken(request *evo.Request, user *User, record *UserAccountToken, reason error) {
+	if user == nil {
+		user = &User{}
+	}
+	user.RecordLogin(request, false, LoginReasonInvalidAccountToken)
+	if user.Anonymous() || record == nil {
+		return
+	}
+	auditAccount(request, AccountEventAccountTokenDenied, user.UserID, nil, map[string]any{
+		"token_id": record.ID,
+		"purpose":  record.Purpose,
+		"reason":   reason.Error(),
+	})
+}
+
+// auditAccount writes an account event to the activity log
+func auditAccount(request *evo.Request, event string, userID uuid.UUID, actorID *uuid.UUID, metadata map[string]any) {
+	if AccountAuditLogger != nil {
+		AccountAuditLogger(event, userID, actorID, metadata, clientip.FromRequest(request), request.Header("User-Agent"))
+	}
+}
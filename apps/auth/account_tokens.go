@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/getevo/restify"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Account token purposes. They are also the Purpose claim, so the tokens are never accepted as sessions.
+const (
+	AccountTokenInvite        = "invite"
+	AccountTokenPasswordReset = "password_reset"
+)
+
+// Account audit events
+const (
+	AccountEventInviteSent         = "invite"
+	AccountEventInviteAccepted     = "accept_invite"
+	AccountEventResetRequested     = "request_password_reset"
+	AccountEventPasswordReset      = "reset_password"
+	AccountEventAccountTokenDenied = "reject_account_token"
+)
+
+// SessionRevokedPasswordReset ends the sessions of a user whose password was reset
+const SessionRevokedPasswordReset = "password_reset"
+
+// LoginReasonInvalidAccountToken is recorded for invite and reset tokens that are not accepted
+const LoginReasonInvalidAccountToken = "invalid_account_token"
+
+var (
+	ErrAccountTokenInvalid = errors.New("invalid or expired link")
+	ErrAccountTokenUsed    = errors.New("this link has already been used")
+	ErrEmailNotConfigured  = errors.New("no email sender is configured")
+)
+
+// UserAccountToken is the server-side record of an invite or password reset link.
+// Its ID is the jti claim of the signed token; a token is accepted once, before it expires.
+// Issuing a new token of the same purpose revokes the earlier ones.
+type UserAccountToken struct {
+	ID        string     `gorm:"column:id;type:char(36);primaryKey" json:"id"`
+	UserID    uuid.UUID  `gorm:"column:user_id;type:char(36);not null;index;fk:users" json:"user_id"`
+	Purpose   string     `gorm:"column:purpose;size:20;not null;index" json:"purpose"`
+	ExpiresAt time.Time  `gorm:"column:expires_at;not null" json:"expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at" json:"used_at"`
+	UsedIP    string     `gorm:"column:used_ip;size:45" json:"used_ip"`
+	RevokedAt *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+	CreatedBy *uuid.UUID `gorm:"column:created_by;type:char(36)" json:"created_by"`
+	CreatedIP string     `gorm:"column:created_ip;size:45;index" json:"created_ip"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime;index" json:"created_at"`
+
+	restify.API
+}
+
+func (UserAccountToken) TableName() string {
+	return "user_account_tokens"
+}
+
+// AccountEmailSender is set by the email integration to send invite and reset emails
+var AccountEmailSender func(to, subject, body, htmlBody string) error
+
+// AccountAuditLogger is set by the models package to write account events to the activity log
+var AccountAuditLogger func(event string, userID uuid.UUID, actorID *uuid.UUID, metadata map[string]any, ip, userAgent string)
+
+// accountTokenTTL returns the lifetime of a token of purpose
+func accountTokenTTL(purpose string) time.Duration {
+	if purpose == AccountTokenInvite {
+		ttl, _ := settings.Get("AUTH.INVITE_TTL", "72h").Duration()
+		return ttl
+	}
+	ttl, _ := settings.Get("AUTH.PASSWORD_RESET_TTL", "1h").Duration()
+	return ttl
+}
+
+// IssueAccountToken creates a signed, single-use token of purpose for the user and revokes the earlier ones
+func IssueAccountToken(user *User, purpose string, actorID *uuid.UUID, ip string) (string, *UserAccountToken, error) {
+	if len(JWTSecret) == 0 {
+		return "", nil, fmt.Errorf("JWT secret is not initialized")
+	}
+
+	record := UserAccountToken{
+		ID:        uuid.NewString(),
+		UserID:    user.UserID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(accountTokenTTL(purpose)),
+		CreatedBy: actorID,
+		CreatedIP: ip,
+	}
+	if err := db.Model(&UserAccountToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL AND revoked_at IS NULL", user.UserID, purpose).
+		UpdateColumn("revoked_at", time.Now()).Error; err != nil {
+		return "", nil, err
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", nil, err
+	}
+
+	claims := Claims{
+		UserID:  user.UserID.String(),
+		Email:   user.Email,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        record.ID,
+			ExpiresAt: jwt.NewNumericDate(record.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JWTSecret)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &record, nil
+}
+
+// ParseAccountToken returns the user and record of a valid, unused token of purpose without using it up
+func ParseAccountToken(tokenString, purpose string) (*User, *UserAccountToken, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return JWTSecret, nil
+	})
+	if err != nil || !token.Valid || claims.Purpose != purpose || claims.ID == "" {
+		return nil, nil, ErrAccountTokenInvalid
+	}
+
+	var record UserAccountToken
+	if err := db.Where("id = ? AND user_id = ? AND purpose = ?", claims.ID, claims.UserID, purpose).First(&record).Error; err != nil {
+		return nil, nil, ErrAccountTokenInvalid
+	}
+	var user User
+	if err := db.Where("id = ?", record.UserID).First(&user).Error; err != nil {
+		return nil, nil, ErrAccountTokenInvalid
+	}
+	// The token names the email it was sent to; it is void once the email changes
+	if !strings.EqualFold(user.Email, claims.Email) {
+		return &user, &record, ErrAccountTokenInvalid
+	}
+	if record.UsedAt != nil {
+		return &user, &record, ErrAccountTokenUsed
+	}
+	if record.RevokedAt != nil || !record.ExpiresAt.After(time.Now()) {
+		return &user, &record, ErrAccountTokenInvalid
+	}
+	return &user, &record, nil
+}
+
+// Use marks the token as used. Only the first of concurrent requests succeeds.
+func (t *UserAccountToken) Use(ip string) error {
+	now := time.Now()
+	result := db.Model(&UserAccountToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?", t.ID, now).
+		UpdateColumns(map[string]any{"used_at": now, "used_ip": ip})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccountTokenUsed
+	}
+	t.UsedAt = &now
+	t.UsedIP = ip
+	return nil
+}
+
+// recentAccountTokens counts the tokens of purpose issued for the user, or from ip when userID is nil, within the last hour
+func recentAccountTokens(purpose string, userID *uuid.UUID, ip string) int64 {
+	query := db.Model(&UserAccountToken{}).Where("purpose = ? AND created_at > ?", purpose, time.Now().Add(-time.Hour))
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	} else {
+		query = query.Where("created_ip = ?", ip)
+	}
+	var count int64
+	query.Count(&count)
+	return count
+}
+
+// accountLink returns the link of the web app page that accepts token
+func accountLink(page, token string) string {
+	base := settings.Get("AUTH.ACCOUNT_URL").String()
+	if base == "" {
+		base = settings.Get("APP.BASE_PATH").String()
+	}
+	return strings.TrimRight(base, "/") + "/" + page + "?token=" + url.QueryEscape(token)
+}
+
+// sendAccountEmail emails the token of purpose to the user
+func sendAccountEmail(user *User, purpose, token string, expiresAt time.Time) error {
+	if AccountEmailSender == nil {
+		return ErrEmailNotConfigured
+	}
+
+	var subject, intro, action, link string
+	if purpose == AccountTokenInvite {
+		subject = "You have been invited to Homa"
+		intro = "An account has been created for you. Choose a password to activate it."
+		action = "Accept invitation"
+		link = accountLink("accept-invite", token)
+	} else {
+		subject = "Reset your Homa password"
+		intro = "We received a request to reset your password. If you did not ask for this, you can ignore this email."
+		action = "Reset password"
+		link = accountLink("reset-password", token)
+	}
+	expiry := "This link can be used once and expires on " + expiresAt.UTC().Format("2006-01-02 15:04 MST") + "."
+
+	body := fmt.Sprintf("Hello %s,\n\n%s\n\n%s: %s\n\n%s\n", user.Name, intro, action, link, expiry)
+	htmlBody := fmt.Sprintf(`<p>Hello %s,</p><p>%s</p><p><a href="%s">%s</a></p><p style="font-size:12px;color:#888">%s</p>`,
+		html.EscapeString(user.Name), html.EscapeString(intro), html.EscapeString(link), html.EscapeString(action), html.EscapeString(expiry))
+
+	return AccountEmailSender(user.Email, subject, body, htmlBody)
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/lib/clientip"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// APIKeyRequest is the body for creating an API key
+type APIKeyRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	AllowedIPs []string   `json:"allowed_ips"`
+}
+
+// RotateAPIKeyRequest is the body for rotating an API key
+type RotateAPIKeyRequest struct {
+	GracePeriodHours *int `json:"grace_period_hours"` // how long the old key keeps working, 24 when omitted
+}
+
+// APIKeyResponse returns a new key. The plaintext key is only shown once.
+type APIKeyResponse struct {
+	Key    string      `json:"key"`
+	APIKey *UserAPIKey `json:"api_key"`
+}
+
+// ListAPIKeys returns the API keys of the current user
+// @Summary List API keys
+// @Tags Profile
+// @Produce json
+// @Success 200 {array} UserAPIKey
+// @Router /auth/api-keys [get]
+// @Security Bearer
+func (c Controller) ListAPIKeys(request *evo.Request) interface{} {
+	user, errResponse := apiKeyActor(request)
+	if errResponse != nil {
+		return errResponse
+	}
+	return listAPIKeys(user.UserID)
+}
+
+// CreateAPIKey creates an API key for the current user
+// @Summary Create API key
+// @Description Scopes are permission names such as conversations.view, conversations.reply or kb.edit
+// @Tags Profile
+// @Accept json
+// @Produce json
+// @Param body body APIKeyRequest true "API key"
+// @Success 201 {object} APIKeyResponse
+// @Router /auth/api-keys [post]
+// @Security Bearer
+func (c Controller) CreateAPIKey(request *evo.Request) interface{} {
+	user, errResponse := apiKeyActor(request)
+	if errResponse != nil {
+		return errResponse
+	}
+	return createAPIKey(request, user, user.UserID)
+}
+
+// RotateAPIKey replaces an API key of the current user
+// @Summary Rotate API key
+// @Description Creates a replacement key with the same settings; the old key keeps working for the grace period
+// @Tags Profile
+// @Accept json
+// @Produce json
+// @Param id path int true "API key ID"
+// @Param body body RotateAPIKeyRequest false "Grace period"
+// @Success 201 {object} APIKeyResponse
+// @Router /auth/api-keys/{id}/rotate [post]
+// @Security Bearer
+func (c Controller) RotateAPIKey(request *evo.Request) interface{} {
+	user, errResponse := apiKeyActor(request)
+	if errResponse != nil {
+		return errResponse
+	}
+	return rotateAPIKey(request, user, user.UserID, request.Param("id").Uint())
+}
+
+// DeleteAPIKey revokes an API key of the current user
+// @Summary Revoke API key by ID
+// @Tags Profile
+// @Produce json
+// @Param id path int true "API key ID"
+// @Router /auth/api-keys/{id} [delete]
+// @Security Bearer
+func (c Controller) DeleteAPIKey(request *evo.Request) interface{} {
+	user, errResponse := apiKeyActor(request)
+	if errResponse != nil {
+		return errResponse
+	}
+	return revokeAPIKey(request, user, user.UserID, request.Param("id").Uint())
+}
+
+// ListUserAPIKeys returns the API keys of a user, such as a bot
+func (c Controller) ListUserAPIKeys(request *evo.Request) interface{} {
+	_, owner, errResponse := apiKeyOwner(request)
+	if errResponse != nil {
+		return errResponse
+	}
+	return listAPIKeys(owner.UserID)
+}
+
+// CreateUserAPIKey creates an API key for a user, such as a bot
+func (c Controller) CreateUserAPIKey(request *evo.Request) interface{} {
+	user, owner, errResponse := apiKeyOwner(request)
+	if errResponse != nil {
+		return errResponse
+	}
+	return createAPIKey(request, user, owner.UserID)
+}
+
+// RotateUserAPIKey replaces an API key of a user
+func (c Controller) RotateUserAPIKey(request *evo.Request) interface{} {
+	user, owner, errResponse := apiKeyOwner(request)
+	if errResponse != nil {
+		return errResponse
+	}
+	return rotateAPIKey(request, user, owner.UserID, request.Param("key_id").Uint())
+}
+
+// DeleteUserAPIKey revokes an API key of a user
+func (c Controller) DeleteUserAPIKey(request *evo.Request) interface{} {
+	user, owner, errResponse := apiKeyOwner(request)
+	if errResponse != nil {
+		return errResponse
+	}
+	return revokeAPIKey(request, user, owner.UserID, request.Param("key_id").Uint())
+}
+
+// apiKeyActor returns the current user if it may manage API keys. Keys cannot be managed with a key.
+func apiKeyActor(request *evo.Request) (*User, interface{}) {
+	if request.User().Anonymous() {
+		return nil, response.Error(response.ErrUnauthorized)
+	}
+	user := request.User().Interface().(*User)
+	if user.APIKeyID() != 0 {
+		return nil, response.Error(response.NewError(response.ErrorCodeForbidden, "API keys cannot be managed with an API key", 403))
+	}
+	return user, nil
+}
+
+// apiKeyOwner returns the current user and the user in the :id parameter
+func apiKeyOwner(request *evo.Request) (*User, *User, interface{}) {
+	user, errResponse := apiKeyActor(request)
+	if errResponse != nil {
+		return nil, nil, errResponse
+	}
+	id, err := uuid.Parse(request.Param("id").String())
+	if err != nil {
+		return nil, nil, response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user ID format", 400))
+	}
+	var owner User
+	if err := db.GetContext(request).Where("id = ?", id).First(&owner).Error; err != nil {
+		return nil, nil, response.Error(response.NewError(response.ErrorCodeNotFound, "User not found", 404))
+	}
+	return user, &owner, nil
+}
+
+func listAPIKeys(ownerID uuid.UUID) interface{} {
+	var keys []UserAPIKey
+	if err := db.Where("user_id = ?", ownerID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	return response.List(keys, len(keys))
+}
+
+func createAPIKey(request *evo.Request, user *User, ownerID uuid.UUID) interface{} {
+	var req APIKeyRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid request body", 400))
+	}
+	if req.Name == "" {
+		return response.Error(response.NewError(response.ErrorCodeMissingRequired, "name is required", 400))
+	}
+	if len(req.Scopes) == 0 {
+		return response.Error(response.NewError(response.ErrorCodeMissingRequired, "At least one scope is required", 400))
+	}
+	// Nobody can hand out permissions they do not hold themselves
+	if err := validatePermissions(user, req.Scopes); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid scopes", 400, err.Error()))
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "expires_at must be in the future", 400))
+	}
+
+	key := UserAPIKey{
+		UserID:    ownerID,
+		Name:      req.Name,
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: &user.UserID,
+	}
+	key.SetScopes(req.Scopes)
+	if err := key.SetAllowedIPs(req.AllowedIPs); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid allowed_ips", 400, err.Error()))
+	}
+
+	secret, err := NewUserAPIKey(&key)
+	if err != nil {
+		log.Error("Failed to create API key:", err)
+		return response.Error(response.ErrDatabaseError)
+	}
+	auditAPIKey(request, APIKeyEventCreated, &key, user)
+
+	return response.Created(APIKeyResponse{Key: secret, APIKey: &key})
+}
+
+func rotateAPIKey(request *evo.Request, user *User, ownerID uuid.UUID, keyID uint) interface{} {
+	var req RotateAPIKeyRequest
+	if len(request.Body()) > 0 {
+		if err := request.BodyParser(&req); err != nil {
+			return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid request body", 400))
+		}
+	}
+	grace := 24 * time.Hour
+	if req.GracePeriodHours != nil {
+		grace = time.Duration(*req.GracePeriodHours) * time.Hour
+	}
+	if grace < 0 || grace > APIKeyMaxRotationGrace {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "grace_period_hours must be between 0 and 720", 400))
+	}
+
+	var key UserAPIKey
+	if err := db.GetContext(request).Where("id = ? AND user_id = ?", keyID, ownerID).First(&key).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "API key not found", 404))
+	}
+	if !key.Active() {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Only active API keys can be rotated", 400))
+	}
+	if err := validatePermissions(user, key.GetScopes()); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "Not allowed", 403, err.Error()))
+	}
+
+	replacement, secret, err := RotateUserAPIKey(&key, grace, user.UserID)
+	if err != nil {
+		log.Error("Failed to rotate API key:", err)
+		return response.Error(response.ErrDatabaseError)
+	}
+	auditAPIKey(request, APIKeyEventRotated, &key, user)
+	auditAPIKey(request, APIKeyEventCreated, replacement, user)
+
+	return response.Created(APIKeyResponse{Key: secret, APIKey: replacement})
+}
+
+func revokeAPIKey(request *evo.Request, user *User, ownerID uuid.UUID, keyID uint) interface{} {
+	var key UserAPIKey
+	if err := db.GetContext(request).Where("id = ? AND user_id = ?", keyID, ownerID).First(&key).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "API key not found", 404))
+	}
+	if key.RevokedAt == nil {
+		if err := key.Revoke(); err != nil {
+			return response.Error(response.ErrDatabaseError)
+		}
+		auditAPIKey(request, APIKeyEventRevoked, &key, user)
+	}
+	return response.OKWithMessage(key, "API key revoked successfully")
+}
+
+// auditAPIKey writes an API key event to the activity log
+func auditAPIKey(request *evo.Request, event string, key *UserAPIKey, actor *User) {
+	if APIKeyAuditLogger != nil {
+		APIKeyAuditLogger(event, key, actor.UserID, clientip.FromRequest(request), request.Header("User-Agent"))
+	}
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/gofiber/fiber/v2"
+	"github.com/iesreza/homa-backend/lib/clientip"
+	"gorm.io/datatypes"
+)
+
+// TestAPIKeyAllowlistBehindProxy checks the allowlist against the client address the reverse proxy forwarded
+func TestAPIKeyAllowlistBehindProxy(t *testing.T) {
+	key := &UserAPIKey{AllowedIPs: datatypes.JSON(`["198.51.100.0/24"]`)}
+
+	var allowed bool
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		allowed = key.IPAllowed(clientip.FromRequest(evo.Upgrade(c)))
+		return nil
+	})
+
+	// The test connection comes from 0.0.0.0, which stands in for the proxy
+	tests := []struct {
+		name         string
+		trusted      string
+		forwardedFor string
+		want         bool
+	}{
+		{name: "client in the allowlist", trusted: "0.0.0.0", forwardedFor: "198.51.100.7", want: true},
+		{name: "client outside the allowlist", trusted: "0.0.0.0", forwardedFor: "203.0.113.7", want: false},
+		{name: "prepended address is ignored", trusted: "0.0.0.0", forwardedFor: "198.51.100.7, 203.0.113.7", want: false},
+		{name: "header from an untrusted peer", trusted: "10.0.0.0/8", forwardedFor: "198.51.100.7", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings.Set("APP.TRUSTED_PROXIES", tt.trusted)
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(clientip.ForwardedHeader, tt.forwardedFor)
+			if _, err := app.Test(req); err != nil {
+				t.Fatal(err)
+			}
+			if allowed != tt.want {
+				t.Errorf("allowed = %v, want %v", allowed, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// API key audit events
+const (
+	APIKeyEventCreated = "create"
+	APIKeyEventRotated = "rotate"
+	APIKeyEventRevoked = "revoke"
+)
+
+// APIKeyMaxRotationGrace caps how long a rotated key keeps working next to its replacement
+const APIKeyMaxRotationGrace = 30 * 24 * time.Hour
+
+// apiKeyUsageInterval throttles the last-used updates of a key
+const apiKeyUsageInterval = time.Minute
+
+var (
+	ErrAPIKeyInvalid      = errors.New("invalid API key")
+	ErrAPIKeyExpired      = errors.New("API key has expired")
+	ErrAPIKeyIPNotAllowed = errors.New("API key is not allowed from this IP address")
+)
+
+// UserAPIKey is a named API key of a user. Only the SHA-256 hash of the key is stored.
+// Scopes are permission names; a request made with the key holds the permissions that are both in its scopes and granted to the user.
+type UserAPIKey struct {
+	ID            uint           `gorm:"column:id;primaryKey" json:"id"`
+	UserID        uuid.UUID      `gorm:"column:user_id;type:char(36);not null;index;fk:users" json:"user_id"`
+	Name          string         `gorm:"column:name;size:100;not null" json:"name"`
+	Prefix        string         `gorm:"column:prefix;size:20;not null" json:"prefix"` // first characters of the key, to recognise it
+	KeyHash       string         `gorm:"column:key_hash;size:64;uniqueIndex;not null" json:"-"`
+	Scopes        datatypes.JSON `gorm:"column:scopes;type:json" json:"scopes"`
+	AllowedIPs    datatypes.JSON `gorm:"column:allowed_ips;type:json" json:"allowed_ips"` // IP addresses or CIDR ranges; empty allows every address
+	ExpiresAt     *time.Time     `gorm:"column:expires_at;index" json:"expires_at"`
+	LastUsedAt    *time.Time     `gorm:"column:last_used_at" json:"last_used_at"`
+	LastUsedIP    string         `gorm:"column:last_used_ip;size:45" json:"last_used_ip"`
+	RevokedAt     *time.Time     `gorm:"column:revoked_at;index" json:"revoked_at"`
+	RotatedFromID *uint          `gorm:"column:rotated_from_id" json:"rotated_from_id"`
+	CreatedBy     *uuid.UUID     `gorm:"column:created_by;type:char(36)" json:"created_by"`
+	CreatedAt     time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	restify.API
+}
+
+func (UserAPIKey) TableName() string {
+	return "user_api_keys"
+}
+
+// APIKeyAuditLogger is set by the models package to write API key events to the activity log
+var APIKeyAuditLogger func(event string, key *UserAPIKey, actorID uuid.UUID, ip, userAgent string)
+
+// GetScopes decodes the key scopes
+func (k *UserAPIKey) GetScopes() []string {
+	scopes := []string{}
+	if len(k.Scopes) > 0 {
+		json.Unmarshal(k.Scopes, &scopes)
+	}
+	return scopes
+}
+
+// SetScopes encodes the key scopes
+func (k *UserAPIKey) SetScopes(scopes []string) {
+	if scopes == nil {
+		scopes = []string{}
+	}
+	k.Scopes, _ = json.Marshal(scopes)
+}
+
+// GetAllowedIPs decodes the allowed IP addresses and ranges
+func (k *UserAPIKey) GetAllowedIPs() []string {
+	var allowed []string
+	if len(k.AllowedIPs) > 0 {
+		json.Unmarshal(k.AllowedIPs, &allowed)
+	}
+	return allowed
+}
+
+// SetAllowedIPs validates and encodes the allowed IP addresses and ranges
+func (k *UserAPIKey) SetAllowedIPs(allowed []string) error {
+	cleaned := []string{}
+	for _, entry := range allowed {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("%q is not an IP address or CIDR range", entry)
+		}
+		cleaned = append(cleaned, entry)
+	}
+	k.AllowedIPs, _ = json.Marshal(cleaned)
+	return nil
+}
+
+// IPAllowed reports whether the key may be used from ip
+func (k *UserAPIKey) IPAllowed(ip string) bool {
+	allowed := k.GetAllowedIPs()
+	if len(allowed) == 0 {
+		return true
+	}
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(address) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the key is neither revoked nor expired
+func (k *UserAPIKey) Active() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(time.Now()))
+}
+
+// NewUserAPIKey creates a key for the user and returns the plaintext key, which is not stored
+func NewUserAPIKey(key *UserAPIKey) (string, error) {
+	secret, err := generateAPIKeySecret()
+	if err != nil {
+		return "", err
+	}
+	key.ID = 0
+	key.KeyHash = hashAPIKey(secret)
+	key.Prefix = secret[:12]
+	if key.Scopes == nil {
+		key.SetScopes(nil)
+	}
+	if key.AllowedIPs == nil {
+		key.SetAllowedIPs(nil)
+	}
+	if err := db.Create(key).Error; err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// RotateUserAPIKey creates a replacement with the same name, scopes, IP restrictions and expiry.
+// The old key keeps working for the grace period so clients can switch without downtime.
+func RotateUserAPIKey(old *UserAPIKey, grace time.Duration, actorID uuid.UUID) (*UserAPIKey, string, error) {
+	replacement := UserAPIKey{
+		UserID:        old.UserID,
+		Name:          old.Name,
+		Scopes:        old.Scopes,
+		AllowedIPs:    old.AllowedIPs,
+		ExpiresAt:     old.ExpiresAt,
+		RotatedFromID: &old.ID,
+		CreatedBy:     &actorID,
+	}
+	secret, err := NewUserAPIKey(&replacement)
+	if err != nil {
+		return nil, "", err
+	}
+
+	oldExpiry := time.Now().Add(grace)
+	if old.ExpiresAt == nil || old.ExpiresAt.After(oldExpiry) {
+		if err := db.Model(&UserAPIKey{}).Where("id = ?", old.ID).UpdateColumn("expires_at", oldExpiry).Error; err != nil {
+			return nil, "", err
+		}
+		old.ExpiresAt = &oldExpiry
+	}
+	return &replacement, secret, nil
+}
+
+// Revoke disables the key immediately
+func (k *UserAPIKey) Revoke() error {
+	now := time.Now()
+	if err := db.Model(&UserAPIKey{}).Where("id = ? AND revoked_at IS NULL", k.ID).UpdateColumn("revoked_at", now).Error; err != nil {
+		return err
+	}
+	k.RevokedAt = &now
+	return nil
+}
+
+// RevokeUserAPIKeys disables every key of the user and returns the revoked keys
+func RevokeUserAPIKeys(userID uuid.UUID) ([]UserAPIKey, error) {
+	var keys []UserAPIKey
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", userID).Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if err := keys[i].Revoke(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// UserFromAPIKey returns the user of an active key used from ip, limited to the key scopes
+func UserFromAPIKey(secret, ip string) (*User, error) {
+	var key UserAPIKey
+	if err := db.Where("key_hash = ? AND revoked_at IS NULL", hashAPIKey(secret)).First(&key).Error; err != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		return nil, ErrAPIKeyExpired
+	}
+	if !key.IPAllowed(ip) {
+		return nil, ErrAPIKeyIPNotAllowed
+	}
+
+	var user User
+	if err := db.Where("id = ?", key.UserID).First(&user).Error; err != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if user.Status == UserStatusBlocked {
+		return nil, fmt.Errorf("user is blocked")
+	}
+
+	// API keys belong to integrations and skip the second factor
+	user.twoFactorVerified = true
+	user.apiKeyID = key.ID
+	user.apiKeyScopes = key.GetScopes()
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > apiKeyUsageInterval || key.LastUsedIP != ip {
+		go func() {
+			if err := db.Model(&UserAPIKey{}).Where("id = ?", key.ID).
+				UpdateColumns(map[string]any{"last_used_at": time.Now(), "last_used_ip": ip}).Error; err != nil {
+				log.Warning("Failed to record use of API key %d: %v", key.ID, err)
+			}
+		}()
+	}
+	return &user, nil
+}
+
+// APIKeyID returns the key the request was made with, 0 for sessions
+func (u *User) APIKeyID() uint {
+	return u.apiKeyID
+}
+
+// scopeAllows reports whether the API key of the request, if any, is scoped to permission
+func (u *User) scopeAllows(permission string) bool {
+	if u.apiKeyID == 0 {
+		return true
+	}
+	for _, scope := range u.apiKeyScopes {
+		if permissionGranted(scope, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateLegacyAPIKeys moves the single plaintext key of each user into a hashed, unrestricted named key
+func MigrateLegacyAPIKeys() {
+	var users []User
+	if err := db.Where("api_key IS NOT NULL AND api_key != ''").Find(&users).Error; err != nil {
+		log.Warning("Failed to load legacy API keys: %v", err)
+		return
+	}
+	for _, user := range users {
+		secret := *user.APIKey
+		key := UserAPIKey{
+			UserID:  user.UserID,
+			Name:    "Legacy key",
+			KeyHash: hashAPIKey(secret),
+			Prefix:  secret[:min(12, len(secret))],
+		}
+		key.SetScopes([]string{PermissionAll})
+		key.SetAllowedIPs(nil)
+		if err := db.Create(&key).Error; err != nil {
+			log.Warning("Failed to migrate the API key of user %s: %v", user.UserID, err)
+			continue
+		}
+		db.Model(&User{}).Where("id = ?", user.UserID).UpdateColumn("api_key", nil)
+	}
+	if len(users) > 0 {
+		log.Info("Migrated %d legacy API keys", len(users))
+	}
+}
+
+// generateAPIKeySecret returns a new random key
+func generateAPIKeySecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	// Prefix for easy identification
+	return "homa_" + hex.EncodeToString(bytes), nil
+}
+
+// hashAPIKey returns the stored form of a key
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
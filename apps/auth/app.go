@@ -15,6 +15,16 @@ func (a App) Register() error {
 	// Register auth models with GORM
 	db.UseModel(User{})
 	db.UseModel(UserLoginHistory{})
+	db.UseModel(Role{})
+	db.UseModel(UserRole{})
+	db.UseModel(UserRecoveryCode{})
+	db.UseModel(SSOProvider{})
+	db.UseModel(UserSSOIdentity{})
+	db.UseModel(RefreshTokenFamily{})
+	db.UseModel(UserAPIKey{})
+	db.UseModel(UserPasswordHistory{})
+	db.UseModel(LoginLockoutClear{})
+	db.UseModel(UserAccountToken{})
 
 	// Set user interface for Evo framework
 	evo.SetUserInterface(&User{})
@@ -34,10 +44,28 @@ func (a App) Register() error {
 func (a App) Router() error {
 	var controller Controller
 
+	// Role based access control for the protected APIs. Registered before any of their routes.
+	evo.Use("/api/admin", PermissionMiddleware)
+	evo.Use("/api/agent", PermissionMiddleware)
+	evo.Use("/api/rag", PermissionMiddleware)
+
 	// Authentication endpoints
 	evo.Post("/api/auth/login", controller.LoginHandler)
+	evo.Post("/api/auth/login/2fa", controller.LoginTwoFactorHandler)
 	evo.Post("/api/auth/refresh", controller.RefreshHandler)
 
+	// Invitation and password reset links
+	evo.Post("/api/auth/password/forgot", controller.ForgotPassword)
+	evo.Post("/api/auth/password/reset", controller.ResetPassword)
+	evo.Post("/api/auth/invite/accept", controller.AcceptInvite)
+
+	// Two-factor authentication endpoints
+	evo.Get("/api/auth/2fa", controller.GetTwoFactorStatus)
+	evo.Post("/api/auth/2fa/setup", controller.SetupTwoFactor)
+	evo.Post("/api/auth/2fa/enable", controller.EnableTwoFactor)
+	evo.Post("/api/auth/2fa/disable", controller.DisableTwoFactor)
+	evo.Post("/api/auth/2fa/recovery-codes", controller.RegenerateRecoveryCodes)
+
 	// Profile endpoints
 	evo.Get("/api/auth/profile", controller.GetProfile)
 	evo.Put("/api/auth/profile", controller.EditProfile)
@@ -46,6 +74,10 @@ func (a App) Router() error {
 	// API Key management endpoints
 	evo.Post("/api/auth/api-key", controller.GenerateAPIKey)
 	evo.Delete("/api/auth/api-key", controller.RevokeAPIKey)
+	evo.Get("/api/auth/api-keys", controller.ListAPIKeys)
+	evo.Post("/api/auth/api-keys", controller.CreateAPIKey)
+	evo.Post("/api/auth/api-keys/:id/rotate", controller.RotateAPIKey)
+	evo.Delete("/api/auth/api-keys/:id", controller.DeleteAPIKey)
 
 	// OAuth endpoints
 	if settings.Get("OAUTH.MICROSOFT.ENABLED").Bool() {
@@ -58,6 +90,13 @@ func (a App) Router() error {
 	}
 	evo.Get("/api/auth/oauth/providers", controller.GetOAuthProviders)
 
+	// Generic OpenID Connect and SAML single sign-on
+	evo.Get("/api/auth/sso/providers", controller.ListSSOProviders)
+	evo.Get("/api/auth/sso/:slug/login", controller.SSOLogin)
+	evo.Get("/api/auth/sso/:slug/callback", controller.SSOCallback)
+	evo.Post("/api/auth/sso/:slug/acs", controller.SSOAssertionConsumer)
+	evo.Get("/api/auth/sso/:slug/metadata", controller.SSOMetadata)
+
 	// User Management endpoints (admin only)
 	evo.Get("/api/admin/users", controller.ListUsers)
 	evo.Post("/api/admin/users", controller.CreateUser)
@@ -66,6 +105,33 @@ func (a App) Router() error {
 	evo.Delete("/api/admin/users/:id", controller.DeleteUser)
 	evo.Post("/api/admin/users/:id/block", controller.BlockUser)
 	evo.Post("/api/admin/users/:id/unblock", controller.UnblockUser)
+	evo.Post("/api/admin/users/:id/2fa/reset", controller.ResetUserTwoFactor)
+	evo.Post("/api/admin/users/:id/invite", controller.InviteUser)
+	evo.Get("/api/admin/users/:id/api-keys", controller.ListUserAPIKeys)
+	evo.Post("/api/admin/users/:id/api-keys", controller.CreateUserAPIKey)
+	evo.Post("/api/admin/users/:id/api-keys/:key_id/rotate", controller.RotateUserAPIKey)
+	evo.Delete("/api/admin/users/:id/api-keys/:key_id", controller.DeleteUserAPIKey)
+
+	// Login lockouts
+	evo.Get("/api/admin/lockouts", controller.ListLockouts)
+	evo.Delete("/api/admin/lockouts/users/:id", controller.ClearUserLockout)
+	evo.Delete("/api/admin/lockouts/ips/:ip", controller.ClearIPLockout)
+
+	// Role endpoints
+	evo.Get("/api/admin/permissions", controller.ListPermissions)
+	evo.Get("/api/admin/roles", controller.ListRoles)
+	evo.Post("/api/admin/roles", controller.CreateRole)
+	evo.Put("/api/admin/roles/:id", controller.UpdateRole)
+	evo.Delete("/api/admin/roles/:id", controller.DeleteRole)
+	evo.Get("/api/admin/users/:id/roles", controller.ListUserRoles)
+	evo.Post("/api/admin/users/:id/roles", controller.AssignUserRole)
+	evo.Delete("/api/admin/users/:id/roles/:assignment_id", controller.RemoveUserRole)
+
+	// SSO provider management
+	evo.Get("/api/admin/sso-providers", controller.ListSSOProviderConfigs)
+	evo.Post("/api/admin/sso-providers", controller.CreateSSOProvider)
+	evo.Put("/api/admin/sso-providers/:id", controller.UpdateSSOProvider)
+	evo.Delete("/api/admin/sso-providers/:id", controller.DeleteSSOProvider)
 
 	return nil
 }
@@ -73,6 +139,12 @@ func (a App) Router() error {
 func (a App) WhenReady() error {
 	// Initialize OAuth configurations
 	InitOAuthConfigs()
+
+	// Create the built-in roles matching the user types
+	SeedBuiltInRoles()
+
+	// Hash the single plaintext API keys of earlier versions
+	MigrateLegacyAPIKeys()
 	return nil
 }
 
@@ -26,7 +26,7 @@ func CreateAdminUser() {
 	if err := db.Where("email = ?", email).First(&existingUser).Error; err == nil {
 		// User exists, reset password
 		if err := existingUser.SetPassword(password); err != nil {
-			log.Fatalf("Failed to hash password: %v", err)
+			log.Fatalf("Failed to set password: %v", err)
 		}
 
 		// Update user information if provided
@@ -61,7 +61,7 @@ func CreateAdminUser() {
 
 	// Set password
 	if err := user.SetPassword(password); err != nil {
-		log.Fatalf("Failed to hash password: %v", err)
+		log.Fatalf("Failed to set password: %v", err)
 	}
 
 	// Save user to database
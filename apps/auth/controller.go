@@ -11,9 +11,9 @@ import (
 	"github.com/getevo/evo/v2/lib/db"
 	"github.com/getevo/evo/v2/lib/log"
 	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/iesreza/homa-backend/lib/clientip"
 	"github.com/iesreza/homa-backend/lib/response"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/hlandau/passlib"
 	"golang.org/x/oauth2"
 )
 
@@ -96,6 +96,15 @@ type LoginResponse struct {
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int64  `json:"expires_in"`
 	User         *User  `json:"user"`
+	// TwoFactorSetupRequired is set when a role requires 2FA and the session may only enrol
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+}
+
+// TwoFactorChallengeResponse is returned by the login when a second step is required
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	TwoFactorToken    string `json:"two_factor_token"`
+	ExpiresIn         int64  `json:"expires_in"`
 }
 
 type RefreshRequest struct {
@@ -108,17 +117,28 @@ func (c Controller) LoginHandler(request *evo.Request) any {
 		return response.Error(response.ErrInvalidInput)
 	}
 
-	// Find user by email
+	// Refuse addresses that failed too often before looking at the account
 	var user User
+	if lockout := IPLockout(clientip.FromRequest(request)); lockout != nil {
+		user.RecordLogin(request, false, LoginReasonIPLocked)
+		return lockoutResponse(lockout)
+	}
+
+	// Find user by email
 	if err := db.Where("email = ?", loginReq.Email).First(&user).Error; err != nil {
-		user.RecordLogin(request, false, "user_not_found")
+		user.RecordLogin(request, false, LoginReasonUserNotFound)
 		invalidCredentialsErr := response.NewError(response.ErrorCodeUnauthorized, "Invalid email or password", 401)
 		return response.Error(invalidCredentialsErr)
 	}
 
+	if lockout := AccountLockout(user.UserID); lockout != nil {
+		user.RecordLogin(request, false, LoginReasonAccountLocked)
+		return lockoutResponse(lockout)
+	}
+
 	// Verify password
 	if !user.VerifyPassword(loginReq.Password) {
-		user.RecordLogin(request, false, "invalid_password")
+		user.recordLoginFailure(request, LoginReasonInvalidPassword)
 		invalidCredentialsErr := response.NewError(response.ErrorCodeUnauthorized, "Invalid email or password", 401)
 		return response.Error(invalidCredentialsErr)
 	}
@@ -130,6 +150,19 @@ func (c Controller) LoginHandler(request *evo.Request) any {
 		return response.Error(blockedErr)
 	}
 
+	// Users with two-factor authentication finish the login with a code
+	if user.TwoFactorEnabled {
+		challenge, err := user.GenerateTwoFactorChallenge()
+		if err != nil {
+			return response.Error(response.ErrInternalError)
+		}
+		return response.OK(TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			TwoFactorToken:    challenge,
+			ExpiresIn:         int64(TwoFactorChallengeTTL.Seconds()),
+		})
+	}
+
 	// Generate tokens
 	accessToken, err := user.GenerateJWT()
 	if err != nil {
@@ -150,10 +183,11 @@ func (c Controller) LoginHandler(request *evo.Request) any {
 	user.PasswordHash = nil
 
 	loginData := LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    86400, // 24 hours
-		User:         &user,
+		AccessToken:            accessToken,
+		RefreshToken:           refreshToken,
+		ExpiresIn:              86400, // 24 hours
+		User:                   &user,
+		TwoFactorSetupRequired: user.RequiresTwoFactor(),
 	}
 
 	return response.OK(loginData)
@@ -175,16 +209,27 @@ func (c Controller) RefreshHandler(request *evo.Request) any {
 	}
 
 	claims, ok := token.Claims.(*Claims)
-	if !ok {
+	if !ok || claims.Purpose != refreshTokenPurpose {
 		return response.Error(response.ErrInvalidToken)
 	}
 
 	// Find user
 	var user User
-	if err := db.Where("id = ?", claims.UserID).First(&user).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", claims.UserID).First(&user).Error; err != nil {
 		return response.Error(response.ErrUserNotFound)
 	}
 
+	// Sessions from before 2FA was enabled cannot be renewed; the new tokens keep the verified claim
+	if user.TwoFactorEnabled && !claims.TwoFactorVerified {
+		return response.Error(response.ErrInvalidToken)
+	}
+	user.twoFactorVerified = claims.TwoFactorVerified
+
+	// Only the latest refresh token of the session is accepted; a reused one revokes the session
+	if err := user.RotateRefreshToken(claims); err != nil {
+		return response.Error(response.ErrInvalidToken)
+	}
+
 	// Generate new access token
 	accessToken, err := user.GenerateJWT()
 	if err != nil {
@@ -201,10 +246,11 @@ func (c Controller) RefreshHandler(request *evo.Request) any {
 	user.PasswordHash = nil
 
 	refreshData := LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: newRefreshToken,
-		ExpiresIn:    86400, // 24 hours
-		User:         &user,
+		AccessToken:            accessToken,
+		RefreshToken:           newRefreshToken,
+		ExpiresIn:              86400, // 24 hours
+		User:                   &user,
+		TwoFactorSetupRequired: !user.TwoFactorEnabled && user.RequiresTwoFactor(),
 	}
 
 	return response.OK(refreshData)
@@ -387,7 +433,16 @@ func (c Controller) GoogleOAuthCallback(req *evo.Request) interface{} {
 	// Update avatar if not already set or if OAuth provides a new one
 	if userInfo.Picture != "" && (user.Avatar == nil || *user.Avatar == "") {
 		user.Avatar = &userInfo.Picture
-		db.Save(&user)
+		db.GetContext(req).Save(&user)
+	}
+
+	// Users with two-factor authentication finish the login with a code
+	if user.TwoFactorEnabled {
+		challenge, err := user.GenerateTwoFactorChallenge()
+		if err != nil {
+			return req.Redirect(redirectURL + "?oauth=error&message=Failed%20to%20start%20two-factor%20authentication")
+		}
+		return req.Redirect(redirectURL + "?oauth=2fa&two_factor_token=" + url.QueryEscape(challenge))
 	}
 
 	// Generate JWT tokens
@@ -535,6 +590,15 @@ func (c Controller) MicrosoftOAuthCallback(req *evo.Request) interface{} {
 		}
 	}
 
+	// Users with two-factor authentication finish the login with a code
+	if user.TwoFactorEnabled {
+		challenge, err := user.GenerateTwoFactorChallenge()
+		if err != nil {
+			return req.Redirect(redirectURL + "?oauth=error&message=Failed%20to%20start%20two-factor%20authentication")
+		}
+		return req.Redirect(redirectURL + "?oauth=2fa&two_factor_token=" + url.QueryEscape(challenge))
+	}
+
 	// Generate JWT tokens
 	accessToken, err := user.GenerateJWT()
 	if err != nil {
@@ -586,7 +650,7 @@ func (c Controller) GetProfile(req *evo.Request) interface{} {
 	var user = req.User().(*User)
 	var departments []Department
 	// Query departments directly to avoid import cycle
-	db.Raw("SELECT d.id, d.name, d.description FROM departments d JOIN user_departments ud ON d.id = ud.department_id WHERE ud.user_id = ?", user.UserID).Scan(&departments)
+	db.GetContext(req).Raw("SELECT d.id, d.name, d.description FROM departments d JOIN user_departments ud ON d.id = ud.department_id WHERE ud.user_id = ?", user.UserID).Scan(&departments)
 
 	profileData := GetProfileResponse{
 		User:        *user,
@@ -637,15 +701,12 @@ func (c Controller) EditProfile(req *evo.Request) interface{} {
 	}
 
 	if params.Password != nil && *params.Password != "" {
-		hash, err := passlib.Hash(*params.Password)
-		if err != nil {
-			log.Error(err)
-			return err
+		if err := user.SetPassword(*params.Password); err != nil {
+			return PasswordErrorResponse(err)
 		}
-		user.PasswordHash = &hash
 	}
 
-	if err := db.Save(&user).Error; err != nil {
+	if err := db.GetContext(req).Save(&user).Error; err != nil {
 		log.Error(err)
 		return response.Error(response.ErrDatabaseError)
 	}
@@ -655,7 +716,7 @@ func (c Controller) EditProfile(req *evo.Request) interface{} {
 
 // GenerateAPIKey generates a new API key for the authenticated user
 // @Summary Generate API key
-// @Description Generate a new API key for the authenticated user. This will replace any existing API key.
+// @Description Generate a new unrestricted API key for the authenticated user. This will revoke every existing API key; use /auth/api-keys for named, scoped keys.
 // @Tags Profile
 // @Accept json
 // @Produce json
@@ -663,27 +724,37 @@ func (c Controller) EditProfile(req *evo.Request) interface{} {
 // @Router /auth/api-key [post]
 // @Security Bearer
 func (c Controller) GenerateAPIKey(req *evo.Request) interface{} {
-	user := req.User().(*User)
+	user, errResponse := apiKeyActor(req)
+	if errResponse != nil {
+		return errResponse
+	}
 
-	apiKey, err := user.GenerateAPIKey()
+	revoked, err := RevokeUserAPIKeys(user.UserID)
 	if err != nil {
-		log.Error("Failed to generate API key:", err)
-		return response.Error(response.ErrInternalError)
+		log.Error("Failed to revoke API keys:", err)
+		return response.Error(response.ErrDatabaseError)
+	}
+	for i := range revoked {
+		auditAPIKey(req, APIKeyEventRevoked, &revoked[i], user)
 	}
 
-	if err := db.Save(user).Error; err != nil {
+	key := UserAPIKey{UserID: user.UserID, Name: "API key", CreatedBy: &user.UserID}
+	key.SetScopes([]string{PermissionAll})
+	apiKey, err := NewUserAPIKey(&key)
+	if err != nil {
 		log.Error("Failed to save API key:", err)
 		return response.Error(response.ErrDatabaseError)
 	}
+	auditAPIKey(req, APIKeyEventCreated, &key, user)
 
 	return response.OK(map[string]string{
 		"api_key": apiKey,
 	})
 }
 
-// RevokeAPIKey removes the API key from the authenticated user
-// @Summary Revoke API key
-// @Description Revoke the current API key for the authenticated user
+// RevokeAPIKey revokes every API key of the authenticated user
+// @Summary Revoke API keys
+// @Description Revoke all API keys of the authenticated user
 // @Tags Profile
 // @Accept json
 // @Produce json
@@ -691,14 +762,19 @@ func (c Controller) GenerateAPIKey(req *evo.Request) interface{} {
 // @Router /auth/api-key [delete]
 // @Security Bearer
 func (c Controller) RevokeAPIKey(req *evo.Request) interface{} {
-	user := req.User().(*User)
-
-	user.ClearAPIKey()
+	user, errResponse := apiKeyActor(req)
+	if errResponse != nil {
+		return errResponse
+	}
 
-	if err := db.Save(user).Error; err != nil {
+	revoked, err := RevokeUserAPIKeys(user.UserID)
+	if err != nil {
 		log.Error("Failed to revoke API key:", err)
 		return response.Error(response.ErrDatabaseError)
 	}
+	for i := range revoked {
+		auditAPIKey(req, APIKeyEventRevoked, &revoked[i], user)
+	}
 
 	return response.Message("API key revoked successfully")
 }
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+)
+
+// Login history reasons of the password step
+const (
+	LoginReasonInvalidPassword = "invalid_password"
+	LoginReasonUserNotFound    = "user_not_found"
+	LoginReasonAccountLocked   = "account_locked"
+	LoginReasonIPLocked        = "ip_locked"
+)
+
+// Lockout scopes
+const (
+	LockoutScopeUser = "user"
+	LockoutScopeIP   = "ip"
+)
+
+// accountFailureReasons count towards the lockout of an account; attempts refused by a lockout do not
+var accountFailureReasons = []string{LoginReasonInvalidPassword, LoginReasonTwoFactorFailed}
+
+// ipFailureReasons count towards the lockout of an IP address, including attempts on unknown emails and links
+var ipFailureReasons = []string{LoginReasonInvalidPassword, LoginReasonTwoFactorFailed, LoginReasonUserNotFound, LoginReasonInvalidAccountToken}
+
+// LoginLockoutClear marks the point from which failed logins of an account or IP address count again
+type LoginLockoutClear struct {
+	ID        uint       `gorm:"column:id;primaryKey" json:"id"`
+	Scope     string     `gorm:"column:scope;size:10;not null;index:idx_lockout_clear_key" json:"scope"`
+	Key       string     `gorm:"column:lockout_key;size:45;not null;index:idx_lockout_clear_key" json:"key"` // user ID or IP address
+	ClearedBy *uuid.UUID `gorm:"column:cleared_by;type:char(36)" json:"cleared_by"`
+	ClearedAt time.Time  `gorm:"column:cleared_at;autoCreateTime" json:"cleared_at"`
+
+	restify.API
+}
+
+func (LoginLockoutClear) TableName() string {
+	return "login_lockout_clears"
+}
+
+// LoginLockout is an account or IP address that may not log in until LockedUntil
+type LoginLockout struct {
+	Scope          string     `json:"scope"`
+	Key            string     `json:"key"`
+	UserID         *uuid.UUID `json:"user_id,omitempty"`
+	Email          string     `json:"email,omitempty"`
+	FailedAttempts int64      `json:"failed_attempts"`
+	LastFailureAt  time.Time  `json:"last_failure_at"`
+	LockedUntil    time.Time  `json:"locked_until"`
+}
+
+// LockoutPolicy is the configured progressive lockout
+type LockoutPolicy struct {
+	Threshold   int64         // failed logins of an account before it is locked
+	IPThreshold int64         // failed logins from an IP address before it is locked
+	Duration    time.Duration // first lockout, doubled by every further failure
+	MaxDuration time.Duration
+	Window      time.Duration // failures older than this are forgotten
+}
+
+// GetLockoutPolicy reads the lockout settings
+func GetLockoutPolicy() LockoutPolicy {
+	policy := LockoutPolicy{
+		Threshold:   settings.Get("AUTH.LOCKOUT_THRESHOLD", 5).Int64(),
+		IPThreshold: settings.Get("AUTH.IP_LOCKOUT_THRESHOLD", 20).Int64(),
+	}
+	policy.Duration, _ = settings.Get("AUTH.LOCKOUT_DURATION", "1m").Duration()
+	policy.MaxDuration, _ = settings.Get("AUTH.LOCKOUT_MAX_DURATION", "24h").Duration()
+	policy.Window, _ = settings.Get("AUTH.LOCKOUT_WINDOW", "24h").Duration()
+	if policy.Window <= 0 {
+		policy.Window = 24 * time.Hour
+	}
+	return policy
+}
+
+// lockedUntil returns the end of the lockout after failures, the last at lastFailure.
+// Every failure past the threshold doubles the lockout, up to the maximum.
+func (p LockoutPolicy) lockedUntil(failures, threshold int64, lastFailure time.Time) time.Time {
+	if threshold <= 0 || failures < threshold {
+		return time.Time{}
+	}
+	duration := p.Duration
+	for i := threshold; i < failures && duration < p.MaxDuration; i++ {
+		duration *= 2
+	}
+	if p.MaxDuration > 0 && duration > p.MaxDuration {
+		duration = p.MaxDuration
+	}
+	return lastFailure.Add(duration)
+}
+
+// AccountLockout returns the lockout of the user, nil when the user may log in
+func AccountLockout(userID uuid.UUID) *LoginLockout {
+	lockout := accountFailures(userID, GetLockoutPolicy())
+	if lockout == nil || !lockout.LockedUntil.After(time.Now()) {
+		return nil
+	}
+	return lockout
+}
+
+// IPLockout returns the lockout of the IP address, nil when logins from it are allowed
+func IPLockout(ip string) *LoginLockout {
+	lockout := ipFailures(ip, GetLockoutPolicy())
+	if lockout == nil || !lockout.LockedUntil.After(time.Now()) {
+		return nil
+	}
+	return lockout
+}
+
+// accountFailures counts the failed logins of the user since the last success or clear
+func accountFailures(userID uuid.UUID, policy LockoutPolicy) *LoginLockout {
+	since := lockoutCountStart(LockoutScopeUser, userID.String(), policy)
+	var lastSuccess UserLoginHistory
+	if err := db.Where("user_id = ? AND success = ? AND login_at > ?", userID, true, since).
+		Order("login_at DESC").First(&lastSuccess).Error; err == nil {
+		since = lastSuccess.LoginAt
+	}
+
+	var stats struct {
+		Failures    int64
+		LastFailure *time.Time
+	}
+	db.Model(&UserLoginHistory{}).Select("COUNT(*) AS failures, MAX(login_at) AS last_failure").
+		Where("user_id = ? AND success = ? AND reason IN ? AND login_at > ?", userID, false, accountFailureReasons, since).
+		Scan(&stats)
+	if stats.LastFailure == nil {
+		return nil
+	}
+
+	id := userID
+	return &LoginLockout{
+		Scope:          LockoutScopeUser,
+		Key:            userID.String(),
+		UserID:         &id,
+		FailedAttempts: stats.Failures,
+		LastFailureAt:  *stats.LastFailure,
+		LockedUntil:    policy.lockedUntil(stats.Failures, policy.Threshold, *stats.LastFailure),
+	}
+}
+
+// ipFailures counts the failed logins from the IP address since the last clear.
+// Successful logins from the address do not reset it, so one valid account cannot hide a password spray.
+func ipFailures(ip string, policy LockoutPolicy) *LoginLockout {
+	since := lockoutCountStart(LockoutScopeIP, ip, policy)
+
+	var stats struct {
+		Failures    int64
+		LastFailure *time.Time
+	}
+	db.Model(&UserLoginHistory{}).Select("COUNT(*) AS failures, MAX(login_at) AS last_failure").
+		Where("ip_address = ? AND success = ? AND reason IN ? AND login_at > ?", ip, false, ipFailureReasons, since).
+		Scan(&stats)
+	if stats.LastFailure == nil {
+		return nil
+	}
+
+	return &LoginLockout{
+		Scope:          LockoutScopeIP,
+		Key:            ip,
+		FailedAttempts: stats.Failures,
+		LastFailureAt:  *stats.LastFailure,
+		LockedUntil:    policy.lockedUntil(stats.Failures, policy.IPThreshold, *stats.LastFailure),
+	}
+}
+
+// lockoutCountStart returns the later of the window start and the last clear of the account or IP address
+func lockoutCountStart(scope, key string, policy LockoutPolicy) time.Time {
+	since := time.Now().Add(-policy.Window)
+	var clear LoginLockoutClear
+	if err := db.Where("scope = ? AND lockout_key = ? AND cleared_at > ?", scope, key, since).
+		Order("cleared_at DESC").First(&clear).Error; err == nil {
+		since = clear.ClearedAt
+	}
+	return since
+}
+
+// ListLockouts returns the accounts and IP addresses that are locked out now
+func ListLockouts() ([]LoginLockout, error) {
+	policy := GetLockoutPolicy()
+	since := time.Now().Add(-policy.Window)
+	lockouts := []LoginLockout{}
+
+	// Candidates have enough failures within the window; the exact count honours successes and clears
+	var userIDs []uuid.UUID
+	if err := db.Model(&UserLoginHistory{}).Select("user_id").
+		Where("success = ? AND reason IN ? AND login_at > ? AND user_id != ?", false, accountFailureReasons, since, uuid.Nil).
+		Group("user_id").Having("COUNT(*) >= ?", policy.Threshold).Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, userID := range userIDs {
+		lockout := AccountLockout(userID)
+		if lockout == nil {
+			continue
+		}
+		var user User
+		if err := db.Select("id, email").Where("id = ?", userID).First(&user).Error; err == nil {
+			lockout.Email = user.Email
+		}
+		lockouts = append(lockouts, *lockout)
+	}
+
+	var ips []string
+	if err := db.Model(&UserLoginHistory{}).Select("ip_address").
+		Where("success = ? AND reason IN ? AND login_at > ?", false, ipFailureReasons, since).
+		Group("ip_address").Having("COUNT(*) >= ?", policy.IPThreshold).Pluck("ip_address", &ips).Error; err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if lockout := IPLockout(ip); lockout != nil {
+			lockouts = append(lockouts, *lockout)
+		}
+	}
+	return lockouts, nil
+}
+
+// ClearLockout lets an account or IP address log in again and restarts its failure count
+func ClearLockout(scope, key string, actorID *uuid.UUID) error {
+	return db.Create(&LoginLockoutClear{Scope: scope, Key: key, ClearedBy: actorID}).Error
+}
+
+// recordLoginFailure records a failed login and broadcasts user.locked when it locks the account
+func (u *User) recordLoginFailure(request *evo.Request, reason string) {
+	u.RecordLogin(request, false, reason)
+	if u.Anonymous() {
+		return
+	}
+	lockout := AccountLockout(u.UserID)
+	if lockout == nil || UserWebhookBroadcaster == nil {
+		return
+	}
+	data := u.ToWebhookData()
+	data["locked_until"] = lockout.LockedUntil
+	data["failed_attempts"] = lockout.FailedAttempts
+	if err := UserWebhookBroadcaster(evo.GetDBO(), u.WorkspaceID, "user.locked", data); err != nil {
+		log.Error("Failed to queue user.locked webhook: %v", err)
+	}
+}
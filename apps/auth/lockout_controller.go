@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// ListLockouts returns the locked accounts and IP addresses
+// @Summary List login lockouts
+// @Tags Users
+// @Produce json
+// @Success 200 {array} LoginLockout
+// @Router /admin/lockouts [get]
+// @Security Bearer
+func (c Controller) ListLockouts(request *evo.Request) interface{} {
+	lockouts, err := ListLockouts()
+	if err != nil {
+		log.Error("Failed to list lockouts:", err)
+		return response.Error(response.ErrDatabaseError)
+	}
+	return response.List(lockouts, len(lockouts))
+}
+
+// ClearUserLockout lets a locked account log in again
+// @Summary Clear account lockout
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Router /admin/lockouts/users/{id} [delete]
+// @Security Bearer
+func (c Controller) ClearUserLockout(request *evo.Request) interface{} {
+	id, err := uuid.Parse(request.Param("id").String())
+	if err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user ID format", 400))
+	}
+	var count int64
+	db.GetContext(request).Model(&User{}).Where("id = ?", id).Count(&count)
+	if count == 0 {
+		return response.Error(response.ErrUserNotFound)
+	}
+	return clearLockout(request, LockoutScopeUser, id.String())
+}
+
+// ClearIPLockout lets a locked IP address log in again
+// @Summary Clear IP address lockout
+// @Tags Users
+// @Produce json
+// @Param ip path string true "IP address"
+// @Router /admin/lockouts/ips/{ip} [delete]
+// @Security Bearer
+func (c Controller) ClearIPLockout(request *evo.Request) interface{} {
+	ip := request.Param("ip").String()
+	if net.ParseIP(ip) == nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid IP address", 400))
+	}
+	return clearLockout(request, LockoutScopeIP, ip)
+}
+
+func clearLockout(request *evo.Request, scope, key string) interface{} {
+	var actorID *uuid.UUID
+	if !request.User().Anonymous() {
+		actorID = &request.User().Interface().(*User).UserID
+	}
+	if err := ClearLockout(scope, key, actorID); err != nil {
+		log.Error("Failed to clear lockout:", err)
+		return response.Error(response.ErrDatabaseError)
+	}
+	return response.Message("Lockout cleared successfully")
+}
+
+// lockoutResponse refuses a login while the account or IP address is locked
+func lockoutResponse(lockout *LoginLockout) interface{} {
+	retryAfter := int64(time.Until(lockout.LockedUntil).Seconds()) + 1
+	return response.Error(response.NewErrorWithDetails("too_many_requests",
+		"Too many failed login attempts. Please try again later.", 429,
+		fmt.Sprintf("retry after %d seconds", retryAfter)))
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/getevo/evo/v2"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// RoutePermission requires Permission for requests whose path matches Pattern.
+// Pattern segments starting with ":" match any value and the pattern matches the path and everything below it.
+// An empty Method matches every method; an empty Permission only requires a logged-in user.
+type RoutePermission struct {
+	Method     string
+	Pattern    string
+	Permission string
+}
+
+// RoutePermissions maps the protected API routes to the permission they require.
+// The most specific matching rule wins; protected paths without a rule require the administrator role.
+var RoutePermissions = []RoutePermission{
+	// Admin: tickets and conversations
+	{"", "/api/admin/tickets", PermissionTicketsManage},
+	{"DELETE", "/api/admin/tickets/:id", PermissionConversationsDelete},
+	{"", "/api/admin/conversations", PermissionTicketsManage},
+	{"DELETE", "/api/admin/messages", PermissionConversationsDelete},
+
+	// Admin: clients
+	{"GET", "/api/admin/clients", PermissionClientsView},
+	{"POST", "/api/admin/clients", PermissionClientsEdit},
+	{"PUT", "/api/admin/clients", PermissionClientsEdit},
+	{"DELETE", "/api/admin/clients", PermissionClientsDelete},
+	{"POST", "/api/admin/clients/merge", PermissionClientsMerge},
+	{"", "/api/admin/client-duplicates", PermissionClientsMerge},
+
+	// Admin: knowledge base
+	{"GET", "/api/admin/kb", PermissionKBView},
+	{"POST", "/api/admin/kb", PermissionKBEdit},
+	{"PUT", "/api/admin/kb", PermissionKBEdit},
+	{"DELETE", "/api/admin/kb", PermissionKBEdit},
+
+	// Admin: configuration
+	{"", "/api/admin/users", PermissionUsersManage},
+	{"", "/api/admin/lockouts", PermissionUsersManage},
+	{"", "/api/admin/upload/avatar", PermissionUsersManage},
+	{"", "/api/admin/roles", PermissionRolesManage},
+	{"", "/api/admin/permissions", PermissionRolesManage},
+	{"", "/api/admin/users/:id/roles", PermissionRolesManage},
+	{"", "/api/admin/departments", PermissionDepartmentsManage},
+	{"", "/api/admin/tags", PermissionTagsManage},
+	{"", "/api/admin/attributes", PermissionAttributesManage},
+	{"", "/api/admin/channels", PermissionChannelsManage},
+	{"", "/api/admin/inboxes", PermissionInboxesManage},
+	{"", "/api/admin/integrations", PermissionIntegrationsManage},
+	{"", "/api/admin/sso-providers", PermissionSettingsManage},
+	{"", "/api/admin/canned-messages", PermissionCannedManage},
+	{"", "/api/admin/blocklist", PermissionSpamManage},
+	{"", "/api/admin/spam-events", PermissionSpamManage},
+	{"", "/api/admin/campaigns", PermissionCampaignsManage},
+	{"", "/api/admin/campaign-opt-outs", PermissionCampaignsManage},
+	{"", "/api/admin/webhooks", PermissionWebhooksManage},
+	{"", "/api/admin/workspaces", PermissionWorkspacesManage},
+	{"", "/api/admin/webhook_deliveries", PermissionWebhooksManage},
+	{"", "/api/admin/webhook_queue", PermissionWebhooksManage},
+	{"", "/api/admin/ai-agents", PermissionAIAgentsEdit},
+	{"", "/api/admin/ai", PermissionAIAgentsEdit},
+
+	// Agent
+	{"", "/api/agent", PermissionConversationsView},
+	{"", "/api/agent/me", ""},
+	{"", "/api/agent/notification-sounds", ""},
+	{"GET", "/api/agent/departments", ""},
+	{"GET", "/api/agent/users", ""},
+	{"POST", "/api/agent/conversations", PermissionConversationsReply},
+	{"PUT", "/api/agent/conversations", PermissionConversationsReply},
+	{"DELETE", "/api/agent/conversations", PermissionConversationsReply},
+	{"", "/api/agent/conversations/:id/assign", PermissionConversationsAssign},
+	{"", "/api/agent/scheduled-messages", PermissionConversationsReply},
+	{"POST", "/api/agent/canned-messages", PermissionConversationsReply},
+	{"PUT", "/api/agent/canned-messages", PermissionConversationsReply},
+	{"DELETE", "/api/agent/canned-messages", PermissionConversationsReply},
+	{"POST", "/api/agent/macros", PermissionConversationsReply},
+	{"PUT", "/api/agent/macros", PermissionConversationsReply},
+	{"DELETE", "/api/agent/macros", PermissionConversationsReply},
+	{"POST", "/api/agent/tickets", PermissionConversationsReply},
+	{"PUT", "/api/agent/tickets", PermissionConversationsReply},
+	{"PUT", "/api/agent/tickets/:id/assign", PermissionConversationsAssign},
+	{"POST", "/api/agent/tags", PermissionTagsCreate},
+	{"GET", "/api/agent/clients", PermissionClientsView},
+	{"POST", "/api/agent/clients", PermissionClientsEdit},
+	{"PUT", "/api/agent/clients", PermissionClientsEdit},
+	{"DELETE", "/api/agent/clients", PermissionClientsEdit},
+	{"POST", "/api/agent/clients/:id/conversations", PermissionConversationsReply},
+	{"GET", "/api/agent/organizations", PermissionClientsView},
+	{"POST", "/api/agent/organizations", PermissionClientsEdit},
+	{"PUT", "/api/agent/organizations", PermissionClientsEdit},
+	{"DELETE", "/api/agent/organizations", PermissionClientsEdit},
+	{"POST", "/api/agent/attributes", PermissionClientsEdit},
+	{"PUT", "/api/agent/attributes", PermissionClientsEdit},
+	{"DELETE", "/api/agent/attributes", PermissionClientsEdit},
+
+	// Knowledge base search index
+	{"", "/api/rag", PermissionRAGManage},
+	{"GET", "/api/rag/health", PermissionKBView},
+	{"POST", "/api/rag/search", PermissionKBView},
+
+	// Settings, jobs and reports
+	{"", "/api/settings", PermissionSettingsManage},
+	{"", "/api/settings/jobs", PermissionJobsManage},
+	{"", "/api/activity-logs", PermissionReportsView},
+}
+
+// PermissionMiddleware requires a logged-in user holding the permission RoutePermissions assigns to the request.
+// Department-scoped roles pass here; handlers of department resources check the department with HasDepartmentPermission.
+func PermissionMiddleware(request *evo.Request) error {
+	if request.User().Anonymous() {
+		return response.ErrUnauthorized
+	}
+	user := request.User().Interface().(*User)
+
+	permission := RequiredPermission(request.Method(), request.Path())
+	if permission != "" && !user.HasPermissionInAnyScope(permission) {
+		return response.ErrForbidden
+	}
+
+	return request.Next()
+}
+
+// RequiredPermission returns the permission a request needs, "" when any logged-in user may call it
+func RequiredPermission(method, path string) string {
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	best := -1
+	bestScore := -1
+	for i, rule := range RoutePermissions {
+		if rule.Method != "" && !strings.EqualFold(rule.Method, method) {
+			continue
+		}
+		score, ok := matchRoutePattern(rule.Pattern, pathSegments)
+		if !ok {
+			continue
+		}
+		// Method-specific rules win over rules for every method at the same depth
+		score *= 2
+		if rule.Method != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return PermissionAll
+	}
+	return RoutePermissions[best].Permission
+}
+
+// matchRoutePattern reports whether pattern matches the start of the path and how specific the match is
+func matchRoutePattern(pattern string, pathSegments []string) (int, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	if len(patternSegments) > len(pathSegments) {
+		return 0, false
+	}
+	score := 0
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, ":") {
+			score += 1
+			continue
+		}
+		if segment != pathSegments[i] {
+			return 0, false
+		}
+		score += 2
+	}
+	return score, true
+}
@@ -1,9 +1,6 @@
 package auth
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"fmt"
 	"os"
 	"strings"
 	"time"
@@ -17,6 +14,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/hlandau/passlib"
+	"github.com/iesreza/homa-backend/lib/clientip"
 	"gorm.io/gorm"
 )
 
@@ -66,30 +64,65 @@ type Claims struct {
 	Name        string   `json:"name"`
 	Type        string   `json:"type"`
 	Departments []string `json:"departments"`
+	// TwoFactorVerified is set when the session passed the second login step
+	TwoFactorVerified bool `json:"tfa_verified,omitempty"`
+	// TwoFactorSetup limits the session to 2FA enrolment because a role requires it
+	TwoFactorSetup bool `json:"tfa_setup,omitempty"`
+	// Purpose marks tokens that are not sessions, such as the 2FA login challenge
+	Purpose string `json:"purpose,omitempty"`
+	// SessionID is the refresh token family the token belongs to
+	SessionID string `json:"sid,omitempty"`
+	// WorkspaceID is the workspace the user belongs to
+	WorkspaceID uint `json:"wid,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type User struct {
 	UserID       uuid.UUID `gorm:"column:id;type:char(36);primaryKey" json:"id"`
+	WorkspaceID  uint      `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
 	Name         string    `gorm:"column:name;size:255;not null" json:"name"`
 	LastName     string    `gorm:"column:last_name;size:255;not null" json:"last_name"`
 	DisplayName  string    `gorm:"column:display_name;size:255" json:"display_name"`
 	Avatar       *string   `gorm:"column:avatar;size:500" json:"avatar"`
 	Email        string    `gorm:"column:email;size:255;uniqueIndex;not null" json:"email"`
 	PasswordHash *string   `gorm:"column:password_hash;size:255" json:"password_hash,omitempty"`
-	APIKey       *string   `gorm:"column:api_key;size:255;uniqueIndex" json:"api_key,omitempty"`
+	APIKey       *string   `gorm:"column:api_key;size:255;uniqueIndex" json:"api_key,omitempty"` // legacy plaintext key, moved to UserAPIKey on startup
 	SecurityKey  *string   `gorm:"column:security_key;size:50" json:"security_key,omitempty"`
 	Language               string `gorm:"column:language;size:10;not null;default:'en'" json:"language"`
 	AutoTranslateIncoming  bool   `gorm:"column:auto_translate_incoming;not null;default:false" json:"auto_translate_incoming"`
 	AutoTranslateOutgoing  bool   `gorm:"column:auto_translate_outgoing;not null;default:false" json:"auto_translate_outgoing"`
 	Type                   string `gorm:"column:type;size:50;not null;check:type IN ('agent','administrator','bot')" json:"type"`
 	Status       string    `gorm:"column:status;size:20;not null;default:'active';check:status IN ('active','blocked')" json:"status"`
+	TwoFactorEnabled   bool       `gorm:"column:two_factor_enabled;not null;default:false" json:"two_factor_enabled"`
+	TwoFactorSecret    *string    `gorm:"column:two_factor_secret;size:64" json:"-"`
+	TwoFactorLastStep  int64      `gorm:"column:two_factor_last_step;not null;default:0" json:"-"`
+	TwoFactorEnabledAt *time.Time `gorm:"column:two_factor_enabled_at" json:"two_factor_enabled_at"`
+	EmailVerifiedAt    *time.Time `gorm:"column:email_verified_at" json:"email_verified_at"`
+	InvitedAt          *time.Time `gorm:"column:invited_at" json:"invited_at"` // last invitation; pending until email_verified_at is set
 	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
 	UpdatedAt    time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
 
 	// Relationships
 	LoginHistory []UserLoginHistory `gorm:"foreignKey:UserID;references:UserID" json:"login_history,omitempty"`
 
+	// Permission grants, loaded on first use
+	grants       []permissionGrant
+	grantsLoaded bool
+
+	// Set when the session passed the second login step
+	twoFactorVerified bool
+
+	// Token session of the request and the refresh token ID to issue next
+	sessionID      string
+	refreshTokenID string
+
+	// API key of the request and its scopes
+	apiKeyID     uint
+	apiKeyScopes []string
+
+	// Set by SetPassword so the next save records the password history
+	passwordChanged bool
+
 	restify.API
 }
 
@@ -118,20 +151,22 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-// AfterCreate hook - broadcast user creation to webhooks
+// AfterCreate hook - queue user creation for webhooks in the same transaction
 func (u *User) AfterCreate(tx *gorm.DB) error {
+	u.recordPasswordHistory(tx)
 	// Trigger webhook with sanitized user entity
 	if UserWebhookBroadcaster != nil {
-		go UserWebhookBroadcaster("user.created", u.ToWebhookData())
+		return UserWebhookBroadcaster(tx, u.WorkspaceID, "user.created", u.ToWebhookData())
 	}
 	return nil
 }
 
-// AfterUpdate hook - broadcast user update to webhooks
+// AfterUpdate hook - queue user update for webhooks in the same transaction
 func (u *User) AfterUpdate(tx *gorm.DB) error {
+	u.recordPasswordHistory(tx)
 	// Trigger webhook with sanitized user entity
 	if UserWebhookBroadcaster != nil {
-		go UserWebhookBroadcaster("user.updated", u.ToWebhookData())
+		return UserWebhookBroadcaster(tx, u.WorkspaceID, "user.updated", u.ToWebhookData())
 	}
 	return nil
 }
@@ -146,6 +181,7 @@ func (u *User) ToWebhookData() map[string]any {
 		"email":                   u.Email,
 		"type":                    u.Type,
 		"status":                  u.Status,
+		"workspace_id":            u.WorkspaceID,
 		"avatar":                  u.Avatar,
 		"language":                u.Language,
 		"auto_translate_incoming": u.AutoTranslateIncoming,
@@ -163,7 +199,7 @@ func (u *User) ToWebhookData() map[string]any {
 }
 
 // UserWebhookBroadcaster is set by the models package to avoid circular dependencies
-var UserWebhookBroadcaster func(event string, data map[string]any)
+var UserWebhookBroadcaster func(tx *gorm.DB, workspaceID uint, event string, data map[string]any) error
 
 // Evo UserInterface implementation
 func (u *User) GetFirstName() string {
@@ -199,10 +235,6 @@ func (u *User) Anonymous() bool {
 	return u.UserID == uuid.Nil
 }
 
-func (u *User) HasPermission(permission string) bool {
-	return u.Type == UserTypeAdministrator
-}
-
 func (u *User) Attributes() evo.Attributes {
 	var m evo.Attributes
 	generic.Parse(u).Cast(&m)
@@ -220,14 +252,12 @@ func (u *User) FromRequest(request *evo.Request) evo.UserInterface {
 	if strings.HasPrefix(authToken, "APIKey") {
 		apikey := strings.TrimSpace(authToken[6:])
 		if apikey != "" {
-			var user User
-			if err := db.Where("api_key = ?", apikey).First(&user).Error; err != nil {
-				log.Debug("API key not found:", err)
+			user, err := UserFromAPIKey(apikey, clientip.FromRequest(request))
+			if err != nil {
+				log.Debug("API key authentication failed:", err)
 				return u
 			}
-			if !user.Anonymous() {
-				return &user
-			}
+			return user
 		}
 		return u
 	}
@@ -248,52 +278,27 @@ func (u *User) FromRequest(request *evo.Request) evo.UserInterface {
 		tokenString = tokenString[:idx]
 	}
 
-	// Debug: Check if secret is properly initialized
-	if len(JWTSecret) == 0 {
-		log.Error("JWT secret is not initialized!")
-		return u
-	}
-
-	jwtToken, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return JWTSecret, nil
-	})
-
+	user, err := UserFromToken(tokenString, request.Path())
 	if err != nil {
-		log.Debug("JWT parsing error:", err)
-		return u
-	}
-
-	if !jwtToken.Valid {
-		log.Debug("JWT token is not valid")
-		return u
-	}
-
-	claims, ok := jwtToken.Claims.(*Claims)
-	if !ok {
-		log.Debug("JWT claims parsing failed")
+		log.Debug("JWT authentication failed:", err)
 		return u
 	}
 
-	// Find user in database
-	var user User
-	if err := db.Where("id = ?", claims.UserID).First(&user).Error; err != nil {
-		log.Debug("User not found for claims:", claims.UserID)
-		return u
-	}
-
-	return &user
+	return user
 }
 
 // Password and JWT utilities
+// SetPassword checks the password against the password policy and hashes it
 func (u *User) SetPassword(password string) error {
+	if err := u.ValidatePassword(password); err != nil {
+		return err
+	}
 	hash, err := passlib.Hash(password)
 	if err != nil {
 		return err
 	}
 	u.PasswordHash = &hash
+	u.passwordChanged = true
 	return nil
 }
 
@@ -305,27 +310,14 @@ func (u *User) VerifyPassword(password string) bool {
 	return err == nil
 }
 
-// GenerateAPIKey creates a new API key for the user
-func (u *User) GenerateAPIKey() (string, error) {
-	// Generate a random 32-byte API key
-	bytes := make([]byte, 32)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
+func (u *User) GenerateJWT() (string, error) {
+	// Every login starts a server-side session that can be revoked
+	if u.sessionID == "" {
+		if err := u.startSession(); err != nil {
+			return "", err
+		}
 	}
 
-	// Convert to hex string and add prefix for easy identification
-	apiKey := "homa_" + hex.EncodeToString(bytes)
-	u.APIKey = &apiKey
-
-	return apiKey, nil
-}
-
-// ClearAPIKey removes the API key from the user
-func (u *User) ClearAPIKey() {
-	u.APIKey = nil
-}
-
-func (u *User) GenerateJWT() (string, error) {
 	// Load departments for this user - will need to import from models package later
 	var departments []interface{} // Placeholder for now
 
@@ -335,13 +327,18 @@ func (u *User) GenerateJWT() (string, error) {
 	// }
 
 	claims := Claims{
-		UserID:      u.UserID.String(),
-		Email:       u.Email,
-		Name:        u.GetFullName(),
-		Type:        u.Type,
-		Departments: departmentNames,
+		UserID:            u.UserID.String(),
+		Email:             u.Email,
+		Name:              u.GetFullName(),
+		Type:              u.Type,
+		Departments:       departmentNames,
+		TwoFactorVerified: u.twoFactorVerified,
+		TwoFactorSetup:    !u.TwoFactorEnabled && u.RequiresTwoFactor(),
+		SessionID:         u.sessionID,
+		WorkspaceID:       u.WorkspaceID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
@@ -351,11 +348,30 @@ func (u *User) GenerateJWT() (string, error) {
 	return token.SignedString(JWTSecret)
 }
 
+// GenerateRefreshToken issues the refresh token of the session; it replaces every earlier refresh token of the session
 func (u *User) GenerateRefreshToken() (string, error) {
+	if u.sessionID == "" {
+		if err := u.startSession(); err != nil {
+			return "", err
+		}
+	}
+	tokenID := u.refreshTokenID
+	if tokenID == "" {
+		var err error
+		if tokenID, err = u.rotateSession(); err != nil {
+			return "", err
+		}
+	}
+	u.refreshTokenID = ""
+
 	claims := Claims{
-		UserID: u.UserID.String(),
+		UserID:            u.UserID.String(),
+		TwoFactorVerified: u.twoFactorVerified,
+		Purpose:           refreshTokenPurpose,
+		SessionID:         u.sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(RefreshTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
@@ -367,7 +383,7 @@ func (u *User) GenerateRefreshToken() (string, error) {
 
 // Login creates a login history record
 func (u *User) RecordLogin(request *evo.Request, success bool, reason string) {
-	ip := request.IP()
+	ip := clientip.FromRequest(request)
 	if ip == "" {
 		ip = "unknown"
 	}
@@ -385,7 +401,7 @@ func (u *User) RecordLogin(request *evo.Request, success bool, reason string) {
 		Reason:    reason,
 	}
 
-	db.Create(&history)
+	db.GetContext(request).Create(&history)
 }
 
 func (UserLoginHistory) TableName() string {
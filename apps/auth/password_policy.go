@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"github.com/hlandau/passlib"
+	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/gorm"
+)
+
+// PasswordMaxLength bounds the work of hashing a password
+const PasswordMaxLength = 128
+
+//go:embed breached_passwords.txt
+var embeddedBreachedPasswords string
+
+var (
+	breachedPasswords     map[string]struct{}
+	breachedPasswordsOnce sync.Once
+)
+
+// PasswordPolicyError is returned by SetPassword when a password does not meet the policy
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Reason
+}
+
+// UserPasswordHistory keeps the hashes of earlier passwords so they are not reused
+type UserPasswordHistory struct {
+	ID           uint      `gorm:"column:id;primaryKey" json:"id"`
+	UserID       uuid.UUID `gorm:"column:user_id;type:char(36);not null;index;fk:users" json:"user_id"`
+	PasswordHash string    `gorm:"column:password_hash;size:255;not null" json:"-"`
+	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+
+	restify.API
+}
+
+func (UserPasswordHistory) TableName() string {
+	return "user_password_history"
+}
+
+// PasswordPolicy is the configured password policy
+type PasswordPolicy struct {
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length"`
+	History   int `json:"history"` // number of earlier passwords that cannot be reused
+}
+
+// GetPasswordPolicy reads the password policy settings
+func GetPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: settings.Get("AUTH.PASSWORD_MIN_LENGTH", 10).Int(),
+		MaxLength: PasswordMaxLength,
+		History:   settings.Get("AUTH.PASSWORD_HISTORY", 5).Int(),
+	}
+}
+
+// ValidatePassword checks a new password of the user against the password policy
+func (u *User) ValidatePassword(password string) error {
+	policy := GetPasswordPolicy()
+
+	length := len([]rune(password))
+	if length < policy.MinLength {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("Password must be at least %d characters long", policy.MinLength)}
+	}
+	if length > policy.MaxLength {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("Password must be at most %d characters long", policy.MaxLength)}
+	}
+	if u.Email != "" && strings.EqualFold(password, u.Email) {
+		return &PasswordPolicyError{Reason: "Password must not be the email address"}
+	}
+	if isBreachedPassword(password) {
+		return &PasswordPolicyError{Reason: "This password appears in a list of breached passwords. Please choose another one."}
+	}
+
+	if policy.History > 0 && !u.Anonymous() {
+		var hashes []string
+		db.Model(&UserPasswordHistory{}).Where("user_id = ?", u.UserID).
+			Order("created_at DESC").Limit(policy.History).Pluck("password_hash", &hashes)
+		if u.PasswordHash != nil {
+			hashes = append(hashes, *u.PasswordHash)
+		}
+		for _, hash := range hashes {
+			if _, err := passlib.Verify(password, hash); err == nil {
+				return &PasswordPolicyError{Reason: fmt.Sprintf("Password must differ from the last %d passwords", policy.History)}
+			}
+		}
+	}
+	return nil
+}
+
+// recordPasswordHistory stores the hash set by SetPassword and drops the entries the policy no longer needs
+func (u *User) recordPasswordHistory(tx *gorm.DB) {
+	if !u.passwordChanged || u.PasswordHash == nil {
+		return
+	}
+	u.passwordChanged = false
+
+	history := UserPasswordHistory{UserID: u.UserID, PasswordHash: *u.PasswordHash}
+	if err := tx.Session(&gorm.Session{NewDB: true}).Create(&history).Error; err != nil {
+		log.Warning("Failed to record password history of user %s: %v", u.UserID, err)
+		return
+	}
+
+	keep := max(GetPasswordPolicy().History, 1)
+	var stale []uint
+	tx.Session(&gorm.Session{NewDB: true}).Model(&UserPasswordHistory{}).Where("user_id = ?", u.UserID).
+		Order("created_at DESC").Offset(keep).Pluck("id", &stale)
+	if len(stale) > 0 {
+		tx.Session(&gorm.Session{NewDB: true}).Where("id IN ?", stale).Delete(&UserPasswordHistory{})
+	}
+}
+
+// isBreachedPassword reports whether password is in the embedded or the configured breached password list
+func isBreachedPassword(password string) bool {
+	breachedPasswordsOnce.Do(loadBreachedPasswords)
+	_, found := breachedPasswords[strings.ToLower(password)]
+	return found
+}
+
+// loadBreachedPasswords reads the embedded list and the file in AUTH.BREACHED_PASSWORDS_FILE
+func loadBreachedPasswords() {
+	breachedPasswords = map[string]struct{}{}
+	readPasswordList(strings.NewReader(embeddedBreachedPasswords))
+
+	path := settings.Get("AUTH.BREACHED_PASSWORDS_FILE").String()
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Warning("Failed to open breached password list %s: %v", path, err)
+		return
+	}
+	defer file.Close()
+	readPasswordList(file)
+	log.Info("Loaded %d breached passwords", len(breachedPasswords))
+}
+
+func readPasswordList(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breachedPasswords[strings.ToLower(line)] = struct{}{}
+	}
+}
+
+// PasswordErrorResponse returns the API error for a password SetPassword rejected
+func PasswordErrorResponse(err error) interface{} {
+	var policyErr *PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Password does not meet the password policy", 400, policyErr.Reason))
+	}
+	return response.Error(response.ErrInternalError)
+}
@@ -0,0 +1,132 @@
+package auth
+
+import "strings"
+
+// Permission constants. A role holding "*" has every permission and "<group>.*" grants every permission of a group.
+const (
+	PermissionAll = "*"
+
+	PermissionConversationsView   = "conversations.view"
+	PermissionConversationsReply  = "conversations.reply"
+	PermissionConversationsAssign = "conversations.assign"
+	PermissionConversationsDelete = "conversations.delete"
+	PermissionTicketsManage       = "tickets.manage"
+
+	PermissionClientsView   = "clients.view"
+	PermissionClientsEdit   = "clients.edit"
+	PermissionClientsDelete = "clients.delete"
+	PermissionClientsMerge  = "clients.merge"
+	PermissionClientsExport = "clients.export"
+
+	PermissionKBView    = "kb.view"
+	PermissionKBEdit    = "kb.edit"
+	PermissionKBPublish = "kb.publish"
+	PermissionRAGManage = "rag.manage"
+
+	PermissionUsersManage        = "users.manage"
+	PermissionRolesManage        = "roles.manage"
+	PermissionDepartmentsManage  = "departments.manage"
+	PermissionTagsCreate         = "tags.create"
+	PermissionTagsManage         = "tags.manage"
+	PermissionAttributesManage   = "attributes.manage"
+	PermissionChannelsManage     = "channels.manage"
+	PermissionInboxesManage      = "inboxes.manage"
+	PermissionIntegrationsManage = "integrations.manage"
+	PermissionCannedManage       = "canned_messages.manage"
+	PermissionMacrosManage       = "macros.manage"
+	PermissionSpamManage         = "spam.manage"
+	PermissionCampaignsManage    = "campaigns.manage"
+
+	PermissionWebhooksManage = "webhooks.manage"
+	PermissionAIAgentsEdit   = "ai_agents.edit"
+	PermissionReportsView    = "reports.view"
+	PermissionSettingsManage = "settings.manage"
+	PermissionJobsManage     = "jobs.manage"
+
+	PermissionWorkspacesManage = "workspaces.manage"
+)
+
+// Permissions lists every named permission with a short description
+var Permissions = map[string]string{
+	PermissionConversationsView:   "View conversations, tickets, canned messages and macros",
+	PermissionConversationsReply:  "Reply to conversations and use canned messages and macros",
+	PermissionConversationsAssign: "Assign conversations to agents and departments",
+	PermissionConversationsDelete: "Delete conversations and messages",
+	PermissionTicketsManage:       "Manage tickets from the admin panel",
+	PermissionClientsView:         "View clients and organizations",
+	PermissionClientsEdit:         "Create and edit clients, organizations and their attributes",
+	PermissionClientsDelete:       "Delete clients",
+	PermissionClientsMerge:        "Merge clients and review duplicates",
+	PermissionClientsExport:       "Export client data",
+	PermissionKBView:              "View knowledge base articles and search the knowledge base",
+	PermissionKBEdit:              "Create and edit knowledge base articles, categories and tags",
+	PermissionKBPublish:           "Publish knowledge base articles",
+	PermissionRAGManage:           "Manage the knowledge base search index",
+	PermissionUsersManage:         "Manage users",
+	PermissionRolesManage:         "Manage roles and role assignments",
+	PermissionDepartmentsManage:   "Manage departments",
+	PermissionTagsCreate:          "Create conversation tags",
+	PermissionTagsManage:          "Manage conversation tags",
+	PermissionAttributesManage:    "Manage custom attribute definitions",
+	PermissionChannelsManage:      "Manage channels",
+	PermissionInboxesManage:       "Manage inboxes",
+	PermissionIntegrationsManage:  "Manage integrations",
+	PermissionCannedManage:        "Review and prune canned messages",
+	PermissionMacrosManage:        "Create, edit and delete macros",
+	PermissionSpamManage:          "Manage the blocklist and spam events",
+	PermissionCampaignsManage:     "Manage campaigns and opt-outs",
+	PermissionWebhooksManage:      "Manage webhooks and view deliveries",
+	PermissionAIAgentsEdit:        "Manage AI agents, their tools and the bot prompt template",
+	PermissionReportsView:         "View reports and activity logs",
+	PermissionSettingsManage:      "Manage system settings",
+	PermissionJobsManage:          "Configure and trigger background jobs",
+	PermissionWorkspacesManage:    "Create, rename and suspend workspaces (default workspace only)",
+}
+
+// Built-in role names. Users hold the built-in role of their type without an assignment.
+const (
+	RoleAdministrator = "administrator"
+	RoleAgent         = "agent"
+)
+
+// BuiltInRolePermissions are the permissions the built-in roles are created with
+var BuiltInRolePermissions = map[string][]string{
+	RoleAdministrator: {PermissionAll},
+	RoleAgent: {
+		PermissionConversationsView,
+		PermissionConversationsReply,
+		PermissionConversationsAssign,
+		PermissionClientsView,
+		PermissionClientsEdit,
+		PermissionTagsCreate,
+	},
+}
+
+// IsValidPermission reports whether permission is "*", a named permission or a "<group>.*" wildcard
+func IsValidPermission(permission string) bool {
+	if permission == PermissionAll {
+		return true
+	}
+	if _, ok := Permissions[permission]; ok {
+		return true
+	}
+	if group, ok := strings.CutSuffix(permission, ".*"); ok {
+		for name := range Permissions {
+			if strings.HasPrefix(name, group+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// permissionGranted reports whether granted covers permission
+func permissionGranted(granted, permission string) bool {
+	if granted == PermissionAll || granted == permission {
+		return true
+	}
+	if group, ok := strings.CutSuffix(granted, ".*"); ok {
+		return strings.HasPrefix(permission, group+".")
+	}
+	return false
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/lib/clientip"
+	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/gorm"
+)
+
+// RoleRequest represents the request structure for creating or updating a role
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	// RequireTwoFactor makes holders of the role enrol in two-factor authentication
+	RequireTwoFactor *bool `json:"require_two_factor"`
+}
+
+// UserRoleRequest represents the request structure for assigning a role to a user
+type UserRoleRequest struct {
+	RoleID       uint  `json:"role_id"`
+	DepartmentID *uint `json:"department_id"` // nil assigns the role globally
+}
+
+// PermissionInfo describes a named permission
+type PermissionInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ListPermissions returns every named permission
+func (c Controller) ListPermissions(request *evo.Request) interface{} {
+	permissions := make([]PermissionInfo, 0, len(Permissions))
+	for name, description := range Permissions {
+		permissions = append(permissions, PermissionInfo{Name: name, Description: description})
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].Name < permissions[j].Name
+	})
+	return response.OK(permissions)
+}
+
+// ListRoles returns all roles
+func (c Controller) ListRoles(request *evo.Request) interface{} {
+	var roles []Role
+	if err := db.GetContext(request).Order("is_built_in DESC, name ASC").Find(&roles).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	return response.OK(roles)
+}
+
+// CreateRole creates a custom role
+func (c Controller) CreateRole(request *evo.Request) interface{} {
+	user := request.User().Interface().(*User)
+
+	var req RoleRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid request body", 400))
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Role name is required", 400))
+	}
+	if err := validatePermissions(user, req.Permissions); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid permissions", 400, err.Error()))
+	}
+
+	var existing Role
+	if err := db.GetContext(request).Where("name = ?", req.Name).First(&existing).Error; err == nil {
+		return response.Error(response.NewError(response.ErrorCodeConflict, "A role with this name already exists", 409))
+	}
+
+	role := Role{Name: req.Name, Description: req.Description}
+	role.SetPermissions(req.Permissions)
+	if req.RequireTwoFactor != nil {
+		role.RequireTwoFactor = *req.RequireTwoFactor
+	}
+	if err := db.GetContext(request).Create(&role).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	auditRole(request, role.ID, RoleEventCreated, user, nil, roleValues(&role))
+
+	return response.Created(role)
+}
+
+// UpdateRole updates a role. Built-in roles keep their name and the administrator role keeps every permission.
+func (c Controller) UpdateRole(request *evo.Request) interface{} {
+	user := request.User().Interface().(*User)
+
+	var role Role
+	if err := db.GetContext(request).First(&role, request.Param("id").Uint()).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "Role not found", 404))
+	}
+	oldValues := roleValues(&role)
+
+	var req RoleRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid request body", 400))
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name != "" && req.Name != role.Name {
+		if role.IsBuiltIn {
+			return response.Error(response.NewError(response.ErrorCodeForbidden, "Built-in roles cannot be renamed", 403))
+		}
+		var existing Role
+		if err := db.GetContext(request).Where("name = ? AND id != ?", req.Name, role.ID).First(&existing).Error; err == nil {
+			return response.Error(response.NewError(response.ErrorCodeConflict, "A role with this name already exists", 409))
+		}
+		role.Name = req.Name
+	}
+	if req.Description != "" {
+		role.Description = req.Description
+	}
+	if req.Permissions != nil {
+		if role.Name == RoleAdministrator && role.IsBuiltIn {
+			return response.Error(response.NewError(response.ErrorCodeForbidden, "The administrator role always has every permission", 403))
+		}
+		if err := validatePermissions(user, req.Permissions); err != nil {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid permissions", 400, err.Error()))
+		}
+		role.SetPermissions(req.Permissions)
+	}
+	if req.RequireTwoFactor != nil {
+		role.RequireTwoFactor = *req.RequireTwoFactor
+	}
+
+	if err := db.GetContext(request).Save(&role).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	auditRole(request, role.ID, RoleEventUpdated, user, oldValues, roleValues(&role))
+
+	return response.OK(role)
+}
+
+// DeleteRole deletes a custom role and its assignments
+func (c Controller) DeleteRole(request *evo.Request) interface{} {
+	user := request.User().Interface().(*User)
+
+	var role Role
+	if err := db.GetContext(request).First(&role, request.Param("id").Uint()).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "Role not found", 404))
+	}
+	if role.IsBuiltIn {
+		return response.Error(response.NewError(response.ErrorCodeForbidden, "Built-in roles cannot be deleted", 403))
+	}
+
+	err := db.GetContext(request).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", role.ID).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	auditRole(request, role.ID, RoleEventDeleted, user, roleValues(&role), nil)
+
+	return response.OKWithMessage(nil, "Role deleted successfully")
+}
+
+// ListUserRoles returns the roles assigned to a user
+func (c Controller) ListUserRoles(request *evo.Request) interface{} {
+	id, err := uuid.Parse(request.Param("id").String())
+	if err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user ID format", 400))
+	}
+
+	var assignments []UserRole
+	if err := db.GetContext(request).Preload("Role").Where("user_id = ?", id).Order("id ASC").Find(&assignments).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+
+	return response.OK(assignments)
+}
+
+// AssignUserRole assigns a role to a user, globally or within one department
+func (c Controller) AssignUserRole(request *evo.Request) interface{} {
+	user := request.User().Interface().(*User)
+
+	id, err := uuid.Parse(request.Param("id").String())
+	if err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user ID format", 400))
+	}
+
+	var req UserRoleRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid request body", 400))
+	}
+
+	var targetUser User
+	if err := db.GetContext(request).Where("id = ?", id).First(&targetUser).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "User not found", 404))
+	}
+
+	var role Role
+	if err := db.GetContext(request).First(&role, req.RoleID).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "Role not found", 404))
+	}
+
+	// Nobody can hand out permissions they do not hold themselves
+	for _, permission := range role.GetPermissions() {
+		if !user.HasPermission(permission) {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "Not allowed", 403, fmt.Sprintf("You do not hold the %s permission", permission)))
+		}
+	}
+
+	if req.DepartmentID != nil {
+		var count int64
+		// Table() bypasses the workspace scope, so the department must be checked against the user's workspace explicitly
+		db.GetContext(request).Table("departments").Where("id = ? AND workspace_id = ?", *req.DepartmentID, targetUser.WorkspaceID).Count(&count)
+		if count == 0 {
+			return response.Error(response.NewError(response.ErrorCodeNotFound, "Department not found", 404))
+		}
+	}
+
+	query := db.GetContext(request).Where("user_id = ? AND role_id = ?", id, role.ID)
+	if req.DepartmentID == nil {
+		query = query.Where("department_id IS NULL")
+	} else {
+		query = query.Where("department_id = ?", *req.DepartmentID)
+	}
+	var existing UserRole
+	if err := query.First(&existing).Error; err == nil {
+		return response.Error(response.NewError(response.ErrorCodeConflict, "The user already has this role", 409))
+	}
+
+	assignment := UserRole{UserID: id, RoleID: role.ID, DepartmentID: req.DepartmentID}
+	if err := db.GetContext(request).Create(&assignment).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	assignment.Role = &role
+	auditRole(request, role.ID, RoleEventAssigned, user, nil, map[string]any{"user_id": id.String(), "department_id": req.DepartmentID})
+
+	return response.Created(assignment)
+}
+
+// RemoveUserRole removes a role assignment from a user
+func (c Controller) RemoveUserRole(request *evo.Request) interface{} {
+	user := request.User().Interface().(*User)
+
+	id, err := uuid.Parse(request.Param("id").String())
+	if err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user ID format", 400))
+	}
+
+	var assignment UserRole
+	if err := db.GetContext(request).Where("id = ? AND user_id = ?", request.Param("assignment_id").Uint(), id).First(&assignment).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "Role assignment not found", 404))
+	}
+	if err := db.GetContext(request).Delete(&assignment).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	auditRole(request, assignment.RoleID, RoleEventUnassigned, user, map[string]any{"user_id": id.String(), "department_id": assignment.DepartmentID}, nil)
+
+	return response.OKWithMessage(nil, "Role removed successfully")
+}
+
+// auditRole writes a role change or assignment to the activity log
+func auditRole(request *evo.Request, roleID uint, action string, actor *User, oldValues, newValues map[string]any) {
+	if RoleAuditLogger != nil {
+		RoleAuditLogger(roleID, action, actor, oldValues, newValues, clientip.FromRequest(request), request.Header("User-Agent"))
+	}
+}
+
+// roleValues returns the audited fields of a role
+func roleValues(role *Role) map[string]any {
+	return map[string]any{
+		"name":               role.Name,
+		"description":        role.Description,
+		"permissions":        role.GetPermissions(),
+		"require_two_factor": role.RequireTwoFactor,
+	}
+}
+
+// validatePermissions checks that every permission is known and held by the user, so nobody can grant more than they have
+func validatePermissions(user *User, permissions []string) error {
+	for _, permission := range permissions {
+		if !IsValidPermission(permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+		if !user.HasPermission(permission) {
+			return fmt.Errorf("you do not hold the %s permission", permission)
+		}
+	}
+	return nil
+}
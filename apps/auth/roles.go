@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm/clause"
+)
+
+// Role is a named set of permissions
+type Role struct {
+	ID               uint           `gorm:"column:id;primaryKey" json:"id"`
+	Name             string         `gorm:"column:name;size:100;uniqueIndex;not null" json:"name"`
+	Description      string         `gorm:"column:description;size:500" json:"description"`
+	Permissions      datatypes.JSON `gorm:"column:permissions;type:json" json:"permissions"`
+	IsBuiltIn        bool           `gorm:"column:is_built_in;not null;default:false" json:"is_built_in"`
+	RequireTwoFactor bool           `gorm:"column:require_two_factor;not null;default:false" json:"require_two_factor"` // holders must enrol in two-factor authentication
+	CreatedAt        time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	restify.API
+}
+
+func (Role) TableName() string {
+	return "roles"
+}
+
+// Role audit events
+const (
+	RoleEventCreated    = "create"
+	RoleEventUpdated    = "update"
+	RoleEventDeleted    = "delete"
+	RoleEventAssigned   = "assign"
+	RoleEventUnassigned = "unassign"
+)
+
+// RoleAuditLogger is set by the models package to write role changes and assignments to the activity log
+var RoleAuditLogger func(roleID uint, action string, actor *User, oldValues, newValues map[string]any, ip, userAgent string)
+
+// UserRole assigns a role to a user, globally or within one department
+type UserRole struct {
+	ID           uint      `gorm:"column:id;primaryKey" json:"id"`
+	UserID       uuid.UUID `gorm:"column:user_id;type:char(36);not null;uniqueIndex:idx_user_role_scope;fk:users" json:"user_id"`
+	RoleID       uint      `gorm:"column:role_id;not null;uniqueIndex:idx_user_role_scope;index;fk:roles" json:"role_id"`
+	DepartmentID *uint     `gorm:"column:department_id;uniqueIndex:idx_user_role_scope;index" json:"department_id"` // nil for a global assignment
+	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+
+	// Relationships
+	Role *Role `gorm:"foreignKey:RoleID;references:ID" json:"role,omitempty"`
+
+	restify.API
+}
+
+func (UserRole) TableName() string {
+	return "user_roles"
+}
+
+// permissionGrant is one permission a user holds, globally or within a department
+type permissionGrant struct {
+	Permission   string
+	DepartmentID *uint
+}
+
+// GetPermissions decodes the role permissions
+func (r *Role) GetPermissions() []string {
+	var permissions []string
+	if len(r.Permissions) > 0 {
+		json.Unmarshal(r.Permissions, &permissions)
+	}
+	return permissions
+}
+
+// SetPermissions encodes the role permissions
+func (r *Role) SetPermissions(permissions []string) {
+	if permissions == nil {
+		permissions = []string{}
+	}
+	r.Permissions, _ = json.Marshal(permissions)
+}
+
+// BuiltInRoleName returns the built-in role a user holds because of their type
+func (u *User) BuiltInRoleName() string {
+	if u.Type == UserTypeAdministrator {
+		return RoleAdministrator
+	}
+	return RoleAgent
+}
+
+// HasPermission reports whether the user holds permission globally
+func (u *User) HasPermission(permission string) bool {
+	if !u.scopeAllows(permission) {
+		return false
+	}
+	for _, grant := range u.permissionGrants() {
+		if grant.DepartmentID == nil && permissionGranted(grant.Permission, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasDepartmentPermission reports whether the user holds permission globally or within the department
+func (u *User) HasDepartmentPermission(permission string, departmentID uint) bool {
+	if !u.scopeAllows(permission) {
+		return false
+	}
+	for _, grant := range u.permissionGrants() {
+		if (grant.DepartmentID == nil || *grant.DepartmentID == departmentID) && permissionGranted(grant.Permission, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermissionInAnyScope reports whether the user holds permission globally or within at least one department
+func (u *User) HasPermissionInAnyScope(permission string) bool {
+	if !u.scopeAllows(permission) {
+		return false
+	}
+	for _, grant := range u.permissionGrants() {
+		if permissionGranted(grant.Permission, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionDepartmentIDs returns the departments in which the user holds permission through a department-scoped role
+func (u *User) PermissionDepartmentIDs(permission string) []uint {
+	if !u.scopeAllows(permission) {
+		return nil
+	}
+	var departmentIDs []uint
+	for _, grant := range u.permissionGrants() {
+		if grant.DepartmentID != nil && permissionGranted(grant.Permission, permission) {
+			departmentIDs = append(departmentIDs, *grant.DepartmentID)
+		}
+	}
+	return departmentIDs
+}
+
+// RequiresTwoFactor reports whether the built-in role or an assigned role of the user requires two-factor authentication
+func (u *User) RequiresTwoFactor() bool {
+	if u.Anonymous() {
+		return false
+	}
+	var count int64
+	assigned := db.Model(&UserRole{}).Select("role_id").Where("user_id = ?", u.UserID)
+	db.Model(&Role{}).
+		Where("require_two_factor = ? AND (name = ? OR id IN (?))", true, u.BuiltInRoleName(), assigned).
+		Count(&count)
+	return count > 0
+}
+
+// permissionGrants loads the permissions of the user's built-in role and assigned roles once per user value
+func (u *User) permissionGrants() []permissionGrant {
+	if u.grantsLoaded || u.Anonymous() {
+		return u.grants
+	}
+	u.grantsLoaded = true
+
+	var builtIn Role
+	if err := db.Where("name = ?", u.BuiltInRoleName()).First(&builtIn).Error; err == nil {
+		for _, permission := range builtIn.GetPermissions() {
+			u.grants = append(u.grants, permissionGrant{Permission: permission})
+		}
+	} else {
+		// Roles are not seeded yet; fall back to the defaults so nobody is locked out
+		for _, permission := range BuiltInRolePermissions[u.BuiltInRoleName()] {
+			u.grants = append(u.grants, permissionGrant{Permission: permission})
+		}
+	}
+
+	var assignments []UserRole
+	if err := db.Preload("Role").Where("user_id = ?", u.UserID).Find(&assignments).Error; err != nil {
+		log.Warning("Failed to load roles of user %s: %v", u.UserID, err)
+		return u.grants
+	}
+	for _, assignment := range assignments {
+		if assignment.Role == nil {
+			continue
+		}
+		for _, permission := range assignment.Role.GetPermissions() {
+			u.grants = append(u.grants, permissionGrant{Permission: permission, DepartmentID: assignment.DepartmentID})
+		}
+	}
+	return u.grants
+}
+
+// SeedBuiltInRoles creates the built-in roles if they do not exist. Existing roles keep their permissions.
+func SeedBuiltInRoles() {
+	for name, permissions := range BuiltInRolePermissions {
+		role := Role{Name: name, Description: "Built-in " + name + " role", IsBuiltIn: true}
+		role.SetPermissions(permissions)
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&role).Error; err != nil {
+			log.Warning("Failed to seed built-in role %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/getevo/restify"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SSO provider type constants
+const (
+	SSOProviderTypeOIDC = "oidc"
+	SSOProviderTypeSAML = "saml"
+)
+
+// SSOStateTTL is how long a user may take to sign in at the identity provider
+const SSOStateTTL = 10 * time.Minute
+
+const ssoStatePurpose = "sso"
+
+var (
+	// ErrSSOUserNotFound is returned when no user matches the identity and provisioning is off
+	ErrSSOUserNotFound = errors.New("no user exists for this identity")
+	// ErrSSOEmailMissing is returned when the identity provider sent no usable email address
+	ErrSSOEmailMissing = errors.New("the identity provider did not send an email address")
+	// ErrSSODomainNotAllowed is returned when the email domain is not allowed for provisioning
+	ErrSSODomainNotAllowed = errors.New("email domain is not allowed for this provider")
+	// ErrSSOUserBlocked is returned when the matched user is blocked
+	ErrSSOUserBlocked = errors.New("your account has been blocked")
+)
+
+// SSOProvider is a generic OpenID Connect or SAML 2.0 identity provider.
+// Claim fields name the OIDC claims or SAML attributes mapped onto the user.
+type SSOProvider struct {
+	ID      uint   `gorm:"column:id;primaryKey" json:"id"`
+	Slug    string `gorm:"column:slug;size:100;uniqueIndex;not null" json:"slug"`
+	Name    string `gorm:"column:name;size:255;not null" json:"name"`
+	Type    string `gorm:"column:type;size:10;not null;check:type IN ('oidc','saml')" json:"type"`
+	Enabled bool   `gorm:"column:enabled;not null;default:true" json:"enabled"`
+
+	// OpenID Connect
+	IssuerURL    string `gorm:"column:issuer_url;size:500" json:"issuer_url"`
+	ClientID     string `gorm:"column:client_id;size:255" json:"client_id"`
+	ClientSecret string `gorm:"column:client_secret;size:500" json:"client_secret,omitempty"`
+	Scopes       string `gorm:"column:scopes;size:500" json:"scopes"` // space separated, "openid email profile" by default
+
+	// SAML 2.0
+	IDPMetadataURL string `gorm:"column:idp_metadata_url;size:500" json:"idp_metadata_url"`
+	IDPMetadataXML string `gorm:"column:idp_metadata_xml;type:text" json:"idp_metadata_xml,omitempty"`
+	SPCertificate  string `gorm:"column:sp_certificate;type:text" json:"sp_certificate"`
+	SPPrivateKey   string `gorm:"column:sp_private_key;type:text" json:"-"`
+
+	// Claim mapping
+	EmailClaim       string `gorm:"column:email_claim;size:255" json:"email_claim"`
+	FirstNameClaim   string `gorm:"column:first_name_claim;size:255" json:"first_name_claim"`
+	LastNameClaim    string `gorm:"column:last_name_claim;size:255" json:"last_name_claim"`
+	DisplayNameClaim string `gorm:"column:display_name_claim;size:255" json:"display_name_claim"`
+	DepartmentClaim  string `gorm:"column:department_claim;size:255" json:"department_claim"` // values are matched to department names
+
+	// Just-in-time provisioning
+	AutoProvision  bool   `gorm:"column:auto_provision;not null;default:false" json:"auto_provision"`
+	AllowedDomains string `gorm:"column:allowed_domains;size:1000" json:"allowed_domains"` // comma separated, empty allows every domain
+
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	restify.API
+}
+
+func (SSOProvider) TableName() string {
+	return "sso_providers"
+}
+
+// UserSSOIdentity links a user to the subject an identity provider knows them by
+type UserSSOIdentity struct {
+	ID         uint      `gorm:"column:id;primaryKey" json:"id"`
+	UserID     uuid.UUID `gorm:"column:user_id;type:char(36);not null;index;fk:users" json:"user_id"`
+	ProviderID uint      `gorm:"column:provider_id;not null;uniqueIndex:idx_sso_identity_subject;fk:sso_providers" json:"provider_id"`
+	Subject    string    `gorm:"column:subject;size:255;not null;uniqueIndex:idx_sso_identity_subject" json:"subject"`
+	LastLogin  time.Time `gorm:"column:last_login" json:"last_login"`
+	CreatedAt  time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+
+	restify.API
+}
+
+func (UserSSOIdentity) TableName() string {
+	return "user_sso_identities"
+}
+
+// SSOIdentity is the user information an identity provider asserted
+type SSOIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+	DisplayName   string
+	Departments   []string
+}
+
+// ssoStateClaims carry the login attempt through the identity provider
+type ssoStateClaims struct {
+	ProviderID  uint   `json:"pid"`
+	Nonce       string `json:"nonce"` // OIDC nonce or SAML request ID
+	RedirectURL string `json:"redirect"`
+	Purpose     string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// BaseURL returns the public URL the identity provider redirects back to
+func (p *SSOProvider) BaseURL() string {
+	return strings.TrimRight(settings.Get("APP.BASE_PATH", "http://localhost:8000").String(), "/") + "/api/auth/sso/" + p.Slug
+}
+
+// Validate checks the provider configuration
+func (p *SSOProvider) Validate() error {
+	p.Slug = strings.ToLower(strings.TrimSpace(p.Slug))
+	if p.Slug == "" || strings.ContainsAny(p.Slug, "/?#& ") {
+		return fmt.Errorf("slug is required and may not contain spaces or URL delimiters")
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch p.Type {
+	case SSOProviderTypeOIDC:
+		if p.IssuerURL == "" || p.ClientID == "" {
+			return fmt.Errorf("issuer_url and client_id are required for OpenID Connect providers")
+		}
+	case SSOProviderTypeSAML:
+		if p.IDPMetadataURL == "" && p.IDPMetadataXML == "" {
+			return fmt.Errorf("idp_metadata_url or idp_metadata_xml is required for SAML providers")
+		}
+	default:
+		return fmt.Errorf("type must be oidc or saml")
+	}
+	return nil
+}
+
+// ApplyDefaults fills in the default claim names of the provider type
+func (p *SSOProvider) ApplyDefaults() {
+	if p.Type == SSOProviderTypeOIDC {
+		if p.Scopes == "" {
+			p.Scopes = "openid email profile"
+		}
+		setDefault(&p.EmailClaim, "email")
+		setDefault(&p.FirstNameClaim, "given_name")
+		setDefault(&p.LastNameClaim, "family_name")
+		setDefault(&p.DisplayNameClaim, "name")
+		return
+	}
+	setDefault(&p.EmailClaim, "email")
+	setDefault(&p.FirstNameClaim, "firstName")
+	setDefault(&p.LastNameClaim, "lastName")
+	setDefault(&p.DisplayNameClaim, "displayName")
+}
+
+// EnsureSPCertificate generates the key pair the SAML service provider signs with
+func (p *SSOProvider) EnsureSPCertificate() error {
+	if p.Type != SSOProviderTypeSAML || (p.SPCertificate != "" && p.SPPrivateKey != "") {
+		return nil
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return err
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: p.Slug + " SAML service provider"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	p.SPCertificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
+	p.SPPrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	return nil
+}
+
+// emailDomainAllowed reports whether the email may be provisioned by the provider
+func (p *SSOProvider) emailDomainAllowed(email string) bool {
+	if strings.TrimSpace(p.AllowedDomains) == "" {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range strings.Split(p.AllowedDomains, ",") {
+		if strings.ToLower(strings.TrimSpace(allowed)) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveSSOUser finds or provisions the user of an identity and links the identity to them.
+// Users are matched by the linked subject first and by verified email second.
+func ResolveSSOUser(provider *SSOProvider, identity *SSOIdentity) (*User, error) {
+	identity.Email = strings.ToLower(strings.TrimSpace(identity.Email))
+
+	var user User
+	var link UserSSOIdentity
+	err := db.Where("provider_id = ? AND subject = ?", provider.ID, identity.Subject).First(&link).Error
+	switch {
+	case err == nil:
+		if err := db.Where("id = ?", link.UserID).First(&user).Error; err != nil {
+			return nil, ErrSSOUserNotFound
+		}
+	case identity.Email == "":
+		return nil, ErrSSOEmailMissing
+	case !identity.EmailVerified:
+		// An unverified address must not take over an existing account
+		return nil, ErrSSOEmailMissing
+	default:
+		if err := db.Where("email = ?", identity.Email).First(&user).Error; err != nil {
+			if !provider.AutoProvision {
+				return nil, ErrSSOUserNotFound
+			}
+			if !provider.emailDomainAllowed(identity.Email) {
+				return nil, ErrSSODomainNotAllowed
+			}
+			if err := provisionSSOUser(&user, identity); err != nil {
+				return nil, err
+			}
+			log.Info("[sso] provisioned user %s from provider %s", user.Email, provider.Slug)
+		}
+	}
+
+	if user.Status == UserStatusBlocked {
+		return nil, ErrSSOUserBlocked
+	}
+
+	now := time.Now()
+	if link.ID == 0 {
+		link = UserSSOIdentity{UserID: user.UserID, ProviderID: provider.ID, Subject: identity.Subject, LastLogin: now}
+		if err := db.Create(&link).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		db.Model(&link).UpdateColumn("last_login", now)
+	}
+
+	assignSSODepartments(&user, identity.Departments)
+	return &user, nil
+}
+
+// provisionSSOUser creates an agent for an identity that has no account yet
+func provisionSSOUser(user *User, identity *SSOIdentity) error {
+	localPart := identity.Email
+	if at := strings.Index(localPart, "@"); at > 0 {
+		localPart = localPart[:at]
+	}
+	*user = User{
+		Name:        firstNonEmpty(identity.FirstName, localPart),
+		LastName:    firstNonEmpty(identity.LastName, "-"),
+		DisplayName: firstNonEmpty(identity.DisplayName, strings.TrimSpace(identity.FirstName+" "+identity.LastName), localPart),
+		Email:       identity.Email,
+		Type:        UserTypeAgent,
+		Status:      UserStatusActive,
+	}
+	return db.Create(user).Error
+}
+
+// assignSSODepartments adds the user to the departments named by the identity provider. Existing memberships are kept.
+func assignSSODepartments(user *User, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	var departmentIDs []uint
+	if err := db.Table("departments").Where("workspace_id = ? AND name IN ?", user.WorkspaceID, names).Pluck("id", &departmentIDs).Error; err != nil {
+		log.Warning("[sso] failed to look up departments for %s: %v", user.Email, err)
+		return
+	}
+	for _, departmentID := range departmentIDs {
+		err := db.Exec("INSERT IGNORE INTO user_departments (user_id, department_id) VALUES (?, ?)", user.UserID, departmentID).Error
+		if err != nil {
+			log.Warning("[sso] failed to add %s to department %d: %v", user.Email, departmentID, err)
+		}
+	}
+}
+
+// generateSSOState signs the state that travels through the identity provider
+func generateSSOState(provider *SSOProvider, nonce, redirectURL string) (string, error) {
+	claims := ssoStateClaims{
+		ProviderID:  provider.ID,
+		Nonce:       nonce,
+		RedirectURL: redirectURL,
+		Purpose:     ssoStatePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(SSOStateTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JWTSecret)
+}
+
+// parseSSOState verifies the state returned by the identity provider
+func parseSSOState(provider *SSOProvider, state string) (*ssoStateClaims, error) {
+	claims := &ssoStateClaims{}
+	token, err := jwt.ParseWithClaims(state, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return JWTSecret, nil
+	})
+	if err != nil || !token.Valid || claims.Purpose != ssoStatePurpose || claims.ProviderID != provider.ID {
+		return nil, fmt.Errorf("invalid or expired login state")
+	}
+	return claims, nil
+}
+
+// IsAllowedSSORedirect reports whether the login result may be sent to redirectURL.
+// Tokens travel in the redirect, so only relative URLs and the application's own host are allowed.
+func IsAllowedSSORedirect(redirectURL string) bool {
+	target, err := url.Parse(redirectURL)
+	if err != nil {
+		return false
+	}
+	if target.Scheme == "" && target.Host == "" {
+		return strings.HasPrefix(redirectURL, "/") && !strings.HasPrefix(redirectURL, "//")
+	}
+	base, err := url.Parse(settings.Get("APP.BASE_PATH", "http://localhost:8000").String())
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(target.Host, base.Host) && (target.Scheme == "http" || target.Scheme == "https")
+}
+
+// GetSSOProvider loads an enabled provider by slug
+func GetSSOProvider(slug string) (*SSOProvider, error) {
+	var provider SSOProvider
+	if err := db.Where("slug = ? AND enabled = ?", slug, true).First(&provider).Error; err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// AfterDelete removes the identities linked through the provider
+func (p *SSOProvider) AfterDelete(tx *gorm.DB) error {
+	forgetSSOProvider(p.ID)
+	return tx.Where("provider_id = ?", p.ID).Delete(&UserSSOIdentity{}).Error
+}
+
+// AfterUpdate drops the cached discovery document and metadata of the provider
+func (p *SSOProvider) AfterUpdate(tx *gorm.DB) error {
+	forgetSSOProvider(p.ID)
+	return nil
+}
+
+// claimStrings returns a claim as a list of strings; single values and lists are both accepted
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func setDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// SSOProviderInfo is the public description of an enabled SSO provider
+type SSOProviderInfo struct {
+	Slug     string `json:"slug"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	LoginURL string `json:"login_url"`
+}
+
+// ListSSOProviders returns the enabled SSO providers for the login page
+// @Summary Get SSO providers
+// @Description List the enabled OpenID Connect and SAML providers with their login URLs
+// @Tags OAuth
+// @Produce json
+// @Success 200 {array} SSOProviderInfo
+// @Router /auth/sso/providers [get]
+func (c Controller) ListSSOProviders(req *evo.Request) interface{} {
+	var providers []SSOProvider
+	if err := db.GetContext(req).Where("enabled = ?", true).Order("name ASC").Find(&providers).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+
+	list := make([]SSOProviderInfo, 0, len(providers))
+	for _, provider := range providers {
+		list = append(list, SSOProviderInfo{
+			Slug:     provider.Slug,
+			Name:     provider.Name,
+			Type:     provider.Type,
+			LoginURL: "/api/auth/sso/" + provider.Slug + "/login",
+		})
+	}
+	return response.List(list, len(list))
+}
+
+// SSOLogin redirects to the identity provider
+// @Summary Start SSO login
+// @Description Redirects to the OpenID Connect or SAML identity provider
+// @Tags OAuth
+// @Param slug path string true "Provider slug"
+// @Param redirect_url query string true "URL to redirect after login, relative or on the application host"
+// @Router /auth/sso/{slug}/login [get]
+func (c Controller) SSOLogin(req *evo.Request) interface{} {
+	redirectURL := req.Query("redirect_url").String()
+	if redirectURL == "" {
+		redirectURL = "/dashboard/login.html"
+	}
+	if !IsAllowedSSORedirect(redirectURL) {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "redirect_url must be relative or on the application host", 400))
+	}
+
+	provider, err := GetSSOProvider(req.Param("slug").String())
+	if err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "SSO provider not found", 404))
+	}
+
+	var loginURL string
+	if provider.Type == SSOProviderTypeOIDC {
+		loginURL, err = provider.OIDCLoginURL(redirectURL)
+	} else {
+		loginURL, err = provider.SAMLLoginURL(req.Context.Context(), redirectURL)
+	}
+	if err != nil {
+		log.Error("[sso] failed to start login with %s: %v", provider.Slug, err)
+		return req.Redirect(redirectURL + "?oauth=error&message=" + url.QueryEscape("Failed to contact the identity provider"))
+	}
+	return req.Redirect(loginURL)
+}
+
+// SSOCallback completes an OpenID Connect login
+// @Summary Handle OpenID Connect callback
+// @Tags OAuth
+// @Param slug path string true "Provider slug"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State parameter"
+// @Router /auth/sso/{slug}/callback [get]
+func (c Controller) SSOCallback(req *evo.Request) interface{} {
+	provider, err := GetSSOProvider(req.Param("slug").String())
+	if err != nil || provider.Type != SSOProviderTypeOIDC {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "SSO provider not found", 404))
+	}
+
+	if errorCode := req.Query("error").String(); errorCode != "" {
+		stateClaims, err := parseSSOState(provider, req.Query("state").String())
+		if err != nil {
+			return response.Error(response.ErrInvalidToken)
+		}
+		return req.Redirect(stateClaims.RedirectURL + "?oauth=error&message=" + url.QueryEscape("Login was cancelled at the identity provider"))
+	}
+
+	identity, redirectURL, err := provider.OIDCCallback(req.Context.Context(), req.Query("code").String(), req.Query("state").String())
+	return c.completeSSOLogin(req, provider, identity, redirectURL, err)
+}
+
+// SSOAssertionConsumer completes a SAML login (HTTP-POST binding)
+// @Summary Handle SAML assertion
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Param slug path string true "Provider slug"
+// @Router /auth/sso/{slug}/acs [post]
+func (c Controller) SSOAssertionConsumer(req *evo.Request) interface{} {
+	provider, err := GetSSOProvider(req.Param("slug").String())
+	if err != nil || provider.Type != SSOProviderTypeSAML {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "SSO provider not found", 404))
+	}
+
+	identity, redirectURL, err := provider.SAMLAssertion(req.Context.Context(), req.FormValue("SAMLResponse").String(), req.FormValue("RelayState").String())
+	return c.completeSSOLogin(req, provider, identity, redirectURL, err)
+}
+
+// SSOMetadata returns the SAML service provider metadata
+// @Summary Get SAML service provider metadata
+// @Tags OAuth
+// @Produce xml
+// @Param slug path string true "Provider slug"
+// @Router /auth/sso/{slug}/metadata [get]
+func (c Controller) SSOMetadata(req *evo.Request) interface{} {
+	provider, err := GetSSOProvider(req.Param("slug").String())
+	if err != nil || provider.Type != SSOProviderTypeSAML {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "SSO provider not found", 404))
+	}
+
+	metadata, err := provider.SAMLMetadata()
+	if err != nil {
+		log.Error("[sso] failed to build metadata for %s: %v", provider.Slug, err)
+		return response.Error(response.ErrInternalError)
+	}
+	req.Set("Content-Type", "application/samlmetadata+xml")
+	return metadata
+}
+
+// completeSSOLogin signs in the user of a verified identity and redirects back to the application
+func (c Controller) completeSSOLogin(req *evo.Request, provider *SSOProvider, identity *SSOIdentity, redirectURL string, err error) interface{} {
+	if redirectURL == "" || !IsAllowedSSORedirect(redirectURL) {
+		// Without a valid state there is nowhere safe to send the browser
+		if err != nil {
+			log.Warning("[sso] rejected login with %s: %v", provider.Slug, err)
+		}
+		return response.Error(response.ErrInvalidToken)
+	}
+	if err != nil {
+		log.Warning("[sso] rejected login with %s: %v", provider.Slug, err)
+		return req.Redirect(redirectURL + "?oauth=error&message=" + url.QueryEscape("Login with "+provider.Name+" failed"))
+	}
+
+	user, err := ResolveSSOUser(provider, identity)
+	if err != nil {
+		message := "Login with " + provider.Name + " failed"
+		switch {
+		case errors.Is(err, ErrSSOUserNotFound):
+			message = "User not found. Only existing users can log in with " + provider.Name + "."
+		case errors.Is(err, ErrSSOEmailMissing), errors.Is(err, ErrSSODomainNotAllowed), errors.Is(err, ErrSSOUserBlocked):
+			message = err.Error()
+		default:
+			log.Error("[sso] failed to resolve user from %s: %v", provider.Slug, err)
+		}
+		return req.Redirect(redirectURL + "?oauth=error&message=" + url.QueryEscape(message))
+	}
+
+	// Users with two-factor authentication finish the login with a code
+	if user.TwoFactorEnabled {
+		challenge, err := user.GenerateTwoFactorChallenge()
+		if err != nil {
+			return req.Redirect(redirectURL + "?oauth=error&message=Failed%20to%20start%20two-factor%20authentication")
+		}
+		return req.Redirect(redirectURL + "?oauth=2fa&two_factor_token=" + url.QueryEscape(challenge))
+	}
+
+	accessToken, err := user.GenerateJWT()
+	if err != nil {
+		return req.Redirect(redirectURL + "?oauth=error&message=Failed%20to%20generate%20access%20token")
+	}
+	refreshToken, err := user.GenerateRefreshToken()
+	if err != nil {
+		return req.Redirect(redirectURL + "?oauth=error&message=Failed%20to%20generate%20refresh%20token")
+	}
+
+	user.RecordLogin(req, true, "sso_"+provider.Slug)
+
+	res := OAuthLoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    24 * 60 * 60, // 24 hours
+		User: User{
+			UserID:      user.UserID,
+			Name:        user.Name,
+			LastName:    user.LastName,
+			DisplayName: user.DisplayName,
+			Avatar:      user.Avatar,
+			Email:       user.Email,
+			Type:        user.Type,
+		},
+	}
+	return req.Redirect(redirectURL + "?oauth=success&data=" + encodeResponseData(res))
+}
+
+// ListSSOProviderConfigs returns every SSO provider with its configuration
+func (c Controller) ListSSOProviderConfigs(req *evo.Request) interface{} {
+	var providers []SSOProvider
+	if err := db.GetContext(req).Order("name ASC").Find(&providers).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	for i := range providers {
+		providers[i].ClientSecret = ""
+	}
+	return response.OK(providers)
+}
+
+// CreateSSOProvider adds an SSO provider
+func (c Controller) CreateSSOProvider(req *evo.Request) interface{} {
+	var provider SSOProvider
+	if err := req.BodyParser(&provider); err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid request body", 400))
+	}
+	provider.ID = 0
+	provider.SPCertificate, provider.SPPrivateKey = "", ""
+
+	provider.ApplyDefaults()
+	if err := provider.Validate(); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid SSO provider", 400, err.Error()))
+	}
+	var count int64
+	db.GetContext(req).Model(&SSOProvider{}).Where("slug = ?", provider.Slug).Count(&count)
+	if count > 0 {
+		return response.Error(response.NewError(response.ErrorCodeConflict, "An SSO provider with this slug already exists", 409))
+	}
+	if err := provider.EnsureSPCertificate(); err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	if err := db.GetContext(req).Create(&provider).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+
+	provider.ClientSecret = ""
+	return response.Created(provider)
+}
+
+// UpdateSSOProvider changes an SSO provider. An empty client_secret keeps the stored secret.
+func (c Controller) UpdateSSOProvider(req *evo.Request) interface{} {
+	var provider SSOProvider
+	if err := db.GetContext(req).First(&provider, req.Param("id").Uint()).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "SSO provider not found", 404))
+	}
+
+	var input SSOProvider
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid request body", 400))
+	}
+	input.ID = provider.ID
+	input.CreatedAt = provider.CreatedAt
+	input.SPCertificate, input.SPPrivateKey = provider.SPCertificate, provider.SPPrivateKey
+	if input.ClientSecret == "" {
+		input.ClientSecret = provider.ClientSecret
+	}
+
+	input.ApplyDefaults()
+	if err := input.Validate(); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid SSO provider", 400, err.Error()))
+	}
+	var count int64
+	db.GetContext(req).Model(&SSOProvider{}).Where("slug = ? AND id != ?", input.Slug, input.ID).Count(&count)
+	if count > 0 {
+		return response.Error(response.NewError(response.ErrorCodeConflict, "An SSO provider with this slug already exists", 409))
+	}
+	if err := input.EnsureSPCertificate(); err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	if err := db.GetContext(req).Save(&input).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+
+	input.ClientSecret = ""
+	return response.OK(input)
+}
+
+// DeleteSSOProvider removes an SSO provider and the identities linked through it
+func (c Controller) DeleteSSOProvider(req *evo.Request) interface{} {
+	var provider SSOProvider
+	if err := db.GetContext(req).First(&provider, req.Param("id").Uint()).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "SSO provider not found", 404))
+	}
+	if err := db.GetContext(req).Delete(&provider).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	return response.OKWithMessage(nil, "SSO provider deleted successfully")
+}
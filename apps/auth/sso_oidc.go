@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ssoHTTPClient is used for discovery, key and token requests to identity providers
+var ssoHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// oidcProviders caches the discovered OpenID Connect configuration per provider ID
+var oidcProviders sync.Map
+
+// samlMetadata caches the parsed SAML IdP metadata per provider ID
+var samlMetadata sync.Map
+
+// forgetSSOProvider drops the cached configuration of a provider after it changed
+func forgetSSOProvider(providerID uint) {
+	oidcProviders.Delete(providerID)
+	samlMetadata.Delete(providerID)
+}
+
+// oidcProvider returns the discovered configuration of the issuer
+func (p *SSOProvider) oidcProvider() (*oidc.Provider, error) {
+	if cached, ok := oidcProviders.Load(p.ID); ok {
+		return cached.(*oidc.Provider), nil
+	}
+	// The provider keeps the context to fetch signing keys later, so it must not be request scoped
+	ctx := oidc.ClientContext(context.Background(), ssoHTTPClient)
+	provider, err := oidc.NewProvider(ctx, p.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("issuer discovery failed: %w", err)
+	}
+	oidcProviders.Store(p.ID, provider)
+	return provider, nil
+}
+
+// oauthConfig returns the authorization code flow configuration of the provider
+func (p *SSOProvider) oauthConfig(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  p.BaseURL() + "/callback",
+		Endpoint:     provider.Endpoint(),
+		Scopes:       strings.Fields(p.Scopes),
+	}
+}
+
+// OIDCLoginURL returns the authorization URL the browser is sent to
+func (p *SSOProvider) OIDCLoginURL(redirectURL string) (string, error) {
+	provider, err := p.oidcProvider()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	state, err := generateSSOState(p, nonce, redirectURL)
+	if err != nil {
+		return "", err
+	}
+	return p.oauthConfig(provider).AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// OIDCCallback exchanges the authorization code and returns the verified identity and the redirect URL
+func (p *SSOProvider) OIDCCallback(ctx context.Context, code, state string) (*SSOIdentity, string, error) {
+	stateClaims, err := parseSSOState(p, state)
+	if err != nil {
+		return nil, "", err
+	}
+	provider, err := p.oidcProvider()
+	if err != nil {
+		return nil, stateClaims.RedirectURL, err
+	}
+
+	ctx = oidc.ClientContext(ctx, ssoHTTPClient)
+	config := p.oauthConfig(provider)
+	token, err := config.Exchange(ctx, code)
+	if err != nil {
+		return nil, stateClaims.RedirectURL, fmt.Errorf("code exchange failed: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, stateClaims.RedirectURL, fmt.Errorf("the identity provider returned no id_token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, stateClaims.RedirectURL, fmt.Errorf("id_token verification failed: %w", err)
+	}
+	if idToken.Nonce != stateClaims.Nonce {
+		return nil, stateClaims.RedirectURL, fmt.Errorf("id_token nonce does not match")
+	}
+
+	claims := map[string]any{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, stateClaims.RedirectURL, err
+	}
+	// Many providers only put profile claims in the userinfo response
+	if provider.UserInfoEndpoint() != "" {
+		if info, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token)); err == nil && info.Subject == idToken.Subject {
+			userInfoClaims := map[string]any{}
+			if info.Claims(&userInfoClaims) == nil {
+				for key, value := range userInfoClaims {
+					if _, exists := claims[key]; !exists {
+						claims[key] = value
+					}
+				}
+			}
+		}
+	}
+
+	identity := &SSOIdentity{
+		Subject:     idToken.Subject,
+		Email:       firstNonEmpty(claimStrings(claims[p.EmailClaim])...),
+		FirstName:   firstNonEmpty(claimStrings(claims[p.FirstNameClaim])...),
+		LastName:    firstNonEmpty(claimStrings(claims[p.LastNameClaim])...),
+		DisplayName: firstNonEmpty(claimStrings(claims[p.DisplayNameClaim])...),
+	}
+	if p.DepartmentClaim != "" {
+		identity.Departments = claimStrings(claims[p.DepartmentClaim])
+	}
+	// Providers that do not send email_verified only release addresses they own
+	identity.EmailVerified = true
+	if verified, ok := claims["email_verified"].(bool); ok {
+		identity.EmailVerified = verified
+	}
+
+	return identity, stateClaims.RedirectURL, nil
+}
+
+// randomToken returns a random URL-safe string
+func randomToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+)
+
+// samlMetadataTTL is how long fetched IdP metadata is reused
+const samlMetadataTTL = time.Hour
+
+type cachedSAMLMetadata struct {
+	metadata  *saml.EntityDescriptor
+	fetchedAt time.Time
+}
+
+// idpMetadata returns the identity provider metadata, fetched from the metadata URL or parsed from the stored XML
+func (p *SSOProvider) idpMetadata(ctx context.Context) (*saml.EntityDescriptor, error) {
+	if cached, ok := samlMetadata.Load(p.ID); ok {
+		entry := cached.(cachedSAMLMetadata)
+		if time.Since(entry.fetchedAt) < samlMetadataTTL {
+			return entry.metadata, nil
+		}
+	}
+
+	var metadata *saml.EntityDescriptor
+	var err error
+	if p.IDPMetadataXML != "" {
+		metadata, err = samlsp.ParseMetadata([]byte(p.IDPMetadataXML))
+	} else {
+		var metadataURL *url.URL
+		if metadataURL, err = url.Parse(p.IDPMetadataURL); err == nil {
+			metadata, err = samlsp.FetchMetadata(ctx, ssoHTTPClient, *metadataURL)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load IdP metadata: %w", err)
+	}
+	samlMetadata.Store(p.ID, cachedSAMLMetadata{metadata: metadata, fetchedAt: time.Now()})
+	return metadata, nil
+}
+
+// serviceProvider returns the SAML service provider of the provider, configured with the IdP metadata
+func (p *SSOProvider) serviceProvider(ctx context.Context) (*saml.ServiceProvider, error) {
+	sp, err := p.localServiceProvider()
+	if err != nil {
+		return nil, err
+	}
+	if sp.IDPMetadata, err = p.idpMetadata(ctx); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// localServiceProvider returns the SAML service provider without the IdP metadata, enough to publish the SP metadata
+func (p *SSOProvider) localServiceProvider() (*saml.ServiceProvider, error) {
+	keyBlock, _ := pem.Decode([]byte(p.SPPrivateKey))
+	certBlock, _ := pem.Decode([]byte(p.SPCertificate))
+	if keyBlock == nil || certBlock == nil {
+		return nil, fmt.Errorf("the service provider key pair is missing")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataURL, _ := url.Parse(p.BaseURL() + "/metadata")
+	acsURL, _ := url.Parse(p.BaseURL() + "/acs")
+	return &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               key,
+		Certificate:       cert,
+		HTTPClient:        ssoHTTPClient,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		AuthnNameIDFormat: saml.EmailAddressNameIDFormat,
+	}, nil
+}
+
+// SAMLMetadata returns the service provider metadata to register at the identity provider
+func (p *SSOProvider) SAMLMetadata() ([]byte, error) {
+	sp, err := p.localServiceProvider()
+	if err != nil {
+		return nil, err
+	}
+	return xml.MarshalIndent(sp.Metadata(), "", "  ")
+}
+
+// SAMLLoginURL returns the IdP URL carrying the authentication request (HTTP-Redirect binding)
+func (p *SSOProvider) SAMLLoginURL(ctx context.Context, redirectURL string) (string, error) {
+	sp, err := p.serviceProvider(ctx)
+	if err != nil {
+		return "", err
+	}
+	ssoURL := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if ssoURL == "" {
+		return "", fmt.Errorf("the identity provider has no HTTP-Redirect single sign-on endpoint")
+	}
+	authnRequest, err := sp.MakeAuthenticationRequest(ssoURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", err
+	}
+	// The request ID travels in the signed relay state so the response can be matched without server-side storage
+	relayState, err := generateSSOState(p, authnRequest.ID, redirectURL)
+	if err != nil {
+		return "", err
+	}
+	loginURL, err := authnRequest.Redirect(relayState, sp)
+	if err != nil {
+		return "", err
+	}
+	return loginURL.String(), nil
+}
+
+// SAMLAssertion verifies the response posted to the assertion consumer service and returns the identity and the redirect URL
+func (p *SSOProvider) SAMLAssertion(ctx context.Context, samlResponse, relayState string) (*SSOIdentity, string, error) {
+	stateClaims, err := parseSSOState(p, relayState)
+	if err != nil {
+		return nil, "", err
+	}
+	sp, err := p.serviceProvider(ctx)
+	if err != nil {
+		return nil, stateClaims.RedirectURL, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return nil, stateClaims.RedirectURL, fmt.Errorf("SAMLResponse is not valid base64")
+	}
+	assertion, err := sp.ParseXMLResponse(decoded, []string{stateClaims.Nonce}, sp.AcsURL)
+	if err != nil {
+		if invalid, ok := err.(*saml.InvalidResponseError); ok {
+			err = invalid.PrivateErr
+		}
+		return nil, stateClaims.RedirectURL, fmt.Errorf("SAML response rejected: %v", err)
+	}
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, stateClaims.RedirectURL, fmt.Errorf("the assertion has no subject")
+	}
+
+	attributes := map[string][]string{}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			var values []string
+			for _, value := range attribute.Values {
+				if value.Value != "" {
+					values = append(values, value.Value)
+				}
+			}
+			attributes[attribute.Name] = append(attributes[attribute.Name], values...)
+			if attribute.FriendlyName != "" {
+				attributes[attribute.FriendlyName] = append(attributes[attribute.FriendlyName], values...)
+			}
+		}
+	}
+
+	nameID := assertion.Subject.NameID
+	identity := &SSOIdentity{
+		Subject:       nameID.Value,
+		Email:         firstNonEmpty(attributes[p.EmailClaim]...),
+		FirstName:     firstNonEmpty(attributes[p.FirstNameClaim]...),
+		LastName:      firstNonEmpty(attributes[p.LastNameClaim]...),
+		DisplayName:   firstNonEmpty(attributes[p.DisplayNameClaim]...),
+		EmailVerified: true, // addresses in a signed assertion are vouched for by the IdP
+	}
+	if identity.Email == "" && nameID.Format == string(saml.EmailAddressNameIDFormat) && strings.Contains(nameID.Value, "@") {
+		identity.Email = nameID.Value
+	}
+	if p.DepartmentClaim != "" {
+		identity.Departments = attributes[p.DepartmentClaim]
+	}
+
+	return identity, stateClaims.RedirectURL, nil
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/restify"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Token lifetimes
+const (
+	AccessTokenTTL  = 24 * time.Hour
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// refreshTokenPurpose keeps refresh tokens from being accepted as access tokens
+const refreshTokenPurpose = "refresh"
+
+// Session revocation reasons
+const (
+	SessionRevokedLogout     = "logout"
+	SessionRevokedTerminated = "terminated"
+	SessionRevokedReuse      = "refresh_token_reuse"
+	SessionRevokedBlocked    = "user_blocked"
+	SessionRevokedDeleted    = "user_deleted"
+)
+
+// ErrSessionRevoked is returned for tokens of a revoked or unknown session
+var ErrSessionRevoked = errors.New("session has been revoked")
+
+// RefreshTokenFamily is the server-side record of a login session.
+// Its ID is the "sid" claim of every access and refresh token issued for the session.
+// Only the latest refresh token of a family is accepted; presenting an older one revokes the family.
+type RefreshTokenFamily struct {
+	ID             string     `gorm:"column:id;type:char(36);primaryKey" json:"id"`
+	UserID         uuid.UUID  `gorm:"column:user_id;type:char(36);not null;index;fk:users" json:"user_id"`
+	CurrentTokenID string     `gorm:"column:current_token_id;size:36;not null" json:"-"`
+	Rotations      int        `gorm:"column:rotations;not null;default:0" json:"rotations"`
+	ExpiresAt      time.Time  `gorm:"column:expires_at;not null;index" json:"expires_at"`
+	RevokedAt      *time.Time `gorm:"column:revoked_at;index" json:"revoked_at"`
+	RevokedReason  string     `gorm:"column:revoked_reason;size:50" json:"revoked_reason,omitempty"`
+	CreatedAt      time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	restify.API
+}
+
+func (RefreshTokenFamily) TableName() string {
+	return "refresh_token_families"
+}
+
+// SessionRevokedBroadcaster is set by the livechat package to drop the WebSocket connections of revoked sessions
+var SessionRevokedBroadcaster func(userID uuid.UUID, sessionID string)
+
+// SessionID returns the token session of the current request, empty for API key requests
+func (u *User) SessionID() string {
+	return u.sessionID
+}
+
+// startSession creates the refresh token family of a new login
+func (u *User) startSession() error {
+	family := RefreshTokenFamily{
+		ID:             uuid.NewString(),
+		UserID:         u.UserID,
+		CurrentTokenID: uuid.NewString(),
+		ExpiresAt:      time.Now().Add(RefreshTokenTTL),
+	}
+	if err := db.Create(&family).Error; err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	u.sessionID = family.ID
+	u.refreshTokenID = family.CurrentTokenID
+	return nil
+}
+
+// RotateRefreshToken accepts a refresh token of the user and moves its family to a new token.
+// Reusing a refresh token that was already rotated revokes the whole family.
+func (u *User) RotateRefreshToken(claims *Claims) error {
+	if claims.SessionID == "" || claims.ID == "" {
+		return ErrSessionRevoked
+	}
+
+	nextTokenID := uuid.NewString()
+	result := db.Model(&RefreshTokenFamily{}).
+		Where("id = ? AND user_id = ? AND current_token_id = ? AND revoked_at IS NULL AND expires_at > ?", claims.SessionID, u.UserID, claims.ID, time.Now()).
+		UpdateColumns(map[string]any{
+			"current_token_id": nextTokenID,
+			"rotations":        gorm.Expr("rotations + 1"),
+			"expires_at":       time.Now().Add(RefreshTokenTTL),
+			"updated_at":       time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var family RefreshTokenFamily
+		if err := db.Where("id = ? AND user_id = ?", claims.SessionID, u.UserID).First(&family).Error; err == nil &&
+			family.RevokedAt == nil && family.CurrentTokenID != claims.ID {
+			log.Warning("[auth] refresh token reuse detected for user %s, revoking session %s", u.UserID, family.ID)
+			RevokeSession(u.UserID, family.ID, SessionRevokedReuse)
+		}
+		return ErrSessionRevoked
+	}
+
+	u.sessionID = claims.SessionID
+	u.refreshTokenID = nextTokenID
+	return nil
+}
+
+// rotateSession moves the current session to a new refresh token, for tokens issued without a refresh request
+func (u *User) rotateSession() (string, error) {
+	tokenID := uuid.NewString()
+	result := db.Model(&RefreshTokenFamily{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", u.sessionID, u.UserID).
+		UpdateColumns(map[string]any{
+			"current_token_id": tokenID,
+			"rotations":        gorm.Expr("rotations + 1"),
+			"expires_at":       time.Now().Add(RefreshTokenTTL),
+			"updated_at":       time.Now(),
+		})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", ErrSessionRevoked
+	}
+	return tokenID, nil
+}
+
+// RevokeSession ends a token session of the user immediately
+func RevokeSession(userID uuid.UUID, sessionID, reason string) error {
+	if sessionID == "" {
+		return nil
+	}
+	result := db.Model(&RefreshTokenFamily{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		UpdateColumns(map[string]any{"revoked_at": time.Now(), "revoked_reason": reason})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 && SessionRevokedBroadcaster != nil {
+		go SessionRevokedBroadcaster(userID, sessionID)
+	}
+	return nil
+}
+
+// RevokeUserSessions ends every token session of the user except the given one
+func RevokeUserSessions(userID uuid.UUID, reason string, exceptSessionID string) (int64, error) {
+	query := db.Model(&RefreshTokenFamily{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if exceptSessionID != "" {
+		query = query.Where("id != ?", exceptSessionID)
+	}
+	var sessionIDs []string
+	if err := query.Pluck("id", &sessionIDs).Error; err != nil {
+		return 0, err
+	}
+	if len(sessionIDs) == 0 {
+		return 0, nil
+	}
+
+	result := db.Model(&RefreshTokenFamily{}).
+		Where("id IN ? AND revoked_at IS NULL", sessionIDs).
+		UpdateColumns(map[string]any{"revoked_at": time.Now(), "revoked_reason": reason})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if SessionRevokedBroadcaster != nil {
+		for _, sessionID := range sessionIDs {
+			go SessionRevokedBroadcaster(userID, sessionID)
+		}
+	}
+	return result.RowsAffected, nil
+}
+
+// sessionActive reports whether the token session has not been revoked
+func sessionActive(userID uuid.UUID, sessionID string) bool {
+	var count int64
+	db.Model(&RefreshTokenFamily{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).Count(&count)
+	return count > 0
+}
+
+// UserFromToken returns the user of a valid access token.
+// Tokens of revoked sessions and blocked users are rejected, as are the enrolment-only sessions outside the 2FA endpoints.
+func UserFromToken(tokenString, path string) (*User, error) {
+	if len(JWTSecret) == 0 {
+		return nil, fmt.Errorf("JWT secret is not initialized")
+	}
+
+	claims := &Claims{}
+	jwtToken, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return JWTSecret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !jwtToken.Valid {
+		return nil, fmt.Errorf("token is not valid")
+	}
+
+	// Challenge tokens are only accepted by the second login step
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("token is not an access token")
+	}
+
+	var user User
+	if err := db.Where("id = ?", claims.UserID).First(&user).Error; err != nil {
+		return nil, fmt.Errorf("user not found for claims: %s", claims.UserID)
+	}
+	if user.Status == UserStatusBlocked {
+		return nil, fmt.Errorf("user is blocked")
+	}
+	// A token is only valid in the workspace it was issued for
+	if claims.WorkspaceID != 0 && claims.WorkspaceID != user.WorkspaceID {
+		return nil, fmt.Errorf("token was issued for another workspace")
+	}
+	// Tokens issued before server-side sessions cannot be revoked and are no longer accepted
+	if claims.SessionID == "" || !sessionActive(user.UserID, claims.SessionID) {
+		return nil, ErrSessionRevoked
+	}
+
+	// Sessions issued before 2FA was enabled are no longer valid
+	if user.TwoFactorEnabled && !claims.TwoFactorVerified {
+		return nil, fmt.Errorf("two-factor authentication required")
+	}
+	// Sessions of users a role requires to enrol may only reach the enrolment endpoints
+	if claims.TwoFactorSetup && !user.TwoFactorEnabled && !isTwoFactorSetupPath(path) {
+		return nil, fmt.Errorf("two-factor enrolment required")
+	}
+	user.twoFactorVerified = claims.TwoFactorVerified
+	user.sessionID = claims.SessionID
+
+	return &user, nil
+}
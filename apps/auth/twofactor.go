@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/getevo/restify"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by every authenticator app)
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// Accept codes from one period before and after the current one to allow for clock drift
+	totpSkew = 1
+
+	// RecoveryCodeCount is the number of recovery codes issued on enrolment
+	RecoveryCodeCount = 10
+
+	// TwoFactorChallengeTTL is how long the second login step may take
+	TwoFactorChallengeTTL = 5 * time.Minute
+	// TwoFactorMaxAttempts is the number of failed codes allowed per user within TwoFactorChallengeTTL
+	TwoFactorMaxAttempts = 5
+
+	twoFactorChallengePurpose = "2fa"
+)
+
+// Login history reasons of the second login step
+const (
+	LoginReasonTwoFactorFailed  = "invalid_2fa_code"
+	LoginReasonTwoFactorSuccess = "login_success_2fa"
+	LoginReasonRecoveryCodeUsed = "login_success_recovery_code"
+)
+
+// UserRecoveryCode is a one-time code that replaces the authenticator app once
+type UserRecoveryCode struct {
+	ID        uint       `gorm:"column:id;primaryKey" json:"id"`
+	UserID    uuid.UUID  `gorm:"column:user_id;type:char(36);not null;index;fk:users" json:"user_id"`
+	CodeHash  string     `gorm:"column:code_hash;size:64;not null" json:"-"`
+	UsedAt    *time.Time `gorm:"column:used_at" json:"used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+
+	restify.API
+}
+
+func (UserRecoveryCode) TableName() string {
+	return "user_recovery_codes"
+}
+
+// GenerateTOTPSecret returns a new base32 encoded TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURL returns the otpauth:// URL authenticator apps read from a QR code
+func TOTPProvisioningURL(secret, email string) string {
+	issuer := settings.Get("AUTH.TOTP_ISSUER", "Homa").String()
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+email) + "?" + query.Encode()
+}
+
+// validateTOTP checks code against secret and returns the time step it belongs to.
+// Steps at or before lastStep are rejected so a code cannot be replayed.
+func validateTOTP(secret, code string, lastStep int64) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the code of one time step (RFC 4226 dynamic truncation)
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// VerifyTwoFactorCode checks an authenticator code and records its time step so it cannot be used twice
+func (u *User) VerifyTwoFactorCode(code string) bool {
+	if u.TwoFactorSecret == nil {
+		return false
+	}
+	step, ok := validateTOTP(*u.TwoFactorSecret, code, u.TwoFactorLastStep)
+	if !ok {
+		return false
+	}
+	// Another request may have used the same code in the meantime
+	result := db.Model(&User{}).
+		Where("id = ? AND two_factor_last_step < ?", u.UserID, step).
+		UpdateColumn("two_factor_last_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	u.TwoFactorLastStep = step
+	return true
+}
+
+// UseRecoveryCode consumes one of the user's unused recovery codes
+func (u *User) UseRecoveryCode(code string) bool {
+	hash := hashRecoveryCode(code)
+	result := db.Model(&UserRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", u.UserID, hash).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// GenerateRecoveryCodes replaces the user's recovery codes and returns the new codes in plain text
+func (u *User) GenerateRecoveryCodes(tx *gorm.DB) ([]string, error) {
+	if err := tx.Where("user_id = ?", u.UserID).Delete(&UserRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:]
+		if err := tx.Create(&UserRecoveryCode{UserID: u.UserID, CodeHash: hashRecoveryCode(code)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes
+func (u *User) RemainingRecoveryCodes() int64 {
+	var count int64
+	db.Model(&UserRecoveryCode{}).Where("user_id = ? AND used_at IS NULL", u.UserID).Count(&count)
+	return count
+}
+
+// DisableTwoFactor removes the user's TOTP secret and recovery codes
+func (u *User) DisableTwoFactor() error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", u.UserID).Delete(&UserRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		u.TwoFactorEnabled = false
+		u.TwoFactorSecret = nil
+		u.TwoFactorLastStep = 0
+		u.TwoFactorEnabledAt = nil
+		return tx.Model(&User{}).Where("id = ?", u.UserID).UpdateColumns(map[string]any{
+			"two_factor_enabled":    false,
+			"two_factor_secret":     nil,
+			"two_factor_last_step":  0,
+			"two_factor_enabled_at": nil,
+		}).Error
+	})
+}
+
+// TwoFactorVerified reports whether the current session passed the second login step
+func (u *User) TwoFactorVerified() bool {
+	return u.twoFactorVerified
+}
+
+// GenerateTwoFactorChallenge returns a short-lived token that identifies the user in the second login step
+func (u *User) GenerateTwoFactorChallenge() (string, error) {
+	claims := Claims{
+		UserID:  u.UserID.String(),
+		Purpose: twoFactorChallengePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TwoFactorChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(JWTSecret)
+}
+
+// parseTwoFactorChallenge returns the user ID of a valid challenge token
+func parseTwoFactorChallenge(tokenString string) (string, bool) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return JWTSecret, nil
+	})
+	if err != nil || !token.Valid || claims.Purpose != twoFactorChallengePurpose {
+		return "", false
+	}
+	return claims.UserID, true
+}
+
+// recentTwoFactorFailures counts the failed second steps of the user within the challenge lifetime
+func (u *User) recentTwoFactorFailures() int64 {
+	var count int64
+	db.Model(&UserLoginHistory{}).
+		Where("user_id = ? AND success = ? AND reason = ? AND login_at > ?", u.UserID, false, LoginReasonTwoFactorFailed, time.Now().Add(-TwoFactorChallengeTTL)).
+		Count(&count)
+	return count
+}
+
+// isTwoFactorSetupPath reports whether a session that still has to enrol may call path
+func isTwoFactorSetupPath(path string) bool {
+	return strings.HasPrefix(path, "/api/auth/2fa") || path == "/api/auth/profile"
+}
+
+// hashRecoveryCode hashes a recovery code; codes are random, so a plain digest is enough
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
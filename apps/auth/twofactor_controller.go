@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/gorm"
+)
+
+// TwoFactorLoginRequest completes a login that requires a second step
+type TwoFactorLoginRequest struct {
+	TwoFactorToken string `json:"two_factor_token" validate:"required"`
+	Code           string `json:"code"`          // authenticator app code
+	RecoveryCode   string `json:"recovery_code"` // used instead of code when the device is lost
+}
+
+// TwoFactorCodeRequest carries an authenticator code or a recovery code
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TwoFactorStatusResponse describes the 2FA state of the current user
+type TwoFactorStatusResponse struct {
+	Enabled                bool       `json:"enabled"`
+	Required               bool       `json:"required"`
+	EnabledAt              *time.Time `json:"enabled_at"`
+	RecoveryCodesRemaining int64      `json:"recovery_codes_remaining"`
+}
+
+// TwoFactorSetupResponse contains the secret to add to an authenticator app
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURL string `json:"provisioning_url"`
+}
+
+// TwoFactorEnabledResponse is returned once 2FA is enabled. The recovery codes are shown only once.
+type TwoFactorEnabledResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	LoginResponse
+}
+
+// LoginTwoFactorHandler completes a login with an authenticator code or a recovery code
+// @Summary Complete two-factor login
+// @Description Exchange the two_factor_token returned by the login and a TOTP code or recovery code for access tokens
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param body body TwoFactorLoginRequest true "Second login step"
+// @Success 200 {object} LoginResponse
+// @Router /auth/login/2fa [post]
+func (c Controller) LoginTwoFactorHandler(request *evo.Request) any {
+	var loginReq TwoFactorLoginRequest
+	if err := request.BodyParser(&loginReq); err != nil {
+		return response.Error(response.ErrInvalidInput)
+	}
+
+	userID, ok := parseTwoFactorChallenge(loginReq.TwoFactorToken)
+	if !ok {
+		return response.Error(response.ErrInvalidToken)
+	}
+
+	var user User
+	if err := db.GetContext(request).Where("id = ?", userID).First(&user).Error; err != nil {
+		return response.Error(response.ErrInvalidToken)
+	}
+	if user.Status == UserStatusBlocked {
+		user.RecordLogin(request, false, "account_blocked")
+		return response.Error(response.NewError(response.ErrorCodeForbidden, "Your account has been blocked. Please contact an administrator.", 403))
+	}
+	if !user.TwoFactorEnabled {
+		return response.Error(response.ErrInvalidToken)
+	}
+
+	if lockout := AccountLockout(user.UserID); lockout != nil {
+		user.RecordLogin(request, false, LoginReasonAccountLocked)
+		return lockoutResponse(lockout)
+	}
+	if user.recentTwoFactorFailures() >= TwoFactorMaxAttempts {
+		return response.Error(response.NewError("too_many_requests", "Too many failed attempts. Please log in again later.", 429))
+	}
+
+	reason := LoginReasonTwoFactorSuccess
+	switch {
+	case loginReq.Code != "" && user.VerifyTwoFactorCode(loginReq.Code):
+	case loginReq.RecoveryCode != "" && user.UseRecoveryCode(loginReq.RecoveryCode):
+		reason = LoginReasonRecoveryCodeUsed
+	default:
+		user.recordLoginFailure(request, LoginReasonTwoFactorFailed)
+		return response.Error(response.NewError(response.ErrorCodeUnauthorized, "Invalid two-factor code", 401))
+	}
+
+	user.twoFactorVerified = true
+	loginData, err := c.issueTokens(&user)
+	if err != nil {
+		user.RecordLogin(request, false, "token_generation_failed")
+		return response.Error(response.ErrInternalError)
+	}
+
+	user.RecordLogin(request, true, reason)
+	return response.OK(loginData)
+}
+
+// GetTwoFactorStatus returns the 2FA state of the current user
+// @Summary Get two-factor status
+// @Description Whether 2FA is enabled or required by a role, and how many recovery codes are left
+// @Tags Profile
+// @Produce json
+// @Success 200 {object} TwoFactorStatusResponse
+// @Router /auth/2fa [get]
+// @Security Bearer
+func (c Controller) GetTwoFactorStatus(request *evo.Request) any {
+	if request.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := request.User().Interface().(*User)
+
+	status := TwoFactorStatusResponse{
+		Enabled:   user.TwoFactorEnabled,
+		Required:  user.RequiresTwoFactor(),
+		EnabledAt: user.TwoFactorEnabledAt,
+	}
+	if user.TwoFactorEnabled {
+		status.RecoveryCodesRemaining = user.RemainingRecoveryCodes()
+	}
+	return response.OK(status)
+}
+
+// SetupTwoFactor creates a new TOTP secret for the current user. 2FA is enabled once a code is confirmed.
+// @Summary Start two-factor enrolment
+// @Description Generate a TOTP secret and provisioning URL to add to an authenticator app. Confirm with /auth/2fa/enable.
+// @Tags Profile
+// @Produce json
+// @Success 200 {object} TwoFactorSetupResponse
+// @Router /auth/2fa/setup [post]
+// @Security Bearer
+func (c Controller) SetupTwoFactor(request *evo.Request) any {
+	if request.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := request.User().Interface().(*User)
+	if user.TwoFactorEnabled {
+		return response.Error(response.NewError(response.ErrorCodeConflict, "Two-factor authentication is already enabled", 409))
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+	if err := db.GetContext(request).Model(&User{}).Where("id = ?", user.UserID).UpdateColumns(map[string]any{
+		"two_factor_secret":    secret,
+		"two_factor_last_step": 0,
+	}).Error; err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+
+	return response.OK(TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningURL: TOTPProvisioningURL(secret, user.Email),
+	})
+}
+
+// EnableTwoFactor confirms the enrolment with a code and returns the recovery codes and a verified session
+// @Summary Enable two-factor authentication
+// @Description Confirm the secret from /auth/2fa/setup with a code. Returns recovery codes and new tokens; older sessions stop working.
+// @Tags Profile
+// @Accept json
+// @Produce json
+// @Param body body TwoFactorCodeRequest true "Authenticator code"
+// @Success 200 {object} TwoFactorEnabledResponse
+// @Router /auth/2fa/enable [post]
+// @Security Bearer
+func (c Controller) EnableTwoFactor(request *evo.Request) any {
+	if request.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := request.User().Interface().(*User)
+	if user.TwoFactorEnabled {
+		return response.Error(response.NewError(response.ErrorCodeConflict, "Two-factor authentication is already enabled", 409))
+	}
+	if user.TwoFactorSecret == nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Start the enrolment with /api/auth/2fa/setup first", 400))
+	}
+
+	var req TwoFactorCodeRequest
+	if err := request.BodyParser(&req); err != nil {
+		return response.Error(response.ErrInvalidInput)
+	}
+	if !user.VerifyTwoFactorCode(req.Code) {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid two-factor code", 400))
+	}
+
+	var codes []string
+	now := time.Now()
+	err := db.GetContext(request).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if codes, err = user.GenerateRecoveryCodes(tx); err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", user.UserID).UpdateColumns(map[string]any{
+			"two_factor_enabled":    true,
+			"two_factor_enabled_at": now,
+		}).Error
+	})
+	if err != nil {
+		log.Error("Failed to enable two-factor authentication:", err)
+		return response.Error(response.ErrDatabaseError)
+	}
+	user.TwoFactorEnabled = true
+	user.TwoFactorEnabledAt = &now
+
+	user.twoFactorVerified = true
+	loginData, err := c.issueTokens(user)
+	if err != nil {
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OKWithMessage(TwoFactorEnabledResponse{RecoveryCodes: codes, LoginResponse: *loginData}, "Two-factor authentication enabled")
+}
+
+// DisableTwoFactor turns 2FA off for the current user
+// @Summary Disable two-factor authentication
+// @Description Turn 2FA off with a current code or a recovery code. Not possible while a role requires 2FA.
+// @Tags Profile
+// @Accept json
+// @Produce json
+// @Param body body TwoFactorCodeRequest true "Authenticator code or recovery code"
+// @Success 200 {object} object{message=string}
+// @Router /auth/2fa/disable [post]
+// @Security Bearer
+func (c Controller) DisableTwoFactor(request *evo.Request) any {
+	user, errResp := c.verifiedTwoFactorUser(request)
+	if errResp != nil {
+		return errResp
+	}
+	if user.RequiresTwoFactor() {
+		return response.Error(response.NewError(response.ErrorCodeForbidden, "Two-factor authentication is required by your role", 403))
+	}
+
+	if err := user.DisableTwoFactor(); err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+
+	return response.OKWithMessage(nil, "Two-factor authentication disabled")
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes. Requires a current code or recovery code.
+// @Tags Profile
+// @Accept json
+// @Produce json
+// @Param body body TwoFactorCodeRequest true "Authenticator code or recovery code"
+// @Success 200 {object} object{recovery_codes=[]string}
+// @Router /auth/2fa/recovery-codes [post]
+// @Security Bearer
+func (c Controller) RegenerateRecoveryCodes(request *evo.Request) any {
+	user, errResp := c.verifiedTwoFactorUser(request)
+	if errResp != nil {
+		return errResp
+	}
+
+	var codes []string
+	err := db.GetContext(request).Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = user.GenerateRecoveryCodes(tx)
+		return err
+	})
+	if err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+
+	return response.OK(map[string]any{"recovery_codes": codes})
+}
+
+// ResetUserTwoFactor turns 2FA off for a user who lost their device
+func (c Controller) ResetUserTwoFactor(request *evo.Request) any {
+	id, err := uuid.Parse(request.Param("id").String())
+	if err != nil {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user ID format", 400))
+	}
+
+	var targetUser User
+	if err := db.GetContext(request).Where("id = ?", id).First(&targetUser).Error; err != nil {
+		return response.Error(response.NewError(response.ErrorCodeNotFound, "User not found", 404))
+	}
+	if targetUser.Type == UserTypeAdministrator && !request.User().Interface().(*User).HasPermission(PermissionAll) {
+		return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can change administrator accounts", 403))
+	}
+
+	if err := targetUser.DisableTwoFactor(); err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+
+	return response.OKWithMessage(nil, "Two-factor authentication reset")
+}
+
+// verifiedTwoFactorUser returns the current user after checking the code or recovery code in the request
+func (c Controller) verifiedTwoFactorUser(request *evo.Request) (*User, any) {
+	if request.User().Anonymous() {
+		return nil, response.Error(response.ErrUnauthorized)
+	}
+	user := request.User().Interface().(*User)
+	if !user.TwoFactorEnabled {
+		return nil, response.Error(response.NewError(response.ErrorCodeInvalidInput, "Two-factor authentication is not enabled", 400))
+	}
+
+	var req TwoFactorCodeRequest
+	if err := request.BodyParser(&req); err != nil {
+		return nil, response.Error(response.ErrInvalidInput)
+	}
+	if user.recentTwoFactorFailures() >= TwoFactorMaxAttempts {
+		return nil, response.Error(response.NewError("too_many_requests", "Too many failed attempts. Please try again later.", 429))
+	}
+	if !(req.Code != "" && user.VerifyTwoFactorCode(req.Code)) && !(req.RecoveryCode != "" && user.UseRecoveryCode(req.RecoveryCode)) {
+		user.RecordLogin(request, false, LoginReasonTwoFactorFailed)
+		return nil, response.Error(response.NewError(response.ErrorCodeUnauthorized, "Invalid two-factor code", 401))
+	}
+	return user, nil
+}
+
+// issueTokens creates the access and refresh tokens of a session
+func (c Controller) issueTokens(user *User) (*LoginResponse, error) {
+	accessToken, err := user.GenerateJWT()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := user.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	user.PasswordHash = nil
+	return &LoginResponse{
+		AccessToken:            accessToken,
+		RefreshToken:           refreshToken,
+		ExpiresIn:              86400, // 24 hours
+		User:                   user,
+		TwoFactorSetupRequired: !user.TwoFactorEnabled && user.RequiresTwoFactor(),
+	}, nil
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 test key "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	// RFC 6238 appendix B SHA-1 vectors, truncated to 6 digits
+	for seconds, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		if got := totpCode(key, seconds/totpPeriod); got != want {
+			t.Errorf("code at %d = %s, want %s", seconds, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	current := time.Now().Unix() / totpPeriod
+	code := func(step int64) string { return totpCode(key, step) }
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", secret: rfcSecret, code: code(current), wantStep: current, wantOK: true},
+		{name: "previous step within drift", secret: rfcSecret, code: code(current - 1), wantStep: current - 1, wantOK: true},
+		{name: "next step within drift", secret: rfcSecret, code: code(current + 1), wantStep: current + 1, wantOK: true},
+		{name: "expired step", secret: rfcSecret, code: code(current - 2)},
+		{name: "replayed code", secret: rfcSecret, code: code(current), lastStep: current},
+		{name: "older than last used step", secret: rfcSecret, code: code(current - 1), lastStep: current - 1},
+		{name: "spaces and lowercase secret", secret: strings.ToLower(rfcSecret), code: " " + code(current)[:3] + " " + code(current)[3:], wantStep: current, wantOK: true},
+		{name: "wrong code", secret: rfcSecret, code: wrongCode(code, current)},
+		{name: "too short", secret: rfcSecret, code: code(current)[:5]},
+		{name: "invalid secret", secret: "not base32!", code: code(current)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := validateTOTP(tt.secret, tt.code, tt.lastStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("validateTOTP = %d, %v; want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+// wrongCode returns a code that belongs to none of the steps validateTOTP accepts around current
+func wrongCode(code func(int64) string, current int64) string {
+	accepted := map[string]bool{}
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		accepted[code(step)] = true
+	}
+	for _, candidate := range []string{"000000", "111111", "222222", "333333"} {
+		if !accepted[candidate] {
+			return candidate
+		}
+	}
+	return "999999"
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/getevo/evo/v2"
 	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
 	"github.com/google/uuid"
 	"github.com/iesreza/homa-backend/lib/response"
 )
@@ -14,7 +15,7 @@ import (
 func (c Controller) ListUsers(request *evo.Request) interface{} {
 	// Check if user is administrator
 	user := request.User().Interface().(*User)
-	if user.Type != UserTypeAdministrator {
+	if !user.HasPermission(PermissionUsersManage) {
 		return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can access user management", 403))
 	}
 
@@ -47,7 +48,7 @@ func (c Controller) ListUsers(request *evo.Request) interface{} {
 	}
 
 	// Build query
-	query := db.Model(&User{})
+	query := db.GetContext(request).Model(&User{})
 
 	// Apply search filter
 	if search != "" {
@@ -108,7 +109,7 @@ func (c Controller) ListUsers(request *evo.Request) interface{} {
 func (c Controller) CreateUser(request *evo.Request) interface{} {
 	// Check if user is administrator
 	user := request.User().Interface().(*User)
-	if user.Type != UserTypeAdministrator {
+	if !user.HasPermission(PermissionUsersManage) {
 		return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can create users", 403))
 	}
 
@@ -122,6 +123,7 @@ func (c Controller) CreateUser(request *evo.Request) interface{} {
 		Avatar      *string `json:"avatar"`
 		SecurityKey *string `json:"security_key"`
 		Language    string  `json:"language"`
+		SendInvite  bool    `json:"send_invite"` // email an invitation instead of setting the password
 	}
 
 	if err := request.BodyParser(&req); err != nil {
@@ -129,14 +131,20 @@ func (c Controller) CreateUser(request *evo.Request) interface{} {
 	}
 
 	// Validate required fields
-	if req.Name == "" || req.LastName == "" || req.Email == "" || req.Password == "" || req.Type == "" {
+	if req.Name == "" || req.LastName == "" || req.Email == "" || req.Type == "" || (req.Password == "" && !req.SendInvite) {
 		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Missing required fields", 400))
 	}
+	if req.SendInvite && req.Type == UserTypeBot {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Bots cannot be invited", 400))
+	}
 
 	// Validate user type
 	if req.Type != UserTypeAgent && req.Type != UserTypeAdministrator && req.Type != UserTypeBot {
 		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user type", 400))
 	}
+	if req.Type == UserTypeAdministrator && !user.HasPermission(PermissionAll) {
+		return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can create administrators", 403))
+	}
 
 	// Check if email already exists
 	var existingUser User
@@ -173,16 +181,28 @@ func (c Controller) CreateUser(request *evo.Request) interface{} {
 		newUser.SecurityKey = req.SecurityKey
 	}
 
-	// Set password
-	if err := newUser.SetPassword(req.Password); err != nil {
-		return response.Error(response.ErrInternalError)
+	// Set password, unless the user chooses it from the invitation
+	if req.Password != "" {
+		if err := newUser.SetPassword(req.Password); err != nil {
+			return PasswordErrorResponse(err)
+		}
 	}
 
 	// Save to database
-	if err := db.Create(&newUser).Error; err != nil {
+	if err := db.GetContext(request).Create(&newUser).Error; err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
+	// The user is kept when the email fails; the invitation can be sent again from /invite
+	if req.SendInvite {
+		if _, errResponse := inviteUser(request, &newUser, user); errResponse != nil {
+			newUser.PasswordHash = nil
+			return response.OKWithMessage(map[string]interface{}{
+				"user": newUser,
+			}, "User created, but the invitation email could not be sent")
+		}
+	}
+
 	// Remove sensitive data before returning
 	newUser.PasswordHash = nil
 	newUser.APIKey = nil
@@ -196,7 +216,7 @@ func (c Controller) CreateUser(request *evo.Request) interface{} {
 func (c Controller) GetUser(request *evo.Request) interface{} {
 	// Check if user is administrator
 	user := request.User().Interface().(*User)
-	if user.Type != UserTypeAdministrator {
+	if !user.HasPermission(PermissionUsersManage) {
 		return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can access user management", 403))
 	}
 
@@ -213,7 +233,7 @@ func (c Controller) GetUser(request *evo.Request) interface{} {
 
 	// Find user
 	var targetUser User
-	if err := db.Where("id = ?", id).First(&targetUser).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", id).First(&targetUser).Error; err != nil {
 		return response.Error(response.NewError(response.ErrorCodeNotFound, "User not found", 404))
 	}
 
@@ -228,7 +248,7 @@ func (c Controller) GetUser(request *evo.Request) interface{} {
 func (c Controller) UpdateUser(request *evo.Request) interface{} {
 	// Check if user is administrator
 	user := request.User().Interface().(*User)
-	if user.Type != UserTypeAdministrator {
+	if !user.HasPermission(PermissionUsersManage) {
 		return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can update users", 403))
 	}
 
@@ -261,7 +281,7 @@ func (c Controller) UpdateUser(request *evo.Request) interface{} {
 
 	// Find user
 	var targetUser User
-	if err := db.Where("id = ?", id).First(&targetUser).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", id).First(&targetUser).Error; err != nil {
 		return response.Error(response.NewError(response.ErrorCodeNotFound, "User not found", 404))
 	}
 
@@ -287,6 +307,9 @@ func (c Controller) UpdateUser(request *evo.Request) interface{} {
 		if *req.Type != UserTypeAgent && *req.Type != UserTypeAdministrator && *req.Type != UserTypeBot {
 			return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user type", 400))
 		}
+		if (*req.Type == UserTypeAdministrator || targetUser.Type == UserTypeAdministrator) && *req.Type != targetUser.Type && !user.HasPermission(PermissionAll) {
+			return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can change administrator accounts", 403))
+		}
 		targetUser.Type = *req.Type
 	}
 	if req.Avatar != nil {
@@ -297,7 +320,7 @@ func (c Controller) UpdateUser(request *evo.Request) interface{} {
 	}
 	if req.Password != nil && *req.Password != "" {
 		if err := targetUser.SetPassword(*req.Password); err != nil {
-			return response.Error(response.ErrInternalError)
+			return PasswordErrorResponse(err)
 		}
 	}
 	// Update security_key for bot users
@@ -306,7 +329,7 @@ func (c Controller) UpdateUser(request *evo.Request) interface{} {
 	}
 
 	// Save updates
-	if err := db.Save(&targetUser).Error; err != nil {
+	if err := db.GetContext(request).Save(&targetUser).Error; err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
@@ -323,7 +346,7 @@ func (c Controller) UpdateUser(request *evo.Request) interface{} {
 func (c Controller) DeleteUser(request *evo.Request) interface{} {
 	// Check if user is administrator
 	user := request.User().Interface().(*User)
-	if user.Type != UserTypeAdministrator {
+	if !user.HasPermission(PermissionUsersManage) {
 		return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can delete users", 403))
 	}
 
@@ -345,14 +368,17 @@ func (c Controller) DeleteUser(request *evo.Request) interface{} {
 
 	// Find user
 	var targetUser User
-	if err := db.Where("id = ?", id).First(&targetUser).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", id).First(&targetUser).Error; err != nil {
 		return response.Error(response.NewError(response.ErrorCodeNotFound, "User not found", 404))
 	}
 
 	// Delete user
-	if err := db.Delete(&targetUser).Error; err != nil {
+	if err := db.GetContext(request).Delete(&targetUser).Error; err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
+	if _, err := RevokeUserSessions(targetUser.UserID, SessionRevokedDeleted, ""); err != nil {
+		log.Error("Failed to revoke sessions of deleted user %s: %v", targetUser.UserID, err)
+	}
 
 	return response.OKWithMessage(nil, "User deleted successfully")
 }
@@ -361,7 +387,7 @@ func (c Controller) DeleteUser(request *evo.Request) interface{} {
 func (c Controller) BlockUser(request *evo.Request) interface{} {
 	// Check if user is administrator
 	user := request.User().Interface().(*User)
-	if user.Type != UserTypeAdministrator {
+	if !user.HasPermission(PermissionUsersManage) {
 		return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can block users", 403))
 	}
 
@@ -383,16 +409,21 @@ func (c Controller) BlockUser(request *evo.Request) interface{} {
 
 	// Find user
 	var targetUser User
-	if err := db.Where("id = ?", id).First(&targetUser).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", id).First(&targetUser).Error; err != nil {
 		return response.Error(response.NewError(response.ErrorCodeNotFound, "User not found", 404))
 	}
 
 	// Update status
 	targetUser.Status = UserStatusBlocked
-	if err := db.Save(&targetUser).Error; err != nil {
+	if err := db.GetContext(request).Save(&targetUser).Error; err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
+	// Drop the user's API and WebSocket access right away instead of at token expiry
+	if _, err := RevokeUserSessions(targetUser.UserID, SessionRevokedBlocked, ""); err != nil {
+		log.Error("Failed to revoke sessions of blocked user %s: %v", targetUser.UserID, err)
+	}
+
 	return response.OKWithMessage(map[string]interface{}{
 		"id":     targetUser.UserID,
 		"email":  targetUser.Email,
@@ -404,7 +435,7 @@ func (c Controller) BlockUser(request *evo.Request) interface{} {
 func (c Controller) UnblockUser(request *evo.Request) interface{} {
 	// Check if user is administrator
 	user := request.User().Interface().(*User)
-	if user.Type != UserTypeAdministrator {
+	if !user.HasPermission(PermissionUsersManage) {
 		return response.Error(response.NewError(response.ErrorCodeForbidden, "Only administrators can unblock users", 403))
 	}
 
@@ -421,13 +452,13 @@ func (c Controller) UnblockUser(request *evo.Request) interface{} {
 
 	// Find user
 	var targetUser User
-	if err := db.Where("id = ?", id).First(&targetUser).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", id).First(&targetUser).Error; err != nil {
 		return response.Error(response.NewError(response.ErrorCodeNotFound, "User not found", 404))
 	}
 
 	// Update status
 	targetUser.Status = UserStatusActive
-	if err := db.Save(&targetUser).Error; err != nil {
+	if err := db.GetContext(request).Save(&targetUser).Error; err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
@@ -68,7 +68,7 @@ func (c Controller) SendMessage(request *evo.Request) interface{} {
 
 	// Find the bot user
 	var botUser auth.User
-	if err := db.Where("id = ?", botID).First(&botUser).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", botID).First(&botUser).Error; err != nil {
 		return response.Error(response.NewError(response.ErrorCodeNotFound, "Bot not found", 404))
 	}
 
@@ -95,7 +95,7 @@ func (c Controller) SendMessage(request *evo.Request) interface{} {
 
 	// Check if conversation exists
 	var conversation models.Conversation
-	if err := db.Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", conversationID).First(&conversation).Error; err != nil {
 		return response.Error(response.NewError(response.ErrorCodeNotFound, "Conversation not found", 404))
 	}
 
@@ -107,7 +107,7 @@ func (c Controller) SendMessage(request *evo.Request) interface{} {
 		CreatedAt:      time.Now(),
 	}
 
-	if err := db.Create(&message).Error; err != nil {
+	if err := db.GetContext(request).Create(&message).Error; err != nil {
 		return response.Error(response.NewError(response.ErrorCodeInternalError, "Failed to create message", 500))
 	}
 
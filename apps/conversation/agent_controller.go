@@ -42,9 +42,10 @@ func (ac AgentController) GetConversationDetail(req *evo.Request) interface{} {
 	}
 
 	var conv models.Conversation
-	if err := db.Where("id = ?", conversationID).
+	if err := db.GetContext(req).Where("id = ?", conversationID).
 		Preload("Client").
 		Preload("Client.ExternalIDs").
+		Preload("Client.Organization").
 		Preload("Department").
 		Preload("Channel").
 		Preload("Tags").
@@ -93,7 +94,7 @@ func (ac AgentController) GetConversationDetail(req *evo.Request) interface{} {
 
 	var lastMessage models.Message
 	var hasLastMessage bool
-	if err := db.Where("conversation_id = ?", conv.ID).
+	if err := db.GetContext(req).Where("conversation_id = ?", conv.ID).
 		Order("created_at DESC").
 		First(&lastMessage).Error; err == nil {
 		hasLastMessage = true
@@ -112,7 +113,7 @@ func (ac AgentController) GetConversationDetail(req *evo.Request) interface{} {
 	}
 
 	var messageCount int64
-	db.Model(&models.Message{}).Where("conversation_id = ?", conv.ID).Count(&messageCount)
+	db.GetContext(req).Model(&models.Message{}).Where("conversation_id = ?", conv.ID).Count(&messageCount)
 
 	var email string
 	var phone *string
@@ -146,16 +147,17 @@ func (ac AgentController) GetConversationDetail(req *evo.Request) interface{} {
 		UnreadMessagesCount: 0,
 		IsAssignedToMe:      isAssignedToMe,
 		Customer: CustomerInfo{
-			ID:          conv.Client.ID.String(),
-			Name:        conv.Client.Name,
-			Email:       email,
-			Phone:       phone,
-			AvatarURL:   conv.Client.Avatar,
-			Initials:    initials,
-			ExternalIDs: externalIDs,
-			Language:    conv.Client.Language,
-			Timezone:    conv.Client.Timezone,
-			Data:        parseJSONToMap(conv.Client.Data),
+			ID:           conv.Client.ID.String(),
+			Name:         conv.Client.Name,
+			Email:        email,
+			Phone:        phone,
+			AvatarURL:    conv.Client.Avatar,
+			Initials:     initials,
+			ExternalIDs:  externalIDs,
+			Language:     conv.Client.Language,
+			Timezone:     conv.Client.Timezone,
+			Data:         parseJSONToMap(conv.Client.Data),
+			Organization: newOrganizationInfo(conv.Client.Organization),
 		},
 		AssignedAgents:  assignedAgents,
 		Department:      department,
@@ -190,7 +192,7 @@ func (ac AgentController) GetConversationDetail(req *evo.Request) interface{} {
 	}
 
 	var messages []models.Message
-	query := db.Where("conversation_id = ?", conversationID).
+	query := db.GetContext(req).Where("conversation_id = ?", conversationID).
 		Preload("User").
 		Preload("Client").
 		Limit(limit).
@@ -292,6 +294,8 @@ type PreviousConversationItem struct {
 	Title              string `json:"title"`
 	Status             string `json:"status"`
 	Priority           string `json:"priority"`
+	ClientID           string `json:"client_id,omitempty"`
+	ClientName         string `json:"client_name,omitempty"`
 	CreatedAt          string `json:"created_at"`
 	UpdatedAt          string `json:"updated_at"`
 }
@@ -327,7 +331,7 @@ func (ac AgentController) GetClientPreviousConversations(req *evo.Request) inter
 	currentConversationID := req.Query("exclude_id").Uint()
 
 	var conversations []models.Conversation
-	query := db.Where("client_id = ?", clientID).
+	query := db.GetContext(req).Where("client_id = ?", clientID).
 		Order("updated_at DESC")
 
 	if currentConversationID > 0 {
@@ -397,7 +401,7 @@ func (ac AgentController) UpdateConversationProperties(req *evo.Request) interfa
 	}
 
 	var conversation models.Conversation
-	if err := db.Preload("Department").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+	if err := db.GetContext(req).Preload("Department").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, fmt.Sprintf("No conversation exists with ID %d", conversationID)))
 	}
 
@@ -441,15 +445,17 @@ func (ac AgentController) UpdateConversationProperties(req *evo.Request) interfa
 
 	var actorName string
 	var userID *uuid.UUID
+	var actor *auth.User
 	if !req.User().Anonymous() {
-		user := req.User().Interface().(*auth.User)
-		actorName = user.Name
-		if user.LastName != "" {
-			actorName = user.Name + " " + user.LastName
+		actor = req.User().Interface().(*auth.User)
+		actorName = actor.Name
+		if actor.LastName != "" {
+			actorName = actor.Name + " " + actor.LastName
 		}
-		userID = &user.UserID
+		userID = &actor.UserID
 	}
 
+	ip, userAgent := req.IP(), req.Header("User-Agent")
 	updateData := make(map[string]interface{})
 
 	if updateReq.Priority != nil {
@@ -476,7 +482,11 @@ func (ac AgentController) UpdateConversationProperties(req *evo.Request) interfa
 		}
 	}
 
+	var newDept models.Department
 	if updateReq.DepartmentID != nil {
+		if err := db.GetContext(req).Where("id = ?", *updateReq.DepartmentID).First(&newDept).Error; err != nil {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid department", 400, "Department not found"))
+		}
 		updateData["department_id"] = *updateReq.DepartmentID
 	}
 
@@ -485,7 +495,7 @@ func (ac AgentController) UpdateConversationProperties(req *evo.Request) interfa
 	}
 
 	if len(updateData) > 0 {
-		if err := db.Model(&conversation).Updates(updateData).Error; err != nil {
+		if err := db.GetContext(req).Model(&conversation).Updates(updateData).Error; err != nil {
 			log.Error("Failed to update conversation: ", err)
 			return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to update conversation", 500, err.Error()))
 		}
@@ -494,6 +504,7 @@ func (ac AgentController) UpdateConversationProperties(req *evo.Request) interfa
 			if updateReq.Status != nil && *updateReq.Status != oldStatus {
 				action := fmt.Sprintf(`set conversation status to "%s"`, getStatusDisplayName(*updateReq.Status))
 				models.CreateActionMessage(conversationID, userID, actorName, action)
+				models.LogConversationStatusChange(conversationID, actor, oldStatus, *updateReq.Status, ip, userAgent)
 			}
 
 			if updateReq.Priority != nil && *updateReq.Priority != oldPriority {
@@ -512,21 +523,18 @@ func (ac AgentController) UpdateConversationProperties(req *evo.Request) interfa
 			}
 
 			if updateReq.DepartmentID != nil && (oldDepartmentID == nil || *updateReq.DepartmentID != *oldDepartmentID) {
-				var newDept models.Department
-				if err := db.Where("id = ?", *updateReq.DepartmentID).First(&newDept).Error; err == nil {
-					var action string
-					if oldDepartmentName != "" {
-						action = fmt.Sprintf(`switched department from "%s" to "%s"`, oldDepartmentName, newDept.Name)
-					} else {
-						action = fmt.Sprintf(`set department to "%s"`, newDept.Name)
-					}
-					models.CreateActionMessage(conversationID, userID, actorName, action)
+				var action string
+				if oldDepartmentName != "" {
+					action = fmt.Sprintf(`switched department from "%s" to "%s"`, oldDepartmentName, newDept.Name)
+				} else {
+					action = fmt.Sprintf(`set department to "%s"`, newDept.Name)
 				}
+				models.CreateActionMessage(conversationID, userID, actorName, action)
 			}
 		}()
 	}
 
-	if err := db.Preload("Client").Preload("Department").Preload("Tags").First(&conversation, conversationID).Error; err != nil {
+	if err := db.GetContext(req).Preload("Client").Preload("Department").Preload("Tags").First(&conversation, conversationID).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to reload conversation", 500, err.Error()))
 	}
 
@@ -550,7 +558,7 @@ func (ac AgentController) UpdateConversationProperties(req *evo.Request) interfa
 // @Router /api/agent/departments [get]
 func (ac AgentController) GetDepartments(req *evo.Request) interface{} {
 	var departments []models.Department
-	if err := db.Find(&departments).Error; err != nil {
+	if err := db.GetContext(req).Find(&departments).Error; err != nil {
 		log.Error("Failed to get departments:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to get departments", 500, err.Error()))
 	}
@@ -564,7 +572,7 @@ func (ac AgentController) GetDepartments(req *evo.Request) interface{} {
 	result := make([]DepartmentWithCount, 0, len(departments))
 	for _, dept := range departments {
 		var agentCount int64
-		db.Model(&models.UserDepartment{}).Where("department_id = ?", dept.ID).Count(&agentCount)
+		db.GetContext(req).Model(&models.UserDepartment{}).Where("department_id = ?", dept.ID).Count(&agentCount)
 
 		result = append(result, DepartmentWithCount{
 			ID:         dept.ID,
@@ -591,7 +599,7 @@ func (ac AgentController) GetMyDepartments(req *evo.Request) interface{} {
 	}
 
 	var userDepartments []models.UserDepartment
-	if err := db.Where("user_id = ?", user.UserID).Find(&userDepartments).Error; err != nil {
+	if err := db.GetContext(req).Where("user_id = ?", user.UserID).Find(&userDepartments).Error; err != nil {
 		log.Error("Failed to get user departments:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to get user departments", 500, err.Error()))
 	}
@@ -606,7 +614,7 @@ func (ac AgentController) GetMyDepartments(req *evo.Request) interface{} {
 	}
 
 	var departments []models.Department
-	if err := db.Where("id IN ?", deptIDs).Find(&departments).Error; err != nil {
+	if err := db.GetContext(req).Where("id IN ?", deptIDs).Find(&departments).Error; err != nil {
 		log.Error("Failed to get departments:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to get departments", 500, err.Error()))
 	}
@@ -617,7 +625,7 @@ func (ac AgentController) GetMyDepartments(req *evo.Request) interface{} {
 // GetUsers handles the GET /api/agent/users endpoint
 func (ac AgentController) GetUsers(req *evo.Request) interface{} {
 	var users []auth.User
-	query := db.Model(&auth.User{}).Select("id, name, last_name, display_name, email, avatar")
+	query := db.GetContext(req).Model(&auth.User{}).Select("id, name, last_name, display_name, email, avatar")
 
 	if search := req.Query("search").String(); search != "" {
 		query = query.Where(
@@ -687,7 +695,7 @@ func (c AgentController) UploadUserAvatar(request *evo.Request) any {
 		return response.Error(response.ErrInternalError)
 	}
 
-	if err := db.Model(&auth.User{}).Where("id = ?", user.UserID).Update("avatar", avatarURL).Error; err != nil {
+	if err := db.GetContext(request).Model(&auth.User{}).Where("id = ?", user.UserID).Update("avatar", avatarURL).Error; err != nil {
 		log.Error("Failed to update user avatar:", err)
 		return response.Error(response.ErrInternalError)
 	}
@@ -711,7 +719,7 @@ func (c AgentController) DeleteUserAvatar(request *evo.Request) any {
 		}
 	}
 
-	if err := db.Model(&auth.User{}).Where("id = ?", user.UserID).Update("avatar", nil).Error; err != nil {
+	if err := db.GetContext(request).Model(&auth.User{}).Where("id = ?", user.UserID).Update("avatar", nil).Error; err != nil {
 		log.Error("Failed to update user:", err)
 		return response.Error(response.ErrInternalError)
 	}
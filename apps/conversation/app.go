@@ -2,12 +2,14 @@ package conversation
 
 import (
 	"github.com/getevo/evo/v2"
+	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/apps/redis"
 )
 
 type App struct{}
 
 func (a App) Register() error {
+	models.RenderCampaignMessage = renderCampaignMessage
 
 	return nil
 }
@@ -20,10 +22,13 @@ func (a App) Router() error {
 	// Client-facing APIs with rate limiting
 	evo.Use("/api/client/conversations", redis.EvoRateLimitMiddleware("client.create_conversation"))
 	evo.Put("/api/client/conversations", controller.CreateConversation)
+	evo.Post("/api/client/conversations/history", controller.GetClientConversationHistory)
 	evo.Post("/api/client/conversations/:conversation_id/:secret/messages", controller.AddClientMessage)
 	evo.Get("/api/client/conversations/:conversation_id/:secret", controller.GetConversationWithSecret)
 	evo.Delete("/api/client/conversations/:conversation_id/:secret", controller.CloseConversationWithSecret)
 	evo.Post("/api/client/upsert", controller.UpsertClient)
+	evo.Get("/api/client/campaigns/unsubscribe/:token", controller.UnsubscribeCampaign)
+	evo.Post("/api/client/campaigns/unsubscribe/:token", controller.UnsubscribeCampaign)
 
 	// Admin conversation APIs
 	evo.Get("/api/admin/conversations/:conversation_id", controller.GetConversationDetail)
@@ -39,6 +44,10 @@ func (a App) Router() error {
 	evo.Get("/api/agent/conversations/:id", agentController.GetConversationDetail)
 	evo.Get("/api/agent/conversations/:conversation_id/messages", agentController.GetConversationMessages)
 	evo.Post("/api/agent/conversations/:id/messages", agentController.AddAgentMessage)
+	evo.Get("/api/agent/conversations/:id/scheduled-messages", agentController.ListScheduledMessages)
+	evo.Post("/api/agent/conversations/:id/scheduled-messages", agentController.CreateScheduledMessage)
+	evo.Put("/api/agent/scheduled-messages/:id", agentController.UpdateScheduledMessage)
+	evo.Delete("/api/agent/scheduled-messages/:id", agentController.CancelScheduledMessage)
 	evo.Get("/api/agent/conversations/unread-count", agentController.GetUnreadCount)
 	evo.Patch("/api/agent/conversations/:id/read", agentController.MarkConversationRead)
 	evo.Get("/api/agent/departments", agentController.GetDepartments)
@@ -47,6 +56,8 @@ func (a App) Router() error {
 	evo.Get("/api/agent/tags", agentController.GetTags)
 	evo.Post("/api/agent/tags", agentController.CreateTag)
 	evo.Get("/api/agent/clients/:client_id/conversations", agentController.GetClientPreviousConversations)
+	evo.Get("/api/agent/clients/:id/timeline", agentController.GetClientTimeline)
+	evo.Post("/api/agent/clients/:id/conversations", agentController.StartOutboundConversation)
 	evo.Get("/api/agent/clients", agentController.ListClients)
 	evo.Get("/api/agent/clients/:id", agentController.GetClient)
 	evo.Post("/api/agent/clients", agentController.CreateClient)
@@ -71,6 +82,27 @@ func (a App) Router() error {
 	evo.Post("/api/agent/canned-messages", agentController.CreateCannedMessage)
 	evo.Put("/api/agent/canned-messages/:id", agentController.UpdateCannedMessage)
 	evo.Delete("/api/agent/canned-messages/:id", agentController.DeleteCannedMessage)
+	evo.Post("/api/agent/canned-messages/:id/render", agentController.RenderCannedMessage)
+
+	// Agent Organizations APIs
+	evo.Get("/api/agent/organizations", agentController.ListOrganizations)
+	evo.Get("/api/agent/organizations/:id", agentController.GetOrganization)
+	evo.Post("/api/agent/organizations", agentController.CreateOrganization)
+	evo.Put("/api/agent/organizations/:id", agentController.UpdateOrganization)
+	evo.Delete("/api/agent/organizations/:id", agentController.DeleteOrganization)
+	evo.Get("/api/agent/organizations/:id/clients", agentController.GetOrganizationClients)
+	evo.Get("/api/agent/organizations/:id/conversations", agentController.GetOrganizationConversations)
+	evo.Post("/api/agent/organizations/:id/notes", agentController.AddOrganizationNote)
+	evo.Delete("/api/agent/organizations/:id/notes/:note_id", agentController.DeleteOrganizationNote)
+
+	// Agent Macros APIs
+	evo.Get("/api/agent/macros", agentController.ListMacros)
+	evo.Get("/api/agent/macros/:id", agentController.GetMacro)
+	evo.Post("/api/agent/macros", agentController.CreateMacro)
+	evo.Put("/api/agent/macros/:id", agentController.UpdateMacro)
+	evo.Delete("/api/agent/macros/:id", agentController.DeleteMacro)
+	evo.Post("/api/agent/conversations/:id/macros/:macro_id/preview", agentController.PreviewMacro)
+	evo.Post("/api/agent/conversations/:id/macros/:macro_id/apply", agentController.ApplyMacro)
 
 	// User Avatar APIs
 	evo.Post("/api/agent/me/avatar", agentController.UploadUserAvatar)
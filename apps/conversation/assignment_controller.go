@@ -31,13 +31,13 @@ func (ac AgentController) AssignConversation(req *evo.Request) interface{} {
 	}
 
 	var conversation models.Conversation
-	if err := db.Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+	if err := db.GetContext(req).Where("id = ?", conversationID).First(&conversation).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, fmt.Sprintf("No conversation exists with ID %d", conversationID)))
 	}
 
 	// Get old assignments with user names for action messages
 	var oldAssignments []models.ConversationAssignment
-	db.Preload("User").Where("conversation_id = ? AND user_id IS NOT NULL", conversationID).Find(&oldAssignments)
+	db.GetContext(req).Preload("User").Where("conversation_id = ? AND user_id IS NOT NULL", conversationID).Find(&oldAssignments)
 	oldUserNames := make(map[string]string)
 	for _, a := range oldAssignments {
 		if a.User != nil {
@@ -62,7 +62,7 @@ func (ac AgentController) AssignConversation(req *evo.Request) interface{} {
 	}
 
 	// Clear existing assignments
-	if err := db.Where("conversation_id = ?", conversationID).Delete(&models.ConversationAssignment{}).Error; err != nil {
+	if err := db.GetContext(req).Where("conversation_id = ?", conversationID).Delete(&models.ConversationAssignment{}).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to clear assignments", 500, err.Error()))
 	}
 
@@ -80,14 +80,14 @@ func (ac AgentController) AssignConversation(req *evo.Request) interface{} {
 			ConversationID: conversationID,
 			UserID:         &userID,
 		}
-		if err := db.Create(&assignment).Error; err != nil {
+		if err := db.GetContext(req).Create(&assignment).Error; err != nil {
 			log.Error("Failed to assign user: ", err)
 			return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to assign user", 500, err.Error()))
 		}
 
 		// Get user name for action message
 		var user auth.User
-		if err := db.Where("id = ?", userID).First(&user).Error; err == nil {
+		if err := db.GetContext(req).Where("id = ?", userID).First(&user).Error; err == nil {
 			name := user.Name
 			if user.LastName != "" {
 				name = user.Name + " " + user.LastName
@@ -102,7 +102,7 @@ func (ac AgentController) AssignConversation(req *evo.Request) interface{} {
 			ConversationID: conversationID,
 			DepartmentID:   assignReq.DepartmentID,
 		}
-		if err := db.Create(&assignment).Error; err != nil {
+		if err := db.GetContext(req).Create(&assignment).Error; err != nil {
 			log.Error("Failed to assign department: ", err)
 			return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to assign department", 500, err.Error()))
 		}
@@ -138,7 +138,7 @@ func (ac AgentController) UnassignConversation(req *evo.Request) interface{} {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid conversation ID", 400, "Conversation ID must be a positive integer"))
 	}
 
-	if err := db.Where("conversation_id = ?", conversationID).Delete(&models.ConversationAssignment{}).Error; err != nil {
+	if err := db.GetContext(req).Where("conversation_id = ?", conversationID).Delete(&models.ConversationAssignment{}).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to clear assignments", 500, err.Error()))
 	}
 
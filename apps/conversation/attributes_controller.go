@@ -9,6 +9,8 @@ import (
 	"github.com/getevo/evo/v2/lib/log"
 	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 // ListCustomAttributes returns paginated list of custom attributes with search and filtering
@@ -18,8 +20,9 @@ import (
 // @Accept json
 // @Produce json
 // @Param search query string false "Search in name, title, description"
-// @Param scope query string false "Filter by scope (client or conversation)"
-// @Param data_type query string false "Filter by data type (int, float, date, string)"
+// @Param scope query string false "Filter by scope (client, conversation or organization)"
+// @Param data_type query string false "Filter by data type (int, float, date, datetime, string, select, multi_select, boolean, email, url, phone)"
+// @Param department_id query int false "Filter conversation attributes by department (includes attributes shared by all departments)"
 // @Param visibility query string false "Filter by visibility (everyone, administrator, hidden)"
 // @Param order_by query string false "Order by field (name, title, scope, created_at)"
 // @Param page query int false "Page number" default(1)
@@ -42,7 +45,7 @@ func (ac AgentController) ListCustomAttributes(req *evo.Request) interface{} {
 
 	offset := (page - 1) * limit
 
-	query := db.Model(&models.CustomAttribute{})
+	query := db.GetContext(req).Model(&models.CustomAttribute{})
 
 	search := req.Query("search").String()
 	if search != "" {
@@ -63,6 +66,10 @@ func (ac AgentController) ListCustomAttributes(req *evo.Request) interface{} {
 		query = query.Where("visibility = ?", visibility)
 	}
 
+	if departmentID := req.Query("department_id").Uint(); departmentID > 0 {
+		query = query.Where("department_id IS NULL OR department_id = ?", departmentID)
+	}
+
 	orderBy := req.Query("order_by").String()
 	switch orderBy {
 	case "name":
@@ -111,13 +118,15 @@ func (ac AgentController) ListCustomAttributes(req *evo.Request) interface{} {
 // @Router /api/agent/attributes [post]
 func (ac AgentController) CreateCustomAttribute(req *evo.Request) interface{} {
 	type CreateRequest struct {
-		Scope       string  `json:"scope"`
-		Name        string  `json:"name"`
-		DataType    string  `json:"data_type"`
-		Validation  *string `json:"validation"`
-		Title       string  `json:"title"`
-		Description *string `json:"description"`
-		Visibility  string  `json:"visibility"`
+		Scope        string         `json:"scope"`
+		Name         string         `json:"name"`
+		DataType     string         `json:"data_type"`
+		Validation   *string        `json:"validation"`
+		Options      datatypes.JSON `json:"options"`
+		DepartmentID *uint          `json:"department_id"`
+		Title        string         `json:"title"`
+		Description  *string        `json:"description"`
+		Visibility   string         `json:"visibility"`
 	}
 
 	var createReq CreateRequest
@@ -129,13 +138,8 @@ func (ac AgentController) CreateCustomAttribute(req *evo.Request) interface{} {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Missing required fields", 400, "scope, name, data_type, title, and visibility are required"))
 	}
 
-	if createReq.Scope != "client" && createReq.Scope != "conversation" {
-		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid scope", 400, "Scope must be 'client' or 'conversation'"))
-	}
-
-	validDataTypes := map[string]bool{"int": true, "float": true, "date": true, "string": true}
-	if !validDataTypes[createReq.DataType] {
-		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid data type", 400, "Data type must be one of: int, float, date, string"))
+	if createReq.Scope != models.CustomAttributeScopeClient && createReq.Scope != models.CustomAttributeScopeConversation && createReq.Scope != models.CustomAttributeScopeOrganization {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid scope", 400, "Scope must be 'client', 'conversation' or 'organization'"))
 	}
 
 	validVisibilities := map[string]bool{"everyone": true, "administrator": true, "hidden": true}
@@ -150,16 +154,22 @@ func (ac AgentController) CreateCustomAttribute(req *evo.Request) interface{} {
 	}
 
 	customAttr := models.CustomAttribute{
-		Scope:       createReq.Scope,
-		Name:        name,
-		DataType:    createReq.DataType,
-		Validation:  createReq.Validation,
-		Title:       createReq.Title,
-		Description: createReq.Description,
-		Visibility:  createReq.Visibility,
+		Scope:        createReq.Scope,
+		Name:         name,
+		DataType:     createReq.DataType,
+		Validation:   createReq.Validation,
+		Options:      createReq.Options,
+		DepartmentID: createReq.DepartmentID,
+		Title:        createReq.Title,
+		Description:  createReq.Description,
+		Visibility:   createReq.Visibility,
+	}
+
+	if err := ValidateAttributeDefinition(db.GetContext(req), customAttr); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid custom attribute definition", 400, err.Error()))
 	}
 
-	if err := db.Create(&customAttr).Error; err != nil {
+	if err := db.GetContext(req).Create(&customAttr).Error; err != nil {
 		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "Duplicate") {
 			return response.Error(response.NewErrorWithDetails(response.ErrorCodeConflict, "Custom attribute with this scope and name already exists", 409, err.Error()))
 		}
@@ -190,11 +200,13 @@ func (ac AgentController) UpdateCustomAttribute(req *evo.Request) interface{} {
 	}
 
 	type UpdateRequest struct {
-		DataType    string  `json:"data_type"`
-		Validation  *string `json:"validation"`
-		Title       string  `json:"title"`
-		Description *string `json:"description"`
-		Visibility  string  `json:"visibility"`
+		DataType     string         `json:"data_type"`
+		Validation   *string        `json:"validation"`
+		Options      datatypes.JSON `json:"options"`
+		DepartmentID *uint          `json:"department_id"`
+		Title        string         `json:"title"`
+		Description  *string        `json:"description"`
+		Visibility   string         `json:"visibility"`
 	}
 
 	var updateReq UpdateRequest
@@ -206,33 +218,38 @@ func (ac AgentController) UpdateCustomAttribute(req *evo.Request) interface{} {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Missing required fields", 400, "data_type, title, and visibility are required"))
 	}
 
-	validDataTypes := map[string]bool{"int": true, "float": true, "date": true, "string": true}
-	if !validDataTypes[updateReq.DataType] {
-		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid data type", 400, "Data type must be one of: int, float, date, string"))
-	}
-
 	validVisibilities := map[string]bool{"everyone": true, "administrator": true, "hidden": true}
 	if !validVisibilities[updateReq.Visibility] {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid visibility", 400, "Visibility must be one of: everyone, administrator, hidden"))
 	}
 
 	var customAttr models.CustomAttribute
-	if err := db.Where("scope = ? AND name = ?", scope, name).First(&customAttr).Error; err != nil {
+	if err := db.GetContext(req).Where("scope = ? AND name = ?", scope, name).First(&customAttr).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Custom attribute not found", 404, fmt.Sprintf("No attribute found with scope '%s' and name '%s'", scope, name)))
 	}
 
-	if err := db.Model(&customAttr).Updates(models.CustomAttribute{
-		DataType:    updateReq.DataType,
-		Validation:  updateReq.Validation,
-		Title:       updateReq.Title,
-		Description: updateReq.Description,
-		Visibility:  updateReq.Visibility,
+	customAttr.DataType = updateReq.DataType
+	customAttr.Validation = updateReq.Validation
+	customAttr.Options = updateReq.Options
+	customAttr.DepartmentID = updateReq.DepartmentID
+	if err := ValidateAttributeDefinition(db.GetContext(req), customAttr); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid custom attribute definition", 400, err.Error()))
+	}
+
+	if err := db.GetContext(req).Model(&customAttr).Select("data_type", "validation", "options", "department_id", "title", "description", "visibility").Updates(models.CustomAttribute{
+		DataType:     updateReq.DataType,
+		Validation:   updateReq.Validation,
+		Options:      updateReq.Options,
+		DepartmentID: updateReq.DepartmentID,
+		Title:        updateReq.Title,
+		Description:  updateReq.Description,
+		Visibility:   updateReq.Visibility,
 	}).Error; err != nil {
 		log.Error("Failed to update custom attribute:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to update attribute", 500, err.Error()))
 	}
 
-	db.Where("scope = ? AND name = ?", scope, name).First(&customAttr)
+	db.GetContext(req).Where("scope = ? AND name = ?", scope, name).First(&customAttr)
 
 	return response.OK(customAttr)
 }
@@ -256,11 +273,11 @@ func (ac AgentController) DeleteCustomAttribute(req *evo.Request) interface{} {
 	}
 
 	var customAttr models.CustomAttribute
-	if err := db.Where("scope = ? AND name = ?", scope, name).First(&customAttr).Error; err != nil {
+	if err := db.GetContext(req).Where("scope = ? AND name = ?", scope, name).First(&customAttr).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Custom attribute not found", 404, fmt.Sprintf("No attribute found with scope '%s' and name '%s'", scope, name)))
 	}
 
-	if err := db.Delete(&customAttr).Error; err != nil {
+	if err := db.GetContext(req).Delete(&customAttr).Error; err != nil {
 		log.Error("Failed to delete custom attribute:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to delete attribute", 500, err.Error()))
 	}
@@ -271,3 +288,27 @@ func (ac AgentController) DeleteCustomAttribute(req *evo.Request) interface{} {
 		"name":    name,
 	})
 }
+
+// ValidateAttributeDefinition checks an attribute definition before it is saved, including that
+// its department exists in the workspace of tx and that its default value is itself valid
+func ValidateAttributeDefinition(tx *gorm.DB, attr models.CustomAttribute) error {
+	if err := attr.ValidateDefinition(); err != nil {
+		return err
+	}
+	if attr.DepartmentID != nil {
+		var count int64
+		if err := tx.Model(&models.Department{}).Where("id = ?", *attr.DepartmentID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to verify department: %w", err)
+		}
+		if count == 0 {
+			return fmt.Errorf("department %d not found", *attr.DepartmentID)
+		}
+	}
+
+	if defaultValue, ok := attr.Rules().Get("default"); ok {
+		if _, err := castAndValidateValue(attr, defaultValue); err != nil {
+			return fmt.Errorf("invalid default value: %w", err)
+		}
+	}
+	return nil
+}
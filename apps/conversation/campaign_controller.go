@@ -0,0 +1,47 @@
+package conversation
+
+import (
+	"strings"
+
+	"github.com/getevo/evo/v2"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// renderCampaignMessage replaces {{client.*}} placeholders in a campaign body.
+// Campaigns have no conversation or agent, so every other placeholder, and client
+// attributes the client does not have, render as empty text.
+// The client must have ExternalIDs loaded.
+func renderCampaignMessage(body string, client *models.Client) string {
+	conversation := &models.Conversation{Client: *client}
+	return cannedPlaceholderRegex.ReplaceAllStringFunc(body, func(match string) string {
+		parts := cannedPlaceholderRegex.FindStringSubmatch(match)
+		if strings.ToLower(parts[1]) != "client" {
+			return ""
+		}
+		value, _ := resolveCannedPlaceholder("client", parts[2], conversation, nil)
+		return value
+	})
+}
+
+// UnsubscribeCampaign opts a client out of campaigns using the link sent with a campaign email
+// @Summary Unsubscribe from campaigns
+// @Description Opt the recipient of a campaign message out of further campaigns on the same channel. Accepts GET for link clicks and POST for one-click unsubscribe (RFC 8058).
+// @Tags Client Campaigns
+// @Produce json
+// @Param token path string true "Unsubscribe token"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/client/campaigns/unsubscribe/{token} [get]
+// @Router /api/client/campaigns/unsubscribe/{token} [post]
+func (c Controller) UnsubscribeCampaign(req *evo.Request) interface{} {
+	if _, err := models.UnsubscribeByToken(req.Param("token").String()); err != nil {
+		if err == models.ErrUnsubscribeTokenInvalid {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Invalid unsubscribe link", 404, err.Error()))
+		}
+		return response.Error(response.ErrInternalError)
+	}
+
+	return response.OK(map[string]interface{}{
+		"message": "You have been unsubscribed",
+	})
+}
@@ -6,23 +6,64 @@ import (
 	"github.com/getevo/evo/v2"
 	"github.com/getevo/evo/v2/lib/db"
 	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/auth"
 	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/gorm"
 )
 
+// CannedMessageVariantInput is a per-language body of a canned message
+type CannedMessageVariantInput struct {
+	Language string `json:"language"`
+	Message  string `json:"message"`
+}
+
+// CannedMessageAttachmentInput is a file sent along with a canned message
+type CannedMessageAttachmentInput struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// CannedMessageRequest is the request body for creating and updating canned messages
+type CannedMessageRequest struct {
+	Title        string                          `json:"title"`
+	Message      string                          `json:"message"`
+	Shortcut     *string                         `json:"shortcut"`
+	IsActive     *bool                           `json:"is_active"`
+	Scope        string                          `json:"scope"`
+	DepartmentID *uint                           `json:"department_id"`
+	Variants     *[]CannedMessageVariantInput    `json:"variants"`
+	Attachments  *[]CannedMessageAttachmentInput `json:"attachments"`
+}
+
+// RenderCannedMessageRequest is the request body for rendering a canned message
+type RenderCannedMessageRequest struct {
+	ConversationID uint   `json:"conversation_id"`
+	Language       string `json:"language"`
+}
+
 // ListCannedMessages returns paginated list of canned messages
 // @Summary List canned messages
-// @Description Get a paginated list of canned messages with optional filtering
+// @Description Get a paginated list of canned messages visible to the current user (global, own departments and personal)
 // @Tags Agent - Canned Messages
 // @Accept json
 // @Produce json
-// @Param search query string false "Search in title or message content"
+// @Param search query string false "Search in title, shortcut or message content"
 // @Param is_active query bool false "Filter by active status"
+// @Param scope query string false "Filter by scope (personal, department, global)"
+// @Param department_id query int false "Filter by department"
 // @Param page query int false "Page number" default(1)
 // @Param limit query int false "Items per page" default(20)
 // @Success 200 {object} response.Response
 // @Router /api/agent/canned-messages [get]
 func (ac AgentController) ListCannedMessages(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
 	page := req.Query("page").Int()
 	if page < 1 {
 		page = 1
@@ -38,12 +79,16 @@ func (ac AgentController) ListCannedMessages(req *evo.Request) interface{} {
 
 	offset := (page - 1) * limit
 
-	query := db.Model(&models.CannedMessage{})
+	query, err := visibleCannedMessages(db.GetContext(req).Model(&models.CannedMessage{}), user)
+	if err != nil {
+		log.Error("Failed to get user departments:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch messages", 500, err.Error()))
+	}
 
 	search := req.Query("search").String()
 	if search != "" {
 		searchTerm := "%" + search + "%"
-		query = query.Where("title LIKE ? OR message LIKE ?", searchTerm, searchTerm)
+		query = query.Where("title LIKE ? OR message LIKE ? OR shortcut LIKE ?", searchTerm, searchTerm, searchTerm)
 	}
 
 	if isActiveStr := req.Query("is_active").String(); isActiveStr != "" {
@@ -51,6 +96,14 @@ func (ac AgentController) ListCannedMessages(req *evo.Request) interface{} {
 		query = query.Where("is_active = ?", isActive)
 	}
 
+	if scope := req.Query("scope").String(); scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+
+	if departmentID := req.Query("department_id").Int(); departmentID > 0 {
+		query = query.Where("department_id = ?", departmentID)
+	}
+
 	query = query.Order("created_at DESC")
 
 	var total int64
@@ -60,7 +113,7 @@ func (ac AgentController) ListCannedMessages(req *evo.Request) interface{} {
 	}
 
 	var messages []models.CannedMessage
-	if err := query.Limit(limit).Offset(offset).Find(&messages).Error; err != nil {
+	if err := query.Preload("Variants").Preload("Attachments").Limit(limit).Offset(offset).Find(&messages).Error; err != nil {
 		log.Error("Failed to fetch canned messages:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch messages", 500, err.Error()))
 	}
@@ -88,14 +141,19 @@ func (ac AgentController) ListCannedMessages(req *evo.Request) interface{} {
 // @Success 200 {object} response.Response
 // @Router /api/agent/canned-messages/{id} [get]
 func (ac AgentController) GetCannedMessage(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
 	id := req.Param("id").Uint()
 	if id == 0 {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid message ID", 400, "Message ID must be a positive integer"))
 	}
 
-	var message models.CannedMessage
-	if err := db.Where("id = ?", id).First(&message).Error; err != nil {
-		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Canned message not found", 404, err.Error()))
+	message, errResp := findVisibleCannedMessage(id, user)
+	if errResp != nil {
+		return errResp
 	}
 
 	return response.OK(message)
@@ -103,31 +161,31 @@ func (ac AgentController) GetCannedMessage(req *evo.Request) interface{} {
 
 // CreateCannedMessage creates a new canned message
 // @Summary Create canned message
-// @Description Create a new canned message
+// @Description Create a new canned message. Global responses can only be created by administrators,
+// @Description department responses by members of the department and personal responses by anyone.
 // @Tags Agent - Canned Messages
 // @Accept json
 // @Produce json
-// @Param body body object true "Canned message data"
+// @Param body body CannedMessageRequest true "Canned message data"
 // @Success 201 {object} response.Response
 // @Router /api/agent/canned-messages [post]
 func (ac AgentController) CreateCannedMessage(req *evo.Request) interface{} {
-	type CreateRequest struct {
-		Title    string  `json:"title"`
-		Message  string  `json:"message"`
-		Shortcut *string `json:"shortcut"`
-		IsActive *bool   `json:"is_active"`
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
 	}
+	user := req.User().Interface().(*auth.User)
 
-	var createReq CreateRequest
+	var createReq CannedMessageRequest
 	if err := req.BodyParser(&createReq); err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request body", 400, err.Error()))
 	}
 
-	if createReq.Title == "" {
-		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Title is required", 400, "title field cannot be empty"))
-	}
-	if createReq.Message == "" {
-		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Message is required", 400, "message field cannot be empty"))
+	if createReq.Scope == "" {
+		if user.Type == auth.UserTypeAdministrator {
+			createReq.Scope = models.CannedMessageScopeGlobal
+		} else {
+			createReq.Scope = models.CannedMessageScopePersonal
+		}
 	}
 
 	isActive := true
@@ -136,82 +194,118 @@ func (ac AgentController) CreateCannedMessage(req *evo.Request) interface{} {
 	}
 
 	cannedMessage := models.CannedMessage{
-		Title:    createReq.Title,
-		Message:  createReq.Message,
-		Shortcut: createReq.Shortcut,
-		IsActive: isActive,
+		WorkspaceID:  models.OrDefaultWorkspace(models.RequestWorkspaceID(req)),
+		Title:        createReq.Title,
+		Message:      createReq.Message,
+		Shortcut:     normalizeShortcut(createReq.Shortcut),
+		Scope:        createReq.Scope,
+		DepartmentID: createReq.DepartmentID,
+		IsActive:     isActive,
+	}
+	if cannedMessage.Scope == models.CannedMessageScopePersonal {
+		cannedMessage.OwnerID = &user.UserID
 	}
 
-	if err := db.Create(&cannedMessage).Error; err != nil {
-		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "Duplicate") {
-			return response.Error(response.NewErrorWithDetails(response.ErrorCodeConflict, "A canned message with this shortcut already exists", 409, err.Error()))
+	if errResp := validateCannedMessage(&cannedMessage, createReq); errResp != nil {
+		return errResp
+	}
+	if !canManageCannedMessage(user, &cannedMessage) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "You are not allowed to create canned messages in this scope", 403, "scope: "+cannedMessage.Scope))
+	}
+	if cannedShortcutTaken(&cannedMessage) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeConflict, "A canned message with this shortcut already exists", 409, *cannedMessage.Shortcut))
+	}
+
+	err := db.GetContext(req).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Variants", "Attachments", "Department").Create(&cannedMessage).Error; err != nil {
+			return err
 		}
+		return saveCannedMessageChildren(tx, cannedMessage.ID, createReq)
+	})
+	if err != nil {
 		log.Error("Failed to create canned message:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to create message", 500, err.Error()))
 	}
 
+	db.GetContext(req).Preload("Variants").Preload("Attachments").Where("id = ?", cannedMessage.ID).First(&cannedMessage)
+
 	return response.Created(cannedMessage)
 }
 
 // UpdateCannedMessage updates an existing canned message
 // @Summary Update canned message
-// @Description Update an existing canned message by ID
+// @Description Update an existing canned message by ID. When variants or attachments are provided they replace the existing ones.
 // @Tags Agent - Canned Messages
 // @Accept json
 // @Produce json
 // @Param id path int true "Message ID"
-// @Param body body object true "Canned message data"
+// @Param body body CannedMessageRequest true "Canned message data"
 // @Success 200 {object} response.Response
 // @Router /api/agent/canned-messages/{id} [put]
 func (ac AgentController) UpdateCannedMessage(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
 	id := req.Param("id").Uint()
 	if id == 0 {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid message ID", 400, "Message ID must be a positive integer"))
 	}
 
-	type UpdateRequest struct {
-		Title    string  `json:"title"`
-		Message  string  `json:"message"`
-		Shortcut *string `json:"shortcut"`
-		IsActive *bool   `json:"is_active"`
-	}
-
-	var updateReq UpdateRequest
+	var updateReq CannedMessageRequest
 	if err := req.BodyParser(&updateReq); err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request body", 400, err.Error()))
 	}
 
-	if updateReq.Title == "" {
-		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Title is required", 400, "title field cannot be empty"))
-	}
-	if updateReq.Message == "" {
-		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Message is required", 400, "message field cannot be empty"))
-	}
-
 	var cannedMessage models.CannedMessage
-	if err := db.Where("id = ?", id).First(&cannedMessage).Error; err != nil {
+	if err := db.GetContext(req).Where("id = ?", id).First(&cannedMessage).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Canned message not found", 404, err.Error()))
 	}
-
-	updates := map[string]interface{}{
-		"title":    updateReq.Title,
-		"message":  updateReq.Message,
-		"shortcut": updateReq.Shortcut,
+	if !canManageCannedMessage(user, &cannedMessage) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "You are not allowed to modify this canned message", 403, "scope: "+cannedMessage.Scope))
 	}
 
+	cannedMessage.Title = updateReq.Title
+	cannedMessage.Message = updateReq.Message
+	cannedMessage.Shortcut = normalizeShortcut(updateReq.Shortcut)
 	if updateReq.IsActive != nil {
-		updates["is_active"] = *updateReq.IsActive
+		cannedMessage.IsActive = *updateReq.IsActive
+	}
+	if updateReq.Scope != "" && updateReq.Scope != cannedMessage.Scope {
+		cannedMessage.Scope = updateReq.Scope
+		cannedMessage.OwnerID = nil
+		if cannedMessage.Scope == models.CannedMessageScopePersonal {
+			cannedMessage.OwnerID = &user.UserID
+		}
 	}
+	cannedMessage.DepartmentID = updateReq.DepartmentID
 
-	if err := db.Model(&cannedMessage).Updates(updates).Error; err != nil {
-		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "Duplicate") {
-			return response.Error(response.NewErrorWithDetails(response.ErrorCodeConflict, "A canned message with this shortcut already exists", 409, err.Error()))
+	if errResp := validateCannedMessage(&cannedMessage, updateReq); errResp != nil {
+		return errResp
+	}
+	// The user must also be allowed to manage the message in its new scope
+	if !canManageCannedMessage(user, &cannedMessage) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "You are not allowed to move canned messages to this scope", 403, "scope: "+cannedMessage.Scope))
+	}
+	if cannedShortcutTaken(&cannedMessage) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeConflict, "A canned message with this shortcut already exists", 409, *cannedMessage.Shortcut))
+	}
+
+	err := db.GetContext(req).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&cannedMessage).
+			Select("title", "message", "shortcut", "is_active", "scope", "owner_id", "department_id").
+			Updates(&cannedMessage).Error; err != nil {
+			return err
 		}
+		return saveCannedMessageChildren(tx, cannedMessage.ID, updateReq)
+	})
+	if err != nil {
 		log.Error("Failed to update canned message:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to update message", 500, err.Error()))
 	}
 
-	db.Where("id = ?", id).First(&cannedMessage)
+	db.GetContext(req).Preload("Variants").Preload("Attachments").Where("id = ?", id).First(&cannedMessage)
 
 	return response.OK(cannedMessage)
 }
@@ -226,17 +320,25 @@ func (ac AgentController) UpdateCannedMessage(req *evo.Request) interface{} {
 // @Success 200 {object} response.Response
 // @Router /api/agent/canned-messages/{id} [delete]
 func (ac AgentController) DeleteCannedMessage(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
 	id := req.Param("id").Uint()
 	if id == 0 {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid message ID", 400, "Message ID must be a positive integer"))
 	}
 
 	var cannedMessage models.CannedMessage
-	if err := db.Where("id = ?", id).First(&cannedMessage).Error; err != nil {
+	if err := db.GetContext(req).Where("id = ?", id).First(&cannedMessage).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Canned message not found", 404, err.Error()))
 	}
+	if !canManageCannedMessage(user, &cannedMessage) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "You are not allowed to delete this canned message", 403, "scope: "+cannedMessage.Scope))
+	}
 
-	if err := db.Delete(&cannedMessage).Error; err != nil {
+	if err := models.DeleteCannedMessages([]uint{cannedMessage.ID}); err != nil {
 		log.Error("Failed to delete canned message:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to delete message", 500, err.Error()))
 	}
@@ -246,3 +348,241 @@ func (ac AgentController) DeleteCannedMessage(req *evo.Request) interface{} {
 		"id":      id,
 	})
 }
+
+// RenderCannedMessage renders a canned message for a conversation
+// @Summary Render canned message
+// @Description Pick the variant matching the client language and replace placeholders
+// @Description ({{client.name}}, {{agent.name}}, {{ticket.ref}}, {{client.<attr>}}, {{conversation.<attr>}}, ...)
+// @Tags Agent - Canned Messages
+// @Accept json
+// @Produce json
+// @Param id path int true "Message ID"
+// @Param body body RenderCannedMessageRequest true "Conversation to render for"
+// @Success 200 {object} response.Response
+// @Router /api/agent/canned-messages/{id}/render [post]
+func (ac AgentController) RenderCannedMessage(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	id := req.Param("id").Uint()
+	if id == 0 {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid message ID", 400, "Message ID must be a positive integer"))
+	}
+
+	var input RenderCannedMessageRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request body", 400, err.Error()))
+	}
+	if input.ConversationID == 0 {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeMissingRequired, "conversation_id is required", 400, "conversation_id must be a positive integer"))
+	}
+
+	message, errResp := findVisibleCannedMessage(id, user)
+	if errResp != nil {
+		return errResp
+	}
+
+	if user.Type != auth.UserTypeAdministrator && !models.HasConversationAccess(user.UserID, input.ConversationID) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeAccessDenied, "You don't have access to this conversation", 403, "conversation is not in your departments"))
+	}
+
+	var conversation models.Conversation
+	if err := db.GetContext(req).Preload("Client").Preload("Client.ExternalIDs").Where("id = ?", input.ConversationID).First(&conversation).Error; err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, err.Error()))
+	}
+
+	language := input.Language
+	if language == "" && conversation.Client.Language != nil {
+		language = *conversation.Client.Language
+	}
+	body, matchedLanguage := message.MessageForLanguage(language)
+	rendered, unresolved := renderCannedMessage(body, &conversation, user)
+
+	return response.OK(map[string]interface{}{
+		"id":          message.ID,
+		"body":        rendered,
+		"language":    matchedLanguage,
+		"attachments": message.Attachments,
+		"unresolved":  unresolved,
+	})
+}
+
+// visibleCannedMessages restricts query to canned messages the user may see and use:
+// global ones, those of the user's departments (all departments for administrators) and the user's personal ones
+func visibleCannedMessages(query *gorm.DB, user *auth.User) (*gorm.DB, error) {
+	if user.Type == auth.UserTypeAdministrator {
+		return query.Where("scope IN ? OR (scope = ? AND owner_id = ?)",
+			[]string{models.CannedMessageScopeGlobal, models.CannedMessageScopeDepartment},
+			models.CannedMessageScopePersonal, user.UserID), nil
+	}
+
+	departmentIDs, err := models.GetUserDepartmentIDs(user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(departmentIDs) == 0 {
+		return query.Where("scope = ? OR (scope = ? AND owner_id = ?)",
+			models.CannedMessageScopeGlobal, models.CannedMessageScopePersonal, user.UserID), nil
+	}
+	return query.Where("scope = ? OR (scope = ? AND department_id IN ?) OR (scope = ? AND owner_id = ?)",
+		models.CannedMessageScopeGlobal,
+		models.CannedMessageScopeDepartment, departmentIDs,
+		models.CannedMessageScopePersonal, user.UserID), nil
+}
+
+// findVisibleCannedMessage loads a canned message with variants and attachments if the user may use it
+func findVisibleCannedMessage(id uint, user *auth.User) (*models.CannedMessage, interface{}) {
+	query, err := visibleCannedMessages(models.WorkspaceDB(user.WorkspaceID).Model(&models.CannedMessage{}), user)
+	if err != nil {
+		return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch message", 500, err.Error()))
+	}
+
+	var message models.CannedMessage
+	if err := query.Preload("Variants").Preload("Attachments").Where("id = ?", id).First(&message).Error; err != nil {
+		return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Canned message not found", 404, err.Error()))
+	}
+	return &message, nil
+}
+
+// canManageCannedMessage reports whether user may create, edit or delete message.
+// Global responses are managed by administrators, department responses by department members
+// and administrators, personal responses only by their owner.
+func canManageCannedMessage(user *auth.User, message *models.CannedMessage) bool {
+	switch message.Scope {
+	case models.CannedMessageScopeGlobal:
+		return user.Type == auth.UserTypeAdministrator
+	case models.CannedMessageScopeDepartment:
+		if message.DepartmentID == nil {
+			return false
+		}
+		return user.Type == auth.UserTypeAdministrator || models.HasDepartmentAccess(user.UserID, *message.DepartmentID)
+	case models.CannedMessageScopePersonal:
+		return message.OwnerID != nil && *message.OwnerID == user.UserID
+	}
+	return false
+}
+
+// validateCannedMessage checks required fields, scope consistency, variants and attachments
+func validateCannedMessage(message *models.CannedMessage, input CannedMessageRequest) interface{} {
+	if strings.TrimSpace(message.Title) == "" {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Title is required", 400, "title field cannot be empty"))
+	}
+	if strings.TrimSpace(message.Message) == "" {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Message is required", 400, "message field cannot be empty"))
+	}
+
+	switch message.Scope {
+	case models.CannedMessageScopeGlobal, models.CannedMessageScopePersonal:
+		message.DepartmentID = nil
+	case models.CannedMessageScopeDepartment:
+		if message.DepartmentID == nil {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "department_id is required for department scope", 400, "department_id field cannot be empty"))
+		}
+		var count int64
+		models.WorkspaceDB(message.WorkspaceID).Model(&models.Department{}).Where("id = ?", *message.DepartmentID).Count(&count)
+		if count == 0 {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Department not found", 400, "department_id does not exist"))
+		}
+	default:
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid scope", 400, "scope must be one of: personal, department, global"))
+	}
+
+	if input.Variants != nil {
+		seen := map[string]bool{}
+		for _, variant := range *input.Variants {
+			language := strings.ToLower(strings.TrimSpace(variant.Language))
+			if language == "" || strings.TrimSpace(variant.Message) == "" {
+				return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid variant", 400, "each variant requires language and message"))
+			}
+			if seen[language] {
+				return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Duplicate variant language", 400, "language "+language+" is defined more than once"))
+			}
+			seen[language] = true
+		}
+	}
+
+	if input.Attachments != nil {
+		for _, attachment := range *input.Attachments {
+			if strings.TrimSpace(attachment.Name) == "" || strings.TrimSpace(attachment.URL) == "" {
+				return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid attachment", 400, "each attachment requires name and url"))
+			}
+		}
+	}
+
+	return nil
+}
+
+// cannedShortcutTaken reports whether another canned message with the same shortcut
+// would be visible alongside message (same global, department or personal space)
+func cannedShortcutTaken(message *models.CannedMessage) bool {
+	if message.Shortcut == nil {
+		return false
+	}
+
+	query := models.WorkspaceDB(message.WorkspaceID).Model(&models.CannedMessage{}).Where("shortcut = ? AND scope = ?", *message.Shortcut, message.Scope)
+	switch message.Scope {
+	case models.CannedMessageScopeDepartment:
+		query = query.Where("department_id = ?", message.DepartmentID)
+	case models.CannedMessageScopePersonal:
+		query = query.Where("owner_id = ?", message.OwnerID)
+	}
+	if message.ID != 0 {
+		query = query.Where("id <> ?", message.ID)
+	}
+
+	var count int64
+	query.Count(&count)
+	return count > 0
+}
+
+// saveCannedMessageChildren replaces variants and attachments of a canned message when they are part of the request
+func saveCannedMessageChildren(tx *gorm.DB, cannedMessageID uint, input CannedMessageRequest) error {
+	if input.Variants != nil {
+		if err := tx.Where("canned_message_id = ?", cannedMessageID).Delete(&models.CannedMessageVariant{}).Error; err != nil {
+			return err
+		}
+		for _, item := range *input.Variants {
+			variant := models.CannedMessageVariant{
+				CannedMessageID: cannedMessageID,
+				Language:        strings.ToLower(strings.TrimSpace(item.Language)),
+				Message:         item.Message,
+			}
+			if err := tx.Create(&variant).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	if input.Attachments != nil {
+		if err := tx.Where("canned_message_id = ?", cannedMessageID).Delete(&models.CannedMessageAttachment{}).Error; err != nil {
+			return err
+		}
+		for _, item := range *input.Attachments {
+			attachment := models.CannedMessageAttachment{
+				CannedMessageID: cannedMessageID,
+				Name:            strings.TrimSpace(item.Name),
+				URL:             strings.TrimSpace(item.URL),
+				ContentType:     item.ContentType,
+				Size:            item.Size,
+			}
+			if err := tx.Create(&attachment).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func normalizeShortcut(shortcut *string) *string {
+	if shortcut == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*shortcut)
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
+}
@@ -0,0 +1,126 @@
+package conversation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+)
+
+// cannedPlaceholderRegex matches {{ namespace.key }} placeholders in canned messages
+var cannedPlaceholderRegex = regexp.MustCompile(`\{\{\s*([a-zA-Z_]+)\.([a-zA-Z0-9_]+)\s*\}\}`)
+
+// renderCannedMessage replaces placeholders in body with values from the conversation, its client and the agent.
+// Supported placeholders:
+//   - {{client.name}}, {{client.first_name}}, {{client.email}}, {{client.phone}}, {{client.<custom attribute>}}
+//   - {{agent.name}}, {{agent.first_name}}, {{agent.last_name}}, {{agent.email}}
+//   - {{ticket.ref}}, {{ticket.id}}, {{ticket.title}}, {{ticket.status}}, {{ticket.priority}}
+//   - {{conversation.<custom attribute>}}
+//
+// Placeholders that cannot be resolved are left untouched and returned in the second value.
+// The conversation must have Client and Client.ExternalIDs loaded.
+func renderCannedMessage(body string, conversation *models.Conversation, agent *auth.User) (string, []string) {
+	var unresolved []string
+	rendered := cannedPlaceholderRegex.ReplaceAllStringFunc(body, func(match string) string {
+		parts := cannedPlaceholderRegex.FindStringSubmatch(match)
+		value, ok := resolveCannedPlaceholder(strings.ToLower(parts[1]), parts[2], conversation, agent)
+		if !ok {
+			unresolved = append(unresolved, parts[1]+"."+parts[2])
+			return match
+		}
+		return value
+	})
+	return rendered, unresolved
+}
+
+func resolveCannedPlaceholder(namespace, key string, conversation *models.Conversation, agent *auth.User) (string, bool) {
+	switch namespace {
+	case "client":
+		if conversation == nil {
+			return "", false
+		}
+		client := conversation.Client
+		switch key {
+		case "name":
+			return client.Name, true
+		case "first_name":
+			first, _, _ := strings.Cut(strings.TrimSpace(client.Name), " ")
+			return first, true
+		case "email":
+			return clientExternalIDValue(client, models.ExternalIDTypeEmail), true
+		case "phone":
+			return clientExternalIDValue(client, models.ExternalIDTypePhone), true
+		}
+		value := models.CustomAttributeValueString(client.Data, key)
+		return value, value != ""
+
+	case "agent":
+		if agent == nil {
+			return "", false
+		}
+		switch key {
+		case "name":
+			if agent.DisplayName != "" {
+				return agent.DisplayName, true
+			}
+			return strings.TrimSpace(agent.Name + " " + agent.LastName), true
+		case "first_name":
+			return agent.Name, true
+		case "last_name":
+			return agent.LastName, true
+		case "email":
+			return agent.Email, true
+		}
+
+	case "ticket":
+		if conversation == nil {
+			return "", false
+		}
+		switch key {
+		case "ref":
+			return fmt.Sprintf("CONV-%d", conversation.ID), true
+		case "id":
+			return fmt.Sprint(conversation.ID), true
+		case "title":
+			return conversation.Title, true
+		case "status":
+			return conversation.Status, true
+		case "priority":
+			return conversation.Priority, true
+		}
+
+	case "conversation":
+		if conversation == nil {
+			return "", false
+		}
+		value := models.CustomAttributeValueString(conversation.CustomFields, key)
+		return value, value != ""
+	}
+
+	return "", false
+}
+
+func clientExternalIDValue(client models.Client, idType string) string {
+	for _, externalID := range client.ExternalIDs {
+		if externalID.Type == idType {
+			return externalID.Value
+		}
+	}
+	return ""
+}
+
+// appendCannedAttachments appends attachment links to a message body
+func appendCannedAttachments(body string, attachments []models.CannedMessageAttachment) string {
+	if len(attachments) == 0 {
+		return body
+	}
+	var sb strings.Builder
+	sb.WriteString(strings.TrimRight(body, "\n"))
+	sb.WriteString("\n")
+	for _, attachment := range attachments {
+		sb.WriteString(fmt.Sprintf("\n[%s](%s)", attachment.Name, attachment.URL))
+	}
+	return sb.String()
+}
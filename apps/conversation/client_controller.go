@@ -26,7 +26,11 @@ func (c AgentController) ListClients(request *evo.Request) any {
 	}
 
 	var clients []models.Client
-	query := db.Preload("ExternalIDs")
+	query := db.GetContext(request).Preload("ExternalIDs").Preload("Organization")
+
+	if organizationID := request.Query("organization_id").Int(); organizationID > 0 {
+		query = query.Where("organization_id = ?", organizationID)
+	}
 
 	search := request.Query("search").String()
 	if search != "" {
@@ -61,7 +65,7 @@ func (c AgentController) ListClients(request *evo.Request) any {
 	}
 
 	var total int64
-	db.Model(&models.Client{}).Count(&total)
+	db.GetContext(request).Model(&models.Client{}).Count(&total)
 
 	offset := (page - 1) * limit
 	query = query.Offset(offset).Limit(limit)
@@ -101,7 +105,7 @@ func (c AgentController) GetClient(request *evo.Request) any {
 	}
 
 	var client models.Client
-	if err := db.Preload("ExternalIDs").Where("id = ?", clientID).First(&client).Error; err != nil {
+	if err := db.GetContext(request).Preload("ExternalIDs").Preload("Organization").Where("id = ?", clientID).First(&client).Error; err != nil {
 		return response.Error(response.ErrNotFound)
 	}
 
@@ -120,10 +124,11 @@ func (c AgentController) CreateClient(request *evo.Request) any {
 	}
 
 	var req struct {
-		Name        string   `json:"name" validate:"required"`
-		Language    *string  `json:"language"`
-		Timezone    *string  `json:"timezone"`
-		ExternalIDs []struct {
+		Name           string  `json:"name" validate:"required"`
+		Language       *string `json:"language"`
+		Timezone       *string `json:"timezone"`
+		OrganizationID *uint   `json:"organization_id"`
+		ExternalIDs    []struct {
 			Type  string `json:"type" validate:"required,oneof=email phone whatsapp slack telegram web chat"`
 			Value string `json:"value" validate:"required"`
 		} `json:"external_ids"`
@@ -133,13 +138,18 @@ func (c AgentController) CreateClient(request *evo.Request) any {
 		return response.Error(response.ErrInvalidInput)
 	}
 
+	if req.OrganizationID != nil && !organizationExists(db.GetContext(request), *req.OrganizationID) {
+		return response.BadRequest(request, "Organization not found")
+	}
+
 	client := models.Client{
-		Name:     req.Name,
-		Language: req.Language,
-		Timezone: req.Timezone,
+		Name:           req.Name,
+		Language:       req.Language,
+		Timezone:       req.Timezone,
+		OrganizationID: req.OrganizationID,
 	}
 
-	if err := db.Create(&client).Error; err != nil {
+	if err := db.GetContext(request).Create(&client).Error; err != nil {
 		log.Error("Failed to create client:", err)
 		return response.Error(response.ErrInternalError)
 	}
@@ -150,12 +160,18 @@ func (c AgentController) CreateClient(request *evo.Request) any {
 			Type:     extID.Type,
 			Value:    extID.Value,
 		}
-		if err := db.Create(&externalID).Error; err != nil {
+		if err := db.GetContext(request).Create(&externalID).Error; err != nil {
 			log.Error("Failed to create external ID:", err)
 		}
+		if extID.Type == models.ExternalIDTypeEmail {
+			models.AssociateClientOrganizationByEmail(&client, extID.Value)
+		}
 	}
 
-	db.Preload("ExternalIDs").First(&client, "id = ?", client.ID)
+	db.GetContext(request).Preload("ExternalIDs").Preload("Organization").First(&client, "id = ?", client.ID)
+
+	// Clients created by agents are only queued for review, never auto-merged
+	go models.FindClientDuplicates(client.ID)
 
 	return response.OK(client)
 }
@@ -177,15 +193,18 @@ func (c AgentController) UpdateClient(request *evo.Request) any {
 	}
 
 	var client models.Client
-	if err := db.Where("id = ?", clientID).First(&client).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", clientID).First(&client).Error; err != nil {
 		return response.Error(response.ErrNotFound)
 	}
+	before := client
 
 	var req struct {
 		Name        *string                `json:"name"`
 		Language    *string                `json:"language"`
 		Timezone    *string                `json:"timezone"`
 		Data        map[string]interface{} `json:"data"`
+		// OrganizationID links the client to an organization; 0 removes the link
+		OrganizationID *uint `json:"organization_id"`
 		ExternalIDs []struct {
 			Type  string `json:"type" validate:"oneof=email phone whatsapp slack telegram web chat"`
 			Value string `json:"value"`
@@ -205,6 +224,15 @@ func (c AgentController) UpdateClient(request *evo.Request) any {
 	if req.Timezone != nil {
 		client.Timezone = req.Timezone
 	}
+	if req.OrganizationID != nil {
+		if *req.OrganizationID == 0 {
+			client.OrganizationID = nil
+		} else if organizationExists(db.GetContext(request), *req.OrganizationID) {
+			client.OrganizationID = req.OrganizationID
+		} else {
+			return response.BadRequest(request, "Organization not found")
+		}
+	}
 
 	if req.Data != nil {
 		dataBytes, err := json.Marshal(req.Data)
@@ -215,13 +243,15 @@ func (c AgentController) UpdateClient(request *evo.Request) any {
 		client.Data = datatypes.JSON(dataBytes)
 	}
 
-	if err := db.Save(&client).Error; err != nil {
+	if err := db.GetContext(request).Save(&client).Error; err != nil {
 		log.Error("Failed to update client:", err)
 		return response.Error(response.ErrInternalError)
 	}
+	oldValues, newValues := models.ClientChanges(&before, &client)
+	models.LogClientUpdate(client.ID, user, oldValues, newValues, request.IP(), request.Header("User-Agent"))
 
 	if req.ExternalIDs != nil {
-		db.Where("client_id = ?", client.ID).Delete(&models.ClientExternalID{})
+		db.GetContext(request).Where("client_id = ?", client.ID).Delete(&models.ClientExternalID{})
 
 		for _, extID := range req.ExternalIDs {
 			externalID := models.ClientExternalID{
@@ -229,13 +259,13 @@ func (c AgentController) UpdateClient(request *evo.Request) any {
 				Type:     extID.Type,
 				Value:    extID.Value,
 			}
-			if err := db.Create(&externalID).Error; err != nil {
+			if err := db.GetContext(request).Create(&externalID).Error; err != nil {
 				log.Error("Failed to create external ID:", err)
 			}
 		}
 	}
 
-	db.Preload("ExternalIDs").First(&client, "id = ?", client.ID)
+	db.GetContext(request).Preload("ExternalIDs").Preload("Organization").First(&client, "id = ?", client.ID)
 
 	return response.OK(client)
 }
@@ -257,13 +287,13 @@ func (c AgentController) DeleteClient(request *evo.Request) any {
 	}
 
 	var client models.Client
-	if err := db.Where("id = ?", clientID).First(&client).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", clientID).First(&client).Error; err != nil {
 		return response.Error(response.ErrNotFound)
 	}
 
-	db.Where("client_id = ?", client.ID).Delete(&models.ClientExternalID{})
+	db.GetContext(request).Where("client_id = ?", client.ID).Delete(&models.ClientExternalID{})
 
-	if err := db.Delete(&client).Error; err != nil {
+	if err := db.GetContext(request).Delete(&client).Error; err != nil {
 		log.Error("Failed to delete client:", err)
 		return response.Error(response.ErrInternalError)
 	}
@@ -291,7 +321,7 @@ func (c AgentController) UploadClientAvatar(request *evo.Request) any {
 	}
 
 	var client models.Client
-	if err := db.Where("id = ?", clientID).First(&client).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", clientID).First(&client).Error; err != nil {
 		return response.Error(response.ErrNotFound)
 	}
 
@@ -320,7 +350,7 @@ func (c AgentController) UploadClientAvatar(request *evo.Request) any {
 	}
 
 	client.Avatar = &avatarURL
-	if err := db.Save(&client).Error; err != nil {
+	if err := db.GetContext(request).Save(&client).Error; err != nil {
 		log.Error("Failed to update client avatar:", err)
 		return response.Error(response.ErrInternalError)
 	}
@@ -347,7 +377,7 @@ func (c AgentController) DeleteClientAvatar(request *evo.Request) any {
 	}
 
 	var client models.Client
-	if err := db.Where("id = ?", clientID).First(&client).Error; err != nil {
+	if err := db.GetContext(request).Where("id = ?", clientID).First(&client).Error; err != nil {
 		return response.Error(response.ErrNotFound)
 	}
 
@@ -358,7 +388,7 @@ func (c AgentController) DeleteClientAvatar(request *evo.Request) any {
 	}
 
 	client.Avatar = nil
-	if err := db.Save(&client).Error; err != nil {
+	if err := db.GetContext(request).Save(&client).Error; err != nil {
 		log.Error("Failed to update client:", err)
 		return response.Error(response.ErrInternalError)
 	}
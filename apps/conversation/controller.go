@@ -45,17 +45,24 @@ type CreateConversationRequest struct {
 	// Client fields (for creating new clients)
 	ClientName       *string        `json:"client_name" example:"John Doe"`                                                                                                                                 // If provided, creates a new client
 	ClientEmail      *string        `json:"client_email" example:"john.doe@example.com"`                                                                                                                    // If provided, used as client email
-	ClientID         *string        `json:"client_id" example:"c4ae2903-1127-4229-9e20-3225990af447"`                                                                                                       // If provided, uses existing client
+	ClientID         *string        `json:"client_id" example:"c4ae2903-1127-4229-9e20-3225990af447"`                                                                                                       // If provided, uses existing client; inboxes with identity verification require a signed identity instead
 	ClientAttributes map[string]any `json:"client_attributes" swaggertype:"object" example:"age:25,preferred_language:en,subscription_level:premium,annual_revenue:150000.50,registration_date:2024-01-15"` // Custom attributes for client
 
 	// Message
 	Message *string `json:"message" example:"I cannot log into my account. Getting error message 'Invalid credentials'."` // Optional initial message
+
+	// Pre-chat form
+	Consent *bool `json:"consent" example:"true"` // Consent checkbox answer when the inbox pre-chat form requires it
+
+	// Identity verification, required to link the visitor to an existing client when the inbox has an identity secret
+	IdentityProof
 }
 
 // CreateConversationResponse represents the response structure for conversation creation (includes secret)
 type CreateConversationResponse struct {
 	models.Conversation
-	Secret string `json:"secret"` // Include secret in creation response only
+	Secret           string `json:"secret"`            // Include secret in creation response only
+	IdentityVerified bool   `json:"identity_verified"` // Whether the client was linked through a verified identity
 }
 
 // AddClientMessageRequest represents the request structure for adding a client message via URL secret
@@ -93,6 +100,10 @@ type UpsertClientRequest struct {
 	Value      string         `json:"value" validate:"required,min=1" example:"john.doe@example.com"`
 	Name       *string        `json:"name" example:"John Doe"`                                                                                                                                 // Optional client name
 	Attributes map[string]any `json:"attributes" swaggertype:"object" example:"age:25,preferred_language:en,subscription_level:premium,annual_revenue:150000.50,registration_date:2024-01-15"` // Custom attributes
+	InboxKey   *string        `json:"inbox_key" example:"inbox_abc123..."`                                                                                                                      // Optional inbox API key for widget requests
+
+	// Identity verification, required for widget requests when the inbox has an identity secret
+	IdentityProof
 }
 
 // CreateConversation creates a new conversation with custom attributes
@@ -121,42 +132,118 @@ func (c Controller) CreateConversation(req *evo.Request) interface{} {
 		input.Priority = "medium"
 	}
 
-	// Lookup inbox by API key if provided
+	// Lookup inbox by API key if provided, otherwise use the default inbox
+	inbox, appErr := resolveWidgetInbox(req, input.InboxKey)
+	if appErr != nil {
+		return response.Error(*appErr)
+	}
 	var inboxID *uint
-	if input.InboxKey != nil && *input.InboxKey != "" {
-		inbox, err := models.GetInboxByAPIKey(*input.InboxKey)
-		if err != nil {
-			return response.Error(response.NewError(response.ErrorCodeNotFound, "Inbox not found", 404))
+	if inbox != nil {
+		inboxID = &inbox.ID
+	}
+
+	// The conversation and its client belong to the workspace of the inbox
+	workspaceID := widgetWorkspace(inbox)
+	models.SetRequestWorkspace(req, workspaceID)
+
+	// Only an identity signed by the website may link the visitor to an existing client
+	var claimedEmail string
+	if input.ClientEmail != nil {
+		claimedEmail = *input.ClientEmail
+	}
+	identity, identityErr := verifyWidgetIdentity(inbox, models.ExternalIDTypeEmail, claimedEmail, input.IdentityProof)
+	if identityErr != nil {
+		return identityError(identityErr)
+	}
+	unverifiedClaims := inbox.IdentityVerificationEnabled() && identity == nil
+	if identity != nil {
+		if identity.Type == models.ExternalIDTypeEmail {
+			input.ClientEmail = &identity.Value
 		}
-		if !inbox.Enabled {
-			return response.Error(response.NewError(response.ErrorCodeForbidden, "Inbox is disabled", 403))
+		if (input.ClientName == nil || *input.ClientName == "") && identity.Name != "" {
+			input.ClientName = &identity.Name
 		}
-		inboxID = &inbox.ID
-	} else {
-		// Use default inbox if no key provided
-		inbox, err := models.GetDefaultInbox()
-		if err == nil {
-			inboxID = &inbox.ID
+	}
+
+	// Validate pre-chat form answers before creating the client or conversation
+	var preChatForm *models.PreChatForm
+	if inbox != nil && inbox.PreChatFormEnabled() {
+		preChatForm, _ = inbox.GetPreChatForm()
+		if err := validatePreChatSubmission(preChatForm, &input); err != nil {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Pre-chat form validation failed", 400, err.Error()))
 		}
 	}
 
+	// Check the visitor against the blocklist before creating a client
+	clientIP := getRealIP(req)
+	sender := models.InboundSender{IP: clientIP}
+	if input.ClientID != nil && *input.ClientID != "" && !unverifiedClaims {
+		if id, err := uuid.Parse(*input.ClientID); err == nil {
+			sender.ClientID = &id
+		}
+	} else if input.ClientEmail != nil && *input.ClientEmail != "" {
+		sender.ExternalIDType = models.ExternalIDTypeEmail
+		sender.ExternalIDValue = *input.ClientEmail
+	}
+	if input.ClientEmail != nil {
+		sender.Email = *input.ClientEmail
+	}
+	spamSummary := input.Title
+	if input.Message != nil && *input.Message != "" {
+		spamSummary = *input.Message
+	}
+	verdict := models.CheckInboundSender(&sender)
+	if verdict != nil && verdict.Action == models.BlocklistActionDrop {
+		models.RecordSpamEvent(models.ExternalIDTypeWeb, sender, verdict, spamSummary)
+		return response.Error(response.NewError(response.ErrorCodeForbidden, "Conversation could not be created", 403))
+	}
+
 	// Handle client creation or lookup
 	var clientID uuid.UUID
 	var err error
 
-	if input.ClientID != nil && *input.ClientID != "" {
+	if identity != nil {
+		// Verified visitors are linked to the client of their identity, whatever client_id says
+		client, err := UpsertClientWithAttributes(workspaceID, identity.Type, identity.Value, input.ClientName, input.ClientAttributes)
+		if err != nil {
+			log.Error("Failed to upsert verified client:", err)
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to upsert client", 400, err.Error()))
+		}
+		clientID = client.ID
+	} else if input.ClientID != nil && *input.ClientID != "" {
+		// Without a verified identity a visitor could take over any client whose ID they know
+		if unverifiedClaims {
+			return identityError(models.ErrIdentityRequired)
+		}
+
 		// Use existing client
 		clientID, err = uuid.Parse(*input.ClientID)
 		if err != nil {
 			invalidClientIDErr := response.NewError(response.ErrorCodeInvalidInput, "Invalid client ID format", 400)
 			return response.Error(invalidClientIDErr)
 		}
+
+		// The client must belong to the workspace of the inbox
+		var count int64
+		models.WorkspaceDB(workspaceID).Model(&models.Client{}).Where("id = ?", clientID).Count(&count)
+		if count == 0 {
+			return response.Error(response.NewError(response.ErrorCodeNotFound, "Client not found", 404))
+		}
+
+		// Store pre-chat answers about the client on the existing client record
+		if preChatForm != nil {
+			if err := mergeClientAttributes(clientID, input.ClientAttributes); err != nil {
+				log.Error("Failed to store pre-chat client attributes:", err)
+				return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Pre-chat form validation failed", 400, err.Error()))
+			}
+		}
 	} else if input.ClientName != nil && *input.ClientName != "" {
 		// Upsert client based on email if provided, otherwise use name
 		var client *models.Client
-		if input.ClientEmail != nil && *input.ClientEmail != "" {
+		// An unverified email is not trusted to find the client when the inbox verifies identities
+		if input.ClientEmail != nil && *input.ClientEmail != "" && !unverifiedClaims {
 			// Use email to upsert client with attributes support
-			client, err = UpsertClientWithAttributes("email", *input.ClientEmail, input.ClientName, input.ClientAttributes)
+			client, err = UpsertClientWithAttributes(workspaceID, "email", *input.ClientEmail, input.ClientName, input.ClientAttributes)
 			if err != nil {
 				log.Error("Failed to upsert client by email:", err)
 				clientUpsertErr := response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to upsert client", 400, err.Error())
@@ -165,8 +252,9 @@ func (c Controller) CreateConversation(req *evo.Request) interface{} {
 		} else {
 			// Create new client using just the name and attributes
 			clientInput := ClientInput{
-				Name:       *input.ClientName,
-				Parameters: input.ClientAttributes,
+				Name:        *input.ClientName,
+				Parameters:  input.ClientAttributes,
+				WorkspaceID: workspaceID,
 			}
 			client, err = CreateClient(clientInput)
 			if err != nil {
@@ -181,9 +269,6 @@ func (c Controller) CreateConversation(req *evo.Request) interface{} {
 		return response.Error(missingClientErr)
 	}
 
-	// Extract client IP
-	clientIP := getRealIP(req)
-
 	// Parse user agent from parameters if provided
 	var browser, operatingSystem *string
 	if input.Parameters != nil {
@@ -198,6 +283,12 @@ func (c Controller) CreateConversation(req *evo.Request) interface{} {
 		}
 	}
 
+	// Blocklisted visitors may still open a conversation, but it goes straight to spam
+	requestedStatus := input.Status
+	if verdict != nil {
+		input.Status = models.ConversationStatusSpam
+	}
+
 	// Create conversation input (hardcode channel_id as "web" for web interface)
 	conversationInput := ConversationInput{
 		Title:           input.Title,
@@ -213,6 +304,7 @@ func (c Controller) CreateConversation(req *evo.Request) interface{} {
 		Browser:         browser,
 		OperatingSystem: operatingSystem,
 		InboxID:         inboxID,
+		WorkspaceID:     workspaceID,
 	}
 
 	// Create the conversation using the business logic function
@@ -222,15 +314,22 @@ func (c Controller) CreateConversation(req *evo.Request) interface{} {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to create conversation", 500, err.Error()))
 	}
 
+	if verdict != nil {
+		if err := models.RecordConversationSpam(conversation, requestedStatus, models.ExternalIDTypeWeb, sender, verdict, spamSummary); err != nil {
+			log.Error("Failed to record spam event for conversation %d: %v", conversation.ID, err)
+		}
+	}
+
 	// Load related data for response
-	if err := db.Preload("Client").Preload("Department").Preload("Channel").Preload("Inbox").First(conversation, conversation.ID).Error; err != nil {
+	if err := db.GetContext(req).Preload("Client").Preload("Department").Preload("Channel").Preload("Inbox").First(conversation, conversation.ID).Error; err != nil {
 		log.Warning("Failed to preload conversation relations:", err)
 	}
 
 	// Create response with secret included
 	conversationResponse := CreateConversationResponse{
-		Conversation: *conversation,
-		Secret: secret,
+		Conversation:     *conversation,
+		Secret:           secret,
+		IdentityVerified: identity != nil,
 	}
 
 	return response.Created(conversationResponse)
@@ -275,7 +374,7 @@ func (c Controller) AddClientMessage(req *evo.Request) interface{} {
 
 	// Find conversation and verify secret
 	var conversation models.Conversation
-	if err := db.First(&conversation, uint(conversationID)).Error; err != nil {
+	if err := db.GetContext(req).First(&conversation, uint(conversationID)).Error; err != nil {
 		return response.Error(response.ErrConversationNotFound)
 	}
 
@@ -285,6 +384,11 @@ func (c Controller) AddClientMessage(req *evo.Request) interface{} {
 		return response.Error(unauthorizedErr)
 	}
 
+	// The widget must be used from an allowed origin of the inbox
+	if appErr := checkConversationAccess(req, &conversation); appErr != nil {
+		return response.Error(*appErr)
+	}
+
 	// Create message with conversation.ClientID as sender (recognizing client as opener of conversation)
 	message := models.Message{
 		ConversationID:        conversation.ID,
@@ -293,13 +397,13 @@ func (c Controller) AddClientMessage(req *evo.Request) interface{} {
 		IsSystemMessage: false,
 	}
 
-	if err := db.Create(&message).Error; err != nil {
+	if err := db.GetContext(req).Create(&message).Error; err != nil {
 		log.Error("Failed to create client message:", err)
 		return response.Error(response.ErrCreateMessage())
 	}
 
 	// Load related data for response
-	if err := db.Preload("Conversation").Preload("Client").First(&message, message.ID).Error; err != nil {
+	if err := db.GetContext(req).Preload("Conversation").Preload("Client").First(&message, message.ID).Error; err != nil {
 		log.Warning("Failed to preload message relations:", err)
 	}
 
@@ -345,7 +449,7 @@ func (c Controller) GetConversationWithSecret(req *evo.Request) interface{} {
 
 	// Find conversation and verify secret
 	var conversation models.Conversation
-	if err := db.Preload("Client").Preload("Department").Preload("Channel").
+	if err := db.GetContext(req).Preload("Client").Preload("Department").Preload("Channel").
 		Preload("Tags").Preload("Assignments").
 		First(&conversation, uint(conversationID)).Error; err != nil {
 		return response.Error(response.ErrConversationNotFound)
@@ -356,9 +460,14 @@ func (c Controller) GetConversationWithSecret(req *evo.Request) interface{} {
 		return response.Error(response.NewError(response.ErrorCodeUnauthorized, "Invalid secret", 401))
 	}
 
+	// The widget must be used from an allowed origin of the inbox
+	if appErr := checkConversationAccess(req, &conversation); appErr != nil {
+		return response.Error(*appErr)
+	}
+
 	// Count total messages for this conversation (excluding action messages for clients)
 	var totalMessages int64
-	if err := db.Model(&models.Message{}).Where("conversation_id = ? AND (type != ? OR type IS NULL)", conversation.ID, models.MessageTypeAction).Count(&totalMessages).Error; err != nil {
+	if err := db.GetContext(req).Model(&models.Message{}).Where("conversation_id = ? AND (type != ? OR type IS NULL)", conversation.ID, models.MessageTypeAction).Count(&totalMessages).Error; err != nil {
 		log.Error("Failed to count messages:", err)
 		return response.Error(response.NewError(response.ErrorCodeDatabaseError, "Failed to count messages", 500))
 	}
@@ -375,7 +484,7 @@ func (c Controller) GetConversationWithSecret(req *evo.Request) interface{} {
 	// Get messages with pagination, ordered by created_at ASC, with all associations preloaded
 	// Exclude action messages from client view (they are internal activity logs)
 	var messages []models.Message
-	if err := db.Preload("Conversation").Preload("Client").Preload("User").
+	if err := db.GetContext(req).Preload("Conversation").Preload("Client").Preload("User").
 		Where("conversation_id = ? AND (type != ? OR type IS NULL)", conversation.ID, models.MessageTypeAction).
 		Order("created_at ASC").
 		Offset(offset).Limit(limit).
@@ -427,7 +536,7 @@ func (c Controller) CloseConversationWithSecret(req *evo.Request) interface{} {
 
 	// Find conversation and verify secret
 	var conversation models.Conversation
-	if err := db.First(&conversation, uint(conversationID)).Error; err != nil {
+	if err := db.GetContext(req).First(&conversation, uint(conversationID)).Error; err != nil {
 		return response.Error(response.ErrConversationNotFound)
 	}
 
@@ -436,6 +545,11 @@ func (c Controller) CloseConversationWithSecret(req *evo.Request) interface{} {
 		return response.Error(response.NewError(response.ErrorCodeUnauthorized, "Invalid secret", 401))
 	}
 
+	// The widget must be used from an allowed origin of the inbox
+	if appErr := checkConversationAccess(req, &conversation); appErr != nil {
+		return response.Error(*appErr)
+	}
+
 	// Update conversation to closed status using the business logic function
 	updatedConversation, err := UpdateConversation(uint(conversationID), ConversationInput{
 		Status: "closed",
@@ -446,7 +560,7 @@ func (c Controller) CloseConversationWithSecret(req *evo.Request) interface{} {
 	}
 
 	// Load related data for response
-	if err := db.Preload("Client").Preload("Department").Preload("Channel").First(updatedConversation, updatedConversation.ID).Error; err != nil {
+	if err := db.GetContext(req).Preload("Client").Preload("Department").Preload("Channel").First(updatedConversation, updatedConversation.ID).Error; err != nil {
 		log.Warning("Failed to preload conversation relations:", err)
 	}
 
@@ -455,7 +569,7 @@ func (c Controller) CloseConversationWithSecret(req *evo.Request) interface{} {
 
 // UpsertClient creates or returns an existing client based on type and value
 // @Summary Upsert a client
-// @Description Create a new client if it doesn't exist, or return existing client if it does. Supports custom attributes validation and storage.
+// @Description Create a new client if it doesn't exist, or return existing client if it does. Supports custom attributes validation and storage. Widget requests to an inbox with identity verification must sign the identifier with identity_hash or identity_token.
 // @Tags Clients
 // @Accept json
 // @Produce json
@@ -473,15 +587,38 @@ func (c Controller) UpsertClient(req *evo.Request) interface{} {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Validation failed", 400, err.Error()))
 	}
 
+	// Widget requests are scoped to their inbox and, when the inbox verifies identities,
+	// may only upsert the identity the website signed. Authenticated callers are trusted.
+	workspaceID := models.RequestWorkspaceID(req)
+	if req.User().Anonymous() {
+		inbox, appErr := resolveWidgetInbox(req, input.InboxKey)
+		if appErr != nil {
+			return response.Error(*appErr)
+		}
+		workspaceID = widgetWorkspace(inbox)
+		models.SetRequestWorkspace(req, workspaceID)
+
+		if inbox.IdentityVerificationEnabled() {
+			identity, err := verifyWidgetIdentity(inbox, input.Type, input.Value, input.IdentityProof)
+			if err == nil && identity == nil {
+				err = models.ErrIdentityRequired
+			}
+			if err != nil {
+				return identityError(err)
+			}
+			input.Type, input.Value = identity.Type, identity.Value
+		}
+	}
+
 	// Upsert the client with attributes support
-	client, err := UpsertClientWithAttributes(input.Type, input.Value, input.Name, input.Attributes)
+	client, err := UpsertClientWithAttributes(workspaceID, input.Type, input.Value, input.Name, input.Attributes)
 	if err != nil {
 		log.Error("Failed to upsert client:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to upsert client", 400, err.Error()))
 	}
 
 	// Load related data for response
-	if err := db.Preload("ExternalIDs").First(client, client.ID).Error; err != nil {
+	if err := db.GetContext(req).Preload("ExternalIDs").First(client, client.ID).Error; err != nil {
 		log.Warning("Failed to preload client relations:", err)
 	}
 
@@ -672,7 +809,7 @@ func (c Controller) GetConversationDetail(req *evo.Request) interface{} {
 
 	// Get conversation with all relations
 	var conversation models.Conversation
-	if err := db.Preload("Client").
+	if err := db.GetContext(req).Preload("Client").
 		Preload("Department").
 		Preload("Channel").
 		Preload("Tags").
@@ -685,14 +822,14 @@ func (c Controller) GetConversationDetail(req *evo.Request) interface{} {
 
 	// Get total message count
 	var totalMessages int64
-	if err := db.Model(&models.Message{}).Where("conversation_id = ?", conversationID).Count(&totalMessages).Error; err != nil {
+	if err := db.GetContext(req).Model(&models.Message{}).Where("conversation_id = ?", conversationID).Count(&totalMessages).Error; err != nil {
 		log.Error("Failed to count messages:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to count messages", 500, err.Error()))
 	}
 
 	// Get paginated messages
 	var messages []models.Message
-	if err := db.Where("conversation_id = ?", conversationID).
+	if err := db.GetContext(req).Where("conversation_id = ?", conversationID).
 		Preload("User").
 		Preload("Client").
 		Order("created_at ASC").
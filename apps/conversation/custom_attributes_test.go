@@ -0,0 +1,86 @@
+package conversation
+
+import (
+	"testing"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/iesreza/homa-backend/apps/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestValidateValueWithRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		rules   string
+		wantErr bool
+	}{
+		{name: "within range", value: 5, rules: "min:1,max:10"},
+		{name: "below min", value: 0, rules: "min:1,max:10", wantErr: true},
+		{name: "too long", value: "abcdef", rules: "maxlen:5", wantErr: true},
+		{name: "pattern with comma", value: "a,b", rules: "minlen:1,regex:^[a-z],[a-z]$"},
+		{name: "pattern mismatch", value: "A,b", rules: "regex:^[a-z],[a-z]$", wantErr: true},
+		{name: "required empty", value: "", rules: "required", wantErr: true},
+		// Rules stored before definitions were validated are skipped, not enforced against every value
+		{name: "unknown rule", value: "x", rules: "between:1-5"},
+		{name: "malformed min", value: 5, rules: "min:one"},
+		{name: "malformed maxlen", value: "x", rules: "maxlen:-"},
+		{name: "broken pattern", value: "x", rules: "regex:[a-"},
+		{name: "valid rule after a malformed one", value: 50, rules: "min:one,max:10", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateValueWithRules(tt.value, tt.rules); (err != nil) != tt.wantErr {
+				t.Errorf("validateValueWithRules(%v, %q) = %v, want error %v", tt.value, tt.rules, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessCustomAttributesDepartments(t *testing.T) {
+	dbo, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The MySQL check constraints of the model do not run on SQLite, so create the table by hand
+	if err := dbo.Exec(`CREATE TABLE custom_attributes (scope text, name text, data_type text, validation text, options text,
+		department_id integer, title text, description text, visibility text, created_at datetime, updated_at datetime,
+		PRIMARY KEY (scope, name))`).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Register(dbo)
+
+	sales, support := uint(1), uint(2)
+	for _, attr := range []models.CustomAttribute{
+		{Scope: models.CustomAttributeScopeConversation, Name: "order_total", DataType: models.CustomAttributeDataTypeInt, DepartmentID: &sales, Title: "Order total", Visibility: "everyone"},
+		{Scope: models.CustomAttributeScopeConversation, Name: "priority_score", DataType: models.CustomAttributeDataTypeInt, Title: "Priority score", Visibility: "everyone"},
+	} {
+		if err := dbo.Create(&attr).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		department *uint
+		parameters map[string]any
+		wantErr    bool
+	}{
+		{name: "own department", department: &sales, parameters: map[string]any{"order_total": "12"}},
+		{name: "own department validates the type", department: &sales, parameters: map[string]any{"order_total": "a lot"}, wantErr: true},
+		{name: "other department", department: &support, parameters: map[string]any{"order_total": "a lot"}, wantErr: true},
+		{name: "no department", parameters: map[string]any{"order_total": 12}, wantErr: true},
+		{name: "shared attribute", department: &support, parameters: map[string]any{"priority_score": 3}},
+		{name: "unknown key", department: &support, parameters: map[string]any{"utm_source": "ads"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := processCustomAttributes(models.CustomAttributeScopeConversation, tt.department, tt.parameters, false)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("processCustomAttributes = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
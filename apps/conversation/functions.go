@@ -5,6 +5,8 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/mail"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
@@ -33,12 +35,14 @@ type ConversationInput struct {
 	Browser         *string        `json:"browser"`    // Browser name and version
 	OperatingSystem *string        `json:"operating_system"` // OS name and version
 	InboxID         *uint          `json:"inbox_id"`   // Optional inbox ID for web conversations
+	WorkspaceID     uint           `json:"-"`          // Workspace of the conversation, the default workspace when unset
 }
 
 // ClientInput represents the input structure for creating or updating clients
 type ClientInput struct {
-	Name       string         `json:"name" validate:"required,min=1,max=255"`
-	Parameters map[string]any `json:"parameters"` // Custom attributes
+	Name        string         `json:"name" validate:"required,min=1,max=255"`
+	Parameters  map[string]any `json:"parameters"` // Custom attributes
+	WorkspaceID uint           `json:"-"`          // Workspace of the client, the default workspace when unset
 }
 
 var validate = validator.New()
@@ -52,13 +56,15 @@ func generateSecret() string {
 
 // CreateConversation creates a new conversation with custom attribute validation and processing
 func CreateConversation(input ConversationInput) (*models.Conversation, string, error) {
+	tx := models.WorkspaceDB(models.OrDefaultWorkspace(input.WorkspaceID))
+
 	// Validate basic conversation fields
 	if err := validate.Struct(input); err != nil {
 		return nil, "", fmt.Errorf("validation error: %w", err)
 	}
 
 	// Process and validate custom attributes
-	customFields, err := processCustomAttributes(models.CustomAttributeScopeConversation, input.Parameters)
+	customFields, err := processCustomAttributes(models.CustomAttributeScopeConversation, input.DepartmentID, input.Parameters, true)
 	if err != nil {
 		return nil, "", fmt.Errorf("custom attributes error: %w", err)
 	}
@@ -89,7 +95,7 @@ func CreateConversation(input ConversationInput) (*models.Conversation, string,
 	}
 
 	// Save to database
-	if err := db.Create(&conversation).Error; err != nil {
+	if err := tx.Create(&conversation).Error; err != nil {
 		log.Error("Failed to create conversation:", err)
 		return nil, "", fmt.Errorf("failed to create conversation: %w", err)
 	}
@@ -103,7 +109,7 @@ func CreateConversation(input ConversationInput) (*models.Conversation, string,
 			IsSystemMessage: false,
 		}
 
-		if err := db.Create(&message).Error; err != nil {
+		if err := tx.Create(&message).Error; err != nil {
 			log.Error("Failed to create initial message:", err)
 			// Don't fail the conversation creation if message creation fails
 			// Just log the error
@@ -144,7 +150,11 @@ func UpdateConversation(conversationID uint, input ConversationInput) (*models.C
 	var customFields datatypes.JSON
 	if input.Parameters != nil {
 		var err error
-		customFields, err = processCustomAttributes(models.CustomAttributeScopeConversation, input.Parameters)
+		departmentID := conversation.DepartmentID
+		if input.DepartmentID != nil {
+			departmentID = input.DepartmentID
+		}
+		customFields, err = processCustomAttributes(models.CustomAttributeScopeConversation, departmentID, input.Parameters, false)
 		if err != nil {
 			return nil, fmt.Errorf("custom attributes error: %w", err)
 		}
@@ -207,13 +217,15 @@ func UpdateConversation(conversationID uint, input ConversationInput) (*models.C
 
 // CreateClient creates a new client with custom attribute validation and processing
 func CreateClient(input ClientInput) (*models.Client, error) {
+	tx := models.WorkspaceDB(models.OrDefaultWorkspace(input.WorkspaceID))
+
 	// Validate basic client fields
 	if err := validate.Struct(input); err != nil {
 		return nil, fmt.Errorf("validation error: %w", err)
 	}
 
 	// Process and validate custom attributes
-	data, err := processCustomAttributes(models.CustomAttributeScopeClient, input.Parameters)
+	data, err := processCustomAttributes(models.CustomAttributeScopeClient, nil, input.Parameters, true)
 	if err != nil {
 		return nil, fmt.Errorf("custom attributes error: %w", err)
 	}
@@ -230,7 +242,7 @@ func CreateClient(input ClientInput) (*models.Client, error) {
 	}
 
 	// Save to database
-	if err := db.Create(&client).Error; err != nil {
+	if err := tx.Create(&client).Error; err != nil {
 		log.Error("Failed to create client:", err)
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
@@ -261,7 +273,7 @@ func UpdateClient(clientID uuid.UUID, input ClientInput) (*models.Client, error)
 	var data datatypes.JSON
 	if input.Parameters != nil {
 		var err error
-		data, err = processCustomAttributes(models.CustomAttributeScopeClient, input.Parameters)
+		data, err = processCustomAttributes(models.CustomAttributeScopeClient, nil, input.Parameters, false)
 		if err != nil {
 			return nil, fmt.Errorf("custom attributes error: %w", err)
 		}
@@ -290,16 +302,19 @@ func UpdateClient(clientID uuid.UUID, input ClientInput) (*models.Client, error)
 	return &client, nil
 }
 
-// processCustomAttributes validates and processes custom attributes according to their definitions
-func processCustomAttributes(scope string, parameters map[string]any) (datatypes.JSON, error) {
-	if parameters == nil || len(parameters) == 0 {
+// processCustomAttributes validates and processes custom attributes according to their definitions.
+// departmentID narrows conversation attributes to the ones shared by all departments plus the ones
+// owned by that department; values for attributes of another department are rejected. When creating is true, omitted attributes receive their default value
+// and required attributes without a default are rejected.
+func processCustomAttributes(scope string, departmentID *uint, parameters map[string]any, creating bool) (datatypes.JSON, error) {
+	if !creating && len(parameters) == 0 {
 		return nil, nil
 	}
 
 	// Get custom attribute definitions for this scope
-	var customAttrs []models.CustomAttribute
-	if err := db.Where("scope = ?", scope).Find(&customAttrs).Error; err != nil {
-		return nil, fmt.Errorf("failed to load custom attributes: %w", err)
+	customAttrs, err := loadCustomAttributes(scope)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create a map for quick lookup
@@ -312,6 +327,10 @@ func processCustomAttributes(scope string, parameters map[string]any) (datatypes
 	result := make(map[string]interface{})
 	for key, value := range parameters {
 		attr, exists := attrMap[key]
+		if exists && !attributeApplies(attr, departmentID) {
+			// A typed attribute of another department must not be written unvalidated
+			return nil, fmt.Errorf("attribute %s does not apply to this department", key)
+		}
 		if !exists {
 			// Allow arbitrary custom attributes as strings (for flexible widget integration)
 			// Unknown attributes are stored as-is without strict type validation
@@ -328,6 +347,35 @@ func processCustomAttributes(scope string, parameters map[string]any) (datatypes
 		result[key] = castedValue
 	}
 
+	// Fill in defaults and enforce required attributes on creation
+	if creating {
+		for _, attr := range customAttrs {
+			if !attributeApplies(attr, departmentID) {
+				continue
+			}
+			if _, provided := parameters[attr.Name]; provided {
+				continue
+			}
+			rules := attr.Rules()
+			if defaultValue, ok := rules.Get("default"); ok {
+				castedValue, err := castAndValidateValue(attr, defaultValue)
+				if err == nil {
+					result[attr.Name] = castedValue
+					continue
+				}
+				// Only definitions saved before defaults were checked can get here
+				log.Warning("Invalid default value for attribute %s: %v", attr.Name, err)
+			}
+			if rules.Has("required") {
+				return nil, fmt.Errorf("attribute %s is required", attr.Name)
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+
 	// Convert to JSON
 	jsonData, err := json.Marshal(result)
 	if err != nil {
@@ -337,10 +385,29 @@ func processCustomAttributes(scope string, parameters map[string]any) (datatypes
 	return datatypes.JSON(jsonData), nil
 }
 
+// loadCustomAttributes returns the attribute definitions of the given scope, including those of other
+// departments, so their names are not taken for unknown attributes; see attributeApplies.
+func loadCustomAttributes(scope string) ([]models.CustomAttribute, error) {
+	var customAttrs []models.CustomAttribute
+	if err := db.Where("scope = ?", scope).Find(&customAttrs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load custom attributes: %w", err)
+	}
+	return customAttrs, nil
+}
+
+// attributeApplies reports whether the attribute applies to the department. Attributes without a
+// department apply everywhere; department-scoped attributes only apply when departmentID matches.
+func attributeApplies(attr models.CustomAttribute, departmentID *uint) bool {
+	return attr.DepartmentID == nil || (departmentID != nil && *attr.DepartmentID == *departmentID)
+}
+
 // castAndValidateValue casts a value to the correct type and validates it
 func castAndValidateValue(attr models.CustomAttribute, value interface{}) (interface{}, error) {
 	// Handle nil values
 	if value == nil {
+		if attr.Rules().Has("required") {
+			return nil, fmt.Errorf("value is required")
+		}
 		return nil, nil
 	}
 
@@ -355,8 +422,22 @@ func castAndValidateValue(attr models.CustomAttribute, value interface{}) (inter
 		castedValue, err = castToFloat(value)
 	case models.CustomAttributeDataTypeDate:
 		castedValue, err = castToDate(value)
+	case models.CustomAttributeDataTypeDateTime:
+		castedValue, err = castToDateTime(value)
 	case models.CustomAttributeDataTypeString:
 		castedValue = fmt.Sprintf("%v", value)
+	case models.CustomAttributeDataTypeBoolean:
+		castedValue, err = castToBool(value)
+	case models.CustomAttributeDataTypeEmail:
+		castedValue, err = castToEmail(value)
+	case models.CustomAttributeDataTypeURL:
+		castedValue, err = castToURL(value)
+	case models.CustomAttributeDataTypePhone:
+		castedValue, err = castToPhone(value)
+	case models.CustomAttributeDataTypeSelect:
+		castedValue, err = castToOption(value, attr.OptionValues())
+	case models.CustomAttributeDataTypeMultiSelect:
+		castedValue, err = castToOptions(value, attr.OptionValues())
 	default:
 		return nil, fmt.Errorf("unsupported data type: %s", attr.DataType)
 	}
@@ -431,49 +512,177 @@ func castToDate(value interface{}) (time.Time, error) {
 	}
 }
 
+// castToDateTime converts a value to time.Time, requiring a time component
+func castToDateTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case string:
+		formats := []string{
+			time.RFC3339,
+			"2006-01-02T15:04:05",
+			"2006-01-02 15:04:05",
+			"2006-01-02T15:04",
+			"2006-01-02 15:04",
+		}
+		for _, format := range formats {
+			if t, err := time.Parse(format, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid datetime format: %s", v)
+	case time.Time:
+		return v, nil
+	default:
+		return time.Time{}, fmt.Errorf("cannot convert %T to time.Time", value)
+	}
+}
+
+// castToBool converts a value to bool
+func castToBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case int:
+		return v != 0, nil
+	case int64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "1", "yes", "on":
+			return true, nil
+		case "false", "0", "no", "off":
+			return false, nil
+		}
+		return false, fmt.Errorf("invalid boolean value: %s", v)
+	default:
+		return false, fmt.Errorf("cannot convert %T to bool", value)
+	}
+}
+
+// castToEmail validates and normalizes an email address
+func castToEmail(value interface{}) (string, error) {
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("cannot convert %T to email", value)
+	}
+	str = strings.TrimSpace(str)
+	addr, err := mail.ParseAddress(str)
+	if err != nil || addr.Address != str {
+		return "", fmt.Errorf("invalid email address: %s", str)
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// castToURL validates an absolute http(s) URL
+func castToURL(value interface{}) (string, error) {
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("cannot convert %T to url", value)
+	}
+	str = strings.TrimSpace(str)
+	parsed, err := url.ParseRequestURI(str)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid URL: %s", str)
+	}
+	return str, nil
+}
+
+// phoneNumberRegex matches a normalized phone number (optional leading +, 6 to 15 digits)
+var phoneNumberRegex = regexp.MustCompile(`^\+?[0-9]{6,15}$`)
+
+// castToPhone validates a phone number and strips common formatting characters
+func castToPhone(value interface{}) (string, error) {
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case float64:
+		str = strconv.FormatFloat(v, 'f', 0, 64)
+	case int:
+		str = strconv.Itoa(v)
+	default:
+		return "", fmt.Errorf("cannot convert %T to phone", value)
+	}
+
+	normalized := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "").Replace(strings.TrimSpace(str))
+	if !phoneNumberRegex.MatchString(normalized) {
+		return "", fmt.Errorf("invalid phone number: %s", str)
+	}
+	return normalized, nil
+}
+
+// castToOption validates that a value is one of the allowed options
+func castToOption(value interface{}, options []string) (string, error) {
+	str := strings.TrimSpace(fmt.Sprintf("%v", value))
+	for _, option := range options {
+		if option == str {
+			return str, nil
+		}
+	}
+	return "", fmt.Errorf("value %q is not one of the allowed options", str)
+}
+
+// castToOptions validates a list of values against the allowed options.
+// Accepts a JSON array or a string separated by commas or pipes.
+func castToOptions(value interface{}, options []string) ([]string, error) {
+	var items []string
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			items = append(items, fmt.Sprintf("%v", item))
+		}
+	case []string:
+		items = v
+	case string:
+		items = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '|' })
+	default:
+		return nil, fmt.Errorf("cannot convert %T to a list of options", value)
+	}
+
+	result := make([]string, 0, len(items))
+	seen := make(map[string]bool)
+	for _, item := range items {
+		option, err := castToOption(item, options)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[option] {
+			seen[option] = true
+			result = append(result, option)
+		}
+	}
+	return result, nil
+}
+
 // validateValueWithRules validates a value against validation rules
-// Supports rules: min, max, pattern, required (comma-separated)
-// Example: "min:1,max:100,pattern:^[a-zA-Z]+$"
+// Supports rules: min, max, minlen, maxlen, regex (alias pattern), required, default (comma-separated)
+// Example: "min:1,max:100,regex:^[a-zA-Z]+$"
+// Definitions are checked by CustomAttributeRules.Validate when they are saved. Rules stored before
+// that check existed may still be malformed; they are logged and skipped, so they cannot reject
+// every value written to the attribute.
 func validateValueWithRules(value interface{}, rules string) error {
 	if rules == "" {
 		return nil
 	}
 
-	// Parse rules (comma-separated)
-	ruleList := strings.Split(rules, ",")
-
-	for _, rule := range ruleList {
-		rule = strings.TrimSpace(rule)
-		if rule == "" {
-			continue
-		}
-
-		// Handle "required" rule
-		if rule == "required" {
+	for _, rule := range models.ParseCustomAttributeRules(&rules) {
+		switch rule.Name {
+		case "required":
 			if value == nil {
 				return fmt.Errorf("value is required")
 			}
 			if str, ok := value.(string); ok && str == "" {
 				return fmt.Errorf("value is required")
 			}
-			continue
-		}
-
-		// Parse "key:value" format
-		parts := strings.SplitN(rule, ":", 2)
-		if len(parts) != 2 {
-			log.Warning("Invalid validation rule format: %s", rule)
-			continue
-		}
-
-		ruleName := strings.TrimSpace(parts[0])
-		ruleValue := strings.TrimSpace(parts[1])
+			if list, ok := value.([]string); ok && len(list) == 0 {
+				return fmt.Errorf("value is required")
+			}
 
-		switch ruleName {
 		case "min":
-			minVal, err := strconv.ParseFloat(ruleValue, 64)
+			minVal, err := strconv.ParseFloat(rule.Value, 64)
 			if err != nil {
-				log.Warning("Invalid min value in validation rule: %s", ruleValue)
+				log.Warning("Invalid min value in validation rule: %s", rule.Value)
 				continue
 			}
 			if err := validateMin(value, minVal); err != nil {
@@ -481,20 +690,20 @@ func validateValueWithRules(value interface{}, rules string) error {
 			}
 
 		case "max":
-			maxVal, err := strconv.ParseFloat(ruleValue, 64)
+			maxVal, err := strconv.ParseFloat(rule.Value, 64)
 			if err != nil {
-				log.Warning("Invalid max value in validation rule: %s", ruleValue)
+				log.Warning("Invalid max value in validation rule: %s", rule.Value)
 				continue
 			}
 			if err := validateMax(value, maxVal); err != nil {
 				return err
 			}
 
-		case "pattern":
+		case "pattern", "regex":
 			if str, ok := value.(string); ok {
-				matched, err := regexp.MatchString(ruleValue, str)
+				matched, err := regexp.MatchString(rule.Value, str)
 				if err != nil {
-					log.Warning("Invalid regex pattern in validation rule: %s", ruleValue)
+					log.Warning("Invalid regex pattern in validation rule: %s", rule.Value)
 					continue
 				}
 				if !matched {
@@ -503,9 +712,9 @@ func validateValueWithRules(value interface{}, rules string) error {
 			}
 
 		case "minlen":
-			minLen, err := strconv.Atoi(ruleValue)
+			minLen, err := strconv.Atoi(rule.Value)
 			if err != nil {
-				log.Warning("Invalid minlen value in validation rule: %s", ruleValue)
+				log.Warning("Invalid minlen value in validation rule: %s", rule.Value)
 				continue
 			}
 			if str, ok := value.(string); ok {
@@ -515,9 +724,9 @@ func validateValueWithRules(value interface{}, rules string) error {
 			}
 
 		case "maxlen":
-			maxLen, err := strconv.Atoi(ruleValue)
+			maxLen, err := strconv.Atoi(rule.Value)
 			if err != nil {
-				log.Warning("Invalid maxlen value in validation rule: %s", ruleValue)
+				log.Warning("Invalid maxlen value in validation rule: %s", rule.Value)
 				continue
 			}
 			if str, ok := value.(string); ok {
@@ -526,8 +735,11 @@ func validateValueWithRules(value interface{}, rules string) error {
 				}
 			}
 
+		case "default":
+			// Applied by processCustomAttributes when the attribute is omitted on creation
+
 		default:
-			log.Debug("Unknown validation rule: %s", ruleName)
+			log.Warning("Unknown validation rule: %s", rule.Name)
 		}
 	}
 
@@ -554,6 +766,11 @@ func validateMin(value interface{}, minVal float64) error {
 		if float64(len(v)) < minVal {
 			return fmt.Errorf("value must be at least %v characters", minVal)
 		}
+	case []string:
+		// For multi-select values, min refers to the number of selected options
+		if float64(len(v)) < minVal {
+			return fmt.Errorf("at least %v options must be selected", minVal)
+		}
 	}
 	return nil
 }
@@ -578,26 +795,36 @@ func validateMax(value interface{}, maxVal float64) error {
 		if float64(len(v)) > maxVal {
 			return fmt.Errorf("value must be at most %v characters", maxVal)
 		}
+	case []string:
+		// For multi-select values, max refers to the number of selected options
+		if float64(len(v)) > maxVal {
+			return fmt.Errorf("at most %v options can be selected", maxVal)
+		}
 	}
 	return nil
 }
 
-// UpsertClient creates a new client if it doesn't exist, or returns existing client if it does
-func UpsertClient(clientType, clientValue string) (*models.Client, error) {
+// UpsertClient creates a new client in the workspace if it doesn't exist, or returns existing client if it does
+func UpsertClient(workspaceID uint, clientType, clientValue string) (*models.Client, error) {
+	tx := models.WorkspaceDB(models.OrDefaultWorkspace(workspaceID))
+
 	if clientType == "" || clientValue == "" {
 		return nil, fmt.Errorf("client type and value are required")
 	}
 
 	// First, try to find existing client by external ID
 	var externalID models.ClientExternalID
-	err := db.Where("type = ? AND value = ?", clientType, clientValue).First(&externalID).Error
+	err := tx.Where("type = ? AND value = ?", clientType, clientValue).First(&externalID).Error
 
 	if err == nil {
 		// Client exists, return it
 		var client models.Client
-		if err := db.First(&client, "id = ?", externalID.ClientID).Error; err != nil {
+		if err := tx.First(&client, "id = ?", externalID.ClientID).Error; err != nil {
 			return nil, fmt.Errorf("failed to load existing client: %w", err)
 		}
+		if clientType == models.ExternalIDTypeEmail {
+			models.AssociateClientOrganizationByEmail(&client, clientValue)
+		}
 		return &client, nil
 	}
 
@@ -617,7 +844,7 @@ func UpsertClient(clientType, clientValue string) (*models.Client, error) {
 		Data: datatypes.JSON("{}"),
 	}
 
-	if err := db.Create(&client).Error; err != nil {
+	if err := tx.Create(&client).Error; err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
@@ -628,40 +855,47 @@ func UpsertClient(clientType, clientValue string) (*models.Client, error) {
 		Value:    clientValue,
 	}
 
-	if err := db.Create(&newExternalID).Error; err != nil {
+	if err := tx.Create(&newExternalID).Error; err != nil {
 		log.Warning("Failed to create client external ID:", err)
 		// Don't fail the operation, client is already created
 	}
 
-	return &client, nil
+	if clientType == models.ExternalIDTypeEmail {
+		models.AssociateClientOrganizationByEmail(&client, clientValue)
+	}
+	return models.ResolveClientDuplicates(&client), nil
 }
 
-// UpsertClientWithAttributes creates a new client if it doesn't exist, or updates existing client with custom attributes
-func UpsertClientWithAttributes(clientType, clientValue string, name *string, attributes map[string]any) (*models.Client, error) {
+// UpsertClientWithAttributes creates a new client in the workspace if it doesn't exist, or updates existing client with custom attributes
+func UpsertClientWithAttributes(workspaceID uint, clientType, clientValue string, name *string, attributes map[string]any) (*models.Client, error) {
+	tx := models.WorkspaceDB(models.OrDefaultWorkspace(workspaceID))
+
 	if clientType == "" || clientValue == "" {
 		return nil, fmt.Errorf("client type and value are required")
 	}
 
 	// First, try to find existing client by external ID
 	var externalID models.ClientExternalID
-	err := db.Where("type = ? AND value = ?", clientType, clientValue).First(&externalID).Error
+	err := tx.Where("type = ? AND value = ?", clientType, clientValue).First(&externalID).Error
 
 	if err == nil {
 		// Client exists, update it if attributes or name provided
 		var client models.Client
-		if err := db.First(&client, "id = ?", externalID.ClientID).Error; err != nil {
+		if err := tx.First(&client, "id = ?", externalID.ClientID).Error; err != nil {
 			return nil, fmt.Errorf("failed to load existing client: %w", err)
 		}
 
 		// Process custom attributes if provided
 		var data datatypes.JSON
 		if attributes != nil {
-			data, err = processCustomAttributes(models.CustomAttributeScopeClient, attributes)
+			data, err = processCustomAttributes(models.CustomAttributeScopeClient, nil, attributes, false)
 			if err != nil {
 				return nil, fmt.Errorf("custom attributes error: %w", err)
 			}
 		}
 
+		before := client
+
 		// Update client fields if provided
 		updates := make(map[string]interface{})
 		if name != nil && *name != "" {
@@ -673,16 +907,24 @@ func UpsertClientWithAttributes(clientType, clientValue string, name *string, at
 
 		// Only update if there are changes
 		if len(updates) > 0 {
-			if err := db.Model(&client).Updates(updates).Error; err != nil {
+			if err := tx.Model(&client).Updates(updates).Error; err != nil {
 				return nil, fmt.Errorf("failed to update client: %w", err)
 			}
 
 			// Reload client to get updated values
-			if err := db.First(&client, "id = ?", client.ID).Error; err != nil {
+			if err := tx.First(&client, "id = ?", client.ID).Error; err != nil {
 				return nil, fmt.Errorf("failed to reload client: %w", err)
 			}
+			if data != nil {
+				go models.FindClientDuplicates(client.ID)
+			}
+			oldValues, newValues := models.ClientChanges(&before, &client)
+			models.LogClientUpdate(client.ID, nil, oldValues, newValues, "", "")
 		}
 
+		if clientType == models.ExternalIDTypeEmail {
+			models.AssociateClientOrganizationByEmail(&client, clientValue)
+		}
 		return &client, nil
 	}
 
@@ -698,13 +940,10 @@ func UpsertClientWithAttributes(clientType, clientValue string, name *string, at
 		}
 	}
 
-	// Process custom attributes if provided
-	var data datatypes.JSON
-	if attributes != nil {
-		data, err = processCustomAttributes(models.CustomAttributeScopeClient, attributes)
-		if err != nil {
-			return nil, fmt.Errorf("custom attributes error: %w", err)
-		}
+	// Process custom attributes, applying defaults for the new client
+	data, err := processCustomAttributes(models.CustomAttributeScopeClient, nil, attributes, true)
+	if err != nil {
+		return nil, fmt.Errorf("custom attributes error: %w", err)
 	}
 
 	// Ensure data is not nil
@@ -718,7 +957,7 @@ func UpsertClientWithAttributes(clientType, clientValue string, name *string, at
 		Data: data,
 	}
 
-	if err := db.Create(&client).Error; err != nil {
+	if err := tx.Create(&client).Error; err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
@@ -729,12 +968,15 @@ func UpsertClientWithAttributes(clientType, clientValue string, name *string, at
 		Value:    clientValue,
 	}
 
-	if err := db.Create(&newExternalID).Error; err != nil {
+	if err := tx.Create(&newExternalID).Error; err != nil {
 		log.Warning("Failed to create client external ID:", err)
 		// Don't fail the operation, client is already created
 	}
 
-	return &client, nil
+	if clientType == models.ExternalIDTypeEmail {
+		models.AssociateClientOrganizationByEmail(&client, clientValue)
+	}
+	return models.ResolveClientDuplicates(&client), nil
 }
 
 // AssignConversationToUser assigns a conversation to a specific user
@@ -0,0 +1,126 @@
+package conversation
+
+import (
+	"errors"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// IdentityProof is the website's signature of the visitor identity, sent by the widget
+type IdentityProof struct {
+	IdentityHash      *string `json:"identity_hash" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"` // Hex HMAC-SHA256 of "<identifier>:<identity_timestamp>" with the inbox identity secret
+	IdentityTimestamp *int64  `json:"identity_timestamp" example:"1767225600"`                                                  // Unix time identity_hash was signed at; accepted for 24 hours
+	IdentityToken     *string `json:"identity_token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`                         // HS256 JWT with email and/or sub claims, signed with the inbox identity secret
+}
+
+// ClientConversationHistoryRequest represents the request structure for loading a verified visitor's conversations
+type ClientConversationHistoryRequest struct {
+	InboxKey *string `json:"inbox_key" example:"inbox_abc123..."`
+	Email    *string `json:"email" example:"john.doe@example.com"` // Identifier signed by identity_hash
+	IdentityProof
+}
+
+// ClientConversationHistoryItem is a previous conversation with the secret the widget needs to reopen it
+type ClientConversationHistoryItem struct {
+	models.Conversation
+	Secret string `json:"secret"`
+}
+
+// verifyWidgetIdentity checks the identity proof sent with a claimed identifier. It returns nil without
+// an error when the inbox does not verify identities, or when no proof was sent and none is required.
+func verifyWidgetIdentity(inbox *models.Inbox, claimType, claimValue string, proof IdentityProof) (*models.VerifiedIdentity, error) {
+	if !inbox.IdentityVerificationEnabled() {
+		return nil, nil
+	}
+
+	switch {
+	case proof.IdentityToken != nil && *proof.IdentityToken != "":
+		identity, err := inbox.VerifyIdentityToken(*proof.IdentityToken)
+		if err != nil {
+			return nil, err
+		}
+		if !identity.Matches(claimType, claimValue) {
+			return nil, models.ErrIdentityMismatch
+		}
+		return identity, nil
+	case proof.IdentityHash != nil && *proof.IdentityHash != "":
+		if proof.IdentityTimestamp == nil {
+			return nil, models.ErrIdentityInvalid
+		}
+		return inbox.VerifyIdentityHash(claimType, claimValue, *proof.IdentityHash, *proof.IdentityTimestamp)
+	case inbox.IdentityRequired && claimValue != "":
+		return nil, models.ErrIdentityRequired
+	}
+	return nil, nil
+}
+
+// identityError converts an identity verification error to a response
+func identityError(err error) interface{} {
+	if errors.Is(err, models.ErrIdentityNotEnabled) {
+		return response.Error(response.NewError(response.ErrorCodeForbidden, err.Error(), 403))
+	}
+	return response.Error(response.NewError(response.ErrorCodeUnauthorized, err.Error(), 401))
+}
+
+// GetClientConversationHistory returns the previous conversations of a verified visitor
+// @Summary Get the conversation history of a verified visitor
+// @Description Returns the visitor's previous conversations in the inbox, with their secrets, once the website has signed the visitor identity. Requires identity verification to be enabled for the inbox.
+// @Tags Client Conversations
+// @Accept json
+// @Produce json
+// @Param body body ClientConversationHistoryRequest true "Inbox key and identity proof"
+// @Success 200 {array} ClientConversationHistoryItem
+// @Router /api/client/conversations/history [post]
+func (c Controller) GetClientConversationHistory(req *evo.Request) interface{} {
+	var input ClientConversationHistoryRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request format", 400, err.Error()))
+	}
+
+	inbox, appErr := resolveWidgetInbox(req, input.InboxKey)
+	if appErr != nil {
+		return response.Error(*appErr)
+	}
+	if !inbox.IdentityVerificationEnabled() {
+		return identityError(models.ErrIdentityNotEnabled)
+	}
+	workspaceID := widgetWorkspace(inbox)
+	models.SetRequestWorkspace(req, workspaceID)
+
+	var email string
+	if input.Email != nil {
+		email = *input.Email
+	}
+	identity, err := verifyWidgetIdentity(inbox, models.ExternalIDTypeEmail, email, input.IdentityProof)
+	if err == nil && identity == nil {
+		err = models.ErrIdentityRequired
+	}
+	if err != nil {
+		return identityError(err)
+	}
+
+	tx := models.WorkspaceDB(workspaceID)
+	var externalID models.ClientExternalID
+	if err := tx.Where("type = ? AND value = ?", identity.Type, identity.Value).Limit(1).Find(&externalID).Error; err != nil {
+		log.Error("Failed to look up verified client:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to load conversations", 500, err.Error()))
+	}
+	items := make([]ClientConversationHistoryItem, 0)
+	if externalID.ID == 0 {
+		return response.OK(items)
+	}
+
+	var conversations []models.Conversation
+	if err := tx.Where("client_id = ? AND inbox_id = ?", externalID.ClientID, inbox.ID).
+		Order("updated_at DESC").Limit(50).Find(&conversations).Error; err != nil {
+		log.Error("Failed to load verified client conversations:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to load conversations", 500, err.Error()))
+	}
+	for _, conversation := range conversations {
+		items = append(items, ClientConversationHistoryItem{Conversation: conversation, Secret: conversation.Secret})
+	}
+	return response.OK(items)
+}
@@ -0,0 +1,372 @@
+package conversation
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// MacroRequest is the request body for creating and updating macros
+type MacroRequest struct {
+	Name               string   `json:"name"`
+	Description        string   `json:"description"`
+	Reply              *string  `json:"reply"`
+	Status             *string  `json:"status"`
+	Priority           *string  `json:"priority"`
+	HandleByBot        *bool    `json:"handle_by_bot"`
+	AddTagIDs          []uint   `json:"add_tag_ids"`
+	RemoveTagIDs       []uint   `json:"remove_tag_ids"`
+	Reassign           bool     `json:"reassign"`
+	AssignUserIDs      []string `json:"assign_user_ids"`
+	AssignDepartmentID *uint    `json:"assign_department_id"`
+	AssignToActor      bool     `json:"assign_to_actor"`
+	DepartmentID       *uint    `json:"department_id"`
+	IsActive           *bool    `json:"is_active"`
+}
+
+// ListMacros returns the macros the current user may apply
+// @Summary List macros
+// @Description Get global macros and macros of the current user's departments
+// @Tags Agent - Macros
+// @Accept json
+// @Produce json
+// @Param search query string false "Search in name or description"
+// @Param department_id query int false "Filter by department"
+// @Param is_active query bool false "Filter by active status"
+// @Success 200 {object} response.Response
+// @Router /api/agent/macros [get]
+func (ac AgentController) ListMacros(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	query := db.GetContext(req).Model(&models.Macro{})
+	if !user.HasPermission(auth.PermissionMacrosManage) {
+		departmentIDs, err := models.GetUserDepartmentIDs(user.UserID)
+		if err != nil {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch macros", 500, err.Error()))
+		}
+		departmentIDs = append(departmentIDs, user.PermissionDepartmentIDs(auth.PermissionMacrosManage)...)
+		if len(departmentIDs) > 0 {
+			query = query.Where("department_id IS NULL OR department_id IN ?", departmentIDs)
+		} else {
+			query = query.Where("department_id IS NULL")
+		}
+	}
+
+	if search := req.Query("search").String(); search != "" {
+		searchTerm := "%" + search + "%"
+		query = query.Where("name LIKE ? OR description LIKE ?", searchTerm, searchTerm)
+	}
+	if departmentID := req.Query("department_id").Int(); departmentID > 0 {
+		query = query.Where("department_id = ?", departmentID)
+	}
+	if isActiveStr := req.Query("is_active").String(); isActiveStr != "" {
+		query = query.Where("is_active = ?", isActiveStr == "true" || isActiveStr == "1")
+	}
+
+	var macros []models.Macro
+	if err := query.Order("name ASC").Find(&macros).Error; err != nil {
+		log.Error("Failed to fetch macros:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch macros", 500, err.Error()))
+	}
+
+	return response.OK(macros)
+}
+
+// GetMacro returns a single macro by ID
+// @Summary Get macro
+// @Tags Agent - Macros
+// @Accept json
+// @Produce json
+// @Param id path int true "Macro ID"
+// @Success 200 {object} response.Response
+// @Router /api/agent/macros/{id} [get]
+func (ac AgentController) GetMacro(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	macro, errResp := findMacro(req, req.Param("id").Uint())
+	if errResp != nil {
+		return errResp
+	}
+	if !canUseMacro(user, macro) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Macro not found", 404, "macro is not available in your departments"))
+	}
+
+	return response.OK(macro)
+}
+
+// CreateMacro creates a new macro
+// @Summary Create macro
+// @Description Create a macro. Global macros (no department_id) require the macros.manage permission.
+// @Tags Agent - Macros
+// @Accept json
+// @Produce json
+// @Param body body MacroRequest true "Macro data"
+// @Success 201 {object} response.Response
+// @Router /api/agent/macros [post]
+func (ac AgentController) CreateMacro(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	var input MacroRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request body", 400, err.Error()))
+	}
+
+	macro := models.Macro{WorkspaceID: models.OrDefaultWorkspace(models.RequestWorkspaceID(req)), IsActive: true, CreatedBy: &user.UserID}
+	if errResp := fillMacro(&macro, input); errResp != nil {
+		return errResp
+	}
+	if !canManageMacro(user, &macro) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "You are not allowed to create macros for this department", 403, "global macros require the macros.manage permission"))
+	}
+
+	if err := db.GetContext(req).Omit("Department").Create(&macro).Error; err != nil {
+		log.Error("Failed to create macro:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to create macro", 500, err.Error()))
+	}
+
+	return response.Created(macro)
+}
+
+// UpdateMacro updates an existing macro
+// @Summary Update macro
+// @Tags Agent - Macros
+// @Accept json
+// @Produce json
+// @Param id path int true "Macro ID"
+// @Param body body MacroRequest true "Macro data"
+// @Success 200 {object} response.Response
+// @Router /api/agent/macros/{id} [put]
+func (ac AgentController) UpdateMacro(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	macro, errResp := findMacro(req, req.Param("id").Uint())
+	if errResp != nil {
+		return errResp
+	}
+	if !canManageMacro(user, macro) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "You are not allowed to modify this macro", 403, "macro belongs to another department"))
+	}
+
+	var input MacroRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request body", 400, err.Error()))
+	}
+	if errResp := fillMacro(macro, input); errResp != nil {
+		return errResp
+	}
+	if !canManageMacro(user, macro) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "You are not allowed to move macros to this department", 403, "global macros require the macros.manage permission"))
+	}
+
+	if err := db.GetContext(req).Model(macro).Select(
+		"name", "description", "reply", "status", "priority", "handle_by_bot", "add_tag_ids", "remove_tag_ids",
+		"reassign", "assign_user_ids", "assign_department_id", "assign_to_actor", "department_id", "is_active",
+	).Updates(macro).Error; err != nil {
+		log.Error("Failed to update macro:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to update macro", 500, err.Error()))
+	}
+
+	return response.OK(macro)
+}
+
+// DeleteMacro deletes a macro
+// @Summary Delete macro
+// @Tags Agent - Macros
+// @Accept json
+// @Produce json
+// @Param id path int true "Macro ID"
+// @Success 200 {object} response.Response
+// @Router /api/agent/macros/{id} [delete]
+func (ac AgentController) DeleteMacro(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	macro, errResp := findMacro(req, req.Param("id").Uint())
+	if errResp != nil {
+		return errResp
+	}
+	if !canManageMacro(user, macro) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "You are not allowed to delete this macro", 403, "macro belongs to another department"))
+	}
+
+	if err := db.GetContext(req).Delete(macro).Error; err != nil {
+		log.Error("Failed to delete macro:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to delete macro", 500, err.Error()))
+	}
+
+	return response.OK(map[string]interface{}{
+		"message": "Macro deleted successfully",
+		"id":      macro.ID,
+	})
+}
+
+// PreviewMacro shows what a macro would change on a conversation without applying it
+// @Summary Preview macro
+// @Description Returns the rendered reply and the property, tag and assignment changes the macro would make
+// @Tags Agent - Macros
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param macro_id path int true "Macro ID"
+// @Success 200 {object} MacroPlan
+// @Router /api/agent/conversations/{id}/macros/{macro_id}/preview [post]
+func (ac AgentController) PreviewMacro(req *evo.Request) interface{} {
+	plan, _, _, errResp := prepareMacro(req)
+	if errResp != nil {
+		return errResp
+	}
+	return response.OK(plan)
+}
+
+// ApplyMacro applies a macro to a conversation in a single transaction
+// @Summary Apply macro
+// @Description Posts the macro reply and applies status, priority, tag, assignment and bot handling changes atomically
+// @Tags Agent - Macros
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param macro_id path int true "Macro ID"
+// @Success 200 {object} response.Response
+// @Router /api/agent/conversations/{id}/macros/{macro_id}/apply [post]
+func (ac AgentController) ApplyMacro(req *evo.Request) interface{} {
+	plan, conversation, user, errResp := prepareMacro(req)
+	if errResp != nil {
+		return errResp
+	}
+
+	reply, err := applyMacroPlan(plan, conversation, user)
+	if err != nil {
+		log.Error("Failed to apply macro:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to apply macro", 500, err.Error()))
+	}
+
+	return response.OK(map[string]interface{}{
+		"applied": true,
+		"plan":    plan,
+		"message": reply,
+	})
+}
+
+// prepareMacro loads the conversation and macro of a preview/apply request, checks permissions and builds the plan
+func prepareMacro(req *evo.Request) (*MacroPlan, *models.Conversation, *auth.User, interface{}) {
+	if req.User().Anonymous() {
+		return nil, nil, nil, response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	conversationID := req.Param("id").Uint()
+	if conversationID == 0 {
+		return nil, nil, nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid conversation ID", 400, "Conversation ID must be a positive integer"))
+	}
+
+	macro, errResp := findMacro(req, req.Param("macro_id").Uint())
+	if errResp != nil {
+		return nil, nil, nil, errResp
+	}
+	if !macro.IsActive {
+		return nil, nil, nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Macro is disabled", 400, "inactive macros cannot be applied"))
+	}
+	if !canUseMacro(user, macro) {
+		return nil, nil, nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeAccessDenied, "You are not allowed to use this macro", 403, "macro belongs to another department"))
+	}
+	if user.Type != auth.UserTypeAdministrator && !models.HasConversationAccess(user.UserID, conversationID) {
+		return nil, nil, nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeAccessDenied, "You don't have access to this conversation", 403, "conversation is not in your departments"))
+	}
+
+	var conversation models.Conversation
+	if err := db.GetContext(req).Preload("Tags").Preload("Client").Preload("Client.ExternalIDs").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+		return nil, nil, nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, fmt.Sprintf("No conversation exists with ID %d", conversationID)))
+	}
+
+	plan, err := buildMacroPlan(macro, &conversation, user)
+	if err != nil {
+		return nil, nil, nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Macro cannot be applied", 400, err.Error()))
+	}
+	return plan, &conversation, user, nil
+}
+
+func findMacro(req *evo.Request, id uint) (*models.Macro, interface{}) {
+	if id == 0 {
+		return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid macro ID", 400, "Macro ID must be a positive integer"))
+	}
+	var macro models.Macro
+	if err := db.GetContext(req).Where("id = ?", id).First(&macro).Error; err != nil {
+		return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Macro not found", 404, err.Error()))
+	}
+	return &macro, nil
+}
+
+// fillMacro copies input onto macro and validates the result
+func fillMacro(macro *models.Macro, input MacroRequest) interface{} {
+	macro.Name = input.Name
+	macro.Description = input.Description
+	macro.Reply = input.Reply
+	macro.Status = input.Status
+	macro.Priority = input.Priority
+	macro.HandleByBot = input.HandleByBot
+	macro.Reassign = input.Reassign
+	macro.AssignDepartmentID = input.AssignDepartmentID
+	macro.AssignToActor = input.AssignToActor
+	macro.DepartmentID = input.DepartmentID
+	if input.IsActive != nil {
+		macro.IsActive = *input.IsActive
+	}
+	macro.AddTagIDs, _ = json.Marshal(nonNilSlice(input.AddTagIDs))
+	macro.RemoveTagIDs, _ = json.Marshal(nonNilSlice(input.RemoveTagIDs))
+	macro.AssignUserIDs, _ = json.Marshal(nonNilSlice(input.AssignUserIDs))
+
+	if err := macro.Validate(); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid macro", 400, err.Error()))
+	}
+
+	// The same tag may be listed twice or in both lists, so compare against the distinct IDs
+	tagIDs := append(append([]uint{}, input.AddTagIDs...), input.RemoveTagIDs...)
+	slices.Sort(tagIDs)
+	tagIDs = slices.Compact(tagIDs)
+	if len(tagIDs) > 0 {
+		var count int64
+		models.WorkspaceDB(macro.WorkspaceID).Model(&models.Tag{}).Where("id IN ?", tagIDs).Count(&count)
+		if int(count) != len(tagIDs) {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid macro", 400, "one or more tags do not exist"))
+		}
+	}
+	for _, departmentID := range []*uint{macro.DepartmentID, macro.AssignDepartmentID} {
+		if departmentID == nil {
+			continue
+		}
+		var count int64
+		models.WorkspaceDB(macro.WorkspaceID).Model(&models.Department{}).Where("id = ?", *departmentID).Count(&count)
+		if count == 0 {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid macro", 400, fmt.Sprintf("department %d does not exist", *departmentID)))
+		}
+	}
+	return nil
+}
+
+func nonNilSlice[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
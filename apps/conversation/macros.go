@@ -0,0 +1,290 @@
+package conversation
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"gorm.io/gorm"
+)
+
+// MacroChange describes a single property change a macro makes to a conversation
+type MacroChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// MacroAssignee is a user or department a macro assigns to the conversation
+type MacroAssignee struct {
+	UserID       *uuid.UUID `json:"user_id,omitempty"`
+	DepartmentID *uint      `json:"department_id,omitempty"`
+	Name         string     `json:"name"`
+}
+
+// MacroPlan is the outcome of applying a macro to a conversation.
+// It is returned as-is by the preview endpoint and executed by the apply endpoint.
+type MacroPlan struct {
+	MacroID        uint            `json:"macro_id"`
+	ConversationID uint            `json:"conversation_id"`
+	Reply          string          `json:"reply,omitempty"`
+	Unresolved     []string        `json:"unresolved_placeholders,omitempty"`
+	Changes        []MacroChange   `json:"changes"`
+	TagsAdded      []TagInfo       `json:"tags_added"`
+	TagsRemoved    []TagInfo       `json:"tags_removed"`
+	Reassign       bool            `json:"reassign"`
+	Assignees      []MacroAssignee `json:"assignees,omitempty"`
+	Unassigned     []MacroAssignee `json:"unassigned,omitempty"`
+
+	macro       *models.Macro
+	updates     map[string]any
+	addTags     []models.Tag
+	removeTags  []models.Tag
+	assignUsers []uuid.UUID
+}
+
+// buildMacroPlan computes what macro would change on conversation when applied by actor.
+// The conversation must have Tags, Client and Client.ExternalIDs loaded.
+func buildMacroPlan(macro *models.Macro, conversation *models.Conversation, actor *auth.User) (*MacroPlan, error) {
+	plan := &MacroPlan{
+		MacroID:        macro.ID,
+		ConversationID: conversation.ID,
+		Changes:        []MacroChange{},
+		TagsAdded:      []TagInfo{},
+		TagsRemoved:    []TagInfo{},
+		macro:          macro,
+		updates:        map[string]any{},
+	}
+
+	if macro.Reply != nil && *macro.Reply != "" {
+		plan.Reply, plan.Unresolved = renderCannedMessage(*macro.Reply, conversation, actor)
+	}
+
+	if macro.Status != nil && *macro.Status != conversation.Status {
+		plan.Changes = append(plan.Changes, MacroChange{Field: "status", From: conversation.Status, To: *macro.Status})
+		plan.updates["status"] = *macro.Status
+		if *macro.Status == models.ConversationStatusClosed || *macro.Status == models.ConversationStatusArchived {
+			now := time.Now()
+			plan.updates["closed_at"] = &now
+		}
+	}
+	if macro.Priority != nil && *macro.Priority != conversation.Priority {
+		plan.Changes = append(plan.Changes, MacroChange{Field: "priority", From: conversation.Priority, To: *macro.Priority})
+		plan.updates["priority"] = *macro.Priority
+	}
+	if macro.HandleByBot != nil && *macro.HandleByBot != conversation.HandleByBot {
+		plan.Changes = append(plan.Changes, MacroChange{Field: "handle_by_bot", From: conversation.HandleByBot, To: *macro.HandleByBot})
+		plan.updates["handle_by_bot"] = *macro.HandleByBot
+	}
+
+	current := make(map[uint]bool, len(conversation.Tags))
+	for _, tag := range conversation.Tags {
+		current[tag.ID] = true
+	}
+	if ids := macro.AddTags(); len(ids) > 0 {
+		var tags []models.Tag
+		if err := models.WorkspaceDB(conversation.WorkspaceID).Where("id IN ?", ids).Find(&tags).Error; err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			if !current[tag.ID] {
+				plan.addTags = append(plan.addTags, tag)
+				plan.TagsAdded = append(plan.TagsAdded, TagInfo{ID: tag.ID, Name: tag.Name})
+			}
+		}
+	}
+	for _, tag := range conversation.Tags {
+		if slices.Contains(macro.RemoveTags(), tag.ID) {
+			plan.removeTags = append(plan.removeTags, tag)
+			plan.TagsRemoved = append(plan.TagsRemoved, TagInfo{ID: tag.ID, Name: tag.Name})
+		}
+	}
+
+	if macro.Reassign {
+		plan.Reassign = true
+		plan.assignUsers = macro.AssignUsers()
+		if macro.AssignToActor && actor != nil && !slices.Contains(plan.assignUsers, actor.UserID) {
+			plan.assignUsers = append(plan.assignUsers, actor.UserID)
+		}
+
+		var users []auth.User
+		if len(plan.assignUsers) > 0 {
+			if err := models.WorkspaceDB(conversation.WorkspaceID).Where("id IN ?", plan.assignUsers).Find(&users).Error; err != nil {
+				return nil, err
+			}
+		}
+		if len(users) != len(plan.assignUsers) {
+			return nil, fmt.Errorf("macro assigns users that no longer exist")
+		}
+		for i := range users {
+			plan.Assignees = append(plan.Assignees, MacroAssignee{UserID: &users[i].UserID, Name: userFullName(&users[i])})
+		}
+		if macro.AssignDepartmentID != nil {
+			var department models.Department
+			if err := models.WorkspaceDB(conversation.WorkspaceID).Where("id = ?", *macro.AssignDepartmentID).First(&department).Error; err != nil {
+				return nil, fmt.Errorf("macro assigns a department that no longer exists")
+			}
+			plan.Assignees = append(plan.Assignees, MacroAssignee{DepartmentID: &department.ID, Name: department.Name})
+		}
+
+		var existing []models.ConversationAssignment
+		db.Preload("User").Preload("Department").Where("conversation_id = ?", conversation.ID).Find(&existing)
+		for _, assignment := range existing {
+			switch {
+			case assignment.UserID != nil && !slices.Contains(plan.assignUsers, *assignment.UserID):
+				name := assignment.UserID.String()
+				if assignment.User != nil {
+					name = userFullName(assignment.User)
+				}
+				plan.Unassigned = append(plan.Unassigned, MacroAssignee{UserID: assignment.UserID, Name: name})
+			case assignment.DepartmentID != nil && (macro.AssignDepartmentID == nil || *assignment.DepartmentID != *macro.AssignDepartmentID):
+				name := fmt.Sprint(*assignment.DepartmentID)
+				if assignment.Department != nil {
+					name = assignment.Department.Name
+				}
+				plan.Unassigned = append(plan.Unassigned, MacroAssignee{DepartmentID: assignment.DepartmentID, Name: name})
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// applyMacroPlan executes plan in a single transaction. The reply is created last so that it is
+// only sent out to external channels once every other change has succeeded.
+func applyMacroPlan(plan *MacroPlan, conversation *models.Conversation, actor *auth.User) (*models.Message, error) {
+	var reply *models.Message
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if len(plan.updates) > 0 {
+			if err := tx.Model(conversation).Updates(plan.updates).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(plan.addTags) > 0 {
+			if err := tx.Model(conversation).Association("Tags").Append(plan.addTags); err != nil {
+				return err
+			}
+		}
+		if len(plan.removeTags) > 0 {
+			if err := tx.Model(conversation).Association("Tags").Delete(plan.removeTags); err != nil {
+				return err
+			}
+		}
+
+		if plan.Reassign {
+			if err := tx.Where("conversation_id = ?", conversation.ID).Delete(&models.ConversationAssignment{}).Error; err != nil {
+				return err
+			}
+			for i := range plan.assignUsers {
+				assignment := models.ConversationAssignment{ConversationID: conversation.ID, UserID: &plan.assignUsers[i]}
+				if err := tx.Create(&assignment).Error; err != nil {
+					return err
+				}
+			}
+			if plan.macro.AssignDepartmentID != nil {
+				assignment := models.ConversationAssignment{ConversationID: conversation.ID, DepartmentID: plan.macro.AssignDepartmentID}
+				if err := tx.Create(&assignment).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Model(&models.Macro{}).Where("id = ?", plan.macro.ID).Updates(map[string]any{
+			"usage_count":  gorm.Expr("usage_count + 1"),
+			"last_used_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+
+		if plan.Reply != "" {
+			reply = &models.Message{
+				ConversationID:  conversation.ID,
+				UserID:          &actor.UserID,
+				Body:            plan.Reply,
+				KeepBotHandling: plan.macro.HandleByBot != nil && *plan.macro.HandleByBot,
+			}
+			if err := tx.Create(reply).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go createMacroActionMessages(plan, actor)
+
+	return reply, nil
+}
+
+// createMacroActionMessages records what the macro did in the conversation activity log
+func createMacroActionMessages(plan *MacroPlan, actor *auth.User) {
+	actorName := userFullName(actor)
+	userID := &actor.UserID
+
+	models.CreateActionMessage(plan.ConversationID, userID, actorName, fmt.Sprintf(`applied macro "%s"`, plan.macro.Name))
+	for _, change := range plan.Changes {
+		var action string
+		switch change.Field {
+		case "handle_by_bot":
+			if change.To == true {
+				action = "enabled bot handling"
+			} else {
+				action = "disabled bot handling"
+			}
+		case "status":
+			action = fmt.Sprintf(`set %s to "%v"`, change.Field, change.To)
+			models.LogConversationStatusChange(plan.ConversationID, actor, fmt.Sprint(change.From), fmt.Sprint(change.To), "", "")
+		default:
+			action = fmt.Sprintf(`set %s to "%v"`, change.Field, change.To)
+		}
+		models.CreateActionMessage(plan.ConversationID, userID, actorName, action)
+	}
+	for _, tag := range plan.TagsAdded {
+		models.CreateActionMessage(plan.ConversationID, userID, actorName, fmt.Sprintf(`added tag "%s"`, tag.Name))
+	}
+	for _, tag := range plan.TagsRemoved {
+		models.CreateActionMessage(plan.ConversationID, userID, actorName, fmt.Sprintf(`removed tag "%s"`, tag.Name))
+	}
+	for _, assignee := range plan.Unassigned {
+		models.CreateActionMessage(plan.ConversationID, userID, actorName, fmt.Sprintf(`unassigned "%s" from the conversation`, assignee.Name))
+	}
+	for _, assignee := range plan.Assignees {
+		models.CreateActionMessage(plan.ConversationID, userID, actorName, fmt.Sprintf(`assigned "%s" to the conversation`, assignee.Name))
+	}
+}
+
+// canUseMacro reports whether user may apply or view macro.
+// Global macros are available to everyone, department macros to department members
+// and to users who manage macros in the department.
+func canUseMacro(user *auth.User, macro *models.Macro) bool {
+	if macro.DepartmentID == nil {
+		return true
+	}
+	return models.HasDepartmentAccess(user.UserID, *macro.DepartmentID) ||
+		user.HasDepartmentPermission(auth.PermissionMacrosManage, *macro.DepartmentID)
+}
+
+// canManageMacro reports whether user may create, edit or delete macro.
+// Global macros need macros.manage, department macros macros.manage in the department or department membership.
+func canManageMacro(user *auth.User, macro *models.Macro) bool {
+	if macro.DepartmentID == nil {
+		return user.HasPermission(auth.PermissionMacrosManage)
+	}
+	return user.HasDepartmentPermission(auth.PermissionMacrosManage, *macro.DepartmentID) ||
+		models.HasDepartmentAccess(user.UserID, *macro.DepartmentID)
+}
+
+func userFullName(user *auth.User) string {
+	if user.LastName != "" {
+		return user.Name + " " + user.LastName
+	}
+	return user.Name
+}
@@ -0,0 +1,116 @@
+package conversation
+
+import (
+	"testing"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupMacroDB registers a database with the tables macro access and validation read
+func setupMacroDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dbo, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbo.AutoMigrate(&auth.Role{}, &auth.UserRole{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE tags (id integer PRIMARY KEY, workspace_id integer, name text)`,
+		`CREATE TABLE user_departments (user_id text, department_id integer, priority integer, PRIMARY KEY (user_id, department_id))`,
+	} {
+		if err := dbo.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Register(dbo)
+	auth.SeedBuiltInRoles()
+	return dbo
+}
+
+func TestMacroPermissions(t *testing.T) {
+	dbo := setupMacroDB(t)
+	sales, support := uint(1), uint(2)
+
+	manager := auth.Role{Name: "macro manager"}
+	manager.SetPermissions([]string{auth.PermissionMacrosManage})
+	if err := dbo.Create(&manager).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &auth.User{UserID: uuid.New(), Type: auth.UserTypeAdministrator}
+	member := &auth.User{UserID: uuid.New(), Type: auth.UserTypeAgent}
+	globalManager := &auth.User{UserID: uuid.New(), Type: auth.UserTypeAgent}
+	salesManager := &auth.User{UserID: uuid.New(), Type: auth.UserTypeAgent}
+	dbo.Create(&models.UserDepartment{UserID: member.UserID, DepartmentID: sales})
+	dbo.Create(&auth.UserRole{UserID: globalManager.UserID, RoleID: manager.ID})
+	dbo.Create(&auth.UserRole{UserID: salesManager.UserID, RoleID: manager.ID, DepartmentID: &sales})
+
+	global := &models.Macro{}
+	salesMacro := &models.Macro{DepartmentID: &sales}
+	supportMacro := &models.Macro{DepartmentID: &support}
+
+	tests := []struct {
+		name   string
+		user   *auth.User
+		macro  *models.Macro
+		use    bool
+		manage bool
+	}{
+		{name: "admin global", user: admin, macro: global, use: true, manage: true},
+		{name: "admin department", user: admin, macro: supportMacro, use: true, manage: true},
+		{name: "member global", user: member, macro: global, use: true, manage: false},
+		{name: "member own department", user: member, macro: salesMacro, use: true, manage: true},
+		{name: "member other department", user: member, macro: supportMacro, use: false, manage: false},
+		{name: "global manager global", user: globalManager, macro: global, use: true, manage: true},
+		{name: "global manager department", user: globalManager, macro: supportMacro, use: true, manage: true},
+		{name: "scoped manager global", user: salesManager, macro: global, use: true, manage: false},
+		{name: "scoped manager own scope", user: salesManager, macro: salesMacro, use: true, manage: true},
+		{name: "scoped manager other department", user: salesManager, macro: supportMacro, use: false, manage: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canUseMacro(tt.user, tt.macro); got != tt.use {
+				t.Errorf("canUseMacro = %v, want %v", got, tt.use)
+			}
+			if got := canManageMacro(tt.user, tt.macro); got != tt.manage {
+				t.Errorf("canManageMacro = %v, want %v", got, tt.manage)
+			}
+		})
+	}
+}
+
+func TestFillMacroDuplicateTags(t *testing.T) {
+	dbo := setupMacroDB(t)
+	dbo.Exec(`INSERT INTO tags (id, workspace_id, name) VALUES (1, 1, 'vip'), (2, 1, 'billing')`)
+
+	tests := []struct {
+		name    string
+		add     []uint
+		remove  []uint
+		wantErr bool
+	}{
+		{name: "distinct tags", add: []uint{1}, remove: []uint{2}},
+		{name: "tag listed twice", add: []uint{1, 1}},
+		{name: "duplicates in both lists", add: []uint{1, 1}, remove: []uint{2, 2}},
+		// Validate rejects this with its own message before the tags are looked up
+		{name: "tag in both lists", add: []uint{1, 2}, remove: []uint{2}, wantErr: true},
+		{name: "missing tag", add: []uint{1, 1}, remove: []uint{3}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			macro := &models.Macro{WorkspaceID: 1}
+			errResp := fillMacro(macro, MacroRequest{Name: "tags", AddTagIDs: tt.add, RemoveTagIDs: tt.remove})
+			if (errResp != nil) != tt.wantErr {
+				t.Errorf("fillMacro(add %v, remove %v) = %v, want error %v", tt.add, tt.remove, errResp, tt.wantErr)
+			}
+		})
+	}
+}
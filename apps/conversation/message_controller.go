@@ -33,7 +33,7 @@ func (ac AgentController) GetConversationMessages(req *evo.Request) interface{}
 	}
 
 	var conversation models.Conversation
-	if err := db.Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+	if err := db.GetContext(req).Where("id = ?", conversationID).First(&conversation).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, fmt.Sprintf("No conversation exists with ID %d", conversationID)))
 	}
 
@@ -61,13 +61,13 @@ func (ac AgentController) GetConversationMessages(req *evo.Request) interface{}
 	}
 
 	var total int64
-	if err := db.Model(&models.Message{}).Where("conversation_id = ?", conversationID).Count(&total).Error; err != nil {
+	if err := db.GetContext(req).Model(&models.Message{}).Where("conversation_id = ?", conversationID).Count(&total).Error; err != nil {
 		log.Error("Failed to count messages:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to count messages", 500, err.Error()))
 	}
 
 	var messages []models.Message
-	query := db.Where("conversation_id = ?", conversationID).
+	query := db.GetContext(req).Where("conversation_id = ?", conversationID).
 		Preload("User").
 		Preload("Client").
 		Limit(limit).
@@ -165,6 +165,9 @@ func (ac AgentController) GetConversationMessages(req *evo.Request) interface{}
 // AddAgentMessageRequest represents the request body for sending agent messages
 type AddAgentMessageRequest struct {
 	Body string `json:"body"`
+	// CannedMessageID is set when the body was produced from a canned message; it is counted
+	// as a usage and the canned message attachments are appended to the body
+	CannedMessageID *uint `json:"canned_message_id,omitempty"`
 }
 
 // AddAgentMessage handles the POST /api/agent/conversations/:id/messages endpoint
@@ -191,7 +194,7 @@ func (ac AgentController) AddAgentMessage(req *evo.Request) interface{} {
 	}
 
 	var conversation models.Conversation
-	if err := db.Preload("Client").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+	if err := db.GetContext(req).Preload("Client").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, fmt.Sprintf("No conversation exists with ID %d", conversationID)))
 	}
 
@@ -204,6 +207,16 @@ func (ac AgentController) AddAgentMessage(req *evo.Request) interface{} {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Message body is required", 400, "Message body cannot be empty"))
 	}
 
+	var cannedMessage *models.CannedMessage
+	if input.CannedMessageID != nil && user != nil {
+		var errResp interface{}
+		cannedMessage, errResp = findVisibleCannedMessage(*input.CannedMessageID, user)
+		if errResp != nil {
+			return errResp
+		}
+		input.Body = appendCannedAttachments(input.Body, cannedMessage.Attachments)
+	}
+
 	originalBody := input.Body
 	messageBody := input.Body
 	var translationRecord *models.ConversationMessageTranslation
@@ -248,19 +261,25 @@ func (ac AgentController) AddAgentMessage(req *evo.Request) interface{} {
 		IsSystemMessage: false,
 	}
 
-	if err := db.Create(&message).Error; err != nil {
+	if err := db.GetContext(req).Create(&message).Error; err != nil {
 		log.Error("Failed to create agent message:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to create message", 500, err.Error()))
 	}
 
 	if translationRecord != nil {
 		translationRecord.MessageID = message.ID
-		if err := db.Create(translationRecord).Error; err != nil {
+		if err := db.GetContext(req).Create(translationRecord).Error; err != nil {
 			log.Warning("Failed to save translation record: %v", err)
 		}
 	}
 
-	if err := db.Preload("Conversation").Preload("User").First(&message, message.ID).Error; err != nil {
+	if cannedMessage != nil {
+		if err := models.RecordCannedMessageUsage(cannedMessage.ID); err != nil {
+			log.Warning("Failed to record canned message usage: %v", err)
+		}
+	}
+
+	if err := db.GetContext(req).Preload("Conversation").Preload("User").First(&message, message.ID).Error; err != nil {
 		log.Warning("Failed to preload message relations:", err)
 	}
 
@@ -0,0 +1,541 @@
+package conversation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/pagination"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// OrganizationRequest is the request body for creating and updating organizations
+type OrganizationRequest struct {
+	Name    string         `json:"name"`
+	Website *string        `json:"website"`
+	Domains *[]string      `json:"domains"`
+	Data    map[string]any `json:"data"`
+	// AssociateExisting links existing clients without an organization whose email matches one of the domains
+	AssociateExisting bool `json:"associate_existing"`
+}
+
+// OrganizationNoteRequest is the request body for organization notes
+type OrganizationNoteRequest struct {
+	Body string `json:"body"`
+}
+
+// OrganizationListItem is an organization with its client count
+type OrganizationListItem struct {
+	models.Organization
+	ClientCount int64 `json:"client_count"`
+}
+
+// ListOrganizations returns a paginated list of organizations
+// @Summary List organizations
+// @Tags Agent - Organizations
+// @Accept json
+// @Produce json
+// @Param search query string false "Search in name or domain"
+// @Param page query int false "Page number" default(1)
+// @Param size query int false "Items per page" default(10)
+// @Success 200 {object} response.Response
+// @Router /api/agent/organizations [get]
+func (ac AgentController) ListOrganizations(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+
+	var organizations []models.Organization
+	query := db.GetContext(req).Model(&models.Organization{}).Preload("Domains")
+
+	if search := req.Query("search").String(); search != "" {
+		searchTerm := "%" + search + "%"
+		query = query.Where("name LIKE ? OR id IN (?)", searchTerm,
+			db.GetContext(req).Model(&models.OrganizationDomain{}).Select("organization_id").Where("domain LIKE ?", searchTerm))
+	}
+	query = query.Order("name ASC")
+
+	p, err := pagination.New(query, req, &organizations, pagination.Options{MaxSize: 100})
+	if err != nil {
+		log.Error("Failed to fetch organizations:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch organizations", 500, err.Error()))
+	}
+
+	items := make([]OrganizationListItem, 0, len(organizations))
+	for _, organization := range organizations {
+		item := OrganizationListItem{Organization: organization}
+		db.GetContext(req).Model(&models.Client{}).Where("organization_id = ?", organization.ID).Count(&item.ClientCount)
+		items = append(items, item)
+	}
+
+	return response.OKWithMeta(items, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// GetOrganization returns an organization with its domains and notes
+// @Summary Get organization
+// @Tags Agent - Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Success 200 {object} response.Response
+// @Router /api/agent/organizations/{id} [get]
+func (ac AgentController) GetOrganization(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+
+	var organization models.Organization
+	if err := db.GetContext(req).Preload("Domains").
+		Preload("Notes", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at DESC") }).
+		Where("id = ?", req.Param("id").Uint()).First(&organization).Error; err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Organization not found", 404, err.Error()))
+	}
+
+	item := OrganizationListItem{Organization: organization}
+	db.GetContext(req).Model(&models.Client{}).Where("organization_id = ?", organization.ID).Count(&item.ClientCount)
+
+	return response.OK(item)
+}
+
+// CreateOrganization creates an organization
+// @Summary Create organization
+// @Tags Agent - Organizations
+// @Accept json
+// @Produce json
+// @Param body body OrganizationRequest true "Organization data"
+// @Success 201 {object} response.Response
+// @Router /api/agent/organizations [post]
+func (ac AgentController) CreateOrganization(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+
+	var input OrganizationRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request body", 400, err.Error()))
+	}
+	if strings.TrimSpace(input.Name) == "" {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Name is required", 400, "name field cannot be empty"))
+	}
+
+	domains, errResp := normalizeOrganizationDomains(db.GetContext(req), input.Domains, 0)
+	if errResp != nil {
+		return errResp
+	}
+
+	data, err := processCustomAttributes(models.CustomAttributeScopeOrganization, nil, input.Data, true)
+	if err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid custom attributes", 400, err.Error()))
+	}
+	if data == nil {
+		data = datatypes.JSON("{}")
+	}
+
+	organization := models.Organization{
+		Name:    strings.TrimSpace(input.Name),
+		Website: input.Website,
+		Data:    data,
+	}
+
+	err = db.GetContext(req).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Domains", "Notes", "Clients").Create(&organization).Error; err != nil {
+			return err
+		}
+		return replaceOrganizationDomains(tx, organization.ID, domains)
+	})
+	if err != nil {
+		log.Error("Failed to create organization:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to create organization", 500, err.Error()))
+	}
+
+	if input.AssociateExisting {
+		associateOrganizationClients(db.GetContext(req), organization.ID, domains)
+	}
+
+	db.GetContext(req).Preload("Domains").First(&organization, organization.ID)
+	return response.Created(organization)
+}
+
+// UpdateOrganization updates an organization. When domains are given they replace the existing ones.
+// @Summary Update organization
+// @Tags Agent - Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param body body OrganizationRequest true "Organization data"
+// @Success 200 {object} response.Response
+// @Router /api/agent/organizations/{id} [put]
+func (ac AgentController) UpdateOrganization(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+
+	var organization models.Organization
+	if err := db.GetContext(req).Where("id = ?", req.Param("id").Uint()).First(&organization).Error; err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Organization not found", 404, err.Error()))
+	}
+
+	var input OrganizationRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request body", 400, err.Error()))
+	}
+
+	updates := map[string]any{}
+	if strings.TrimSpace(input.Name) != "" {
+		updates["name"] = strings.TrimSpace(input.Name)
+	}
+	if input.Website != nil {
+		updates["website"] = input.Website
+	}
+	if input.Data != nil {
+		data, err := processCustomAttributes(models.CustomAttributeScopeOrganization, nil, input.Data, false)
+		if err != nil {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid custom attributes", 400, err.Error()))
+		}
+		if data == nil {
+			data = datatypes.JSON("{}")
+		}
+		updates["data"] = data
+	}
+
+	var domains []string
+	if input.Domains != nil {
+		var errResp interface{}
+		domains, errResp = normalizeOrganizationDomains(db.GetContext(req), input.Domains, organization.ID)
+		if errResp != nil {
+			return errResp
+		}
+	}
+
+	err := db.GetContext(req).Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(&organization).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if input.Domains != nil {
+			return replaceOrganizationDomains(tx, organization.ID, domains)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("Failed to update organization:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to update organization", 500, err.Error()))
+	}
+
+	if input.AssociateExisting && input.Domains != nil {
+		associateOrganizationClients(db.GetContext(req), organization.ID, domains)
+	}
+
+	db.GetContext(req).Preload("Domains").First(&organization, organization.ID)
+	return response.OK(organization)
+}
+
+// DeleteOrganization deletes an organization; its clients are kept and unlinked
+// @Summary Delete organization
+// @Tags Agent - Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Success 200 {object} response.Response
+// @Router /api/agent/organizations/{id} [delete]
+func (ac AgentController) DeleteOrganization(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+	if user.Type != auth.UserTypeAdministrator {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInsufficientPermissions, "Only administrators can delete organizations", 403, "administrator role required"))
+	}
+
+	var organization models.Organization
+	if err := db.GetContext(req).Where("id = ?", req.Param("id").Uint()).First(&organization).Error; err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Organization not found", 404, err.Error()))
+	}
+
+	err := db.GetContext(req).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Client{}).Where("organization_id = ?", organization.ID).UpdateColumn("organization_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", organization.ID).Delete(&models.OrganizationDomain{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", organization.ID).Delete(&models.OrganizationNote{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&organization).Error
+	})
+	if err != nil {
+		log.Error("Failed to delete organization:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to delete organization", 500, err.Error()))
+	}
+
+	return response.OK(map[string]interface{}{
+		"message": "Organization deleted successfully",
+		"id":      organization.ID,
+	})
+}
+
+// GetOrganizationClients returns the clients of an organization
+// @Summary List organization clients
+// @Tags Agent - Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Success 200 {object} response.Response
+// @Router /api/agent/organizations/{id}/clients [get]
+func (ac AgentController) GetOrganizationClients(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+
+	var clients []models.Client
+	query := db.GetContext(req).Model(&models.Client{}).Preload("ExternalIDs").
+		Where("organization_id = ?", req.Param("id").Uint()).
+		Order("name ASC")
+
+	p, err := pagination.New(query, req, &clients, pagination.Options{MaxSize: 100})
+	if err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch clients", 500, err.Error()))
+	}
+
+	return response.OKWithMeta(clients, &response.Meta{
+		Page:       p.CurrentPage,
+		Limit:      p.Size,
+		Total:      int64(p.Records),
+		TotalPages: p.Pages,
+	})
+}
+
+// GetOrganizationConversations returns the conversations of all clients of an organization
+// @Summary List organization conversations
+// @Tags Agent - Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param status query string false "Comma-separated status values"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(10)
+// @Success 200 {object} PreviousConversationsResponse
+// @Router /api/agent/organizations/{id}/conversations [get]
+func (ac AgentController) GetOrganizationConversations(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+
+	organizationID := req.Param("id").Uint()
+	if organizationID == 0 {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid organization ID", 400, "Organization ID must be a positive integer"))
+	}
+
+	page := req.Query("page").Int()
+	if page < 1 {
+		page = 1
+	}
+	limit := req.Query("limit").Int()
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+	offset := (page - 1) * limit
+
+	query := db.GetContext(req).Model(&models.Conversation{}).
+		Preload("Client").
+		Where("client_id IN (?)", db.GetContext(req).Model(&models.Client{}).Select("id").Where("organization_id = ?", organizationID)).
+		Order("updated_at DESC")
+	if statusStr := req.Query("status").String(); statusStr != "" {
+		query = query.Where("status IN ?", strings.Split(statusStr, ","))
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Error("Failed to count organization conversations: ", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to count conversations", 500, err.Error()))
+	}
+
+	var conversations []models.Conversation
+	if err := query.Limit(limit).Offset(offset).Find(&conversations).Error; err != nil {
+		log.Error("Failed to fetch organization conversations: ", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch conversations", 500, err.Error()))
+	}
+
+	items := make([]PreviousConversationItem, 0, len(conversations))
+	for _, conv := range conversations {
+		items = append(items, PreviousConversationItem{
+			ID:                 conv.ID,
+			ConversationNumber: fmt.Sprintf("CONV-%d", conv.ID),
+			Title:              conv.Title,
+			Status:             conv.Status,
+			Priority:           conv.Priority,
+			ClientID:           conv.ClientID.String(),
+			ClientName:         conv.Client.Name,
+			CreatedAt:          conv.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+			UpdatedAt:          conv.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		})
+	}
+
+	totalPages := int(total) / limit
+	if int(total)%limit != 0 {
+		totalPages++
+	}
+
+	return response.OK(PreviousConversationsResponse{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+		Data:       items,
+	})
+}
+
+// AddOrganizationNote adds an internal note to an organization
+// @Summary Add organization note
+// @Tags Agent - Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param body body OrganizationNoteRequest true "Note"
+// @Success 201 {object} response.Response
+// @Router /api/agent/organizations/{id}/notes [post]
+func (ac AgentController) AddOrganizationNote(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	organizationID := req.Param("id").Uint()
+	if !organizationExists(db.GetContext(req), organizationID) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Organization not found", 404, fmt.Sprintf("No organization exists with ID %d", organizationID)))
+	}
+
+	var input OrganizationNoteRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request body", 400, err.Error()))
+	}
+	if strings.TrimSpace(input.Body) == "" {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Note body is required", 400, "body field cannot be empty"))
+	}
+
+	note := models.OrganizationNote{
+		OrganizationID: organizationID,
+		UserID:         &user.UserID,
+		Body:           input.Body,
+	}
+	if err := db.GetContext(req).Create(&note).Error; err != nil {
+		log.Error("Failed to create organization note:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to create note", 500, err.Error()))
+	}
+
+	return response.Created(note)
+}
+
+// DeleteOrganizationNote deletes an organization note. Agents can only delete their own notes.
+// @Summary Delete organization note
+// @Tags Agent - Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param note_id path int true "Note ID"
+// @Success 200 {object} response.Response
+// @Router /api/agent/organizations/{id}/notes/{note_id} [delete]
+func (ac AgentController) DeleteOrganizationNote(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	var note models.OrganizationNote
+	if err := db.GetContext(req).Where("id = ? AND organization_id = ?", req.Param("note_id").Uint(), req.Param("id").Uint()).First(&note).Error; err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Note not found", 404, err.Error()))
+	}
+	if user.Type != auth.UserTypeAdministrator && (note.UserID == nil || *note.UserID != user.UserID) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "You can only delete your own notes", 403, "note belongs to another user"))
+	}
+
+	if err := db.GetContext(req).Delete(&note).Error; err != nil {
+		log.Error("Failed to delete organization note:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to delete note", 500, err.Error()))
+	}
+
+	return response.OK(map[string]interface{}{
+		"message": "Note deleted successfully",
+		"id":      note.ID,
+	})
+}
+
+// normalizeOrganizationDomains normalizes and validates domains and makes sure no other organization
+// of the workspace of tx owns them
+func normalizeOrganizationDomains(tx *gorm.DB, input *[]string, organizationID uint) ([]string, interface{}) {
+	if input == nil {
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	domains := make([]string, 0, len(*input))
+	for _, raw := range *input {
+		domain := models.NormalizeDomain(raw)
+		if domain == "" || seen[domain] {
+			continue
+		}
+		if err := models.ValidateDomain(domain); err != nil {
+			return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid domain", 400, err.Error()))
+		}
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+
+	if len(domains) > 0 {
+		var taken []string
+		tx.Model(&models.OrganizationDomain{}).
+			Where("domain IN ? AND organization_id <> ?", domains, organizationID).
+			Pluck("domain", &taken)
+		if len(taken) > 0 {
+			return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeConflict, "Domain already belongs to another organization", 409, strings.Join(taken, ", ")))
+		}
+	}
+	return domains, nil
+}
+
+func replaceOrganizationDomains(tx *gorm.DB, organizationID uint, domains []string) error {
+	if err := tx.Where("organization_id = ?", organizationID).Delete(&models.OrganizationDomain{}).Error; err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		if err := tx.Create(&models.OrganizationDomain{OrganizationID: organizationID, Domain: domain}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// associateOrganizationClients links the clients of the workspace of tx without an organization
+// whose email address is on one of domains
+func associateOrganizationClients(tx *gorm.DB, organizationID uint, domains []string) {
+	for _, domain := range domains {
+		result := tx.Session(&gorm.Session{NewDB: true}).Model(&models.Client{}).
+			Where("organization_id IS NULL AND id IN (?)",
+				tx.Session(&gorm.Session{NewDB: true}).Model(&models.ClientExternalID{}).Select("client_id").
+					Where("type = ? AND value LIKE ?", models.ExternalIDTypeEmail, "%@"+domain)).
+			UpdateColumn("organization_id", organizationID)
+		if result.Error != nil {
+			log.Warning("Failed to associate clients of domain %s with organization %d: %v", domain, organizationID, result.Error)
+		}
+	}
+}
+
+// organizationExists reports whether the organization exists in the workspace of tx
+func organizationExists(tx *gorm.DB, id uint) bool {
+	var count int64
+	tx.Model(&models.Organization{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,188 @@
+package conversation
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// StartOutboundConversationRequest represents the request structure for an agent-initiated conversation
+type StartOutboundConversationRequest struct {
+	Channel          string                   `json:"channel" example:"email"`                       // email, telegram, whatsapp or slack
+	Title            string                   `json:"title" example:"Your order #1042"`              // Conversation title, used as the email subject
+	Message          string                   `json:"message" example:"Hi, your order has shipped."` // First message; optional when a WhatsApp template is sent
+	DepartmentID     *uint                    `json:"department_id" example:"1"`
+	Priority         string                   `json:"priority" example:"medium"`
+	InboxID          *uint                    `json:"inbox_id" example:"1"`
+	WhatsAppTemplate *models.WhatsAppTemplate `json:"whatsapp_template"` // Required on WhatsApp outside the 24-hour customer service window
+}
+
+// StartOutboundConversation starts a new conversation with an existing client and sends the first message
+// @Summary Start an outbound conversation
+// @Description Create a conversation with an existing client on email, Telegram, WhatsApp or Slack using the client's external ID, and deliver the first message. WhatsApp requires an approved template when the client has not messaged within the last 24 hours.
+// @Tags Agent - Clients
+// @Accept json
+// @Produce json
+// @Param id path string true "Client ID"
+// @Param body body StartOutboundConversationRequest true "Outbound conversation data"
+// @Success 201 {object} models.Conversation
+// @Router /api/agent/clients/{id}/conversations [post]
+func (ac AgentController) StartOutboundConversation(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	var client models.Client
+	if err := db.GetContext(req).Preload("ExternalIDs").Where("id = ?", req.Param("id").String()).First(&client).Error; err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Client not found", 404, "No client exists with the given ID"))
+	}
+
+	var input StartOutboundConversationRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request format", 400, err.Error()))
+	}
+
+	if !slices.Contains(models.OutboundChannels, input.Channel) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid channel", 400, "Channel must be one of: "+strings.Join(models.OutboundChannels, ", ")))
+	}
+	input.Message = strings.TrimSpace(input.Message)
+	if input.Priority == "" {
+		input.Priority = models.ConversationPriorityMedium
+	}
+
+	// Channel delivery uses the client's first external ID of the channel type
+	var externalID *models.ClientExternalID
+	for i := range client.ExternalIDs {
+		if client.ExternalIDs[i].Type == input.Channel {
+			externalID = &client.ExternalIDs[i]
+			break
+		}
+	}
+	if externalID == nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Client cannot be reached on this channel", 400, fmt.Sprintf("Client has no matching %s external ID", input.Channel)))
+	}
+
+	// WhatsApp only accepts free-form messages within the customer service window
+	template := input.WhatsAppTemplate
+	if input.Channel != models.ExternalIDTypeWhatsapp {
+		template = nil
+	} else if template == nil && !models.WhatsAppSessionOpen(client.ID) {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "WhatsApp template required", 400, "The client has not messaged in the last 24 hours; WhatsApp only allows approved template messages"))
+	}
+	if template != nil && strings.TrimSpace(template.Name) == "" {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "WhatsApp template name is required", 400, "whatsapp_template.name cannot be empty"))
+	}
+
+	if input.Message == "" {
+		if template == nil {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Message body is required", 400, "Message body cannot be empty"))
+		}
+		input.Message = fmt.Sprintf("[WhatsApp template: %s]", template.Name)
+	}
+	if input.Title == "" {
+		input.Title = fmt.Sprintf("%s Conversation", strings.ToUpper(input.Channel[:1])+input.Channel[1:])
+	}
+
+	// The inbox and department must belong to the agent's workspace
+	if input.InboxID != nil {
+		var inbox models.Inbox
+		if err := db.GetContext(req).Where("id = ?", *input.InboxID).First(&inbox).Error; err != nil {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid inbox", 400, fmt.Sprintf("Inbox %d not found", *input.InboxID)))
+		}
+	}
+	if input.DepartmentID != nil {
+		var department models.Department
+		if err := db.GetContext(req).Where("id = ?", *input.DepartmentID).First(&department).Error; err != nil {
+			return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid department", 400, fmt.Sprintf("Department %d not found", *input.DepartmentID)))
+		}
+	}
+
+	conversation, _, err := CreateConversation(ConversationInput{
+		Title:        input.Title,
+		ClientID:     client.ID,
+		DepartmentID: input.DepartmentID,
+		ChannelID:    input.Channel,
+		Status:       models.ConversationStatusWaitForUser,
+		Priority:     input.Priority,
+		InboxID:      input.InboxID,
+		WorkspaceID:  models.RequestWorkspaceID(req),
+	})
+	if err != nil {
+		log.Error("Failed to create outbound conversation:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Failed to create conversation", 400, err.Error()))
+	}
+
+	// The message is delivered below so that a failure can be reported to the agent
+	message := models.Message{
+		ConversationID:      conversation.ID,
+		UserID:              &user.UserID,
+		Body:                input.Message,
+		SkipChannelDelivery: true,
+	}
+	if err := db.GetContext(req).Create(&message).Error; err != nil {
+		log.Error("Failed to create outbound message:", err)
+		failOutboundConversation(req, conversation, err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to create message", 500, fmt.Sprintf("Conversation %d was closed: %v", conversation.ID, err)))
+	}
+
+	if template != nil {
+		if models.SendWhatsAppTemplate == nil {
+			err = fmt.Errorf("WhatsApp template sending is not available")
+		} else {
+			err = models.SendWhatsAppTemplate(conversation.WorkspaceID, externalID.Value, *template)
+		}
+	} else {
+		err = message.DeliverToExternalChannel()
+	}
+	if err != nil {
+		log.Error("Failed to deliver outbound message for conversation %d: %v", conversation.ID, err)
+		failOutboundConversation(req, conversation, err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to send message", 502, fmt.Sprintf("Conversation %d was closed: %v", conversation.ID, err)))
+	}
+
+	// The initiating agent owns the conversation
+	assignment := models.ConversationAssignment{
+		ConversationID: conversation.ID,
+		UserID:         &user.UserID,
+	}
+	if err := db.GetContext(req).Create(&assignment).Error; err != nil {
+		log.Warning("Failed to assign outbound conversation %d: %v", conversation.ID, err)
+	}
+
+	models.LogConversationCreate(conversation.ID, user, map[string]any{
+		"channel":     input.Channel,
+		"client_id":   client.ID,
+		"external_id": externalID.Value,
+		"outbound":    true,
+	}, req.IP(), req.Header("User-Agent"))
+
+	if err := db.GetContext(req).Preload("Client.ExternalIDs").Preload("Department").Preload("Channel").Preload("Assignments.User").First(conversation, conversation.ID).Error; err != nil {
+		log.Warning("Failed to preload conversation relations:", err)
+	}
+
+	return response.Created(conversation)
+}
+
+// failOutboundConversation closes a conversation whose first message could not be delivered. The
+// conversation is kept: its creation has already been published to NATS and webhook subscribers.
+func failOutboundConversation(req *evo.Request, conversation *models.Conversation, cause error) {
+	now := time.Now()
+	if err := db.GetContext(req).Model(conversation).Updates(map[string]any{
+		"status":    models.ConversationStatusClosed,
+		"closed_at": &now,
+	}).Error; err != nil {
+		log.Warning("Failed to close undelivered conversation %d: %v", conversation.ID, err)
+	}
+	if err := models.CreateActionMessage(conversation.ID, nil, "", fmt.Sprintf(`could not deliver the first message: "%v"`, cause)); err != nil {
+		log.Warning("Failed to record delivery failure of conversation %d: %v", conversation.ID, err)
+	}
+}
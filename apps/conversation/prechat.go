@@ -0,0 +1,132 @@
+package conversation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/models"
+	"gorm.io/datatypes"
+)
+
+// validatePreChatSubmission checks a widget conversation request against the inbox pre-chat form.
+// Answers are validated through processCustomAttributes before anything is created, so an invalid
+// form never leaves a half-created client or conversation behind. When consent is collected, the
+// acceptance is recorded in the conversation parameters.
+func validatePreChatSubmission(form *models.PreChatForm, input *CreateConversationRequest) error {
+	existingClient := input.ClientID != nil && *input.ClientID != ""
+
+	if !existingClient {
+		if form.RequireName && (input.ClientName == nil || strings.TrimSpace(*input.ClientName) == "") {
+			return fmt.Errorf("client_name is required")
+		}
+		if form.RequireEmail {
+			if input.ClientEmail == nil || strings.TrimSpace(*input.ClientEmail) == "" {
+				return fmt.Errorf("client_email is required")
+			}
+			if _, err := castToEmail(*input.ClientEmail); err != nil {
+				return fmt.Errorf("client_email: %w", err)
+			}
+		}
+	}
+
+	// Existing clients may already have answered client fields in a previous conversation
+	var existingData map[string]interface{}
+	if existingClient {
+		if clientID, err := uuid.Parse(*input.ClientID); err == nil {
+			var client models.Client
+			if err := db.Select("data").First(&client, "id = ?", clientID).Error; err == nil {
+				existingData = parseJSONToMap(client.Data)
+			}
+		}
+	}
+
+	for _, field := range form.Fields {
+		if !field.Required {
+			continue
+		}
+		answers := input.Parameters
+		if field.Scope == models.CustomAttributeScopeClient {
+			answers = input.ClientAttributes
+		}
+		if isEmptyAnswer(answers[field.Name]) && (field.Scope != models.CustomAttributeScopeClient || isEmptyAnswer(existingData[field.Name])) {
+			label := field.Label
+			if label == "" {
+				label = field.Name
+			}
+			return fmt.Errorf("%s is required", label)
+		}
+	}
+
+	if form.Consent != nil && form.Consent.Enabled && form.Consent.Required {
+		if input.Consent == nil || !*input.Consent {
+			return fmt.Errorf("consent is required")
+		}
+	}
+
+	if _, err := processCustomAttributes(models.CustomAttributeScopeClient, nil, input.ClientAttributes, !existingClient); err != nil {
+		return err
+	}
+	if _, err := processCustomAttributes(models.CustomAttributeScopeConversation, input.DepartmentID, input.Parameters, true); err != nil {
+		return err
+	}
+
+	if form.Consent != nil && form.Consent.Enabled && input.Consent != nil && *input.Consent {
+		if input.Parameters == nil {
+			input.Parameters = make(map[string]any)
+		}
+		input.Parameters["pre_chat_consent"] = true
+		input.Parameters["pre_chat_consent_at"] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	return nil
+}
+
+// isEmptyAnswer reports whether a submitted form value should be treated as missing
+func isEmptyAnswer(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// mergeClientAttributes validates attributes and merges them into an existing client's data,
+// keeping values that were not submitted
+func mergeClientAttributes(clientID uuid.UUID, attributes map[string]any) error {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	processed, err := processCustomAttributes(models.CustomAttributeScopeClient, nil, attributes, false)
+	if err != nil || processed == nil {
+		return err
+	}
+
+	var client models.Client
+	if err := db.First(&client, "id = ?", clientID).Error; err != nil {
+		return fmt.Errorf("client not found: %w", err)
+	}
+
+	data := parseJSONToMap(client.Data)
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	for key, value := range parseJSONToMap(processed) {
+		data[key] = value
+	}
+
+	merged, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal client data: %w", err)
+	}
+	return db.Model(&client).Update("data", datatypes.JSON(merged)).Error
+}
@@ -0,0 +1,238 @@
+package conversation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+)
+
+// ScheduledMessageRequest represents the request structure for scheduling or editing an agent message
+type ScheduledMessageRequest struct {
+	Body        string     `json:"body" example:"Good morning! Your replacement ships today."`
+	SendAt      *time.Time `json:"send_at" example:"2026-01-15T08:00:00Z"`   // Absolute send time
+	SendAtLocal string     `json:"send_at_local" example:"2026-01-15T09:00"` // Send time in the client's timezone, used when send_at is empty
+}
+
+// ListScheduledMessages returns the scheduled messages of a conversation
+// @Summary List scheduled messages
+// @Description Get the messages scheduled for a conversation, soonest first. Filter by status (pending, sent, cancelled, failed).
+// @Tags Agent - Conversations
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param status query string false "Status filter"
+// @Success 200 {array} models.ScheduledMessage
+// @Router /api/agent/conversations/{id}/scheduled-messages [get]
+func (ac AgentController) ListScheduledMessages(req *evo.Request) interface{} {
+	conversationID := req.Param("id").Uint()
+	if conversationID == 0 {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid conversation ID", 400, "Conversation ID must be a positive integer"))
+	}
+
+	var count int64
+	db.GetContext(req).Model(&models.Conversation{}).Where("id = ?", conversationID).Count(&count)
+	if count == 0 {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, fmt.Sprintf("No conversation exists with ID %d", conversationID)))
+	}
+
+	query := db.GetContext(req).Preload("User").Where("conversation_id = ?", conversationID)
+	if status := req.Query("status").String(); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var scheduled []models.ScheduledMessage
+	if err := query.Order("send_at ASC").Find(&scheduled).Error; err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch scheduled messages", 500, err.Error()))
+	}
+
+	return response.OK(scheduled)
+}
+
+// CreateScheduledMessage schedules an agent reply to be sent later
+// @Summary Schedule a message
+// @Description Write a reply now and send it at a chosen time. Use send_at for an absolute time or send_at_local for a wall-clock time in the client's timezone. The message is cancelled if the conversation is closed or the customer writes before then.
+// @Tags Agent - Conversations
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param body body ScheduledMessageRequest true "Scheduled message data"
+// @Success 201 {object} models.ScheduledMessage
+// @Router /api/agent/conversations/{id}/scheduled-messages [post]
+func (ac AgentController) CreateScheduledMessage(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	conversationID := req.Param("id").Uint()
+	if conversationID == 0 {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid conversation ID", 400, "Conversation ID must be a positive integer"))
+	}
+
+	var conversation models.Conversation
+	if err := db.GetContext(req).Preload("Client").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, fmt.Sprintf("No conversation exists with ID %d", conversationID)))
+	}
+	if conversation.Status == models.ConversationStatusClosed || conversation.Status == models.ConversationStatusArchived {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Conversation is closed", 400, "Messages cannot be scheduled for a closed conversation"))
+	}
+
+	var input ScheduledMessageRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request format", 400, err.Error()))
+	}
+	if strings.TrimSpace(input.Body) == "" {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Message body is required", 400, "Message body cannot be empty"))
+	}
+
+	sendAt, errResp := resolveScheduledSendTime(&input, conversation.Client.Timezone)
+	if errResp != nil {
+		return errResp
+	}
+
+	scheduled := models.ScheduledMessage{
+		ConversationID: conversationID,
+		UserID:         user.UserID,
+		Body:           input.Body,
+		SendAt:         sendAt,
+		Status:         models.ScheduledMessageStatusPending,
+	}
+	if err := db.GetContext(req).Create(&scheduled).Error; err != nil {
+		log.Error("Failed to create scheduled message:", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to schedule message", 500, err.Error()))
+	}
+
+	return response.Created(scheduled)
+}
+
+// UpdateScheduledMessage edits the body or send time of a pending scheduled message
+// @Summary Edit a scheduled message
+// @Description Change the body or send time of a scheduled message that has not been sent yet. Only the agent who scheduled it can edit it.
+// @Tags Agent - Conversations
+// @Accept json
+// @Produce json
+// @Param id path int true "Scheduled message ID"
+// @Param body body ScheduledMessageRequest true "Scheduled message data"
+// @Success 200 {object} models.ScheduledMessage
+// @Router /api/agent/scheduled-messages/{id} [put]
+func (ac AgentController) UpdateScheduledMessage(req *evo.Request) interface{} {
+	scheduled, errResp := findOwnScheduledMessage(req)
+	if errResp != nil {
+		return errResp
+	}
+
+	var input ScheduledMessageRequest
+	if err := req.BodyParser(&input); err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid request format", 400, err.Error()))
+	}
+
+	updates := map[string]any{}
+	if strings.TrimSpace(input.Body) != "" {
+		scheduled.Body = input.Body
+		updates["body"] = input.Body
+	}
+	if input.SendAt != nil || input.SendAtLocal != "" {
+		var conversation models.Conversation
+		db.GetContext(req).Preload("Client").First(&conversation, scheduled.ConversationID)
+		sendAt, errResp := resolveScheduledSendTime(&input, conversation.Client.Timezone)
+		if errResp != nil {
+			return errResp
+		}
+		scheduled.SendAt = sendAt
+		updates["send_at"] = sendAt
+	}
+	if len(updates) == 0 {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Nothing to update", 400, "Provide body, send_at or send_at_local"))
+	}
+
+	// The dispatcher may have claimed the message in the meantime
+	result := db.GetContext(req).Model(&models.ScheduledMessage{}).
+		Where("id = ? AND status = ?", scheduled.ID, models.ScheduledMessageStatusPending).
+		Updates(updates)
+	if result.Error != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to update scheduled message", 500, result.Error.Error()))
+	}
+	if result.RowsAffected == 0 {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeConflict, "Scheduled message cannot be changed", 409, models.ErrScheduledMessageNotPending.Error()))
+	}
+
+	return response.OK(scheduled)
+}
+
+// CancelScheduledMessage cancels a pending scheduled message
+// @Summary Cancel a scheduled message
+// @Description Cancel a scheduled message that has not been sent yet. Only the agent who scheduled it can cancel it.
+// @Tags Agent - Conversations
+// @Produce json
+// @Param id path int true "Scheduled message ID"
+// @Success 200 {object} models.ScheduledMessage
+// @Router /api/agent/scheduled-messages/{id} [delete]
+func (ac AgentController) CancelScheduledMessage(req *evo.Request) interface{} {
+	scheduled, errResp := findOwnScheduledMessage(req)
+	if errResp != nil {
+		return errResp
+	}
+
+	now := time.Now()
+	result := db.GetContext(req).Model(&models.ScheduledMessage{}).
+		Where("id = ? AND status = ?", scheduled.ID, models.ScheduledMessageStatusPending).
+		Updates(map[string]any{
+			"status":        models.ScheduledMessageStatusCancelled,
+			"cancel_reason": models.ScheduledMessageCancelAgent,
+			"cancelled_at":  now,
+		})
+	if result.Error != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to cancel scheduled message", 500, result.Error.Error()))
+	}
+	if result.RowsAffected == 0 {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeConflict, "Scheduled message cannot be cancelled", 409, models.ErrScheduledMessageNotPending.Error()))
+	}
+
+	scheduled.Status = models.ScheduledMessageStatusCancelled
+	scheduled.CancelReason = models.ScheduledMessageCancelAgent
+	scheduled.CancelledAt = &now
+	return response.OK(scheduled)
+}
+
+// findOwnScheduledMessage loads a pending scheduled message created by the current agent
+func findOwnScheduledMessage(req *evo.Request) (*models.ScheduledMessage, interface{}) {
+	if req.User().Anonymous() {
+		return nil, response.Error(response.ErrUnauthorized)
+	}
+	user := req.User().Interface().(*auth.User)
+
+	id := req.Param("id").Uint()
+	if id == 0 {
+		return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid scheduled message ID", 400, "Scheduled message ID must be a positive integer"))
+	}
+
+	var scheduled models.ScheduledMessage
+	if err := db.GetContext(req).First(&scheduled, id).Error; err != nil {
+		return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Scheduled message not found", 404, fmt.Sprintf("No scheduled message exists with ID %d", id)))
+	}
+	if scheduled.UserID != user.UserID {
+		return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeForbidden, "Not allowed", 403, "Only the agent who scheduled the message can change it"))
+	}
+	if scheduled.Status != models.ScheduledMessageStatusPending {
+		return nil, response.Error(response.NewErrorWithDetails(response.ErrorCodeConflict, "Scheduled message cannot be changed", 409, models.ErrScheduledMessageNotPending.Error()))
+	}
+	return &scheduled, nil
+}
+
+// resolveScheduledSendTime turns the request send time into an absolute time in the future
+func resolveScheduledSendTime(input *ScheduledMessageRequest, timezone *string) (time.Time, interface{}) {
+	sendAt, err := models.ResolveSendTime(input.SendAt, input.SendAtLocal, timezone)
+	if err != nil {
+		return time.Time{}, response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid send time", 400, err.Error()))
+	}
+	if !sendAt.After(time.Now()) {
+		return time.Time{}, response.Error(response.NewErrorWithDetails(response.ErrorCodeValidationError, "Invalid send time", 400, models.ErrScheduledMessageInPast.Error()))
+	}
+	return sendAt, nil
+}
@@ -1,7 +1,9 @@
 package conversation
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
@@ -28,9 +30,14 @@ import (
 // @Param priority query string false "Comma-separated priority values (low,medium,high,urgent)"
 // @Param channel query string false "Comma-separated channel IDs"
 // @Param department_id query string false "Comma-separated department IDs"
+// @Param organization_id query string false "Comma-separated organization IDs of the customer"
 // @Param tags query string false "Comma-separated tag names or IDs"
 // @Param assigned_to_me query boolean false "Filter conversations assigned to authenticated agent"
 // @Param unassigned query boolean false "Filter unassigned conversations only"
+// @Param attr.{name} query string false "Filter by conversation custom attribute value (comma-separated values match any; multi_select matches any selected option)"
+// @Param attr.{name}.from query string false "Lower bound (inclusive) for numeric, date and datetime conversation attributes"
+// @Param attr.{name}.to query string false "Upper bound (inclusive) for numeric, date and datetime conversation attributes"
+// @Param client_attr.{name} query string false "Filter by client custom attribute value (same syntax as attr.{name})"
 // @Param has_unread query boolean false "Filter conversations with unread messages"
 // @Param sort_by query string false "Sort field (created_at,updated_at,priority,status)" default(updated_at)
 // @Param sort_order query string false "Sort order (asc,desc)" default(desc)
@@ -62,21 +69,21 @@ func (ac AgentController) SearchConversations(req *evo.Request) interface{} {
 	}
 
 	// Build query
-	query := db.Model(&models.Conversation{})
+	query := db.GetContext(req).Model(&models.Conversation{})
 
 	// Apply search filter
 	search := req.Query("search").String()
 	if search != "" {
 		searchTerm := "%" + search + "%"
 		query = query.Where(
-			db.Where("conversations.title LIKE ?", searchTerm).
+			db.GetContext(req).Where("conversations.title LIKE ?", searchTerm).
 				Or("conversations.id IN (?)",
-					db.Model(&models.Message{}).
+					db.GetContext(req).Model(&models.Message{}).
 						Select("conversation_id").
 						Where("body LIKE ?", searchTerm),
 				).
 				Or("conversations.client_id IN (?)",
-					db.Model(&models.Client{}).
+					db.GetContext(req).Model(&models.Client{}).
 						Select("id").
 						Where("name LIKE ? OR data LIKE ?", searchTerm, searchTerm),
 				),
@@ -107,6 +114,16 @@ func (ac AgentController) SearchConversations(req *evo.Request) interface{} {
 		query = query.Where("conversations.department_id IN ?", deptIDs)
 	}
 
+	// Apply organization filter
+	if orgStr := req.Query("organization_id").String(); orgStr != "" {
+		orgIDs := strings.Split(orgStr, ",")
+		query = query.Where("conversations.client_id IN (?)",
+			db.GetContext(req).Model(&models.Client{}).
+				Select("id").
+				Where("organization_id IN ?", orgIDs),
+		)
+	}
+
 	// Apply inbox filter
 	if inboxStr := req.Query("inbox_id").String(); inboxStr != "" {
 		inboxIDs := strings.Split(inboxStr, ",")
@@ -117,7 +134,7 @@ func (ac AgentController) SearchConversations(req *evo.Request) interface{} {
 	if tagsStr := req.Query("tags").String(); tagsStr != "" {
 		tagNames := strings.Split(tagsStr, ",")
 		query = query.Where("conversations.id IN (?)",
-			db.Model(&models.ConversationTag{}).
+			db.GetContext(req).Model(&models.ConversationTag{}).
 				Select("conversation_id").
 				Joins("JOIN tags ON tags.id = conversation_tags.tag_id").
 				Where("tags.name IN ?", tagNames),
@@ -127,7 +144,7 @@ func (ac AgentController) SearchConversations(req *evo.Request) interface{} {
 	// Apply assigned_to_me filter
 	if req.Query("assigned_to_me").String() == "true" && userIDStr != "" {
 		query = query.Where("conversations.id IN (?)",
-			db.Model(&models.ConversationAssignment{}).
+			db.GetContext(req).Model(&models.ConversationAssignment{}).
 				Select("conversation_id").
 				Where("user_id = ?", userIDStr),
 		)
@@ -136,11 +153,17 @@ func (ac AgentController) SearchConversations(req *evo.Request) interface{} {
 	// Apply unassigned filter
 	if req.Query("unassigned").String() == "true" {
 		query = query.Where("conversations.id NOT IN (?)",
-			db.Model(&models.ConversationAssignment{}).
+			db.GetContext(req).Model(&models.ConversationAssignment{}).
 				Select("DISTINCT conversation_id"),
 		)
 	}
 
+	// Apply custom attribute filters (attr.<name>, client_attr.<name>)
+	query, err := applyCustomAttributeFilters(query, req.QueryString())
+	if err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "Invalid attribute filter", 400, err.Error()))
+	}
+
 	// Apply sorting with whitelist validation to prevent SQL injection
 	sortBy := req.Query("sort_by").String()
 	// Whitelist of allowed sort columns for conversations
@@ -179,6 +202,7 @@ func (ac AgentController) SearchConversations(req *evo.Request) interface{} {
 		Joins("Channel").
 		Preload("Inbox").
 		Preload("Client.ExternalIDs").
+		Preload("Client.Organization").
 		Preload("Tags").
 		Preload("Assignments", func(db *gorm.DB) *gorm.DB {
 			return db.Order("id ASC").Limit(10)
@@ -205,7 +229,7 @@ func (ac AgentController) SearchConversations(req *evo.Request) interface{} {
 		CreatedAt      time.Time
 	}
 	var lastMessages []lastMessageResult
-	if err := db.Raw(`
+	if err := db.GetContext(req).Raw(`
 		SELECT m.conversation_id, m.id, m.body, m.created_at
 		FROM messages m
 		WHERE m.conversation_id IN (?)
@@ -226,7 +250,7 @@ func (ac AgentController) SearchConversations(req *evo.Request) interface{} {
 		Count          int64
 	}
 	var messageCounts []messageCountResult
-	if err := db.Raw(`
+	if err := db.GetContext(req).Raw(`
 		SELECT conversation_id, COUNT(*) as count
 		FROM messages
 		WHERE conversation_id IN (?)
@@ -354,16 +378,17 @@ func (ac AgentController) SearchConversations(req *evo.Request) interface{} {
 			UnreadMessagesCount: 0,
 			IsAssignedToMe:      isAssignedToMe,
 			Customer: CustomerInfo{
-				ID:          conv.Client.ID.String(),
-				Name:        conv.Client.Name,
-				Email:       email,
-				Phone:       phone,
-				AvatarURL:   conv.Client.Avatar,
-				Initials:    initials,
-				ExternalIDs: externalIDs,
-				Language:    conv.Client.Language,
-				Timezone:    conv.Client.Timezone,
-				Data:        parseJSONToMap(conv.Client.Data),
+				ID:           conv.Client.ID.String(),
+				Name:         conv.Client.Name,
+				Email:        email,
+				Phone:        phone,
+				AvatarURL:    conv.Client.Avatar,
+				Initials:     initials,
+				ExternalIDs:  externalIDs,
+				Language:     conv.Client.Language,
+				Timezone:     conv.Client.Timezone,
+				Data:         parseJSONToMap(conv.Client.Data),
+				Organization: newOrganizationInfo(conv.Client.Organization),
 			},
 			AssignedAgents:  assignedAgents,
 			Department:      department,
@@ -456,7 +481,7 @@ func (ac AgentController) MarkConversationRead(req *evo.Request) interface{} {
 	}
 
 	var conversation models.Conversation
-	if err := db.Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+	if err := db.GetContext(req).Where("id = ?", conversationID).First(&conversation).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, fmt.Sprintf("No conversation exists with ID %d", conversationID)))
 	}
 
@@ -471,3 +496,133 @@ func (ac AgentController) MarkConversationRead(req *evo.Request) interface{} {
 		"marked_read_at":  markedAt.Format(time.RFC3339),
 	})
 }
+
+// customAttributeFilter is a parsed attr.<name> query filter
+type customAttributeFilter struct {
+	Scope  string
+	Name   string
+	Values []string
+	From   string
+	To     string
+}
+
+// parseCustomAttributeFilters extracts attr.<name>[.from|.to] and client_attr.<name>[.from|.to]
+// parameters from a raw query string
+func parseCustomAttributeFilters(rawQuery string) ([]*customAttributeFilter, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make(map[string]*customAttributeFilter)
+	var ordered []*customAttributeFilter
+	for key, vals := range values {
+		var scope, rest string
+		switch {
+		case strings.HasPrefix(key, "attr."):
+			scope, rest = models.CustomAttributeScopeConversation, strings.TrimPrefix(key, "attr.")
+		case strings.HasPrefix(key, "client_attr."):
+			scope, rest = models.CustomAttributeScopeClient, strings.TrimPrefix(key, "client_attr.")
+		default:
+			continue
+		}
+		if len(vals) == 0 || vals[0] == "" {
+			continue
+		}
+
+		name, bound, _ := strings.Cut(rest, ".")
+		if !isValidAttributeName(name) || (bound != "" && bound != "from" && bound != "to") {
+			return nil, fmt.Errorf("invalid attribute filter: %s", key)
+		}
+
+		filter, ok := filters[scope+"."+name]
+		if !ok {
+			filter = &customAttributeFilter{Scope: scope, Name: name}
+			filters[scope+"."+name] = filter
+			ordered = append(ordered, filter)
+		}
+		switch bound {
+		case "from":
+			filter.From = vals[0]
+		case "to":
+			filter.To = vals[0]
+		default:
+			filter.Values = strings.Split(vals[0], ",")
+		}
+	}
+	return ordered, nil
+}
+
+// applyCustomAttributeFilters narrows a conversation query by custom attribute values stored in
+// conversations.custom_fields (attr.<name>) or clients.data (client_attr.<name>)
+func applyCustomAttributeFilters(query *gorm.DB, rawQuery string) (*gorm.DB, error) {
+	filters, err := parseCustomAttributeFilters(rawQuery)
+	if err != nil || len(filters) == 0 {
+		return query, err
+	}
+
+	for _, filter := range filters {
+		var attr models.CustomAttribute
+		if err := query.Session(&gorm.Session{NewDB: true}).Where("scope = ? AND name = ?", filter.Scope, filter.Name).First(&attr).Error; err != nil {
+			// Unknown attributes are stored as plain values, so compare them as strings
+			attr = models.CustomAttribute{Scope: filter.Scope, Name: filter.Name, DataType: models.CustomAttributeDataTypeString}
+		}
+
+		column := "conversations.custom_fields"
+		if filter.Scope == models.CustomAttributeScopeClient {
+			column = "clients.data"
+		}
+		path := "$." + filter.Name
+		extracted := fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s, ?))", column)
+
+		var clauses []string
+		var args []interface{}
+		if len(filter.Values) > 0 {
+			if attr.DataType == models.CustomAttributeDataTypeMultiSelect {
+				var options []string
+				for _, value := range filter.Values {
+					encoded, _ := json.Marshal(value)
+					options = append(options, fmt.Sprintf("JSON_CONTAINS(JSON_EXTRACT(%s, ?), ?)", column))
+					args = append(args, path, string(encoded))
+				}
+				clauses = append(clauses, "("+strings.Join(options, " OR ")+")")
+			} else {
+				clauses = append(clauses, extracted+" IN ?")
+				args = append(args, path, filter.Values)
+			}
+		}
+
+		comparable := extracted
+		if attr.DataType == models.CustomAttributeDataTypeInt || attr.DataType == models.CustomAttributeDataTypeFloat {
+			comparable = "CAST(" + extracted + " AS DECIMAL(30,10))"
+		}
+		if filter.From != "" {
+			clauses = append(clauses, comparable+" >= ?")
+			args = append(args, path, filter.From)
+		}
+		if filter.To != "" {
+			to := filter.To
+			if attr.DataType == models.CustomAttributeDataTypeDate && len(to) == len("2006-01-02") {
+				// Include the whole day when only a date is given as the upper bound
+				to += "T23:59:59Z"
+			}
+			clauses = append(clauses, comparable+" <= ?")
+			args = append(args, path, to)
+		}
+		if len(clauses) == 0 {
+			continue
+		}
+
+		condition := strings.Join(clauses, " AND ")
+		if filter.Scope == models.CustomAttributeScopeClient {
+			query = query.Where("conversations.client_id IN (?)",
+				query.Session(&gorm.Session{NewDB: true}).Model(&models.Client{}).Select("id").
+					Where("clients.workspace_id = conversations.workspace_id").Where(condition, args...),
+			)
+		} else {
+			query = query.Where(condition, args...)
+		}
+	}
+
+	return query, nil
+}
@@ -29,7 +29,7 @@ func (ac AgentController) GetTags(req *evo.Request) interface{} {
 	}
 
 	var result []TagWithUsage
-	if err := db.Raw(`
+	if err := db.GetContext(req).Raw(`
 		SELECT t.id, t.name, '#4ECDC4' as color, COUNT(ct.tag_id) as usage_count
 		FROM tags t
 		LEFT JOIN conversation_tags ct ON t.id = ct.tag_id
@@ -59,7 +59,7 @@ func (ac AgentController) CreateTag(req *evo.Request) interface{} {
 	}
 
 	var existingTag models.Tag
-	if err := db.Where("name = ?", createReq.Name).First(&existingTag).Error; err == nil {
+	if err := db.GetContext(req).Where("name = ?", createReq.Name).First(&existingTag).Error; err == nil {
 		return response.OK(map[string]interface{}{
 			"id":    existingTag.ID,
 			"name":  existingTag.Name,
@@ -71,7 +71,7 @@ func (ac AgentController) CreateTag(req *evo.Request) interface{} {
 		Name: createReq.Name,
 	}
 
-	if err := db.Create(&tag).Error; err != nil {
+	if err := db.GetContext(req).Create(&tag).Error; err != nil {
 		log.Error("Failed to create tag:", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to create tag", 500, err.Error()))
 	}
@@ -101,7 +101,7 @@ func (ac AgentController) UpdateConversationTags(req *evo.Request) interface{} {
 	}
 
 	var conversation models.Conversation
-	if err := db.Preload("Tags").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+	if err := db.GetContext(req).Preload("Tags").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, fmt.Sprintf("No conversation exists with ID %d", conversationID)))
 	}
 
@@ -126,13 +126,13 @@ func (ac AgentController) UpdateConversationTags(req *evo.Request) interface{} {
 	// Get tags
 	var tags []models.Tag
 	if len(tagsReq.TagIDs) > 0 {
-		if err := db.Where("id IN ?", tagsReq.TagIDs).Find(&tags).Error; err != nil {
+		if err := db.GetContext(req).Where("id IN ?", tagsReq.TagIDs).Find(&tags).Error; err != nil {
 			return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to fetch tags", 500, err.Error()))
 		}
 	}
 
 	// Replace tags
-	if err := db.Model(&conversation).Association("Tags").Replace(tags); err != nil {
+	if err := db.GetContext(req).Model(&conversation).Association("Tags").Replace(tags); err != nil {
 		log.Error("Failed to update conversation tags: ", err)
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to update tags", 500, err.Error()))
 	}
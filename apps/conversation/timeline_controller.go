@@ -0,0 +1,380 @@
+package conversation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/response"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Timeline event type constants
+const (
+	TimelineEventConversationStarted = "conversation_started"
+	TimelineEventLivechatSession     = "livechat_session"
+	TimelineEventConversationClosed  = "conversation_closed"
+	TimelineEventMessage             = "message"
+	TimelineEventEmailReceived       = "email_received"
+	TimelineEventEmailSent           = "email_sent"
+	TimelineEventStatusChange        = "status_change"
+	TimelineEventAttributeChange     = "attribute_change"
+	TimelineEventClientMerged        = "client_merged"
+)
+
+// TimelineEvent is a single entry of the client timeline.
+// Data depends on Type; Actor is set for events performed by a user or the client.
+type TimelineEvent struct {
+	Type               string      `json:"type"`
+	OccurredAt         time.Time   `json:"occurred_at"`
+	ConversationID     *uint       `json:"conversation_id,omitempty"`
+	ConversationNumber string      `json:"conversation_number,omitempty"`
+	ChannelID          string      `json:"channel_id,omitempty"`
+	Actor              *AuthorInfo `json:"actor,omitempty"`
+	Data               any         `json:"data"`
+}
+
+// timelineSource is a SELECT producing (event_type, ref_id, conversation_id, occurred_at) rows for one client.
+// Each query takes the client ID as its only parameter.
+type timelineSource struct {
+	types []string
+	query string
+}
+
+var timelineSources = []timelineSource{
+	{
+		types: []string{TimelineEventConversationStarted, TimelineEventLivechatSession},
+		query: `SELECT CASE WHEN channel_id = 'web' THEN 'livechat_session' ELSE 'conversation_started' END AS event_type,
+			CAST(id AS CHAR) AS ref_id, id AS conversation_id, created_at AS occurred_at
+			FROM conversations WHERE client_id = ?`,
+	},
+	{
+		types: []string{TimelineEventConversationClosed},
+		query: `SELECT 'conversation_closed' AS event_type, CAST(id AS CHAR) AS ref_id, id AS conversation_id, closed_at AS occurred_at
+			FROM conversations WHERE client_id = ? AND closed_at IS NOT NULL`,
+	},
+	{
+		types: []string{TimelineEventMessage},
+		query: `SELECT 'message' AS event_type, CAST(messages.id AS CHAR) AS ref_id, messages.conversation_id AS conversation_id, messages.created_at AS occurred_at
+			FROM messages JOIN conversations ON conversations.id = messages.conversation_id
+			WHERE conversations.client_id = ? AND messages.type = 'message'`,
+	},
+	{
+		types: []string{TimelineEventEmailReceived, TimelineEventEmailSent},
+		query: `SELECT CASE WHEN email_messages.direction = 'outbound' THEN 'email_sent' ELSE 'email_received' END AS event_type,
+			CAST(email_messages.id AS CHAR) AS ref_id, email_messages.conversation_id AS conversation_id, email_messages.received_at AS occurred_at
+			FROM email_messages JOIN conversations ON conversations.id = email_messages.conversation_id
+			WHERE conversations.client_id = ?`,
+	},
+	{
+		types: []string{TimelineEventStatusChange},
+		query: `SELECT 'status_change' AS event_type, CAST(activity_logs.id AS CHAR) AS ref_id, conversations.id AS conversation_id, activity_logs.created_at AS occurred_at
+			FROM activity_logs JOIN conversations ON activity_logs.entity_id = CAST(conversations.id AS CHAR)
+			WHERE conversations.client_id = ? AND activity_logs.entity_type = 'conversation' AND activity_logs.action = 'status_change'`,
+	},
+	{
+		types: []string{TimelineEventAttributeChange, TimelineEventClientMerged},
+		query: `SELECT CASE WHEN action = 'merge' THEN 'client_merged' ELSE 'attribute_change' END AS event_type,
+			CAST(id AS CHAR) AS ref_id, NULL AS conversation_id, created_at AS occurred_at
+			FROM activity_logs WHERE entity_type = 'client' AND entity_id = ? AND action IN ('update', 'merge')`,
+	},
+}
+
+// timelineRow is a raw timeline entry before it is hydrated into a TimelineEvent
+type timelineRow struct {
+	EventType      string    `gorm:"column:event_type"`
+	RefID          string    `gorm:"column:ref_id"`
+	ConversationID *uint     `gorm:"column:conversation_id"`
+	OccurredAt     time.Time `gorm:"column:occurred_at"`
+}
+
+// GetClientTimeline returns a chronological feed (newest first) of everything that happened with a client
+// across all channels: conversations and livechat sessions, messages, email thread events, status changes,
+// attribute changes and merges.
+// @Summary Get client timeline
+// @Tags Agent - Clients
+// @Produce json
+// @Param id path string true "Client ID"
+// @Param types query string false "Comma-separated event types to include"
+// @Param from query string false "Only events at or after this time (RFC3339)"
+// @Param to query string false "Only events at or before this time (RFC3339)"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Success 200 {object} response.Response
+// @Router /api/agent/clients/{id}/timeline [get]
+func (ac AgentController) GetClientTimeline(req *evo.Request) interface{} {
+	if req.User().Anonymous() {
+		return response.Error(response.ErrUnauthorized)
+	}
+
+	var client models.Client
+	if err := db.GetContext(req).Where("id = ?", req.Param("id").String()).First(&client).Error; err != nil {
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Client not found", 404, "No client exists with the given ID"))
+	}
+
+	types := map[string]bool{}
+	if raw := req.Query("types").String(); raw != "" {
+		for _, eventType := range strings.Split(raw, ",") {
+			types[strings.TrimSpace(eventType)] = true
+		}
+	}
+
+	var parts []string
+	var args []any
+	var eventTypes []string
+	for _, source := range timelineSources {
+		included := false
+		for _, eventType := range source.types {
+			if len(types) == 0 || types[eventType] {
+				eventTypes = append(eventTypes, eventType)
+				included = true
+			}
+		}
+		if included {
+			parts = append(parts, source.query)
+			args = append(args, client.ID.String())
+		}
+	}
+	if len(parts) == 0 {
+		return response.BadRequest(req, "No valid event types given")
+	}
+
+	where := []string{"event_type IN ?"}
+	args = append(args, eventTypes)
+	for _, bound := range []struct{ param, condition string }{{"from", "occurred_at >= ?"}, {"to", "occurred_at <= ?"}} {
+		if value := req.Query(bound.param).String(); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return response.BadRequest(req, fmt.Sprintf("%s must be an RFC3339 timestamp", bound.param))
+			}
+			where = append(where, bound.condition)
+			args = append(args, t)
+		}
+	}
+	timeline := "(" + strings.Join(parts, " UNION ALL ") + ") AS timeline WHERE " + strings.Join(where, " AND ")
+
+	page := req.Query("page").Int()
+	if page < 1 {
+		page = 1
+	}
+	limit := req.Query("limit").Int()
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	var total int64
+	if err := db.GetContext(req).Raw("SELECT COUNT(*) FROM "+timeline, args...).Scan(&total).Error; err != nil {
+		log.Error("Failed to count client timeline: ", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to load timeline", 500, err.Error()))
+	}
+
+	var rows []timelineRow
+	err := db.GetContext(req).Raw("SELECT * FROM "+timeline+" ORDER BY occurred_at DESC, event_type ASC, CAST(ref_id AS UNSIGNED) DESC LIMIT ? OFFSET ?",
+		append(args, limit, (page-1)*limit)...).Scan(&rows).Error
+	if err != nil {
+		log.Error("Failed to fetch client timeline: ", err)
+		return response.Error(response.NewErrorWithDetails(response.ErrorCodeDatabaseError, "Failed to load timeline", 500, err.Error()))
+	}
+
+	totalPages := int(total) / limit
+	if int(total)%limit != 0 {
+		totalPages++
+	}
+
+	return response.OKWithMeta(hydrateTimeline(db.GetContext(req), rows), &response.Meta{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+// hydrateTimeline loads the records referenced by rows through tx and converts them into timeline events
+func hydrateTimeline(tx *gorm.DB, rows []timelineRow) []TimelineEvent {
+	var conversationIDs []uint
+	var messageIDs, emailIDs, logIDs []string
+	for _, row := range rows {
+		if row.ConversationID != nil {
+			conversationIDs = append(conversationIDs, *row.ConversationID)
+		}
+		switch row.EventType {
+		case TimelineEventMessage:
+			messageIDs = append(messageIDs, row.RefID)
+		case TimelineEventEmailReceived, TimelineEventEmailSent:
+			emailIDs = append(emailIDs, row.RefID)
+		case TimelineEventStatusChange, TimelineEventAttributeChange, TimelineEventClientMerged:
+			logIDs = append(logIDs, row.RefID)
+		}
+	}
+
+	conversations := map[uint]models.Conversation{}
+	if len(conversationIDs) > 0 {
+		var list []models.Conversation
+		tx.Preload("Inbox").Where("id IN ?", conversationIDs).Find(&list)
+		for _, conversation := range list {
+			conversations[conversation.ID] = conversation
+		}
+	}
+	messages := map[string]models.Message{}
+	if len(messageIDs) > 0 {
+		var list []models.Message
+		tx.Preload("User").Preload("Client").Where("id IN ?", messageIDs).Find(&list)
+		for _, message := range list {
+			messages[fmt.Sprint(message.ID)] = message
+		}
+	}
+	emails := map[string]models.EmailMessage{}
+	if len(emailIDs) > 0 {
+		var list []models.EmailMessage
+		tx.Where("id IN ?", emailIDs).Find(&list)
+		for _, email := range list {
+			emails[fmt.Sprint(email.ID)] = email
+		}
+	}
+	logs := map[string]models.ActivityLog{}
+	if len(logIDs) > 0 {
+		var list []models.ActivityLog
+		tx.Preload("User").Where("id IN ?", logIDs).Find(&list)
+		for _, entry := range list {
+			logs[fmt.Sprint(entry.ID)] = entry
+		}
+	}
+
+	events := make([]TimelineEvent, 0, len(rows))
+	for _, row := range rows {
+		event := TimelineEvent{
+			Type:           row.EventType,
+			OccurredAt:     row.OccurredAt,
+			ConversationID: row.ConversationID,
+		}
+		conversation, hasConversation := models.Conversation{}, false
+		if row.ConversationID != nil {
+			conversation, hasConversation = conversations[*row.ConversationID]
+			event.ConversationNumber = fmt.Sprintf("CONV-%d", *row.ConversationID)
+			event.ChannelID = conversation.ChannelID
+		}
+
+		switch row.EventType {
+		case TimelineEventConversationStarted, TimelineEventConversationClosed:
+			if !hasConversation {
+				continue
+			}
+			event.Data = map[string]any{
+				"title":         conversation.Title,
+				"status":        conversation.Status,
+				"priority":      conversation.Priority,
+				"department_id": conversation.DepartmentID,
+			}
+		case TimelineEventLivechatSession:
+			if !hasConversation {
+				continue
+			}
+			data := map[string]any{
+				"title":            conversation.Title,
+				"status":           conversation.Status,
+				"inbox_id":         conversation.InboxID,
+				"ip":               conversation.IP,
+				"browser":          conversation.Browser,
+				"operating_system": conversation.OperatingSystem,
+			}
+			if conversation.Inbox != nil {
+				data["inbox_name"] = conversation.Inbox.Name
+			}
+			event.Data = data
+		case TimelineEventMessage:
+			message, ok := messages[row.RefID]
+			if !ok {
+				continue
+			}
+			actor := timelineMessageAuthor(&message)
+			event.Actor = &actor
+			event.Data = map[string]any{
+				"id":                message.ID,
+				"body":              message.Body,
+				"language":          message.Language,
+				"is_system_message": message.IsSystemMessage,
+			}
+		case TimelineEventEmailReceived, TimelineEventEmailSent:
+			email, ok := emails[row.RefID]
+			if !ok {
+				continue
+			}
+			event.Data = map[string]any{
+				"subject":           email.Subject,
+				"from_email":        email.FromEmail,
+				"from_name":         email.FromName,
+				"to_email":          email.ToEmail,
+				"message_id":        email.MessageID,
+				"in_reply_to":       email.InReplyTo,
+				"message_record_id": email.MessageRecordID,
+			}
+		case TimelineEventStatusChange, TimelineEventAttributeChange, TimelineEventClientMerged:
+			entry, ok := logs[row.RefID]
+			if !ok {
+				continue
+			}
+			if entry.User != nil {
+				event.Actor = &AuthorInfo{
+					ID:        entry.User.UserID.String(),
+					Name:      entry.User.DisplayName,
+					Type:      timelineUserType(entry.User),
+					AvatarURL: entry.User.Avatar,
+					Initials:  getInitials(entry.User.DisplayName),
+				}
+			}
+			event.Data = map[string]any{
+				"old_values": nonEmptyJSON(entry.OldValues),
+				"new_values": nonEmptyJSON(entry.NewValues),
+				"metadata":   nonEmptyJSON(entry.Metadata),
+			}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// timelineMessageAuthor returns the author of a message the same way the conversation message list does
+func timelineMessageAuthor(message *models.Message) AuthorInfo {
+	switch {
+	case message.UserID != nil:
+		author := AuthorInfo{Type: "agent"}
+		if message.User != nil {
+			author.ID = message.User.UserID.String()
+			author.Name = message.User.DisplayName
+			author.Type = timelineUserType(message.User)
+			author.AvatarURL = message.User.Avatar
+		}
+		author.Initials = getInitials(author.Name)
+		return author
+	case message.ClientID != nil:
+		author := AuthorInfo{ID: message.ClientID.String(), Type: "customer"}
+		if message.Client != nil {
+			author.Name = message.Client.Name
+			author.AvatarURL = message.Client.Avatar
+		}
+		author.Initials = getInitials(author.Name)
+		return author
+	default:
+		return AuthorInfo{ID: "system", Name: "System", Type: "system", Initials: getInitials("System")}
+	}
+}
+
+func timelineUserType(user *auth.User) string {
+	if user.Type == auth.UserTypeBot {
+		return "bot"
+	}
+	return "agent"
+}
+
+func nonEmptyJSON(data datatypes.JSON) any {
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
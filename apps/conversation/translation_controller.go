@@ -63,7 +63,7 @@ func (c TranslationController) GetTranslations(req *evo.Request) interface{} {
 
 	// Fetch messages with their detected language
 	var messages []models.Message
-	if err := db.Where("id IN ?", params.MessageIDs).Find(&messages).Error; err != nil {
+	if err := db.GetContext(req).Where("id IN ?", params.MessageIDs).Find(&messages).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to fetch messages", 500, err.Error()))
 	}
 
@@ -79,12 +79,12 @@ func (c TranslationController) GetTranslations(req *evo.Request) interface{} {
 			if detectedLang != "" {
 				msg.Language = detectedLang
 				// Update the database
-				db.Model(&models.Message{}).Where("id = ?", msg.ID).Update("language", detectedLang)
+				db.GetContext(req).Model(&models.Message{}).Where("id = ?", msg.ID).Update("language", detectedLang)
 				log.Info("Detected language '%s' for message %d", detectedLang, msg.ID)
 			} else if customerLang != "" && msg.ClientID != nil {
 				// For short customer messages where detection fails, use conversation's dominant language
 				msg.Language = customerLang
-				db.Model(&models.Message{}).Where("id = ?", msg.ID).Update("language", customerLang)
+				db.GetContext(req).Model(&models.Message{}).Where("id = ?", msg.ID).Update("language", customerLang)
 				log.Info("Using fallback language '%s' for short message %d", customerLang, msg.ID)
 			}
 		}
@@ -170,13 +170,13 @@ func (c TranslationController) GetOutgoingTranslations(req *evo.Request) interfa
 
 	// Fetch existing outgoing translations (these store the original content)
 	var existingTranslations []models.ConversationMessageTranslation
-	db.Where("conversation_id = ? AND message_id IN ? AND type = ?",
+	db.GetContext(req).Where("conversation_id = ? AND message_id IN ? AND type = ?",
 		conversationID, params.MessageIDs, models.TranslationTypeOutgoing).
 		Find(&existingTranslations)
 
 	// Fetch messages to get the translated content (what customer sees in message.body)
 	var messages []models.Message
-	db.Where("id IN ?", params.MessageIDs).Find(&messages)
+	db.GetContext(req).Where("id IN ?", params.MessageIDs).Find(&messages)
 	messageMap := make(map[uint]models.Message)
 	for _, msg := range messages {
 		messageMap[msg.ID] = msg
@@ -253,7 +253,7 @@ func (c TranslationController) TranslateOutgoing(req *evo.Request) interface{} {
 
 	// Get conversation with client info
 	var conversation models.Conversation
-	if err := db.Preload("Client").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+	if err := db.GetContext(req).Preload("Client").Where("id = ?", conversationID).First(&conversation).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, "No conversation exists with the specified ID"))
 	}
 
@@ -373,7 +373,7 @@ func (c TranslationController) GetLanguageInfo(req *evo.Request) interface{} {
 
 	// Get conversation
 	var conversation models.Conversation
-	if err := db.Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+	if err := db.GetContext(req).Where("id = ?", conversationID).First(&conversation).Error; err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeNotFound, "Conversation not found", 404, "No conversation exists with the specified ID"))
 	}
 
@@ -388,7 +388,7 @@ func (c TranslationController) GetLanguageInfo(req *evo.Request) interface{} {
 
 	// Count messages that would need translation
 	var messageCountNeedingTranslation int64
-	db.Model(&models.Message{}).
+	db.GetContext(req).Model(&models.Message{}).
 		Where("conversation_id = ? AND language IS NOT NULL AND language != '' AND language != ? AND is_system_message = false", conversationID, agentLang).
 		Count(&messageCountNeedingTranslation)
 
@@ -3,6 +3,7 @@ package conversation
 import (
 	"encoding/json"
 
+	"github.com/iesreza/homa-backend/apps/models"
 	"gorm.io/datatypes"
 )
 
@@ -55,16 +56,31 @@ type ExternalIDInfo struct {
 
 // CustomerInfo represents customer information
 type CustomerInfo struct {
-	ID          string                 `json:"id"`
-	Name        string                 `json:"name"`
-	Email       string                 `json:"email"`
-	Phone       *string                `json:"phone"`
-	AvatarURL   *string                `json:"avatar_url"`
-	Initials    string                 `json:"initials"`
-	ExternalIDs []ExternalIDInfo       `json:"external_ids"`
-	Language    *string                `json:"language"`
-	Timezone    *string                `json:"timezone"`
-	Data        map[string]interface{} `json:"data,omitempty"`
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	Email        string                 `json:"email"`
+	Phone        *string                `json:"phone"`
+	AvatarURL    *string                `json:"avatar_url"`
+	Initials     string                 `json:"initials"`
+	ExternalIDs  []ExternalIDInfo       `json:"external_ids"`
+	Language     *string                `json:"language"`
+	Timezone     *string                `json:"timezone"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+	Organization *OrganizationInfo      `json:"organization,omitempty"`
+}
+
+// OrganizationInfo represents the organization a customer belongs to
+type OrganizationInfo struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// newOrganizationInfo returns nil when the client has no organization loaded
+func newOrganizationInfo(organization *models.Organization) *OrganizationInfo {
+	if organization == nil {
+		return nil
+	}
+	return &OrganizationInfo{ID: organization.ID, Name: organization.Name}
 }
 
 // AgentInfo represents agent information
@@ -0,0 +1,88 @@
+package conversation
+
+import (
+	"errors"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/apps/redis"
+	"github.com/iesreza/homa-backend/lib/response"
+	"github.com/iesreza/homa-backend/lib/tenant"
+	"gorm.io/gorm"
+)
+
+// resolveWidgetInbox returns the inbox of the API key, or the default inbox when no key is given,
+// once the request passed the origin allowlist and rate limit of the inbox
+func resolveWidgetInbox(req *evo.Request, inboxKey *string) (*models.Inbox, *response.AppError) {
+	var inbox *models.Inbox
+	if inboxKey == nil || *inboxKey == "" {
+		var err error
+		inbox, err = models.GetDefaultInbox()
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			// Not knowing the default inbox must not skip its restrictions
+			log.Error("Failed to load the default inbox: %v", err)
+			appErr := response.NewError(response.ErrorCodeDatabaseError, "Failed to check inbox access", 500)
+			return nil, &appErr
+		}
+	} else {
+		var err error
+		inbox, err = models.GetInboxByAPIKey(*inboxKey)
+		if err != nil {
+			appErr := response.NewError(response.ErrorCodeNotFound, "Inbox not found", 404)
+			return nil, &appErr
+		}
+		if !inbox.Enabled {
+			appErr := response.NewError(response.ErrorCodeForbidden, "Inbox is disabled", 403)
+			return nil, &appErr
+		}
+	}
+	if appErr := checkWidgetAccess(req, inbox); appErr != nil {
+		return nil, appErr
+	}
+	return inbox, nil
+}
+
+// checkConversationAccess enforces the origin allowlist and rate limit of the inbox of the conversation.
+// Conversations without an inbox are not restricted; access is refused when the inbox cannot be loaded.
+func checkConversationAccess(req *evo.Request, conversation *models.Conversation) *response.AppError {
+	if conversation.InboxID == nil {
+		return nil
+	}
+	inbox, err := models.GetInboxByID(conversation.WorkspaceID, *conversation.InboxID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Without its inbox the restrictions of the conversation cannot be checked
+		appErr := response.NewError(response.ErrorCodeForbidden, "Inbox of the conversation is not available", 403)
+		return &appErr
+	}
+	if err != nil {
+		log.Error("Failed to load inbox %d of conversation %d: %v", *conversation.InboxID, conversation.ID, err)
+		appErr := response.NewError(response.ErrorCodeDatabaseError, "Failed to check conversation access", 500)
+		return &appErr
+	}
+	return checkWidgetAccess(req, inbox)
+}
+
+// checkWidgetAccess enforces the allowed origins and the rate limit of the inbox
+func checkWidgetAccess(req *evo.Request, inbox *models.Inbox) *response.AppError {
+	if inbox == nil {
+		return nil
+	}
+	if !inbox.OriginAllowed(req.Header("Origin")) {
+		appErr := response.NewError(response.ErrorCodeForbidden, "Origin is not allowed for this inbox", 403)
+		return &appErr
+	}
+	if !redis.AllowInboxRequest(inbox, getRealIP(req)) {
+		appErr := response.NewError("too_many_requests", "Too many requests. Please try again later.", 429)
+		return &appErr
+	}
+	return nil
+}
+
+// widgetWorkspace returns the workspace of the inbox
+func widgetWorkspace(inbox *models.Inbox) uint {
+	if inbox == nil {
+		return tenant.DefaultID
+	}
+	return models.OrDefaultWorkspace(inbox.WorkspaceID)
+}
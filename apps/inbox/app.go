@@ -32,6 +32,8 @@ func (App) Router() error {
 	evo.Put("/api/admin/inboxes/:id", UpdateInbox)
 	evo.Delete("/api/admin/inboxes/:id", DeleteInbox)
 	evo.Post("/api/admin/inboxes/:id/regenerate-key", RegenerateAPIKey)
+	evo.Post("/api/admin/inboxes/:id/identity-secret", RotateIdentitySecret)
+	evo.Delete("/api/admin/inboxes/:id/identity-secret", DisableIdentityVerification)
 
 	// Client endpoint for SDK to get inbox config
 	evo.Get("/api/client/inbox/:key", GetInboxByAPIKey)
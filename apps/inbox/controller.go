@@ -2,8 +2,11 @@ package inbox
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
 	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/lib/response"
 )
@@ -11,7 +14,7 @@ import (
 // ListInboxes returns all inboxes
 // GET /api/admin/inboxes
 func ListInboxes(r *evo.Request) any {
-	inboxes, err := models.GetAllInboxes()
+	inboxes, err := models.GetAllInboxes(models.RequestWorkspaceID(r))
 	if err != nil {
 		return response.InternalError(nil, "Failed to get inboxes")
 	}
@@ -26,7 +29,7 @@ func GetInbox(r *evo.Request) any {
 		return response.BadRequest(nil, "Invalid inbox ID")
 	}
 
-	inbox, err := models.GetInboxByID(id)
+	inbox, err := models.GetInboxByID(models.RequestWorkspaceID(r), id)
 	if err != nil {
 		return response.NotFound(nil, "Inbox not found")
 	}
@@ -41,6 +44,9 @@ type CreateInboxRequest struct {
 	SDKConfig           map[string]any `json:"sdk_config"`
 	ConversationTimeout int            `json:"conversation_timeout"`
 	Enabled             bool           `json:"enabled"`
+	IdentityRequired    bool           `json:"identity_required"`
+	AllowedOrigins      []string       `json:"allowed_origins"`
+	RateLimit           int            `json:"rate_limit"`
 }
 
 // CreateInbox creates a new inbox
@@ -67,15 +73,30 @@ func CreateInbox(r *evo.Request) any {
 		sdkConfig = []byte("{}")
 	}
 
+	if err := models.ValidateSDKConfig(sdkConfig); err != nil {
+		return response.BadRequest(nil, err.Error())
+	}
+
+	allowedOrigins, err := models.NormalizeAllowedOrigins(req.AllowedOrigins)
+	if err != nil {
+		return response.BadRequest(nil, err.Error())
+	}
+	if req.RateLimit < 0 {
+		return response.BadRequest(nil, "Rate limit cannot be negative")
+	}
+
 	inbox := &models.Inbox{
 		Name:                req.Name,
 		Description:         req.Description,
 		SDKConfig:           sdkConfig,
 		ConversationTimeout: req.ConversationTimeout,
 		Enabled:             req.Enabled,
+		IdentityRequired:    req.IdentityRequired,
+		AllowedOrigins:      allowedOrigins,
+		RateLimit:           req.RateLimit,
 	}
 
-	if err := models.CreateInbox(inbox); err != nil {
+	if err := models.CreateInbox(models.RequestWorkspaceID(r), inbox); err != nil {
 		return response.InternalError(nil, "Failed to create inbox")
 	}
 
@@ -89,6 +110,9 @@ type UpdateInboxRequest struct {
 	SDKConfig           map[string]any `json:"sdk_config"`
 	ConversationTimeout *int           `json:"conversation_timeout"`
 	Enabled             *bool          `json:"enabled"`
+	IdentityRequired    *bool          `json:"identity_required"`
+	AllowedOrigins      *[]string      `json:"allowed_origins"`
+	RateLimit           *int           `json:"rate_limit"`
 }
 
 // UpdateInbox updates an existing inbox
@@ -99,7 +123,7 @@ func UpdateInbox(r *evo.Request) any {
 		return response.BadRequest(nil, "Invalid inbox ID")
 	}
 
-	inbox, err := models.GetInboxByID(id)
+	inbox, err := models.GetInboxByID(models.RequestWorkspaceID(r), id)
 	if err != nil {
 		return response.NotFound(nil, "Inbox not found")
 	}
@@ -120,6 +144,9 @@ func UpdateInbox(r *evo.Request) any {
 		if err != nil {
 			return response.BadRequest(nil, "Invalid SDK config")
 		}
+		if err := models.ValidateSDKConfig(sdkConfig); err != nil {
+			return response.BadRequest(nil, err.Error())
+		}
 		inbox.SDKConfig = sdkConfig
 	}
 	if req.ConversationTimeout != nil {
@@ -128,6 +155,22 @@ func UpdateInbox(r *evo.Request) any {
 	if req.Enabled != nil {
 		inbox.Enabled = *req.Enabled
 	}
+	if req.IdentityRequired != nil {
+		inbox.IdentityRequired = *req.IdentityRequired
+	}
+	if req.AllowedOrigins != nil {
+		allowedOrigins, err := models.NormalizeAllowedOrigins(*req.AllowedOrigins)
+		if err != nil {
+			return response.BadRequest(nil, err.Error())
+		}
+		inbox.AllowedOrigins = allowedOrigins
+	}
+	if req.RateLimit != nil {
+		if *req.RateLimit < 0 {
+			return response.BadRequest(nil, "Rate limit cannot be negative")
+		}
+		inbox.RateLimit = *req.RateLimit
+	}
 
 	if err := models.UpdateInbox(inbox); err != nil {
 		return response.InternalError(nil, "Failed to update inbox")
@@ -145,7 +188,7 @@ func DeleteInbox(r *evo.Request) any {
 	}
 
 	// Check if inbox exists
-	_, err := models.GetInboxByID(id)
+	_, err := models.GetInboxByID(models.RequestWorkspaceID(r), id)
 	if err != nil {
 		return response.NotFound(nil, "Inbox not found")
 	}
@@ -153,27 +196,32 @@ func DeleteInbox(r *evo.Request) any {
 	// TODO: Check if inbox has conversations and handle accordingly
 	// For now, we'll allow deletion
 
-	if err := models.DeleteInbox(id); err != nil {
+	if err := models.DeleteInbox(models.RequestWorkspaceID(r), id); err != nil {
 		return response.InternalError(nil, "Failed to delete inbox")
 	}
 
 	return response.OK(map[string]string{"message": "Inbox deleted successfully"})
 }
 
-// RegenerateAPIKey generates a new API key for an inbox
-// POST /api/admin/inboxes/:id/regenerate-key
+// RegenerateAPIKey generates a new API key for an inbox. With grace_period (hours) the old key
+// keeps working for that long, so deployed widgets can be updated.
+// POST /api/admin/inboxes/:id/regenerate-key?grace_period=24
 func RegenerateAPIKey(r *evo.Request) any {
 	id := r.Param("id").Uint()
 	if id == 0 {
 		return response.BadRequest(nil, "Invalid inbox ID")
 	}
 
-	inbox, err := models.GetInboxByID(id)
+	inbox, err := models.GetInboxByID(models.RequestWorkspaceID(r), id)
 	if err != nil {
 		return response.NotFound(nil, "Inbox not found")
 	}
 
-	inbox.APIKey = models.GenerateInboxAPIKey()
+	gracePeriod := time.Duration(r.Query("grace_period").Int()) * time.Hour
+	if gracePeriod < 0 || gracePeriod > models.MaxAPIKeyGracePeriod {
+		return response.BadRequest(nil, "Grace period must be between 0 and 720 hours")
+	}
+	inbox.RotateAPIKey(gracePeriod)
 
 	if err := models.UpdateInbox(inbox); err != nil {
 		return response.InternalError(nil, "Failed to regenerate API key")
@@ -182,6 +230,55 @@ func RegenerateAPIKey(r *evo.Request) any {
 	return response.OK(inbox)
 }
 
+// RotateIdentitySecret generates a new identity secret for an inbox, enabling identity verification.
+// The secret is only returned by this endpoint; identities signed with the previous secret stop verifying.
+// POST /api/admin/inboxes/:id/identity-secret
+func RotateIdentitySecret(r *evo.Request) any {
+	id := r.Param("id").Uint()
+	if id == 0 {
+		return response.BadRequest(nil, "Invalid inbox ID")
+	}
+
+	inbox, err := models.GetInboxByID(models.RequestWorkspaceID(r), id)
+	if err != nil {
+		return response.NotFound(nil, "Inbox not found")
+	}
+
+	inbox.IdentitySecret = models.GenerateIdentitySecret()
+
+	if err := models.UpdateInbox(inbox); err != nil {
+		return response.InternalError(nil, "Failed to rotate identity secret")
+	}
+
+	return response.OK(map[string]any{
+		"inbox":           inbox,
+		"identity_secret": inbox.IdentitySecret,
+	})
+}
+
+// DisableIdentityVerification removes the identity secret of an inbox
+// DELETE /api/admin/inboxes/:id/identity-secret
+func DisableIdentityVerification(r *evo.Request) any {
+	id := r.Param("id").Uint()
+	if id == 0 {
+		return response.BadRequest(nil, "Invalid inbox ID")
+	}
+
+	inbox, err := models.GetInboxByID(models.RequestWorkspaceID(r), id)
+	if err != nil {
+		return response.NotFound(nil, "Inbox not found")
+	}
+
+	inbox.IdentitySecret = ""
+	inbox.IdentityRequired = false
+
+	if err := models.UpdateInbox(inbox); err != nil {
+		return response.InternalError(nil, "Failed to disable identity verification")
+	}
+
+	return response.OK(inbox)
+}
+
 // GetInboxByAPIKey returns an inbox by its API key (for SDK validation)
 // GET /api/client/inbox/:key
 func GetInboxByAPIKey(r *evo.Request) any {
@@ -198,11 +295,81 @@ func GetInboxByAPIKey(r *evo.Request) any {
 	if !inbox.Enabled {
 		return response.Forbidden(nil, "Inbox is disabled")
 	}
+	if !inbox.OriginAllowed(r.Header("Origin")) {
+		return response.Forbidden(nil, "Origin is not allowed for this inbox")
+	}
 
 	// Return only public info for client SDK
-	return response.OK(map[string]any{
+	data := map[string]any{
 		"id":         inbox.ID,
 		"name":       inbox.Name,
 		"sdk_config": inbox.SDKConfig,
-	})
+		// Tells the widget to send the signed identity of logged-in visitors
+		"identity_verification": inbox.IdentityVerificationEnabled(),
+	}
+	if form := buildPreChatFormDefinition(inbox); form != nil {
+		data["pre_chat_form"] = form
+	}
+	return response.OK(data)
+}
+
+// PreChatFormFieldDefinition is a pre-chat form field resolved against its custom attribute,
+// carrying everything the widget needs to render and validate the input
+type PreChatFormFieldDefinition struct {
+	models.PreChatFormField
+	DataType    string   `json:"data_type"`
+	Description *string  `json:"description,omitempty"`
+	Validation  *string  `json:"validation,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// PreChatFormDefinition is the public pre-chat form returned to the widget
+type PreChatFormDefinition struct {
+	Title        string                       `json:"title,omitempty"`
+	Description  string                       `json:"description,omitempty"`
+	RequireName  bool                         `json:"require_name"`
+	RequireEmail bool                         `json:"require_email"`
+	Fields       []PreChatFormFieldDefinition `json:"fields"`
+	Consent      *models.PreChatFormConsent   `json:"consent,omitempty"`
+}
+
+// buildPreChatFormDefinition resolves the inbox pre-chat form fields against the custom attribute
+// definitions. Returns nil when the inbox has no enabled form.
+func buildPreChatFormDefinition(inbox *models.Inbox) *PreChatFormDefinition {
+	form, err := inbox.GetPreChatForm()
+	if err != nil || form == nil || !form.Enabled {
+		return nil
+	}
+
+	definition := &PreChatFormDefinition{
+		Title:        form.Title,
+		Description:  form.Description,
+		RequireName:  form.RequireName,
+		RequireEmail: form.RequireEmail,
+		Fields:       make([]PreChatFormFieldDefinition, 0, len(form.Fields)),
+	}
+	if form.Consent != nil && form.Consent.Enabled {
+		definition.Consent = form.Consent
+	}
+
+	for _, field := range form.Fields {
+		var attr models.CustomAttribute
+		if err := db.Where("scope = ? AND name = ?", field.Scope, field.Name).First(&attr).Error; err != nil {
+			// Attribute was deleted after the form was saved; skip the field
+			log.Warning("[inbox] Pre-chat form of inbox %d references missing attribute %s.%s", inbox.ID, field.Scope, field.Name)
+			continue
+		}
+		if field.Label == "" {
+			field.Label = attr.Title
+		}
+		definition.Fields = append(definition.Fields, PreChatFormFieldDefinition{
+			PreChatFormField: field,
+			DataType:         attr.DataType,
+			Description:      attr.Description,
+			Validation:       attr.Validation,
+			Options:          attr.OptionValues(),
+		})
+	}
+
+	return definition
 }
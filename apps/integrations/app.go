@@ -13,6 +13,7 @@ func (a App) Register() error {
 	models.SendTelegramMessage = SendTelegramMessage
 	models.SendWhatsAppMessage = SendWhatsAppMessage
 	models.SendSlackMessage = SendSlackMessage
+	models.SendWhatsAppTemplate = SendWhatsAppTemplate
 
 	// Initialize email reply function
 	email.RegisterSendEmailReply()
@@ -34,6 +35,12 @@ func (a App) Router() error {
 	evo.Get("/api/integrations/webhooks/whatsapp", controller.WhatsAppWebhook)
 	evo.Post("/api/integrations/webhooks/whatsapp", controller.WhatsAppWebhook)
 
+	// Webhooks of the integrations of a workspace; the routes above deliver to the default workspace
+	evo.Post("/api/integrations/webhooks/slack/:workspace_id", controller.SlackWebhook)
+	evo.Post("/api/integrations/webhooks/telegram/:workspace_id", controller.TelegramWebhook)
+	evo.Get("/api/integrations/webhooks/whatsapp/:workspace_id", controller.WhatsAppWebhook)
+	evo.Post("/api/integrations/webhooks/whatsapp/:workspace_id", controller.WhatsAppWebhook)
+
 	return nil
 }
 
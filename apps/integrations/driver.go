@@ -3,6 +3,7 @@ package integrations
 
 import (
 	"encoding/json"
+	"strconv"
 	"strings"
 
 	"github.com/iesreza/homa-backend/apps/integrations/drivers"
@@ -13,6 +14,8 @@ import (
 	"github.com/iesreza/homa-backend/apps/integrations/drivers/telegram"
 	"github.com/iesreza/homa-backend/apps/integrations/drivers/whatsapp"
 	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/crypto"
+	"github.com/iesreza/homa-backend/lib/tenant"
 )
 
 func init() {
@@ -74,14 +77,24 @@ func GetMaskedConfig(integrationType string, configJSON string) map[string]inter
 	return driver.GetMaskedConfig(configJSON)
 }
 
-// OnSave is called after an integration is saved. It performs any necessary
+// OnSave is called after an integration of the workspace is saved. It performs any necessary
 // post-save operations like registering webhooks.
-func OnSave(integrationType string, configJSON string, status string, webhookBaseURL string) OnSaveResult {
+func OnSave(integrationType string, configJSON string, status string, webhookBaseURL string, workspaceID uint) OnSaveResult {
 	driver, ok := drivers.Get(integrationType)
 	if !ok {
 		return OnSaveResult{Success: true, Message: "No post-save actions required"}
 	}
-	return driver.OnSave(configJSON, status, webhookBaseURL)
+	return driver.OnSave(configJSON, status, WebhookURL(webhookBaseURL, integrationType, workspaceID))
+}
+
+// WebhookURL returns the webhook endpoint of an integration of the workspace. The integrations of the
+// default workspace keep the endpoints registered before workspaces existed.
+func WebhookURL(webhookBaseURL string, integrationType string, workspaceID uint) string {
+	webhookURL := webhookBaseURL + "/api/integrations/webhooks/" + integrationType
+	if workspaceID != 0 && workspaceID != tenant.DefaultID {
+		webhookURL += "/" + strconv.FormatUint(uint64(workspaceID), 10)
+	}
+	return webhookURL
 }
 
 // MergeConfigWithExisting merges new config with existing config,
@@ -116,16 +129,15 @@ func MergeConfigWithExisting(existingConfigJSON string, newConfig map[string]int
 	return result
 }
 
-// EncryptConfig encrypts configuration JSON (placeholder - implement proper encryption).
-func EncryptConfig(config string) string {
-	// TODO: Implement proper encryption using AES-256-GCM
-	return config
+// EncryptConfig encrypts configuration JSON with the active encryption key.
+// Integration configs are encrypted when saved, so this is only needed for configs stored elsewhere.
+func EncryptConfig(config string) (string, error) {
+	return crypto.EncryptValue(config)
 }
 
-// DecryptConfig decrypts configuration JSON (placeholder - implement proper decryption).
-func DecryptConfig(encryptedConfig string) string {
-	// TODO: Implement proper decryption using AES-256-GCM
-	return encryptedConfig
+// DecryptConfig decrypts configuration JSON. Plaintext configs are returned unchanged.
+func DecryptConfig(encryptedConfig string) (string, error) {
+	return crypto.DecryptValue(encryptedConfig)
 }
 
 // GetIntegrationTypes returns all available integration types.
@@ -46,8 +46,8 @@ type Driver interface {
 	GetMaskedConfig(configJSON string) map[string]interface{}
 
 	// OnSave is called after the integration is saved.
-	// webhookBaseURL is provided for integrations that need to register webhooks.
-	OnSave(configJSON string, status string, webhookBaseURL string) OnSaveResult
+	// webhookURL is the URL of the integration's webhook endpoint, for integrations that need to register webhooks.
+	OnSave(configJSON string, status string, webhookURL string) OnSaveResult
 }
 
 // SensitiveFields contains field names that should be masked in responses.
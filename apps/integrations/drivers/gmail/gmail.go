@@ -157,7 +157,7 @@ func (d *Driver) GetMaskedConfig(configJSON string) map[string]interface{} {
 }
 
 // OnSave is called after the integration is saved.
-func (d *Driver) OnSave(configJSON string, status string, webhookBaseURL string) drivers.OnSaveResult {
+func (d *Driver) OnSave(configJSON string, status string, webhookURL string) drivers.OnSaveResult {
 	return drivers.OnSaveResult{
 		Success: true,
 		Message: `Gmail integration saved successfully.
@@ -124,9 +124,7 @@ func (d *Driver) GetMaskedConfig(configJSON string) map[string]interface{} {
 
 // OnSave is called after the integration is saved.
 // Slack uses event subscriptions configured in the Slack app settings, so no auto-registration is needed.
-func (d *Driver) OnSave(configJSON string, status string, webhookBaseURL string) drivers.OnSaveResult {
-	webhookURL := webhookBaseURL + "/api/integrations/webhooks/slack"
-
+func (d *Driver) OnSave(configJSON string, status string, webhookURL string) drivers.OnSaveResult {
 	instructions := `Slack integration saved successfully!
 
 To complete setup, configure event subscriptions in your Slack App:
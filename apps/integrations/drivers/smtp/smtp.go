@@ -252,7 +252,7 @@ func (d *Driver) GetMaskedConfig(configJSON string) map[string]interface{} {
 }
 
 // OnSave is called after the integration is saved.
-func (d *Driver) OnSave(configJSON string, status string, webhookBaseURL string) drivers.OnSaveResult {
+func (d *Driver) OnSave(configJSON string, status string, webhookURL string) drivers.OnSaveResult {
 	var config Config
 	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
 		return drivers.OnSaveResult{Success: false, Message: "Invalid configuration"}
@@ -163,7 +163,7 @@ func (d *Driver) GetMaskedConfig(configJSON string) map[string]interface{} {
 
 // OnSave is called after the integration is saved.
 // It automatically registers the webhook with Telegram.
-func (d *Driver) OnSave(configJSON string, status string, webhookBaseURL string) drivers.OnSaveResult {
+func (d *Driver) OnSave(configJSON string, status string, webhookURL string) drivers.OnSaveResult {
 	if status != "enabled" {
 		return drivers.OnSaveResult{Success: true, Message: "Integration disabled, skipping webhook registration"}
 	}
@@ -177,9 +177,6 @@ func (d *Driver) OnSave(configJSON string, status string, webhookBaseURL string)
 		return drivers.OnSaveResult{Success: false, Message: "Bot token is required"}
 	}
 
-	// Build webhook URL
-	webhookURL := fmt.Sprintf("%s/api/integrations/webhooks/telegram", webhookBaseURL)
-
 	// Register webhook with Telegram
 	setWebhookURL := fmt.Sprintf("https://api.telegram.org/bot%s/setWebhook", config.BotToken)
 
@@ -125,9 +125,7 @@ func (d *Driver) GetMaskedConfig(configJSON string) map[string]interface{} {
 
 // OnSave is called after the integration is saved.
 // WhatsApp webhooks are configured in the Meta Developer Console.
-func (d *Driver) OnSave(configJSON string, status string, webhookBaseURL string) drivers.OnSaveResult {
-	webhookURL := webhookBaseURL + "/api/integrations/webhooks/whatsapp"
-
+func (d *Driver) OnSave(configJSON string, status string, webhookURL string) drivers.OnSaveResult {
 	instructions := `WhatsApp integration saved successfully!
 
 To complete setup, configure the webhook in Meta Developer Console:
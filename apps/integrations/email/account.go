@@ -0,0 +1,21 @@
+package email
+
+import (
+	"github.com/iesreza/homa-backend/apps/models"
+)
+
+// SendAccountEmail sends an invitation or password reset email through the default email integration
+func SendAccountEmail(to, subject, body, htmlBody string) error {
+	config, _, err := getEmailIntegrationForConversation(models.Conversation{})
+	if err != nil {
+		return err
+	}
+
+	_, err = NewSMTPClient(*config).Send(Email{
+		To:       []string{to},
+		Subject:  subject,
+		Body:     body,
+		HTMLBody: htmlBody,
+	})
+	return err
+}
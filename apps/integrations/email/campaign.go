@@ -0,0 +1,34 @@
+package email
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/iesreza/homa-backend/apps/models"
+)
+
+// SendCampaignEmail sends a campaign email through an email integration of the campaign's workspace.
+// The unsubscribe link is appended to the body and advertised with List-Unsubscribe headers
+// so mail clients can offer one-click unsubscribe (RFC 8058).
+func SendCampaignEmail(workspaceID uint, to, subject, body, unsubscribeURL string) (string, error) {
+	config, _, err := getEmailIntegrationForConversation(models.Conversation{WorkspaceID: models.OrDefaultWorkspace(workspaceID)})
+	if err != nil {
+		return "", err
+	}
+
+	footer := "\n\n--\nTo stop receiving these messages, unsubscribe here: " + unsubscribeURL
+	htmlBody := "<div>" + strings.ReplaceAll(html.EscapeString(body), "\n", "<br>") + "</div>" +
+		fmt.Sprintf(`<hr><p style="font-size:12px;color:#888">To stop receiving these messages, <a href="%s">unsubscribe</a>.</p>`, html.EscapeString(unsubscribeURL))
+
+	return NewSMTPClient(*config).Send(Email{
+		To:       []string{to},
+		Subject:  subject,
+		Body:     body + footer,
+		HTMLBody: htmlBody,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+}
@@ -258,6 +258,15 @@ func (c *IMAPClient) parseMessage(msg *imap.Message, section *imap.BodySectionNa
 		email.References = strings.Fields(refs)
 	}
 
+	// Keep the first value of every header for spam scoring
+	email.Headers = map[string]string{}
+	fields := entity.Header.Fields()
+	for fields.Next() {
+		if _, exists := email.Headers[fields.Key()]; !exists {
+			email.Headers[fields.Key()] = fields.Value()
+		}
+	}
+
 	// Parse multipart or simple body
 	if mr := entity.MultipartReader(); mr != nil {
 		// Multipart message
@@ -41,13 +41,20 @@ func SendEmailReply(conversationID uint, messageID uint, body string, user *auth
 	}
 
 	// Get the most recent inbound email for this conversation to get reply-to info
-	var lastInboundEmail models.EmailMessage
+	var lastEmail models.EmailMessage
 	err := db.Where("conversation_id = ?", conversationID).
 		Where("direction = ?", "inbound").
 		Order("received_at DESC").
-		First(&lastInboundEmail).Error
+		First(&lastEmail).Error
 	if err != nil {
-		log.Warning("[email] No previous inbound email found for conversation %d", conversationID)
+		// Agent-initiated conversations have no inbound email yet; thread follow-ups onto our last email
+		err = db.Where("conversation_id = ?", conversationID).
+			Where("direction = ?", "outbound").
+			Order("received_at DESC").
+			First(&lastEmail).Error
+		if err != nil {
+			log.Info("[email] No previous email found for conversation %d, starting a new thread", conversationID)
+		}
 	}
 
 	// Get the email integration to use
@@ -56,8 +63,11 @@ func SendEmailReply(conversationID uint, messageID uint, body string, user *auth
 		return fmt.Errorf("failed to get email integration: %w", err)
 	}
 
-	// Build the email subject (Re: original subject)
+	// Build the email subject (Re: original subject), or use the title as is when starting a new thread
 	subject := GenerateReplySubject(conversation.Title)
+	if lastEmail.MessageID == "" {
+		subject = CleanSubject(conversation.Title)
+	}
 
 	// Build template data
 	displayName := "Support"
@@ -108,13 +118,13 @@ func SendEmailReply(conversationID uint, messageID uint, body string, user *auth
 	}
 
 	// Set reply headers if we have a previous email
-	if lastInboundEmail.MessageID != "" {
-		email.InReplyTo = lastInboundEmail.MessageID
+	if lastEmail.MessageID != "" {
+		email.InReplyTo = lastEmail.MessageID
 		// Add all referenced message IDs
-		if lastInboundEmail.References != "" {
-			email.References = strings.Split(lastInboundEmail.References, " ")
+		if lastEmail.References != "" {
+			email.References = strings.Split(lastEmail.References, " ")
 		}
-		email.References = append(email.References, lastInboundEmail.MessageID)
+		email.References = append(email.References, lastEmail.MessageID)
 	}
 
 	// Send the email using SMTP client
@@ -168,9 +178,9 @@ func getEmailIntegrationForConversation(conversation models.Conversation) (*Conf
 		}
 	}
 
-	// Fallback: find any enabled email integration
+	// Fallback: find any enabled email integration of the conversation's workspace
 	var integration models.Integration
-	err := db.Where("status = ?", models.IntegrationStatusEnabled).
+	err := models.WorkspaceDB(conversation.WorkspaceID).Where("status = ?", models.IntegrationStatusEnabled).
 		Where("type IN ?", []string{models.IntegrationTypeSMTP, models.IntegrationTypeGmail, models.IntegrationTypeOutlook}).
 		First(&integration).Error
 
@@ -412,6 +422,8 @@ func ParseIntegrationConfig(integrationType, configJSON string) (*Config, error)
 // This should be called during application initialization
 func RegisterSendEmailReply() {
 	models.SendEmailReply = SendEmailReply
+	models.SendCampaignEmail = SendCampaignEmail
+	auth.AccountEmailSender = SendAccountEmail
 	log.Info("[email] Registered SendEmailReply handler")
 }
 
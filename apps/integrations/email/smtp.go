@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/smtp"
 	"net/textproto"
+	"sort"
 	"strings"
 	"time"
 )
@@ -210,6 +211,17 @@ func (c *SMTPClient) buildMessage(email Email) ([]byte, error) {
 		fmt.Fprintf(&buf, "References: %s\r\n", strings.Join(email.References, " "))
 	}
 
+	// Additional headers such as List-Unsubscribe
+	headerNames := make([]string, 0, len(email.Headers))
+	for name := range email.Headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(email.Headers[name])
+		fmt.Fprintf(&buf, "%s: %s\r\n", textproto.CanonicalMIMEHeaderKey(name), value)
+	}
+
 	// MIME headers
 	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
 
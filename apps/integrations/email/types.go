@@ -74,6 +74,7 @@ type Email struct {
 	HTMLBody    string    `json:"html_body"`    // HTML body
 	Date        time.Time `json:"date"`
 	Attachments []Attachment `json:"attachments,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"` // First value of each header; additional headers when sending
 	UID         uint32    `json:"uid"`          // IMAP UID
 	SeqNum      uint32    `json:"seq_num"`      // IMAP sequence number
 }
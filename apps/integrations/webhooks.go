@@ -17,11 +17,12 @@ import (
 	"time"
 
 	"github.com/getevo/evo/v2"
-	"github.com/getevo/evo/v2/lib/db"
 	"github.com/getevo/evo/v2/lib/log"
 	"github.com/google/uuid"
 	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/apps/storage"
+	"github.com/iesreza/homa-backend/lib/tenant"
+	"gorm.io/gorm"
 )
 
 // WebhookController handles incoming webhooks from integrations
@@ -33,6 +34,21 @@ const DefaultConversationTimeoutHours = 12
 // Setting key for conversation timeout
 const SettingConversationTimeoutHours = "workflow.conversation_timeout_hours"
 
+// webhookWorkspace returns the workspace a webhook is delivered to: the workspace in the URL, or the
+// default workspace for the URLs registered before workspaces existed. It returns 0 for unknown or
+// suspended workspaces.
+func webhookWorkspace(request *evo.Request) uint {
+	workspaceID := uint(request.Param("workspace_id").Int())
+	if workspaceID == 0 {
+		workspaceID = tenant.DefaultID
+	}
+	workspace, err := models.GetWorkspace(workspaceID)
+	if err != nil || workspace.Status != models.WorkspaceStatusActive {
+		return 0
+	}
+	return workspaceID
+}
+
 // =============================================================================
 // Slack Webhook Handler
 // =============================================================================
@@ -40,8 +56,9 @@ const SettingConversationTimeoutHours = "workflow.conversation_timeout_hours"
 // SlackWebhook handles incoming Slack webhook events
 func (c WebhookController) SlackWebhook(request *evo.Request) any {
 	// Get Slack integration config
-	integration, err := models.GetIntegration(models.IntegrationTypeSlack)
-	if err != nil || integration.Status != models.IntegrationStatusEnabled {
+	workspaceID := webhookWorkspace(request)
+	integration, err := models.GetIntegration(workspaceID, models.IntegrationTypeSlack)
+	if workspaceID == 0 || err != nil || integration.Status != models.IntegrationStatusEnabled {
 		log.Warning("Slack webhook received but integration is not enabled")
 		return map[string]string{"error": "Integration not enabled"}
 	}
@@ -104,6 +121,7 @@ func (c WebhookController) SlackWebhook(request *evo.Request) any {
 			if userID != "" && text != "" {
 				// Process the message
 				go processIncomingMessage(
+					workspaceID,
 					models.ExternalIDTypeSlack,
 					userID,
 					text,
@@ -134,8 +152,9 @@ func verifySlackSignature(signingSecret, timestamp, body, signature string) bool
 // TelegramWebhook handles incoming Telegram webhook updates
 func (c WebhookController) TelegramWebhook(request *evo.Request) any {
 	// Get Telegram integration config
-	integration, err := models.GetIntegration(models.IntegrationTypeTelegram)
-	if err != nil || integration.Status != models.IntegrationStatusEnabled {
+	workspaceID := webhookWorkspace(request)
+	integration, err := models.GetIntegration(workspaceID, models.IntegrationTypeTelegram)
+	if workspaceID == 0 || err != nil || integration.Status != models.IntegrationStatusEnabled {
 		log.Warning("Telegram webhook received but integration is not enabled")
 		return map[string]string{"ok": "true"}
 	}
@@ -199,6 +218,7 @@ func (c WebhookController) TelegramWebhook(request *evo.Request) any {
 
 		// Process the message
 		go processIncomingMessage(
+			workspaceID,
 			models.ExternalIDTypeTelegram,
 			externalID,
 			msg.Text,
@@ -244,7 +264,7 @@ type TelegramChat struct {
 
 // getTelegramUserProfilePhotoURL fetches the user's profile photo from Telegram API,
 // downloads it, stores it in S3, and returns the S3 key (not the expiring Telegram URL)
-func getTelegramUserProfilePhotoURL(botToken string, userID int64) string {
+func getTelegramUserProfilePhotoURL(workspaceID uint, botToken string, userID int64) string {
 	// First, get user profile photos
 	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/getUserProfilePhotos?user_id=%d&limit=1", botToken, userID)
 
@@ -362,7 +382,7 @@ func getTelegramUserProfilePhotoURL(botToken string, userID int64) string {
 	// Upload to S3 if enabled
 	if storage.IsEnabled() {
 		// Use fileUniqueID for stable filename (doesn't change even if photo is re-uploaded)
-		s3Key := fmt.Sprintf("avatars/telegram/%d_%s%s", userID, fileUniqueID, ext)
+		s3Key := tenant.Prefix(workspaceID, fmt.Sprintf("avatars/telegram/%d_%s%s", userID, fileUniqueID, ext))
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -389,8 +409,9 @@ func getTelegramUserProfilePhotoURL(botToken string, userID int64) string {
 // WhatsAppWebhook handles incoming WhatsApp webhook events
 func (c WebhookController) WhatsAppWebhook(request *evo.Request) any {
 	// Get WhatsApp integration config
-	integration, err := models.GetIntegration(models.IntegrationTypeWhatsApp)
-	if err != nil || integration.Status != models.IntegrationStatusEnabled {
+	workspaceID := webhookWorkspace(request)
+	integration, err := models.GetIntegration(workspaceID, models.IntegrationTypeWhatsApp)
+	if workspaceID == 0 || err != nil || integration.Status != models.IntegrationStatusEnabled {
 		log.Warning("WhatsApp webhook received but integration is not enabled")
 		return map[string]string{"error": "Integration not enabled"}
 	}
@@ -462,6 +483,7 @@ func (c WebhookController) WhatsAppWebhook(request *evo.Request) any {
 
 				// Process the message
 				go processIncomingMessage(
+					workspaceID,
 					models.ExternalIDTypeWhatsapp,
 					msg.From,
 					msg.Text.Body,
@@ -526,8 +548,9 @@ type WhatsAppMessage struct {
 // =============================================================================
 
 // processIncomingMessage handles the common logic for processing incoming messages
-// from any integration (Slack, Telegram, WhatsApp)
+// from any integration (Slack, Telegram, WhatsApp) in the workspace of the integration
 func processIncomingMessage(
+	workspaceID uint,
 	externalIDType string,
 	externalIDValue string,
 	messageText string,
@@ -537,46 +560,101 @@ func processIncomingMessage(
 ) {
 	log.Info("Processing incoming message from %s: user=%s, message=%s",
 		externalIDType, externalIDValue, truncateString(messageText, 50))
+	tx := models.WorkspaceDB(workspaceID)
 
-	// 1. Upsert client
-	client, err := upsertClient(externalIDType, externalIDValue, userInfo)
-	if err != nil {
-		log.Error("Failed to upsert client:", err)
+	// 1. Check the sender against the blocklist
+	sender := models.InboundSender{ExternalIDType: externalIDType, ExternalIDValue: externalIDValue}
+	if externalIDType == models.ExternalIDTypeWhatsapp {
+		sender.Phone = externalIDValue
+	}
+	verdict := models.CheckInboundSender(&sender)
+	if verdict != nil && verdict.Action == models.BlocklistActionDrop {
+		log.Info("Dropped message from blocked %s sender %s", externalIDType, externalIDValue)
+		models.RecordSpamEvent(externalIDType, sender, verdict, messageText)
 		return
 	}
 
-	// 2. Find or create conversation
-	conversation, err := findOrCreateConversation(client, externalIDType, channelContext)
+	// 2. Upsert client
+	client, err := upsertClient(tx, workspaceID, externalIDType, externalIDValue, userInfo)
 	if err != nil {
-		log.Error("Failed to find/create conversation:", err)
+		log.Error("Failed to upsert client:", err)
 		return
 	}
 
-	// 3. Create message
+	// 3. Find or create conversation; spam keeps going to the sender's latest spam conversation
+	var conversation *models.Conversation
+	if verdict != nil {
+		conversation = findSpamConversation(tx, workspaceID, client, externalIDType)
+	}
+	if conversation == nil {
+		conversation, err = findOrCreateConversation(tx, workspaceID, client, externalIDType, channelContext)
+		if err != nil {
+			log.Error("Failed to find/create conversation:", err)
+			return
+		}
+		if verdict != nil {
+			if err := models.MarkConversationSpam(conversation.ID, externalIDType, sender, verdict, messageText); err != nil {
+				log.Error("Failed to mark conversation %d as spam: %v", conversation.ID, err)
+			}
+			conversation.Status = models.ConversationStatusSpam
+		}
+	}
+
+	// 4. Create message
 	message := models.Message{
 		ConversationID: conversation.ID,
 		ClientID:       &client.ID,
 		Body:           messageText,
 	}
 
-	if err := db.Create(&message).Error; err != nil {
+	if err := tx.Create(&message).Error; err != nil {
 		log.Error("Failed to create message:", err)
 		return
 	}
 
-	// 4. Update conversation status to "wait_for_agent" when customer sends a message
-	if err := db.Model(&conversation).Update("status", models.ConversationStatusWaitForAgent).Error; err != nil {
-		log.Warning("Failed to update conversation status:", err)
+	// 5. Update conversation status to "wait_for_agent" when customer sends a message
+	if conversation.Status != models.ConversationStatusSpam {
+		if err := tx.Model(conversation).Update("status", models.ConversationStatusWaitForAgent).Error; err != nil {
+			log.Warning("Failed to update conversation status:", err)
+		}
+
+		// 6. Track campaign replies and keyword opt-outs
+		models.RecordCampaignReply(client.ID, externalIDType, conversation.ID)
+		if models.IsOptOutKeyword(messageText) {
+			if err := models.OptOutClient(client.ID, externalIDType, models.OptOutSourceKeyword, nil); err != nil {
+				log.Warning("Failed to opt out client %s: %v", client.ID, err)
+			} else {
+				models.CreateActionMessage(conversation.ID, nil, "", "Client opted out of campaign messages")
+			}
+		}
 	}
 
 	log.Info("Message created successfully: conversation=%d, message=%d", conversation.ID, message.ID)
 }
 
-// upsertClient finds or creates a client based on external ID
-func upsertClient(externalIDType, externalIDValue string, userInfo map[string]interface{}) (*models.Client, error) {
+// findSpamConversation returns the client's most recent spam conversation on the channel within the
+// conversation timeout, or nil if there is none
+func findSpamConversation(tx *gorm.DB, workspaceID uint, client *models.Client, channelType string) *models.Conversation {
+	cutoffTime := time.Now().Add(-time.Duration(getConversationTimeoutHours(workspaceID)) * time.Hour)
+
+	var conversation models.Conversation
+	err := tx.Where("client_id = ? AND channel_id = ? AND status = ? AND created_at > ?",
+		client.ID,
+		mapExternalTypeToChannelID(channelType),
+		models.ConversationStatusSpam,
+		cutoffTime,
+	).Order("created_at DESC").First(&conversation).Error
+	if err != nil {
+		return nil
+	}
+	return &conversation
+}
+
+// upsertClient finds or creates a client of the workspace based on external ID
+func upsertClient(tx *gorm.DB, workspaceID uint, externalIDType, externalIDValue string, userInfo map[string]interface{}) (*models.Client, error) {
 	// First, try to find existing client by external ID
 	var externalID models.ClientExternalID
-	err := db.Where("type = ? AND value = ?", externalIDType, externalIDValue).
+	err := tx.Where("type = ? AND value = ?", externalIDType, externalIDValue).
 		Preload("Client").
 		First(&externalID).Error
 
@@ -607,7 +685,7 @@ func upsertClient(externalIDType, externalIDValue string, userInfo map[string]in
 				// Fetch avatar from Telegram if bot token is provided (deferred fetch)
 				if telegramUserID, ok := userInfo["telegram_user_id"].(int64); ok && telegramUserID > 0 {
 					if botToken, ok := userInfo["telegram_bot_token"].(string); ok && botToken != "" {
-						avatarURL := getTelegramUserProfilePhotoURL(botToken, telegramUserID)
+						avatarURL := getTelegramUserProfilePhotoURL(workspaceID, botToken, telegramUserID)
 						if avatarURL != "" && (client.Avatar == nil || *client.Avatar != avatarURL) {
 							client.Avatar = &avatarURL
 							updated = true
@@ -617,12 +695,13 @@ func upsertClient(externalIDType, externalIDValue string, userInfo map[string]in
 			}
 
 			if updated {
-				if err := db.Save(client).Error; err != nil {
+				if err := tx.Save(client).Error; err != nil {
 					log.Warning("Failed to update client info:", err)
 				}
 			}
 		}
 
+		models.AssociateClientOrganizationByEmail(client, clientEmailAddress(externalIDType, externalIDValue, userInfo))
 		return client, nil
 	}
 
@@ -641,7 +720,7 @@ func upsertClient(externalIDType, externalIDValue string, userInfo map[string]in
 		// Fetch avatar from Telegram for new clients
 		if telegramUserID, ok := userInfo["telegram_user_id"].(int64); ok && telegramUserID > 0 {
 			if botToken, ok := userInfo["telegram_bot_token"].(string); ok && botToken != "" {
-				avatarURL := getTelegramUserProfilePhotoURL(botToken, telegramUserID)
+				avatarURL := getTelegramUserProfilePhotoURL(workspaceID, botToken, telegramUserID)
 				if avatarURL != "" {
 					clientAvatar = &avatarURL
 				}
@@ -669,7 +748,7 @@ func upsertClient(externalIDType, externalIDValue string, userInfo map[string]in
 		Avatar:   clientAvatar,
 	}
 
-	if err := db.Create(client).Error; err != nil {
+	if err := tx.Create(client).Error; err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
@@ -680,20 +759,32 @@ func upsertClient(externalIDType, externalIDValue string, userInfo map[string]in
 		Value:    externalIDValue,
 	}
 
-	if err := db.Create(&newExternalID).Error; err != nil {
+	if err := tx.Create(&newExternalID).Error; err != nil {
 		return nil, fmt.Errorf("failed to create external ID: %w", err)
 	}
 
 	log.Info("Created new client: id=%s, name=%s, external_type=%s",
 		client.ID, client.Name, externalIDType)
 
-	return client, nil
+	models.AssociateClientOrganizationByEmail(client, clientEmailAddress(externalIDType, externalIDValue, userInfo))
+	return models.ResolveClientDuplicates(client), nil
+}
+
+// clientEmailAddress returns the email address known for a client, either from its external ID or its profile info
+func clientEmailAddress(externalIDType, externalIDValue string, userInfo map[string]interface{}) string {
+	if externalIDType == models.ExternalIDTypeEmail {
+		return externalIDValue
+	}
+	if email, ok := userInfo["email"].(string); ok {
+		return email
+	}
+	return ""
 }
 
 // findOrCreateConversation finds an existing conversation within timeout or creates a new one
-func findOrCreateConversation(client *models.Client, channelType, channelContext string) (*models.Conversation, error) {
+func findOrCreateConversation(tx *gorm.DB, workspaceID uint, client *models.Client, channelType, channelContext string) (*models.Conversation, error) {
 	// Get conversation timeout from settings
-	timeoutHours := getConversationTimeoutHours()
+	timeoutHours := getConversationTimeoutHours(workspaceID)
 	cutoffTime := time.Now().Add(-time.Duration(timeoutHours) * time.Hour)
 
 	// Map external ID type to channel ID
@@ -701,7 +792,7 @@ func findOrCreateConversation(client *models.Client, channelType, channelContext
 
 	// Find recent open conversation for this client on this channel
 	var conversation models.Conversation
-	err := db.Where("client_id = ? AND channel_id = ? AND status NOT IN (?, ?, ?) AND created_at > ?",
+	err := tx.Where("client_id = ? AND channel_id = ? AND status NOT IN (?, ?, ?) AND created_at > ?",
 		client.ID,
 		channelID,
 		models.ConversationStatusClosed,
@@ -730,7 +821,7 @@ func findOrCreateConversation(client *models.Client, channelType, channelContext
 	}
 
 	// Get default department from settings
-	defaultDeptStr := models.GetSettingValue("workflow.default_department", "")
+	defaultDeptStr := models.GetWorkspaceSettingValue(workspaceID, "workflow.default_department", "")
 	if defaultDeptStr != "" {
 		if deptID, err := strconv.ParseUint(defaultDeptStr, 10, 32); err == nil && deptID > 0 {
 			deptIDUint := uint(deptID)
@@ -738,7 +829,7 @@ func findOrCreateConversation(client *models.Client, channelType, channelContext
 		}
 	}
 
-	if err := db.Create(&conversation).Error; err != nil {
+	if err := tx.Create(&conversation).Error; err != nil {
 		return nil, fmt.Errorf("failed to create conversation: %w", err)
 	}
 
@@ -748,9 +839,9 @@ func findOrCreateConversation(client *models.Client, channelType, channelContext
 	return &conversation, nil
 }
 
-// getConversationTimeoutHours gets the conversation timeout from settings
-func getConversationTimeoutHours() int {
-	timeoutStr := models.GetSettingValue(SettingConversationTimeoutHours, "")
+// getConversationTimeoutHours gets the conversation timeout from the settings of the workspace
+func getConversationTimeoutHours(workspaceID uint) int {
+	timeoutStr := models.GetWorkspaceSettingValue(workspaceID, SettingConversationTimeoutHours, "")
 	if timeoutStr == "" {
 		return DefaultConversationTimeoutHours
 	}
@@ -805,8 +896,8 @@ func toTitle(s string) string {
 // =============================================================================
 
 // SendSlackMessage sends a message to Slack using chat.postMessage API
-func SendSlackMessage(channelID, text string) error {
-	integration, err := models.GetIntegration(models.IntegrationTypeSlack)
+func SendSlackMessage(workspaceID uint, channelID, text string) error {
+	integration, err := models.GetIntegration(workspaceID, models.IntegrationTypeSlack)
 	if err != nil || integration.Status != models.IntegrationStatusEnabled {
 		return fmt.Errorf("Slack integration not enabled")
 	}
@@ -877,8 +968,8 @@ func SendSlackMessage(channelID, text string) error {
 }
 
 // SendTelegramMessage sends a message to Telegram
-func SendTelegramMessage(chatID, text string) error {
-	integration, err := models.GetIntegration(models.IntegrationTypeTelegram)
+func SendTelegramMessage(workspaceID uint, chatID, text string) error {
+	integration, err := models.GetIntegration(workspaceID, models.IntegrationTypeTelegram)
 	if err != nil || integration.Status != models.IntegrationStatusEnabled {
 		return fmt.Errorf("Telegram integration not enabled")
 	}
@@ -923,45 +1014,94 @@ func SendTelegramMessage(chatID, text string) error {
 }
 
 // SendWhatsAppMessage sends a message to WhatsApp using the Business API
-func SendWhatsAppMessage(phoneNumber, text string) error {
-	integration, err := models.GetIntegration(models.IntegrationTypeWhatsApp)
+func SendWhatsAppMessage(workspaceID uint, phoneNumber, text string) error {
+	payload := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"to":                phoneNumber,
+		"type":              "text",
+		"text": map[string]interface{}{
+			"body": text,
+		},
+	}
+
+	messageID, err := sendWhatsAppPayload(workspaceID, payload)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Sent WhatsApp message to %s: %s (ID: %s)", phoneNumber, truncateString(text, 50), messageID)
+	return nil
+}
+
+// SendWhatsAppTemplate sends a pre-approved template message, required to message a customer
+// outside the WhatsApp customer service window
+func SendWhatsAppTemplate(workspaceID uint, phoneNumber string, template models.WhatsAppTemplate) error {
+	language := template.Language
+	if language == "" {
+		language = "en_US"
+	}
+
+	templatePayload := map[string]interface{}{
+		"name":     template.Name,
+		"language": map[string]interface{}{"code": language},
+	}
+	if len(template.Parameters) > 0 {
+		parameters := make([]map[string]interface{}, 0, len(template.Parameters))
+		for _, value := range template.Parameters {
+			parameters = append(parameters, map[string]interface{}{"type": "text", "text": value})
+		}
+		templatePayload["components"] = []map[string]interface{}{
+			{"type": "body", "parameters": parameters},
+		}
+	}
+
+	payload := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"to":                phoneNumber,
+		"type":              "template",
+		"template":          templatePayload,
+	}
+
+	messageID, err := sendWhatsAppPayload(workspaceID, payload)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Sent WhatsApp template %s to %s (ID: %s)", template.Name, phoneNumber, messageID)
+	return nil
+}
+
+// sendWhatsAppPayload posts a message payload to the WhatsApp Business API and returns the message ID
+func sendWhatsAppPayload(workspaceID uint, payload map[string]interface{}) (string, error) {
+	integration, err := models.GetIntegration(workspaceID, models.IntegrationTypeWhatsApp)
 	if err != nil || integration.Status != models.IntegrationStatusEnabled {
-		return fmt.Errorf("WhatsApp integration not enabled")
+		return "", fmt.Errorf("WhatsApp integration not enabled")
 	}
 
 	var config models.WhatsAppConfig
 	if err := json.Unmarshal([]byte(integration.Config), &config); err != nil {
-		return fmt.Errorf("invalid WhatsApp config: %w", err)
+		return "", fmt.Errorf("invalid WhatsApp config: %w", err)
 	}
 
 	// Validate required fields
 	if config.PhoneNumberID == "" {
-		return fmt.Errorf("WhatsApp phone number ID not configured")
+		return "", fmt.Errorf("WhatsApp phone number ID not configured")
 	}
 	if config.AccessToken == "" {
-		return fmt.Errorf("WhatsApp access token not configured")
+		return "", fmt.Errorf("WhatsApp access token not configured")
 	}
 
 	// Call WhatsApp Business API messages endpoint
 	apiURL := fmt.Sprintf("https://graph.instagram.com/v18.0/%s/messages", config.PhoneNumberID)
 
-	payload := map[string]interface{}{
-		"messaging_product": "whatsapp",
-		"to":                phoneNumber,
-		"type":              "text",
-		"text": map[string]interface{}{
-			"body": text,
-		},
-	}
-
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		return "", fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
 	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
@@ -972,14 +1112,14 @@ func SendWhatsAppMessage(phoneNumber, text string) error {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send WhatsApp message: %w", err)
+		return "", fmt.Errorf("failed to send WhatsApp message: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return "", fmt.Errorf("failed to read response: %w", err)
 	}
 
 	// Parse WhatsApp API response
@@ -994,22 +1134,21 @@ func SendWhatsAppMessage(phoneNumber, text string) error {
 	}
 
 	if err := json.Unmarshal(body, &waResp); err != nil {
-		return fmt.Errorf("failed to parse WhatsApp response: %w", err)
+		return "", fmt.Errorf("failed to parse WhatsApp response: %w", err)
 	}
 
 	// Check if WhatsApp returned an error
 	if waResp.Error.Message != "" {
 		log.Error("WhatsApp API error: %s (code: %d)", waResp.Error.Message, waResp.Error.Code)
-		return fmt.Errorf("WhatsApp API error: %s", waResp.Error.Message)
+		return "", fmt.Errorf("WhatsApp API error: %s", waResp.Error.Message)
 	}
 
 	// Verify message was sent
 	if len(waResp.Messages) == 0 {
-		return fmt.Errorf("WhatsApp API did not return a message ID")
+		return "", fmt.Errorf("WhatsApp API did not return a message ID")
 	}
 
-	log.Info("Sent WhatsApp message to %s: %s (ID: %s)", phoneNumber, truncateString(text, 50), waResp.Messages[0].ID)
-	return nil
+	return waResp.Messages[0].ID, nil
 }
 
 // Placeholder for unused uuid import
@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/models"
+)
+
+// Job name constant
+const (
+	JobSendCampaigns = "send_campaigns"
+)
+
+// RegisterCampaignJob registers the campaign sending job
+func RegisterCampaignJob() {
+	registry := GetRegistry()
+
+	registry.Register(JobDefinition{
+		Name:           JobSendCampaigns,
+		Description:    "Start scheduled campaigns and send pending campaign messages",
+		TimeoutSeconds: 900, // 15 minutes
+		Handler:        handleSendCampaigns,
+	})
+
+	log.Info("[jobs] Registered campaign job")
+}
+
+func handleSendCampaigns(ctx context.Context) (interface{}, error) {
+	result, err := models.ProcessDueCampaigns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if result.Sent > 0 || result.Failed > 0 || result.CampaignsStarted > 0 {
+		log.Info("[%s] Started %d campaigns, sent %d messages, %d failed, %d skipped, completed %d campaigns",
+			JobSendCampaigns, result.CampaignsStarted, result.Sent, result.Failed, result.Skipped, result.CampaignsCompleted)
+	}
+	return result, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/iesreza/homa-backend/apps/integrations/email"
 	"github.com/iesreza/homa-backend/apps/models"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 // Job name constant
@@ -27,6 +29,7 @@ type EmailFetchResult struct {
 	EmailsFetched         int                    `json:"emails_fetched"`
 	ConversationsCreated  int                    `json:"conversations_created"`
 	MessagesAdded         int                    `json:"messages_added"`
+	EmailsDropped         int                    `json:"emails_dropped"`
 	Errors                []string               `json:"errors,omitempty"`
 	Details               map[string]interface{} `json:"details,omitempty"`
 }
@@ -99,12 +102,13 @@ func handleFetchEmailMessages(ctx context.Context) (interface{}, error) {
 		result.EmailsFetched += intResult.EmailsFetched
 		result.ConversationsCreated += intResult.ConversationsCreated
 		result.MessagesAdded += intResult.MessagesAdded
+		result.EmailsDropped += intResult.EmailsDropped
 		result.Details[integration.Type] = intResult
 	}
 
-	log.Info("[%s] Email fetch job completed: %d integrations, %d emails fetched, %d conversations, %d messages",
+	log.Info("[%s] Email fetch job completed: %d integrations, %d emails fetched, %d conversations, %d messages, %d dropped",
 		JobFetchEmailMessages, result.IntegrationsProcessed, result.EmailsFetched,
-		result.ConversationsCreated, result.MessagesAdded)
+		result.ConversationsCreated, result.MessagesAdded, result.EmailsDropped)
 
 	return result, nil
 }
@@ -114,8 +118,12 @@ type integrationResult struct {
 	EmailsFetched        int `json:"emails_fetched"`
 	ConversationsCreated int `json:"conversations_created"`
 	MessagesAdded        int `json:"messages_added"`
+	EmailsDropped        int `json:"emails_dropped"`
 }
 
+// errEmailDropped is returned by processIncomingEmail when the sender is blocked
+var errEmailDropped = errors.New("email dropped by blocklist")
+
 // processEmailIntegration processes a single email integration
 func processEmailIntegration(ctx context.Context, integration models.Integration) (*integrationResult, error) {
 	result := &integrationResult{}
@@ -183,6 +191,10 @@ func processEmailIntegration(ctx context.Context, integration models.Integration
 
 		// Process the email (create conversation or add to existing)
 		isNew, err := processIncomingEmail(ctx, integration, fetchedEmail, emailConfig)
+		if errors.Is(err, errEmailDropped) {
+			result.EmailsDropped++
+			continue
+		}
 		if err != nil {
 			log.Error("[%s] Failed to process email %s: %v", JobFetchEmailMessages, fetchedEmail.MessageID, err)
 			continue
@@ -206,6 +218,33 @@ func getEmailConfigForIntegration(integration models.Integration) (*email.Config
 // processIncomingEmail processes an incoming email and creates/updates conversation
 // Returns true if a new conversation was created, false if message was added to existing
 func processIncomingEmail(ctx context.Context, integration models.Integration, incomingEmail email.Email, emailConfig *email.Config) (bool, error) {
+	// Check the sender against the blocklist and score the email for spam
+	sender := models.InboundSender{
+		ExternalIDType:  models.ExternalIDTypeEmail,
+		ExternalIDValue: incomingEmail.From,
+		Email:           incomingEmail.From,
+	}
+	verdict := models.CheckInboundEmail(&sender, incomingEmail.Headers, incomingEmail.Body, incomingEmail.HTMLBody)
+	if verdict != nil && verdict.Action == models.BlocklistActionDrop {
+		log.Info("[%s] Dropped email %s from blocked sender %s", JobFetchEmailMessages, incomingEmail.MessageID, incomingEmail.From)
+		models.RecordSpamEvent("email", sender, verdict, incomingEmail.Subject)
+
+		// Track the email without a conversation so it is not fetched again
+		if err := models.CreateEmailMessage(&models.EmailMessage{
+			IntegrationType: integration.Type,
+			MessageID:       incomingEmail.MessageID,
+			Subject:         incomingEmail.Subject,
+			FromEmail:       incomingEmail.From,
+			FromName:        incomingEmail.FromName,
+			ToEmail:         emailConfig.Email,
+			Direction:       "inbound",
+			ReceivedAt:      incomingEmail.Date,
+		}); err != nil {
+			log.Error("[%s] Failed to create email tracking record: %v", JobFetchEmailMessages, err)
+		}
+		return false, errEmailDropped
+	}
+
 	// Try to find existing conversation by email threading
 	var conversation *models.Conversation
 	var err error
@@ -214,6 +253,7 @@ func processIncomingEmail(ctx context.Context, integration models.Integration, i
 	// 1. First try In-Reply-To header
 	if incomingEmail.InReplyTo != "" {
 		conversation, err = models.GetConversationByInReplyTo(incomingEmail.InReplyTo)
+		conversation = inIntegrationWorkspace(conversation, integration)
 		if err == nil && conversation != nil {
 			log.Debug("[%s] Found conversation %d by In-Reply-To", JobFetchEmailMessages, conversation.ID)
 		}
@@ -222,6 +262,7 @@ func processIncomingEmail(ctx context.Context, integration models.Integration, i
 	// 2. Try References header
 	if conversation == nil && len(incomingEmail.References) > 0 {
 		conversation, err = models.GetConversationByEmailThread(incomingEmail.References)
+		conversation = inIntegrationWorkspace(conversation, integration)
 		if err == nil && conversation != nil {
 			log.Debug("[%s] Found conversation %d by References", JobFetchEmailMessages, conversation.ID)
 		}
@@ -231,6 +272,7 @@ func processIncomingEmail(ctx context.Context, integration models.Integration, i
 	if conversation == nil {
 		cleanedSubject := email.CleanSubject(incomingEmail.Subject)
 		conversation, err = models.GetConversationByEmailAndSubject(incomingEmail.From, cleanedSubject, "email")
+		conversation = inIntegrationWorkspace(conversation, integration)
 		if err == nil && conversation != nil {
 			log.Debug("[%s] Found conversation %d by email+subject", JobFetchEmailMessages, conversation.ID)
 		}
@@ -246,6 +288,15 @@ func processIncomingEmail(ctx context.Context, integration models.Integration, i
 		log.Info("[%s] Created new conversation %d for email from %s", JobFetchEmailMessages, conversation.ID, incomingEmail.From)
 	}
 
+	// Mark as spam before the message is added so the AI agent does not answer it
+	if verdict != nil && conversation.Status != models.ConversationStatusSpam {
+		if err := models.MarkConversationSpam(conversation.ID, "email", sender, verdict, incomingEmail.Subject); err != nil {
+			log.Error("[%s] Failed to mark conversation %d as spam: %v", JobFetchEmailMessages, conversation.ID, err)
+		} else {
+			conversation.Status = models.ConversationStatusSpam
+		}
+	}
+
 	// Add message to conversation
 	messageBody := email.ExtractPlainText(incomingEmail)
 	if email.IsReplyEmail(incomingEmail.Subject) {
@@ -301,13 +352,26 @@ func processIncomingEmail(ctx context.Context, integration models.Integration, i
 	}
 	db.Model(conversation).Updates(updates)
 
+	// Track campaign replies and opt-outs sent as a reply instead of through the unsubscribe link
+	if conversation.Status != models.ConversationStatusSpam {
+		models.RecordCampaignReply(conversation.ClientID, models.ExternalIDTypeEmail, conversation.ID)
+		if models.IsOptOutKeyword(messageBody) || models.IsOptOutKeyword(incomingEmail.Subject) {
+			if err := models.OptOutClient(conversation.ClientID, models.ExternalIDTypeEmail, models.OptOutSourceKeyword, nil); err != nil {
+				log.Warning("[%s] Failed to opt out client %s: %v", JobFetchEmailMessages, conversation.ClientID, err)
+			} else {
+				models.CreateActionMessage(conversation.ID, nil, "", "Client opted out of campaign messages")
+			}
+		}
+	}
+
 	return isNewConversation, nil
 }
 
 // createConversationFromEmail creates a new conversation from an incoming email
 func createConversationFromEmail(integration models.Integration, incomingEmail email.Email, emailConfig *email.Config) (*models.Conversation, error) {
 	// Find or create customer (client in our model)
-	client, err := findOrCreateClientFromEmail(incomingEmail)
+	tx := models.WorkspaceDB(integration.WorkspaceID)
+	client, err := findOrCreateClientFromEmail(tx, incomingEmail)
 	if err != nil {
 		return nil, err
 	}
@@ -333,24 +397,25 @@ func createConversationFromEmail(integration models.Integration, incomingEmail e
 		CustomFields: datatypes.JSON("{}"),
 	}
 
-	if err := db.Create(conversation).Error; err != nil {
+	if err := tx.Create(conversation).Error; err != nil {
 		return nil, err
 	}
 
 	return conversation, nil
 }
 
-// findOrCreateClientFromEmail finds an existing client by email or creates a new one
-func findOrCreateClientFromEmail(incomingEmail email.Email) (*models.Client, error) {
+// findOrCreateClientFromEmail finds an existing client by email or creates a new one in the workspace of tx
+func findOrCreateClientFromEmail(tx *gorm.DB, incomingEmail email.Email) (*models.Client, error) {
 	// Try to find by email in external IDs
 	var extID models.ClientExternalID
-	err := db.Where("type = ? AND value = ?", models.ExternalIDTypeEmail, incomingEmail.From).First(&extID).Error
+	err := tx.Where("type = ? AND value = ?", models.ExternalIDTypeEmail, incomingEmail.From).First(&extID).Error
 	if err == nil {
 		// Found existing client
 		var client models.Client
-		if err := db.First(&client, "id = ?", extID.ClientID).Error; err != nil {
+		if err := tx.First(&client, "id = ?", extID.ClientID).Error; err != nil {
 			return nil, err
 		}
+		models.AssociateClientOrganizationByEmail(&client, incomingEmail.From)
 		return &client, nil
 	}
 
@@ -370,7 +435,7 @@ func findOrCreateClientFromEmail(incomingEmail email.Email) (*models.Client, err
 		Data: datatypes.JSON("{}"),
 	}
 
-	if err := db.Create(&client).Error; err != nil {
+	if err := tx.Create(&client).Error; err != nil {
 		return nil, err
 	}
 
@@ -381,12 +446,21 @@ func findOrCreateClientFromEmail(incomingEmail email.Email) (*models.Client, err
 		Value:    incomingEmail.From,
 	}
 
-	if err := db.Create(&emailExtID).Error; err != nil {
+	if err := tx.Create(&emailExtID).Error; err != nil {
 		log.Warning("[%s] Failed to create email external ID: %v", JobFetchEmailMessages, err)
 		// Don't fail, client was created
 	}
 
-	return &client, nil
+	models.AssociateClientOrganizationByEmail(&client, incomingEmail.From)
+	return models.ResolveClientDuplicates(&client), nil
+}
+
+// inIntegrationWorkspace drops a conversation matched by email threading that belongs to another workspace
+func inIntegrationWorkspace(conversation *models.Conversation, integration models.Integration) *models.Conversation {
+	if conversation == nil || conversation.WorkspaceID != integration.WorkspaceID {
+		return nil
+	}
+	return conversation
 }
 
 // generateEmailConversationSecret generates a random 32-character hexadecimal secret
@@ -75,6 +75,12 @@ func RegisterAllJobs() {
 	// Register email fetch job (defined in email_fetch.go)
 	RegisterEmailFetchJob()
 
+	// Register campaign job (defined in campaigns.go)
+	RegisterCampaignJob()
+
+	// Register scheduled message job (defined in scheduled_messages.go)
+	RegisterScheduledMessageJob()
+
 	log.Info("[jobs] Registered %d jobs", registry.Count())
 }
 
@@ -3,6 +3,7 @@ package jobs
 import (
 	"github.com/getevo/evo/v2"
 	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/iesreza/homa-backend/apps/auth"
 	"github.com/iesreza/homa-backend/lib/response"
 )
 
@@ -33,6 +34,13 @@ func APIKeyMiddleware(req *evo.Request) error {
 		providedKey = authHeader[7:]
 	}
 
+	// Logged-in users holding the jobs permission may call the endpoints without the key
+	if providedKey == "" && !req.User().Anonymous() {
+		if user, ok := req.User().Interface().(*auth.User); ok && user.HasPermission(auth.PermissionJobsManage) {
+			return req.Next()
+		}
+	}
+
 	// Check if API key is configured
 	if apiKey == "" {
 		req.WriteResponse(response.InternalError(nil, "Jobs API key not configured"))
@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/models"
+)
+
+// Job name constant
+const (
+	JobSendScheduledMessages = "send_scheduled_messages"
+)
+
+// RegisterScheduledMessageJob registers the scheduled message dispatch job
+func RegisterScheduledMessageJob() {
+	registry := GetRegistry()
+
+	registry.Register(JobDefinition{
+		Name:           JobSendScheduledMessages,
+		Description:    "Send agent messages whose scheduled time has passed",
+		TimeoutSeconds: 300, // 5 minutes
+		Handler:        handleSendScheduledMessages,
+	})
+
+	log.Info("[jobs] Registered scheduled message job")
+}
+
+func handleSendScheduledMessages(ctx context.Context) (interface{}, error) {
+	result, err := models.ProcessDueScheduledMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if result.Sent > 0 || result.Cancelled > 0 || result.Failed > 0 {
+		log.Info("[%s] Sent %d scheduled messages, cancelled %d, %d failed",
+			JobSendScheduledMessages, result.Sent, result.Cancelled, result.Failed)
+	}
+	return result, nil
+}
@@ -1,19 +1,16 @@
 package livechat
 
 import (
-	"os"
-
 	"github.com/getevo/evo/v2"
-	"github.com/getevo/evo/v2/lib/log"
-	"github.com/getevo/evo/v2/lib/settings"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/iesreza/homa-backend/apps/auth"
 )
 
 // RegisterRoutes registers livechat HTTP and WebSocket routes
 func RegisterRoutes() error {
-	// Initialize JWT secret for agent WebSocket authentication
-	initJWTSecret()
+	// Drop agent WebSocket connections of revoked sessions
+	auth.SessionRevokedBroadcaster = publishSessionRevoked
 
 	// Serve the livechat UI
 	evo.Static("/livechat", "./static/livechat")
@@ -41,25 +38,11 @@ func RegisterRoutes() error {
 		return fiber.ErrUpgradeRequired
 	})
 
-	// WebSocket route for conversations (client-facing with secret auth)
-	app.Get("/ws/conversations/:conversation_id/:secret", websocket.New(HandleWebSocket))
+	// WebSocket route for conversations (client-facing with secret auth, restricted to the inbox's allowed origins)
+	app.Get("/ws/conversations/:conversation_id/:secret", checkConversationOrigin, websocket.New(HandleWebSocket))
 
 	// WebSocket route for agents (JWT authenticated, receives all conversation events)
 	app.Get("/ws/agent", websocket.New(HandleAgentWebSocket))
 
 	return nil
 }
-
-// initJWTSecret initializes the JWT secret from config
-func initJWTSecret() {
-	secret := settings.Get("JWT.SECRET").String()
-	if secret == "" {
-		secret = os.Getenv("JWT_SECRET")
-	}
-	if secret == "" {
-		log.Warning("JWT_SECRET not set for livechat WebSocket, using development key")
-		secret = "your-secret-key-change-this-in-production"
-	}
-	SetJWTSecret([]byte(secret))
-	log.Debug("Livechat JWT secret initialized")
-}
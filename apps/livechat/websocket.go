@@ -9,9 +9,13 @@ import (
 	"github.com/getevo/evo/v2/lib/db"
 	"github.com/getevo/evo/v2/lib/log"
 	"github.com/gofiber/contrib/websocket"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/auth"
 	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/apps/nats"
+	"github.com/iesreza/homa-backend/apps/redis"
+	"github.com/iesreza/homa-backend/lib/tenant"
 	natsclient "github.com/nats-io/nats.go"
 	"gorm.io/gorm"
 )
@@ -27,6 +31,29 @@ var (
 	wsLock        sync.RWMutex
 )
 
+// checkConversationOrigin enforces the origin allowlist and rate limit of the conversation's inbox
+// before the WebSocket upgrade. Browsers send the Origin header with every upgrade.
+func checkConversationOrigin(c *fiber.Ctx) error {
+	var conversation models.Conversation
+	if err := db.Select("id", "workspace_id", "inbox_id").
+		Where("id = ? AND secret = ?", c.Params("conversation_id"), c.Params("secret")).
+		First(&conversation).Error; err != nil || conversation.InboxID == nil {
+		// Unknown conversations are closed by HandleWebSocket
+		return c.Next()
+	}
+	inbox, err := models.GetInboxByID(conversation.WorkspaceID, *conversation.InboxID)
+	if err != nil {
+		return c.Next()
+	}
+	if !inbox.OriginAllowed(c.Get("Origin")) {
+		return fiber.ErrForbidden
+	}
+	if !redis.AllowInboxRequest(inbox, c.IP()) {
+		return fiber.ErrTooManyRequests
+	}
+	return c.Next()
+}
+
 // HandleWebSocket handles WebSocket connections for conversations
 func HandleWebSocket(c *websocket.Conn) {
 	conversationIDStr := c.Params("conversation_id")
@@ -65,7 +92,7 @@ func HandleWebSocket(c *websocket.Conn) {
 
 	// Subscribe to NATS channel for this conversation
 	// Each WebSocket connection subscribes independently and receives all messages
-	subject := fmt.Sprintf("conversation.%d", conversationID)
+	subject := models.ConversationSubject(conversation.WorkspaceID, conversation.ID)
 	sub, err := nats.Subscribe(subject, func(msg *natsclient.Msg) {
 		// Filter out action messages from client view (they are internal activity logs)
 		var msgData map[string]interface{}
@@ -137,7 +164,7 @@ func BroadcastToConversation(conversationID uint, data interface{}) error {
 	}
 
 	// Publish to NATS - this will trigger the subscription handlers
-	subject := fmt.Sprintf("conversation.%d", conversationID)
+	subject := models.ConversationSubject(0, conversationID)
 	return nats.Publish(subject, jsonData)
 }
 
@@ -159,13 +186,14 @@ func HandleAgentWebSocket(c *websocket.Conn) {
 	}
 
 	// Validate JWT token
-	userID, err := validateJWTToken(token)
+	user, err := validateJWTToken(token)
 	if err != nil {
 		log.Warning("Agent WebSocket: Invalid token: %v", err)
 		c.WriteJSON(map[string]string{"error": "invalid token"})
 		c.Close()
 		return
 	}
+	userID := user.UserID.String()
 
 	log.Info("Agent WebSocket connected for user %s", userID)
 
@@ -174,9 +202,9 @@ func HandleAgentWebSocket(c *websocket.Conn) {
 	wsConn := &WebSocketConn{conn: c}
 	agentConnections.Store(connectionID, wsConn)
 
-	// Subscribe to all conversation events using wildcard
+	// Subscribe to all conversation events of the user's workspace using wildcard
 	// This receives: conversation.created, conversation.updated, message.created, etc.
-	sub, err := nats.Subscribe("conversations.>", func(msg *natsclient.Msg) {
+	sub, err := nats.Subscribe(tenant.Subject(user.WorkspaceID, "conversations.>"), func(msg *natsclient.Msg) {
 		wsConn.mutex.Lock()
 		err := wsConn.conn.WriteMessage(websocket.TextMessage, msg.Data)
 		wsConn.mutex.Unlock()
@@ -194,7 +222,7 @@ func HandleAgentWebSocket(c *websocket.Conn) {
 	defer sub.Unsubscribe()
 
 	// Also subscribe to individual conversation updates (conversation.{id} pattern)
-	subConv, err := nats.Subscribe("conversation.>", func(msg *natsclient.Msg) {
+	subConv, err := nats.Subscribe(tenant.Subject(user.WorkspaceID, "conversation.>"), func(msg *natsclient.Msg) {
 		wsConn.mutex.Lock()
 		err := wsConn.conn.WriteMessage(websocket.TextMessage, msg.Data)
 		wsConn.mutex.Unlock()
@@ -209,6 +237,22 @@ func HandleAgentWebSocket(c *websocket.Conn) {
 		defer subConv.Unsubscribe()
 	}
 
+	// Close the connection as soon as its session is revoked (logout, termination, blocked user)
+	subRevoked, err := nats.Subscribe(sessionRevokedSubject(userID), func(msg *natsclient.Msg) {
+		if string(msg.Data) != user.SessionID() {
+			return
+		}
+		wsConn.mutex.Lock()
+		wsConn.conn.WriteJSON(map[string]string{"error": "session revoked"})
+		wsConn.mutex.Unlock()
+		c.Close()
+	})
+	if err != nil {
+		log.Error("Agent WebSocket: Failed to subscribe to session revocations: %v", err)
+	} else {
+		defer subRevoked.Unsubscribe()
+	}
+
 	// Send confirmation
 	c.WriteJSON(map[string]string{"status": "connected", "user_id": userID})
 
@@ -230,46 +274,19 @@ func HandleAgentWebSocket(c *websocket.Conn) {
 	}
 }
 
-// validateJWTToken validates a JWT token and returns the user ID
-func validateJWTToken(tokenString string) (string, error) {
-	// Import auth package's JWT validation
-	token, err := parseJWT(tokenString)
-	if err != nil {
-		return "", err
-	}
-
-	claims, ok := token.Claims.(*jwtClaims)
-	if !ok || !token.Valid {
-		return "", fmt.Errorf("invalid token claims")
-	}
-
-	return claims.UserID, nil
-}
-
-// jwtClaims mirrors auth.Claims for JWT parsing
-type jwtClaims struct {
-	UserID      string   `json:"user_id"`
-	Email       string   `json:"email"`
-	Name        string   `json:"name"`
-	Type        string   `json:"type"`
-	Departments []string `json:"departments"`
-	jwt.RegisteredClaims
+// validateJWTToken validates a JWT token and returns its user; revoked sessions and blocked users are rejected
+func validateJWTToken(tokenString string) (*auth.User, error) {
+	return auth.UserFromToken(tokenString, "/ws/agent")
 }
 
-// parseJWT parses and validates a JWT token
-func parseJWT(tokenString string) (*jwt.Token, error) {
-	return jwt.ParseWithClaims(tokenString, &jwtClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+// sessionRevokedSubject is the NATS subject the revoked sessions of a user are published on
+func sessionRevokedSubject(userID string) string {
+	return "auth.session.revoked." + userID
 }
 
-// jwtSecret will be set during initialization
-var jwtSecret []byte
-
-// SetJWTSecret sets the JWT secret for token validation
-func SetJWTSecret(secret []byte) {
-	jwtSecret = secret
+// publishSessionRevoked notifies every instance to drop the WebSocket connections of a revoked session
+func publishSessionRevoked(userID uuid.UUID, sessionID string) {
+	if err := nats.Publish(sessionRevokedSubject(userID.String()), []byte(sessionID)); err != nil {
+		log.Error("Failed to publish session revocation: %v", err)
+	}
 }
@@ -20,6 +20,11 @@ const (
 	ActionLogin        = "login"
 	ActionLogout       = "logout"
 	ActionView         = "view"
+	ActionMerge        = "merge"
+	ActionRevert       = "revert"
+	ActionRevoke       = "revoke"
+	ActionRotate       = "rotate"
+	ActionExport       = "export"
 )
 
 // Activity log entity type constants
@@ -34,26 +39,46 @@ const (
 	EntityCategory     = "category"
 	EntitySetting      = "setting"
 	EntityMessage      = "message"
+	EntityBlocklist    = "blocklist_entry"
+	EntitySpamEvent    = "spam_event"
+	EntityCampaign     = "campaign"
+	EntityAPIKey       = "api_key"
+	EntityIntegration  = "integration"
+	EntityAIAgent      = "ai_agent"
+	EntityAIAgentTool  = "ai_agent_tool"
+	EntityRole         = "role"
+	EntityActivityLog  = "activity_log"
 )
 
-// ActivityLog tracks all changes to entities in the system
+// ActivityLog tracks all changes to entities in the system.
+// Entries are append-only: each one stores the hash of the entry of its workspace before it (see
+// activity_log_chain.go), so editing, removing or moving an entry breaks the chain and is reported by
+// VerifyActivityLogChain.
 type ActivityLog struct {
-	ID         uint           `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
-	EntityType string         `gorm:"column:entity_type;size:50;not null;index" json:"entity_type"`
-	EntityID   string         `gorm:"column:entity_id;size:255;not null;index" json:"entity_id"`
-	Action     string         `gorm:"column:action;size:50;not null;index" json:"action"`
-	UserID     *uuid.UUID     `gorm:"column:user_id;type:char(36);index;fk:users" json:"user_id"`
-	OldValues  datatypes.JSON `gorm:"column:old_values;type:json" json:"old_values,omitempty"`
-	NewValues  datatypes.JSON `gorm:"column:new_values;type:json" json:"new_values,omitempty"`
-	Metadata   datatypes.JSON `gorm:"column:metadata;type:json" json:"metadata,omitempty"`
-	IPAddress  *string        `gorm:"column:ip_address;size:45" json:"ip_address,omitempty"`
-	UserAgent  *string        `gorm:"column:user_agent;size:500" json:"user_agent,omitempty"`
-	CreatedAt  time.Time      `gorm:"column:created_at;autoCreateTime;index" json:"created_at"`
+	ID          uint           `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	WorkspaceID uint           `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	EntityType  string         `gorm:"column:entity_type;size:50;not null;index" json:"entity_type"`
+	EntityID    string         `gorm:"column:entity_id;size:255;not null;index" json:"entity_id"`
+	Action      string         `gorm:"column:action;size:50;not null;index" json:"action"`
+	UserID      *uuid.UUID     `gorm:"column:user_id;type:char(36);index;fk:users" json:"user_id"`
+	APIKeyID    *uint          `gorm:"column:api_key_id;index" json:"api_key_id,omitempty"`
+	OldValues   datatypes.JSON `gorm:"column:old_values;type:json" json:"old_values,omitempty"`
+	NewValues   datatypes.JSON `gorm:"column:new_values;type:json" json:"new_values,omitempty"`
+	Metadata    datatypes.JSON `gorm:"column:metadata;type:json" json:"metadata,omitempty"`
+	IPAddress   *string        `gorm:"column:ip_address;size:45" json:"ip_address,omitempty"`
+	UserAgent   *string        `gorm:"column:user_agent;size:500" json:"user_agent,omitempty"`
+	CreatedAt   time.Time      `gorm:"column:created_at;autoCreateTime;index" json:"created_at"`
+	PrevHash    string         `gorm:"column:prev_hash;size:64;not null;default:''" json:"prev_hash"`
+	Hash        string         `gorm:"column:hash;size:64;not null;default:'';index" json:"hash"`
 
 	// Relationships
 	User *auth.User `gorm:"foreignKey:UserID;references:UserID" json:"user,omitempty"`
 
 	restify.API
+	restify.DisableCreate
+	restify.DisableUpdate
+	restify.DisableDelete
+	restify.DisableSet
 }
 
 func (ActivityLog) TableName() string {
@@ -62,13 +87,15 @@ func (ActivityLog) TableName() string {
 
 // ActivityLogEntry is a helper struct for creating activity log entries
 type ActivityLogEntry struct {
-	EntityType string
-	EntityID   string
-	Action     string
-	UserID     *uuid.UUID
-	OldValues  map[string]any
-	NewValues  map[string]any
-	Metadata   map[string]any
-	IPAddress  string
-	UserAgent  string
+	WorkspaceID uint // Looked up from the entity or the user when 0
+	EntityType  string
+	EntityID    string
+	Action      string
+	UserID      *uuid.UUID
+	APIKeyID    *uint
+	OldValues   map[string]any
+	NewValues   map[string]any
+	Metadata    map[string]any
+	IPAddress   string
+	UserAgent   string
 }
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errChainBroken stops VerifyActivityLogChain at the first broken entry
+var errChainBroken = errors.New("activity log chain is broken")
+
+// chainMu orders the appends of this process; the locking read in appendActivityLog orders them across processes
+var chainMu sync.Mutex
+
+// appendActivityLog links the entry to the last entry of its workspace's chain and inserts it
+func appendActivityLog(entry *ActivityLog) error {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	entry.WorkspaceID = OrDefaultWorkspace(entry.WorkspaceID)
+	truncateActivityLog(entry)
+	return db.Transaction(func(tx *gorm.DB) error {
+		var last ActivityLog
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "hash").
+			Where("workspace_id = ?", entry.WorkspaceID).Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		// Stored with second precision so the hash can be recomputed from the row
+		entry.CreatedAt = time.Now().Truncate(time.Second)
+		entry.PrevHash = last.Hash
+		// The hash covers the ID, which is only known once the entry is inserted
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		entry.Hash = activityLogHash(entry)
+		return tx.Model(entry).UpdateColumn("hash", entry.Hash).Error
+	})
+}
+
+// truncateActivityLog cuts the text columns to their size, so the hash covers what the database stores
+func truncateActivityLog(entry *ActivityLog) {
+	entry.EntityType = truncateRunes(entry.EntityType, 50)
+	entry.EntityID = truncateRunes(entry.EntityID, 255)
+	entry.Action = truncateRunes(entry.Action, 50)
+	if entry.IPAddress != nil {
+		ip := truncateRunes(*entry.IPAddress, 45)
+		entry.IPAddress = &ip
+	}
+	if entry.UserAgent != nil {
+		userAgent := truncateRunes(*entry.UserAgent, 500)
+		entry.UserAgent = &userAgent
+	}
+}
+
+// truncateRunes returns the first size characters of s
+func truncateRunes(s string, size int) string {
+	if runes := []rune(s); len(runes) > size {
+		return string(runes[:size])
+	}
+	return s
+}
+
+// activityLogHash returns the SHA-256 of the entry's ID, workspace and content and the hash of the entry
+// before it. JSON values are re-encoded first: the database does not keep their formatting or key order.
+func activityLogHash(entry *ActivityLog) string {
+	var userID string
+	if entry.UserID != nil {
+		userID = entry.UserID.String()
+	}
+	var apiKeyID uint
+	if entry.APIKeyID != nil {
+		apiKeyID = *entry.APIKeyID
+	}
+	var ip, userAgent string
+	if entry.IPAddress != nil {
+		ip = *entry.IPAddress
+	}
+	if entry.UserAgent != nil {
+		userAgent = *entry.UserAgent
+	}
+
+	payload, _ := json.Marshal([]any{
+		entry.PrevHash,
+		entry.ID,
+		entry.WorkspaceID,
+		entry.EntityType,
+		entry.EntityID,
+		entry.Action,
+		userID,
+		apiKeyID,
+		canonicalJSON(entry.OldValues),
+		canonicalJSON(entry.NewValues),
+		canonicalJSON(entry.Metadata),
+		ip,
+		userAgent,
+		entry.CreatedAt.Unix(),
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalJSON decodes and re-encodes a JSON value so equal values hash the same
+func canonicalJSON(raw []byte) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return string(raw)
+	}
+	return value
+}
+
+// ActivityLogVerification is the result of checking the activity log chain
+type ActivityLogVerification struct {
+	Valid bool `json:"valid"`
+	// Checked is the number of chained entries that were verified
+	Checked int64 `json:"checked"`
+	// Legacy is the number of entries written before the chain existed; they cannot be verified
+	Legacy int64 `json:"legacy"`
+	// HeadID and HeadHash identify the last verified entry. Keeping them outside the database
+	// also detects entries removed from the end of the chain.
+	HeadID   uint   `json:"head_id,omitempty"`
+	HeadHash string `json:"head_hash,omitempty"`
+	// BrokenAt is the first entry that does not match the chain
+	BrokenAt *uint  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// VerifyActivityLogChain recomputes the hashes of the workspace's entries with fromID <= id <= toID (0 for
+// no bound) and reports the first entry that was edited or moved, or whose predecessor was edited or removed.
+// A range that starts after the first entry trusts the previous hash stored in its first entry.
+func VerifyActivityLogChain(workspaceID, fromID, toID uint) (*ActivityLogVerification, error) {
+	result := &ActivityLogVerification{Valid: true}
+
+	query := WorkspaceDB(OrDefaultWorkspace(workspaceID)).Model(&ActivityLog{})
+	if fromID > 0 {
+		query = query.Where("id >= ?", fromID)
+	}
+	if toID > 0 {
+		query = query.Where("id <= ?", toID)
+	}
+
+	started := false
+	var prev string
+	var batch []ActivityLog
+	err := query.FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			entry := &batch[i]
+			reason := ""
+			switch {
+			case entry.Hash == "" && !started:
+				result.Legacy++
+				continue
+			case entry.Hash == "":
+				reason = "entry is not chained"
+			case !started && fromID == 0 && entry.PrevHash != "":
+				reason = "the entries before this one were removed"
+			case started && entry.PrevHash != prev:
+				reason = "the entry before this one was removed or replaced"
+			case activityLogHash(entry) != entry.Hash:
+				reason = "entry was modified"
+			}
+			if reason != "" {
+				result.Valid = false
+				brokenAt := entry.ID
+				result.BrokenAt = &brokenAt
+				result.Reason = reason
+				return errChainBroken
+			}
+			started = true
+			prev = entry.Hash
+			result.Checked++
+			result.HeadID = entry.ID
+			result.HeadHash = entry.Hash
+		}
+		return nil
+	}).Error
+	if err != nil && err != errChainBroken {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ActivityLogFilter selects the activity log entries of a workspace to export
+type ActivityLogFilter struct {
+	WorkspaceID uint
+	From        *time.Time
+	To          *time.Time
+	EntityType  string
+	EntityID    string
+	Action      string
+	UserID      *uuid.UUID
+}
+
+// EachActivityLog calls fn for the entries matching filter in chain order, reading them in batches
+func EachActivityLog(filter ActivityLogFilter, fn func(entry *ActivityLog) error) error {
+	query := WorkspaceDB(OrDefaultWorkspace(filter.WorkspaceID)).Model(&ActivityLog{})
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.UserID != nil {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+
+	var batch []ActivityLog
+	return query.FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
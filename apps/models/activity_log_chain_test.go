@@ -0,0 +1,72 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+func TestActivityLogHashCoversPosition(t *testing.T) {
+	entry := ActivityLog{
+		ID:          7,
+		WorkspaceID: 2,
+		EntityType:  EntityConversation,
+		EntityID:    "15",
+		Action:      ActionUpdate,
+		NewValues:   datatypes.JSON(`{"status":"closed"}`),
+		CreatedAt:   time.Unix(1700000000, 0),
+		PrevHash:    "abc",
+	}
+	hash := activityLogHash(&entry)
+
+	tests := []struct {
+		name   string
+		change func(entry *ActivityLog)
+	}{
+		{name: "reordered", change: func(entry *ActivityLog) { entry.ID = 8 }},
+		{name: "moved to another workspace", change: func(entry *ActivityLog) { entry.WorkspaceID = 3 }},
+		{name: "previous entry replaced", change: func(entry *ActivityLog) { entry.PrevHash = "abd" }},
+		{name: "content edited", change: func(entry *ActivityLog) { entry.Action = ActionDelete }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := entry
+			tt.change(&changed)
+			if activityLogHash(&changed) == hash {
+				t.Error("hash did not change")
+			}
+		})
+	}
+
+	// JSON formatting and key order are not kept by the database and must not change the hash
+	reformatted := entry
+	reformatted.NewValues = datatypes.JSON(`{ "status": "closed" }`)
+	if activityLogHash(&reformatted) != hash {
+		t.Error("hash changed with the JSON formatting")
+	}
+}
+
+func TestTruncateActivityLog(t *testing.T) {
+	userAgent := strings.Repeat("é", 600)
+	ip := "2001:0db8:85a3:0000:0000:8a2e:0370:7334%interface-name"
+	entry := ActivityLog{EntityType: EntityClient, EntityID: strings.Repeat("x", 300), Action: ActionUpdate, UserAgent: &userAgent, IPAddress: &ip}
+
+	truncateActivityLog(&entry)
+	for name, got := range map[string]struct {
+		value string
+		size  int
+	}{
+		"entity_id":  {entry.EntityID, 255},
+		"user_agent": {*entry.UserAgent, 500},
+		"ip_address": {*entry.IPAddress, 45},
+	} {
+		if length := len([]rune(got.value)); length != got.size {
+			t.Errorf("%s has %d characters, want %d", name, length, got.size)
+		}
+	}
+	if entry.EntityType != EntityClient {
+		t.Errorf("entity_type = %q, want it unchanged", entry.EntityType)
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 
-	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2"
 	"github.com/getevo/evo/v2/lib/log"
 	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/auth"
 )
 
 // LogActivity creates a new activity log entry asynchronously
@@ -26,10 +27,12 @@ func LogActivitySync(entry ActivityLogEntry) error {
 // createActivityLog is the internal function that actually creates the log entry
 func createActivityLog(entry ActivityLogEntry) error {
 	activityLog := ActivityLog{
-		EntityType: entry.EntityType,
-		EntityID:   entry.EntityID,
-		Action:     entry.Action,
-		UserID:     entry.UserID,
+		WorkspaceID: entry.WorkspaceID,
+		EntityType:  entry.EntityType,
+		EntityID:    entry.EntityID,
+		Action:      entry.Action,
+		UserID:      entry.UserID,
+		APIKeyID:    entry.APIKeyID,
 	}
 
 	// Convert old values to JSON
@@ -66,16 +69,57 @@ func createActivityLog(entry ActivityLogEntry) error {
 		activityLog.UserAgent = &entry.UserAgent
 	}
 
-	return db.Create(&activityLog).Error
+	if activityLog.WorkspaceID == 0 {
+		activityLog.WorkspaceID = activityLogWorkspace(&activityLog)
+	}
+	return appendActivityLog(&activityLog)
+}
+
+// activityEntityModels are the entity types whose rows carry their workspace
+var activityEntityModels = map[string]any{
+	EntityConversation: &Conversation{},
+	EntityClient:       &Client{},
+	EntityDepartment:   &Department{},
+	EntityWebhook:      &Webhook{},
+	EntityCampaign:     &Campaign{},
+	EntityAIAgent:      &AIAgent{},
+}
+
+// activityLogWorkspace returns the workspace of the entity an entry is about or, for other entities,
+// of the user who made the change
+func activityLogWorkspace(entry *ActivityLog) uint {
+	var workspaceID uint
+	if model, ok := activityEntityModels[entry.EntityType]; ok {
+		workspaceID = workspaceOf(evo.GetDBO(), model, entry.EntityID)
+	}
+	if workspaceID == 0 && entry.UserID != nil {
+		workspaceID = workspaceOf(evo.GetDBO(), &auth.User{}, *entry.UserID)
+	}
+	return OrDefaultWorkspace(workspaceID)
+}
+
+// actorIDs returns the user and, for requests made with an API key, the key that performed an action.
+// Both are nil for changes made by the system.
+func actorIDs(actor *auth.User) (*uuid.UUID, *uint) {
+	if actor == nil {
+		return nil, nil
+	}
+	var apiKeyID *uint
+	if id := actor.APIKeyID(); id != 0 {
+		apiKeyID = &id
+	}
+	return &actor.UserID, apiKeyID
 }
 
 // LogConversationCreate logs a conversation creation
-func LogConversationCreate(conversationID uint, userID *uuid.UUID, newValues map[string]any, ip, userAgent string) {
+func LogConversationCreate(conversationID uint, actor *auth.User, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	LogActivity(ActivityLogEntry{
 		EntityType: EntityConversation,
 		EntityID:   fmt.Sprintf("%d", conversationID),
 		Action:     ActionCreate,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		NewValues:  newValues,
 		IPAddress:  ip,
 		UserAgent:  userAgent,
@@ -83,12 +127,14 @@ func LogConversationCreate(conversationID uint, userID *uuid.UUID, newValues map
 }
 
 // LogConversationUpdate logs a conversation update
-func LogConversationUpdate(conversationID uint, userID *uuid.UUID, oldValues, newValues map[string]any, ip, userAgent string) {
+func LogConversationUpdate(conversationID uint, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	LogActivity(ActivityLogEntry{
 		EntityType: EntityConversation,
 		EntityID:   fmt.Sprintf("%d", conversationID),
 		Action:     ActionUpdate,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		OldValues:  oldValues,
 		NewValues:  newValues,
 		IPAddress:  ip,
@@ -97,12 +143,14 @@ func LogConversationUpdate(conversationID uint, userID *uuid.UUID, oldValues, ne
 }
 
 // LogConversationStatusChange logs a conversation status change
-func LogConversationStatusChange(conversationID uint, userID *uuid.UUID, oldStatus, newStatus string, ip, userAgent string) {
+func LogConversationStatusChange(conversationID uint, actor *auth.User, oldStatus, newStatus string, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	LogActivity(ActivityLogEntry{
 		EntityType: EntityConversation,
 		EntityID:   fmt.Sprintf("%d", conversationID),
 		Action:     ActionStatusChange,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		OldValues:  map[string]any{"status": oldStatus},
 		NewValues:  map[string]any{"status": newStatus},
 		IPAddress:  ip,
@@ -111,7 +159,8 @@ func LogConversationStatusChange(conversationID uint, userID *uuid.UUID, oldStat
 }
 
 // LogConversationAssign logs a user or department assignment to a conversation
-func LogConversationAssign(conversationID uint, userID *uuid.UUID, assignedUserID *uuid.UUID, assignedDeptID *uint, ip, userAgent string) {
+func LogConversationAssign(conversationID uint, actor *auth.User, assignedUserID *uuid.UUID, assignedDeptID *uint, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	metadata := map[string]any{}
 	if assignedUserID != nil {
 		metadata["assigned_user_id"] = assignedUserID.String()
@@ -125,6 +174,7 @@ func LogConversationAssign(conversationID uint, userID *uuid.UUID, assignedUserI
 		EntityID:   fmt.Sprintf("%d", conversationID),
 		Action:     ActionAssign,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		Metadata:   metadata,
 		IPAddress:  ip,
 		UserAgent:  userAgent,
@@ -155,13 +205,47 @@ func LogUserLogout(userID uuid.UUID, ip, userAgent string) {
 	})
 }
 
+// LogAPIKeyEvent logs the creation, rotation or revocation of an API key
+func LogAPIKeyEvent(action string, key *auth.UserAPIKey, actorID uuid.UUID, ip, userAgent string) {
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityAPIKey,
+		EntityID:   fmt.Sprintf("%d", key.ID),
+		Action:     action,
+		UserID:     &actorID,
+		Metadata: map[string]any{
+			"owner_id":   key.UserID.String(),
+			"name":       key.Name,
+			"prefix":     key.Prefix,
+			"scopes":     key.GetScopes(),
+			"expires_at": key.ExpiresAt,
+		},
+		IPAddress: ip,
+		UserAgent: userAgent,
+	})
+}
+
+// LogAccountEvent logs an invitation or password reset of a user
+func LogAccountEvent(action string, userID uuid.UUID, actorID *uuid.UUID, metadata map[string]any, ip, userAgent string) {
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityUser,
+		EntityID:   userID.String(),
+		Action:     action,
+		UserID:     actorID,
+		Metadata:   metadata,
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
 // LogArticleCreate logs an article creation
-func LogArticleCreate(articleID uint, userID *uuid.UUID, title string, ip, userAgent string) {
+func LogArticleCreate(articleID uint, actor *auth.User, title string, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	LogActivity(ActivityLogEntry{
 		EntityType: EntityArticle,
 		EntityID:   fmt.Sprintf("%d", articleID),
 		Action:     ActionCreate,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		NewValues:  map[string]any{"title": title},
 		IPAddress:  ip,
 		UserAgent:  userAgent,
@@ -169,12 +253,14 @@ func LogArticleCreate(articleID uint, userID *uuid.UUID, title string, ip, userA
 }
 
 // LogArticleUpdate logs an article update
-func LogArticleUpdate(articleID uint, userID *uuid.UUID, oldValues, newValues map[string]any, ip, userAgent string) {
+func LogArticleUpdate(articleID uint, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	LogActivity(ActivityLogEntry{
 		EntityType: EntityArticle,
 		EntityID:   fmt.Sprintf("%d", articleID),
 		Action:     ActionUpdate,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		OldValues:  oldValues,
 		NewValues:  newValues,
 		IPAddress:  ip,
@@ -183,12 +269,14 @@ func LogArticleUpdate(articleID uint, userID *uuid.UUID, oldValues, newValues ma
 }
 
 // LogSettingChange logs a setting change
-func LogSettingChange(key string, userID *uuid.UUID, oldValue, newValue any, ip, userAgent string) {
+func LogSettingChange(key string, actor *auth.User, oldValue, newValue any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	LogActivity(ActivityLogEntry{
 		EntityType: EntitySetting,
 		EntityID:   key,
 		Action:     ActionUpdate,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		OldValues:  map[string]any{"value": oldValue},
 		NewValues:  map[string]any{"value": newValue},
 		IPAddress:  ip,
@@ -196,13 +284,111 @@ func LogSettingChange(key string, userID *uuid.UUID, oldValue, newValue any, ip,
 	})
 }
 
+// LogClientUpdate logs changed client fields and custom attributes
+func LogClientUpdate(clientID uuid.UUID, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	if len(oldValues) == 0 && len(newValues) == 0 {
+		return
+	}
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityClient,
+		EntityID:   clientID.String(),
+		Action:     ActionUpdate,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		OldValues:  oldValues,
+		NewValues:  newValues,
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
+// LogClientMerge logs source clients being merged into a target client.
+// actor is nil when the merge was performed automatically.
+func LogClientMerge(targetID uuid.UUID, sourceIDs []uuid.UUID, actor *auth.User, metadata map[string]any) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityClient,
+		EntityID:   targetID.String(),
+		Action:     ActionMerge,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		OldValues:  map[string]any{"source_client_ids": sourceIDs},
+		Metadata:   metadata,
+	})
+}
+
+// LogBlocklistChange logs a blocklist entry being created, updated or deleted
+func LogBlocklistChange(entryID uint, action string, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityBlocklist,
+		EntityID:   fmt.Sprintf("%d", entryID),
+		Action:     action,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		OldValues:  oldValues,
+		NewValues:  newValues,
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
+// LogSpamEventRevert logs a spam event being reverted
+func LogSpamEventRevert(eventID uint, actor *auth.User, allowSender bool, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntitySpamEvent,
+		EntityID:   fmt.Sprintf("%d", eventID),
+		Action:     ActionRevert,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		Metadata:   map[string]any{"allow_sender": allowSender},
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
+// LogCampaignChange logs a campaign being created, updated, deleted, started or cancelled
+func LogCampaignChange(campaignID uint, action string, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityCampaign,
+		EntityID:   fmt.Sprintf("%d", campaignID),
+		Action:     action,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		OldValues:  oldValues,
+		NewValues:  newValues,
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
+// LogClientOptOutChange logs an agent opting a client out of campaigns on a channel, or removing the opt-out
+func LogClientOptOutChange(clientID uuid.UUID, action string, actor *auth.User, channel, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityClient,
+		EntityID:   clientID.String(),
+		Action:     action,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		Metadata:   map[string]any{"campaign_opt_out": channel},
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
 // LogWebhookCreate logs a webhook creation
-func LogWebhookCreate(webhookID uint, userID *uuid.UUID, name string, ip, userAgent string) {
+func LogWebhookCreate(webhookID uint, actor *auth.User, name string, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	LogActivity(ActivityLogEntry{
 		EntityType: EntityWebhook,
 		EntityID:   fmt.Sprintf("%d", webhookID),
 		Action:     ActionCreate,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		NewValues:  map[string]any{"name": name},
 		IPAddress:  ip,
 		UserAgent:  userAgent,
@@ -210,12 +396,14 @@ func LogWebhookCreate(webhookID uint, userID *uuid.UUID, name string, ip, userAg
 }
 
 // LogWebhookUpdate logs a webhook update
-func LogWebhookUpdate(webhookID uint, userID *uuid.UUID, oldValues, newValues map[string]any, ip, userAgent string) {
+func LogWebhookUpdate(webhookID uint, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	LogActivity(ActivityLogEntry{
 		EntityType: EntityWebhook,
 		EntityID:   fmt.Sprintf("%d", webhookID),
 		Action:     ActionUpdate,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		OldValues:  oldValues,
 		NewValues:  newValues,
 		IPAddress:  ip,
@@ -224,24 +412,106 @@ func LogWebhookUpdate(webhookID uint, userID *uuid.UUID, oldValues, newValues ma
 }
 
 // LogWebhookDelete logs a webhook deletion
-func LogWebhookDelete(webhookID uint, userID *uuid.UUID, name string, ip, userAgent string) {
+func LogWebhookDelete(webhookID uint, actor *auth.User, name string, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
 	LogActivity(ActivityLogEntry{
 		EntityType: EntityWebhook,
 		EntityID:   fmt.Sprintf("%d", webhookID),
 		Action:     ActionDelete,
 		UserID:     userID,
+		APIKeyID:   apiKeyID,
 		OldValues:  map[string]any{"name": name},
 		IPAddress:  ip,
 		UserAgent:  userAgent,
 	})
 }
 
-// GetActivityLogs retrieves activity logs with filtering and pagination
-func GetActivityLogs(entityType, entityID, action string, userID *uuid.UUID, limit, offset int) ([]ActivityLog, int64, error) {
+// LogIntegrationChange logs an integration being configured or removed. Secrets are never included.
+func LogIntegrationChange(integrationType, action string, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityIntegration,
+		EntityID:   integrationType,
+		Action:     action,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		OldValues:  oldValues,
+		NewValues:  newValues,
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
+// LogAIAgentChange logs an AI agent being created, updated or deleted
+func LogAIAgentChange(agentID uint, action string, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityAIAgent,
+		EntityID:   fmt.Sprintf("%d", agentID),
+		Action:     action,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		OldValues:  oldValues,
+		NewValues:  newValues,
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
+// LogAIAgentToolChange logs a tool of an AI agent being created, updated or deleted
+func LogAIAgentToolChange(toolID, agentID uint, action string, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityAIAgentTool,
+		EntityID:   fmt.Sprintf("%d", toolID),
+		Action:     action,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		OldValues:  oldValues,
+		NewValues:  newValues,
+		Metadata:   map[string]any{"ai_agent_id": agentID},
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
+// LogRoleChange logs a role being created, updated or deleted
+func LogRoleChange(roleID uint, action string, actor *auth.User, oldValues, newValues map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityRole,
+		EntityID:   fmt.Sprintf("%d", roleID),
+		Action:     action,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		OldValues:  oldValues,
+		NewValues:  newValues,
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
+// LogActivityExport logs an export of the activity log with the filters it used
+func LogActivityExport(actor *auth.User, format string, filters map[string]any, ip, userAgent string) {
+	userID, apiKeyID := actorIDs(actor)
+	LogActivity(ActivityLogEntry{
+		EntityType: EntityActivityLog,
+		EntityID:   format,
+		Action:     ActionExport,
+		UserID:     userID,
+		APIKeyID:   apiKeyID,
+		Metadata:   filters,
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+	})
+}
+
+// GetActivityLogs retrieves activity logs of the workspace with filtering and pagination
+func GetActivityLogs(workspaceID uint, entityType, entityID, action string, userID *uuid.UUID, limit, offset int) ([]ActivityLog, int64, error) {
 	var logs []ActivityLog
 	var total int64
 
-	query := db.Model(&ActivityLog{})
+	query := WorkspaceDB(OrDefaultWorkspace(workspaceID)).Model(&ActivityLog{})
 
 	if entityType != "" {
 		query = query.Where("entity_type = ?", entityType)
@@ -280,15 +550,15 @@ func GetActivityLogs(entityType, entityID, action string, userID *uuid.UUID, lim
 	return logs, total, err
 }
 
-// GetEntityActivityLogs retrieves all activity logs for a specific entity
-func GetEntityActivityLogs(entityType, entityID string, limit int) ([]ActivityLog, error) {
+// GetEntityActivityLogs retrieves all activity logs of the workspace for a specific entity
+func GetEntityActivityLogs(workspaceID uint, entityType, entityID string, limit int) ([]ActivityLog, error) {
 	var logs []ActivityLog
 
 	if limit <= 0 {
 		limit = 50
 	}
 
-	err := db.Where("entity_type = ? AND entity_id = ?", entityType, entityID).
+	err := WorkspaceDB(OrDefaultWorkspace(workspaceID)).Where("entity_type = ? AND entity_id = ?", entityType, entityID).
 		Preload("User").
 		Order("created_at DESC").
 		Limit(limit).
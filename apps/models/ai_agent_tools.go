@@ -59,7 +59,7 @@ type AIAgentTool struct {
 	BodyParams           datatypes.JSON `gorm:"column:body_params;type:json" json:"body_params"`
 	AuthorizationType    string         `gorm:"column:authorization_type;size:20;default:'None'" json:"authorization_type"`
 	AuthorizationHeader  string         `gorm:"column:authorization_header;size:255" json:"authorization_header"`
-	AuthorizationValue   string         `gorm:"column:authorization_value;size:500" json:"authorization_value"`
+	AuthorizationValue   string         `gorm:"column:authorization_value;type:text" json:"authorization_value"` // encrypted at rest
 	ResponseInstructions string         `gorm:"column:response_instructions;type:text" json:"response_instructions"`
 	CreatedAt            time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
 	UpdatedAt            time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
@@ -26,6 +26,7 @@ const (
 
 type AIAgent struct {
 	ID                uint           `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID       uint           `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
 	Name              string         `gorm:"column:name;size:255;not null" json:"name"`
 	BotID             string         `gorm:"column:bot_id;size:255;not null;index" json:"bot_id"`
 	HandoverEnabled   bool           `gorm:"column:handover_enabled;not null;default:0" json:"handover_enabled"`
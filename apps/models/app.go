@@ -1,16 +1,40 @@
 package models
 
 import (
+	"os"
+
+	"github.com/getevo/evo/v2"
 	"github.com/getevo/evo/v2/lib/args"
 	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/lib/tenant"
 )
 
 type App struct{}
 
 func (a App) Register() error {
+	// Write API key, account and role events to the activity log
+	auth.APIKeyAuditLogger = LogAPIKeyEvent
+	auth.AccountAuditLogger = LogAccountEvent
+	auth.RoleAuditLogger = LogRoleChange
+
+	// Scope statements to the workspace of their context and resolve the workspace of API requests.
+	// The middleware is registered here so it runs before the routes of every app.
+	if err := db.Use(tenant.Plugin{}); err != nil {
+		return err
+	}
+	db.OnPrepareContext(scopeRequest)
+	evo.Use("/api", WorkspaceMiddleware)
+
 	// Register all models with GORM (auth models are now registered in auth app)
+	db.UseModel(Workspace{})
+	db.UseModel(Organization{})
+	db.UseModel(OrganizationDomain{})
+	db.UseModel(OrganizationNote{})
 	db.UseModel(Client{})
 	db.UseModel(ClientExternalID{})
+	db.UseModel(ClientDuplicateCandidate{})
 	db.UseModel(Department{})
 	db.UseModel(AIAgent{})
 	db.UseModel(AIAgentTool{})
@@ -26,7 +50,11 @@ func (a App) Register() error {
 	db.UseModel(CustomAttribute{})
 	db.UseModel(Webhook{})
 	db.UseModel(WebhookDelivery{})
+	db.UseModel(WebhookQueueItem{})
 	db.UseModel(CannedMessage{})
+	db.UseModel(CannedMessageVariant{})
+	db.UseModel(CannedMessageAttachment{})
+	db.UseModel(Macro{})
 
 	// Knowledge Base models for RAG
 	db.UseModel(KnowledgeBaseArticle{})
@@ -55,6 +83,18 @@ func (a App) Register() error {
 	// Email tracking model
 	db.UseModel(EmailMessage{})
 
+	// Blocklist and spam filtering models
+	db.UseModel(BlocklistEntry{})
+	db.UseModel(SpamEvent{})
+
+	// Broadcast campaign models
+	db.UseModel(Campaign{})
+	db.UseModel(CampaignDelivery{})
+	db.UseModel(ClientOptOut{})
+
+	// Scheduled agent messages
+	db.UseModel(ScheduledMessage{})
+
 	return nil
 }
 
@@ -68,6 +108,22 @@ func (a App) WhenReady() error {
 		if err != nil {
 			return err
 		}
+		if err := AlignChildWorkspaces(); err != nil {
+			log.Error("Failed to align workspaces of existing rows: %v", err)
+		}
+	}
+	if err := EnsureDefaultWorkspace(); err != nil {
+		log.Error("Failed to create the default workspace: %v", err)
+	}
+
+	// Encrypt plaintext credentials and move them to the active key after a key rotation
+	if args.Exists("--reencrypt-secrets") {
+		updated, err := ReencryptSecrets()
+		if err != nil {
+			log.Fatal("Failed to re-encrypt secrets: %v", err)
+		}
+		log.Info("Re-encrypted %d secrets", updated)
+		os.Exit(0)
 	}
 	return nil
 }
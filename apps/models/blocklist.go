@@ -0,0 +1,198 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Blocklist entry type constants
+const (
+	BlocklistTypeClient = "client"
+	BlocklistTypeEmail  = "email"
+	BlocklistTypeDomain = "domain"
+	BlocklistTypePhone  = "phone"
+	BlocklistTypeIP     = "ip"
+)
+
+// Blocklist entry action constants
+const (
+	BlocklistActionDrop  = "drop"
+	BlocklistActionSpam  = "spam"
+	BlocklistActionAllow = "allow"
+)
+
+// BlocklistTypes lists all valid blocklist entry types
+var BlocklistTypes = []string{BlocklistTypeClient, BlocklistTypeEmail, BlocklistTypeDomain, BlocklistTypePhone, BlocklistTypeIP}
+
+// BlocklistActions lists all valid blocklist entry actions
+var BlocklistActions = []string{BlocklistActionDrop, BlocklistActionSpam, BlocklistActionAllow}
+
+// BlocklistEntry blocks (or explicitly allows) inbound traffic from a client, email address, email domain,
+// phone number or IP address/CIDR range.
+// Drop discards inbound messages, spam delivers them into a conversation marked as spam and allow
+// exempts the sender from other entries and from heuristic spam scoring.
+type BlocklistEntry struct {
+	ID        uint       `gorm:"column:id;primaryKey" json:"id"`
+	Type      string     `gorm:"column:type;size:20;not null;uniqueIndex:idx_blocklist_type_value;check:type IN ('client','email','domain','phone','ip')" json:"type"`
+	Value     string     `gorm:"column:value;size:255;not null;uniqueIndex:idx_blocklist_type_value" json:"value"`
+	Action    string     `gorm:"column:action;size:20;not null;default:'drop';check:action IN ('drop','spam','allow')" json:"action"`
+	Reason    string     `gorm:"column:reason;size:500" json:"reason"`
+	IsActive  bool       `gorm:"column:is_active;default:1;index" json:"is_active"`
+	HitCount  uint       `gorm:"column:hit_count;default:0" json:"hit_count"`
+	LastHitAt *time.Time `gorm:"column:last_hit_at" json:"last_hit_at"`
+	CreatedBy *uuid.UUID `gorm:"column:created_by;type:char(36);fk:users" json:"created_by"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	restify.API
+}
+
+func (BlocklistEntry) TableName() string {
+	return "blocklist_entries"
+}
+
+// Normalize validates the entry and normalizes its value so that lookups can match exactly
+func (e *BlocklistEntry) Normalize() error {
+	if !slices.Contains(BlocklistTypes, e.Type) {
+		return fmt.Errorf("invalid type %q", e.Type)
+	}
+	if e.Action == "" {
+		e.Action = BlocklistActionDrop
+	}
+	if !slices.Contains(BlocklistActions, e.Action) {
+		return fmt.Errorf("invalid action %q", e.Action)
+	}
+
+	value := strings.TrimSpace(e.Value)
+	switch e.Type {
+	case BlocklistTypeClient:
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return fmt.Errorf("invalid client id %q", value)
+		}
+		value = id.String()
+	case BlocklistTypeEmail:
+		value = normalizeEmail(value)
+		if value == "" {
+			return fmt.Errorf("invalid email address %q", e.Value)
+		}
+	case BlocklistTypeDomain:
+		value = NormalizeDomain(value)
+		if err := ValidateDomain(value); err != nil {
+			return err
+		}
+	case BlocklistTypePhone:
+		value = normalizePhone(value)
+		if value == "" {
+			return fmt.Errorf("invalid phone number %q", e.Value)
+		}
+	case BlocklistTypeIP:
+		if _, network, err := net.ParseCIDR(value); err == nil {
+			value = network.String()
+		} else if ip := net.ParseIP(value); ip != nil {
+			value = ip.String()
+		} else {
+			return fmt.Errorf("invalid IP address or CIDR range %q", e.Value)
+		}
+	}
+	e.Value = value
+	return nil
+}
+
+// matchesIP reports whether an ip entry covers ip
+func (e *BlocklistEntry) matchesIP(ip net.IP) bool {
+	if _, network, err := net.ParseCIDR(e.Value); err == nil {
+		return network.Contains(ip)
+	}
+	return net.ParseIP(e.Value).Equal(ip)
+}
+
+// InboundSender identifies the sender of an inbound message. Any field may be empty.
+// When ClientID is nil it is resolved from ExternalIDType/ExternalIDValue if the client already exists.
+type InboundSender struct {
+	ClientID        *uuid.UUID
+	ExternalIDType  string
+	ExternalIDValue string
+	Email           string
+	Phone           string
+	IP              string
+}
+
+// CheckBlocklist returns the blocklist entry that applies to sender, or nil if none does.
+// Allow entries win over block entries and drop entries win over spam entries.
+// sender.ClientID is filled in when it can be resolved from the external ID.
+func CheckBlocklist(sender *InboundSender) *BlocklistEntry {
+	if sender.ClientID == nil && sender.ExternalIDType != "" && sender.ExternalIDValue != "" {
+		var externalID ClientExternalID
+		if err := db.Where("type = ? AND value = ?", sender.ExternalIDType, sender.ExternalIDValue).First(&externalID).Error; err == nil {
+			sender.ClientID = &externalID.ClientID
+		}
+	}
+
+	conditions := []string{}
+	var args []any
+	if sender.ClientID != nil {
+		conditions = append(conditions, "(type = ? AND value = ?)")
+		args = append(args, BlocklistTypeClient, sender.ClientID.String())
+	}
+	if email := normalizeEmail(sender.Email); email != "" {
+		conditions = append(conditions, "(type = ? AND value = ?)")
+		args = append(args, BlocklistTypeEmail, email)
+
+		// Match the domain and its parent domains (mail.example.com is covered by example.com)
+		var domains []string
+		labels := strings.Split(EmailDomain(email), ".")
+		for i := 0; i < len(labels)-1; i++ {
+			domains = append(domains, strings.Join(labels[i:], "."))
+		}
+		conditions = append(conditions, "(type = ? AND value IN ?)")
+		args = append(args, BlocklistTypeDomain, domains)
+	}
+	if phone := normalizePhone(sender.Phone); phone != "" {
+		conditions = append(conditions, "(type = ? AND value = ?)")
+		args = append(args, BlocklistTypePhone, phone)
+	}
+	ip := net.ParseIP(strings.TrimSpace(sender.IP))
+	if ip != nil {
+		// Ranges cannot be matched in SQL; ip entries are filtered below
+		conditions = append(conditions, "type = ?")
+		args = append(args, BlocklistTypeIP)
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	var entries []BlocklistEntry
+	if err := db.Where("is_active = ?", true).Where(strings.Join(conditions, " OR "), args...).Find(&entries).Error; err != nil {
+		log.Warning("Failed to check blocklist: %v", err)
+		return nil
+	}
+
+	var match *BlocklistEntry
+	rank := map[string]int{BlocklistActionSpam: 1, BlocklistActionDrop: 2, BlocklistActionAllow: 3}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Type == BlocklistTypeIP && !entry.matchesIP(ip) {
+			continue
+		}
+		if match == nil || rank[entry.Action] > rank[match.Action] {
+			match = entry
+		}
+	}
+	if match != nil {
+		db.Model(match).UpdateColumns(map[string]any{
+			"hit_count":   gorm.Expr("hit_count + 1"),
+			"last_hit_at": time.Now(),
+		})
+	}
+	return match
+}
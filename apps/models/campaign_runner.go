@@ -0,0 +1,231 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+)
+
+// campaignStuckTimeout is how long a delivery may stay in sending before it is considered lost
+const campaignStuckTimeout = 15 * time.Minute
+
+// Default campaign send rates per channel, overridable with the campaigns.<channel>.rate_per_minute setting
+var defaultCampaignRatePerMinute = map[string]int{
+	ExternalIDTypeEmail:    60,
+	ExternalIDTypeTelegram: 600,
+	ExternalIDTypeWhatsapp: 600,
+	ExternalIDTypeSlack:    60,
+}
+
+// CampaignRunResult summarizes one campaign runner pass
+type CampaignRunResult struct {
+	CampaignsStarted   int   `json:"campaigns_started"`
+	CampaignsCompleted int   `json:"campaigns_completed"`
+	Sent               int64 `json:"sent"`
+	Failed             int64 `json:"failed"`
+	Skipped            int64 `json:"skipped"`
+}
+
+var campaignRunnerMutex sync.Mutex
+
+// CampaignRatePerMinute returns the maximum number of campaign messages sent per minute on channel
+func CampaignRatePerMinute(channel string) int {
+	defaultRate := defaultCampaignRatePerMinute[channel]
+	if defaultRate == 0 {
+		defaultRate = 60
+	}
+	rate, err := strconv.Atoi(GetSettingValue("campaigns."+channel+".rate_per_minute", strconv.Itoa(defaultRate)))
+	if err != nil || rate <= 0 {
+		return defaultRate
+	}
+	return rate
+}
+
+// ProcessDueCampaigns starts scheduled campaigns that are due and sends pending deliveries,
+// throttled per channel, until all are sent or ctx is done. Only one pass runs at a time;
+// a concurrent call returns immediately with an empty result.
+func ProcessDueCampaigns(ctx context.Context) (*CampaignRunResult, error) {
+	result := &CampaignRunResult{}
+	if !campaignRunnerMutex.TryLock() {
+		return result, nil
+	}
+	defer campaignRunnerMutex.Unlock()
+
+	var due []Campaign
+	if err := db.Where("status = ? AND scheduled_at <= ?", CampaignStatusScheduled, time.Now()).Find(&due).Error; err != nil {
+		return result, err
+	}
+	for i := range due {
+		if err := StartCampaign(&due[i]); err != nil {
+			logCampaignError(due[i].ID, "failed to start: %v", err)
+			continue
+		}
+		result.CampaignsStarted++
+	}
+
+	// A delivery left in sending was interrupted mid-send; it may or may not have gone out, so it is not retried
+	if err := db.Model(&CampaignDelivery{}).
+		Where("status = ? AND updated_at < ?", CampaignDeliveryStatusSending, time.Now().Add(-campaignStuckTimeout)).
+		Updates(map[string]any{"status": CampaignDeliveryStatusFailed, "error": "delivery interrupted"}).Error; err != nil {
+		log.Warning("[campaigns] failed to reset interrupted deliveries: %v", err)
+	}
+
+	var campaigns []Campaign
+	if err := db.Where("status = ?", CampaignStatusSending).Order("started_at ASC").Find(&campaigns).Error; err != nil {
+		return result, err
+	}
+
+	byChannel := map[string][]Campaign{}
+	for _, campaign := range campaigns {
+		byChannel[campaign.Channel] = append(byChannel[campaign.Channel], campaign)
+	}
+
+	var wg sync.WaitGroup
+	for channel, channelCampaigns := range byChannel {
+		wg.Add(1)
+		go func(channel string, channelCampaigns []Campaign) {
+			defer wg.Done()
+			sendChannelCampaigns(ctx, channel, channelCampaigns, result)
+		}(channel, channelCampaigns)
+	}
+	wg.Wait()
+
+	for _, campaign := range campaigns {
+		var remaining int64
+		db.Model(&CampaignDelivery{}).
+			Where("campaign_id = ? AND status IN ?", campaign.ID, []string{CampaignDeliveryStatusPending, CampaignDeliveryStatusSending}).
+			Count(&remaining)
+		if remaining > 0 {
+			continue
+		}
+		now := time.Now()
+		if db.Model(&Campaign{}).Where("id = ? AND status = ?", campaign.ID, CampaignStatusSending).
+			Updates(map[string]any{"status": CampaignStatusCompleted, "completed_at": now}).RowsAffected > 0 {
+			result.CampaignsCompleted++
+		}
+	}
+
+	return result, nil
+}
+
+// sendChannelCampaigns sends the pending deliveries of campaigns sharing one channel, oldest campaign first
+func sendChannelCampaigns(ctx context.Context, channel string, campaigns []Campaign, result *CampaignRunResult) {
+	ticker := time.NewTicker(time.Minute / time.Duration(CampaignRatePerMinute(channel)))
+	defer ticker.Stop()
+
+	for i := range campaigns {
+		campaign := &campaigns[i]
+		for {
+			var delivery CampaignDelivery
+			if err := db.Where("campaign_id = ? AND status = ?", campaign.ID, CampaignDeliveryStatusPending).
+				Order("id ASC").First(&delivery).Error; err != nil {
+				break
+			}
+
+			// Claim the delivery; another runner or a cancellation may have changed it
+			claimed := db.Model(&CampaignDelivery{}).
+				Where("id = ? AND status = ?", delivery.ID, CampaignDeliveryStatusPending).
+				Update("status", CampaignDeliveryStatusSending).RowsAffected
+			if claimed == 0 {
+				continue
+			}
+
+			status, providerID, sendErr := sendCampaignDelivery(campaign, &delivery)
+			updates := map[string]any{"status": status, "provider_message_id": providerID}
+			switch status {
+			case CampaignDeliveryStatusSent:
+				updates["sent_at"] = time.Now()
+				atomic.AddInt64(&result.Sent, 1)
+			case CampaignDeliveryStatusSkipped:
+				atomic.AddInt64(&result.Skipped, 1)
+			default:
+				atomic.AddInt64(&result.Failed, 1)
+			}
+			if sendErr != nil {
+				updates["error"] = truncateCampaignError(sendErr.Error())
+			}
+			if err := db.Model(&delivery).Updates(updates).Error; err != nil {
+				logCampaignError(campaign.ID, "failed to update delivery %d: %v", delivery.ID, err)
+			}
+
+			if status == CampaignDeliveryStatusSkipped {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// sendCampaignDelivery sends one campaign message and returns the resulting delivery status
+func sendCampaignDelivery(campaign *Campaign, delivery *CampaignDelivery) (string, string, error) {
+	var client Client
+	if err := db.Preload("ExternalIDs").Where("id = ?", delivery.ClientID).First(&client).Error; err != nil {
+		return CampaignDeliveryStatusSkipped, "", fmt.Errorf("client no longer exists")
+	}
+	if IsClientOptedOut(client.ID, campaign.Channel) {
+		return CampaignDeliveryStatusSkipped, "", fmt.Errorf("client opted out")
+	}
+
+	body := renderCampaignBody(campaign.Body, &client)
+
+	var providerID string
+	var err error
+	switch campaign.Channel {
+	case ExternalIDTypeEmail:
+		unsubscribeURL := CampaignUnsubscribeURL(delivery.UnsubscribeToken)
+		if unsubscribeURL == "" {
+			return CampaignDeliveryStatusFailed, "", fmt.Errorf("APP.API_BASE_URL is not configured, cannot build unsubscribe link")
+		}
+		if SendCampaignEmail == nil {
+			return CampaignDeliveryStatusFailed, "", fmt.Errorf("campaign email sending is not available")
+		}
+		providerID, err = SendCampaignEmail(campaign.WorkspaceID, delivery.Recipient, renderCampaignBody(campaign.Subject, &client), body, unsubscribeURL)
+	case ExternalIDTypeTelegram:
+		if SendTelegramMessage == nil {
+			return CampaignDeliveryStatusFailed, "", fmt.Errorf("Telegram sending is not available")
+		}
+		err = SendTelegramMessage(client.WorkspaceID, delivery.Recipient, body)
+	case ExternalIDTypeSlack:
+		if SendSlackMessage == nil {
+			return CampaignDeliveryStatusFailed, "", fmt.Errorf("Slack sending is not available")
+		}
+		err = SendSlackMessage(client.WorkspaceID, delivery.Recipient, body)
+	case ExternalIDTypeWhatsapp:
+		template := campaign.ParseWhatsAppTemplate()
+		if template == nil || SendWhatsAppTemplate == nil {
+			return CampaignDeliveryStatusFailed, "", fmt.Errorf("WhatsApp template sending is not available")
+		}
+		parameters := make([]string, len(template.Parameters))
+		for i, parameter := range template.Parameters {
+			parameters[i] = renderCampaignBody(parameter, &client)
+		}
+		template.Parameters = parameters
+		err = SendWhatsAppTemplate(client.WorkspaceID, delivery.Recipient, *template)
+	default:
+		return CampaignDeliveryStatusFailed, "", fmt.Errorf("unsupported channel %s", campaign.Channel)
+	}
+	if err != nil {
+		return CampaignDeliveryStatusFailed, "", err
+	}
+	return CampaignDeliveryStatusSent, providerID, nil
+}
+
+func truncateCampaignError(message string) string {
+	if len(message) > 1000 {
+		return message[:1000]
+	}
+	return message
+}
@@ -0,0 +1,446 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Campaign status constants
+const (
+	CampaignStatusDraft     = "draft"
+	CampaignStatusScheduled = "scheduled"
+	CampaignStatusSending   = "sending"
+	CampaignStatusCompleted = "completed"
+	CampaignStatusCancelled = "cancelled"
+)
+
+// Campaign delivery status constants
+const (
+	CampaignDeliveryStatusPending = "pending"
+	CampaignDeliveryStatusSending = "sending"
+	CampaignDeliveryStatusSent    = "sent"
+	CampaignDeliveryStatusFailed  = "failed"
+	CampaignDeliveryStatusSkipped = "skipped"
+)
+
+// Opt-out source constants
+const (
+	OptOutSourceUnsubscribeLink = "unsubscribe_link"
+	OptOutSourceKeyword         = "keyword"
+	OptOutSourceAgent           = "agent"
+)
+
+// CampaignReplyWindow is how long after a delivery an inbound message counts as a reply to the campaign
+const CampaignReplyWindow = 14 * 24 * time.Hour
+
+var (
+	// ErrCampaignNotEditable is returned when a campaign that already started is modified
+	ErrCampaignNotEditable = errors.New("campaign can only be changed while it is a draft or scheduled")
+	// ErrUnsubscribeTokenInvalid is returned when an unsubscribe token does not exist
+	ErrUnsubscribeTokenInvalid = errors.New("invalid unsubscribe token")
+)
+
+// RenderCampaignMessage replaces placeholders in a campaign body for a client - set by the conversation package
+var RenderCampaignMessage func(body string, client *Client) string
+
+// SendCampaignEmail sends a campaign email of the workspace with an unsubscribe link and returns its Message-ID - set by the email package
+var SendCampaignEmail func(workspaceID uint, to, subject, body, unsubscribeURL string) (string, error)
+
+// Campaign is a one-off or scheduled announcement to a segment of clients on a single channel
+type Campaign struct {
+	ID               uint           `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID      uint           `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	Name             string         `gorm:"column:name;size:255;not null" json:"name"`
+	Channel          string         `gorm:"column:channel;size:20;not null;index;check:channel IN ('email','telegram','whatsapp','slack')" json:"channel"`
+	Subject          string         `gorm:"column:subject;size:500" json:"subject"`
+	Body             string         `gorm:"column:body;type:text;not null" json:"body"`
+	WhatsAppTemplate datatypes.JSON `gorm:"column:whatsapp_template;type:json" json:"whatsapp_template,omitempty"`
+	Segment          datatypes.JSON `gorm:"column:segment;type:json" json:"segment"`
+	Status           string         `gorm:"column:status;size:20;not null;default:'draft';index;check:status IN ('draft','scheduled','sending','completed','cancelled')" json:"status"`
+	ScheduledAt      *time.Time     `gorm:"column:scheduled_at;index" json:"scheduled_at"`
+	StartedAt        *time.Time     `gorm:"column:started_at" json:"started_at"`
+	CompletedAt      *time.Time     `gorm:"column:completed_at" json:"completed_at"`
+	CreatedBy        *uuid.UUID     `gorm:"column:created_by;type:char(36);fk:users" json:"created_by"`
+	CreatedAt        time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	restify.API
+}
+
+func (Campaign) TableName() string {
+	return "campaigns"
+}
+
+// CampaignDelivery tracks sending a campaign to one client.
+// ClientID has no foreign key so delivery history survives client merges and deletions.
+type CampaignDelivery struct {
+	ID                uint       `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID       uint       `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	CampaignID        uint       `gorm:"column:campaign_id;not null;uniqueIndex:idx_campaign_delivery_client;index:idx_campaign_delivery_status,priority:1;fk:campaigns" json:"campaign_id"`
+	ClientID          uuid.UUID  `gorm:"column:client_id;type:char(36);not null;uniqueIndex:idx_campaign_delivery_client;index" json:"client_id"`
+	Recipient         string     `gorm:"column:recipient;size:255;not null" json:"recipient"`
+	Status            string     `gorm:"column:status;size:20;not null;default:'pending';index:idx_campaign_delivery_status,priority:2" json:"status"`
+	Error             string     `gorm:"column:error;size:1000" json:"error,omitempty"`
+	ProviderMessageID string     `gorm:"column:provider_message_id;size:500;index" json:"provider_message_id,omitempty"`
+	UnsubscribeToken  string     `gorm:"column:unsubscribe_token;size:64;uniqueIndex" json:"-"`
+	ConversationID    *uint      `gorm:"column:conversation_id;index" json:"conversation_id"`
+	SentAt            *time.Time `gorm:"column:sent_at" json:"sent_at"`
+	RepliedAt         *time.Time `gorm:"column:replied_at" json:"replied_at"`
+	OptedOutAt        *time.Time `gorm:"column:opted_out_at" json:"opted_out_at"`
+	CreatedAt         time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	// Relationships
+	Client *Client `gorm:"foreignKey:ClientID;references:ID" json:"client,omitempty"`
+
+	restify.API
+}
+
+func (CampaignDelivery) TableName() string {
+	return "campaign_deliveries"
+}
+
+// BeforeCreate hook - a delivery belongs to the workspace of its campaign
+func (d *CampaignDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.WorkspaceID == 0 {
+		d.WorkspaceID = workspaceOf(tx, &Campaign{}, d.CampaignID)
+	}
+	return nil
+}
+
+// ClientOptOut records that a client does not want to receive campaigns on a channel
+type ClientOptOut struct {
+	ID          uint      `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID uint      `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	ClientID    uuid.UUID `gorm:"column:client_id;type:char(36);not null;uniqueIndex:idx_client_opt_out_channel" json:"client_id"`
+	Channel     string    `gorm:"column:channel;size:20;not null;uniqueIndex:idx_client_opt_out_channel" json:"channel"`
+	Source      string    `gorm:"column:source;size:20;not null" json:"source"`
+	CampaignID  *uint     `gorm:"column:campaign_id;index" json:"campaign_id"`
+	CreatedAt   time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+
+	// Relationships
+	Client *Client `gorm:"foreignKey:ClientID;references:ID" json:"client,omitempty"`
+
+	restify.API
+}
+
+func (ClientOptOut) TableName() string {
+	return "client_opt_outs"
+}
+
+// BeforeCreate hook - an opt-out belongs to the workspace of its client
+func (o *ClientOptOut) BeforeCreate(tx *gorm.DB) error {
+	if o.WorkspaceID == 0 {
+		o.WorkspaceID = workspaceOf(tx, &Client{}, o.ClientID)
+	}
+	return nil
+}
+
+// CampaignSegment selects the clients a campaign is sent to. Empty fields do not filter.
+// Clients always need an external ID on the campaign channel and must not have opted out of it.
+type CampaignSegment struct {
+	Attributes        map[string][]string `json:"attributes,omitempty"` // Client custom attribute name -> accepted values
+	Languages         []string            `json:"languages,omitempty"`
+	OrganizationIDs   []uint              `json:"organization_ids,omitempty"`
+	LastContactAfter  *time.Time          `json:"last_contact_after,omitempty"`  // Client's last inbound message is at or after this time
+	LastContactBefore *time.Time          `json:"last_contact_before,omitempty"` // Client's last inbound message is at or before this time
+}
+
+// CampaignStats summarizes the deliveries of a campaign
+type CampaignStats struct {
+	Total    int64 `json:"total"`
+	Pending  int64 `json:"pending"`
+	Sent     int64 `json:"sent"`
+	Failed   int64 `json:"failed"`
+	Skipped  int64 `json:"skipped"`
+	Replied  int64 `json:"replied"`
+	OptedOut int64 `json:"opted_out"`
+}
+
+var attributeNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ParseSegment decodes the campaign segment
+func (c *Campaign) ParseSegment() (CampaignSegment, error) {
+	var segment CampaignSegment
+	if len(c.Segment) == 0 {
+		return segment, nil
+	}
+	err := json.Unmarshal(c.Segment, &segment)
+	return segment, err
+}
+
+// ParseWhatsAppTemplate decodes the campaign WhatsApp template, or returns nil if none is set
+func (c *Campaign) ParseWhatsAppTemplate() *WhatsAppTemplate {
+	if len(c.WhatsAppTemplate) == 0 || string(c.WhatsAppTemplate) == "null" {
+		return nil
+	}
+	var template WhatsAppTemplate
+	if err := json.Unmarshal(c.WhatsAppTemplate, &template); err != nil || template.Name == "" {
+		return nil
+	}
+	return &template
+}
+
+// Validate checks the campaign content for its channel
+func (c *Campaign) Validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !slices.Contains(OutboundChannels, c.Channel) {
+		return fmt.Errorf("channel must be one of: %s", strings.Join(OutboundChannels, ", "))
+	}
+	switch c.Channel {
+	case ExternalIDTypeEmail:
+		if strings.TrimSpace(c.Subject) == "" {
+			return fmt.Errorf("subject is required for email campaigns")
+		}
+	case ExternalIDTypeWhatsapp:
+		// Campaigns are business-initiated, which WhatsApp only allows with approved templates
+		if c.ParseWhatsAppTemplate() == nil {
+			return fmt.Errorf("whatsapp_template is required for WhatsApp campaigns")
+		}
+	}
+	if c.Channel != ExternalIDTypeWhatsapp && strings.TrimSpace(c.Body) == "" {
+		return fmt.Errorf("body is required")
+	}
+	segment, err := c.ParseSegment()
+	if err != nil {
+		return fmt.Errorf("invalid segment: %w", err)
+	}
+	return segment.Validate()
+}
+
+// Validate checks the segment filters
+func (s CampaignSegment) Validate() error {
+	for name := range s.Attributes {
+		if !attributeNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid attribute name %q", name)
+		}
+	}
+	if s.LastContactAfter != nil && s.LastContactBefore != nil && s.LastContactAfter.After(*s.LastContactBefore) {
+		return fmt.Errorf("last_contact_after must be before last_contact_before")
+	}
+	return nil
+}
+
+// SegmentClientsQuery returns a query over the clients of the workspace of tx matching segment that can be
+// reached on channel. tx must be scoped to a workspace, e.g. db.GetContext(request) or WorkspaceDB.
+func SegmentClientsQuery(tx *gorm.DB, channel string, segment CampaignSegment) *gorm.DB {
+	subquery := tx.Session(&gorm.Session{NewDB: true})
+	query := tx.Session(&gorm.Session{NewDB: true}).Model(&Client{}).
+		Where("clients.id IN (?)", subquery.Model(&ClientExternalID{}).Select("client_id").Where("type = ?", channel)).
+		Where("clients.id NOT IN (?)", subquery.Model(&ClientOptOut{}).Select("client_id").Where("channel = ?", channel))
+
+	for name, values := range segment.Attributes {
+		if len(values) == 0 {
+			continue
+		}
+		query = query.Where("JSON_UNQUOTE(JSON_EXTRACT(clients.data, ?)) IN ?", "$."+name, values)
+	}
+	if len(segment.Languages) > 0 {
+		query = query.Where("clients.language IN ?", segment.Languages)
+	}
+	if len(segment.OrganizationIDs) > 0 {
+		query = query.Where("clients.organization_id IN ?", segment.OrganizationIDs)
+	}
+
+	lastContact := "(SELECT MAX(messages.created_at) FROM messages WHERE messages.client_id = clients.id AND messages.user_id IS NULL)"
+	if segment.LastContactAfter != nil {
+		query = query.Where(lastContact+" >= ?", *segment.LastContactAfter)
+	}
+	if segment.LastContactBefore != nil {
+		query = query.Where(lastContact+" <= ?", *segment.LastContactBefore)
+	}
+	return query
+}
+
+// GetCampaignStats counts the deliveries of a campaign by outcome
+func GetCampaignStats(campaignID uint) (CampaignStats, error) {
+	var stats CampaignStats
+	err := db.Model(&CampaignDelivery{}).
+		Select(`COUNT(*) AS total,
+			COALESCE(SUM(status IN ('pending','sending')), 0) AS pending,
+			COALESCE(SUM(status = 'sent'), 0) AS sent,
+			COALESCE(SUM(status = 'failed'), 0) AS failed,
+			COALESCE(SUM(status = 'skipped'), 0) AS skipped,
+			COALESCE(SUM(replied_at IS NOT NULL), 0) AS replied,
+			COALESCE(SUM(opted_out_at IS NOT NULL), 0) AS opted_out`).
+		Where("campaign_id = ?", campaignID).
+		Scan(&stats).Error
+	return stats, err
+}
+
+// StartCampaign snapshots the campaign segment, among the clients of the campaign's workspace, into pending
+// deliveries and sets the campaign to sending
+func StartCampaign(campaign *Campaign) error {
+	segment, err := campaign.ParseSegment()
+	if err != nil {
+		return err
+	}
+
+	workspaceDB := WorkspaceDB(OrDefaultWorkspace(campaign.WorkspaceID))
+	var clients []Client
+	if err := SegmentClientsQuery(workspaceDB, campaign.Channel, segment).Preload("ExternalIDs", "type = ?", campaign.Channel).Find(&clients).Error; err != nil {
+		return err
+	}
+
+	deliveries := make([]CampaignDelivery, 0, len(clients))
+	for _, client := range clients {
+		if len(client.ExternalIDs) == 0 {
+			continue
+		}
+		deliveries = append(deliveries, CampaignDelivery{
+			CampaignID:       campaign.ID,
+			ClientID:         client.ID,
+			Recipient:        client.ExternalIDs[0].Value,
+			Status:           CampaignDeliveryStatusPending,
+			UnsubscribeToken: generateUnsubscribeToken(),
+		})
+	}
+
+	now := time.Now()
+	return workspaceDB.Transaction(func(tx *gorm.DB) error {
+		if len(deliveries) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&deliveries, 500).Error; err != nil {
+				return err
+			}
+		}
+		campaign.Status = CampaignStatusSending
+		campaign.StartedAt = &now
+		return tx.Model(campaign).Updates(map[string]any{
+			"status":     CampaignStatusSending,
+			"started_at": now,
+		}).Error
+	})
+}
+
+// CancelCampaign stops a campaign; deliveries that were not sent yet are skipped
+func CancelCampaign(campaign *Campaign) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&CampaignDelivery{}).
+			Where("campaign_id = ? AND status = ?", campaign.ID, CampaignDeliveryStatusPending).
+			Updates(map[string]any{"status": CampaignDeliveryStatusSkipped, "error": "campaign cancelled"}).Error; err != nil {
+			return err
+		}
+		campaign.Status = CampaignStatusCancelled
+		return tx.Model(campaign).Update("status", CampaignStatusCancelled).Error
+	})
+}
+
+// CampaignUnsubscribeURL returns the public unsubscribe link for a delivery, or "" if APP.API_BASE_URL is not configured
+func CampaignUnsubscribeURL(token string) string {
+	baseURL := strings.TrimRight(settings.Get("APP.API_BASE_URL").String(), "/")
+	if baseURL == "" {
+		return ""
+	}
+	return baseURL + "/api/client/campaigns/unsubscribe/" + token
+}
+
+// OptOutClient stops campaigns to the client on channel. Opting out twice is not an error.
+func OptOutClient(clientID uuid.UUID, channel, source string, campaignID *uint) error {
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&ClientOptOut{
+		ClientID:   clientID,
+		Channel:    channel,
+		Source:     source,
+		CampaignID: campaignID,
+	}).Error
+}
+
+// IsClientOptedOut reports whether the client opted out of campaigns on channel
+func IsClientOptedOut(clientID uuid.UUID, channel string) bool {
+	var count int64
+	db.Model(&ClientOptOut{}).Where("client_id = ? AND channel = ?", clientID, channel).Count(&count)
+	return count > 0
+}
+
+// UnsubscribeByToken opts the client of a delivery out of the campaign channel
+func UnsubscribeByToken(token string) (*CampaignDelivery, error) {
+	var delivery CampaignDelivery
+	if token == "" || db.Where("unsubscribe_token = ?", token).First(&delivery).Error != nil {
+		return nil, ErrUnsubscribeTokenInvalid
+	}
+
+	var campaign Campaign
+	if err := db.First(&campaign, delivery.CampaignID).Error; err != nil {
+		return nil, err
+	}
+	if err := OptOutClient(delivery.ClientID, campaign.Channel, OptOutSourceUnsubscribeLink, &campaign.ID); err != nil {
+		return nil, err
+	}
+	if delivery.OptedOutAt == nil {
+		now := time.Now()
+		delivery.OptedOutAt = &now
+		db.Model(&delivery).UpdateColumn("opted_out_at", now)
+	}
+	return &delivery, nil
+}
+
+// IsOptOutKeyword reports whether an inbound message asks to stop campaign messages
+func IsOptOutKeyword(text string) bool {
+	switch strings.ToUpper(strings.Trim(strings.TrimSpace(text), ".!")) {
+	case "STOP", "UNSUBSCRIBE", "STOP ALL", "OPT OUT", "OPTOUT":
+		return true
+	}
+	return false
+}
+
+// RecordCampaignReply marks the client's most recent campaign delivery on channel as replied when the
+// inbound message arrives within CampaignReplyWindow, and notes the campaign in the conversation
+func RecordCampaignReply(clientID uuid.UUID, channel string, conversationID uint) {
+	var delivery CampaignDelivery
+	err := db.Joins("JOIN campaigns ON campaigns.id = campaign_deliveries.campaign_id").
+		Where("campaign_deliveries.client_id = ? AND campaigns.channel = ?", clientID, channel).
+		Where("campaign_deliveries.status = ? AND campaign_deliveries.replied_at IS NULL", CampaignDeliveryStatusSent).
+		Where("campaign_deliveries.sent_at > ?", time.Now().Add(-CampaignReplyWindow)).
+		Order("campaign_deliveries.sent_at DESC").
+		First(&delivery).Error
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	db.Model(&delivery).UpdateColumns(map[string]any{
+		"replied_at":      now,
+		"conversation_id": conversationID,
+	})
+
+	var campaign Campaign
+	if db.First(&campaign, delivery.CampaignID).Error == nil {
+		CreateActionMessage(conversationID, nil, "", fmt.Sprintf("Client replied to campaign \"%s\"", campaign.Name))
+	}
+}
+
+func generateUnsubscribeToken() string {
+	bytes := make([]byte, 24)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// renderCampaignBody renders placeholders in the campaign body for client
+func renderCampaignBody(body string, client *Client) string {
+	if RenderCampaignMessage == nil || client == nil {
+		return body
+	}
+	return RenderCampaignMessage(body, client)
+}
+
+// logCampaignError logs a campaign runner error
+func logCampaignError(campaignID uint, format string, args ...any) {
+	log.Error("[campaigns] campaign %d: %s", campaignID, fmt.Sprintf(format, args...))
+}
@@ -1,18 +1,132 @@
 package models
 
 import (
-	"github.com/getevo/restify"
+	"strings"
 	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+// CannedMessage scope constants
+const (
+	CannedMessageScopePersonal   = "personal"
+	CannedMessageScopeDepartment = "department"
+	CannedMessageScopeGlobal     = "global"
+)
+
+// CannedMessage is a reusable reply agents can insert into a conversation.
+// The message body may contain placeholders (e.g. {{client.name}}, {{agent.name}}, {{ticket.ref}})
+// that are rendered server-side for a specific conversation.
+//
+// Scope controls who can see and use the response:
+// - personal: only the owner (OwnerID)
+// - department: members of DepartmentID
+// - global: everyone
 type CannedMessage struct {
-	ID        uint      `gorm:"column:id;primaryKey" json:"id"`
-	Title     string    `gorm:"column:title;size:255;not null" json:"title"`
-	Message   string    `gorm:"column:message;type:text;not null" json:"message"`
-	Shortcut  *string   `gorm:"column:shortcut;size:50;uniqueIndex" json:"shortcut"`
-	IsActive  bool      `gorm:"column:is_active;default:1" json:"is_active"`
-	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+	ID           uint       `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID  uint       `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	Title        string     `gorm:"column:title;size:255;not null" json:"title"`
+	Message      string     `gorm:"column:message;type:text;not null" json:"message"`
+	Shortcut     *string    `gorm:"column:shortcut;size:50;index" json:"shortcut"`
+	Scope        string     `gorm:"column:scope;size:20;not null;default:'global';index;check:scope IN ('personal','department','global')" json:"scope"`
+	OwnerID      *uuid.UUID `gorm:"column:owner_id;type:char(36);index;fk:users" json:"owner_id"`
+	DepartmentID *uint      `gorm:"column:department_id;index;fk:departments" json:"department_id"`
+	IsActive     bool       `gorm:"column:is_active;default:1" json:"is_active"`
+	UsageCount   uint       `gorm:"column:usage_count;default:0" json:"usage_count"`
+	LastUsedAt   *time.Time `gorm:"column:last_used_at;index" json:"last_used_at"`
+	CreatedAt    time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	// Relationships
+	Variants    []CannedMessageVariant    `gorm:"foreignKey:CannedMessageID;references:ID" json:"variants,omitempty"`
+	Attachments []CannedMessageAttachment `gorm:"foreignKey:CannedMessageID;references:ID" json:"attachments,omitempty"`
+	Department  *Department               `gorm:"foreignKey:DepartmentID;references:ID" json:"department,omitempty"`
 
 	restify.API
 }
+
+// CannedMessageVariant is a translated version of a canned message for a specific language
+type CannedMessageVariant struct {
+	ID              uint      `gorm:"column:id;primaryKey" json:"id"`
+	CannedMessageID uint      `gorm:"column:canned_message_id;not null;uniqueIndex:idx_canned_variant_language;fk:canned_messages" json:"canned_message_id"`
+	Language        string    `gorm:"column:language;size:10;not null;uniqueIndex:idx_canned_variant_language" json:"language"`
+	Message         string    `gorm:"column:message;type:text;not null" json:"message"`
+	CreatedAt       time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	restify.API
+}
+
+func (CannedMessageVariant) TableName() string {
+	return "canned_message_variants"
+}
+
+// CannedMessageAttachment is a file sent along with a canned message (uploaded to storage beforehand)
+type CannedMessageAttachment struct {
+	ID              uint      `gorm:"column:id;primaryKey" json:"id"`
+	CannedMessageID uint      `gorm:"column:canned_message_id;not null;index;fk:canned_messages" json:"canned_message_id"`
+	Name            string    `gorm:"column:name;size:255;not null" json:"name"`
+	URL             string    `gorm:"column:url;size:500;not null" json:"url"`
+	ContentType     string    `gorm:"column:content_type;size:100" json:"content_type"`
+	Size            int64     `gorm:"column:size;default:0" json:"size"`
+	CreatedAt       time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+
+	restify.API
+}
+
+func (CannedMessageAttachment) TableName() string {
+	return "canned_message_attachments"
+}
+
+// MessageForLanguage returns the body to use for a client speaking language.
+// Exact matches win, then the base language (e.g. "pt" for "pt-BR"), then the default message.
+// Variants must be loaded.
+func (c CannedMessage) MessageForLanguage(language string) (string, string) {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if language == "" {
+		return c.Message, ""
+	}
+
+	base, _, _ := strings.Cut(language, "-")
+	var baseMatch *CannedMessageVariant
+	for i, variant := range c.Variants {
+		variantLang := strings.ToLower(variant.Language)
+		if variantLang == language {
+			return variant.Message, variant.Language
+		}
+		if baseMatch == nil && (variantLang == base || strings.HasPrefix(variantLang, base+"-")) {
+			baseMatch = &c.Variants[i]
+		}
+	}
+	if baseMatch != nil {
+		return baseMatch.Message, baseMatch.Language
+	}
+	return c.Message, ""
+}
+
+// RecordCannedMessageUsage increments the usage counter of a canned message and stamps its last use
+func RecordCannedMessageUsage(id uint) error {
+	return db.Model(&CannedMessage{}).Where("id = ?", id).Updates(map[string]any{
+		"usage_count":  gorm.Expr("usage_count + 1"),
+		"last_used_at": time.Now(),
+	}).Error
+}
+
+// DeleteCannedMessages deletes canned messages together with their variants and attachments
+func DeleteCannedMessages(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("canned_message_id IN ?", ids).Delete(&CannedMessageVariant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("canned_message_id IN ?", ids).Delete(&CannedMessageAttachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&CannedMessage{}).Error
+	})
+}
@@ -0,0 +1,427 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Client duplicate candidate status constants
+const (
+	ClientDuplicateStatusPending   = "pending"
+	ClientDuplicateStatusMerged    = "merged"
+	ClientDuplicateStatusDismissed = "dismissed"
+)
+
+// Duplicate signal constants, reported in ClientDuplicateCandidate.Reasons
+const (
+	DuplicateSignalEmail              = "email"
+	DuplicateSignalPhone              = "phone"
+	DuplicateSignalName               = "name"
+	DuplicateSignalSharedConversation = "shared_conversations"
+)
+
+// SettingKeyClientAutoMergeThreshold is the score (0-1) at or above which duplicates are merged
+// automatically. Empty or 0 disables auto-merge.
+const SettingKeyClientAutoMergeThreshold = "clients.auto_merge_threshold"
+
+// ClientDuplicateMinScore is the minimum score for a pair to be queued for review
+const ClientDuplicateMinScore = 0.4
+
+// maxDuplicateCandidates caps how many clients are scored for a single client
+const maxDuplicateCandidates = 50
+
+var nonDigitRegex = regexp.MustCompile(`[^0-9]`)
+
+// ClientDuplicateCandidate is a pair of clients that probably belong to the same person.
+// ClientID is always the older client, which is kept when the pair is merged.
+// Resolved candidates are kept as an audit trail after the duplicate has been deleted,
+// so the pair columns carry no foreign keys.
+type ClientDuplicateCandidate struct {
+	ID          uint           `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID uint           `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	ClientID    uuid.UUID      `gorm:"column:client_id;type:char(36);not null;uniqueIndex:idx_client_duplicate_pair" json:"client_id"`
+	DuplicateID uuid.UUID      `gorm:"column:duplicate_id;type:char(36);not null;uniqueIndex:idx_client_duplicate_pair;index" json:"duplicate_id"`
+	Score       float64        `gorm:"column:score;not null;index" json:"score"`
+	Reasons     datatypes.JSON `gorm:"column:reasons;type:json" json:"reasons"`
+	Status      string         `gorm:"column:status;size:20;not null;default:'pending';index;check:status IN ('pending','merged','dismissed')" json:"status"`
+	AutoMerged  bool           `gorm:"column:auto_merged;default:0" json:"auto_merged"`
+	ResolvedBy  *uuid.UUID     `gorm:"column:resolved_by;type:char(36);fk:users" json:"resolved_by"`
+	ResolvedAt  *time.Time     `gorm:"column:resolved_at" json:"resolved_at"`
+	CreatedAt   time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	// Relationships
+	Client    *Client `gorm:"foreignKey:ClientID;references:ID" json:"client,omitempty"`
+	Duplicate *Client `gorm:"foreignKey:DuplicateID;references:ID" json:"duplicate,omitempty"`
+
+	restify.API
+}
+
+func (ClientDuplicateCandidate) TableName() string {
+	return "client_duplicate_candidates"
+}
+
+// DuplicateReason is a single signal that contributed to a duplicate score
+type DuplicateReason struct {
+	Signal string  `json:"signal"`
+	Value  string  `json:"value"`
+	Weight float64 `json:"weight"`
+}
+
+// clientIdentity holds the normalized identifiers of a client used for matching
+type clientIdentity struct {
+	emails map[string]bool
+	phones map[string]bool
+	name   string
+}
+
+// newClientIdentity collects emails and phones from the external IDs and data of client.
+// ExternalIDs must be loaded.
+func newClientIdentity(client *Client) clientIdentity {
+	identity := clientIdentity{
+		emails: map[string]bool{},
+		phones: map[string]bool{},
+		name:   normalizeClientName(client.Name),
+	}
+	for _, externalID := range client.ExternalIDs {
+		switch externalID.Type {
+		case ExternalIDTypeEmail:
+			if email := normalizeEmail(externalID.Value); email != "" {
+				identity.emails[email] = true
+			}
+		case ExternalIDTypePhone, ExternalIDTypeWhatsapp:
+			if phone := normalizePhone(externalID.Value); phone != "" {
+				identity.phones[phone] = true
+			}
+		}
+	}
+
+	var data map[string]any
+	if len(client.Data) > 0 && json.Unmarshal(client.Data, &data) == nil {
+		if value, ok := data["email"].(string); ok {
+			if email := normalizeEmail(value); email != "" {
+				identity.emails[email] = true
+			}
+		}
+		for _, key := range []string{"phone", "phone_number", "mobile"} {
+			if value, ok := data[key].(string); ok {
+				if phone := normalizePhone(value); phone != "" {
+					identity.phones[phone] = true
+				}
+			}
+		}
+	}
+	return identity
+}
+
+// normalizeEmail lowercases an email address and returns "" if it does not look like one
+func normalizeEmail(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if strings.Count(value, "@") != 1 || EmailDomain(value) == "" {
+		return ""
+	}
+	return value
+}
+
+// normalizePhone keeps the last 10 digits of a phone number so that country prefixes written
+// differently ("+49", "0049", "0") still match. Numbers shorter than 7 digits are ignored.
+func normalizePhone(value string) string {
+	digits := strings.TrimLeft(nonDigitRegex.ReplaceAllString(value, ""), "0")
+	if len(digits) < 7 {
+		return ""
+	}
+	if len(digits) > 10 {
+		digits = digits[len(digits)-10:]
+	}
+	return digits
+}
+
+// normalizeClientName lowercases and collapses a client name. Names that were derived from an
+// identifier or are placeholders are ignored.
+func normalizeClientName(name string) string {
+	name = strings.Join(strings.Fields(strings.ToLower(name)), " ")
+	if name == "unknown" || strings.Contains(name, "@") || !strings.ContainsFunc(name, unicode.IsLetter) {
+		return ""
+	}
+	return name
+}
+
+// nameSimilarity returns the Levenshtein similarity (0-1) of two normalized names
+func nameSimilarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return 1 - float64(previous[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// countSharedConversations counts conversations of one client in which the other client wrote messages
+func countSharedConversations(a, b uuid.UUID) int64 {
+	var count int64
+	db.Table("messages").
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+		Where("(messages.client_id = ? AND conversations.client_id = ?) OR (messages.client_id = ? AND conversations.client_id = ?)", a, b, b, a).
+		Distinct("messages.conversation_id").
+		Count(&count)
+	return count
+}
+
+// ScoreClientDuplicate scores how likely a and b are the same person. Signals are combined as
+// independent probabilities, so the score grows with every matching signal but never exceeds 1.
+// ExternalIDs must be loaded on both clients.
+func ScoreClientDuplicate(a, b *Client) (float64, []DuplicateReason) {
+	ia, ib := newClientIdentity(a), newClientIdentity(b)
+	var reasons []DuplicateReason
+
+	for email := range ia.emails {
+		if ib.emails[email] {
+			reasons = append(reasons, DuplicateReason{Signal: DuplicateSignalEmail, Value: email, Weight: 0.7})
+			break
+		}
+	}
+	for phone := range ia.phones {
+		if ib.phones[phone] {
+			reasons = append(reasons, DuplicateReason{Signal: DuplicateSignalPhone, Value: phone, Weight: 0.6})
+			break
+		}
+	}
+	if similarity := nameSimilarity(ia.name, ib.name); similarity >= 0.85 {
+		reasons = append(reasons, DuplicateReason{Signal: DuplicateSignalName, Value: fmt.Sprintf("%.2f", similarity), Weight: 0.3 * similarity})
+	}
+	if shared := countSharedConversations(a.ID, b.ID); shared > 0 {
+		weight := 0.2
+		if shared >= 3 {
+			weight = 0.3
+		}
+		reasons = append(reasons, DuplicateReason{Signal: DuplicateSignalSharedConversation, Value: strconv.FormatInt(shared, 10), Weight: weight})
+	}
+
+	remaining := 1.0
+	for _, reason := range reasons {
+		remaining *= 1 - reason.Weight
+	}
+	score, _ := strconv.ParseFloat(fmt.Sprintf("%.3f", 1-remaining), 64)
+	return score, reasons
+}
+
+// findPossibleDuplicateIDs returns clients sharing an email, phone, full name or conversation with client
+func findPossibleDuplicateIDs(client *Client) ([]uuid.UUID, error) {
+	identity := newClientIdentity(client)
+	found := map[uuid.UUID]bool{}
+	collect := func(query *gorm.DB) error {
+		var ids []uuid.UUID
+		if err := query.Limit(maxDuplicateCandidates).Pluck("client_id", &ids).Error; err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if id != client.ID && id != uuid.Nil {
+				found[id] = true
+			}
+		}
+		return nil
+	}
+
+	emails := make([]string, 0, len(identity.emails))
+	for email := range identity.emails {
+		emails = append(emails, email)
+	}
+	var phoneConditions []string
+	var phoneArgs []any
+	for phone := range identity.phones {
+		phoneConditions = append(phoneConditions, "REGEXP_REPLACE(%s, '[^0-9]', '') LIKE ?")
+		phoneArgs = append(phoneArgs, "%"+phone)
+	}
+
+	if len(emails) > 0 {
+		if err := collect(db.Model(&ClientExternalID{}).Distinct("client_id").
+			Where("type = ? AND LOWER(value) IN ?", ExternalIDTypeEmail, emails)); err != nil {
+			return nil, err
+		}
+		if err := collect(db.Model(&Client{}).Select("id AS client_id").
+			Where("LOWER(JSON_UNQUOTE(JSON_EXTRACT(data, '$.email'))) IN ?", emails)); err != nil {
+			return nil, err
+		}
+	}
+	if len(phoneConditions) > 0 {
+		externalCondition := fmt.Sprintf(strings.Join(phoneConditions, " OR "), repeatArgs("value", len(phoneConditions))...)
+		if err := collect(db.Model(&ClientExternalID{}).Distinct("client_id").
+			Where("type IN ?", []string{ExternalIDTypePhone, ExternalIDTypeWhatsapp}).
+			Where(externalCondition, phoneArgs...)); err != nil {
+			return nil, err
+		}
+		dataCondition := fmt.Sprintf(strings.Join(phoneConditions, " OR "), repeatArgs("JSON_UNQUOTE(JSON_EXTRACT(data, '$.phone'))", len(phoneConditions))...)
+		if err := collect(db.Model(&Client{}).Select("id AS client_id").Where(dataCondition, phoneArgs...)); err != nil {
+			return nil, err
+		}
+	}
+	// Single-word names ("John") are too common to look up on their own
+	if strings.Contains(identity.name, " ") {
+		if err := collect(db.Model(&Client{}).Select("id AS client_id").Where("LOWER(name) = ?", identity.name)); err != nil {
+			return nil, err
+		}
+	}
+	if err := collect(db.Table("messages").Distinct("messages.client_id").
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+		Where("conversations.client_id = ? AND messages.client_id IS NOT NULL", client.ID)); err != nil {
+		return nil, err
+	}
+	if err := collect(db.Table("conversations").Distinct("conversations.client_id").
+		Joins("JOIN messages ON messages.conversation_id = conversations.id").
+		Where("messages.client_id = ?", client.ID)); err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func repeatArgs(value string, n int) []any {
+	args := make([]any, n)
+	for i := range args {
+		args[i] = value
+	}
+	return args
+}
+
+// FindClientDuplicates scores every possible duplicate of the client and stores pairs scoring at
+// least ClientDuplicateMinScore in the review queue. Dismissed and merged pairs are left untouched.
+// It returns the pending candidates involving the client.
+func FindClientDuplicates(clientID uuid.UUID) ([]ClientDuplicateCandidate, error) {
+	var client Client
+	if err := db.Preload("ExternalIDs").First(&client, "id = ?", clientID).Error; err != nil {
+		return nil, err
+	}
+
+	ids, err := findPossibleDuplicateIDs(&client)
+	if err != nil {
+		return nil, err
+	}
+	var others []Client
+	if len(ids) > 0 {
+		// Clients of other workspaces are never duplicates
+		if err := WorkspaceDB(OrDefaultWorkspace(client.WorkspaceID)).Preload("ExternalIDs").Where("id IN ?", ids).Find(&others).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range others {
+		older, newer := &client, &others[i]
+		if newer.CreatedAt.Before(older.CreatedAt) || (newer.CreatedAt.Equal(older.CreatedAt) && newer.ID.String() < older.ID.String()) {
+			older, newer = newer, older
+		}
+		score, reasons := ScoreClientDuplicate(older, newer)
+
+		var candidate ClientDuplicateCandidate
+		err := db.Where("client_id = ? AND duplicate_id = ?", older.ID, newer.ID).First(&candidate).Error
+		switch {
+		case err == nil && candidate.Status != ClientDuplicateStatusPending:
+			continue
+		case err == nil && score < ClientDuplicateMinScore:
+			db.Delete(&candidate)
+			continue
+		case err != nil && err != gorm.ErrRecordNotFound:
+			return nil, err
+		case err != nil && score < ClientDuplicateMinScore:
+			continue
+		}
+
+		reasonsJSON, _ := json.Marshal(reasons)
+		candidate.WorkspaceID = OrDefaultWorkspace(client.WorkspaceID)
+		candidate.ClientID = older.ID
+		candidate.DuplicateID = newer.ID
+		candidate.Score = score
+		candidate.Reasons = reasonsJSON
+		candidate.Status = ClientDuplicateStatusPending
+		if err := db.Save(&candidate).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var candidates []ClientDuplicateCandidate
+	err = db.Preload("Client.ExternalIDs").Preload("Duplicate.ExternalIDs").
+		Where("status = ? AND (client_id = ? OR duplicate_id = ?)", ClientDuplicateStatusPending, clientID, clientID).
+		Order("score DESC").
+		Find(&candidates).Error
+	return candidates, err
+}
+
+// ClientAutoMergeThreshold returns the configured auto-merge threshold, or 0 if auto-merge is disabled
+func ClientAutoMergeThreshold() float64 {
+	threshold, err := strconv.ParseFloat(GetSettingValue(SettingKeyClientAutoMergeThreshold, "0"), 64)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		return 0
+	}
+	return threshold
+}
+
+// ResolveClientDuplicates refreshes the duplicate candidates of client and merges candidates
+// scoring at or above the auto-merge threshold, keeping the older client of each pair.
+// It returns the client that survives, which differs from client when client itself was merged
+// into an older one. Errors are logged and leave client untouched.
+func ResolveClientDuplicates(client *Client) *Client {
+	candidates, err := FindClientDuplicates(client.ID)
+	if err != nil {
+		log.Warning("Failed to detect duplicates of client %s: %v", client.ID, err)
+		return client
+	}
+
+	threshold := ClientAutoMergeThreshold()
+	if threshold == 0 {
+		return client
+	}
+	for _, candidate := range candidates {
+		if candidate.Score < threshold {
+			continue
+		}
+		merged, err := MergeClients(candidate.ClientID, []uuid.UUID{candidate.DuplicateID}, nil)
+		if err != nil {
+			log.Warning("Failed to auto-merge client %s into %s: %v", candidate.DuplicateID, candidate.ClientID, err)
+			continue
+		}
+		db.Model(&ClientDuplicateCandidate{}).Where("id = ?", candidate.ID).UpdateColumn("auto_merged", true)
+		LogClientMerge(candidate.ClientID, []uuid.UUID{candidate.DuplicateID}, nil, map[string]any{
+			"candidate_id": candidate.ID,
+			"score":        candidate.Score,
+			"auto_merged":  true,
+		})
+		// client is gone; the merge removed its remaining candidates
+		if candidate.DuplicateID == client.ID {
+			return merged
+		}
+	}
+	return client
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrMergeClientNotFound is returned when the target client does not exist
+	ErrMergeClientNotFound = errors.New("client not found")
+	// ErrMergeInvalidClients is returned when no sources are given, a source does not exist
+	// or the target is also a source
+	ErrMergeInvalidClients = errors.New("invalid clients to merge")
+)
+
+// MergeClients merges the source clients into the target client.
+// Conversations, messages and external IDs are moved to the target, data keys missing on the
+// target are copied from the sources and the source clients are deleted.
+// Pending duplicate candidates between the target and a source are marked as merged by resolvedBy.
+func MergeClients(targetID uuid.UUID, sourceIDs []uuid.UUID, resolvedBy *uuid.UUID) (*Client, error) {
+	if len(sourceIDs) == 0 {
+		return nil, ErrMergeInvalidClients
+	}
+	for _, sourceID := range sourceIDs {
+		if sourceID == targetID {
+			return nil, ErrMergeInvalidClients
+		}
+	}
+
+	var targetClient Client
+	if err := db.First(&targetClient, "id = ?", targetID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrMergeClientNotFound
+		}
+		return nil, err
+	}
+
+	// Clients of another workspace cannot be merged into the target
+	var sourceClients []Client
+	if err := WorkspaceDB(OrDefaultWorkspace(targetClient.WorkspaceID)).Where("id IN (?)", sourceIDs).Find(&sourceClients).Error; err != nil {
+		return nil, err
+	}
+	if len(sourceClients) != len(sourceIDs) {
+		return nil, ErrMergeInvalidClients
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Move all conversations to target client
+		if err := tx.Model(&Conversation{}).
+			Where("client_id IN (?)", sourceIDs).
+			Update("client_id", targetID).Error; err != nil {
+			return err
+		}
+
+		// Move all messages to target client
+		if err := tx.Model(&Message{}).
+			Where("client_id IN (?)", sourceIDs).
+			Update("client_id", targetID).Error; err != nil {
+			return err
+		}
+
+		// Move all external IDs to target client (avoid duplicates)
+		for _, sourceID := range sourceIDs {
+			err := tx.Model(&ClientExternalID{}).
+				Where("client_id = ?", sourceID).
+				Update("client_id", targetID).Error
+			if err != nil {
+				// If there are duplicates, delete the source ones
+				if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
+					tx.Where("client_id = ?", sourceID).Delete(&ClientExternalID{})
+				} else {
+					return err
+				}
+			}
+		}
+
+		// Keep campaign opt-outs; the target may already have opted out of the same channel
+		if err := tx.Exec("UPDATE IGNORE client_opt_outs SET client_id = ? WHERE client_id IN (?)", targetID, sourceIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id IN (?)", sourceIDs).Delete(&ClientOptOut{}).Error; err != nil {
+			return err
+		}
+
+		// Merge data from source clients into target client
+		targetData := map[string]any{}
+		if len(targetClient.Data) > 0 {
+			if err := json.Unmarshal(targetClient.Data, &targetData); err != nil {
+				targetData = map[string]any{}
+			}
+		}
+		for _, sourceClient := range sourceClients {
+			var sourceData map[string]any
+			if len(sourceClient.Data) > 0 && json.Unmarshal(sourceClient.Data, &sourceData) == nil {
+				for key, value := range sourceData {
+					if _, exists := targetData[key]; !exists {
+						targetData[key] = value
+					}
+				}
+			}
+		}
+		mergedData, err := json.Marshal(targetData)
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]any{"data": mergedData}
+		if targetClient.OrganizationID == nil {
+			for _, sourceClient := range sourceClients {
+				if sourceClient.OrganizationID != nil {
+					updates["organization_id"] = *sourceClient.OrganizationID
+					break
+				}
+			}
+		}
+		if err := tx.Model(&targetClient).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		// Resolve duplicate candidates that involve the merged clients
+		now := time.Now()
+		if err := tx.Model(&ClientDuplicateCandidate{}).
+			Where("status = ? AND ((client_id = ? AND duplicate_id IN (?)) OR (duplicate_id = ? AND client_id IN (?)))",
+				ClientDuplicateStatusPending, targetID, sourceIDs, targetID, sourceIDs).
+			Updates(map[string]any{
+				"status":      ClientDuplicateStatusMerged,
+				"resolved_by": resolvedBy,
+				"resolved_at": now,
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("status = ? AND (client_id IN (?) OR duplicate_id IN (?))",
+			ClientDuplicateStatusPending, sourceIDs, sourceIDs).
+			Delete(&ClientDuplicateCandidate{}).Error; err != nil {
+			return err
+		}
+
+		// Delete source clients
+		return tx.Where("id IN (?)", sourceIDs).Delete(&Client{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.
+		Preload("ExternalIDs").
+		Preload("Conversations").
+		First(&targetClient, "id = ?", targetID).Error; err != nil {
+		return nil, err
+	}
+	return &targetClient, nil
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"encoding/json"
+	"reflect"
+
 	"github.com/getevo/restify"
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
@@ -20,16 +23,19 @@ const (
 )
 
 type Client struct {
-	ID        uuid.UUID      `gorm:"column:id;type:char(36);primaryKey" json:"id"`
-	Name      string         `gorm:"column:name;size:255;not null" json:"name"`
-	Avatar    *string        `gorm:"column:avatar;size:500" json:"avatar"`
-	Data      datatypes.JSON `gorm:"column:data;type:json" json:"data"`
-	Language  *string        `gorm:"column:language;size:10" json:"language"`
-	Timezone  *string        `gorm:"column:timezone;size:50" json:"timezone"`
-	CreatedAt time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+	ID             uuid.UUID      `gorm:"column:id;type:char(36);primaryKey" json:"id"`
+	WorkspaceID    uint           `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	Name           string         `gorm:"column:name;size:255;not null" json:"name"`
+	Avatar         *string        `gorm:"column:avatar;size:500" json:"avatar"`
+	Data           datatypes.JSON `gorm:"column:data;type:json" json:"data"`
+	Language       *string        `gorm:"column:language;size:10" json:"language"`
+	Timezone       *string        `gorm:"column:timezone;size:50" json:"timezone"`
+	OrganizationID *uint          `gorm:"column:organization_id;index;fk:organizations" json:"organization_id"`
+	CreatedAt      time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
 
 	// Relationships
+	Organization  *Organization      `gorm:"foreignKey:OrganizationID;references:ID" json:"organization,omitempty"`
 	ExternalIDs   []ClientExternalID `gorm:"foreignKey:ClientID;references:ID" json:"external_ids,omitempty"`
 	Conversations []Conversation     `gorm:"foreignKey:ClientID;references:ID" json:"conversations,omitempty"`
 	Messages      []Message          `gorm:"foreignKey:ClientID;references:ID" json:"messages,omitempty"`
@@ -38,11 +44,12 @@ type Client struct {
 }
 
 type ClientExternalID struct {
-	ID        uint      `gorm:"column:id;primaryKey" json:"id"`
-	ClientID  uuid.UUID `gorm:"column:client_id;type:char(36);not null;index;fk:clients" json:"client_id"`
-	Type      string    `gorm:"column:type;size:50;not null;check:type IN ('email','phone','whatsapp','slack','telegram','web','chat')" json:"type"`
-	Value     string    `gorm:"column:value;size:255;not null" json:"value"`
-	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	ID          uint      `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID uint      `gorm:"column:workspace_id;not null;default:1;index:idx_client_external_ids_workspace_value" json:"workspace_id"`
+	ClientID    uuid.UUID `gorm:"column:client_id;type:char(36);not null;index;fk:clients" json:"client_id"`
+	Type        string    `gorm:"column:type;size:50;not null;check:type IN ('email','phone','whatsapp','slack','telegram','web','chat')" json:"type"`
+	Value       string    `gorm:"column:value;size:255;not null;index:idx_client_external_ids_workspace_value" json:"value"`
+	CreatedAt   time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
 
 	// Relationships
 	Client Client `gorm:"foreignKey:ClientID;references:ID" json:"client,omitempty"`
@@ -63,21 +70,86 @@ func (c *Client) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-// AfterCreate hook - broadcast client creation to webhooks
+// AfterCreate hook - queue client creation for webhooks in the same transaction
 func (c *Client) AfterCreate(tx *gorm.DB) error {
 	// Trigger webhook with full client entity
-	go BroadcastWebhook(WebhookEventClientCreated, map[string]any{
+	c.loadOrganization(tx)
+	return BroadcastWebhook(tx, c.WorkspaceID, WebhookEventClientCreated, map[string]any{
 		"client": c,
 	})
-	return nil
 }
 
-// AfterUpdate hook - broadcast client update to webhooks
+// AfterUpdate hook - queue client update for webhooks in the same transaction
 func (c *Client) AfterUpdate(tx *gorm.DB) error {
 	// Trigger webhook with full client entity
-	go BroadcastWebhook(WebhookEventClientUpdated, map[string]any{
+	c.loadOrganization(tx)
+	return BroadcastWebhook(tx, c.WorkspaceID, WebhookEventClientUpdated, map[string]any{
 		"client": c,
 	})
+}
+
+// AfterDelete hook - drop pending duplicate candidates and campaign opt-outs of the deleted client
+func (c *Client) AfterDelete(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		return nil
+	}
+	if err := tx.Where("client_id = ?", c.ID).Delete(&ClientOptOut{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("status = ? AND (client_id = ? OR duplicate_id = ?)", ClientDuplicateStatusPending, c.ID, c.ID).
+		Delete(&ClientDuplicateCandidate{}).Error
+}
+
+// ClientChanges returns the fields that differ between two versions of a client.
+// Custom attributes are compared one by one and reported as "data.<name>".
+func ClientChanges(before, after *Client) (oldValues, newValues map[string]any) {
+	oldValues, newValues = map[string]any{}, map[string]any{}
+	set := func(field string, oldValue, newValue any) {
+		if !reflect.DeepEqual(oldValue, newValue) {
+			oldValues[field] = oldValue
+			newValues[field] = newValue
+		}
+	}
+
+	set("name", before.Name, after.Name)
+	set("language", before.Language, after.Language)
+	set("timezone", before.Timezone, after.Timezone)
+	set("organization_id", before.OrganizationID, after.OrganizationID)
+
+	var oldData, newData map[string]any
+	if len(before.Data) > 0 {
+		_ = json.Unmarshal(before.Data, &oldData)
+	}
+	if len(after.Data) > 0 {
+		_ = json.Unmarshal(after.Data, &newData)
+	}
+	for key, value := range newData {
+		set("data."+key, oldData[key], value)
+	}
+	for key, value := range oldData {
+		if _, exists := newData[key]; !exists {
+			set("data."+key, value, nil)
+		}
+	}
+	return oldValues, newValues
+}
+
+// loadOrganization loads the client organization (with domains) for webhook payloads
+func (c *Client) loadOrganization(tx *gorm.DB) {
+	if c.OrganizationID == nil || (c.Organization != nil && c.Organization.ID == *c.OrganizationID) {
+		return
+	}
+	var organization Organization
+	if err := tx.Session(&gorm.Session{NewDB: true}).Preload("Domains").Where("id = ?", *c.OrganizationID).First(&organization).Error; err == nil {
+		c.Organization = &organization
+	}
+}
+
+// BeforeCreate hook - an external ID belongs to the workspace of its client
+func (e *ClientExternalID) BeforeCreate(tx *gorm.DB) error {
+	if e.WorkspaceID == 0 {
+		e.WorkspaceID = workspaceOf(tx, &Client{}, e.ClientID)
+	}
 	return nil
 }
 
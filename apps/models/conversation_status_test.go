@@ -0,0 +1,97 @@
+package models
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestConversationStatusChangeWebhook(t *testing.T) {
+	dbo, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Migrating Conversation would migrate its MySQL-only relations too, so create the table by hand
+	if err := dbo.Exec(`CREATE TABLE conversations (id integer PRIMARY KEY AUTOINCREMENT, workspace_id integer, title text, client_id text,
+		department_id integer, channel_id text, inbox_id integer, external_id text, secret text, status text, priority text,
+		handle_by_bot numeric, custom_fields text, ip text, browser text, operating_system text, created_at datetime,
+		updated_at datetime, closed_at datetime)`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		name      string
+		oldStatus any
+		newStatus any
+	}
+	var events []event
+	broadcaster := WebhookBroadcaster
+	WebhookBroadcaster = func(tx *gorm.DB, workspaceID uint, name string, data map[string]any) error {
+		events = append(events, event{name, data["old_status"], data["new_status"]})
+		return nil
+	}
+	t.Cleanup(func() { WebhookBroadcaster = broadcaster })
+
+	conversation := Conversation{WorkspaceID: 1, Title: "test", ChannelID: "web", Status: ConversationStatusNew, Priority: "low"}
+	if err := dbo.Create(&conversation).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		update  func(tx *gorm.DB) error
+		changed bool
+	}{
+		{name: "map update", update: func(tx *gorm.DB) error {
+			return tx.Model(&conversation).Updates(map[string]any{"status": ConversationStatusInProgress}).Error
+		}, changed: true},
+		{name: "single column", update: func(tx *gorm.DB) error {
+			return tx.Model(&Conversation{ID: conversation.ID, WorkspaceID: 1}).Update("status", ConversationStatusResolved).Error
+		}, changed: true},
+		{name: "closed", update: func(tx *gorm.DB) error {
+			return tx.Model(&Conversation{ID: conversation.ID, WorkspaceID: 1}).Updates(map[string]any{"status": ConversationStatusClosed}).Error
+		}, changed: true},
+		{name: "same status", update: func(tx *gorm.DB) error {
+			return tx.Model(&Conversation{ID: conversation.ID, WorkspaceID: 1}).Update("status", ConversationStatusClosed).Error
+		}},
+		{name: "other column", update: func(tx *gorm.DB) error {
+			return tx.Model(&Conversation{ID: conversation.ID, WorkspaceID: 1}).Update("priority", "high").Error
+		}},
+	}
+	previous := ConversationStatusNew
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			if err := dbo.Transaction(tt.update); err != nil {
+				t.Fatal(err)
+			}
+			var changes []event
+			closed := false
+			for _, e := range events {
+				switch e.name {
+				case WebhookEventConversationStatusChange:
+					changes = append(changes, e)
+				case WebhookEventConversationClosed:
+					closed = true
+				}
+			}
+			if !tt.changed {
+				if len(changes) != 0 {
+					t.Fatalf("queued %v, want no status change", changes)
+				}
+				return
+			}
+			var stored Conversation
+			dbo.First(&stored, conversation.ID)
+			if len(changes) != 1 || changes[0].oldStatus != previous || changes[0].newStatus != stored.Status {
+				t.Fatalf("queued %v, want one change from %s to %s", changes, previous, stored.Status)
+			}
+			if closed != (stored.Status == ConversationStatusClosed) {
+				t.Errorf("conversation.closed queued = %v for status %s", closed, stored.Status)
+			}
+			previous = stored.Status
+		})
+	}
+}
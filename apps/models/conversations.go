@@ -13,7 +13,6 @@ import (
 	"github.com/iesreza/homa-backend/apps/nats"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 // Language detection function - set by the ai package to avoid circular imports
@@ -33,6 +32,14 @@ const (
 	ConversationStatusArchived     = "archived"
 )
 
+// ConversationStatuses lists every valid conversation status
+var ConversationStatuses = []string{
+	ConversationStatusNew, ConversationStatusWaitForAgent, ConversationStatusInProgress,
+	ConversationStatusWaitForUser, ConversationStatusOnHold, ConversationStatusResolved,
+	ConversationStatusClosed, ConversationStatusUnresolved, ConversationStatusSpam,
+	ConversationStatusArchived,
+}
+
 // Message type constants
 const (
 	MessageTypeMessage = "message"
@@ -47,12 +54,18 @@ const (
 	ConversationPriorityUrgent = "urgent"
 )
 
+// ConversationPriorities lists every valid conversation priority
+var ConversationPriorities = []string{
+	ConversationPriorityLow, ConversationPriorityMedium, ConversationPriorityHigh, ConversationPriorityUrgent,
+}
+
 // Outbound messaging functions - set by the integrations package to avoid circular imports
 var (
-	SendTelegramMessage func(chatID, text string) error
-	SendWhatsAppMessage func(phoneNumber, text string) error
-	SendSlackMessage    func(channelID, text string) error
-	SendEmailReply      func(conversationID uint, messageID uint, body string, user *auth.User) error
+	SendTelegramMessage  func(workspaceID uint, chatID, text string) error
+	SendWhatsAppMessage  func(workspaceID uint, phoneNumber, text string) error
+	SendSlackMessage     func(workspaceID uint, channelID, text string) error
+	SendEmailReply       func(conversationID uint, messageID uint, body string, user *auth.User) error
+	SendWhatsAppTemplate func(workspaceID uint, phoneNumber string, template WhatsAppTemplate) error
 )
 
 // AI Agent processing function - set by the ai package to avoid circular imports
@@ -60,6 +73,7 @@ var ProcessIncomingMessage func(message *Message) error
 
 type Conversation struct {
 	ID           uint           `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID  uint           `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
 	Title        string         `gorm:"column:title;size:255;not null" json:"title"`
 	ClientID     uuid.UUID      `gorm:"column:client_id;type:char(36);not null;index;fk:clients" json:"client_id"`
 	DepartmentID *uint          `gorm:"column:department_id;index;fk:departments" json:"department_id"`
@@ -87,11 +101,18 @@ type Conversation struct {
 	Tags        []Tag                    `gorm:"many2many:conversation_tags;foreignKey:ID;joinForeignKey:ConversationID;references:ID;joinReferences:TagID" json:"tags,omitempty"`
 	Assignments []ConversationAssignment `gorm:"foreignKey:ConversationID;references:ID" json:"assignments,omitempty"`
 
+	// previousStatus is the stored status before an update that changes it and departmentChanged tells
+	// whether the update changes the department. BeforeUpdate sets them, since tx.Statement.Changed
+	// no longer sees the changes once the update ran.
+	previousStatus    string
+	departmentChanged bool
+
 	restify.API
 }
 
 type Message struct {
 	ID              uint       `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID     uint       `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
 	ConversationID  uint       `gorm:"column:conversation_id;not null;index;fk:conversations" json:"conversation_id"`
 	UserID          *uuid.UUID `gorm:"column:user_id;type:char(36);index;fk:users" json:"user_id"`
 	ClientID        *uuid.UUID `gorm:"column:client_id;type:char(36);index;fk:clients" json:"client_id"`
@@ -101,6 +122,11 @@ type Message struct {
 	IsSystemMessage bool       `gorm:"column:is_system_message;default:0" json:"is_system_message"`
 	CreatedAt       time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
 
+	// KeepBotHandling prevents an agent message from disabling bot handling (e.g. a macro handing the conversation back to the bot)
+	KeepBotHandling bool `gorm:"-" json:"-"`
+	// SkipChannelDelivery prevents an agent message from being sent to the external channel (e.g. when the caller delivers it itself)
+	SkipChannelDelivery bool `gorm:"-" json:"-"`
+
 	// Relationships
 	Conversation Conversation `gorm:"foreignKey:ConversationID;references:ID" json:"conversation,omitempty"`
 	User         *auth.User   `gorm:"foreignKey:UserID;references:UserID" json:"user,omitempty"`
@@ -139,8 +165,9 @@ func CreateActionMessage(conversationID uint, userID *uuid.UUID, actorName strin
 }
 
 type Tag struct {
-	ID   uint   `gorm:"column:id;primaryKey" json:"id"`
-	Name string `gorm:"column:name;size:100;uniqueIndex;not null" json:"name"`
+	ID          uint   `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID uint   `gorm:"column:workspace_id;not null;default:1;uniqueIndex:idx_tags_workspace_name" json:"workspace_id"`
+	Name        string `gorm:"column:name;size:100;uniqueIndex:idx_tags_workspace_name;not null" json:"name"`
 
 	// Relationships
 	Conversations []Conversation `gorm:"many2many:conversation_tags;foreignKey:ID;joinForeignKey:TagID;references:ID;joinReferences:ConversationID" json:"conversations,omitempty"`
@@ -173,19 +200,24 @@ func (c *Conversation) ToWebhookData() map[string]any {
 	// Include client if loaded (non-zero ID)
 	if c.Client.ID != uuid.Nil {
 		clientData := map[string]any{
-			"id":         c.Client.ID,
-			"name":       c.Client.Name,
-			"avatar":     c.Client.Avatar,
-			"data":       c.Client.Data,
-			"language":   c.Client.Language,
-			"timezone":   c.Client.Timezone,
-			"created_at": c.Client.CreatedAt,
-			"updated_at": c.Client.UpdatedAt,
+			"id":              c.Client.ID,
+			"name":            c.Client.Name,
+			"avatar":          c.Client.Avatar,
+			"data":            c.Client.Data,
+			"language":        c.Client.Language,
+			"timezone":        c.Client.Timezone,
+			"organization_id": c.Client.OrganizationID,
+			"created_at":      c.Client.CreatedAt,
+			"updated_at":      c.Client.UpdatedAt,
 		}
 		// Include external IDs if loaded
 		if len(c.Client.ExternalIDs) > 0 {
 			clientData["external_ids"] = c.Client.ExternalIDs
 		}
+		// Include organization if loaded
+		if c.Client.Organization != nil {
+			clientData["organization"] = c.Client.Organization.ToWebhookData()
+		}
 		data["client"] = clientData
 	}
 
@@ -194,11 +226,22 @@ func (c *Conversation) ToWebhookData() map[string]any {
 
 // GORM Hooks for Conversation
 
+// BeforeCreate hook - a conversation belongs to the workspace of its inbox or client
+func (c *Conversation) BeforeCreate(tx *gorm.DB) error {
+	if c.WorkspaceID == 0 && c.InboxID != nil {
+		c.WorkspaceID = workspaceOf(tx, &Inbox{}, *c.InboxID)
+	}
+	if c.WorkspaceID == 0 {
+		c.WorkspaceID = workspaceOf(tx, &Client{}, c.ClientID)
+	}
+	return nil
+}
+
 // AfterCreate hook - broadcast conversation creation to NATS and webhooks
 func (c *Conversation) AfterCreate(tx *gorm.DB) error {
 	// Broadcast to NATS
 	go func() {
-		subject := fmt.Sprintf("conversation.%d", c.ID)
+		subject := ConversationSubject(c.WorkspaceID, c.ID)
 		data, _ := json.Marshal(map[string]interface{}{
 			"event":        "conversation.created",
 			"conversation": c,
@@ -208,35 +251,50 @@ func (c *Conversation) AfterCreate(tx *gorm.DB) error {
 		}
 	}()
 
-	// Trigger webhook with clean conversation data
-	go func() {
-		// Fetch conversation with client and client's external IDs
-		var conversation Conversation
-		if err := db.Preload("Client").Preload("Client.ExternalIDs").First(&conversation, c.ID).Error; err == nil {
-			BroadcastWebhook(WebhookEventConversationCreated, map[string]any{
-				"conversation": conversation.ToWebhookData(),
-			})
-		} else {
-			// Fallback with just the conversation
-			BroadcastWebhook(WebhookEventConversationCreated, map[string]any{
-				"conversation": c.ToWebhookData(),
-			})
-		}
-	}()
+	// Queue the webhook with clean conversation data in the same transaction
+	// Fetch conversation with client and client's external IDs
+	var conversation Conversation
+	if err := tx.Session(&gorm.Session{NewDB: true}).Preload("Client").Preload("Client.ExternalIDs").Preload("Client.Organization.Domains").First(&conversation, c.ID).Error; err != nil {
+		// Fallback with just the conversation
+		conversation = *c
+	}
+	return BroadcastWebhook(tx, c.WorkspaceID, WebhookEventConversationCreated, map[string]any{
+		"conversation": conversation.ToWebhookData(),
+	})
+}
 
+// BeforeUpdate hook - remember what the update changes, which AfterUpdate can no longer tell once the update ran
+func (c *Conversation) BeforeUpdate(tx *gorm.DB) error {
+	c.previousStatus = ""
+	c.departmentChanged = tx.Statement.Changed("DepartmentID")
+	if c.ID != 0 && tx.Statement.Changed("Status") {
+		var status string
+		if err := tx.Session(&gorm.Session{NewDB: true}).Model(&Conversation{}).Where("id = ?", c.ID).Pluck("status", &status).Error; err != nil {
+			return err
+		}
+		c.previousStatus = status
+	}
 	return nil
 }
 
 // AfterUpdate hook - broadcast conversation update to NATS and webhooks
 func (c *Conversation) AfterUpdate(tx *gorm.DB) error {
+	// Closing a conversation cancels replies scheduled for it
+	statusChanged := c.previousStatus != "" && c.previousStatus != c.Status
+	if statusChanged && (c.Status == ConversationStatusClosed || c.Status == ConversationStatusArchived) {
+		if err := CancelScheduledMessages(tx.Session(&gorm.Session{NewDB: true}), c.ID, ScheduledMessageCancelConversationEnd); err != nil {
+			log.Warning("Failed to cancel scheduled messages of conversation %d: %v", c.ID, err)
+		}
+	}
+
 	// Check if department changed and auto-assign department users
-	if tx.Statement.Changed("DepartmentID") && c.DepartmentID != nil {
+	if c.departmentChanged && c.DepartmentID != nil {
 		go c.assignDepartmentUsers(tx)
 	}
 
 	// Broadcast to NATS
 	go func() {
-		subject := fmt.Sprintf("conversation.%d", c.ID)
+		subject := ConversationSubject(c.WorkspaceID, c.ID)
 		data, _ := json.Marshal(map[string]interface{}{
 			"event":        "conversation.updated",
 			"conversation": c,
@@ -246,45 +304,39 @@ func (c *Conversation) AfterUpdate(tx *gorm.DB) error {
 		}
 	}()
 
-	// Fetch full conversation with client for webhooks
-	go func() {
-		var conversation Conversation
-		if err := db.Preload("Client").Preload("Client.ExternalIDs").First(&conversation, c.ID).Error; err != nil {
-			// Fallback to original conversation if fetch fails
-			conversation = *c
-		}
-
-		convData := conversation.ToWebhookData()
-
-		// Check if status changed
-		if tx.Statement.Changed("Status") {
-			// Get old value from select clause
-			var oldConv Conversation
-			if err := tx.Session(&gorm.Session{}).Clauses(clause.Returning{}).Where("id = ?", c.ID).First(&oldConv).Error; err == nil {
-				if oldConv.Status != c.Status {
-					BroadcastWebhook(WebhookEventConversationStatusChange, map[string]any{
-						"conversation": convData,
-						"old_status":   oldConv.Status,
-						"new_status":   c.Status,
-					})
-				}
-			}
+	// Fetch full conversation with client and queue the webhooks in the same transaction
+	var conversation Conversation
+	if err := tx.Session(&gorm.Session{NewDB: true}).Preload("Client").Preload("Client.ExternalIDs").Preload("Client.Organization.Domains").First(&conversation, c.ID).Error; err != nil {
+		// Fallback to original conversation if fetch fails
+		conversation = *c
+	}
 
-			// Check if conversation is closed
-			if c.Status == ConversationStatusClosed {
-				BroadcastWebhook(WebhookEventConversationClosed, map[string]any{
-					"conversation": convData,
-				})
-			}
-		}
+	convData := conversation.ToWebhookData()
 
-		// Trigger general update webhook with clean conversation data
-		BroadcastWebhook(WebhookEventConversationUpdated, map[string]any{
+	// Check if status changed, against the status BeforeUpdate read before the update ran
+	if statusChanged {
+		if err := BroadcastWebhook(tx, conversation.WorkspaceID, WebhookEventConversationStatusChange, map[string]any{
 			"conversation": convData,
-		})
-	}()
+			"old_status":   c.previousStatus,
+			"new_status":   c.Status,
+		}); err != nil {
+			return err
+		}
 
-	return nil
+		// Check if conversation is closed
+		if c.Status == ConversationStatusClosed {
+			if err := BroadcastWebhook(tx, conversation.WorkspaceID, WebhookEventConversationClosed, map[string]any{
+				"conversation": convData,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Trigger general update webhook with clean conversation data
+	return BroadcastWebhook(tx, conversation.WorkspaceID, WebhookEventConversationUpdated, map[string]any{
+		"conversation": convData,
+	})
 }
 
 // assignDepartmentUsers automatically assigns all users from the conversation's department
@@ -344,6 +396,11 @@ func (c *Conversation) assignDepartmentUsers(tx *gorm.DB) {
 
 // BeforeCreate hook - detect message language before saving
 func (m *Message) BeforeCreate(tx *gorm.DB) error {
+	// A message belongs to the workspace of its conversation
+	if m.WorkspaceID == 0 && m.ConversationID > 0 {
+		m.WorkspaceID = workspaceOf(tx, &Conversation{}, m.ConversationID)
+	}
+
 	// Skip language detection for system messages or if already set
 	if m.IsSystemMessage || m.Language != "" {
 		return nil
@@ -381,7 +438,7 @@ func (m *Message) BeforeCreate(tx *gorm.DB) error {
 func (m *Message) AfterCreate(tx *gorm.DB) error {
 	// Broadcast to NATS
 	go func() {
-		subject := fmt.Sprintf("conversation.%d", m.ConversationID)
+		subject := ConversationSubject(m.WorkspaceID, m.ConversationID)
 
 		// Include sender information for client-side filtering
 		var senderID string
@@ -412,48 +469,51 @@ func (m *Message) AfterCreate(tx *gorm.DB) error {
 		}
 	}()
 
-	// Trigger webhook with message and conversation (with client)
-	go func() {
-		// Create clean message map without nested relationships
-		messageData := map[string]any{
-			"id":                m.ID,
-			"conversation_id":   m.ConversationID,
-			"user_id":           m.UserID,
-			"client_id":         m.ClientID,
-			"body":              m.Body,
-			"is_system_message": m.IsSystemMessage,
-			"created_at":        m.CreatedAt,
-		}
-
-		// Fetch the full conversation with client and client's external IDs for the webhook
-		var conversation Conversation
-		if err := db.Preload("Client").Preload("Client.ExternalIDs").First(&conversation, m.ConversationID).Error; err == nil {
-			BroadcastWebhook(WebhookEventMessageCreated, map[string]any{
-				"message":      messageData,
-				"conversation": conversation.ToWebhookData(),
-			})
-		} else {
-			// Fallback if conversation fetch fails
-			BroadcastWebhook(WebhookEventMessageCreated, map[string]any{
-				"message": messageData,
-			})
-		}
-	}()
+	// Queue the webhook with message and conversation (with client) in the same transaction
+	// Create clean message map without nested relationships
+	messageData := map[string]any{
+		"id":                m.ID,
+		"conversation_id":   m.ConversationID,
+		"user_id":           m.UserID,
+		"client_id":         m.ClientID,
+		"body":              m.Body,
+		"is_system_message": m.IsSystemMessage,
+		"created_at":        m.CreatedAt,
+	}
+	webhookData := map[string]any{"message": messageData}
+
+	// Fetch the full conversation with client and client's external IDs for the webhook
+	var conversation Conversation
+	if err := tx.Session(&gorm.Session{NewDB: true}).Preload("Client").Preload("Client.ExternalIDs").Preload("Client.Organization.Domains").First(&conversation, m.ConversationID).Error; err == nil {
+		webhookData["conversation"] = conversation.ToWebhookData()
+	}
+	if err := BroadcastWebhook(tx, m.WorkspaceID, WebhookEventMessageCreated, webhookData); err != nil {
+		return err
+	}
 
 	// Send outbound message to external channels (Telegram, WhatsApp, etc.)
 	// Only for agent messages (UserID is set, not ClientID)
 	log.Info("Message.AfterCreate: ID=%d, UserID=%v, ClientID=%v, IsSystem=%v", m.ID, m.UserID, m.ClientID, m.IsSystemMessage)
 	if m.UserID != nil && !m.IsSystemMessage {
 		log.Info("Message.AfterCreate: Agent message detected, will check bot handling for conv %d", m.ConversationID)
-		go m.sendToExternalChannel()
+		if !m.SkipChannelDelivery {
+			go m.sendToExternalChannel()
+		}
 
 		// Auto-disable bot handling when a human agent sends a message
-		go m.checkAndDisableBotHandling()
+		if !m.KeepBotHandling {
+			go m.checkAndDisableBotHandling()
+		}
 	}
 
 	// Process incoming customer messages with AI agent
 	// Only for customer messages (ClientID is set, not UserID)
 	if m.ClientID != nil && m.UserID == nil && !m.IsSystemMessage {
+		// The customer wrote first; scheduled replies may no longer fit the conversation
+		if err := CancelScheduledMessages(tx.Session(&gorm.Session{NewDB: true}), m.ConversationID, ScheduledMessageCancelCustomerReplied); err != nil {
+			log.Warning("Failed to cancel scheduled messages of conversation %d: %v", m.ConversationID, err)
+		}
+
 		if ProcessIncomingMessage != nil {
 			go func() {
 				if err := ProcessIncomingMessage(m); err != nil {
@@ -512,13 +572,20 @@ func (m *Message) checkAndDisableBotHandling() {
 	log.Info("Bot handling disabled for conversation %d because human agent %s sent a message", m.ConversationID, user.DisplayName)
 }
 
-// sendToExternalChannel sends the message to the appropriate external channel
+// sendToExternalChannel delivers the message to the external channel and logs failures
 func (m *Message) sendToExternalChannel() {
+	if err := m.DeliverToExternalChannel(); err != nil {
+		log.Error("Failed to deliver message %d to external channel: %v", m.ID, err)
+	}
+}
+
+// DeliverToExternalChannel sends the message to the conversation's external channel (Telegram, WhatsApp, Slack or email).
+// Web chat and other channels need no delivery and return nil.
+func (m *Message) DeliverToExternalChannel() error {
 	// Fetch the conversation with client and their external IDs
 	var conversation Conversation
 	if err := db.Preload("Client").Preload("Client.ExternalIDs").First(&conversation, m.ConversationID).Error; err != nil {
-		log.Error("Failed to fetch conversation for outbound message: %v", err)
-		return
+		return fmt.Errorf("failed to fetch conversation for outbound message: %w", err)
 	}
 
 	// Check the channel and send accordingly
@@ -533,11 +600,10 @@ func (m *Message) sendToExternalChannel() {
 			}
 		}
 		if telegramChatID == "" {
-			log.Warning("No Telegram chat ID found for client %s", conversation.ClientID)
-			return
+			return fmt.Errorf("no Telegram chat ID found for client %s", conversation.ClientID)
 		}
-		if err := SendTelegramMessage(telegramChatID, m.Body); err != nil {
-			log.Error("Failed to send Telegram message: %v", err)
+		if err := SendTelegramMessage(conversation.WorkspaceID, telegramChatID, m.Body); err != nil {
+			return fmt.Errorf("failed to send Telegram message: %w", err)
 		}
 
 	case "whatsapp":
@@ -550,11 +616,10 @@ func (m *Message) sendToExternalChannel() {
 			}
 		}
 		if whatsappPhone == "" {
-			log.Warning("No WhatsApp phone found for client %s", conversation.ClientID)
-			return
+			return fmt.Errorf("no WhatsApp phone found for client %s", conversation.ClientID)
 		}
-		if err := SendWhatsAppMessage(whatsappPhone, m.Body); err != nil {
-			log.Error("Failed to send WhatsApp message: %v", err)
+		if err := SendWhatsAppMessage(conversation.WorkspaceID, whatsappPhone, m.Body); err != nil {
+			return fmt.Errorf("failed to send WhatsApp message: %w", err)
 		}
 
 	case "slack":
@@ -567,18 +632,16 @@ func (m *Message) sendToExternalChannel() {
 			}
 		}
 		if slackID == "" {
-			log.Warning("No Slack ID found for client %s", conversation.ClientID)
-			return
+			return fmt.Errorf("no Slack ID found for client %s", conversation.ClientID)
 		}
-		if err := SendSlackMessage(slackID, m.Body); err != nil {
-			log.Error("Failed to send Slack message: %v", err)
+		if err := SendSlackMessage(conversation.WorkspaceID, slackID, m.Body); err != nil {
+			return fmt.Errorf("failed to send Slack message: %w", err)
 		}
 
 	case "email":
 		// Send email reply for email conversations
 		if SendEmailReply == nil {
-			log.Warning("SendEmailReply function not set, cannot send email")
-			return
+			return fmt.Errorf("SendEmailReply function not set, cannot send email")
 		}
 		// Get user info for display name and avatar
 		var user *auth.User
@@ -589,11 +652,12 @@ func (m *Message) sendToExternalChannel() {
 			}
 		}
 		if err := SendEmailReply(m.ConversationID, m.ID, m.Body, user); err != nil {
-			log.Error("Failed to send email reply: %v", err)
+			return fmt.Errorf("failed to send email reply: %w", err)
 		}
 
 	default:
 		// For web chat and other channels, no outbound sending needed
 		// The dashboard handles the realtime updates via WebSocket
 	}
+	return nil
 }
@@ -1,14 +1,22 @@
 package models
 
 import (
-	"github.com/getevo/restify"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/getevo/restify"
+	"gorm.io/datatypes"
 )
 
 // CustomAttribute scope constants
 const (
 	CustomAttributeScopeClient       = "client"
 	CustomAttributeScopeConversation = "conversation"
+	CustomAttributeScopeOrganization = "organization"
 )
 
 // CustomAttribute data type constants
@@ -17,8 +25,41 @@ const (
 	CustomAttributeDataTypeFloat  = "float"
 	CustomAttributeDataTypeDate   = "date"
 	CustomAttributeDataTypeString = "string"
+
+	CustomAttributeDataTypeSelect      = "select"
+	CustomAttributeDataTypeMultiSelect = "multi_select"
+	CustomAttributeDataTypeBoolean     = "boolean"
+	CustomAttributeDataTypeEmail       = "email"
+	CustomAttributeDataTypeURL         = "url"
+	CustomAttributeDataTypePhone       = "phone"
+	CustomAttributeDataTypeDateTime    = "datetime"
 )
 
+// CustomAttributeDataTypes lists every supported data type in display order
+var CustomAttributeDataTypes = []string{
+	CustomAttributeDataTypeInt,
+	CustomAttributeDataTypeFloat,
+	CustomAttributeDataTypeDate,
+	CustomAttributeDataTypeDateTime,
+	CustomAttributeDataTypeString,
+	CustomAttributeDataTypeSelect,
+	CustomAttributeDataTypeMultiSelect,
+	CustomAttributeDataTypeBoolean,
+	CustomAttributeDataTypeEmail,
+	CustomAttributeDataTypeURL,
+	CustomAttributeDataTypePhone,
+}
+
+// IsValidCustomAttributeDataType reports whether dataType is a supported custom attribute data type
+func IsValidCustomAttributeDataType(dataType string) bool {
+	for _, t := range CustomAttributeDataTypes {
+		if t == dataType {
+			return true
+		}
+	}
+	return false
+}
+
 // CustomAttribute visibility constants
 const (
 	CustomAttributeVisibilityEveryone      = "everyone"
@@ -36,21 +77,34 @@ const (
 // - Use in conversation/client creation APIs by passing custom attribute values
 // - System validates according to data_type and validation rules
 // - Values are automatically cast to correct types and stored as JSON
+// - select and multi_select attributes restrict values to the entries listed in options
+// - Conversation attributes with a department_id only apply to conversations of that department
+//
+// Validation rules (comma-separated):
+//   - min:N / max:N      numeric bounds, or length bounds for strings
+//   - minlen:N / maxlen:N string length bounds
+//   - required           the value must be present (and non-empty) when the entity is created
+//   - default:VALUE      value used when the attribute is omitted on creation; write a comma in
+//     the value as \,
+//   - regex:EXPR         the value must match EXPR (alias: pattern). Must be the last rule, so
+//     the expression itself may contain commas
 //
 // Example:
 // 1. Create CustomAttribute: scope="conversation", name="priority_level", data_type="int", validation="min:1,max:5"
 // 2. When creating conversation, pass: {"priority_level": 3}
 // 3. System validates (int between 1-5) and stores in conversation.custom_fields as {"priority_level": 3}
 type CustomAttribute struct {
-	Scope       string    `gorm:"column:scope;size:15;not null;primaryKey;check:scope IN ('client','conversation')" json:"scope"`
-	Name        string    `gorm:"column:name;size:100;not null;primaryKey;check:name REGEXP '^[a-z_]+$'" json:"name"`
-	DataType    string    `gorm:"column:data_type;size:20;not null;check:data_type IN ('int','float','date','string')" json:"data_type"`
-	Validation  *string   `gorm:"column:validation;size:500" json:"validation"`
-	Title       string    `gorm:"column:title;size:255;not null" json:"title"`
-	Description *string   `gorm:"column:description;type:text" json:"description"`
-	Visibility  string    `gorm:"column:visibility;size:20;not null;check:visibility IN ('everyone','administrator','hidden')" json:"visibility"`
-	CreatedAt   time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
-	UpdatedAt   time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+	Scope        string         `gorm:"column:scope;size:15;not null;primaryKey;check:scope IN ('client','conversation','organization')" json:"scope"`
+	Name         string         `gorm:"column:name;size:100;not null;primaryKey;check:name REGEXP '^[a-z_]+$'" json:"name"`
+	DataType     string         `gorm:"column:data_type;size:20;not null;check:data_type IN ('int','float','date','datetime','string','select','multi_select','boolean','email','url','phone')" json:"data_type"`
+	Validation   *string        `gorm:"column:validation;size:500" json:"validation"`
+	Options      datatypes.JSON `gorm:"column:options;type:json" json:"options"`
+	DepartmentID *uint          `gorm:"column:department_id;index;fk:departments" json:"department_id"`
+	Title        string         `gorm:"column:title;size:255;not null" json:"title"`
+	Description  *string        `gorm:"column:description;type:text" json:"description"`
+	Visibility   string         `gorm:"column:visibility;size:20;not null;check:visibility IN ('everyone','administrator','hidden')" json:"visibility"`
+	CreatedAt    time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
 
 	restify.API
 }
@@ -58,3 +112,168 @@ type CustomAttribute struct {
 func (CustomAttribute) TableName() string {
 	return "custom_attributes"
 }
+
+// OptionValues returns the allowed values of a select or multi_select attribute
+func (c CustomAttribute) OptionValues() []string {
+	if len(c.Options) == 0 {
+		return nil
+	}
+	var options []string
+	if err := json.Unmarshal(c.Options, &options); err != nil {
+		return nil
+	}
+	return options
+}
+
+// HasOptions reports whether the attribute's data type restricts values to a list of options
+func (c CustomAttribute) HasOptions() bool {
+	return c.DataType == CustomAttributeDataTypeSelect || c.DataType == CustomAttributeDataTypeMultiSelect
+}
+
+// ValidateDefinition checks the data type, options and department scoping of an attribute definition
+func (c CustomAttribute) ValidateDefinition() error {
+	if !IsValidCustomAttributeDataType(c.DataType) {
+		return fmt.Errorf("data type must be one of: %s", strings.Join(CustomAttributeDataTypes, ", "))
+	}
+	if c.HasOptions() && len(c.OptionValues()) == 0 {
+		return fmt.Errorf("options must be a non-empty list of strings for %s attributes", c.DataType)
+	}
+	if c.DepartmentID != nil && c.Scope != CustomAttributeScopeConversation {
+		return fmt.Errorf("only conversation attributes can be scoped to a department")
+	}
+	return c.Rules().Validate()
+}
+
+// CustomAttributeRule is a single parsed rule from a custom attribute validation string
+type CustomAttributeRule struct {
+	Name  string
+	Value string
+}
+
+// CustomAttributeRules is the parsed form of a custom attribute validation string
+type CustomAttributeRules []CustomAttributeRule
+
+// Rules parses the validation string of the attribute
+func (c CustomAttribute) Rules() CustomAttributeRules {
+	return ParseCustomAttributeRules(c.Validation)
+}
+
+// ParseCustomAttributeRules splits a comma-separated validation string into rules. A comma preceded
+// by a backslash is part of the rule value. A regex or pattern rule consumes the remainder of the
+// string so expressions may contain commas.
+func ParseCustomAttributeRules(rules *string) CustomAttributeRules {
+	if rules == nil || *rules == "" {
+		return nil
+	}
+
+	var result CustomAttributeRules
+	parts := splitUnescaped(*rules, ',')
+	for i := 0; i < len(parts); i++ {
+		rule := strings.TrimSpace(parts[i])
+		if rule == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(rule, ":")
+		name = strings.TrimSpace(name)
+		if name == "regex" || name == "pattern" {
+			value = strings.Join(append([]string{value}, parts[i+1:]...), ",")
+			result = append(result, CustomAttributeRule{Name: name, Value: strings.TrimSpace(value)})
+			break
+		}
+		value = strings.ReplaceAll(strings.TrimSpace(value), `\,`, ",")
+		result = append(result, CustomAttributeRule{Name: name, Value: value})
+	}
+	return result
+}
+
+// splitUnescaped splits s at every sep that is not preceded by a backslash, keeping the escapes
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == sep {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Get returns the value of the first rule with the given name
+func (r CustomAttributeRules) Get(name string) (string, bool) {
+	for _, rule := range r {
+		if rule.Name == name {
+			return rule.Value, true
+		}
+	}
+	return "", false
+}
+
+// Has reports whether a rule with the given name exists
+func (r CustomAttributeRules) Has(name string) bool {
+	_, ok := r.Get(name)
+	return ok
+}
+
+// Validate checks that every rule is known and has a well-formed argument
+func (r CustomAttributeRules) Validate() error {
+	for _, rule := range r {
+		switch rule.Name {
+		case "required":
+			if rule.Value != "" {
+				return fmt.Errorf("rule required takes no value")
+			}
+		case "min", "max":
+			if _, err := strconv.ParseFloat(rule.Value, 64); err != nil {
+				return fmt.Errorf("rule %s needs a number, got %q", rule.Name, rule.Value)
+			}
+		case "minlen", "maxlen":
+			if n, err := strconv.Atoi(rule.Value); err != nil || n < 0 {
+				return fmt.Errorf("rule %s needs a non-negative integer, got %q", rule.Name, rule.Value)
+			}
+		case "regex", "pattern":
+			if _, err := regexp.Compile(rule.Value); err != nil {
+				return fmt.Errorf("invalid %s: %w", rule.Name, err)
+			}
+		case "default":
+		default:
+			return fmt.Errorf("unknown validation rule %q", rule.Name)
+		}
+	}
+	return nil
+}
+
+// CustomAttributeValueString formats a stored custom attribute value (from Conversation.CustomFields
+// or Client.Data) as plain text. Multi-select values are joined with commas; missing attributes
+// resolve to an empty string.
+func CustomAttributeValueString(data datatypes.JSON, name string) string {
+	if len(data) == 0 {
+		return ""
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return ""
+	}
+
+	switch v := values[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprintf("%v", item))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -15,7 +15,8 @@ const (
 
 type Department struct {
 	ID          uint      `gorm:"column:id;primaryKey" json:"id"`
-	Name        string    `gorm:"column:name;size:255;uniqueIndex;not null" json:"name"`
+	WorkspaceID uint      `gorm:"column:workspace_id;not null;default:1;uniqueIndex:idx_departments_workspace_name" json:"workspace_id"`
+	Name        string    `gorm:"column:name;size:255;uniqueIndex:idx_departments_workspace_name;not null" json:"name"`
 	Description string    `gorm:"column:description;type:text" json:"description"`
 	Status      string    `gorm:"column:status;size:20;not null;default:'active';check:status IN ('active','suspended')" json:"status"`
 	AIAgentID   *uint     `gorm:"column:ai_agent_id;index" json:"ai_agent_id"`
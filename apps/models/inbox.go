@@ -3,29 +3,136 @@ package models
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/getevo/evo/v2/lib/db"
+	"github.com/iesreza/homa-backend/lib/tenant"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 // Inbox represents a web chat inbox with its own SDK configuration and timeout
 type Inbox struct {
-	ID                  uint           `gorm:"primaryKey" json:"id"`
-	Name                string         `gorm:"size:255;not null" json:"name"`
-	Description         string         `gorm:"size:500" json:"description"`
-	SDKConfig           datatypes.JSON `gorm:"type:json" json:"sdk_config"`
-	ConversationTimeout int            `gorm:"default:48" json:"conversation_timeout"` // hours until auto-close, 0 = disabled
-	APIKey              string         `gorm:"size:100;uniqueIndex;not null" json:"api_key"`
-	Enabled             bool           `gorm:"default:1" json:"enabled"`
-	CreatedAt           time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt           time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	ID                      uint           `gorm:"primaryKey" json:"id"`
+	WorkspaceID             uint           `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	Name                    string         `gorm:"size:255;not null" json:"name"`
+	Description             string         `gorm:"size:500" json:"description"`
+	SDKConfig               datatypes.JSON `gorm:"type:json" json:"sdk_config"`
+	ConversationTimeout     int            `gorm:"default:48" json:"conversation_timeout"` // hours until auto-close, 0 = disabled
+	APIKey                  string         `gorm:"size:100;uniqueIndex;not null" json:"api_key"`
+	Enabled                 bool           `gorm:"default:1" json:"enabled"`
+	IdentitySecret          string         `gorm:"size:512" json:"-"`                  // Signs the identities of logged-in website users, encrypted at rest
+	IdentityRequired        bool           `gorm:"default:0" json:"identity_required"` // Reject visitor identities that are not signed
+	IdentityVerified        bool           `gorm:"-" json:"identity_verification"`     // Whether an identity secret is set
+	AllowedOrigins          datatypes.JSON `gorm:"type:json" json:"allowed_origins"`   // Origins the widget may be used from, empty allows any
+	RateLimit               int            `gorm:"default:0" json:"rate_limit"`        // Widget requests per minute per visitor, 0 = only the global limits
+	PreviousAPIKey          string         `gorm:"size:100;index" json:"-"`            // Replaced API key, valid until PreviousAPIKeyExpiresAt
+	PreviousAPIKeyExpiresAt *time.Time     `json:"previous_api_key_expires_at"`
+	CreatedAt               time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt               time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 }
 
 func (Inbox) TableName() string {
 	return "inboxes"
 }
 
+// PreChatForm is the pre-chat form shown by the livechat widget before a conversation starts.
+// It is stored under the "pre_chat_form" key of Inbox.SDKConfig.
+type PreChatForm struct {
+	Enabled      bool                `json:"enabled"`
+	Title        string              `json:"title,omitempty"`
+	Description  string              `json:"description,omitempty"`
+	RequireName  bool                `json:"require_name"`
+	RequireEmail bool                `json:"require_email"`
+	Fields       []PreChatFormField  `json:"fields"`
+	Consent      *PreChatFormConsent `json:"consent,omitempty"`
+}
+
+// PreChatFormField references a client or conversation custom attribute shown on the form
+type PreChatFormField struct {
+	Scope       string `json:"scope"`
+	Name        string `json:"name"`
+	Label       string `json:"label,omitempty"`
+	Placeholder string `json:"placeholder,omitempty"`
+	Required    bool   `json:"required"`
+}
+
+// PreChatFormConsent is an optional consent checkbox (e.g. privacy policy acceptance)
+type PreChatFormConsent struct {
+	Enabled  bool   `json:"enabled"`
+	Text     string `json:"text"`
+	URL      string `json:"url,omitempty"`
+	Required bool   `json:"required"`
+}
+
+// GetPreChatForm parses the pre-chat form from the inbox SDK config.
+// Returns nil when no form is configured.
+func (i *Inbox) GetPreChatForm() (*PreChatForm, error) {
+	return ParsePreChatForm(i.SDKConfig)
+}
+
+// PreChatFormEnabled reports whether the inbox has an enabled pre-chat form
+func (i *Inbox) PreChatFormEnabled() bool {
+	form, err := i.GetPreChatForm()
+	return err == nil && form != nil && form.Enabled
+}
+
+// ParsePreChatForm extracts the "pre_chat_form" key from an SDK config document
+func ParsePreChatForm(sdkConfig []byte) (*PreChatForm, error) {
+	if len(sdkConfig) == 0 {
+		return nil, nil
+	}
+	var config struct {
+		PreChatForm *PreChatForm `json:"pre_chat_form"`
+	}
+	if err := json.Unmarshal(sdkConfig, &config); err != nil {
+		return nil, fmt.Errorf("invalid sdk_config: %w", err)
+	}
+	return config.PreChatForm, nil
+}
+
+// Validate checks the form definition against the configured custom attributes
+func (f *PreChatForm) Validate() error {
+	seen := make(map[string]bool)
+	for i, field := range f.Fields {
+		if field.Scope != CustomAttributeScopeClient && field.Scope != CustomAttributeScopeConversation {
+			return fmt.Errorf("pre_chat_form.fields[%d]: scope must be 'client' or 'conversation'", i)
+		}
+		key := field.Scope + "." + field.Name
+		if seen[key] {
+			return fmt.Errorf("pre_chat_form.fields[%d]: duplicate field %s", i, key)
+		}
+		seen[key] = true
+
+		var count int64
+		if err := db.Model(&CustomAttribute{}).Where("scope = ? AND name = ?", field.Scope, field.Name).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to verify custom attribute %s: %w", key, err)
+		}
+		if count == 0 {
+			return fmt.Errorf("pre_chat_form.fields[%d]: custom attribute %s does not exist", i, key)
+		}
+	}
+
+	if f.Consent != nil && f.Consent.Enabled && f.Consent.Text == "" {
+		return fmt.Errorf("pre_chat_form.consent: text is required when consent is enabled")
+	}
+	return nil
+}
+
+// ValidateSDKConfig performs server-side validation of the parts of an SDK config the backend relies on
+func ValidateSDKConfig(sdkConfig []byte) error {
+	form, err := ParsePreChatForm(sdkConfig)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		return form.Validate()
+	}
+	return nil
+}
+
 // GenerateAPIKey generates a random API key for an inbox
 func GenerateInboxAPIKey() string {
 	bytes := make([]byte, 32)
@@ -33,10 +140,10 @@ func GenerateInboxAPIKey() string {
 	return "inbox_" + hex.EncodeToString(bytes)
 }
 
-// GetInboxByID retrieves an inbox by ID
-func GetInboxByID(id uint) (*Inbox, error) {
+// GetInboxByID retrieves an inbox of the workspace by ID
+func GetInboxByID(workspaceID, id uint) (*Inbox, error) {
 	var inbox Inbox
-	if err := db.First(&inbox, id).Error; err != nil {
+	if err := WorkspaceDB(workspaceID).First(&inbox, id).Error; err != nil {
 		return nil, err
 	}
 	return &inbox, nil
@@ -44,17 +151,24 @@ func GetInboxByID(id uint) (*Inbox, error) {
 
 // GetInboxByAPIKey retrieves an inbox by API key
 func GetInboxByAPIKey(apiKey string) (*Inbox, error) {
+	if apiKey == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
 	var inbox Inbox
-	if err := db.Where("api_key = ?", apiKey).First(&inbox).Error; err != nil {
+	err := db.Where("api_key = ? OR previous_api_key = ?", apiKey, apiKey).First(&inbox).Error
+	if err != nil {
 		return nil, err
 	}
+	if !inbox.AcceptsAPIKey(apiKey, time.Now()) {
+		return nil, gorm.ErrRecordNotFound
+	}
 	return &inbox, nil
 }
 
-// GetAllInboxes retrieves all inboxes
-func GetAllInboxes() ([]Inbox, error) {
+// GetAllInboxes retrieves all inboxes of the workspace
+func GetAllInboxes(workspaceID uint) ([]Inbox, error) {
 	var inboxes []Inbox
-	if err := db.Order("created_at ASC").Find(&inboxes).Error; err != nil {
+	if err := WorkspaceDB(workspaceID).Order("created_at ASC").Find(&inboxes).Error; err != nil {
 		return nil, err
 	}
 	return inboxes, nil
@@ -69,12 +183,12 @@ func GetEnabledInboxes() ([]Inbox, error) {
 	return inboxes, nil
 }
 
-// CreateInbox creates a new inbox
-func CreateInbox(inbox *Inbox) error {
+// CreateInbox creates a new inbox in the workspace
+func CreateInbox(workspaceID uint, inbox *Inbox) error {
 	if inbox.APIKey == "" {
 		inbox.APIKey = GenerateInboxAPIKey()
 	}
-	return db.Create(inbox).Error
+	return WorkspaceDB(OrDefaultWorkspace(workspaceID)).Create(inbox).Error
 }
 
 // UpdateInbox updates an existing inbox
@@ -82,15 +196,15 @@ func UpdateInbox(inbox *Inbox) error {
 	return db.Save(inbox).Error
 }
 
-// DeleteInbox deletes an inbox by ID
-func DeleteInbox(id uint) error {
-	return db.Delete(&Inbox{}, id).Error
+// DeleteInbox deletes an inbox of the workspace by ID
+func DeleteInbox(workspaceID, id uint) error {
+	return WorkspaceDB(workspaceID).Delete(&Inbox{}, id).Error
 }
 
-// GetDefaultInbox returns the first inbox or creates one if none exists
+// GetDefaultInbox returns the first inbox of the default workspace
 func GetDefaultInbox() (*Inbox, error) {
 	var inbox Inbox
-	err := db.Order("created_at ASC").First(&inbox).Error
+	err := WorkspaceDB(tenant.DefaultID).Order("created_at ASC").First(&inbox).Error
 	if err != nil {
 		return nil, err
 	}
@@ -100,7 +214,7 @@ func GetDefaultInbox() (*Inbox, error) {
 // EnsureDefaultInbox creates a default inbox if none exists
 func EnsureDefaultInbox() (*Inbox, error) {
 	var count int64
-	db.Model(&Inbox{}).Count(&count)
+	WorkspaceDB(tenant.DefaultID).Model(&Inbox{}).Count(&count)
 
 	if count == 0 {
 		// Create default inbox with empty SDK config
@@ -111,7 +225,7 @@ func EnsureDefaultInbox() (*Inbox, error) {
 			ConversationTimeout: 48,           // 48 hours default
 			Enabled:             true,
 		}
-		if err := CreateInbox(inbox); err != nil {
+		if err := CreateInbox(tenant.DefaultID, inbox); err != nil {
 			return nil, err
 		}
 		return inbox, nil
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The inbox API key is embedded in public widget code, so it only identifies the inbox. The allowed
+// origins stop other websites from embedding the widget: browsers send the Origin header with every
+// cross-origin request and WebSocket upgrade, and cannot forge it. Requests without an Origin header
+// come from servers or scripts, which could send any header, so they are not restricted.
+
+// MaxAPIKeyGracePeriod is the longest time a replaced inbox API key stays valid
+const MaxAPIKeyGracePeriod = 30 * 24 * time.Hour
+
+// GetAllowedOrigins returns the origins the widget may be used from
+func (i *Inbox) GetAllowedOrigins() []string {
+	var origins []string
+	if len(i.AllowedOrigins) > 0 {
+		_ = json.Unmarshal(i.AllowedOrigins, &origins)
+	}
+	return origins
+}
+
+// OriginAllowed reports whether a browser request with the Origin header may use the inbox.
+// An allowed origin is a scheme and host such as https://example.com, https://*.example.com for
+// its subdomains, or * for any origin.
+func (i *Inbox) OriginAllowed(origin string) bool {
+	allowed := i.GetAllowedOrigins()
+	if len(allowed) == 0 || origin == "" {
+		return true
+	}
+	origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	scheme, host, found := strings.Cut(origin, "://")
+	if !found {
+		return false
+	}
+	for _, pattern := range allowed {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		patternScheme, patternHost, _ := strings.Cut(pattern, "://")
+		if suffix, ok := strings.CutPrefix(patternHost, "*."); ok && patternScheme == scheme && strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeAllowedOrigins validates a list of allowed origins and returns it in the stored form
+func NormalizeAllowedOrigins(origins []string) ([]byte, error) {
+	normalized := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			parsed, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+				parsed.Path != "" || parsed.RawQuery != "" || parsed.User != nil {
+				return nil, fmt.Errorf("invalid origin %q: use a scheme and host such as https://example.com", origin)
+			}
+		}
+		normalized = append(normalized, origin)
+	}
+	return json.Marshal(normalized)
+}
+
+// AcceptsAPIKey reports whether key is the API key of the inbox, or its replaced key within the grace period at now
+func (i *Inbox) AcceptsAPIKey(key string, now time.Time) bool {
+	if key == "" {
+		return false
+	}
+	if key == i.APIKey {
+		return true
+	}
+	return key == i.PreviousAPIKey && i.PreviousAPIKeyExpiresAt != nil && now.Before(*i.PreviousAPIKeyExpiresAt)
+}
+
+// RotateAPIKey replaces the API key of the inbox. The replaced key keeps working for the grace period,
+// so widgets already deployed with it can be updated.
+func (i *Inbox) RotateAPIKey(gracePeriod time.Duration) {
+	i.PreviousAPIKey = ""
+	i.PreviousAPIKeyExpiresAt = nil
+	if gracePeriod > 0 {
+		expiresAt := time.Now().Add(min(gracePeriod, MaxAPIKeyGracePeriod))
+		i.PreviousAPIKey = i.APIKey
+		i.PreviousAPIKeyExpiresAt = &expiresAt
+	}
+	i.APIKey = GenerateInboxAPIKey()
+}
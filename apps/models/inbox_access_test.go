@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	inbox := func(origins ...string) *Inbox {
+		raw, _ := json.Marshal(origins)
+		return &Inbox{AllowedOrigins: raw}
+	}
+
+	tests := []struct {
+		name    string
+		inbox   *Inbox
+		origin  string
+		allowed bool
+	}{
+		{name: "no origins configured", inbox: &Inbox{}, origin: "https://evil.example", allowed: true},
+		{name: "request without origin", inbox: inbox("https://example.com"), origin: "", allowed: true},
+		{name: "exact match", inbox: inbox("https://example.com"), origin: "https://example.com", allowed: true},
+		{name: "case and trailing slash", inbox: inbox("https://example.com"), origin: "HTTPS://Example.com/", allowed: true},
+		{name: "other host", inbox: inbox("https://example.com"), origin: "https://example.org", allowed: false},
+		{name: "other scheme", inbox: inbox("https://example.com"), origin: "http://example.com", allowed: false},
+		{name: "other port", inbox: inbox("https://example.com"), origin: "https://example.com:8443", allowed: false},
+		{name: "suffix is not a subdomain", inbox: inbox("https://example.com"), origin: "https://evilexample.com", allowed: false},
+		{name: "wildcard subdomain", inbox: inbox("https://*.example.com"), origin: "https://app.example.com", allowed: true},
+		{name: "wildcard nested subdomain", inbox: inbox("https://*.example.com"), origin: "https://a.b.example.com", allowed: true},
+		{name: "wildcard does not cover the apex", inbox: inbox("https://*.example.com"), origin: "https://example.com", allowed: false},
+		{name: "wildcard lookalike", inbox: inbox("https://*.example.com"), origin: "https://app.evilexample.com", allowed: false},
+		{name: "wildcard other scheme", inbox: inbox("https://*.example.com"), origin: "http://app.example.com", allowed: false},
+		{name: "any origin", inbox: inbox("*"), origin: "https://anything.example", allowed: true},
+		{name: "second entry", inbox: inbox("https://example.com", "https://shop.example.org"), origin: "https://shop.example.org", allowed: true},
+		{name: "malformed origin", inbox: inbox("https://example.com"), origin: "example.com", allowed: false},
+		{name: "null origin", inbox: inbox("https://example.com"), origin: "null", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.inbox.OriginAllowed(tt.origin); got != tt.allowed {
+				t.Errorf("OriginAllowed(%q) = %v, want %v", tt.origin, got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestNormalizeAllowedOrigins(t *testing.T) {
+	raw, err := NormalizeAllowedOrigins([]string{" https://Example.com/ ", "", "https://*.example.org", "*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(raw); got != `["https://example.com","https://*.example.org","*"]` {
+		t.Errorf("NormalizeAllowedOrigins = %s", got)
+	}
+
+	for _, origin := range []string{"example.com", "ftp://example.com", "https://example.com/widget", "https://example.com?x=1", "https://user@example.com"} {
+		if _, err := NormalizeAllowedOrigins([]string{origin}); err == nil {
+			t.Errorf("NormalizeAllowedOrigins(%q) accepted an invalid origin", origin)
+		}
+	}
+}
+
+func TestRotateAPIKeyGracePeriod(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		gracePeriod time.Duration
+		at          time.Duration // when the old key is used, relative to the rotation
+		oldAccepted bool
+	}{
+		{name: "no grace period", gracePeriod: 0, at: 0, oldAccepted: false},
+		{name: "within grace period", gracePeriod: 24 * time.Hour, at: 23 * time.Hour, oldAccepted: true},
+		{name: "after grace period", gracePeriod: 24 * time.Hour, at: 25 * time.Hour, oldAccepted: false},
+		{name: "grace period is capped", gracePeriod: 365 * 24 * time.Hour, at: MaxAPIKeyGracePeriod + time.Hour, oldAccepted: false},
+		{name: "capped grace period still applies", gracePeriod: 365 * 24 * time.Hour, at: MaxAPIKeyGracePeriod - time.Hour, oldAccepted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inbox := &Inbox{APIKey: "inbox_old"}
+			inbox.RotateAPIKey(tt.gracePeriod)
+
+			if inbox.APIKey == "inbox_old" || !inbox.AcceptsAPIKey(inbox.APIKey, now.Add(tt.at)) {
+				t.Fatalf("the new key %q is not accepted", inbox.APIKey)
+			}
+			if got := inbox.AcceptsAPIKey("inbox_old", now.Add(tt.at)); got != tt.oldAccepted {
+				t.Errorf("old key accepted = %v, want %v", got, tt.oldAccepted)
+			}
+		})
+	}
+
+	// Rotating again ends the grace period of the key replaced first
+	inbox := &Inbox{APIKey: "inbox_first"}
+	inbox.RotateAPIKey(time.Hour)
+	inbox.RotateAPIKey(time.Hour)
+	if inbox.AcceptsAPIKey("inbox_first", now) {
+		t.Error("a key replaced two rotations ago is still accepted")
+	}
+	if inbox.AcceptsAPIKey("", now) {
+		t.Error("an empty key is accepted")
+	}
+}
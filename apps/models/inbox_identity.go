@@ -0,0 +1,134 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// A website that knows who its visitor is can vouch for them: it signs the visitor's identifier with
+// the identity secret of the inbox, and the widget sends the signature along. The identifier is
+// either signed directly (identity_hash, the hex HMAC-SHA256 of "<identifier>:<identity_timestamp>",
+// the Unix time of signing) or put in a short-lived HS256 JWT (identity_token) with an email and/or
+// sub claim. Both expire, so a leaked signature cannot be replayed forever.
+
+// IdentityTokenMaxTTL is the longest lifetime accepted for an identity token
+const IdentityTokenMaxTTL = 24 * time.Hour
+
+// IdentityHashMaxAge is how long an identity hash is accepted after its timestamp
+const IdentityHashMaxAge = 24 * time.Hour
+
+// identityClockSkew is how far in the future an identity hash timestamp may be, for website clocks running ahead
+const identityClockSkew = 5 * time.Minute
+
+var (
+	ErrIdentityNotEnabled = errors.New("identity verification is not enabled for this inbox")
+	ErrIdentityRequired   = errors.New("this inbox requires a signed identity")
+	ErrIdentityInvalid    = errors.New("identity could not be verified")
+	ErrIdentityExpired    = errors.New("identity proof is expired or lives too long")
+	ErrIdentityMismatch   = errors.New("identity does not match the signed identity")
+)
+
+// VerifiedIdentity is a visitor identifier signed by the website
+type VerifiedIdentity struct {
+	Type  string // ExternalIDTypeEmail or ExternalIDTypeWeb (the website's own user ID)
+	Value string
+	Name  string
+}
+
+// identityClaims are the claims of an identity token
+type identityClaims struct {
+	Email string `json:"email,omitempty"`
+	Name  string `json:"name,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateIdentitySecret creates a new identity secret for an inbox
+func GenerateIdentitySecret() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return "idsec_" + hex.EncodeToString(bytes)
+}
+
+// IdentityVerificationEnabled reports whether the inbox has an identity secret
+func (i *Inbox) IdentityVerificationEnabled() bool {
+	return i != nil && i.IdentitySecret != ""
+}
+
+// IdentityHash returns the signature the website sends for value signed at timestamp (Unix seconds)
+func (i *Inbox) IdentityHash(value string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(i.IdentitySecret))
+	mac.Write([]byte(value + ":" + strconv.FormatInt(timestamp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyIdentityHash checks the identity hash of an identifier of the given type and that it was
+// signed within IdentityHashMaxAge
+func (i *Inbox) VerifyIdentityHash(identityType, value, hash string, timestamp int64) (*VerifiedIdentity, error) {
+	if !i.IdentityVerificationEnabled() {
+		return nil, ErrIdentityNotEnabled
+	}
+	if value == "" || !hmac.Equal([]byte(i.IdentityHash(value, timestamp)), []byte(strings.ToLower(hash))) {
+		return nil, ErrIdentityInvalid
+	}
+	signedAt := time.Unix(timestamp, 0)
+	if time.Since(signedAt) > IdentityHashMaxAge || time.Until(signedAt) > identityClockSkew {
+		return nil, ErrIdentityExpired
+	}
+	return &VerifiedIdentity{Type: identityType, Value: value}, nil
+}
+
+// VerifyIdentityToken checks an identity token. The email claim is preferred over sub as the identifier.
+func (i *Inbox) VerifyIdentityToken(tokenString string) (*VerifiedIdentity, error) {
+	if !i.IdentityVerificationEnabled() {
+		return nil, ErrIdentityNotEnabled
+	}
+
+	claims := &identityClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		return []byte(i.IdentitySecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrIdentityExpired
+	}
+	if err != nil {
+		return nil, ErrIdentityInvalid
+	}
+	// Tokens are meant to be minted per page load, not stored by the browser
+	if claims.ExpiresAt.Time.After(time.Now().Add(IdentityTokenMaxTTL)) {
+		return nil, ErrIdentityExpired
+	}
+
+	identity := &VerifiedIdentity{Name: claims.Name}
+	switch {
+	case claims.Email != "":
+		identity.Type, identity.Value = ExternalIDTypeEmail, claims.Email
+	case claims.Subject != "":
+		identity.Type, identity.Value = ExternalIDTypeWeb, claims.Subject
+	default:
+		return nil, ErrIdentityInvalid
+	}
+	return identity, nil
+}
+
+// Matches reports whether a claimed identifier agrees with the verified identity. No claim always
+// matches; a claim of another type is not covered by the signature and never does.
+func (v *VerifiedIdentity) Matches(identityType, value string) bool {
+	if value == "" {
+		return true
+	}
+	if identityType != v.Type {
+		return false
+	}
+	if v.Type == ExternalIDTypeEmail {
+		return strings.EqualFold(value, v.Value)
+	}
+	return value == v.Value
+}
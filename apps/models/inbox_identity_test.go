@@ -0,0 +1,119 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestVerifyIdentityHash(t *testing.T) {
+	inbox := &Inbox{IdentitySecret: "idsec_test"}
+	now := time.Now().Unix()
+	valid := inbox.IdentityHash("jane@example.com", now)
+
+	tests := []struct {
+		name      string
+		inbox     *Inbox
+		value     string
+		hash      string
+		timestamp int64
+		want      error
+	}{
+		{name: "valid", inbox: inbox, value: "jane@example.com", hash: valid, timestamp: now},
+		{name: "uppercase hex", inbox: inbox, value: "jane@example.com", hash: strings.ToUpper(valid), timestamp: now},
+		{name: "other identifier", inbox: inbox, value: "john@example.com", hash: valid, timestamp: now, want: ErrIdentityInvalid},
+		{name: "changed timestamp", inbox: inbox, value: "jane@example.com", hash: valid, timestamp: now + 1, want: ErrIdentityInvalid},
+		{name: "rotated secret", inbox: &Inbox{IdentitySecret: "idsec_new"}, value: "jane@example.com", hash: valid, timestamp: now, want: ErrIdentityInvalid},
+		{name: "expired", inbox: inbox, value: "jane@example.com", timestamp: now - int64(IdentityHashMaxAge.Seconds()) - 60, want: ErrIdentityExpired},
+		{name: "signed in the future", inbox: inbox, value: "jane@example.com", timestamp: now + int64(time.Hour.Seconds()), want: ErrIdentityExpired},
+		{name: "empty identifier", inbox: inbox, value: "", timestamp: now, want: ErrIdentityInvalid},
+		{name: "not enabled", inbox: &Inbox{}, value: "jane@example.com", hash: valid, timestamp: now, want: ErrIdentityNotEnabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := tt.hash
+			if hash == "" {
+				hash = tt.inbox.IdentityHash(tt.value, tt.timestamp)
+			}
+			identity, err := tt.inbox.VerifyIdentityHash(ExternalIDTypeEmail, tt.value, hash, tt.timestamp)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (identity.Type != ExternalIDTypeEmail || identity.Value != tt.value) {
+				t.Errorf("identity = %+v, want the signed email", identity)
+			}
+		})
+	}
+}
+
+func TestVerifyIdentityToken(t *testing.T) {
+	inbox := &Inbox{IdentitySecret: "idsec_test"}
+	sign := func(method jwt.SigningMethod, secret any, claims identityClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	expiresIn := func(d time.Duration) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(d))}
+	}
+	secret := []byte(inbox.IdentitySecret)
+	withSubject := expiresIn(time.Hour)
+	withSubject.Subject = "user-42"
+
+	tests := []struct {
+		name      string
+		token     string
+		want      error
+		wantType  string
+		wantValue string
+	}{
+		{name: "email", token: sign(jwt.SigningMethodHS256, secret, identityClaims{Email: "jane@example.com", RegisteredClaims: expiresIn(time.Hour)}), wantType: ExternalIDTypeEmail, wantValue: "jane@example.com"},
+		{name: "subject", token: sign(jwt.SigningMethodHS256, secret, identityClaims{RegisteredClaims: withSubject}), wantType: ExternalIDTypeWeb, wantValue: "user-42"},
+		{name: "expired", token: sign(jwt.SigningMethodHS256, secret, identityClaims{Email: "jane@example.com", RegisteredClaims: expiresIn(-time.Minute)}), want: ErrIdentityExpired},
+		{name: "lives too long", token: sign(jwt.SigningMethodHS256, secret, identityClaims{Email: "jane@example.com", RegisteredClaims: expiresIn(IdentityTokenMaxTTL + time.Hour)}), want: ErrIdentityExpired},
+		{name: "no expiry", token: sign(jwt.SigningMethodHS256, secret, identityClaims{Email: "jane@example.com"}), want: ErrIdentityInvalid},
+		{name: "rotated secret", token: sign(jwt.SigningMethodHS256, []byte("idsec_old"), identityClaims{Email: "jane@example.com", RegisteredClaims: expiresIn(time.Hour)}), want: ErrIdentityInvalid},
+		{name: "other algorithm", token: sign(jwt.SigningMethodHS512, secret, identityClaims{Email: "jane@example.com", RegisteredClaims: expiresIn(time.Hour)}), want: ErrIdentityInvalid},
+		{name: "no identifier", token: sign(jwt.SigningMethodHS256, secret, identityClaims{RegisteredClaims: expiresIn(time.Hour)}), want: ErrIdentityInvalid},
+		{name: "malformed", token: "not-a-token", want: ErrIdentityInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := inbox.VerifyIdentityToken(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (identity.Type != tt.wantType || identity.Value != tt.wantValue) {
+				t.Errorf("identity = %+v, want %s %s", identity, tt.wantType, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestVerifiedIdentityMatches(t *testing.T) {
+	identity := &VerifiedIdentity{Type: ExternalIDTypeEmail, Value: "Jane@Example.com"}
+	tests := []struct {
+		claimType, claimValue string
+		want                  bool
+	}{
+		{ExternalIDTypeEmail, "jane@example.com", true},
+		{ExternalIDTypeEmail, "john@example.com", false},
+		{ExternalIDTypeEmail, "", true},
+		{ExternalIDTypeWeb, "Jane@Example.com", false},
+	}
+	for _, tt := range tests {
+		if got := identity.Matches(tt.claimType, tt.claimValue); got != tt.want {
+			t.Errorf("Matches(%q, %q) = %v, want %v", tt.claimType, tt.claimValue, got, tt.want)
+		}
+	}
+
+	web := &VerifiedIdentity{Type: ExternalIDTypeWeb, Value: "User-42"}
+	if web.Matches(ExternalIDTypeWeb, "user-42") {
+		t.Error("website user IDs must match exactly")
+	}
+}
@@ -26,11 +26,12 @@ const (
 
 // Integration represents a messaging channel integration configuration
 type Integration struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Type      string    `gorm:"size:50;not null;uniqueIndex" json:"type"` // slack, telegram, whatsapp, smtp, gmail, outlook
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	WorkspaceID uint      `gorm:"column:workspace_id;not null;default:1;uniqueIndex:idx_integrations_workspace_type" json:"workspace_id"`
+	Type        string    `gorm:"size:50;not null;uniqueIndex:idx_integrations_workspace_type" json:"type"` // slack, telegram, whatsapp, smtp, gmail, outlook
 	Name      string    `gorm:"size:255;not null" json:"name"`
 	Status    string    `gorm:"size:50;not null;default:'disabled'" json:"status"` // disabled, enabled, error
-	Config    string    `gorm:"type:text" json:"-"`                                // Encrypted JSON config (hidden from API)
+	Config    string    `gorm:"type:text" json:"-"`                                // JSON config, encrypted at rest (hidden from API)
 	LastError string    `gorm:"type:text" json:"last_error,omitempty"`
 	InboxID   *uint     `gorm:"index" json:"inbox_id,omitempty"`                   // Default inbox for conversations from this integration
 	Inbox     *Inbox    `gorm:"foreignKey:InboxID" json:"inbox,omitempty"`
@@ -96,10 +97,10 @@ type OutlookConfig struct {
 	Email        string `json:"email"`
 }
 
-// GetIntegration retrieves an integration by type
-func GetIntegration(integrationType string) (*Integration, error) {
+// GetIntegration retrieves an integration of the workspace by type
+func GetIntegration(workspaceID uint, integrationType string) (*Integration, error) {
 	var integration Integration
-	err := db.Where("type = ?", integrationType).First(&integration).Error
+	err := WorkspaceDB(OrDefaultWorkspace(workspaceID)).Where("type = ?", integrationType).First(&integration).Error
 	return &integration, err
 }
 
@@ -127,17 +128,18 @@ func GetEnabledEmailIntegrations() ([]Integration, error) {
 	return integrations, err
 }
 
-// UpsertIntegration creates or updates an integration by type
-func UpsertIntegration(integration *Integration) error {
+// UpsertIntegration creates or updates an integration of the workspace by type
+func UpsertIntegration(workspaceID uint, integration *Integration) error {
+	tx := WorkspaceDB(OrDefaultWorkspace(workspaceID))
 	var existing Integration
-	err := db.Where("type = ?", integration.Type).First(&existing).Error
+	err := tx.Where("type = ?", integration.Type).First(&existing).Error
 	if err != nil {
 		// Create new
-		return db.Create(integration).Error
+		return tx.Create(integration).Error
 	}
 	// Update existing
 	integration.ID = existing.ID
-	return db.Save(integration).Error
+	return tx.Save(integration).Error
 }
 
 // IntegrationTypeInfo provides display info for integration types
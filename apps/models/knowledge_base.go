@@ -12,8 +12,9 @@ import (
 // KnowledgeBaseArticle represents an article in the knowledge base
 type KnowledgeBaseArticle struct {
 	ID            uuid.UUID  `gorm:"column:id;type:char(36);primaryKey" json:"id"`
+	WorkspaceID   uint       `gorm:"column:workspace_id;not null;default:1;uniqueIndex:idx_kb_articles_workspace_slug" json:"workspace_id"`
 	Title         string     `gorm:"column:title;size:500;not null;index" json:"title"`
-	Slug          string     `gorm:"column:slug;size:500;uniqueIndex;not null" json:"slug"`
+	Slug          string     `gorm:"column:slug;size:500;uniqueIndex:idx_kb_articles_workspace_slug;not null" json:"slug"`
 	Content       string     `gorm:"column:content;type:longtext;not null" json:"content"`
 	Summary       string     `gorm:"column:summary;type:text" json:"summary"`
 	FeaturedImage string     `gorm:"column:featured_image;size:500" json:"featured_image"`
@@ -40,8 +41,9 @@ type KnowledgeBaseArticle struct {
 // KnowledgeBaseCategory represents a category for organizing articles
 type KnowledgeBaseCategory struct {
 	ID          uuid.UUID  `gorm:"column:id;type:char(36);primaryKey" json:"id"`
+	WorkspaceID uint       `gorm:"column:workspace_id;not null;default:1;uniqueIndex:idx_kb_categories_workspace_slug" json:"workspace_id"`
 	Name        string     `gorm:"column:name;size:255;not null" json:"name"`
-	Slug        string     `gorm:"column:slug;size:255;uniqueIndex;not null" json:"slug"`
+	Slug        string     `gorm:"column:slug;size:255;uniqueIndex:idx_kb_categories_workspace_slug;not null" json:"slug"`
 	Description string     `gorm:"column:description;type:text" json:"description"`
 	ParentID    *uuid.UUID `gorm:"column:parent_id;type:char(36);index" json:"parent_id"`
 	Icon        string     `gorm:"column:icon;size:100" json:"icon"`
@@ -78,11 +80,12 @@ type KnowledgeBaseMedia struct {
 
 // KnowledgeBaseTag represents a tag for articles
 type KnowledgeBaseTag struct {
-	ID        uuid.UUID `gorm:"column:id;type:char(36);primaryKey" json:"id"`
-	Name      string    `gorm:"column:name;size:100;uniqueIndex;not null" json:"name"`
-	Slug      string    `gorm:"column:slug;size:100;uniqueIndex;not null" json:"slug"`
-	Color     string    `gorm:"column:color;size:20" json:"color"`
-	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	ID          uuid.UUID `gorm:"column:id;type:char(36);primaryKey" json:"id"`
+	WorkspaceID uint      `gorm:"column:workspace_id;not null;default:1;uniqueIndex:idx_kb_tags_workspace_name;uniqueIndex:idx_kb_tags_workspace_slug" json:"workspace_id"`
+	Name        string    `gorm:"column:name;size:100;uniqueIndex:idx_kb_tags_workspace_name;not null" json:"name"`
+	Slug        string    `gorm:"column:slug;size:100;uniqueIndex:idx_kb_tags_workspace_slug;not null" json:"slug"`
+	Color       string    `gorm:"column:color;size:20" json:"color"`
+	CreatedAt   time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
 
 	// Relationships
 	Articles []KnowledgeBaseArticle `gorm:"many2many:knowledge_base_article_tags;foreignKey:ID;joinForeignKey:TagID;references:ID;joinReferences:ArticleID" json:"-"`
@@ -149,7 +152,7 @@ func (KnowledgeBaseMedia) TableName() string {
 // This allows the models package to trigger indexing without importing the ai package
 type KnowledgeBaseIndexer interface {
 	IndexArticle(articleID uuid.UUID) error
-	DeleteArticleIndex(articleID uuid.UUID) error
+	DeleteArticleIndex(workspaceID uint, articleID uuid.UUID) error
 }
 
 // Global indexer - set by the ai package during initialization
@@ -192,7 +195,7 @@ func (a *KnowledgeBaseArticle) AfterUpdate(tx *gorm.DB) error {
 				}
 			} else {
 				// Remove from index if not published
-				if err := knowledgeBaseIndexer.DeleteArticleIndex(a.ID); err != nil {
+				if err := knowledgeBaseIndexer.DeleteArticleIndex(a.WorkspaceID, a.ID); err != nil {
 					log.Error("Failed to delete article index %s: %v", a.ID, err)
 				}
 			}
@@ -205,7 +208,7 @@ func (a *KnowledgeBaseArticle) AfterUpdate(tx *gorm.DB) error {
 func (a *KnowledgeBaseArticle) AfterDelete(tx *gorm.DB) error {
 	if knowledgeBaseIndexer != nil {
 		go func() {
-			if err := knowledgeBaseIndexer.DeleteArticleIndex(a.ID); err != nil {
+			if err := knowledgeBaseIndexer.DeleteArticleIndex(a.WorkspaceID, a.ID); err != nil {
 				log.Error("Failed to delete article index %s: %v", a.ID, err)
 			}
 		}()
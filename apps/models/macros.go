@@ -0,0 +1,122 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// Macro bundles a templated reply with conversation actions that are applied together in one click.
+// Every action is optional; nil fields leave the conversation untouched.
+//
+// A macro without DepartmentID is global (managed by administrators, usable by everyone);
+// a department macro is managed and used by members of that department.
+//
+// Reply is posted as an agent message and supports the same placeholders as canned messages.
+// When Reassign is set, all assignments are replaced with AssignUserIDs, AssignDepartmentID and,
+// if AssignToActor is set, the agent applying the macro.
+type Macro struct {
+	ID                 uint           `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID        uint           `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	Name               string         `gorm:"column:name;size:255;not null" json:"name"`
+	Description        string         `gorm:"column:description;size:500" json:"description"`
+	Reply              *string        `gorm:"column:reply;type:text" json:"reply"`
+	Status             *string        `gorm:"column:status;size:50" json:"status"`
+	Priority           *string        `gorm:"column:priority;size:50" json:"priority"`
+	HandleByBot        *bool          `gorm:"column:handle_by_bot" json:"handle_by_bot"`
+	AddTagIDs          datatypes.JSON `gorm:"column:add_tag_ids;type:json" json:"add_tag_ids"`
+	RemoveTagIDs       datatypes.JSON `gorm:"column:remove_tag_ids;type:json" json:"remove_tag_ids"`
+	Reassign           bool           `gorm:"column:reassign;default:0" json:"reassign"`
+	AssignUserIDs      datatypes.JSON `gorm:"column:assign_user_ids;type:json" json:"assign_user_ids"`
+	AssignDepartmentID *uint          `gorm:"column:assign_department_id;fk:departments" json:"assign_department_id"`
+	AssignToActor      bool           `gorm:"column:assign_to_actor;default:0" json:"assign_to_actor"`
+	DepartmentID       *uint          `gorm:"column:department_id;index;fk:departments" json:"department_id"`
+	IsActive           bool           `gorm:"column:is_active;default:1" json:"is_active"`
+	UsageCount         uint           `gorm:"column:usage_count;default:0" json:"usage_count"`
+	LastUsedAt         *time.Time     `gorm:"column:last_used_at" json:"last_used_at"`
+	CreatedBy          *uuid.UUID     `gorm:"column:created_by;type:char(36);fk:users" json:"created_by"`
+	CreatedAt          time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	// Relationships
+	Department *Department `gorm:"foreignKey:DepartmentID;references:ID" json:"department,omitempty"`
+
+	restify.API
+}
+
+// AddTags returns the IDs of tags the macro adds
+func (m *Macro) AddTags() []uint {
+	return decodeUintList(m.AddTagIDs)
+}
+
+// RemoveTags returns the IDs of tags the macro removes
+func (m *Macro) RemoveTags() []uint {
+	return decodeUintList(m.RemoveTagIDs)
+}
+
+// AssignUsers returns the users the macro assigns when reassigning
+func (m *Macro) AssignUsers() []uuid.UUID {
+	var raw []string
+	if len(m.AssignUserIDs) > 0 {
+		_ = json.Unmarshal(m.AssignUserIDs, &raw)
+	}
+	users := make([]uuid.UUID, 0, len(raw))
+	for _, item := range raw {
+		if id, err := uuid.Parse(item); err == nil {
+			users = append(users, id)
+		}
+	}
+	return users
+}
+
+// Validate checks that the macro does something and that its values are valid
+func (m *Macro) Validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if m.Status != nil && !slices.Contains(ConversationStatuses, *m.Status) {
+		return fmt.Errorf("invalid status %q", *m.Status)
+	}
+	if m.Priority != nil && !slices.Contains(ConversationPriorities, *m.Priority) {
+		return fmt.Errorf("invalid priority %q", *m.Priority)
+	}
+
+	var rawUsers []string
+	if len(m.AssignUserIDs) > 0 {
+		if err := json.Unmarshal(m.AssignUserIDs, &rawUsers); err != nil {
+			return fmt.Errorf("assign_user_ids must be an array of user ids")
+		}
+	}
+	for _, item := range rawUsers {
+		if _, err := uuid.Parse(item); err != nil {
+			return fmt.Errorf("invalid user id %q", item)
+		}
+	}
+
+	add, remove := m.AddTags(), m.RemoveTags()
+	for _, id := range add {
+		if slices.Contains(remove, id) {
+			return fmt.Errorf("tag %d cannot be both added and removed", id)
+		}
+	}
+
+	hasReply := m.Reply != nil && *m.Reply != ""
+	if !hasReply && m.Status == nil && m.Priority == nil && m.HandleByBot == nil &&
+		len(add) == 0 && len(remove) == 0 && !m.Reassign {
+		return fmt.Errorf("macro must post a reply or perform at least one action")
+	}
+	return nil
+}
+
+func decodeUintList(data datatypes.JSON) []uint {
+	var ids []uint
+	if len(data) > 0 {
+		_ = json.Unmarshal(data, &ids)
+	}
+	return ids
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var domainRegex = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,}$`)
+
+// Organization groups clients that belong to the same company.
+// Clients are associated automatically when their email domain matches one of the organization domains.
+type Organization struct {
+	ID          uint           `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID uint           `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	Name        string         `gorm:"column:name;size:255;not null;index" json:"name"`
+	Website     *string        `gorm:"column:website;size:500" json:"website"`
+	Data        datatypes.JSON `gorm:"column:data;type:json" json:"data"`
+	CreatedAt   time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time      `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	// Relationships
+	Domains []OrganizationDomain `gorm:"foreignKey:OrganizationID;references:ID" json:"domains,omitempty"`
+	Notes   []OrganizationNote   `gorm:"foreignKey:OrganizationID;references:ID" json:"notes,omitempty"`
+	Clients []Client             `gorm:"foreignKey:OrganizationID;references:ID" json:"clients,omitempty"`
+
+	restify.API
+}
+
+// OrganizationDomain is an email domain owned by an organization (e.g. "acme.com")
+type OrganizationDomain struct {
+	ID             uint      `gorm:"column:id;primaryKey" json:"id"`
+	WorkspaceID    uint      `gorm:"column:workspace_id;not null;default:1;uniqueIndex:idx_organization_domains_workspace_domain" json:"workspace_id"`
+	OrganizationID uint      `gorm:"column:organization_id;not null;index;fk:organizations" json:"organization_id"`
+	Domain         string    `gorm:"column:domain;size:255;not null;uniqueIndex:idx_organization_domains_workspace_domain" json:"domain"`
+	CreatedAt      time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+
+	restify.API
+}
+
+func (OrganizationDomain) TableName() string {
+	return "organization_domains"
+}
+
+// BeforeCreate hook - a domain belongs to the workspace of its organization
+func (d *OrganizationDomain) BeforeCreate(tx *gorm.DB) error {
+	if d.WorkspaceID == 0 {
+		d.WorkspaceID = workspaceOf(tx, &Organization{}, d.OrganizationID)
+	}
+	return nil
+}
+
+// OrganizationNote is an internal note agents keep about an organization
+type OrganizationNote struct {
+	ID             uint       `gorm:"column:id;primaryKey" json:"id"`
+	OrganizationID uint       `gorm:"column:organization_id;not null;index;fk:organizations" json:"organization_id"`
+	UserID         *uuid.UUID `gorm:"column:user_id;type:char(36);index;fk:users" json:"user_id"`
+	Body           string     `gorm:"column:body;type:text;not null" json:"body"`
+	CreatedAt      time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	restify.API
+}
+
+func (OrganizationNote) TableName() string {
+	return "organization_notes"
+}
+
+// ToWebhookData returns the organization fields included in webhook payloads
+func (o *Organization) ToWebhookData() map[string]any {
+	domains := make([]string, 0, len(o.Domains))
+	for _, domain := range o.Domains {
+		domains = append(domains, domain.Domain)
+	}
+	return map[string]any{
+		"id":      o.ID,
+		"name":    o.Name,
+		"website": o.Website,
+		"domains": domains,
+		"data":    o.Data,
+	}
+}
+
+// NormalizeDomain lowercases a domain and strips a leading "@" or "www."
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "@")
+	domain = strings.TrimPrefix(domain, "www.")
+	return domain
+}
+
+// ValidateDomain checks that domain is a syntactically valid, normalized domain name
+func ValidateDomain(domain string) error {
+	if !domainRegex.MatchString(domain) {
+		return fmt.Errorf("invalid domain %q", domain)
+	}
+	return nil
+}
+
+// EmailDomain returns the normalized domain part of an email address, or "" if there is none
+func EmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 || at == len(email)-1 {
+		return ""
+	}
+	return NormalizeDomain(strings.TrimSuffix(email[at+1:], ">"))
+}
+
+// FindOrganizationByEmail returns the organization of the workspace owning the domain of email
+func FindOrganizationByEmail(workspaceID uint, email string) (*Organization, error) {
+	domain := EmailDomain(email)
+	if domain == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	tx := WorkspaceDB(OrDefaultWorkspace(workspaceID))
+	var orgDomain OrganizationDomain
+	if err := tx.Where("domain = ?", domain).First(&orgDomain).Error; err != nil {
+		return nil, err
+	}
+
+	var organization Organization
+	if err := tx.Where("id = ?", orgDomain.OrganizationID).First(&organization).Error; err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+// AssociateClientOrganizationByEmail links client to the organization owning the domain of email.
+// Clients that already belong to an organization are left untouched.
+func AssociateClientOrganizationByEmail(client *Client, email string) {
+	if client == nil || client.OrganizationID != nil || email == "" {
+		return
+	}
+
+	organization, err := FindOrganizationByEmail(client.WorkspaceID, email)
+	if err != nil {
+		return
+	}
+
+	// Set before updating so the client.updated webhook carries the organization
+	client.Organization = organization
+	if err := db.Model(client).Where("organization_id IS NULL").Update("organization_id", organization.ID).Error; err != nil {
+		log.Warning("Failed to associate client %s with organization %d: %v", client.ID, organization.ID, err)
+		client.Organization = nil
+		return
+	}
+	client.OrganizationID = &organization.ID
+}
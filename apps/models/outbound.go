@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/google/uuid"
+)
+
+// WhatsAppSessionWindow is how long after a customer's last message free-form WhatsApp messages may be sent.
+// Outside the window WhatsApp only accepts pre-approved template messages.
+const WhatsAppSessionWindow = 24 * time.Hour
+
+// OutboundChannels lists the channels agents can start conversations on
+var OutboundChannels = []string{ExternalIDTypeEmail, ExternalIDTypeTelegram, ExternalIDTypeWhatsapp, ExternalIDTypeSlack}
+
+// WhatsAppTemplate is a pre-approved WhatsApp message template with its body parameters in order
+type WhatsAppTemplate struct {
+	Name       string   `json:"name"`
+	Language   string   `json:"language"`
+	Parameters []string `json:"parameters"`
+}
+
+// WhatsAppSessionOpen reports whether the client messaged on WhatsApp within the customer service window
+func WhatsAppSessionOpen(clientID uuid.UUID) bool {
+	var count int64
+	db.Model(&Message{}).
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+		Where("conversations.channel_id = ? AND messages.client_id = ? AND messages.user_id IS NULL", ExternalIDTypeWhatsapp, clientID).
+		Where("messages.created_at > ?", time.Now().Add(-WhatsAppSessionWindow)).
+		Count(&count)
+	return count > 0
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"gorm.io/gorm"
+)
+
+// Scheduled message status constants
+const (
+	ScheduledMessageStatusPending   = "pending"
+	ScheduledMessageStatusSending   = "sending"
+	ScheduledMessageStatusSent      = "sent"
+	ScheduledMessageStatusCancelled = "cancelled"
+	ScheduledMessageStatusFailed    = "failed"
+)
+
+// Scheduled message cancel reason constants
+const (
+	ScheduledMessageCancelAgent           = "agent"
+	ScheduledMessageCancelConversationEnd = "conversation_closed"
+	ScheduledMessageCancelCustomerReplied = "customer_replied"
+)
+
+var (
+	// ErrScheduledMessageNotPending is returned when a scheduled message that was already sent or cancelled is changed
+	ErrScheduledMessageNotPending = errors.New("scheduled message was already sent or cancelled")
+	// ErrScheduledMessageInPast is returned when a message is scheduled for a time that has passed
+	ErrScheduledMessageInPast = errors.New("send time must be in the future")
+)
+
+// ScheduledMessage is an agent reply that is sent to the conversation at SendAt.
+// It is cancelled when the conversation is closed or the customer writes before then.
+type ScheduledMessage struct {
+	ID             uint       `gorm:"column:id;primaryKey" json:"id"`
+	ConversationID uint       `gorm:"column:conversation_id;not null;index;fk:conversations" json:"conversation_id"`
+	UserID         uuid.UUID  `gorm:"column:user_id;type:char(36);not null;index;fk:users" json:"user_id"`
+	Body           string     `gorm:"column:body;type:text;not null" json:"body"`
+	SendAt         time.Time  `gorm:"column:send_at;not null;index:idx_scheduled_message_due,priority:2" json:"send_at"`
+	Status         string     `gorm:"column:status;size:20;not null;default:'pending';index:idx_scheduled_message_due,priority:1;check:status IN ('pending','sending','sent','cancelled','failed')" json:"status"`
+	CancelReason   string     `gorm:"column:cancel_reason;size:30" json:"cancel_reason,omitempty"`
+	Error          string     `gorm:"column:error;size:1000" json:"error,omitempty"`
+	MessageID      *uint      `gorm:"column:message_id" json:"message_id"`
+	SentAt         *time.Time `gorm:"column:sent_at" json:"sent_at"`
+	CancelledAt    *time.Time `gorm:"column:cancelled_at" json:"cancelled_at"`
+	CreatedAt      time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	// Relationships
+	User *auth.User `gorm:"foreignKey:UserID;references:UserID" json:"user,omitempty"`
+
+	restify.API
+}
+
+func (ScheduledMessage) TableName() string {
+	return "scheduled_messages"
+}
+
+// ScheduledMessageRunResult summarizes one dispatch pass
+type ScheduledMessageRunResult struct {
+	Sent      int `json:"sent"`
+	Cancelled int `json:"cancelled"`
+	Failed    int `json:"failed"`
+}
+
+// ResolveSendTime returns the absolute send time. sendAt is used as is; otherwise localTime
+// ("2006-01-02T15:04") is read in the given IANA timezone, falling back to UTC.
+func ResolveSendTime(sendAt *time.Time, localTime string, timezone *string) (time.Time, error) {
+	if sendAt != nil {
+		return *sendAt, nil
+	}
+	if localTime == "" {
+		return time.Time{}, fmt.Errorf("send_at or send_at_local is required")
+	}
+	location := time.UTC
+	if timezone != nil && *timezone != "" {
+		if loaded, err := time.LoadLocation(*timezone); err == nil {
+			location = loaded
+		}
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04", localTime, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("send_at_local must be formatted as YYYY-MM-DDTHH:MM")
+	}
+	return t, nil
+}
+
+// CancelScheduledMessages cancels the pending scheduled messages of a conversation
+func CancelScheduledMessages(tx *gorm.DB, conversationID uint, reason string) error {
+	return tx.Model(&ScheduledMessage{}).
+		Where("conversation_id = ? AND status = ?", conversationID, ScheduledMessageStatusPending).
+		Updates(map[string]any{
+			"status":        ScheduledMessageStatusCancelled,
+			"cancel_reason": reason,
+			"cancelled_at":  time.Now(),
+		}).Error
+}
+
+// ProcessDueScheduledMessages sends scheduled messages whose send time has passed.
+// Messages of conversations that were closed, or where the customer wrote after the message
+// was scheduled, are cancelled instead.
+func ProcessDueScheduledMessages(ctx context.Context) (*ScheduledMessageRunResult, error) {
+	result := &ScheduledMessageRunResult{}
+
+	// A message left in sending was interrupted; it may already be in the conversation, so it is not retried
+	db.Model(&ScheduledMessage{}).
+		Where("status = ? AND updated_at < ?", ScheduledMessageStatusSending, time.Now().Add(-15*time.Minute)).
+		Updates(map[string]any{"status": ScheduledMessageStatusFailed, "error": "sending interrupted"})
+
+	var due []ScheduledMessage
+	if err := db.Where("status = ? AND send_at <= ?", ScheduledMessageStatusPending, time.Now()).
+		Order("send_at ASC").Find(&due).Error; err != nil {
+		return result, err
+	}
+
+	for i := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		scheduled := &due[i]
+
+		// Claim the message; the agent may have cancelled or edited it in the meantime
+		claimed := db.Model(&ScheduledMessage{}).
+			Where("id = ? AND status = ? AND send_at <= ?", scheduled.ID, ScheduledMessageStatusPending, time.Now()).
+			Update("status", ScheduledMessageStatusSending).RowsAffected
+		if claimed == 0 {
+			continue
+		}
+		if err := db.First(scheduled, scheduled.ID).Error; err != nil {
+			continue
+		}
+
+		if reason := scheduledMessageCancelReason(scheduled); reason != "" {
+			db.Model(scheduled).Updates(map[string]any{
+				"status":        ScheduledMessageStatusCancelled,
+				"cancel_reason": reason,
+				"cancelled_at":  time.Now(),
+			})
+			result.Cancelled++
+			continue
+		}
+
+		message := Message{
+			ConversationID: scheduled.ConversationID,
+			UserID:         &scheduled.UserID,
+			Body:           scheduled.Body,
+		}
+		if err := db.Create(&message).Error; err != nil {
+			log.Error("[scheduled_messages] failed to send scheduled message %d: %v", scheduled.ID, err)
+			db.Model(scheduled).Updates(map[string]any{
+				"status": ScheduledMessageStatusFailed,
+				"error":  err.Error(),
+			})
+			result.Failed++
+			continue
+		}
+
+		now := time.Now()
+		db.Model(scheduled).Updates(map[string]any{
+			"status":     ScheduledMessageStatusSent,
+			"message_id": message.ID,
+			"sent_at":    now,
+		})
+		result.Sent++
+	}
+
+	return result, nil
+}
+
+// scheduledMessageCancelReason returns why a due scheduled message must not be sent, or ""
+func scheduledMessageCancelReason(scheduled *ScheduledMessage) string {
+	var conversation Conversation
+	if err := db.Select("id", "status").First(&conversation, scheduled.ConversationID).Error; err != nil {
+		return ScheduledMessageCancelConversationEnd
+	}
+	switch conversation.Status {
+	case ConversationStatusClosed, ConversationStatusArchived, ConversationStatusSpam:
+		return ScheduledMessageCancelConversationEnd
+	}
+
+	var customerMessages int64
+	db.Model(&Message{}).
+		Where("conversation_id = ? AND client_id IS NOT NULL AND user_id IS NULL AND created_at > ?", scheduled.ConversationID, scheduled.CreatedAt).
+		Count(&customerMessages)
+	if customerMessages > 0 {
+		return ScheduledMessageCancelCustomerReplied
+	}
+	return ""
+}
@@ -0,0 +1,234 @@
+package models
+
+import (
+	"slices"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/lib/crypto"
+	"gorm.io/gorm"
+)
+
+// Credentials are encrypted with lib/crypto when saved and decrypted when loaded, so the rest
+// of the code keeps working with plaintext. Rows written before encryption was configured stay
+// readable and are encrypted by ReencryptSecrets or on their next save.
+//
+// The hooks encrypt struct saves and Update/Updates with a map. UpdateColumn and UpdateColumns skip
+// hooks, so they must not be used to write a credential.
+
+// SecretSettingKeys are the settings that hold credentials. A new credential setting must be added here.
+var SecretSettingKeys = []string{"ai.api_key"}
+
+// IsSecretSetting reports whether the setting holds a credential and is encrypted at rest
+func IsSecretSetting(key string) bool {
+	return slices.Contains(SecretSettingKeys, key)
+}
+
+// encryptSecret replaces the plaintext in value with its encrypted form
+func encryptSecret(value *string) error {
+	encrypted, err := crypto.EncryptValue(*value)
+	if err != nil {
+		return err
+	}
+	*value = encrypted
+	return nil
+}
+
+// decryptSecret replaces the encrypted value with its plaintext. A value that cannot be decrypted,
+// for example because its key was removed from the key ring, is left as it is so the row still loads.
+func decryptSecret(value *string, what string) {
+	plaintext, err := crypto.DecryptValue(*value)
+	if err != nil {
+		log.Error("Failed to decrypt %s: %v", what, err)
+		return
+	}
+	*value = plaintext
+}
+
+// encryptUpdatedColumns encrypts credentials written with Update or Updates and a map, whose new
+// values are in the map rather than in the model the hooks receive
+func encryptUpdatedColumns(tx *gorm.DB, columns ...string) error {
+	updates, ok := tx.Statement.Dest.(map[string]interface{})
+	if !ok || tx.Statement.Schema == nil {
+		return nil
+	}
+	for key, value := range updates {
+		field := tx.Statement.Schema.LookUpField(key)
+		if field == nil || !slices.Contains(columns, field.DBName) {
+			continue
+		}
+		var plaintext string
+		switch v := value.(type) {
+		case string:
+			plaintext = v
+		case *string:
+			if v == nil {
+				continue
+			}
+			plaintext = *v
+		default:
+			continue
+		}
+		encrypted, err := crypto.EncryptValue(plaintext)
+		if err != nil {
+			return err
+		}
+		updates[key] = encrypted
+	}
+	return nil
+}
+
+func (i *Integration) BeforeSave(tx *gorm.DB) error {
+	if err := encryptUpdatedColumns(tx, "config"); err != nil {
+		return err
+	}
+	return encryptSecret(&i.Config)
+}
+
+func (i *Integration) AfterSave(tx *gorm.DB) error {
+	decryptSecret(&i.Config, "integration config")
+	return nil
+}
+
+func (i *Integration) AfterFind(tx *gorm.DB) error {
+	decryptSecret(&i.Config, "integration config")
+	return nil
+}
+
+func (w *Webhook) BeforeSave(tx *gorm.DB) error {
+	if err := encryptUpdatedColumns(tx, "secret", "previous_secret"); err != nil {
+		return err
+	}
+	if err := encryptSecret(&w.Secret); err != nil {
+		return err
+	}
+	return encryptSecret(&w.PreviousSecret)
+}
+
+func (w *Webhook) AfterSave(tx *gorm.DB) error {
+	decryptSecret(&w.Secret, "webhook secret")
+	decryptSecret(&w.PreviousSecret, "previous webhook secret")
+	return nil
+}
+
+func (w *Webhook) AfterFind(tx *gorm.DB) error {
+	decryptSecret(&w.Secret, "webhook secret")
+	decryptSecret(&w.PreviousSecret, "previous webhook secret")
+	return nil
+}
+
+func (t *AIAgentTool) BeforeSave(tx *gorm.DB) error {
+	if err := encryptUpdatedColumns(tx, "authorization_value"); err != nil {
+		return err
+	}
+	return encryptSecret(&t.AuthorizationValue)
+}
+
+func (t *AIAgentTool) AfterSave(tx *gorm.DB) error {
+	decryptSecret(&t.AuthorizationValue, "AI agent tool authorization")
+	return nil
+}
+
+func (t *AIAgentTool) AfterFind(tx *gorm.DB) error {
+	decryptSecret(&t.AuthorizationValue, "AI agent tool authorization")
+	return nil
+}
+
+func (i *Inbox) BeforeSave(tx *gorm.DB) error {
+	if err := encryptUpdatedColumns(tx, "identity_secret"); err != nil {
+		return err
+	}
+	return encryptSecret(&i.IdentitySecret)
+}
+
+func (i *Inbox) AfterSave(tx *gorm.DB) error {
+	decryptSecret(&i.IdentitySecret, "inbox identity secret")
+	i.IdentityVerified = i.IdentitySecret != ""
+	return nil
+}
+
+func (i *Inbox) AfterFind(tx *gorm.DB) error {
+	decryptSecret(&i.IdentitySecret, "inbox identity secret")
+	i.IdentityVerified = i.IdentitySecret != ""
+	return nil
+}
+
+func (s *Setting) BeforeSave(tx *gorm.DB) error {
+	if !IsSecretSetting(s.Key) {
+		return nil
+	}
+	if err := encryptUpdatedColumns(tx, "value"); err != nil {
+		return err
+	}
+	return encryptSecret(&s.Value)
+}
+
+func (s *Setting) AfterSave(tx *gorm.DB) error {
+	decryptSecret(&s.Value, "setting "+s.Key)
+	return nil
+}
+
+func (s *Setting) AfterFind(tx *gorm.DB) error {
+	decryptSecret(&s.Value, "setting "+s.Key)
+	return nil
+}
+
+// secretColumn is a table column that holds credentials
+type secretColumn struct {
+	table     string
+	column    string
+	keyColumn string // for tables where only some rows are secret
+	isSecret  func(key string) bool
+}
+
+var secretColumns = []secretColumn{
+	{table: "integrations", column: "config"},
+	{table: "webhooks", column: "secret"},
+	{table: "webhooks", column: "previous_secret"},
+	{table: "ai_agent_tools", column: "authorization_value"},
+	{table: "inboxes", column: "identity_secret"},
+	{table: "settings", column: "value", keyColumn: "setting_key", isSecret: IsSecretSetting},
+}
+
+// ReencryptSecrets encrypts the plaintext credentials and moves every encrypted credential to the
+// active key, so a retired key can be removed from the key ring. It returns the number of updated values.
+func ReencryptSecrets() (int, error) {
+	if crypto.ActiveKeyID() == "" {
+		return 0, crypto.ErrNoEncryptionKey
+	}
+
+	updated := 0
+	for _, secret := range secretColumns {
+		var rows []struct {
+			ID         uint
+			Value      string
+			SecretName string
+		}
+		query := "id, " + secret.column + " AS value"
+		if secret.keyColumn != "" {
+			query += ", " + secret.keyColumn + " AS secret_name"
+		}
+		if err := db.Table(secret.table).Select(query).Where(secret.column + " IS NOT NULL AND " + secret.column + " != ''").Scan(&rows).Error; err != nil {
+			return updated, err
+		}
+
+		for _, row := range rows {
+			if secret.isSecret != nil && !secret.isSecret(row.SecretName) {
+				continue
+			}
+			value, changed, err := crypto.RewrapValue(row.Value)
+			if err != nil {
+				log.Error("Failed to re-encrypt %s.%s of row %d: %v", secret.table, secret.column, row.ID, err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := db.Table(secret.table).Where("id = ?", row.ID).UpdateColumn(secret.column, value).Error; err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+	return updated, nil
+}
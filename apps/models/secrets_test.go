@@ -0,0 +1,81 @@
+package models
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iesreza/homa-backend/lib/crypto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("ENCRYPTION_KEY", strings.Repeat("k", 32))
+	os.Exit(m.Run())
+}
+
+// updateStatement returns a statement like the one Model(model).Updates(updates) passes to the hooks
+func updateStatement(t *testing.T, model any, updates map[string]interface{}) *gorm.DB {
+	t.Helper()
+	parsed, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &gorm.DB{Statement: &gorm.Statement{Model: model, Dest: updates, Schema: parsed}}
+}
+
+func TestBeforeSaveEncryptsMapUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   interface{ BeforeSave(*gorm.DB) error }
+		updates map[string]interface{}
+		secrets []string
+	}{
+		{name: "integration config", model: &Integration{}, updates: map[string]interface{}{"config": `{"password":"p"}`, "status": "enabled"}, secrets: []string{"config"}},
+		{name: "webhook secrets by field name", model: &Webhook{}, updates: map[string]interface{}{"Secret": "whsec_1", "PreviousSecret": "whsec_0", "name": "n"}, secrets: []string{"Secret", "PreviousSecret"}},
+		{name: "AI agent tool authorization", model: &AIAgentTool{}, updates: map[string]interface{}{"authorization_value": "Bearer t"}, secrets: []string{"authorization_value"}},
+		{name: "inbox identity secret", model: &Inbox{}, updates: map[string]interface{}{"identity_secret": "idsec_1"}, secrets: []string{"identity_secret"}},
+		{name: "secret setting", model: &Setting{Key: "ai.api_key"}, updates: map[string]interface{}{"value": "sk-1"}, secrets: []string{"value"}},
+		{name: "plain setting", model: &Setting{Key: "ai.model"}, updates: map[string]interface{}{"value": "gpt-4o"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := map[string]interface{}{}
+			for key, value := range tt.updates {
+				plaintext[key] = value
+			}
+			if err := tt.model.BeforeSave(updateStatement(t, tt.model, tt.updates)); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, value := range tt.updates {
+				secret := false
+				for _, name := range tt.secrets {
+					secret = secret || name == key
+				}
+				encrypted := crypto.IsEnvelope(value.(string))
+				if encrypted != secret {
+					t.Errorf("%s: encrypted = %v, want %v", key, encrypted, secret)
+				}
+				if decrypted, err := crypto.DecryptValue(value.(string)); err != nil || decrypted != plaintext[key] {
+					t.Errorf("%s: decrypts to %q, %v; want %q", key, decrypted, err, plaintext[key])
+				}
+			}
+		})
+	}
+}
+
+func TestIsSecretSetting(t *testing.T) {
+	for key, want := range map[string]bool{
+		"ai.api_key":            true,
+		"ai.model":              false,
+		"hub.verify_token":      false,
+		"webhooks.max_attempts": false,
+	} {
+		if got := IsSecretSetting(key); got != want {
+			t.Errorf("IsSecretSetting(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
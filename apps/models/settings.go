@@ -3,50 +3,74 @@ package models
 import (
 	"time"
 
-	"github.com/getevo/evo/v2/lib/db"
+	"github.com/iesreza/homa-backend/lib/tenant"
 	"gorm.io/gorm"
 )
 
 // Setting represents a configurable setting stored in the database
 // Note: Settings don't use soft delete - they are hard deleted
 type Setting struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Key       string    `gorm:"column:setting_key;type:varchar(255);uniqueIndex;not null" json:"key"`
-	Value     string    `gorm:"type:text" json:"value"`
-	Type      string    `gorm:"type:varchar(50);default:'string'" json:"type"` // string, number, boolean, json
-	Category  string    `gorm:"type:varchar(100);index" json:"category"`       // ai, workflow, general, etc.
-	Label     string    `gorm:"type:varchar(255)" json:"label"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	WorkspaceID uint      `gorm:"column:workspace_id;not null;default:1;uniqueIndex:idx_settings_workspace_key" json:"workspace_id"`
+	Key         string    `gorm:"column:setting_key;type:varchar(255);uniqueIndex:idx_settings_workspace_key;not null" json:"key"`
+	Value       string    `gorm:"type:text" json:"value"`
+	Type        string    `gorm:"type:varchar(50);default:'string'" json:"type"` // string, number, boolean, json
+	Category    string    `gorm:"type:varchar(100);index" json:"category"`       // ai, workflow, general, etc.
+	Label       string    `gorm:"type:varchar(255)" json:"label"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 func (Setting) TableName() string {
 	return "settings"
 }
 
-// GetSetting retrieves a setting by key
+// Settings belong to a workspace. Settings that are not set in a workspace fall back to the value of
+// the default workspace, which also holds the deployment-wide settings such as the AI and RAG configuration.
+
+// GetSetting retrieves a setting of the default workspace by key
 func GetSetting(key string) (*Setting, error) {
+	return GetWorkspaceSetting(tenant.DefaultID, key)
+}
+
+// GetWorkspaceSetting retrieves a setting of the workspace by key
+func GetWorkspaceSetting(workspaceID uint, key string) (*Setting, error) {
 	var setting Setting
-	err := db.Where("setting_key = ?", key).First(&setting).Error
+	err := WorkspaceDB(OrDefaultWorkspace(workspaceID)).Where("setting_key = ?", key).First(&setting).Error
 	if err != nil {
 		return nil, err
 	}
 	return &setting, nil
 }
 
-// GetSettingValue retrieves only the value of a setting
+// GetSettingValue retrieves only the value of a setting of the default workspace
 func GetSettingValue(key string, defaultValue string) string {
-	setting, err := GetSetting(key)
-	if err != nil {
-		return defaultValue
+	return GetWorkspaceSettingValue(tenant.DefaultID, key, defaultValue)
+}
+
+// GetWorkspaceSettingValue retrieves the value of a setting of the workspace,
+// falling back to the default workspace
+func GetWorkspaceSettingValue(workspaceID uint, key string, defaultValue string) string {
+	setting, err := GetWorkspaceSetting(workspaceID, key)
+	if err == nil {
+		return setting.Value
+	}
+	if OrDefaultWorkspace(workspaceID) != tenant.DefaultID {
+		return GetSettingValue(key, defaultValue)
 	}
-	return setting.Value
+	return defaultValue
 }
 
-// SetSetting creates or updates a setting
+// SetSetting creates or updates a setting of the default workspace
 func SetSetting(key, value, settingType, category, label string) error {
+	return SetWorkspaceSetting(tenant.DefaultID, key, value, settingType, category, label)
+}
+
+// SetWorkspaceSetting creates or updates a setting of the workspace
+func SetWorkspaceSetting(workspaceID uint, key, value, settingType, category, label string) error {
+	tx := WorkspaceDB(OrDefaultWorkspace(workspaceID))
 	var setting Setting
-	err := db.Where("setting_key = ?", key).First(&setting).Error
+	err := tx.Where("setting_key = ?", key).First(&setting).Error
 	if err != nil {
 		// Create new setting
 		setting = Setting{
@@ -56,7 +80,7 @@ func SetSetting(key, value, settingType, category, label string) error {
 			Category: category,
 			Label:    label,
 		}
-		return db.Create(&setting).Error
+		return tx.Create(&setting).Error
 	}
 
 	// Update existing setting
@@ -70,31 +94,31 @@ func SetSetting(key, value, settingType, category, label string) error {
 	if label != "" {
 		setting.Label = label
 	}
-	return db.Save(&setting).Error
+	return tx.Save(&setting).Error
 }
 
-// GetSettingsByCategory retrieves all settings in a category
-func GetSettingsByCategory(category string) ([]Setting, error) {
+// GetSettingsByCategory retrieves all settings of the workspace in a category
+func GetSettingsByCategory(workspaceID uint, category string) ([]Setting, error) {
 	var settings []Setting
-	err := db.Where("category = ?", category).Find(&settings).Error
+	err := WorkspaceDB(OrDefaultWorkspace(workspaceID)).Where("category = ?", category).Find(&settings).Error
 	return settings, err
 }
 
-// GetAllSettings retrieves all settings
-func GetAllSettings() ([]Setting, error) {
+// GetAllSettings retrieves all settings of the workspace
+func GetAllSettings(workspaceID uint) ([]Setting, error) {
 	var settings []Setting
-	err := db.Find(&settings).Error
+	err := WorkspaceDB(OrDefaultWorkspace(workspaceID)).Find(&settings).Error
 	return settings, err
 }
 
-// DeleteSetting deletes a setting by key
-func DeleteSetting(key string) error {
-	return db.Where("setting_key = ?", key).Delete(&Setting{}).Error
+// DeleteSetting deletes a setting of the workspace by key
+func DeleteSetting(workspaceID uint, key string) error {
+	return WorkspaceDB(OrDefaultWorkspace(workspaceID)).Where("setting_key = ?", key).Delete(&Setting{}).Error
 }
 
-// BulkUpdateSettings updates multiple settings at once
-func BulkUpdateSettings(updates map[string]string) error {
-	return db.Transaction(func(tx *gorm.DB) error {
+// BulkUpdateSettings updates multiple settings of the workspace at once
+func BulkUpdateSettings(workspaceID uint, updates map[string]string) error {
+	return WorkspaceDB(OrDefaultWorkspace(workspaceID)).Transaction(func(tx *gorm.DB) error {
 		for key, value := range updates {
 			var setting Setting
 			err := tx.Where("setting_key = ?", key).First(&setting).Error
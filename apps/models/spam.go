@@ -0,0 +1,415 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/restify"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Spam event action constants
+const (
+	SpamEventActionDropped    = "dropped"
+	SpamEventActionMarkedSpam = "marked_spam"
+)
+
+// SettingKeySpamEmailThreshold is the heuristic score (0-1) at which inbound email is marked as spam.
+// A value of 0 disables heuristic scoring.
+const SettingKeySpamEmailThreshold = "spam.email_threshold"
+
+const defaultSpamEmailThreshold = 0.6
+
+var (
+	// ErrSpamEventNotFound is returned when a spam event does not exist
+	ErrSpamEventNotFound = errors.New("spam event not found")
+	// ErrSpamEventReverted is returned when a spam event has already been reverted
+	ErrSpamEventReverted = errors.New("spam event has already been reverted")
+)
+
+// SpamReason is a single signal that contributed to a spam verdict
+type SpamReason struct {
+	Signal string  `json:"signal"`
+	Value  string  `json:"value,omitempty"`
+	Weight float64 `json:"weight"`
+}
+
+// SpamVerdict is the outcome of checking an inbound message. Action is drop or spam.
+type SpamVerdict struct {
+	Action  string
+	Entry   *BlocklistEntry
+	Score   float64
+	Reasons []SpamReason
+}
+
+// SpamEvent records inbound traffic that was dropped or marked as spam so that it can be audited and reverted.
+// ClientID and ConversationID have no foreign key so events survive client merges and deletions.
+type SpamEvent struct {
+	ID               uint           `gorm:"column:id;primaryKey" json:"id"`
+	Channel          string         `gorm:"column:channel;size:50;not null;index" json:"channel"`
+	SenderType       string         `gorm:"column:sender_type;size:20" json:"sender_type"`
+	SenderValue      string         `gorm:"column:sender_value;size:255;index" json:"sender_value"`
+	ClientID         *uuid.UUID     `gorm:"column:client_id;type:char(36);index" json:"client_id"`
+	ConversationID   *uint          `gorm:"column:conversation_id;index" json:"conversation_id"`
+	BlocklistEntryID *uint          `gorm:"column:blocklist_entry_id;index" json:"blocklist_entry_id"`
+	Action           string         `gorm:"column:action;size:20;not null;index;check:action IN ('dropped','marked_spam')" json:"action"`
+	Score            float64        `gorm:"column:score;type:decimal(5,4);default:0" json:"score"`
+	Reasons          datatypes.JSON `gorm:"column:reasons;type:json" json:"reasons"`
+	Summary          string         `gorm:"column:summary;size:500" json:"summary"`
+	PreviousStatus   string         `gorm:"column:previous_status;size:50" json:"previous_status,omitempty"`
+	RevertedBy       *uuid.UUID     `gorm:"column:reverted_by;type:char(36);fk:users" json:"reverted_by"`
+	RevertedAt       *time.Time     `gorm:"column:reverted_at" json:"reverted_at"`
+	CreatedAt        time.Time      `gorm:"column:created_at;autoCreateTime;index" json:"created_at"`
+
+	// Relationships
+	BlocklistEntry *BlocklistEntry `gorm:"foreignKey:BlocklistEntryID;references:ID" json:"blocklist_entry,omitempty"`
+
+	restify.API
+}
+
+func (SpamEvent) TableName() string {
+	return "spam_events"
+}
+
+// senderKey returns the most specific blocklist type and value identifying the sender
+func (s InboundSender) senderKey() (string, string) {
+	if s.ClientID != nil {
+		return BlocklistTypeClient, s.ClientID.String()
+	}
+	if email := normalizeEmail(s.Email); email != "" {
+		return BlocklistTypeEmail, email
+	}
+	if phone := normalizePhone(s.Phone); phone != "" {
+		return BlocklistTypePhone, phone
+	}
+	if ip := net.ParseIP(strings.TrimSpace(s.IP)); ip != nil {
+		return BlocklistTypeIP, ip.String()
+	}
+	return "", ""
+}
+
+// CheckInboundSender checks sender against the blocklist and returns a verdict when the traffic
+// should be dropped or marked as spam, or nil when it should be delivered normally
+func CheckInboundSender(sender *InboundSender) *SpamVerdict {
+	entry := CheckBlocklist(sender)
+	if entry == nil || entry.Action == BlocklistActionAllow {
+		return nil
+	}
+	return blocklistVerdict(entry)
+}
+
+// CheckInboundEmail checks an inbound email against the blocklist and, unless the sender is
+// allowlisted, scores it with ScoreEmailSpam
+func CheckInboundEmail(sender *InboundSender, headers map[string]string, text, html string) *SpamVerdict {
+	entry := CheckBlocklist(sender)
+	if entry != nil {
+		if entry.Action == BlocklistActionAllow {
+			return nil
+		}
+		return blocklistVerdict(entry)
+	}
+
+	threshold := SpamEmailThreshold()
+	if threshold == 0 {
+		return nil
+	}
+	score, reasons := ScoreEmailSpam(headers, text, html)
+	if score < threshold {
+		return nil
+	}
+	return &SpamVerdict{Action: BlocklistActionSpam, Score: score, Reasons: reasons}
+}
+
+func blocklistVerdict(entry *BlocklistEntry) *SpamVerdict {
+	return &SpamVerdict{
+		Action: entry.Action,
+		Entry:  entry,
+		Score:  1,
+		Reasons: []SpamReason{{
+			Signal: "blocklist_" + entry.Type,
+			Value:  entry.Value,
+			Weight: 1,
+		}},
+	}
+}
+
+// SpamEmailThreshold returns the configured heuristic threshold, or 0 if heuristic scoring is disabled
+func SpamEmailThreshold() float64 {
+	threshold, err := strconv.ParseFloat(GetSettingValue(SettingKeySpamEmailThreshold, strconv.FormatFloat(defaultSpamEmailThreshold, 'f', -1, 64)), 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return defaultSpamEmailThreshold
+	}
+	return threshold
+}
+
+var (
+	linkRegex     = regexp.MustCompile(`https?://[^\s"'<>)]+`)
+	anchorRegex   = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']+)["'][^>]*>(.*?)</a>`)
+	htmlTagRegex  = regexp.MustCompile(`<[^>]+>`)
+	urlShorteners = []string{"bit.ly", "tinyurl.com", "goo.gl", "t.co", "ow.ly", "is.gd", "buff.ly", "cutt.ly", "rebrand.ly", "shorturl.at"}
+)
+
+// maxSpamLinkHits caps how many suspicious links contribute to an email's score
+const maxSpamLinkHits = 3
+
+// ScoreEmailSpam scores an email between 0 and 1 using automated-mail headers and suspicious links.
+// Signals are combined as 1 - Π(1 - weight). Header names are matched case-insensitively.
+func ScoreEmailSpam(headers map[string]string, text, html string) (float64, []SpamReason) {
+	header := func(name string) string {
+		for key, value := range headers {
+			if strings.EqualFold(key, name) {
+				return strings.ToLower(strings.TrimSpace(value))
+			}
+		}
+		return ""
+	}
+
+	var reasons []SpamReason
+	add := func(signal, value string, weight float64) {
+		reasons = append(reasons, SpamReason{Signal: signal, Value: value, Weight: weight})
+	}
+
+	// Automated and bulk mail headers
+	if value := header("Auto-Submitted"); value != "" && value != "no" {
+		add("auto_submitted", value, 0.5)
+	}
+	if value := header("Precedence"); value == "bulk" || value == "junk" || value == "list" {
+		add("bulk_precedence", value, 0.4)
+	}
+	if value := header("X-Auto-Response-Suppress"); value != "" {
+		add("auto_response_suppress", value, 0.2)
+	}
+	if header("List-Unsubscribe") != "" || header("List-Id") != "" {
+		add("mailing_list", "", 0.2)
+	}
+
+	// Suspicious links
+	links := linkRegex.FindAllString(text+" "+html, -1)
+	seen := map[string]bool{}
+	hits := 0
+	for _, link := range links {
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		if hits >= maxSpamLinkHits {
+			continue
+		}
+		parsed, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		host := strings.ToLower(parsed.Hostname())
+		switch {
+		case net.ParseIP(host) != nil:
+			add("ip_link", host, 0.3)
+			hits++
+		case strings.HasPrefix(host, "xn--") || strings.Contains(host, ".xn--"):
+			add("punycode_link", host, 0.2)
+			hits++
+		case isURLShortener(host):
+			add("shortened_link", host, 0.2)
+			hits++
+		}
+	}
+	if len(seen) > 10 {
+		add("many_links", strconv.Itoa(len(seen)), 0.1)
+	}
+
+	// Anchors whose text shows a different domain than the link points to
+	for _, match := range anchorRegex.FindAllStringSubmatch(html, -1) {
+		shown := strings.TrimSpace(htmlTagRegex.ReplaceAllString(match[2], ""))
+		shownURL, err := url.Parse(shown)
+		if err != nil || shownURL.Hostname() == "" {
+			continue
+		}
+		target, err := url.Parse(match[1])
+		if err != nil || target.Hostname() == "" {
+			continue
+		}
+		if !strings.EqualFold(shownURL.Hostname(), target.Hostname()) {
+			add("mismatched_link", target.Hostname(), 0.3)
+			break
+		}
+	}
+
+	remaining := 1.0
+	for _, reason := range reasons {
+		remaining *= 1 - reason.Weight
+	}
+	return 1 - remaining, reasons
+}
+
+func isURLShortener(host string) bool {
+	for _, shortener := range urlShorteners {
+		if host == shortener || strings.HasSuffix(host, "."+shortener) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordSpamEvent stores a spam event for traffic that was dropped before reaching a conversation
+func RecordSpamEvent(channel string, sender InboundSender, verdict *SpamVerdict, summary string) {
+	event := newSpamEvent(channel, sender, verdict, summary)
+	event.Action = SpamEventActionDropped
+	if err := db.Create(event).Error; err != nil {
+		log.Error("Failed to record spam event: %v", err)
+	}
+}
+
+// MarkConversationSpam sets the conversation status to spam, disables bot handling and records a spam event
+func MarkConversationSpam(conversationID uint, channel string, sender InboundSender, verdict *SpamVerdict, summary string) error {
+	var conversation Conversation
+	if err := db.First(&conversation, conversationID).Error; err != nil {
+		return err
+	}
+
+	previousStatus := conversation.Status
+	if conversation.Status != ConversationStatusSpam {
+		if err := db.Model(&conversation).Updates(map[string]any{
+			"status":        ConversationStatusSpam,
+			"handle_by_bot": false,
+		}).Error; err != nil {
+			return err
+		}
+		CreateActionMessage(conversation.ID, nil, "", fmt.Sprintf("Conversation was marked as spam (%s)", verdict.describe()))
+		LogConversationStatusChange(conversation.ID, nil, previousStatus, ConversationStatusSpam, sender.IP, "")
+	}
+
+	return RecordConversationSpam(&conversation, previousStatus, channel, sender, verdict, summary)
+}
+
+// RecordConversationSpam records a spam event for a conversation that was created with the spam status.
+// previousStatus is the status restored when the event is reverted.
+func RecordConversationSpam(conversation *Conversation, previousStatus, channel string, sender InboundSender, verdict *SpamVerdict, summary string) error {
+	sender.ClientID = &conversation.ClientID
+	event := newSpamEvent(channel, sender, verdict, summary)
+	event.Action = SpamEventActionMarkedSpam
+	event.ConversationID = &conversation.ID
+	event.PreviousStatus = previousStatus
+	return db.Create(event).Error
+}
+
+// RevertSpamEvent undoes a spam event. Conversations that are still marked as spam get their previous
+// status back. When allowSender is set the sender is added to the blocklist as allowed so future
+// traffic is delivered normally.
+func RevertSpamEvent(eventID uint, userID uuid.UUID, allowSender bool) (*SpamEvent, error) {
+	var event SpamEvent
+	if err := db.First(&event, eventID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrSpamEventNotFound
+		}
+		return nil, err
+	}
+	if event.RevertedAt != nil {
+		return nil, ErrSpamEventReverted
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if event.Action == SpamEventActionMarkedSpam && event.ConversationID != nil {
+			status := event.PreviousStatus
+			if status == "" || status == ConversationStatusSpam {
+				status = ConversationStatusWaitForAgent
+			}
+			var conversation Conversation
+			err := tx.Where("id = ? AND status = ?", *event.ConversationID, ConversationStatusSpam).First(&conversation).Error
+			if err == nil {
+				if err := tx.Model(&conversation).Update("status", status).Error; err != nil {
+					return err
+				}
+			} else if err != gorm.ErrRecordNotFound {
+				return err
+			}
+		}
+
+		if allowSender && event.SenderType != "" {
+			entry := BlocklistEntry{
+				Type:      event.SenderType,
+				Value:     event.SenderValue,
+				Action:    BlocklistActionAllow,
+				Reason:    fmt.Sprintf("Spam event #%d reverted", event.ID),
+				IsActive:  true,
+				CreatedBy: &userID,
+			}
+			var existing BlocklistEntry
+			err := tx.Where("type = ? AND value = ?", entry.Type, entry.Value).First(&existing).Error
+			switch {
+			case err == gorm.ErrRecordNotFound:
+				if err := tx.Create(&entry).Error; err != nil {
+					return err
+				}
+			case err != nil:
+				return err
+			default:
+				if err := tx.Model(&existing).Updates(map[string]any{
+					"action":    BlocklistActionAllow,
+					"reason":    entry.Reason,
+					"is_active": true,
+				}).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		now := time.Now()
+		event.RevertedBy = &userID
+		event.RevertedAt = &now
+		return tx.Model(&event).Updates(map[string]any{
+			"reverted_by": userID,
+			"reverted_at": now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func newSpamEvent(channel string, sender InboundSender, verdict *SpamVerdict, summary string) *SpamEvent {
+	event := &SpamEvent{
+		Channel:  channel,
+		ClientID: sender.ClientID,
+		Score:    verdict.Score,
+		Summary:  truncateSpamSummary(summary),
+	}
+	event.SenderType, event.SenderValue = sender.senderKey()
+	if verdict.Entry != nil {
+		event.BlocklistEntryID = &verdict.Entry.ID
+	}
+	if reasons, err := json.Marshal(verdict.Reasons); err == nil {
+		event.Reasons = reasons
+	}
+	return event
+}
+
+// describe returns a short human readable description of the verdict
+func (v *SpamVerdict) describe() string {
+	if v.Entry != nil {
+		return fmt.Sprintf("blocked %s %s", v.Entry.Type, v.Entry.Value)
+	}
+	signals := make([]string, 0, len(v.Reasons))
+	for _, reason := range v.Reasons {
+		signals = append(signals, reason.Signal)
+	}
+	return fmt.Sprintf("spam score %.2f: %s", v.Score, strings.Join(signals, ", "))
+}
+
+func truncateSpamSummary(summary string) string {
+	summary = strings.TrimSpace(summary)
+	if runes := []rune(summary); len(runes) > 500 {
+		return string(runes[:497]) + "..."
+	}
+	return summary
+}
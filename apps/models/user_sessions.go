@@ -16,6 +16,7 @@ type UserSession struct {
 	UserID       uuid.UUID      `gorm:"column:user_id;type:char(36);not null;index;fk:users" json:"user_id"`
 	SessionID    string         `gorm:"column:session_id;size:36;not null;index" json:"session_id"` // Browser session (shared across tabs)
 	TabID        *string        `gorm:"column:tab_id;size:36;index" json:"tab_id,omitempty"`        // Individual tab ID
+	TokenSessionID string       `gorm:"column:token_session_id;size:36;index" json:"-"`             // Refresh token family of the login
 	IPAddress    string         `gorm:"column:ip_address;size:45;not null" json:"ip_address"`
 	UserAgent    string         `gorm:"column:user_agent;size:500" json:"user_agent"`
 	DeviceInfo   datatypes.JSON `gorm:"column:device_info;type:json" json:"device_info,omitempty"`
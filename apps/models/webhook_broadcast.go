@@ -1,18 +1,22 @@
 package models
 
-// WebhookBroadcaster is a callback function for broadcasting webhooks
+import "gorm.io/gorm"
+
+// WebhookBroadcaster is a callback function for queueing webhook events
 // This is set by the webhook app to avoid circular dependencies
-var WebhookBroadcaster func(event string, data map[string]any)
+var WebhookBroadcaster func(tx *gorm.DB, workspaceID uint, event string, data map[string]any) error
 
 // WebhookSender is a callback function for sending to a specific webhook
 // This is set by the webhook app to avoid circular dependencies
 var WebhookSender func(webhook *Webhook, event string, data map[string]any) error
 
-// BroadcastWebhook sends a webhook event if a broadcaster is registered
-func BroadcastWebhook(event string, data map[string]any) {
+// BroadcastWebhook queues a webhook event for the webhooks of the workspace if a broadcaster is registered.
+// Hooks pass their transaction, so the deliveries are queued if and only if the change that triggers them commits.
+func BroadcastWebhook(tx *gorm.DB, workspaceID uint, event string, data map[string]any) error {
 	if WebhookBroadcaster != nil {
-		WebhookBroadcaster(event, data)
+		return WebhookBroadcaster(tx, workspaceID, event, data)
 	}
+	return nil
 }
 
 // SendToWebhook sends to a specific webhook if a sender is registered
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/getevo/restify"
+	"gorm.io/gorm"
+)
+
+// Webhook events are queued in the database, in the transaction of the change that triggers them, so
+// neither a restart nor a rollback loses or invents deliveries. Deliveries to one webhook are sent in
+// the order they were queued: a delivery waits until the ones before it are delivered, dead, or have
+// failed webhooks.ordered_attempts attempts. Past that a failing delivery keeps being retried behind
+// the later ones instead of blocking them. Failed attempts are retried with exponential backoff and
+// the delivery is dead-lettered after webhooks.max_attempts attempts.
+
+// Webhook queue statuses
+const (
+	WebhookQueueStatusPending   = "pending"
+	WebhookQueueStatusSending   = "sending"
+	WebhookQueueStatusDelivered = "delivered"
+	WebhookQueueStatusDead      = "dead"
+)
+
+// ErrWebhookQueueItemSending is returned when replaying a delivery that is being sent
+var ErrWebhookQueueItemSending = errors.New("delivery is being sent")
+
+// WebhookQueueItem is a queued webhook delivery. Every attempt is logged as a WebhookDelivery with the same DeliveryID.
+type WebhookQueueItem struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	WorkspaceID   uint       `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
+	WebhookID     uint       `gorm:"not null;index:idx_webhook_queue_webhook_status,priority:1;fk:webhooks" json:"webhook_id"`
+	DeliveryID    string     `gorm:"size:36;not null;uniqueIndex" json:"delivery_id"`
+	Event         string     `gorm:"size:100;not null" json:"event"`
+	Payload       string     `gorm:"type:mediumtext;not null" json:"payload"`
+	Status        string     `gorm:"size:20;not null;default:'pending';index:idx_webhook_queue_webhook_status,priority:2;check:status IN ('pending','sending','delivered','dead')" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index" json:"next_attempt_at"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+
+	Webhook *Webhook `gorm:"foreignKey:WebhookID;references:ID" json:"webhook,omitempty"`
+
+	restify.API
+	restify.DisableCreate
+	restify.DisableUpdate
+	restify.DisableDelete
+	restify.DisableSet
+}
+
+// TableName returns the table name
+func (WebhookQueueItem) TableName() string {
+	return "webhook_queue"
+}
+
+// Replay queues the delivery again with a fresh attempt budget. It keeps its delivery ID, so a receiver
+// that already processed it can tell.
+func (item *WebhookQueueItem) Replay(tx *gorm.DB) error {
+	result := tx.Model(item).Where("status <> ?", WebhookQueueStatusSending).Updates(map[string]any{
+		"status":          WebhookQueueStatusPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+		"last_error":      "",
+		"delivered_at":    nil,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebhookQueueItemSending
+	}
+	return tx.First(item, item.ID).Error
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxWebhookSecretGracePeriod is the longest time a replaced webhook secret keeps signing deliveries
+const MaxWebhookSecretGracePeriod = 7 * 24 * time.Hour
+
+// GenerateWebhookSecret creates a new webhook signing secret
+func GenerateWebhookSecret() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return "whsec_" + hex.EncodeToString(bytes)
+}
+
+// BeforeCreate gives every webhook a signing secret
+func (w *Webhook) BeforeCreate(tx *gorm.DB) error {
+	if w.Secret == "" {
+		w.Secret = GenerateWebhookSecret()
+	}
+	return nil
+}
+
+// SigningSecrets returns the secrets deliveries are signed with: the current secret and, during
+// a rotation, the replaced one
+func (w *Webhook) SigningSecrets() []string {
+	var secrets []string
+	if w.Secret != "" {
+		secrets = append(secrets, w.Secret)
+	}
+	if w.PreviousSecret != "" && w.PreviousSecretExpiresAt != nil && time.Now().Before(*w.PreviousSecretExpiresAt) {
+		secrets = append(secrets, w.PreviousSecret)
+	}
+	return secrets
+}
+
+// RotateSecret replaces the signing secret. Deliveries are also signed with the replaced secret for
+// the grace period, so the receiver can be switched to the new secret without rejecting deliveries.
+func (w *Webhook) RotateSecret(gracePeriod time.Duration) {
+	w.PreviousSecret = ""
+	w.PreviousSecretExpiresAt = nil
+	if gracePeriod > 0 && w.Secret != "" {
+		expiresAt := time.Now().Add(min(gracePeriod, MaxWebhookSecretGracePeriod))
+		w.PreviousSecret = w.Secret
+		w.PreviousSecretExpiresAt = &expiresAt
+	}
+	w.Secret = GenerateWebhookSecret()
+}
@@ -9,9 +9,12 @@ import (
 // Webhook represents a webhook subscription
 type Webhook struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
+	WorkspaceID uint      `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
 	Name        string    `gorm:"size:255;not null" json:"name"`
 	URL         string    `gorm:"size:500;not null" json:"url"`
-	Secret      string    `gorm:"size:255" json:"-"` // Hidden from JSON responses for security
+	Secret      string    `gorm:"size:512" json:"-"` // Signs deliveries; hidden from JSON responses for security, encrypted at rest
+	PreviousSecret          string     `gorm:"size:512" json:"-"` // Replaced secret that still signs deliveries until PreviousSecretExpiresAt
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at"`
 	Enabled     bool      `gorm:"default:1" json:"enabled"`
 	Description string    `gorm:"type:text" json:"description,omitempty"`
 
@@ -27,6 +30,7 @@ type Webhook struct {
 	EventClientUpdated           bool `gorm:"default:0" json:"event_client_updated"`
 	EventUserCreated             bool `gorm:"default:0" json:"event_user_created"`
 	EventUserUpdated             bool `gorm:"default:0" json:"event_user_updated"`
+	EventUserLocked              bool `gorm:"default:0" json:"event_user_locked"`
 
 	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
@@ -68,6 +72,8 @@ func (w *Webhook) IsSubscribedTo(event string) bool {
 		return w.EventUserCreated
 	case WebhookEventUserUpdated:
 		return w.EventUserUpdated
+	case WebhookEventUserLocked:
+		return w.EventUserLocked
 	default:
 		return false
 	}
@@ -76,10 +82,14 @@ func (w *Webhook) IsSubscribedTo(event string) bool {
 // WebhookDelivery represents a webhook delivery attempt
 type WebhookDelivery struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
+	WorkspaceID uint    `gorm:"column:workspace_id;not null;default:1;index" json:"workspace_id"`
 	WebhookID uint      `gorm:"not null;index;fk:webhooks" json:"webhook_id"`
 	Event     string    `gorm:"size:100;not null" json:"event"`
 	Success   bool      `gorm:"not null" json:"success"`
 
+	// DeliveryID is sent in the X-Homa-Delivery header and is the same for every attempt of a delivery
+	DeliveryID string `gorm:"size:36;index" json:"delivery_id"`
+
 	// Request details for debugging
 	RequestURL     string `gorm:"size:500" json:"request_url,omitempty"`
 	RequestBody    string `gorm:"type:text" json:"request_body,omitempty"`
@@ -110,6 +120,7 @@ const (
 	WebhookEventClientUpdated            = "client.updated"
 	WebhookEventUserCreated              = "user.created"
 	WebhookEventUserUpdated              = "user.updated"
+	WebhookEventUserLocked               = "user.locked"
 	WebhookEventWebhookTest              = "webhook.test"
 	WebhookEventAll                      = "*"
 )
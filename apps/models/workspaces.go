@@ -0,0 +1,249 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/restify"
+	"github.com/iesreza/homa-backend/apps/auth"
+	"github.com/iesreza/homa-backend/lib/response"
+	"github.com/iesreza/homa-backend/lib/tenant"
+	"gorm.io/gorm"
+)
+
+// Workspace status constants
+const (
+	WorkspaceStatusActive    = "active"
+	WorkspaceStatusSuspended = "suspended"
+)
+
+var workspaceSlugRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,62}[a-z0-9])?$`)
+
+// ErrWorkspaceSuspended is returned for requests to a suspended workspace
+var ErrWorkspaceSuspended = response.NewError("workspace_suspended", "This workspace is suspended", 403)
+
+// WorkspaceLocal is the request local that holds the workspace of the request
+const WorkspaceLocal = "workspace_id"
+
+// Workspace is a tenant: a brand with its own users, departments, inboxes, integrations, knowledge base,
+// AI agents, settings, webhooks and conversations. Rows created before workspaces existed belong to the
+// default workspace (ID 1), which also hosts the operators who manage the other workspaces.
+type Workspace struct {
+	ID        uint      `gorm:"column:id;primaryKey" json:"id"`
+	Name      string    `gorm:"column:name;size:255;not null" json:"name"`
+	Slug      string    `gorm:"column:slug;size:64;uniqueIndex;not null" json:"slug"`
+	Status    string    `gorm:"column:status;size:20;not null;default:'active';check:status IN ('active','suspended')" json:"status"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+
+	restify.API
+}
+
+func (Workspace) TableName() string {
+	return "workspaces"
+}
+
+// Validate checks the name and slug and normalizes the slug
+func (w *Workspace) Validate() error {
+	w.Name = strings.TrimSpace(w.Name)
+	w.Slug = strings.ToLower(strings.TrimSpace(w.Slug))
+	if w.Name == "" {
+		return errors.New("name is required")
+	}
+	if !workspaceSlugRegex.MatchString(w.Slug) {
+		return errors.New("slug must be 1-64 lowercase letters, digits or dashes")
+	}
+	if w.Status == "" {
+		w.Status = WorkspaceStatusActive
+	}
+	if w.Status != WorkspaceStatusActive && w.Status != WorkspaceStatusSuspended {
+		return errors.New("status must be 'active' or 'suspended'")
+	}
+	return nil
+}
+
+// GetWorkspace retrieves a workspace by ID
+func GetWorkspace(id uint) (*Workspace, error) {
+	var workspace Workspace
+	if err := db.First(&workspace, id).Error; err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// EnsureDefaultWorkspace creates the default workspace that owns the data created before workspaces existed
+func EnsureDefaultWorkspace() error {
+	var count int64
+	if err := db.Model(&Workspace{}).Where("id = ?", tenant.DefaultID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return db.Create(&Workspace{ID: tenant.DefaultID, Name: "Default", Slug: "default", Status: WorkspaceStatusActive}).Error
+}
+
+// workspaceChildren are tables whose rows belong to the workspace of a parent row: child, foreign key, parent
+var workspaceChildren = [][3]string{
+	{"organization_domains", "organization_id", "organizations"},
+	{"campaign_deliveries", "campaign_id", "campaigns"},
+	{"client_opt_outs", "client_id", "clients"},
+	{"client_duplicate_candidates", "client_id", "clients"},
+}
+
+// AlignChildWorkspaces moves rows created before their table had a workspace to the workspace of their parent
+func AlignChildWorkspaces() error {
+	for _, child := range workspaceChildren {
+		if err := db.Exec("UPDATE " + child[0] + " c JOIN " + child[2] + " p ON p.id = c." + child[1] +
+			" SET c.workspace_id = p.workspace_id WHERE c.workspace_id <> p.workspace_id").Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WorkspaceDB returns a database handle scoped to the workspace: queries only see its rows and
+// created rows belong to it. Background work that knows its workspace uses it instead of db.
+func WorkspaceDB(workspaceID uint) *gorm.DB {
+	if workspaceID == 0 {
+		return evo.GetDBO()
+	}
+	return db.WithContext(tenant.WithWorkspace(context.Background(), workspaceID))
+}
+
+// OrDefaultWorkspace returns the default workspace for rows and requests without a workspace
+func OrDefaultWorkspace(workspaceID uint) uint {
+	if workspaceID == 0 {
+		return tenant.DefaultID
+	}
+	return workspaceID
+}
+
+// workspaceOf returns the workspace of the row of model with the given ID, 0 when it is not found
+func workspaceOf(tx *gorm.DB, model any, id any) uint {
+	var workspaceID uint
+	tx.Session(&gorm.Session{NewDB: true}).Model(model).Select(tenant.Column).Where("id = ?", id).Scan(&workspaceID)
+	return workspaceID
+}
+
+// ConversationSubject returns the NATS subject the events of a conversation are published on.
+// The workspace is looked up when it is not known.
+func ConversationSubject(workspaceID, conversationID uint) string {
+	if workspaceID == 0 {
+		workspaceID = workspaceOf(evo.GetDBO(), &Conversation{}, conversationID)
+	}
+	return tenant.Subject(workspaceID, fmt.Sprintf("conversation.%d", conversationID))
+}
+
+// RequestWorkspaceID returns the workspace WorkspaceMiddleware resolved for the request, 0 when none
+func RequestWorkspaceID(request *evo.Request) uint {
+	id, _ := request.Locals(WorkspaceLocal).(uint)
+	return id
+}
+
+// SetRequestWorkspace scopes the rest of the request to the workspace, for public endpoints
+// that learn the workspace from the request body or an inbox
+func SetRequestWorkspace(request *evo.Request, workspaceID uint) {
+	request.Locals(WorkspaceLocal, workspaceID)
+}
+
+// defaultWorkspaceRoutes manage data that all workspaces share: workspaces, roles and permissions, attribute
+// definitions and channels, the blocklist and its spam events, SSO providers and login lockouts (both used
+// before a login knows its workspace) and deployment-wide job, rate limit and RAG settings. Only users of
+// the default workspace, who operate the deployment, may use them.
+var defaultWorkspaceRoutes = []string{
+	"/api/admin/workspaces",
+	"/api/admin/roles",
+	"/api/admin/permissions",
+	"/api/admin/attributes",
+	"/api/admin/channels",
+	"/api/admin/blocklist",
+	"/api/admin/spam-events",
+	"/api/admin/sso-providers",
+	"/api/admin/lockouts",
+	"/api/settings/jobs",
+	"/api/settings/rate-limits",
+	"/api/v1/jobs",
+	"/api/rag/create-collection",
+	"/api/rag/drop-collection",
+}
+
+// defaultWorkspaceWriteRoutes are agent endpoints over the same shared data: every workspace reads them,
+// but only the default workspace may change them
+var defaultWorkspaceWriteRoutes = []string{
+	"/api/agent/attributes",
+}
+
+// ErrDefaultWorkspaceOnly is returned when another workspace calls an endpoint limited to the default workspace
+var ErrDefaultWorkspaceOnly = response.NewError("default_workspace_only", "This resource is managed by the default workspace", 403)
+
+// isDefaultWorkspaceRoute reports whether a request with method to path is limited to the default workspace:
+// any request below defaultWorkspaceRoutes, and changes below defaultWorkspaceWriteRoutes
+func isDefaultWorkspaceRoute(method, path string) bool {
+	if routeMatches(defaultWorkspaceRoutes, path) {
+		return true
+	}
+	return method != "GET" && method != "HEAD" && routeMatches(defaultWorkspaceWriteRoutes, path)
+}
+
+// routeMatches reports whether path is one of routes or below one
+func routeMatches(routes []string, path string) bool {
+	for _, route := range routes {
+		if path == route || strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkspaceMiddleware resolves the workspace of an API request from the logged-in user (JWT or API key)
+// or from the inbox key of widget requests, and refuses requests to suspended workspaces.
+// Database statements built with db.GetContext(request) are then scoped to the workspace.
+func WorkspaceMiddleware(request *evo.Request) error {
+	var workspaceID uint
+	if !request.User().Anonymous() {
+		workspaceID = request.User().Interface().(*auth.User).WorkspaceID
+	} else if key := inboxKey(request); key != "" {
+		if inbox, err := GetInboxByAPIKey(key); err == nil {
+			workspaceID = inbox.WorkspaceID
+		}
+	}
+
+	if workspaceID != 0 {
+		workspace, err := GetWorkspace(workspaceID)
+		if err != nil || workspace.Status != WorkspaceStatusActive {
+			return ErrWorkspaceSuspended
+		}
+		if workspaceID != tenant.DefaultID && isDefaultWorkspaceRoute(request.Method(), request.Path()) {
+			return ErrDefaultWorkspaceOnly
+		}
+		SetRequestWorkspace(request, workspaceID)
+	}
+	return request.Next()
+}
+
+// inboxKey returns the inbox API key sent by the livechat widget
+func inboxKey(request *evo.Request) string {
+	if key := request.Header("X-Inbox-Key"); key != "" {
+		return key
+	}
+	return request.Query("inbox_key").String()
+}
+
+// scopeRequest scopes db.GetContext(request) to the workspace of the request
+func scopeRequest(dbo *gorm.DB, v interface{}) *gorm.DB {
+	request, ok := v.(*evo.Request)
+	if !ok {
+		return dbo
+	}
+	if workspaceID := RequestWorkspaceID(request); workspaceID != 0 {
+		return dbo.WithContext(tenant.WithWorkspace(dbo.Statement.Context, workspaceID))
+	}
+	return dbo
+}
@@ -0,0 +1,24 @@
+package models
+
+import "testing"
+
+func TestIsDefaultWorkspaceRoute(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/api/admin/attributes", true},
+		{"POST", "/api/admin/roles/3/permissions", true},
+		{"GET", "/api/agent/attributes", false},
+		{"POST", "/api/agent/attributes", true},
+		{"PUT", "/api/agent/attributes/conversation/priority", true},
+		{"DELETE", "/api/agent/attributes/client/plan", true},
+		{"POST", "/api/agent/attributes-export", false},
+		{"POST", "/api/agent/clients", false},
+	}
+	for _, tt := range tests {
+		if got := isDefaultWorkspaceRoute(tt.method, tt.path); got != tt.want {
+			t.Errorf("isDefaultWorkspaceRoute(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
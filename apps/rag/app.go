@@ -6,6 +6,7 @@ import (
 	"github.com/iesreza/homa-backend/apps/ai"
 	"github.com/iesreza/homa-backend/apps/models"
 	natsconn "github.com/iesreza/homa-backend/apps/nats"
+	"github.com/iesreza/homa-backend/lib/tenant"
 	"github.com/nats-io/nats.go"
 )
 
@@ -36,7 +37,9 @@ func (a App) Register() error {
 			log.Info("Qdrant connection established successfully")
 
 			// Ensure collection exists
-			collectionName := GetCollectionName()
+			// Ensure the collection of the default workspace exists; the collections of the
+			// other workspaces are created when their first article is indexed
+			collectionName := GetCollectionName(tenant.DefaultID)
 			info, err := client.GetCollectionInfo(collectionName)
 			if err != nil {
 				log.Warning("Failed to get collection info: %v", err)
@@ -2,6 +2,7 @@ package rag
 
 import (
 	"github.com/getevo/evo/v2"
+	"github.com/iesreza/homa-backend/apps/models"
 	"github.com/iesreza/homa-backend/lib/response"
 )
 
@@ -132,7 +133,7 @@ func (c Controller) GetHealthHandler(req *evo.Request) interface{} {
 	}
 
 	// Get collection info
-	collectionName := GetCollectionName()
+	collectionName := GetCollectionName(models.RequestWorkspaceID(req))
 	collectionInfo, err := client.GetCollectionInfo(collectionName)
 	if err != nil {
 		return response.OK(HealthResponse{
@@ -177,7 +178,7 @@ func (c Controller) ReindexHandler(req *evo.Request) interface{} {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInvalidInput, "RAG is disabled", 400, "enable RAG before reindexing"))
 	}
 
-	err := StartReindexAllArticles()
+	err := StartReindexAllArticles(models.RequestWorkspaceID(req))
 	if err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Reindex failed to start", 500, err.Error()))
 	}
@@ -239,7 +240,7 @@ func (c Controller) SearchHandler(req *evo.Request) interface{} {
 		searchReq.ScoreThreshold = cfg.ScoreThreshold
 	}
 
-	results, err := Search(searchReq.Query, searchReq.Limit, searchReq.ScoreThreshold)
+	results, err := Search(models.RequestWorkspaceID(req), searchReq.Query, searchReq.Limit, searchReq.ScoreThreshold)
 	if err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Search failed", 500, err.Error()))
 	}
@@ -261,7 +262,7 @@ func (c Controller) DropCollectionHandler(req *evo.Request) interface{} {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Qdrant client not initialized", 500, ""))
 	}
 
-	collectionName := GetCollectionName()
+	collectionName := GetCollectionName(models.RequestWorkspaceID(req))
 	if err := client.DeleteCollection(collectionName); err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to drop collection", 500, err.Error()))
 	}
@@ -287,7 +288,7 @@ func (c Controller) CreateCollectionHandler(req *evo.Request) interface{} {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Qdrant client not initialized", 500, ""))
 	}
 
-	collectionName := GetCollectionName()
+	collectionName := GetCollectionName(models.RequestWorkspaceID(req))
 	if err := client.CreateCollection(collectionName, cfg.VectorSize); err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to create collection", 500, err.Error()))
 	}
@@ -317,7 +318,7 @@ func (c Controller) GetStatsHandler(req *evo.Request) interface{} {
 	}
 
 	// Get indexed article count from DB
-	articleCount, err := GetIndexedArticleCount()
+	articleCount, err := GetIndexedArticleCount(models.RequestWorkspaceID(req))
 	if err != nil {
 		articleCount = 0
 	}
@@ -326,7 +327,7 @@ func (c Controller) GetStatsHandler(req *evo.Request) interface{} {
 	vectorCount := 0
 	client := GetQdrantClient()
 	if client != nil {
-		collectionName := GetCollectionName()
+		collectionName := GetCollectionName(models.RequestWorkspaceID(req))
 		if count, err := client.CountPoints(collectionName); err == nil {
 			vectorCount = count
 		}
@@ -360,7 +361,7 @@ type IndexedArticle struct {
 // @Success 200 {array} IndexedArticle
 // @Router /api/rag/indexed-articles [get]
 func (c Controller) GetIndexedArticlesHandler(req *evo.Request) interface{} {
-	articles, err := GetIndexedArticles()
+	articles, err := GetIndexedArticles(models.RequestWorkspaceID(req))
 	if err != nil {
 		return response.Error(response.NewErrorWithDetails(response.ErrorCodeInternalError, "Failed to get indexed articles", 500, err.Error()))
 	}
@@ -72,7 +72,7 @@ func (i *ArticleIndexer) IndexArticle(articleID uuid.UUID) error {
 	log.Info("Indexing article: %s (%s)", article.Title, articleID)
 
 	// Delete existing chunks for this article (both DB and Qdrant)
-	if err := i.DeleteArticleIndex(articleID); err != nil {
+	if err := i.DeleteArticleIndex(article.WorkspaceID, articleID); err != nil {
 		log.Warning("Failed to delete existing index for article %s: %v", articleID, err)
 	}
 
@@ -114,7 +114,10 @@ func (i *ArticleIndexer) IndexArticle(articleID uuid.UUID) error {
 		return fmt.Errorf("Qdrant client not initialized")
 	}
 
-	collectionName := GetCollectionName()
+	collectionName, err := EnsureCollection(article.WorkspaceID)
+	if err != nil {
+		return fmt.Errorf("failed to prepare Qdrant collection: %w", err)
+	}
 	var qdrantPoints []QdrantPoint
 	var dbChunks []models.KnowledgeBaseChunk
 
@@ -161,14 +164,14 @@ func (i *ArticleIndexer) IndexArticle(articleID uuid.UUID) error {
 	return nil
 }
 
-// DeleteArticleIndex removes an article from the vector database
-func (i *ArticleIndexer) DeleteArticleIndex(articleID uuid.UUID) error {
+// DeleteArticleIndex removes an article from the vector database of its workspace
+func (i *ArticleIndexer) DeleteArticleIndex(workspaceID uint, articleID uuid.UUID) error {
 	log.Info("Deleting index for article: %s", articleID)
 
 	// Delete from Qdrant
 	qdrantClient := GetQdrantClient()
 	if qdrantClient != nil {
-		collectionName := GetCollectionName()
+		collectionName := GetCollectionName(workspaceID)
 		if err := qdrantClient.DeletePoints(collectionName, articleID.String()); err != nil {
 			log.Warning("Failed to delete vectors from Qdrant: %v", err)
 		}
@@ -182,8 +185,8 @@ func (i *ArticleIndexer) DeleteArticleIndex(articleID uuid.UUID) error {
 	return nil
 }
 
-// StartReindexAllArticles starts a background reindex job
-func StartReindexAllArticles() error {
+// StartReindexAllArticles starts a background reindex job for the articles of the workspace
+func StartReindexAllArticles(workspaceID uint) error {
 	reindexMu.Lock()
 	if reindexStatus.Running {
 		reindexMu.Unlock()
@@ -198,7 +201,7 @@ func StartReindexAllArticles() error {
 
 	// Get all published articles
 	var articles []models.KnowledgeBaseArticle
-	if err := db.Where("status = ?", "published").Find(&articles).Error; err != nil {
+	if err := models.WorkspaceDB(workspaceID).Where("status = ?", "published").Find(&articles).Error; err != nil {
 		reindexMu.Unlock()
 		return fmt.Errorf("failed to fetch articles: %w", err)
 	}
@@ -247,8 +250,8 @@ func StartReindexAllArticles() error {
 	return nil
 }
 
-// ReindexAllArticles reindexes all published articles (synchronous, for backwards compatibility)
-func ReindexAllArticles() (int, error) {
+// ReindexAllArticles reindexes the published articles of the workspace (synchronous, for backwards compatibility)
+func ReindexAllArticles(workspaceID uint) (int, error) {
 	cfg := GetConfig()
 	if !cfg.Enabled {
 		return 0, fmt.Errorf("RAG is disabled")
@@ -256,7 +259,7 @@ func ReindexAllArticles() (int, error) {
 
 	// Get all published articles
 	var articles []models.KnowledgeBaseArticle
-	if err := db.Where("status = ?", "published").Find(&articles).Error; err != nil {
+	if err := models.WorkspaceDB(workspaceID).Where("status = ?", "published").Find(&articles).Error; err != nil {
 		return 0, fmt.Errorf("failed to fetch articles: %w", err)
 	}
 
@@ -277,11 +280,13 @@ func ReindexAllArticles() (int, error) {
 	return successCount, nil
 }
 
-// GetIndexedArticleCount returns the count of indexed articles
-func GetIndexedArticleCount() (int, error) {
+// GetIndexedArticleCount returns the count of indexed articles of the workspace
+func GetIndexedArticleCount(workspaceID uint) (int, error) {
 	var count int64
 	if err := db.Model(&models.KnowledgeBaseChunk{}).
-		Distinct("article_id").
+		Joins("JOIN knowledge_base_articles ON knowledge_base_articles.id = knowledge_base_chunks.article_id").
+		Where("knowledge_base_articles.workspace_id = ?", workspaceID).
+		Distinct("knowledge_base_chunks.article_id").
 		Count(&count).Error; err != nil {
 		return 0, err
 	}
@@ -301,8 +306,8 @@ type IndexedArticleInfo struct {
 	TotalTokens int    `json:"total_tokens"`
 }
 
-// GetIndexedArticles returns a list of all indexed articles with their chunk info
-func GetIndexedArticles() ([]IndexedArticleInfo, error) {
+// GetIndexedArticles returns a list of the indexed articles of the workspace with their chunk info
+func GetIndexedArticles(workspaceID uint) ([]IndexedArticleInfo, error) {
 	type ChunkStats struct {
 		ArticleID   uuid.UUID `gorm:"column:article_id"`
 		ChunkCount  int       `gorm:"column:chunk_count"`
@@ -311,8 +316,10 @@ func GetIndexedArticles() ([]IndexedArticleInfo, error) {
 
 	var stats []ChunkStats
 	if err := db.Model(&models.KnowledgeBaseChunk{}).
-		Select("article_id, COUNT(*) as chunk_count, SUM(token_count) as total_tokens").
-		Group("article_id").
+		Select("knowledge_base_chunks.article_id, COUNT(*) as chunk_count, SUM(knowledge_base_chunks.token_count) as total_tokens").
+		Joins("JOIN knowledge_base_articles ON knowledge_base_articles.id = knowledge_base_chunks.article_id").
+		Where("knowledge_base_articles.workspace_id = ?", workspaceID).
+		Group("knowledge_base_chunks.article_id").
 		Find(&stats).Error; err != nil {
 		return nil, fmt.Errorf("failed to get chunk stats: %w", err)
 	}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/getevo/evo/v2/lib/log"
 	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/iesreza/homa-backend/lib/tenant"
 )
 
 // QdrantClient is a client for the Qdrant vector database
@@ -191,6 +192,7 @@ func (c *QdrantClient) DeleteCollection(name string) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete collection: %w", err)
 	}
+	ensuredCollections.Delete(name)
 
 	log.Info("Deleted Qdrant collection: %s", name)
 	return nil
@@ -278,7 +280,35 @@ func (c *QdrantClient) CountPoints(collectionName string) (int, error) {
 	return info.PointsCount, nil
 }
 
-// GetCollectionName returns the configured collection name
-func GetCollectionName() string {
-	return settings.Get("QDRANT.COLLECTION_NAME", "knowledge_base").String()
+// GetCollectionName returns the collection of the workspace, named after the configured collection
+func GetCollectionName(workspaceID uint) string {
+	return tenant.Collection(workspaceID, settings.Get("QDRANT.COLLECTION_NAME", "knowledge_base").String())
+}
+
+// ensuredCollections caches the collections known to exist
+var ensuredCollections sync.Map
+
+// EnsureCollection creates the collection of the workspace on first use and returns its name
+func EnsureCollection(workspaceID uint) (string, error) {
+	collectionName := GetCollectionName(workspaceID)
+	if _, ok := ensuredCollections.Load(collectionName); ok {
+		return collectionName, nil
+	}
+	client := GetQdrantClient()
+	if client == nil {
+		return collectionName, fmt.Errorf("Qdrant client not initialized")
+	}
+	info, err := client.GetCollectionInfo(collectionName)
+	if err != nil {
+		return collectionName, err
+	}
+	if !info.Exists {
+		vectorSize := GetConfig().VectorSize
+		log.Info("Creating Qdrant collection: %s with vector size: %d", collectionName, vectorSize)
+		if err := client.CreateCollection(collectionName, vectorSize); err != nil {
+			return collectionName, err
+		}
+	}
+	ensuredCollections.Store(collectionName, true)
+	return collectionName, nil
 }
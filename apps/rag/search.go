@@ -15,8 +15,8 @@ type ArticleSearchResult struct {
 	Score        float32 `json:"score"`
 }
 
-// Search searches the knowledge base for relevant content
-func Search(query string, limit int, scoreThreshold float32) ([]ArticleSearchResult, error) {
+// Search searches the knowledge base of the workspace for relevant content
+func Search(workspaceID uint, query string, limit int, scoreThreshold float32) ([]ArticleSearchResult, error) {
 	cfg := GetConfig()
 	if !cfg.Enabled {
 		return nil, fmt.Errorf("RAG is disabled")
@@ -46,7 +46,7 @@ func Search(query string, limit int, scoreThreshold float32) ([]ArticleSearchRes
 		return nil, fmt.Errorf("Qdrant client not initialized")
 	}
 
-	collectionName := GetCollectionName()
+	collectionName := GetCollectionName(workspaceID)
 	results, err := qdrantClient.Search(collectionName, queryVector, limit, scoreThreshold)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search: %w", err)
@@ -69,9 +69,9 @@ func Search(query string, limit int, scoreThreshold float32) ([]ArticleSearchRes
 
 // SearchWithContext searches and returns context for AI responses
 // Uses configurable threshold to support multilingual queries
-func SearchWithContext(query string, limit int) (string, error) {
+func SearchWithContext(workspaceID uint, query string, limit int) (string, error) {
 	cfg := GetConfig()
-	results, err := Search(query, limit, cfg.ScoreThreshold)
+	results, err := Search(workspaceID, query, limit, cfg.ScoreThreshold)
 	if err != nil {
 		return "", err
 	}
@@ -9,7 +9,10 @@ import (
 
 	"github.com/getevo/evo/v2"
 	"github.com/gofiber/fiber/v2"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/clientip"
 	"github.com/iesreza/homa-backend/lib/response"
+	"github.com/iesreza/homa-backend/lib/tenant"
 )
 
 // RateLimitConfig holds the configuration for a rate limit rule
@@ -69,13 +72,11 @@ func RateLimitMiddleware(key string) fiber.Handler {
 		}
 
 		// Get client identifier (IP address)
-		clientIP := c.IP()
-		if forwarded := c.Get("X-Forwarded-For"); forwarded != "" {
-			clientIP = forwarded
-		}
+		clientIP := clientip.FromFiber(c)
 
 		// Create Redis key for this client and endpoint
-		redisKey := fmt.Sprintf("rate_limit:%s:%s", key, clientIP)
+		workspaceID, _ := c.Locals(models.WorkspaceLocal).(uint)
+		redisKey := tenant.Key(workspaceID, fmt.Sprintf("rate_limit:%s:%s", key, clientIP))
 
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
@@ -122,12 +123,10 @@ func RateLimitByIP(maxRequests int, window time.Duration) fiber.Handler {
 		}
 
 		// Get client identifier
-		clientIP := c.IP()
-		if forwarded := c.Get("X-Forwarded-For"); forwarded != "" {
-			clientIP = forwarded
-		}
+		clientIP := clientip.FromFiber(c)
 
-		redisKey := fmt.Sprintf("rate_limit:ip:%s", clientIP)
+		workspaceID, _ := c.Locals(models.WorkspaceLocal).(uint)
+		redisKey := tenant.Key(workspaceID, fmt.Sprintf("rate_limit:ip:%s", clientIP))
 
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
@@ -183,13 +182,10 @@ func EvoRateLimitMiddleware(key string) func(*evo.Request) error {
 		}
 
 		// Get client identifier (IP address)
-		clientIP := req.IP()
-		if forwarded := req.Header("X-Forwarded-For"); forwarded != "" {
-			clientIP = forwarded
-		}
+		clientIP := clientip.FromRequest(req)
 
 		// Create Redis key for this client and endpoint
-		redisKey := fmt.Sprintf("rate_limit:%s:%s", key, clientIP)
+		redisKey := tenant.Key(models.RequestWorkspaceID(req), fmt.Sprintf("rate_limit:%s:%s", key, clientIP))
 
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
@@ -214,3 +210,26 @@ func EvoRateLimitMiddleware(key string) func(*evo.Request) error {
 		return req.Next()
 	}
 }
+
+// AllowInboxRequest counts a widget request of a visitor against the rate limit of the inbox.
+// Requests are allowed when the inbox has no rate limit or Redis is not available.
+func AllowInboxRequest(inbox *models.Inbox, clientIP string) bool {
+	if inbox == nil || inbox.RateLimit <= 0 || !IsAvailable() {
+		return true
+	}
+
+	redisKey := tenant.Key(inbox.WorkspaceID, fmt.Sprintf("rate_limit:inbox:%d:%s", inbox.ID, clientIP))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	count, err := Client.Incr(ctx, redisKey).Result()
+	if err != nil {
+		log.Printf("Redis rate limit error: %v", err)
+		return true
+	}
+	if count == 1 {
+		Client.Expire(ctx, redisKey, time.Minute)
+	}
+	return int(count) <= inbox.RateLimit
+}
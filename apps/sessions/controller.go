@@ -82,17 +82,18 @@ func (c Controller) StartSession(request *evo.Request) any {
 
 	// Check for existing session with this session_id (regardless of is_active status)
 	var existingSession models.UserSession
-	err := db.Where("user_id = ? AND session_id = ?", user.UserID, req.SessionID).First(&existingSession).Error
+	err := db.GetContext(request).Where("user_id = ? AND session_id = ?", user.UserID, req.SessionID).First(&existingSession).Error
 
 	if err == nil {
 		// Session exists, update last activity and IP (may have changed)
 		existingSession.LastActivity = now
 		existingSession.IPAddress = ip
 		existingSession.UserAgent = userAgent
+		existingSession.TokenSessionID = user.SessionID()
 		if req.TabID != "" {
 			existingSession.TabID = &req.TabID
 		}
-		db.Save(&existingSession)
+		db.GetContext(request).Save(&existingSession)
 
 		// Update daily activity
 		go updateDailyActivity(user.UserID, now)
@@ -105,19 +106,20 @@ func (c Controller) StartSession(request *evo.Request) any {
 	// Create new session
 	deviceInfoJSON, _ := json.Marshal(req.DeviceInfo)
 	session := models.UserSession{
-		UserID:       user.UserID,
-		SessionID:    req.SessionID,
-		IPAddress:    ip,
-		UserAgent:    userAgent,
-		DeviceInfo:   datatypes.JSON(deviceInfoJSON),
-		StartedAt:    now,
-		LastActivity: now,
+		UserID:         user.UserID,
+		SessionID:      req.SessionID,
+		IPAddress:      ip,
+		UserAgent:      userAgent,
+		DeviceInfo:     datatypes.JSON(deviceInfoJSON),
+		TokenSessionID: user.SessionID(),
+		StartedAt:      now,
+		LastActivity:   now,
 	}
 	if req.TabID != "" {
 		session.TabID = &req.TabID
 	}
 
-	if err := db.Create(&session).Error; err != nil {
+	if err := db.GetContext(request).Create(&session).Error; err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
@@ -150,7 +152,7 @@ func (c Controller) Heartbeat(request *evo.Request) any {
 
 	// Find session (regardless of is_active status)
 	var session models.UserSession
-	if err := db.Where("user_id = ? AND session_id = ?", user.UserID, req.SessionID).First(&session).Error; err != nil {
+	if err := db.GetContext(request).Where("user_id = ? AND session_id = ?", user.UserID, req.SessionID).First(&session).Error; err != nil {
 		// Session not found, need to restart
 		return outcome.Response{
 			StatusCode: http.StatusNotFound,
@@ -164,7 +166,7 @@ func (c Controller) Heartbeat(request *evo.Request) any {
 
 	// Update last activity
 	session.LastActivity = now
-	if err := db.Save(&session).Error; err != nil {
+	if err := db.GetContext(request).Save(&session).Error; err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
@@ -195,7 +197,7 @@ func (c Controller) EndSession(request *evo.Request) any {
 
 	// Set last_activity to 1 hour ago to mark as inactive
 	inactiveTime := time.Now().Add(-1 * time.Hour)
-	result := db.Model(&models.UserSession{}).
+	result := db.GetContext(request).Model(&models.UserSession{}).
 		Where("user_id = ? AND session_id = ?", user.UserID, req.SessionID).
 		Update("last_activity", inactiveTime)
 
@@ -203,6 +205,13 @@ func (c Controller) EndSession(request *evo.Request) any {
 		return response.Error(response.ErrNotFound)
 	}
 
+	// Logging out ends the token session so the tokens cannot be used any more
+	if req.Reason == "logout" {
+		if err := auth.RevokeSession(user.UserID, user.SessionID(), auth.SessionRevokedLogout); err != nil {
+			log.Error("Failed to revoke token session on logout: ", err)
+		}
+	}
+
 	return response.Message("session ended")
 }
 
@@ -214,7 +223,7 @@ func (c Controller) GetMySessions(request *evo.Request) any {
 	}
 
 	var sessions []models.UserSession
-	db.Where("user_id = ?", user.UserID).
+	db.GetContext(request).Where("user_id = ?", user.UserID).
 		Order("started_at DESC").
 		Limit(50).
 		Find(&sessions)
@@ -236,7 +245,7 @@ func (c Controller) GetActiveSessions(request *evo.Request) any {
 	activeThreshold := time.Now().Add(-SessionTimeout)
 
 	var sessions []models.UserSession
-	db.Where("user_id = ? AND last_activity >= ?", user.UserID, activeThreshold).
+	db.GetContext(request).Where("user_id = ? AND last_activity >= ?", user.UserID, activeThreshold).
 		Order("last_activity DESC").
 		Find(&sessions)
 
@@ -262,7 +271,7 @@ func (c Controller) TerminateSession(request *evo.Request) any {
 	// Only terminate sessions that are still "active" (last_activity within 5 min)
 	activeThreshold := now.Add(-SessionTimeout)
 	inactiveTime := now.Add(-1 * time.Hour)
-	result := db.Model(&models.UserSession{}).
+	result := db.GetContext(request).Model(&models.UserSession{}).
 		Where("id = ? AND user_id = ? AND last_activity >= ?", sessionID, user.UserID, activeThreshold).
 		Update("last_activity", inactiveTime)
 
@@ -270,6 +279,15 @@ func (c Controller) TerminateSession(request *evo.Request) any {
 		return response.Error(response.NewError(response.ErrorCodeNotFound, "session not found or already ended", http.StatusNotFound))
 	}
 
+	// Revoke the tokens of the terminated session so its API and WebSocket access ends now
+	var session models.UserSession
+	if err := db.GetContext(request).Where("id = ? AND user_id = ?", sessionID, user.UserID).First(&session).Error; err == nil && session.TokenSessionID != "" {
+		if err := auth.RevokeSession(user.UserID, session.TokenSessionID, auth.SessionRevokedTerminated); err != nil {
+			log.Error("Failed to revoke token session: ", err)
+			return response.Error(response.ErrDatabaseError)
+		}
+	}
+
 	return response.Message("session terminated")
 }
 
@@ -292,10 +310,16 @@ func (c Controller) TerminateAllOtherSessions(request *evo.Request) any {
 	// Only terminate sessions that are still "active" (last_activity within 5 min)
 	activeThreshold := now.Add(-SessionTimeout)
 	inactiveTime := now.Add(-1 * time.Hour)
-	result := db.Model(&models.UserSession{}).
+	result := db.GetContext(request).Model(&models.UserSession{}).
 		Where("user_id = ? AND session_id != ? AND last_activity >= ?", user.UserID, req.CurrentSessionID, activeThreshold).
 		Update("last_activity", inactiveTime)
 
+	// Every other login of the user loses its tokens, including sessions without recent activity
+	if _, err := auth.RevokeUserSessions(user.UserID, auth.SessionRevokedTerminated, user.SessionID()); err != nil {
+		log.Error("Failed to revoke token sessions: ", err)
+		return response.Error(response.ErrDatabaseError)
+	}
+
 	return response.OKWithMessage(map[string]any{
 		"terminated_count": result.RowsAffected,
 	}, "other sessions terminated")
@@ -324,7 +348,7 @@ func (c Controller) GetSessionHistory(request *evo.Request) any {
 	endDate := request.Query("end_date").String()
 
 	// Build query
-	query := db.Model(&models.UserSession{}).Where("user_id = ?", user.UserID)
+	query := db.GetContext(request).Model(&models.UserSession{}).Where("user_id = ?", user.UserID)
 
 	if startDate != "" {
 		startTime, err := time.Parse("2006-01-02", startDate)
@@ -388,7 +412,7 @@ func (c Controller) GetDailyActivity(request *evo.Request) any {
 	}
 
 	var activities []models.UserDailyActivity
-	db.Where("user_id = ? AND activity_date BETWEEN ? AND ?", user.UserID, startDate, endDate).
+	db.GetContext(request).Where("user_id = ? AND activity_date BETWEEN ? AND ?", user.UserID, startDate, endDate).
 		Order("activity_date DESC").
 		Find(&activities)
 
@@ -407,7 +431,7 @@ func (c Controller) GetTodayActivity(request *evo.Request) any {
 	today := time.Now().Format("2006-01-02")
 
 	var activity models.UserDailyActivity
-	err := db.Where("user_id = ? AND activity_date = ?", user.UserID, today).First(&activity).Error
+	err := db.GetContext(request).Where("user_id = ? AND activity_date = ?", user.UserID, today).First(&activity).Error
 
 	if err != nil {
 		// No activity for today yet - return zero values
@@ -455,7 +479,7 @@ func (c Controller) GetActivitySummary(request *evo.Request) any {
 	// Calculate total active time
 	var totalSeconds int64
 	var totalDays int64
-	db.Model(&models.UserDailyActivity{}).
+	db.GetContext(request).Model(&models.UserDailyActivity{}).
 		Where("user_id = ? AND activity_date >= ?", user.UserID, startDate.Format("2006-01-02")).
 		Select("COALESCE(SUM(total_active_seconds), 0) as total_seconds, COUNT(*) as total_days").
 		Row().Scan(&totalSeconds, &totalDays)
@@ -498,7 +522,7 @@ func (c Controller) GetActivityStats(request *evo.Request) any {
 	// Get all activity for the month
 	datePrefix := year + "-" + month
 	var activities []models.UserDailyActivity
-	db.Where("user_id = ? AND activity_date LIKE ?", user.UserID, datePrefix+"%").
+	db.GetContext(request).Where("user_id = ? AND activity_date LIKE ?", user.UserID, datePrefix+"%").
 		Order("activity_date ASC").
 		Find(&activities)
 
@@ -9,6 +9,8 @@ import (
 	"github.com/getevo/evo/v2/lib/log"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/tenant"
 )
 
 // CreateMultipartRequest represents a request to create a multipart upload
@@ -58,7 +60,7 @@ func CreateMultipartUploadHandler(c *fiber.Ctx) error {
 	if prefix == "" {
 		prefix = GetUploadPrefix() // Use configured default prefix
 	}
-	key := fmt.Sprintf("%s/%s%s", prefix, uuid.New().String(), ext)
+	key := workspaceKey(c, fmt.Sprintf("%s/%s%s", prefix, uuid.New().String(), ext))
 
 	log.Debug("[Storage:CreateMultipart] Generated key: %s", key)
 
@@ -118,6 +120,12 @@ func SignPartHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	if !ownsKey(c, req.Key) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Key does not belong to this workspace",
+		})
+	}
+
 	url, err := GetPresignedUploadPartURL(c.Context(), req.Key, req.UploadID, req.PartNumber)
 	if err != nil {
 		log.Error("[Storage:SignPart] Failed to sign part: %v", err)
@@ -166,6 +174,12 @@ func CompleteMultipartUploadHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	if !ownsKey(c, req.Key) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Key does not belong to this workspace",
+		})
+	}
+
 	if err := CompleteMultipartUpload(c.Context(), req.Key, req.UploadID, req.Parts); err != nil {
 		log.Error("[Storage:CompleteMultipart] Failed to complete: %v", err)
 		return c.Status(500).JSON(fiber.Map{
@@ -215,6 +229,12 @@ func AbortMultipartUploadHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	if !ownsKey(c, req.Key) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Key does not belong to this workspace",
+		})
+	}
+
 	if err := AbortMultipartUpload(c.Context(), req.Key, req.UploadID); err != nil {
 		log.Error("[Storage:AbortMultipart] Failed to abort: %v", err)
 		return c.Status(500).JSON(fiber.Map{
@@ -246,6 +266,12 @@ func ListPartsHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	if !ownsKey(c, key) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Key does not belong to this workspace",
+		})
+	}
+
 	parts, err := ListParts(c.Context(), key, uploadID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
@@ -309,7 +335,7 @@ func PresignUploadHandler(c *fiber.Ctx) error {
 	if prefix == "" {
 		prefix = GetUploadPrefix() // Use configured default prefix
 	}
-	key := fmt.Sprintf("%s/%s%s", prefix, uuid.New().String(), ext)
+	key := workspaceKey(c, fmt.Sprintf("%s/%s%s", prefix, uuid.New().String(), ext))
 
 	log.Debug("[Storage:PresignUpload] Generated key: %s", key)
 
@@ -365,3 +391,15 @@ func getExtensionFromContentType(contentType string) string {
 		return ""
 	}
 }
+
+// workspaceKey places an object key under the S3 prefix of the workspace of the request
+func workspaceKey(c *fiber.Ctx, key string) string {
+	workspaceID, _ := c.Locals(models.WorkspaceLocal).(uint)
+	return tenant.Prefix(workspaceID, key)
+}
+
+// ownsKey reports whether an object key is under the S3 prefix of the workspace of the request
+func ownsKey(c *fiber.Ctx, key string) bool {
+	workspaceID, _ := c.Locals(models.WorkspaceLocal).(uint)
+	return tenant.HasPrefix(workspaceID, key)
+}
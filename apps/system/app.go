@@ -117,6 +117,8 @@ func (a App) Router() error {
 	// Activity Logs APIs (admin only)
 	evo.Use("/api/activity-logs", controller.AdminMiddleware)
 	evo.Get("/api/activity-logs", controller.GetActivityLogs)
+	evo.Get("/api/activity-logs/verify", controller.VerifyActivityLogs)
+	evo.Get("/api/activity-logs/export", controller.ExportActivityLogs)
 	evo.Get("/api/activity-logs/:entity_type/:entity_id", controller.GetEntityActivityLogs)
 
 	return nil
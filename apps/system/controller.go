@@ -1,6 +1,10 @@
 package system
 
 import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
 	"time"
 
 	"github.com/getevo/evo/v2"
@@ -41,7 +45,7 @@ type DepartmentListResponse []models.Department
 // @Router /api/system/departments [get]
 func (c Controller) GetDepartments(req *evo.Request) interface{} {
 	var departments []models.Department
-	if err := db.Find(&departments).Error; err != nil {
+	if err := db.GetContext(req).Find(&departments).Error; err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
@@ -118,15 +122,9 @@ func (c Controller) GetTicketStatuses(req *evo.Request) interface{} {
 	return response.List(statuses, len(statuses))
 }
 
+// AdminMiddleware requires the permission auth.RoutePermissions assigns to the route, administrator by default
 func (c Controller) AdminMiddleware(request *evo.Request) error {
-	if request.User().Anonymous() {
-		return response.ErrForbidden
-	}
-	var user = request.User().Interface().(*auth.User)
-	if user.Type != auth.UserTypeAdministrator {
-		return response.ErrForbidden
-	}
-	return request.Next()
+	return auth.PermissionMiddleware(request)
 }
 
 func (c Controller) ServeDashboard(request *evo.Request) any {
@@ -163,9 +161,9 @@ func (c Controller) GetSettings(req *evo.Request) interface{} {
 	var err error
 
 	if category != "" {
-		settings, err = models.GetSettingsByCategory(category)
+		settings, err = models.GetSettingsByCategory(models.RequestWorkspaceID(req), category)
 	} else {
-		settings, err = models.GetAllSettings()
+		settings, err = models.GetAllSettings(models.RequestWorkspaceID(req))
 	}
 
 	if err != nil {
@@ -201,7 +199,7 @@ func (c Controller) UpdateSettings(req *evo.Request) interface{} {
 	}
 
 	// Update settings
-	if err := models.BulkUpdateSettings(request.Settings); err != nil {
+	if err := models.BulkUpdateSettings(models.RequestWorkspaceID(req), request.Settings); err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
@@ -237,7 +235,7 @@ func (c Controller) GetSetting(req *evo.Request) interface{} {
 		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Key is required", 400))
 	}
 
-	setting, err := models.GetSetting(key)
+	setting, err := models.GetWorkspaceSetting(models.RequestWorkspaceID(req), key)
 	if err != nil {
 		return response.Error(response.NewError(response.ErrorCodeNotFound, "Setting not found", 404))
 	}
@@ -266,7 +264,7 @@ func (c Controller) SetSetting(req *evo.Request) interface{} {
 		return response.Error(response.ErrInvalidInput)
 	}
 
-	if err := models.SetSetting(key, setting.Value, setting.Type, setting.Category, setting.Label); err != nil {
+	if err := models.SetWorkspaceSetting(models.RequestWorkspaceID(req), key, setting.Value, setting.Type, setting.Category, setting.Label); err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
@@ -299,7 +297,7 @@ func (c Controller) DeleteSetting(req *evo.Request) interface{} {
 		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Key is required", 400))
 	}
 
-	if err := models.DeleteSetting(key); err != nil {
+	if err := models.DeleteSetting(models.RequestWorkspaceID(req), key); err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
 
@@ -337,7 +335,7 @@ func (c Controller) GetActivityLogs(req *evo.Request) interface{} {
 		userID = &parsedID
 	}
 
-	logs, total, err := models.GetActivityLogs(entityType, entityID, action, userID, limit, offset)
+	logs, total, err := models.GetActivityLogs(models.RequestWorkspaceID(req), entityType, entityID, action, userID, limit, offset)
 	if err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
@@ -365,7 +363,7 @@ func (c Controller) GetEntityActivityLogs(req *evo.Request) interface{} {
 		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Entity type and ID are required", 400))
 	}
 
-	logs, err := models.GetEntityActivityLogs(entityType, entityID, limit)
+	logs, err := models.GetEntityActivityLogs(models.RequestWorkspaceID(req), entityType, entityID, limit)
 	if err != nil {
 		return response.Error(response.ErrDatabaseError)
 	}
@@ -373,6 +371,160 @@ func (c Controller) GetEntityActivityLogs(req *evo.Request) interface{} {
 	return response.List(logs, len(logs))
 }
 
+// VerifyActivityLogs checks the hash chain of the activity log
+// @Summary Verify activity logs
+// @Description Recompute the hash chain of the activity log and report the first entry that was edited or whose predecessor was removed. Keep head_hash outside the database to also detect removed trailing entries.
+// @Tags Activity Logs
+// @Produce json
+// @Param from_id query int false "First entry to check"
+// @Param to_id query int false "Last entry to check"
+// @Success 200 {object} models.ActivityLogVerification
+// @Router /api/activity-logs/verify [get]
+func (c Controller) VerifyActivityLogs(req *evo.Request) interface{} {
+	result, err := models.VerifyActivityLogChain(models.RequestWorkspaceID(req), req.Query("from_id").Uint(), req.Query("to_id").Uint())
+	if err != nil {
+		return response.Error(response.ErrDatabaseError)
+	}
+	return response.OK(result)
+}
+
+// activityLogCSVHeader is the header row of CSV activity log exports
+var activityLogCSVHeader = []string{"id", "workspace_id", "created_at", "entity_type", "entity_id", "action", "user_id", "api_key_id", "old_values", "new_values", "metadata", "ip_address", "user_agent", "prev_hash", "hash"}
+
+// ExportActivityLogs streams activity log entries as JSON lines or CSV, oldest first
+// @Summary Export activity logs
+// @Description Stream activity log entries in chain order. Entries include their hashes so the export can be verified on its own.
+// @Tags Activity Logs
+// @Produce plain
+// @Param format query string false "jsonl (default) or csv"
+// @Param from query string false "Start date (YYYY-MM-DD or RFC 3339), inclusive"
+// @Param to query string false "End date (YYYY-MM-DD or RFC 3339), exclusive"
+// @Param entity_type query string false "Entity type filter"
+// @Param entity_id query string false "Entity ID filter"
+// @Param action query string false "Action filter"
+// @Param user_id query string false "User ID filter (UUID)"
+// @Router /api/activity-logs/export [get]
+func (c Controller) ExportActivityLogs(req *evo.Request) interface{} {
+	format := req.Query("format").String()
+	if format == "" {
+		format = "jsonl"
+	}
+	if format != "jsonl" && format != "csv" {
+		return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Format must be jsonl or csv", 400))
+	}
+
+	filter := models.ActivityLogFilter{
+		WorkspaceID: models.RequestWorkspaceID(req),
+		EntityType:  req.Query("entity_type").String(),
+		EntityID:    req.Query("entity_id").String(),
+		Action:      req.Query("action").String(),
+	}
+	for _, bound := range []struct {
+		param string
+		value **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		raw := req.Query(bound.param).String()
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", raw); err != nil {
+				return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid "+bound.param+" date, use YYYY-MM-DD or RFC 3339", 400))
+			}
+		}
+		*bound.value = &t
+	}
+	if raw := req.Query("user_id").String(); raw != "" {
+		userID, err := uuid.Parse(raw)
+		if err != nil {
+			return response.Error(response.NewError(response.ErrorCodeInvalidInput, "Invalid user ID format", 400))
+		}
+		filter.UserID = &userID
+	}
+
+	actor, _ := req.User().(*auth.User)
+	models.LogActivityExport(actor, format, map[string]any{
+		"from":        req.Query("from").String(),
+		"to":          req.Query("to").String(),
+		"entity_type": filter.EntityType,
+		"entity_id":   filter.EntityID,
+		"action":      filter.Action,
+		"user_id":     req.Query("user_id").String(),
+	}, req.IP(), req.Header("User-Agent"))
+
+	filename := "activity-logs-" + time.Now().Format("20060102-150405") + "." + format
+	if format == "csv" {
+		req.Context.Set("Content-Type", "text/csv")
+	} else {
+		req.Context.Set("Content-Type", "application/x-ndjson")
+	}
+	req.Context.Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	// The entries are read in batches while the response is written, so exports of any size use little memory
+	req.Context.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var write func(entry *models.ActivityLog) error
+		if format == "csv" {
+			csvWriter := csv.NewWriter(w)
+			_ = csvWriter.Write(activityLogCSVHeader)
+			write = func(entry *models.ActivityLog) error {
+				csvWriter.Write(activityLogCSVRow(entry))
+				csvWriter.Flush()
+				return csvWriter.Error()
+			}
+		} else {
+			encoder := json.NewEncoder(w)
+			write = func(entry *models.ActivityLog) error {
+				return encoder.Encode(entry)
+			}
+		}
+		err := models.EachActivityLog(filter, func(entry *models.ActivityLog) error {
+			if err := write(entry); err != nil {
+				return err
+			}
+			return w.Flush()
+		})
+		if err != nil {
+			log.Error("Activity log export stopped: %v", err)
+		}
+	})
+	return nil
+}
+
+// activityLogCSVRow returns the CSV columns of an activity log entry, in activityLogCSVHeader order
+func activityLogCSVRow(entry *models.ActivityLog) []string {
+	optional := func(v *string) string {
+		if v == nil {
+			return ""
+		}
+		return *v
+	}
+	var userID, apiKeyID string
+	if entry.UserID != nil {
+		userID = entry.UserID.String()
+	}
+	if entry.APIKeyID != nil {
+		apiKeyID = strconv.FormatUint(uint64(*entry.APIKeyID), 10)
+	}
+	return []string{
+		strconv.FormatUint(uint64(entry.ID), 10),
+		strconv.FormatUint(uint64(entry.WorkspaceID), 10),
+		entry.CreatedAt.UTC().Format(time.RFC3339),
+		entry.EntityType,
+		entry.EntityID,
+		entry.Action,
+		userID,
+		apiKeyID,
+		string(entry.OldValues),
+		string(entry.NewValues),
+		string(entry.Metadata),
+		optional(entry.IPAddress),
+		optional(entry.UserAgent),
+		entry.PrevHash,
+		entry.Hash,
+	}
+}
+
 // RateLimitUpdateRequest represents a request to update a rate limit setting
 type RateLimitUpdateRequest struct {
 	MaxRequests int  `json:"max_requests"`
@@ -38,6 +38,15 @@ func (a App) Router() error {
 func (a App) WhenReady() error {
 	// Handle CLI commands
 	GenerateMockWebhook()
+
+	// Deliver queued webhooks, including those left over from before a restart
+	StartQueue()
+	return nil
+}
+
+// Shutdown stops the delivery queue; undelivered items stay queued for the next start
+func (a App) Shutdown() error {
+	StopQueue()
 	return nil
 }
 
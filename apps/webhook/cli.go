@@ -57,13 +57,15 @@ func GenerateMockWebhook() {
 	webhook.EventClientUpdated = rand.Intn(2) == 1
 	webhook.EventUserCreated = rand.Intn(2) == 1
 	webhook.EventUserUpdated = rand.Intn(2) == 1
+	webhook.EventUserLocked = rand.Intn(2) == 1
 
 	// Ensure at least one event is subscribed
 	if !webhook.EventConversationCreated && !webhook.EventConversationUpdated &&
 		!webhook.EventConversationStatusChange && !webhook.EventConversationClosed &&
 		!webhook.EventConversationAssigned && !webhook.EventMessageCreated &&
 		!webhook.EventClientCreated && !webhook.EventClientUpdated &&
-		!webhook.EventUserCreated && !webhook.EventUserUpdated {
+		!webhook.EventUserCreated && !webhook.EventUserUpdated &&
+		!webhook.EventUserLocked {
 		webhook.EventConversationCreated = true
 	}
 
@@ -96,6 +98,7 @@ func GenerateMockWebhook() {
 	fmt.Printf("   Client Updated: %v\n", webhook.EventClientUpdated)
 	fmt.Printf("   User Created: %v\n", webhook.EventUserCreated)
 	fmt.Printf("   User Updated: %v\n", webhook.EventUserUpdated)
+	fmt.Printf("   User Locked: %v\n", webhook.EventUserLocked)
 
 	// Test sending the webhook if --send flag is provided
 	if args.Get("--send") != "" {
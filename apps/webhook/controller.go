@@ -14,14 +14,14 @@ type Controller struct{}
 func (c Controller) TestWebhook(request *evo.Request) any {
 	// Check admin authentication
 	user := request.User().(*auth.User)
-	if user.Anonymous() || user.Type != auth.UserTypeAdministrator {
+	if user.Anonymous() || !user.HasPermission(auth.PermissionWebhooksManage) {
 		return response.Error(response.ErrUnauthorized)
 	}
 
 	webhookID := request.Param("id").String()
 	var webhook models.Webhook
 
-	if err := db.First(&webhook, webhookID).Error; err != nil {
+	if err := db.GetContext(request).First(&webhook, webhookID).Error; err != nil {
 		return response.NotFound(request, "Webhook not found")
 	}
 
@@ -0,0 +1,263 @@
+package webhook
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/iesreza/homa-backend/apps/models"
+)
+
+// Queue configuration
+const (
+	queuePollInterval  = 5 * time.Second
+	queueStuckTimeout  = 2 * time.Minute // longer than the HTTP timeout of an attempt
+	queueRetention     = 7 * 24 * time.Hour
+	queuePruneInterval = time.Hour
+)
+
+// Defaults of the webhooks.* queue settings
+const (
+	DefaultQueueWorkers         = 8
+	DefaultQueueMaxPerEndpoint  = 2
+	DefaultQueueMaxAttempts     = 8
+	DefaultQueueOrderedAttempts = 3
+)
+
+// dispatcher sends queued deliveries on a pool of workers. Each endpoint (URL host) gets at most
+// webhooks.max_per_endpoint concurrent deliveries, so a slow receiver cannot take every worker.
+type dispatcher struct {
+	workers chan struct{}
+	wake    chan struct{}
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu        sync.Mutex
+	endpoints map[string]int
+}
+
+var queue *dispatcher
+
+// StartQueue starts delivering queued webhooks
+func StartQueue() {
+	if queue != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	queue = &dispatcher{
+		workers:   make(chan struct{}, queueSetting("webhooks.workers", DefaultQueueWorkers)),
+		wake:      make(chan struct{}, 1),
+		cancel:    cancel,
+		endpoints: map[string]int{},
+	}
+	queue.wg.Add(1)
+	go queue.run(ctx)
+}
+
+// StopQueue stops the dispatcher and waits for deliveries in progress
+func StopQueue() {
+	if queue == nil {
+		return
+	}
+	queue.cancel()
+	queue.wg.Wait()
+	queue = nil
+}
+
+// wakeQueue makes the dispatcher look for due deliveries without waiting for the next poll
+func wakeQueue() {
+	if q := queue; q != nil {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// queueSetting returns a positive integer setting
+func queueSetting(key string, defaultValue int) int {
+	value, err := strconv.Atoi(models.GetSettingValue(key, strconv.Itoa(defaultValue)))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func (d *dispatcher) run(ctx context.Context) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		d.recoverInterrupted()
+		if time.Since(lastPrune) > queuePruneInterval {
+			d.prune()
+			lastPrune = time.Now()
+		}
+		d.dispatch()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// recoverInterrupted queues deliveries again that were left in sending, e.g. by a restart mid-attempt.
+// Receivers dedupe on the delivery ID, so sending one twice is safe.
+func (d *dispatcher) recoverInterrupted() {
+	if err := db.Model(&models.WebhookQueueItem{}).
+		Where("status = ? AND updated_at < ?", models.WebhookQueueStatusSending, time.Now().Add(-queueStuckTimeout)).
+		Updates(map[string]any{"status": models.WebhookQueueStatusPending, "next_attempt_at": time.Now()}).Error; err != nil {
+		log.Warning("[webhook] failed to recover interrupted deliveries: %v", err)
+	}
+}
+
+// prune removes delivered items; their attempts stay in the delivery log
+func (d *dispatcher) prune() {
+	if err := db.Where("status = ? AND delivered_at < ?", models.WebhookQueueStatusDelivered, time.Now().Add(-queueRetention)).
+		Delete(&models.WebhookQueueItem{}).Error; err != nil {
+		log.Warning("[webhook] failed to prune delivered items: %v", err)
+	}
+}
+
+// dispatch starts the due deliveries that have a free worker and endpoint slot. Only the oldest
+// unfinished item of each webhook is due, which keeps deliveries to a webhook in order. An item that
+// failed webhooks.ordered_attempts attempts stops holding back the later ones, so a failing event
+// delays the rest of its webhook by a few minutes of backoff instead of until it is dead-lettered.
+func (d *dispatcher) dispatch() {
+	var items []models.WebhookQueueItem
+	err := db.Preload("Webhook").
+		Where("status = ? AND next_attempt_at <= ?", models.WebhookQueueStatusPending, time.Now()).
+		Where("NOT EXISTS (SELECT 1 FROM webhook_queue earlier WHERE earlier.webhook_id = webhook_queue.webhook_id AND earlier.status IN ? AND earlier.attempts < ? AND earlier.id < webhook_queue.id)",
+			[]string{models.WebhookQueueStatusPending, models.WebhookQueueStatusSending}, queueSetting("webhooks.ordered_attempts", DefaultQueueOrderedAttempts)).
+		Order("next_attempt_at ASC, id ASC").Limit(cap(d.workers) * 4).Find(&items).Error
+	if err != nil {
+		log.Warning("[webhook] failed to load queued deliveries: %v", err)
+		return
+	}
+
+	maxPerEndpoint := queueSetting("webhooks.max_per_endpoint", DefaultQueueMaxPerEndpoint)
+	for i := range items {
+		item := items[i]
+		if item.Webhook == nil {
+			d.finish(&item, models.WebhookQueueStatusDead, "webhook no longer exists")
+			continue
+		}
+		endpoint := endpointOf(item.Webhook.URL)
+		if !d.acquire(endpoint, maxPerEndpoint) {
+			continue
+		}
+		if !d.claim(&item) {
+			d.release(endpoint)
+			continue
+		}
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			defer wakeQueue()
+			defer d.release(endpoint)
+			d.process(&item)
+		}()
+	}
+}
+
+// acquire takes a worker and a slot of the endpoint, if both are free
+func (d *dispatcher) acquire(endpoint string, maxPerEndpoint int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.endpoints[endpoint] >= maxPerEndpoint {
+		return false
+	}
+	select {
+	case d.workers <- struct{}{}:
+	default:
+		return false
+	}
+	d.endpoints[endpoint]++
+	return true
+}
+
+// release frees the worker and endpoint slot taken by acquire
+func (d *dispatcher) release(endpoint string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	<-d.workers
+	if d.endpoints[endpoint]--; d.endpoints[endpoint] <= 0 {
+		delete(d.endpoints, endpoint)
+	}
+}
+
+// claim marks a pending item as sending; it fails when another instance claimed it first
+func (d *dispatcher) claim(item *models.WebhookQueueItem) bool {
+	result := db.Model(&models.WebhookQueueItem{}).
+		Where("id = ? AND status = ?", item.ID, models.WebhookQueueStatusPending).
+		Updates(map[string]any{"status": models.WebhookQueueStatusSending, "attempts": item.Attempts + 1})
+	if result.Error != nil {
+		log.Warning("[webhook] failed to claim delivery %s: %v", item.DeliveryID, result.Error)
+		return false
+	}
+	item.Attempts++
+	return result.RowsAffected == 1
+}
+
+// process makes one attempt and schedules the next one, or dead-letters the item when out of attempts
+func (d *dispatcher) process(item *models.WebhookQueueItem) {
+	if !item.Webhook.Enabled {
+		d.finish(item, models.WebhookQueueStatusDead, "webhook is disabled")
+		return
+	}
+
+	err := deliver(item.Webhook, item.Event, item.DeliveryID, []byte(item.Payload))
+	if err == nil {
+		d.finish(item, models.WebhookQueueStatusDelivered, "")
+		return
+	}
+
+	maxAttempts := queueSetting("webhooks.max_attempts", DefaultQueueMaxAttempts)
+	if item.Attempts >= maxAttempts {
+		log.Error("[webhook] delivery %s to %s is dead after %d attempts: %v", item.DeliveryID, item.Webhook.URL, item.Attempts, err)
+		d.finish(item, models.WebhookQueueStatusDead, err.Error())
+		return
+	}
+
+	backoff := calculateBackoff(item.Attempts)
+	log.Warning("[webhook] delivery %s to %s failed (attempt %d/%d): %v. Retrying in %v",
+		item.DeliveryID, item.Webhook.URL, item.Attempts, maxAttempts, err, backoff)
+	if err := db.Model(item).Updates(map[string]any{
+		"status":          models.WebhookQueueStatusPending,
+		"next_attempt_at": time.Now().Add(backoff),
+		"last_error":      err.Error(),
+	}).Error; err != nil {
+		log.Error("[webhook] failed to reschedule delivery %s: %v", item.DeliveryID, err)
+	}
+}
+
+// finish moves an item to a final status
+func (d *dispatcher) finish(item *models.WebhookQueueItem, status, lastError string) {
+	updates := map[string]any{"status": status, "last_error": lastError}
+	if status == models.WebhookQueueStatusDelivered {
+		updates["delivered_at"] = time.Now()
+	}
+	if err := db.Model(item).Updates(updates).Error; err != nil {
+		log.Error("[webhook] failed to update delivery %s: %v", item.DeliveryID, err)
+	}
+}
+
+// endpointOf returns the host deliveries to url are limited by
+func endpointOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	return strings.ToLower(parsed.Host)
+}
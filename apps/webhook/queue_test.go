@@ -0,0 +1,242 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getevo/evo/v2/lib/db"
+	"github.com/iesreza/homa-backend/apps/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// receiver is a webhook endpoint that answers every delivery with status and counts them
+type receiver struct {
+	*httptest.Server
+	status     atomic.Int32
+	deliveries atomic.Int32
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	r := &receiver{}
+	r.status.Store(int32(status))
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		r.deliveries.Add(1)
+		w.WriteHeader(int(r.status.Load()))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// setupQueue registers an empty database and returns a dispatcher that is not running, so each
+// test drives it one dispatch at a time
+func setupQueue(t *testing.T) *dispatcher {
+	t.Helper()
+	os.Setenv("ENCRYPTION_KEY", strings.Repeat("k", 32))
+	dbo, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbo.AutoMigrate(&models.Webhook{}, &models.WebhookQueueItem{}, &models.WebhookDelivery{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Register(dbo)
+	return &dispatcher{
+		workers:   make(chan struct{}, DefaultQueueWorkers),
+		wake:      make(chan struct{}, 1),
+		endpoints: map[string]int{},
+	}
+}
+
+// dispatchOnce runs one dispatch and waits for the deliveries it started
+func (d *dispatcher) dispatchOnce() {
+	d.dispatch()
+	d.wg.Wait()
+}
+
+func createWebhook(t *testing.T, url string) *models.Webhook {
+	t.Helper()
+	webhook := &models.Webhook{WorkspaceID: 1, Name: "test", URL: url, Enabled: true, EventAll: true}
+	if err := db.Create(webhook).Error; err != nil {
+		t.Fatal(err)
+	}
+	return webhook
+}
+
+func enqueue(t *testing.T, webhook *models.Webhook, n int) []models.WebhookQueueItem {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := EnqueueWebhook(db.Session(&gorm.Session{}), webhook, models.WebhookEventClientCreated, map[string]any{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var items []models.WebhookQueueItem
+	db.Where("webhook_id = ?", webhook.ID).Order("id").Find(&items)
+	return items
+}
+
+func loadItem(t *testing.T, id uint) models.WebhookQueueItem {
+	t.Helper()
+	var item models.WebhookQueueItem
+	if err := db.First(&item, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+// makeDue moves the next attempt of an item to now, as if its backoff had passed
+func makeDue(t *testing.T, id uint) {
+	t.Helper()
+	if err := db.Model(&models.WebhookQueueItem{}).Where("id = ?", id).Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueDeliversInOrder(t *testing.T) {
+	d := setupQueue(t)
+	endpoint := newReceiver(t, http.StatusOK)
+	items := enqueue(t, createWebhook(t, endpoint.URL), 3)
+
+	// Only the oldest unfinished item of a webhook is due, so each dispatch delivers one
+	for i, item := range items {
+		d.dispatchOnce()
+		if got := loadItem(t, item.ID).Status; got != models.WebhookQueueStatusDelivered {
+			t.Fatalf("after dispatch %d item %d is %s, want delivered", i+1, i+1, got)
+		}
+		for _, later := range items[i+1:] {
+			if got := loadItem(t, later.ID); got.Status != models.WebhookQueueStatusPending || got.Attempts != 0 {
+				t.Fatalf("item %d was attempted before the ones queued earlier", later.ID)
+			}
+		}
+	}
+}
+
+func TestQueueFailingDeliveryHoldsBackLaterOnes(t *testing.T) {
+	d := setupQueue(t)
+	endpoint := newReceiver(t, http.StatusInternalServerError)
+	items := enqueue(t, createWebhook(t, endpoint.URL), 2)
+	head, next := items[0], items[1]
+
+	for attempt := 1; attempt <= DefaultQueueOrderedAttempts; attempt++ {
+		makeDue(t, head.ID)
+		d.dispatchOnce()
+
+		got := loadItem(t, head.ID)
+		if got.Status != models.WebhookQueueStatusPending || got.Attempts != attempt || got.LastError == "" {
+			t.Fatalf("head after attempt %d = %s with %d attempts, want pending with %d and an error", attempt, got.Status, got.Attempts, attempt)
+		}
+		// The retry waits for the backoff of the attempt
+		if wait := time.Until(got.NextAttemptAt); wait < calculateBackoff(attempt)-5*time.Second || wait > calculateBackoff(attempt) {
+			t.Errorf("retry after attempt %d in %v, want %v", attempt, wait, calculateBackoff(attempt))
+		}
+		if attempt < DefaultQueueOrderedAttempts && loadItem(t, next.ID).Attempts != 0 {
+			t.Fatalf("later delivery was sent while the head had failed %d attempts", attempt)
+		}
+	}
+
+	// Past webhooks.ordered_attempts the head no longer holds the later delivery back
+	endpoint.status.Store(http.StatusOK)
+	d.dispatchOnce()
+	if got := loadItem(t, next.ID).Status; got != models.WebhookQueueStatusDelivered {
+		t.Fatalf("later delivery is %s, want delivered once the head failed %d attempts", got, DefaultQueueOrderedAttempts)
+	}
+	if got := loadItem(t, head.ID); got.Status != models.WebhookQueueStatusPending || got.Attempts != DefaultQueueOrderedAttempts {
+		t.Fatalf("head = %s with %d attempts, want it still waiting for its backoff", got.Status, got.Attempts)
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	d := setupQueue(t)
+	endpoint := newReceiver(t, http.StatusBadGateway)
+	items := enqueue(t, createWebhook(t, endpoint.URL), 1)
+	item := items[0]
+
+	for attempt := 1; attempt <= DefaultQueueMaxAttempts; attempt++ {
+		makeDue(t, item.ID)
+		d.dispatchOnce()
+	}
+	got := loadItem(t, item.ID)
+	if got.Status != models.WebhookQueueStatusDead || got.Attempts != DefaultQueueMaxAttempts {
+		t.Fatalf("item = %s after %d attempts, want dead after %d", got.Status, got.Attempts, DefaultQueueMaxAttempts)
+	}
+	if n := endpoint.deliveries.Load(); n != DefaultQueueMaxAttempts {
+		t.Errorf("receiver got %d attempts, want %d", n, DefaultQueueMaxAttempts)
+	}
+
+	// A dead item is never sent again
+	makeDue(t, item.ID)
+	d.dispatchOnce()
+	if n := endpoint.deliveries.Load(); n != DefaultQueueMaxAttempts {
+		t.Errorf("dead item was sent again")
+	}
+
+	var logged int64
+	db.Model(&models.WebhookDelivery{}).Where("delivery_id = ? AND success = ?", item.DeliveryID, false).Count(&logged)
+	if logged != DefaultQueueMaxAttempts {
+		t.Errorf("%d failed attempts logged, want %d", logged, DefaultQueueMaxAttempts)
+	}
+}
+
+func TestQueueDeadLettersDisabledWebhook(t *testing.T) {
+	d := setupQueue(t)
+	endpoint := newReceiver(t, http.StatusOK)
+	webhook := createWebhook(t, endpoint.URL)
+	items := enqueue(t, webhook, 1)
+	db.Model(webhook).Update("enabled", false)
+
+	d.dispatchOnce()
+	if got := loadItem(t, items[0].ID).Status; got != models.WebhookQueueStatusDead {
+		t.Fatalf("item = %s, want dead", got)
+	}
+	if endpoint.deliveries.Load() != 0 {
+		t.Error("disabled webhook received a delivery")
+	}
+}
+
+func TestQueueReplay(t *testing.T) {
+	d := setupQueue(t)
+	endpoint := newReceiver(t, http.StatusServiceUnavailable)
+	items := enqueue(t, createWebhook(t, endpoint.URL), 1)
+	item := loadItem(t, items[0].ID)
+	db.Model(&item).Updates(map[string]any{"status": models.WebhookQueueStatusDead, "attempts": DefaultQueueMaxAttempts, "last_error": "gone"})
+
+	if err := item.Replay(db.Session(&gorm.Session{})); err != nil {
+		t.Fatal(err)
+	}
+	if item.Status != models.WebhookQueueStatusPending || item.Attempts != 0 || item.LastError != "" {
+		t.Fatalf("replayed item = %s with %d attempts, want pending with a fresh budget", item.Status, item.Attempts)
+	}
+
+	endpoint.status.Store(http.StatusOK)
+	d.dispatchOnce()
+	got := loadItem(t, item.ID)
+	if got.Status != models.WebhookQueueStatusDelivered || got.DeliveryID != items[0].DeliveryID {
+		t.Fatalf("replayed item = %s, want delivered with its delivery ID", got.Status)
+	}
+
+	// An item being sent cannot be replayed
+	db.Model(&got).Update("status", models.WebhookQueueStatusSending)
+	if err := got.Replay(db.Session(&gorm.Session{})); err != models.ErrWebhookQueueItemSending {
+		t.Errorf("Replay of a sending item = %v, want %v", err, models.ErrWebhookQueueItemSending)
+	}
+}
+
+func TestCalculateBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		8:  64 * time.Minute,
+		20: MaxBackoff,
+	} {
+		if got := calculateBackoff(attempts); got != want {
+			t.Errorf("calculateBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
@@ -12,18 +12,21 @@ import (
 
 	"github.com/getevo/evo/v2/lib/db"
 	"github.com/getevo/evo/v2/lib/log"
+	"github.com/google/uuid"
 	"github.com/iesreza/homa-backend/apps/models"
+	"github.com/iesreza/homa-backend/lib/tenant"
+	"gorm.io/gorm"
 )
 
-// Retry configuration
+// Retry configuration: failed deliveries are retried after 30s, 1m, 2m, 4m... up to MaxBackoff
 const (
-	MaxRetries     = 5
-	InitialBackoff = 1 * time.Second
-	MaxBackoff     = 60 * time.Second
+	InitialBackoff = 30 * time.Second
+	MaxBackoff     = 6 * time.Hour
 )
 
 // WebhookPayload represents the structure of data sent to webhooks
 type WebhookPayload struct {
+	ID        string         `json:"id"` // Delivery ID, the same for every attempt
 	Event     string         `json:"event"`
 	Timestamp string         `json:"timestamp"`
 	Data      map[string]any `json:"data"`
@@ -41,8 +44,17 @@ func SendWebhook(webhook *models.Webhook, event string, data map[string]any) err
 		return nil
 	}
 
-	// Create payload
+	deliveryID, jsonData, err := newDelivery(event, data)
+	if err != nil {
+		return err
+	}
+	return deliver(webhook, event, deliveryID, jsonData)
+}
+
+// newDelivery builds the payload of a new delivery
+func newDelivery(event string, data map[string]any) (string, []byte, error) {
 	payload := WebhookPayload{
+		ID:        uuid.NewString(),
 		Event:     event,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Data:      data,
@@ -51,9 +63,13 @@ func SendWebhook(webhook *models.Webhook, event string, data map[string]any) err
 	// Marshal payload to JSON
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		return "", nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
+	return payload.ID, jsonData, nil
+}
 
+// deliver posts the payload of a delivery to the webhook. Every attempt is signed with its own timestamp.
+func deliver(webhook *models.Webhook, event, deliveryID string, jsonData []byte) error {
 	// Pretty print the body for logging
 	var prettyBody bytes.Buffer
 	json.Indent(&prettyBody, jsonData, "", "  ")
@@ -69,10 +85,11 @@ func SendWebhook(webhook *models.Webhook, event string, data map[string]any) err
 	req.Header.Set("User-Agent", "Homa-Webhook/1.0")
 	req.Header.Set("X-Webhook-Event", event)
 	req.Header.Set("X-Webhook-ID", fmt.Sprintf("%d", webhook.ID))
+	req.Header.Set(DeliveryHeader, deliveryID)
 
-	// Add Authorization header if secret is set
-	if webhook.Secret != "" {
-		req.Header.Set("Authorization", webhook.Secret)
+	// Sign the delivery; the secret itself is never sent
+	if secrets := webhook.SigningSecrets(); len(secrets) > 0 {
+		req.Header.Set(SignatureHeader, Sign(jsonData, time.Now(), secrets...))
 	}
 
 	// Capture headers for logging
@@ -89,7 +106,7 @@ func SendWebhook(webhook *models.Webhook, event string, data map[string]any) err
 
 	if err != nil {
 		// Log failed delivery with request details
-		logWebhookDeliveryFull(webhook.ID, event, false, webhook.URL, prettyBody.String(), headersJSON, 0, err.Error(), durationMs)
+		logWebhookDeliveryFull(webhook, deliveryID, event, false, webhook.URL, prettyBody.String(), headersJSON, 0, err.Error(), durationMs)
 		return fmt.Errorf("failed to send webhook: %w", err)
 	}
 	defer resp.Body.Close()
@@ -105,7 +122,7 @@ func SendWebhook(webhook *models.Webhook, event string, data map[string]any) err
 	success := resp.StatusCode >= 200 && resp.StatusCode < 300
 
 	// Log delivery with full details
-	logWebhookDeliveryFull(webhook.ID, event, success, webhook.URL, prettyBody.String(), headersJSON, resp.StatusCode, responseText, durationMs)
+	logWebhookDeliveryFull(webhook, deliveryID, event, success, webhook.URL, prettyBody.String(), headersJSON, resp.StatusCode, responseText, durationMs)
 
 	if !success {
 		return fmt.Errorf("webhook returned non-success status: %d", resp.StatusCode)
@@ -114,28 +131,58 @@ func SendWebhook(webhook *models.Webhook, event string, data map[string]any) err
 	return nil
 }
 
-// BroadcastWebhook sends a webhook event to all registered webhooks
-func BroadcastWebhook(event string, data map[string]any) {
+// BroadcastWebhook queues a webhook event for the subscribed webhooks of the workspace on tx. Model hooks pass
+// their transaction, so the deliveries are queued if and only if the change that triggers the event commits.
+// Rows created without a workspace belong to the default workspace, so 0 means the default workspace.
+func BroadcastWebhook(tx *gorm.DB, workspaceID uint, event string, data map[string]any) error {
+	if workspaceID == 0 {
+		workspaceID = tenant.DefaultID
+	}
+	tx = tx.Session(&gorm.Session{NewDB: true})
 	var webhooks []models.Webhook
-	if err := db.Where("enabled = ?", true).Find(&webhooks).Error; err != nil {
-		log.Error("Failed to fetch webhooks for broadcast:", err)
-		return
+	if err := tx.Where("enabled = ? AND workspace_id = ?", true, workspaceID).Find(&webhooks).Error; err != nil {
+		return fmt.Errorf("failed to fetch webhooks for %s: %w", event, err)
+	}
+
+	for i := range webhooks {
+		if err := EnqueueWebhook(tx, &webhooks[i], event, data); err != nil {
+			return fmt.Errorf("failed to queue %s to %s: %w", event, webhooks[i].URL, err)
+		}
 	}
+	return nil
+}
 
-	for _, webhook := range webhooks {
-		// Send webhook asynchronously
-		go func(w models.Webhook) {
-			if err := SendWebhook(&w, event, data); err != nil {
-				log.Error("Failed to send webhook to", w.URL, ":", err)
-			}
-		}(webhook)
+// EnqueueWebhook queues a delivery of the event to the webhook on tx, if it is enabled and subscribed.
+// A delivery queued in a transaction that has not committed yet is picked up by the next poll of the dispatcher.
+func EnqueueWebhook(tx *gorm.DB, webhook *models.Webhook, event string, data map[string]any) error {
+	if !webhook.Enabled || !webhook.IsSubscribedTo(event) {
+		return nil
 	}
+
+	deliveryID, jsonData, err := newDelivery(event, data)
+	if err != nil {
+		return err
+	}
+	item := models.WebhookQueueItem{
+		WorkspaceID:   webhook.WorkspaceID,
+		WebhookID:     webhook.ID,
+		DeliveryID:    deliveryID,
+		Event:         event,
+		Payload:       string(jsonData),
+		Status:        models.WebhookQueueStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := tx.Create(&item).Error; err != nil {
+		return fmt.Errorf("failed to queue delivery: %w", err)
+	}
+	wakeQueue()
+	return nil
 }
 
 // BroadcastWebhookWithData broadcasts a webhook event with data that may already contain nested structures
 // This is used for user webhooks where we pass the sanitized data directly
-func BroadcastWebhookWithData(event string, data map[string]any) {
-	BroadcastWebhook(event, data)
+func BroadcastWebhookWithData(tx *gorm.DB, workspaceID uint, event string, data map[string]any) error {
+	return BroadcastWebhook(tx, workspaceID, event, data)
 }
 
 // formatHeaders converts http.Header to JSON string for logging
@@ -149,9 +196,11 @@ func formatHeaders(headers http.Header) string {
 }
 
 // logWebhookDeliveryFull logs webhook delivery attempts with full request details
-func logWebhookDeliveryFull(webhookID uint, event string, success bool, url, body, headers string, statusCode int, response string, durationMs int64) {
+func logWebhookDeliveryFull(webhook *models.Webhook, deliveryID, event string, success bool, url, body, headers string, statusCode int, response string, durationMs int64) {
 	delivery := models.WebhookDelivery{
-		WebhookID:      webhookID,
+		WorkspaceID:    webhook.WorkspaceID,
+		WebhookID:      webhook.ID,
+		DeliveryID:     deliveryID,
 		Event:          event,
 		Success:        success,
 		RequestURL:     url,
@@ -167,69 +216,12 @@ func logWebhookDeliveryFull(webhookID uint, event string, success bool, url, bod
 	}
 }
 
-// logWebhookDelivery logs webhook delivery attempts (simplified version for backward compatibility)
-func logWebhookDelivery(webhookID uint, event string, success bool, message string) {
-	logWebhookDeliveryFull(webhookID, event, success, "", "", "", 0, message, 0)
-}
-
-// SendWebhookWithRetry sends a webhook with exponential backoff retry logic
-// Retries up to MaxRetries times on failure with exponential backoff
-func SendWebhookWithRetry(webhook *models.Webhook, event string, data map[string]any) error {
-	var lastErr error
-
-	for attempt := 0; attempt <= MaxRetries; attempt++ {
-		err := SendWebhook(webhook, event, data)
-		if err == nil {
-			if attempt > 0 {
-				log.Info("Webhook succeeded on retry %d for %s to %s", attempt, event, webhook.URL)
-			}
-			return nil
-		}
-
-		lastErr = err
-
-		// Don't retry on the last attempt
-		if attempt == MaxRetries {
-			break
-		}
-
-		// Calculate backoff with exponential delay
-		backoff := calculateBackoff(attempt)
-		log.Warning("Webhook failed (attempt %d/%d) for %s to %s: %v. Retrying in %v",
-			attempt+1, MaxRetries+1, event, webhook.URL, err, backoff)
-
-		time.Sleep(backoff)
-	}
-
-	log.Error("Webhook failed after %d attempts for %s to %s: %v",
-		MaxRetries+1, event, webhook.URL, lastErr)
-	return fmt.Errorf("webhook failed after %d retries: %w", MaxRetries, lastErr)
-}
-
-// calculateBackoff calculates exponential backoff with jitter
-func calculateBackoff(attempt int) time.Duration {
-	// Exponential backoff: 1s, 2s, 4s, 8s, 16s (capped at MaxBackoff)
-	backoff := float64(InitialBackoff) * math.Pow(2, float64(attempt))
+// calculateBackoff returns the delay before retrying a delivery that failed attempts times
+func calculateBackoff(attempts int) time.Duration {
+	// Exponential backoff: 30s, 1m, 2m, 4m, 8m... (capped at MaxBackoff)
+	backoff := float64(InitialBackoff) * math.Pow(2, float64(attempts-1))
 	if backoff > float64(MaxBackoff) {
 		backoff = float64(MaxBackoff)
 	}
 	return time.Duration(backoff)
 }
-
-// BroadcastWebhookWithRetry sends a webhook event to all registered webhooks with retry logic
-func BroadcastWebhookWithRetry(event string, data map[string]any) {
-	var webhooks []models.Webhook
-	if err := db.Where("enabled = ?", true).Find(&webhooks).Error; err != nil {
-		log.Error("Failed to fetch webhooks for broadcast:", err)
-		return
-	}
-
-	for _, webhook := range webhooks {
-		// Send webhook asynchronously with retry
-		go func(w models.Webhook) {
-			if err := SendWebhookWithRetry(&w, event, data); err != nil {
-				log.Error("Failed to send webhook (with retries) to", w.URL, ":", err)
-			}
-		}(webhook)
-	}
-}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"event":"conversation.created","data":{"id":15}}`)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		header  string
+		body    []byte
+		secrets []string
+		want    error
+	}{
+		{name: "valid", header: Sign(body, now, "whsec_current"), body: body, secrets: []string{"whsec_current"}},
+		{name: "tampered body", header: Sign(body, now, "whsec_current"), body: []byte(`{"event":"conversation.created","data":{"id":16}}`), secrets: []string{"whsec_current"}, want: ErrSignatureMismatch},
+		{name: "wrong secret", header: Sign(body, now, "whsec_other"), body: body, secrets: []string{"whsec_current"}, want: ErrSignatureMismatch},
+		{name: "expired timestamp", header: Sign(body, now.Add(-SignatureTolerance-time.Minute), "whsec_current"), body: body, secrets: []string{"whsec_current"}, want: ErrSignatureExpired},
+		{name: "timestamp in the future", header: Sign(body, now.Add(SignatureTolerance+time.Minute), "whsec_current"), body: body, secrets: []string{"whsec_current"}, want: ErrSignatureExpired},
+		{name: "rotated secret signed with both", header: Sign(body, now, "whsec_new", "whsec_old"), body: body, secrets: []string{"whsec_old"}},
+		{name: "receiver already on the new secret", header: Sign(body, now, "whsec_new", "whsec_old"), body: body, secrets: []string{"whsec_new"}},
+		{name: "receiver accepts either secret", header: Sign(body, now, "whsec_new"), body: body, secrets: []string{"whsec_old", "whsec_new"}},
+		{name: "retired secret", header: Sign(body, now, "whsec_new"), body: body, secrets: []string{"whsec_old"}, want: ErrSignatureMismatch},
+		{name: "missing header", header: "", body: body, secrets: []string{"whsec_current"}, want: ErrSignatureMissing},
+		{name: "no signature", header: Sign(body, now), body: body, secrets: []string{"whsec_current"}, want: ErrSignatureMissing},
+		{name: "no timestamp", header: strings.SplitN(Sign(body, now, "whsec_current"), ",", 2)[1], body: body, secrets: []string{"whsec_current"}, want: ErrSignatureMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.header, tt.body, SignatureTolerance, tt.secrets...)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifySignature = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignFormat(t *testing.T) {
+	header := Sign([]byte("{}"), time.Unix(1700000000, 0), "whsec_a", "whsec_b")
+	parts := strings.Split(header, ",")
+	if len(parts) != 3 || parts[0] != "t=1700000000" || !strings.HasPrefix(parts[1], "v1=") || !strings.HasPrefix(parts[2], "v1=") {
+		t.Fatalf("Sign = %q, want t=1700000000 followed by one v1 signature per secret", header)
+	}
+	// The signature is the hex HMAC-SHA256 of "<timestamp>.<body>"
+	if want := "v1=" + computeSignature("whsec_a", 1700000000, []byte("{}")); parts[1] != want {
+		t.Errorf("first signature = %q, want %q", parts[1], want)
+	}
+	if parts[1] == parts[2] {
+		t.Error("both secrets produced the same signature")
+	}
+}
@@ -0,0 +1,94 @@
+// Package clientip resolves the address of the client behind the reverse proxies of the deployment.
+package clientip
+
+import (
+	"net"
+	"strings"
+
+	"github.com/getevo/evo/v2"
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ForwardedHeader lists the addresses a request passed through, appended to by every proxy
+const ForwardedHeader = "X-Forwarded-For"
+
+// DefaultTrustedProxies are the loopback and private networks reverse proxies usually run in
+const DefaultTrustedProxies = "127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7"
+
+// FromRequest returns the address of the client that sent the request
+func FromRequest(request *evo.Request) string {
+	return FromFiber(request.Context)
+}
+
+// FromFiber returns the address of the client that sent the request
+func FromFiber(c *fiber.Ctx) string {
+	return Resolve(c.Context().RemoteIP(), c.Get(ForwardedHeader), TrustedProxies())
+}
+
+// TrustedProxies returns the networks of APP.TRUSTED_PROXIES, comma-separated addresses or CIDRs
+func TrustedProxies() []*net.IPNet {
+	return ParseNetworks(settings.Get("APP.TRUSTED_PROXIES", DefaultTrustedProxies).String())
+}
+
+// ParseNetworks parses comma-separated addresses and CIDRs, skipping invalid entries
+func ParseNetworks(value string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				continue
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// Resolve returns the client address of a request from peer with the forwardedFor header. The header is
+// only believed as far as it was written by trusted proxies: starting at the peer, each trusted hop
+// hands over to the address it forwarded for, and the first untrusted one is the client. A client
+// can prepend anything to the header, so the entries left of it are never used.
+func Resolve(peer net.IP, forwardedFor string, trusted []*net.IPNet) string {
+	client := peer
+	if forwardedFor != "" {
+		hops := strings.Split(forwardedFor, ",")
+		for i := len(hops) - 1; i >= 0 && isTrusted(client, trusted); i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			client = hop
+		}
+	}
+	if client == nil {
+		return ""
+	}
+	return client.String()
+}
+
+// isTrusted reports whether ip is in one of the trusted networks
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package clientip
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getevo/evo/v2/lib/settings"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestResolve(t *testing.T) {
+	trusted := ParseNetworks("10.0.0.0/8, 192.168.1.5, ::1")
+	tests := []struct {
+		name         string
+		peer         string
+		forwardedFor string
+		want         string
+	}{
+		{name: "direct client", peer: "203.0.113.7", want: "203.0.113.7"},
+		{name: "spoofed header from untrusted peer", peer: "203.0.113.7", forwardedFor: "198.51.100.1", want: "203.0.113.7"},
+		{name: "behind trusted proxy", peer: "10.0.0.2", forwardedFor: "198.51.100.1", want: "198.51.100.1"},
+		{name: "trusted single address", peer: "192.168.1.5", forwardedFor: "198.51.100.1", want: "198.51.100.1"},
+		{name: "trusted IPv6 proxy", peer: "::1", forwardedFor: "2001:db8::1", want: "2001:db8::1"},
+		{name: "chain of trusted proxies", peer: "10.0.0.2", forwardedFor: "198.51.100.1, 10.0.0.9", want: "198.51.100.1"},
+		{name: "client prepends a fake address", peer: "10.0.0.2", forwardedFor: "1.2.3.4, 198.51.100.1", want: "198.51.100.1"},
+		{name: "untrusted hop stops the walk", peer: "10.0.0.2", forwardedFor: "198.51.100.1, 203.0.113.9", want: "203.0.113.9"},
+		{name: "malformed entry", peer: "10.0.0.2", forwardedFor: "198.51.100.1, garbage", want: "10.0.0.2"},
+		{name: "trusted proxy without header", peer: "10.0.0.2", want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Resolve(net.ParseIP(tt.peer), tt.forwardedFor, trusted); got != tt.want {
+				t.Errorf("Resolve(%s, %q) = %s, want %s", tt.peer, tt.forwardedFor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks := ParseNetworks("127.0.0.1, 10.0.0.0/8, not-an-ip, fd00::/8, , 300.1.1.1")
+	if len(networks) != 3 {
+		t.Fatalf("parsed %d networks, want 3: %v", len(networks), networks)
+	}
+	if !isTrusted(net.ParseIP("127.0.0.1"), networks) || isTrusted(net.ParseIP("127.0.0.2"), networks) {
+		t.Error("a single address must only match itself")
+	}
+}
+
+func TestFromFiber(t *testing.T) {
+	var got string
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		got = FromFiber(c)
+		return nil
+	})
+	request := func(trusted string) string {
+		t.Helper()
+		settings.Set("APP.TRUSTED_PROXIES", trusted)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(ForwardedHeader, "198.51.100.1")
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	// The test connection comes from 0.0.0.0
+	if ip := request("0.0.0.0"); ip != "198.51.100.1" {
+		t.Errorf("behind a trusted proxy got %s, want the forwarded address", ip)
+	}
+	if ip := request("10.0.0.0/8"); ip != "0.0.0.0" {
+		t.Errorf("from an untrusted peer got %s, want the peer address", ip)
+	}
+}
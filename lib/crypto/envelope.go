@@ -0,0 +1,249 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/getevo/evo/v2/lib/log"
+	"github.com/getevo/evo/v2/lib/settings"
+)
+
+// Envelope encryption: every value is encrypted with its own random data key,
+// and the data key is encrypted (wrapped) with a master key from the key ring.
+// Stored values look like enc:v1:<key id>:<wrapped data key>:<ciphertext>, so
+// rotating the master key only re-wraps the data keys.
+//
+// Master keys are configured as ENCRYPTION.KEYS ("id:base64key,id2:base64key") with
+// ENCRYPTION.ACTIVE_KEY naming the key for new values. The ENCRYPTION_KEY environment
+// variable is added to the ring as the "default" key.
+
+const envelopePrefix = "enc:v1:"
+
+var (
+	ErrNoEncryptionKey = errors.New("no encryption key is configured")
+	ErrUnknownKeyID    = errors.New("value was encrypted with a key that is not in the key ring")
+)
+
+var (
+	keyRing     map[string][]byte
+	activeKeyID string
+	keyRingOnce sync.Once
+)
+
+// loadKeyRing reads the master keys from the settings and the environment
+func loadKeyRing() {
+	keys := map[string][]byte{}
+	if key := os.Getenv("ENCRYPTION_KEY"); key != "" {
+		if len(key) == 32 {
+			keys["default"] = []byte(key)
+		} else {
+			log.Error("ENCRYPTION_KEY must be 32 bytes for AES-256, got %d bytes", len(key))
+		}
+	}
+	for _, entry := range strings.Split(settings.Get("ENCRYPTION.KEYS").String(), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, found := strings.Cut(entry, ":")
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if !found || id == "" || err != nil || len(key) != 32 {
+			log.Error("Ignoring encryption key %q: expected id:base64 of 32 bytes", id)
+			continue
+		}
+		keys[id] = key
+	}
+
+	active := settings.Get("ENCRYPTION.ACTIVE_KEY").String()
+	if active == "" && len(keys) == 1 {
+		for id := range keys {
+			active = id
+		}
+	}
+	if active != "" && keys[active] == nil {
+		log.Error("ENCRYPTION.ACTIVE_KEY %q is not in the key ring", active)
+		active = ""
+	}
+	if active == "" {
+		log.Warning("No encryption key is configured, secrets are stored in plaintext")
+	}
+
+	keyRing, activeKeyID = keys, active
+}
+
+func masterKey(id string) ([]byte, string) {
+	keyRingOnce.Do(loadKeyRing)
+	if id == "" {
+		id = activeKeyID
+	}
+	return keyRing[id], id
+}
+
+// ActiveKeyID returns the ID of the key new values are encrypted with, empty when encryption is off
+func ActiveKeyID() string {
+	_, id := masterKey("")
+	return id
+}
+
+// IsEnvelope reports whether value was produced by EncryptValue
+func IsEnvelope(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix)
+}
+
+// EnvelopeKeyID returns the master key ID of an encrypted value, empty for plaintext
+func EnvelopeKeyID(value string) string {
+	if !IsEnvelope(value) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, envelopePrefix), ":")
+	return id
+}
+
+// EncryptValue encrypts value with a new data key wrapped by the active master key.
+// Empty and already encrypted values are returned unchanged, as is everything when no key is configured.
+func EncryptValue(value string) (string, error) {
+	if value == "" || IsEnvelope(value) {
+		return value, nil
+	}
+	key, id := masterKey("")
+	if key == nil {
+		return value, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := seal(key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return envelopePrefix + id + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptValue returns the plaintext of an encrypted value. Plaintext values are returned unchanged.
+func DecryptValue(value string) (string, error) {
+	if !IsEnvelope(value) {
+		return value, nil
+	}
+	id, wrapped, ciphertext, err := splitEnvelope(value)
+	if err != nil {
+		return "", err
+	}
+	key, _ := masterKey(id)
+	if key == nil {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKeyID, id)
+	}
+	dataKey, err := open(key, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// RewrapValue moves a value to the active master key: plaintext is encrypted and the data key
+// of a value under another key is re-wrapped. It reports whether the value changed.
+func RewrapValue(value string) (string, bool, error) {
+	if value == "" {
+		return value, false, nil
+	}
+	key, activeID := masterKey("")
+	if key == nil {
+		return value, false, ErrNoEncryptionKey
+	}
+	if !IsEnvelope(value) {
+		encrypted, err := EncryptValue(value)
+		return encrypted, err == nil, err
+	}
+
+	id, wrapped, ciphertext, err := splitEnvelope(value)
+	if err != nil {
+		return value, false, err
+	}
+	if id == activeID {
+		return value, false, nil
+	}
+	oldKey, _ := masterKey(id)
+	if oldKey == nil {
+		return value, false, fmt.Errorf("%w: %s", ErrUnknownKeyID, id)
+	}
+	dataKey, err := open(oldKey, wrapped)
+	if err != nil {
+		return value, false, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	rewrapped, err := seal(key, dataKey)
+	if err != nil {
+		return value, false, err
+	}
+	return envelopePrefix + activeID + ":" + base64.StdEncoding.EncodeToString(rewrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext), true, nil
+}
+
+func splitEnvelope(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("malformed encrypted value")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("malformed data key: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+// seal encrypts data with AES-256-GCM and prepends the nonce
+func seal(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// open decrypts data produced by seal
+func open(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,148 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	testKeyA = bytes.Repeat([]byte("a"), 32)
+	testKeyB = bytes.Repeat([]byte("b"), 32)
+)
+
+// useKeyRing replaces the key ring for the duration of the test
+func useKeyRing(t *testing.T, active string, keys map[string][]byte) {
+	t.Helper()
+	keyRingOnce.Do(func() {})
+	previousRing, previousActive := keyRing, activeKeyID
+	keyRing, activeKeyID = keys, active
+	t.Cleanup(func() { keyRing, activeKeyID = previousRing, previousActive })
+}
+
+func TestEncryptValueRoundTrip(t *testing.T) {
+	useKeyRing(t, "a", map[string][]byte{"a": testKeyA})
+
+	for _, plaintext := range []string{"sk-test-123", `{"password":"p@ss:word"}`, "ünïcode ✓"} {
+		encrypted, err := EncryptValue(plaintext)
+		if err != nil {
+			t.Fatalf("EncryptValue(%q): %v", plaintext, err)
+		}
+		if !IsEnvelope(encrypted) || strings.Contains(encrypted, plaintext) {
+			t.Fatalf("EncryptValue(%q) = %q, want an envelope without the plaintext", plaintext, encrypted)
+		}
+		if id := EnvelopeKeyID(encrypted); id != "a" {
+			t.Errorf("EnvelopeKeyID = %q, want a", id)
+		}
+		decrypted, err := DecryptValue(encrypted)
+		if err != nil || decrypted != plaintext {
+			t.Errorf("DecryptValue = %q, %v; want %q", decrypted, err, plaintext)
+		}
+	}
+
+	first, _ := EncryptValue("same")
+	second, _ := EncryptValue("same")
+	if first == second {
+		t.Error("two encryptions of the same value are identical")
+	}
+}
+
+func TestEncryptValueUnchanged(t *testing.T) {
+	useKeyRing(t, "a", map[string][]byte{"a": testKeyA})
+	encrypted, _ := EncryptValue("secret")
+
+	for name, value := range map[string]string{"empty": "", "already encrypted": encrypted} {
+		got, err := EncryptValue(value)
+		if err != nil || got != value {
+			t.Errorf("%s: EncryptValue = %q, %v; want the value unchanged", name, got, err)
+		}
+	}
+
+	useKeyRing(t, "", map[string][]byte{})
+	if got, err := EncryptValue("secret"); err != nil || got != "secret" {
+		t.Errorf("without a key: EncryptValue = %q, %v; want plaintext", got, err)
+	}
+}
+
+func TestDecryptValueLegacyPlaintext(t *testing.T) {
+	useKeyRing(t, "a", map[string][]byte{"a": testKeyA})
+
+	for _, value := range []string{"", "plain-api-key", `{"host":"smtp.example.com"}`} {
+		got, err := DecryptValue(value)
+		if err != nil || got != value {
+			t.Errorf("DecryptValue(%q) = %q, %v; want it unchanged", value, got, err)
+		}
+	}
+}
+
+func TestDecryptValueFailures(t *testing.T) {
+	useKeyRing(t, "a", map[string][]byte{"a": testKeyA})
+	encrypted, err := EncryptValue("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(encrypted, ":")
+	tampered := strings.Join(append(parts[:len(parts)-1], "AAAA"+parts[len(parts)-1][4:]), ":")
+
+	tests := []struct {
+		name  string
+		value string
+		keys  map[string][]byte
+		want  error
+	}{
+		{name: "wrong key", value: encrypted, keys: map[string][]byte{"a": testKeyB}},
+		{name: "key removed from ring", value: encrypted, keys: map[string][]byte{"b": testKeyB}, want: ErrUnknownKeyID},
+		{name: "tampered ciphertext", value: tampered, keys: map[string][]byte{"a": testKeyA}},
+		{name: "malformed", value: "enc:v1:a:not-enough-parts", keys: map[string][]byte{"a": testKeyA}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeyRing(t, "", tt.keys)
+			got, err := DecryptValue(tt.value)
+			if err == nil {
+				t.Fatalf("DecryptValue = %q, want an error", got)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewrapValue(t *testing.T) {
+	useKeyRing(t, "a", map[string][]byte{"a": testKeyA})
+	underA, _ := EncryptValue("secret")
+
+	// Rotate: b becomes the active key while a stays in the ring
+	useKeyRing(t, "b", map[string][]byte{"a": testKeyA, "b": testKeyB})
+
+	rewrapped, changed, err := RewrapValue(underA)
+	if err != nil || !changed || EnvelopeKeyID(rewrapped) != "b" {
+		t.Fatalf("RewrapValue = %q, %v, %v; want a value under b", rewrapped, changed, err)
+	}
+	if _, again, _ := RewrapValue(rewrapped); again {
+		t.Error("RewrapValue changed a value that is already under the active key")
+	}
+
+	encrypted, changed, err := RewrapValue("legacy plaintext")
+	if err != nil || !changed || EnvelopeKeyID(encrypted) != "b" {
+		t.Errorf("RewrapValue(plaintext) = %q, %v, %v; want it encrypted under b", encrypted, changed, err)
+	}
+
+	// The retired key can be removed once everything is re-wrapped
+	useKeyRing(t, "b", map[string][]byte{"b": testKeyB})
+	for _, value := range []string{rewrapped, encrypted} {
+		if _, err := DecryptValue(value); err != nil {
+			t.Errorf("DecryptValue after removing the retired key: %v", err)
+		}
+	}
+	if _, _, err := RewrapValue(underA); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("RewrapValue with a removed key: error = %v, want ErrUnknownKeyID", err)
+	}
+
+	useKeyRing(t, "", map[string][]byte{})
+	if _, _, err := RewrapValue("plaintext"); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Errorf("RewrapValue without a key: error = %v, want ErrNoEncryptionKey", err)
+	}
+}
@@ -0,0 +1,88 @@
+package tenant
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Plugin scopes the statements of a workspace context (see WithWorkspace) to the rows of that workspace.
+// Queries, updates and deletes of models with a workspace_id column only match the workspace's rows,
+// and created or saved rows are assigned to it. Raw SQL and statements without a workspace context
+// are left as they are.
+type Plugin struct{}
+
+func (Plugin) Name() string {
+	return "tenant"
+}
+
+func (Plugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	if err := callback.Create().Before("gorm:before_create").Register("tenant:assign", assignWorkspace); err != nil {
+		return err
+	}
+	if err := callback.Query().Before("gorm:query").Register("tenant:scope", scopeWorkspace); err != nil {
+		return err
+	}
+	if err := callback.Update().Before("gorm:before_update").Register("tenant:assign", assignWorkspace); err != nil {
+		return err
+	}
+	if err := callback.Update().Before("gorm:update").Register("tenant:scope", scopeWorkspace); err != nil {
+		return err
+	}
+	if err := callback.Delete().Before("gorm:delete").Register("tenant:scope", scopeWorkspace); err != nil {
+		return err
+	}
+	return callback.Row().Before("gorm:row").Register("tenant:scope", scopeWorkspace)
+}
+
+// workspaceField returns the workspace of the statement and the workspace_id field of its model
+func workspaceField(tx *gorm.DB) (uint, *schema.Field) {
+	id := FromContext(tx.Statement.Context)
+	if id == 0 || tx.Statement.Schema == nil || tx.Statement.SQL.Len() > 0 {
+		return 0, nil
+	}
+	return id, tx.Statement.Schema.LookUpField(Column)
+}
+
+func scopeWorkspace(tx *gorm.DB) {
+	id, field := workspaceField(tx)
+	if field == nil {
+		return
+	}
+	tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: Column}, Value: id},
+	}})
+}
+
+// assignWorkspace sets the workspace of the written rows, so a request cannot move rows to another workspace
+func assignWorkspace(tx *gorm.DB) {
+	id, field := workspaceField(tx)
+	if field == nil {
+		return
+	}
+	if values, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		if _, set := values[Column]; set {
+			values[Column] = id
+		}
+		return
+	}
+
+	ctx := tx.Statement.Context
+	switch value := tx.Statement.ReflectValue; value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if row := reflect.Indirect(value.Index(i)); row.Kind() == reflect.Struct {
+				if err := field.Set(ctx, row, id); err != nil {
+					tx.AddError(err)
+				}
+			}
+		}
+	case reflect.Struct:
+		if err := field.Set(ctx, value, id); err != nil {
+			tx.AddError(err)
+		}
+	}
+}
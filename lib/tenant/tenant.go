@@ -0,0 +1,80 @@
+// Package tenant scopes data and shared infrastructure to workspaces
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Every row, NATS subject, Redis key, Qdrant collection and S3 object belongs to a workspace.
+// The default workspace keeps the names used before workspaces existed, so a single-tenant
+// deployment needs no data migration; the names of every other workspace carry its ID.
+
+// DefaultID is the workspace that owns the data created before workspaces existed
+const DefaultID uint = 1
+
+// Column is the column that holds the workspace of a row
+const Column = "workspace_id"
+
+type contextKey struct{}
+
+// WithWorkspace returns a copy of ctx that scopes database statements to the workspace
+func WithWorkspace(ctx context.Context, id uint) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the workspace of ctx, 0 when the context is not scoped
+func FromContext(ctx context.Context) uint {
+	if ctx == nil {
+		return 0
+	}
+	id, _ := ctx.Value(contextKey{}).(uint)
+	return id
+}
+
+// Subject returns the NATS subject of the workspace, e.g. ws.2.conversation.15
+func Subject(id uint, subject string) string {
+	if id == 0 || id == DefaultID {
+		return subject
+	}
+	return fmt.Sprintf("ws.%d.%s", id, subject)
+}
+
+// Key returns the Redis key of the workspace, e.g. ws:2:rate_limit:ip:10.0.0.1
+func Key(id uint, key string) string {
+	if id == 0 || id == DefaultID {
+		return key
+	}
+	return fmt.Sprintf("ws:%d:%s", id, key)
+}
+
+// Collection returns the Qdrant collection of the workspace, e.g. knowledge_base_ws2
+func Collection(id uint, name string) string {
+	if id == 0 || id == DefaultID {
+		return name
+	}
+	return fmt.Sprintf("%s_ws%d", name, id)
+}
+
+// prefixRoot is the S3 prefix that holds the objects of the non-default workspaces
+const prefixRoot = "workspaces/"
+
+// Prefix returns the S3 object key of the workspace, e.g. workspaces/2/media/photo.jpg
+func Prefix(id uint, key string) string {
+	if id == 0 || id == DefaultID {
+		return key
+	}
+	return fmt.Sprintf("%s%d/%s", prefixRoot, id, key)
+}
+
+// HasPrefix reports whether the S3 object key belongs to the workspace
+func HasPrefix(id uint, key string) bool {
+	if id == 0 || id == DefaultID {
+		return !strings.HasPrefix(key, prefixRoot)
+	}
+	return strings.HasPrefix(key, Prefix(id, ""))
+}
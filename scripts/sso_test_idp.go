@@ -0,0 +1,305 @@
+//go:build ignore
+
+package main
+
+// Test identity provider for checking the OpenID Connect and SAML single sign-on locally.
+// Every login is approved immediately for the user given on the command line.
+//
+//	go run scripts/sso_test_idp.go -email agent@example.com -department Support
+//
+// OpenID Connect provider for Homa (POST /api/admin/sso-providers):
+//
+//	{"slug": "test-oidc", "name": "Test OIDC", "type": "oidc", "issuer_url": "http://localhost:9999/oidc",
+//	 "client_id": "homa-test", "client_secret": "homa-test-secret", "department_claim": "department", "auto_provision": true}
+//
+// SAML provider for Homa:
+//
+//	{"slug": "test-saml", "name": "Test SAML", "type": "saml", "idp_metadata_url": "http://localhost:9999/saml/metadata",
+//	 "department_claim": "department", "auto_provision": true}
+//
+// then start the IdP with -sp-metadata http://localhost:8000/api/auth/sso/test-saml/metadata and open
+// http://localhost:8000/api/auth/sso/test-oidc/login or http://localhost:8000/api/auth/sso/test-saml/login.
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	clientID     = "homa-test"
+	clientSecret = "homa-test-secret"
+	keyID        = "test-key"
+)
+
+var (
+	addr       = flag.String("addr", "localhost:9999", "listen address")
+	spMetadata = flag.String("sp-metadata", "http://localhost:8000/api/auth/sso/test-saml/metadata", "SAML service provider metadata URL")
+	email      = flag.String("email", "sso.agent@example.com", "email of the signed-in user")
+	firstName  = flag.String("first-name", "Sso", "first name of the signed-in user")
+	lastName   = flag.String("last-name", "Agent", "last name of the signed-in user")
+	department = flag.String("department", "", "department name sent in the department claim")
+)
+
+type authorization struct {
+	nonce string
+	at    time.Time
+}
+
+var (
+	key    *rsa.PrivateKey
+	cert   *x509.Certificate
+	codes  = map[string]authorization{}
+	codeMu sync.Mutex
+)
+
+func main() {
+	flag.Parse()
+
+	var err error
+	key, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Homa test IdP"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		log.Fatal(err)
+	}
+
+	base := "http://" + *addr
+	http.HandleFunc("/oidc/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                base + "/oidc",
+			"authorization_endpoint":                base + "/oidc/authorize",
+			"token_endpoint":                        base + "/oidc/token",
+			"userinfo_endpoint":                     base + "/oidc/userinfo",
+			"jwks_uri":                              base + "/oidc/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	http.HandleFunc("/oidc/jwks", serveJWKS)
+	http.HandleFunc("/oidc/authorize", serveAuthorize)
+	http.HandleFunc("/oidc/token", func(w http.ResponseWriter, r *http.Request) { serveToken(w, r, base+"/oidc") })
+	http.HandleFunc("/oidc/userinfo", serveUserInfo)
+
+	metadataURL, _ := url.Parse(base + "/saml/metadata")
+	ssoURL, _ := url.Parse(base + "/saml/sso")
+	idp := &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             cert,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: serviceProviders{},
+		SessionProvider:         sessions{},
+	}
+	http.HandleFunc("/saml/metadata", idp.ServeMetadata)
+	http.HandleFunc("/saml/sso", idp.ServeSSO)
+
+	log.Printf("Test IdP listening on %s, signing in %s", base, *email)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func serveJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": keyID,
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+}
+
+// serveAuthorize approves the login at once and redirects back with a code
+func serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != clientID || query.Get("redirect_uri") == "" {
+		http.Error(w, "unknown client or missing redirect_uri", http.StatusBadRequest)
+		return
+	}
+	code := randomString()
+	codeMu.Lock()
+	codes[code] = authorization{nonce: query.Get("nonce"), at: time.Now()}
+	codeMu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func serveToken(w http.ResponseWriter, r *http.Request, issuer string) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != clientID || secret != clientSecret {
+		writeJSONStatus(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	codeMu.Lock()
+	auth, found := codes[r.PostForm.Get("code")]
+	delete(codes, r.PostForm.Get("code"))
+	codeMu.Unlock()
+	if !found || time.Since(auth.at) > time.Minute {
+		writeJSONStatus(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":            issuer,
+		"sub":            *email,
+		"aud":            clientID,
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          auth.nonce,
+		"email":          *email,
+		"email_verified": true,
+		"given_name":     *firstName,
+		"family_name":    *lastName,
+		"name":           *firstName + " " + *lastName,
+	}
+	if *department != "" {
+		claims["department"] = []string{*department}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func serveUserInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{
+		"sub":            *email,
+		"email":          *email,
+		"email_verified": true,
+		"given_name":     *firstName,
+		"family_name":    *lastName,
+	})
+}
+
+// serviceProviders trusts the one service provider given on the command line
+type serviceProviders struct{}
+
+func (serviceProviders) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	if serviceProviderID != *spMetadata {
+		return nil, os.ErrNotExist
+	}
+	resp, err := http.Get(*spMetadata)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var descriptor saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &descriptor); err != nil {
+		return nil, err
+	}
+	return &descriptor, nil
+}
+
+// sessions signs in the user given on the command line for every request
+type sessions struct{}
+
+func (sessions) GetSession(w http.ResponseWriter, r *http.Request, req *saml.IdpAuthnRequest) *saml.Session {
+	attributes := []saml.Attribute{
+		textAttribute("email", *email),
+		textAttribute("firstName", *firstName),
+		textAttribute("lastName", *lastName),
+		textAttribute("displayName", *firstName+" "+*lastName),
+	}
+	if *department != "" {
+		attributes = append(attributes, textAttribute("department", *department))
+	}
+	return &saml.Session{
+		ID:               randomString(),
+		CreateTime:       time.Now(),
+		ExpireTime:       time.Now().Add(time.Hour),
+		Index:            randomString(),
+		NameID:           *email,
+		NameIDFormat:     string(saml.EmailAddressNameIDFormat),
+		UserEmail:        *email,
+		UserGivenName:    *firstName,
+		UserSurname:      *lastName,
+		CustomAttributes: attributes,
+	}
+}
+
+func textAttribute(name, value string) saml.Attribute {
+	return saml.Attribute{
+		Name:       name,
+		NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic",
+		Values:     []saml.AttributeValue{{Type: "xs:string", Value: value}},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}